@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"filippo.io/age/armor"
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/secmem"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+// ageExport writes the file's log encrypted to age recipients so it can be
+// opened with age (age -d -i key.txt out.age) without bpass. The log is the
+// one that's pushed to sync remotes, local keys (like this device's signing
+// key) and nosync entries never leave the machine.
+func (u *uiContext) ageExport(path string, recipientArgs []string) error {
+	recipients, err := ageRecipients(recipientArgs)
+	if err != nil {
+		errColor.Println(err)
+		return nil
+	}
+
+	log, _ := u.store.SyncLog()
+	data, err := (&txlogs.DB{Log: log}).Save()
+	if err != nil {
+		return err
+	}
+
+	ct, err := ageEncrypt(data, recipients)
+	secmem.Wipe(data)
+	if err != nil {
+		return err
+	}
+
+	wrote, err := u.writeNewFile(path, ct, 0600)
+	if err != nil || !wrote {
+		return err
+	}
+
+	infoColor.Printf("wrote %s for %d recipient(s)\n", path, len(recipients))
+	return nil
+}
+
+// ageImport merges the log in an age file (made with export age, or by
+// age from a decrypted export) into this file
+func (u *uiContext) ageImport(path, identityPath string) error {
+	identities, err := ageIdentities(identityPath)
+	if err != nil {
+		errColor.Println(err)
+		return nil
+	}
+
+	ct, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	pt, err := ageDecrypt(ct, identities)
+	if err != nil {
+		errColor.Printf("failed to decrypt %s: %v\n", path, err)
+		return nil
+	}
+
+	log, err := txlogs.NewLog(pt)
+	secmem.Wipe(pt)
+	if err != nil {
+		errColor.Printf("%s is not a bpass export: %v\n", path, err)
+		return nil
+	}
+	log = withoutLocalKeys(log)
+
+	merged, err := mergeLogs(u, u.store.Log, log, txlogs.Base{}, nil, nil, u.conflictPolicy(""))
+	if err != nil {
+		errColor.Println("aborting import, failed to merge logs:", err)
+		return nil
+	}
+	resolved, err := u.resolveKeys(u.store.Log, log, merged)
+	if err != nil {
+		return err
+	}
+
+	old := u.store.Log
+	if err = u.store.DB.ReplaceLog(merged); err != nil {
+		if restoreErr := u.store.DB.ReplaceLog(old); restoreErr != nil {
+			return restoreErr
+		}
+
+		errColor.Println("aborting import, failed to rebuild snapshot:", err)
+		return nil
+	}
+	if err = u.applyResolved(resolved); err != nil {
+		return err
+	}
+	if err = u.recompact(); err != nil {
+		errColor.Println("failed to compact the merged log:", err)
+	}
+
+	infoColor.Printf("imported %s\n", path)
+	return nil
+}
+
+// withoutLocalKeys removes the changes to local keys from a log, another
+// machine's (its signing key, remote passphrases) have no business in this
+// file
+func withoutLocalKeys(log []txlogs.Tx) []txlogs.Tx {
+	kept := make([]txlogs.Tx, 0, len(log))
+	for _, tx := range log {
+		if (tx.Kind == txlogs.TxSetKey || tx.Kind == txlogs.TxDeleteKey) && blobformat.IsLocalKey(tx.Key) {
+			continue
+		}
+		kept = append(kept, tx)
+	}
+
+	return kept
+}
+
+// ageEncrypt encrypts data to the recipients, the output is binary like age
+// writes without --armor
+func ageEncrypt(data []byte, recipients []age.Recipient) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipients...)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ageDecrypt decrypts binary or armored age files
+func ageDecrypt(ct []byte, identities []age.Identity) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(ct)
+	if bytes.HasPrefix(bytes.TrimSpace(ct), []byte(armor.Header)) {
+		r = armor.NewReader(bytes.NewReader(bytes.TrimSpace(ct)))
+	}
+
+	pt, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(pt)
+}
+
+// ageRecipients parses each argument as a recipient (age1...) or a file of
+// recipients, one per line, the same as age -R reads: age1 or ssh public keys
+func ageRecipients(args []string) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "age1") {
+			r, err := age.ParseX25519Recipient(arg)
+			if err != nil {
+				return nil, fmt.Errorf("bad recipient %q: %w", arg, err)
+			}
+			recipients = append(recipients, r)
+			continue
+		}
+
+		f, err := os.Open(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a recipient or a recipients file: %w", arg, err)
+		}
+
+		scanner := bufio.NewScanner(f)
+		for n := 1; scanner.Scan(); n++ {
+			line := strings.TrimSpace(scanner.Text())
+			if len(line) == 0 || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			var r age.Recipient
+			if strings.HasPrefix(line, "ssh-") {
+				r, err = agessh.ParseRecipient(line)
+			} else {
+				r, err = age.ParseX25519Recipient(line)
+			}
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("bad recipient on line %d of %s: %w", n, arg, err)
+			}
+			recipients = append(recipients, r)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients given")
+	}
+
+	return recipients, nil
+}
+
+// ageIdentities reads an age identity file (from age-keygen) or an
+// unencrypted ssh private key
+func ageIdentities(path string) ([]age.Identity, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Contains(data, []byte("PRIVATE KEY-----")) {
+		identity, err := agessh.ParseIdentity(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ssh key %s (passphrase protected keys aren't supported): %w", path, err)
+		}
+		return []age.Identity{identity}, nil
+	}
+
+	identities, err := age.ParseIdentities(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identities in %s: %w", path, err)
+	}
+
+	return identities, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestAgeRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "bpass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	alice, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recipientsFile := filepath.Join(dir, "recipients.txt")
+	contents := "# bob\n\n" + bob.Recipient().String() + "\n"
+	if err = ioutil.WriteFile(recipientsFile, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	identityFile := filepath.Join(dir, "key.txt")
+	if err = ioutil.WriteFile(identityFile, []byte(bob.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	recipients, err := ageRecipients([]string{alice.Recipient().String(), recipientsFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recipients) != 2 {
+		t.Fatal("want 2 recipients, got:", len(recipients))
+	}
+
+	ct, err := ageEncrypt([]byte("log"), recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identities, err := ageIdentities(identityFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pt, err := ageDecrypt(ct, identities); err != nil || string(pt) != "log" {
+		t.Error("bob failed to decrypt:", err)
+	}
+	if pt, err := ageDecrypt(ct, []age.Identity{alice}); err != nil || string(pt) != "log" {
+		t.Error("alice failed to decrypt:", err)
+	}
+
+	// age --armor output can be imported as well
+	var armored bytes.Buffer
+	w := armor.NewWriter(&armored)
+	if _, err = w.Write(ct); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if pt, err := ageDecrypt(armored.Bytes(), identities); err != nil || string(pt) != "log" {
+		t.Error("failed to decrypt armored file:", err)
+	}
+
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ageDecrypt(ct, []age.Identity{other}); err == nil {
+		t.Error("want an error for an identity that isn't a recipient")
+	}
+}
+
+func TestAgeRecipientsErrors(t *testing.T) {
+	t.Parallel()
+
+	if _, err := ageRecipients(nil); err == nil {
+		t.Error("want an error for no recipients")
+	}
+	if _, err := ageRecipients([]string{"age1notakey"}); err == nil {
+		t.Error("want an error for a bad recipient")
+	}
+	if _, err := ageRecipients([]string{"/does/not/exist"}); err == nil {
+		t.Error("want an error for a missing recipients file")
+	}
+}
+
+func TestAgeExportLocalKeys(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "bpass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: ioutil.Discard}
+	uuid, err := u.store.New("github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.store.Set(uuid, blobformat.KeyPass, "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = u.deviceKey(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "export.age")
+	if err = u.ageExport(path, []string{identity.Recipient().String()}); err != nil {
+		t.Fatal(err)
+	}
+
+	ct, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt, err := ageDecrypt(ct, []age.Identity{identity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := txlogs.NewLog(pt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hasPass bool
+	for _, tx := range log {
+		if blobformat.IsLocalKey(tx.Key) {
+			t.Errorf("the export has local key %s", tx.Key)
+		}
+		hasPass = hasPass || tx.Key == blobformat.KeyPass
+	}
+	if !hasPass {
+		t.Error("the export should have the entries")
+	}
+
+	// Existing files are only replaced when the user says so
+	u.in = &scriptedEditor{lines: []string{"n"}}
+	if err = ioutil.WriteFile(path, []byte("keep"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.ageExport(path, []string{identity.Recipient().String()}); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "keep" {
+		t.Error("the existing file was overwritten")
+	}
+
+	// And an export made by someone else doesn't bring their local keys
+	log = append(log, txlogs.Tx{Time: 1, Kind: txlogs.TxSetKey, UUID: uuid, Key: blobformat.SettingDeviceKey, Value: "seed"})
+	if got := withoutLocalKeys(log); len(got) != len(log)-1 {
+		t.Errorf("want %d transactions, got: %d", len(log)-1, len(got))
+	}
+}
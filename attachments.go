@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/aarondl/bpass/blobformat"
+)
+
+// attach attaches the file at path to an entry under its file name
+func (u *uiContext) attach(search, path string) error {
+	uuid, err := u.findOne(search)
+	if err != nil {
+		return err
+	}
+	if len(uuid) == 0 {
+		return nil
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		errColor.Println(err)
+		return nil
+	}
+	if info.IsDir() {
+		errColor.Println(path, "is a directory")
+		return nil
+	}
+	if info.Size() > blobformat.MaxAttachmentSize {
+		errColor.Printf("%s is too big, attachments can't be larger than %d MiB\n", path, blobformat.MaxAttachmentSize>>20)
+		return nil
+	}
+
+	name := filepath.Base(path)
+	if _, ok := blob[blobformat.AttachmentKey(name)]; ok {
+		yes, err := u.getYesNo(fmt.Sprintf("%s already has an attachment named %s, replace it?", blob.Name(), name))
+		if err != nil || !yes {
+			return err
+		}
+	}
+
+	// Read a chunk at a time, it's never all in memory
+	f, err := os.Open(path)
+	if err != nil {
+		errColor.Println(err)
+		return nil
+	}
+	a, err := u.store.AttachReader(uuid, name, f)
+	f.Close()
+	if err != nil {
+		errColor.Println(err)
+		return nil
+	}
+
+	infoColor.Printf("attached %s (%d bytes)\n", name, a.Size)
+	return nil
+}
+
+// attachments lists the files attached to an entry
+func (u *uiContext) attachments(search string) error {
+	uuid, err := u.findOne(search)
+	if err != nil {
+		return err
+	}
+	if len(uuid) == 0 {
+		return nil
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+
+	attachments := blob.Attachments()
+	if len(attachments) == 0 {
+		infoColor.Printf("%s has no attachments\n", blob.Name())
+		return nil
+	}
+
+	for _, a := range attachments {
+		fmt.Fprintf(u.out, "  %s %s\n", keyColor.Sprint(a.Name), attachmentSummary(a))
+	}
+
+	return nil
+}
+
+// extract writes a file attached to an entry to path, into it with the
+// attachment's name when it's a directory
+func (u *uiContext) extract(search, name, path string) error {
+	uuid, err := u.findOne(search)
+	if err != nil {
+		return err
+	}
+	if len(uuid) == 0 {
+		return nil
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := blob[blobformat.AttachmentKey(name)]; !ok {
+		errColor.Printf("%s has no attachment %q\n", blob.Name(), name)
+		return nil
+	}
+
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, name)
+	}
+	if _, err := os.Stat(path); err == nil {
+		yes, err := u.getYesNo(fmt.Sprintf("%s exists, overwrite it?", path))
+		if err != nil || !yes {
+			return err
+		}
+	}
+
+	// Written a chunk at a time next to path and moved over it once it's
+	// checked, so a corrupt attachment never replaces anything. Temp files
+	// are only readable by us, attachments are likely keys.
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		errColor.Println(err)
+		return nil
+	}
+	_, err = blob.WriteAttachment(name, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		errColor.Println(err)
+		return nil
+	}
+
+	infoColor.Println("wrote:", path)
+	return nil
+}
+
+// attachmentSummary is how an attachment is shown in place of its value
+func attachmentSummary(a blobformat.Attachment) string {
+	return fmt.Sprintf("(%d bytes, sha256 %.12s)", a.Size, a.Sum)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestAttachments(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "bpass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: ioutil.Discard}
+	uuid, err := u.store.New("entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte{0, 1, 2, 0xff}, blobformat.AttachmentChunkSize)
+	path := filepath.Join(dir, "key.bin")
+	if err = ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.attach("entry", path); err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachments := blob.Attachments()
+	if len(attachments) != 1 || attachments[0].Name != "key.bin" || attachments[0].Chunks != 4 {
+		t.Fatalf("wrong attachments: %#v", attachments)
+	}
+
+	out := filepath.Join(dir, "out")
+	if err = os.Mkdir(out, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.extract("entry", "key.bin", out); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(out, "key.bin")); err != nil || !bytes.Equal(got, data) {
+		t.Error("extracted file was wrong:", len(got), err)
+	}
+
+	// Replacing it with a smaller file leaves no chunks behind
+	if err = u.store.Attach(uuid, "key.bin", []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	blob, _ = u.store.MustFind(uuid)
+	if got, err := blob.Attachment("key.bin"); err != nil || string(got) != "small" {
+		t.Error("replaced attachment was wrong:", string(got), err)
+	}
+	// A reader that goes past the limit leaves nothing behind
+	big := io.LimitReader(zeroReader{}, blobformat.MaxAttachmentSize+1)
+	if _, err = u.store.AttachReader(uuid, "big.bin", big); err == nil {
+		t.Error("it should not attach more than the limit")
+	}
+	blob, _ = u.store.MustFind(uuid)
+	if _, ok := blob[blobformat.AttachmentKey("big.bin")]; ok {
+		t.Error("the attachment should have been rolled back")
+	}
+
+	chunks := 0
+	for k := range blob {
+		if blobformat.IsAttachmentChunk(k) {
+			chunks++
+		}
+	}
+	if chunks != 1 {
+		t.Error("old chunks should be deleted, have:", chunks)
+	}
+
+	if err = u.store.Set(uuid, "attachment.key.bin/0", "x"); !blobformat.IsKeyNotAllowed(err) {
+		t.Error("chunks should only be set by attaching:", err)
+	}
+	if err = u.store.DeleteKey(uuid, blobformat.AttachmentKey("key.bin")); err != nil {
+		t.Fatal(err)
+	}
+	blob, _ = u.store.MustFind(uuid)
+	for k := range blob {
+		if blobformat.IsAttachmentKey(k) {
+			t.Error("attachment keys should all be deleted:", k)
+		}
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+// logLine is a transaction as it's exported for auditing, values are left
+// out since they're the secrets
+type logLine struct {
+	Time   string        `json:"time"`
+	Kind   txlogs.TxKind `json:"kind"`
+	UUID   string        `json:"uuid"`
+	Name   string        `json:"name,omitempty"`
+	Key    string        `json:"key,omitempty"`
+	Device string        `json:"device,omitempty"`
+}
+
+// logExport writes the log to path as json lines, to the output when path
+// is -
+func (u *uiContext) logExport(path string) error {
+	if path == "-" {
+		return writeLogJSONL(u.out, u.store.DB.Log)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		yes, err := u.getYesNo(fmt.Sprintf("%s exists, overwrite it?", path))
+		if err != nil || !yes {
+			return err
+		}
+	}
+
+	// Names of entries and keys say a lot, only readable by us
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		errColor.Println(err)
+		return nil
+	}
+
+	err = writeLogJSONL(f, u.store.DB.Log)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		errColor.Println("failed to write log:", err)
+		return nil
+	}
+
+	infoColor.Printf("wrote %d changes to: %s\n", len(u.store.DB.Log), path)
+	return nil
+}
+
+// writeLogJSONL writes a line of json for each transaction in the log, named
+// by what its entry was called when it was made (the add by its first name)
+func writeLogJSONL(w io.Writer, log []txlogs.Tx) error {
+	buf := bufio.NewWriter(w)
+	enc := json.NewEncoder(buf)
+
+	names := make(map[string]string)
+	for _, tx := range log {
+		if _, ok := names[tx.UUID]; !ok && tx.Kind == txlogs.TxSetKey && tx.Key == blobformat.KeyName {
+			names[tx.UUID] = tx.Value
+		}
+	}
+
+	for _, tx := range log {
+		if tx.Kind == txlogs.TxSetKey && tx.Key == blobformat.KeyName {
+			names[tx.UUID] = tx.Value
+		}
+
+		line := logLine{
+			Time:   time.Unix(0, tx.Time).UTC().Format(time.RFC3339Nano),
+			Kind:   tx.Kind,
+			UUID:   tx.UUID,
+			Name:   names[tx.UUID],
+			Key:    tx.Key,
+			Device: tx.Device,
+		}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+
+	return buf.Flush()
+}
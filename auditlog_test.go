@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestLogExport(t *testing.T) {
+	t.Parallel()
+
+	out := new(bytes.Buffer)
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: out}
+	u.store.DB.SetDevice("laptop", nil)
+
+	uuid, err := u.store.New("github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.store.Set(uuid, blobformat.KeyPass, "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.store.Rename(uuid, "work/github"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = u.logExport("-"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "hunter2") {
+		t.Error("values must not be exported")
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(u.store.DB.Log) {
+		t.Fatal("there should be a line for each change:", len(lines))
+	}
+
+	var first, last logLine
+	if err = json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+		t.Fatal(err)
+	}
+	if first.Kind != txlogs.TxAdd || first.UUID != uuid || first.Name != "github" || first.Device != "laptop" {
+		t.Errorf("wrong add: %#v", first)
+	}
+	if last.Key != blobformat.KeyName || last.Name != "work/github" || len(last.Time) == 0 {
+		t.Errorf("wrong rename: %#v", last)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	defaultAutoSyncInterval = 10 * time.Minute
+)
+
+var (
+	errHeadless = errors.New("cannot prompt while running in the background")
+)
+
+// autoSyncer periodically runs the sync cycle in the background while the
+// repl is open. It shares the uiContext with the repl and so it must hold
+// the uiContext lock while it's syncing.
+type autoSyncer struct {
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// startAutoSync begins syncing in the background every interval, if a
+// background sync is already running it's replaced with the new interval.
+func (u *uiContext) startAutoSync(interval time.Duration) {
+	u.stopAutoSync(false)
+
+	a := &autoSyncer{
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	u.autoSync = a
+
+	go a.run(u)
+}
+
+// stopAutoSync stops background syncing. If wait is true it waits for
+// a sync in progress to finish, this must never be done while holding the
+// uiContext lock since the syncer needs it to notice it's been stopped.
+func (u *uiContext) stopAutoSync(wait bool) {
+	a := u.autoSync
+	if a == nil {
+		return
+	}
+
+	close(a.stop)
+	u.autoSync = nil
+
+	if wait {
+		<-a.done
+	}
+}
+
+func (a *autoSyncer) run(u *uiContext) {
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+
+		u.Lock()
+		// We may have been stopped while waiting for the lock
+		select {
+		case <-a.stop:
+			u.Unlock()
+			return
+		default:
+		}
+
+		// Anything that would require user input can't be done while the
+		// user is busy typing in the repl, so we refuse to prompt and let
+		// those syncs fail until the user runs sync by hand.
+		u.bgSync.Lock()
+		u.headless = true
+		err := u.sync("", true, true)
+		u.headless = false
+		u.bgSync.Unlock()
+		u.Unlock()
+
+		if err != nil {
+			errColor.Println("background sync failed:", err)
+		}
+	}
+}
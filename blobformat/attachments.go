@@ -0,0 +1,232 @@
+package blobformat
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// AttachmentChunkSize is how many bytes of a file are in each of its
+	// chunks, so attaching a big file isn't one huge transaction
+	AttachmentChunkSize = 48 << 10
+	// MaxAttachmentSize is the largest file that can be attached, every
+	// sync pushes it
+	MaxAttachmentSize = 10 << 20
+)
+
+// attachmentPrefix starts the keys of attachments. An attachment's key is
+// the prefix and its name with its size, number of chunks and sha256 as the
+// value. Its chunks are base64 in the same key followed by /i.
+const attachmentPrefix = "attachment."
+
+// Attachment is a file attached to an entry
+type Attachment struct {
+	Name   string
+	Size   int
+	Chunks int
+	// Sum is the hex sha256 of the file
+	Sum string
+}
+
+// IsAttachmentKey checks if the key is an attachment or one of its chunks,
+// they're managed by Attach and Detach
+func IsAttachmentKey(key string) bool {
+	return strings.HasPrefix(key, attachmentPrefix)
+}
+
+// IsAttachmentChunk checks if the key is one of the chunks of an attachment
+func IsAttachmentChunk(key string) bool {
+	return IsAttachmentKey(key) && strings.IndexByte(key[len(attachmentPrefix):], '/') >= 0
+}
+
+// AttachmentKey is the key of the attachment with the name
+func AttachmentKey(name string) string {
+	return attachmentPrefix + name
+}
+
+func attachmentChunkKey(name string, i int) string {
+	return fmt.Sprintf("%s%s/%d", attachmentPrefix, name, i)
+}
+
+// Attachments returns the files attached to the entry sorted by name
+func (b Blob) Attachments() []Attachment {
+	var attachments []Attachment
+	for k, v := range b {
+		if !IsAttachmentKey(k) || IsAttachmentChunk(k) {
+			continue
+		}
+
+		a, err := parseAttachment(k[len(attachmentPrefix):], v)
+		if err != nil {
+			continue
+		}
+		attachments = append(attachments, a)
+	}
+
+	sort.Slice(attachments, func(i, j int) bool {
+		return attachments[i].Name < attachments[j].Name
+	})
+	return attachments
+}
+
+// Attachment returns the file attached to the entry with the name, it's
+// checked against the size and sum it was attached with
+func (b Blob) Attachment(name string) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := b.WriteAttachment(name, &buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// WriteAttachment writes the file attached to the entry with the name to w a
+// chunk at a time. It's checked against the size and sum it was attached with
+// once it's written, when that fails what was written is corrupt.
+func (b Blob) WriteAttachment(name string, w io.Writer) (Attachment, error) {
+	value, ok := b[AttachmentKey(name)]
+	if !ok {
+		return Attachment{}, fmt.Errorf("%s has no attachment %q", b.Name(), name)
+	}
+	a, err := parseAttachment(name, value)
+	if err != nil {
+		return a, err
+	}
+
+	hash := sha256.New()
+	out := io.MultiWriter(w, hash)
+	decoded := make([]byte, AttachmentChunkSize)
+	size := 0
+	for i := 0; i < a.Chunks; i++ {
+		chunk, ok := b[attachmentChunkKey(name, i)]
+		if !ok {
+			return a, fmt.Errorf("attachment %q is missing chunk %d", name, i)
+		}
+		if base64.StdEncoding.DecodedLen(len(chunk)) > len(decoded) {
+			return a, fmt.Errorf("attachment %q chunk %d is too big", name, i)
+		}
+		n, err := base64.StdEncoding.Decode(decoded, []byte(chunk))
+		if err != nil {
+			return a, fmt.Errorf("attachment %q chunk %d is corrupt: %w", name, i, err)
+		}
+		if _, err = out.Write(decoded[:n]); err != nil {
+			return a, err
+		}
+		size += n
+	}
+
+	if size != a.Size || hex.EncodeToString(hash.Sum(nil)) != a.Sum {
+		return a, fmt.Errorf("attachment %q is corrupt, it doesn't match its checksum", name)
+	}
+
+	return a, nil
+}
+
+// Attach a file to an entry in chunks, an attachment with the same name is
+// replaced
+func (b Blobs) Attach(uuid, name string, data []byte) error {
+	if len(data) > MaxAttachmentSize {
+		return fmt.Errorf("attachments can't be larger than %d MiB", MaxAttachmentSize>>20)
+	}
+
+	_, err := b.AttachReader(uuid, name, bytes.NewReader(data))
+	return err
+}
+
+// AttachReader is Attach for a file that's read a chunk at a time, it's never
+// all in memory at once outside of the log. Nothing is attached when reading
+// fails or it's too large.
+func (b Blobs) AttachReader(uuid, name string, r io.Reader) (a Attachment, err error) {
+	if len(name) == 0 || strings.ContainsAny(name, "/\n") {
+		return a, fmt.Errorf("invalid attachment name %q", name)
+	}
+
+	blob, err := b.MustFind(uuid)
+	if err != nil {
+		return a, err
+	}
+	old, _ := parseAttachment(name, blob[AttachmentKey(name)])
+
+	a.Name = name
+	err = b.DB.Do(func() error {
+		hash := sha256.New()
+		buf := make([]byte, AttachmentChunkSize)
+		for {
+			n, err := io.ReadFull(r, buf)
+			if n != 0 {
+				if a.Size += n; a.Size > MaxAttachmentSize {
+					return fmt.Errorf("attachments can't be larger than %d MiB", MaxAttachmentSize>>20)
+				}
+				hash.Write(buf[:n])
+				b.DB.Set(uuid, attachmentChunkKey(name, a.Chunks), base64.StdEncoding.EncodeToString(buf[:n]))
+				a.Chunks++
+			}
+
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+
+		for i := a.Chunks; i < old.Chunks; i++ {
+			b.DB.DeleteKey(uuid, attachmentChunkKey(name, i))
+		}
+
+		a.Sum = hex.EncodeToString(hash.Sum(nil))
+		b.touchUpdated(uuid)
+		b.DB.Set(uuid, AttachmentKey(name), fmt.Sprintf("%d %d %s", a.Size, a.Chunks, a.Sum))
+		return nil
+	})
+
+	return a, err
+}
+
+// Detach removes a file attached to an entry
+func (b Blobs) Detach(uuid, name string) error {
+	blob, err := b.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+
+	value, ok := blob[AttachmentKey(name)]
+	if !ok {
+		return fmt.Errorf("%s has no attachment %q", blob.Name(), name)
+	}
+	a, _ := parseAttachment(name, value)
+
+	b.touchUpdated(uuid)
+	b.DB.DeleteKey(uuid, AttachmentKey(name))
+	for i := 0; i < a.Chunks; i++ {
+		b.DB.DeleteKey(uuid, attachmentChunkKey(name, i))
+	}
+	return nil
+}
+
+// parseAttachment parses the value of an attachment's key
+func parseAttachment(name, value string) (Attachment, error) {
+	a := Attachment{Name: name}
+	fields := strings.Fields(value)
+	if len(fields) != 3 {
+		return a, errors.New("malformed attachment " + name)
+	}
+
+	var err error
+	if a.Size, err = strconv.Atoi(fields[0]); err != nil {
+		return a, fmt.Errorf("malformed attachment %s: %w", name, err)
+	}
+	if a.Chunks, err = strconv.Atoi(fields[1]); err != nil {
+		return a, fmt.Errorf("malformed attachment %s: %w", name, err)
+	}
+	a.Sum = fields[2]
+
+	return a, nil
+}
@@ -2,6 +2,7 @@ package blobformat
 
 import (
 	"fmt"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -50,7 +51,8 @@ func (b Blob) Get(key string) string {
 // be empty but err will also be nil. If the otp library returns an error
 // it will be propagated here.
 //
-// This uses the TOTP algorithm (Google-Authenticator like).
+// This uses the TOTP algorithm (Google-Authenticator like) with the
+// algorithm, digits and period of the uri, SHA1, 6 and 30 when it has none.
 func (b Blob) TwoFactor() (string, error) {
 	twoFactorURI := b[KeyTwoFactor]
 
@@ -68,7 +70,12 @@ func (b Blob) TwoFactor() (string, error) {
 		return "", fmt.Errorf("two factor key for %s was not a totp key", b.Name())
 	}
 
-	code, err := totp.GenerateCode(key.Secret(), time.Now().UTC())
+	opts, err := totpOptions(key)
+	if err != nil {
+		return "", fmt.Errorf("two factor key for %s was invalid: %w", b.Name(), err)
+	}
+
+	code, err := totp.GenerateCodeCustom(key.Secret(), time.Now().UTC(), opts)
 	if err != nil {
 		return "", err
 	}
@@ -76,6 +83,55 @@ func (b Blob) TwoFactor() (string, error) {
 	return code, nil
 }
 
+// totpOptions reads the algorithm, digits and period parameters of a totp
+// key's uri, the ones it leaves out are what authenticators assume
+func totpOptions(key *otp.Key) (opts totp.ValidateOpts, err error) {
+	opts = totp.ValidateOpts{
+		Period:    30,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	}
+
+	u, err := url.Parse(key.URL())
+	if err != nil {
+		return opts, err
+	}
+	query := u.Query()
+
+	if alg := query.Get("algorithm"); len(alg) != 0 {
+		switch strings.ToUpper(alg) {
+		case "SHA1":
+			opts.Algorithm = otp.AlgorithmSHA1
+		case "SHA256":
+			opts.Algorithm = otp.AlgorithmSHA256
+		case "SHA512":
+			opts.Algorithm = otp.AlgorithmSHA512
+		case "MD5":
+			opts.Algorithm = otp.AlgorithmMD5
+		default:
+			return opts, fmt.Errorf("unknown algorithm %q", alg)
+		}
+	}
+
+	if digits := query.Get("digits"); len(digits) != 0 {
+		n, err := strconv.Atoi(digits)
+		if err != nil || n < 6 || n > 8 {
+			return opts, fmt.Errorf("digits must be 6 to 8, got %q", digits)
+		}
+		opts.Digits = otp.Digits(n)
+	}
+
+	if period := query.Get("period"); len(period) != 0 {
+		n, err := strconv.ParseUint(period, 10, 32)
+		if err != nil || n == 0 {
+			return opts, fmt.Errorf("period must be a number of seconds, got %q", period)
+		}
+		opts.Period = uint(n)
+	}
+
+	return opts, nil
+}
+
 // Labels for the blob
 func (b Blob) Labels() []string {
 	labelVal := b[KeyLabels]
@@ -86,12 +142,71 @@ func (b Blob) Labels() []string {
 	return strings.Split(labelVal, ",")
 }
 
+// Kind of the entry, empty for plain entries
+func (b Blob) Kind() string {
+	return b[KeyKind]
+}
+
+// Protected keys for the blob
+func (b Blob) Protected() []string {
+	return b.keyList(KeyProtected)
+}
+
+// IsProtected checks if key has been protected
+func (b Blob) IsProtected(key string) bool {
+	for _, p := range b.Protected() {
+		if p == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Secrets are the keys of the blob marked secret, see Blobs.MarkSecret
+func (b Blob) Secrets() []string {
+	return b.keyList(KeySecrets)
+}
+
+// IsSecret checks if key has been marked secret
+func (b Blob) IsSecret(key string) bool {
+	for _, s := range b.Secrets() {
+		if s == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keyList splits a comma separated list of keys
+func (b Blob) keyList(listKey string) []string {
+	val := b[listKey]
+	if len(val) == 0 {
+		return nil
+	}
+
+	return strings.Split(val, ",")
+}
+
 // Updated timestamp, if not set it will be time's zero value, returns an error
 // if the underlying type was wrong.
 func (b Blob) Updated() (time.Time, error) {
 	return b.getTimestamp(KeyUpdated)
 }
 
+// IsTrashed checks if the entry is in the trash
+func (b Blob) IsTrashed() bool {
+	_, ok := b[KeyTrashed]
+	return ok
+}
+
+// Trashed returns when the entry was moved to the trash, the zero time if
+// it's not in the trash
+func (b Blob) Trashed() (time.Time, error) {
+	return b.getTimestamp(KeyTrashed)
+}
+
 func (b Blob) getTimestamp(key string) (time.Time, error) {
 	timestamp, ok := txlogs.Entry(b)[key]
 	if !ok {
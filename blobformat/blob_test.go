@@ -0,0 +1,96 @@
+package blobformat
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+func TestTOTPOptionsRFC6238(t *testing.T) {
+	t.Parallel()
+
+	// The seeds and test vectors of RFC 6238 appendix B
+	seeds := map[string]string{
+		"SHA1":   "12345678901234567890",
+		"SHA256": "12345678901234567890123456789012",
+		"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	tests := []struct {
+		Time  int64
+		Codes map[string]string
+	}{
+		{59, map[string]string{"SHA1": "94287082", "SHA256": "46119246", "SHA512": "90693936"}},
+		{1111111109, map[string]string{"SHA1": "07081804", "SHA256": "68084774", "SHA512": "25091201"}},
+		{1111111111, map[string]string{"SHA1": "14050471", "SHA256": "67062674", "SHA512": "99943326"}},
+		{1234567890, map[string]string{"SHA1": "89005924", "SHA256": "91819424", "SHA512": "93441116"}},
+		{2000000000, map[string]string{"SHA1": "69279037", "SHA256": "90698825", "SHA512": "38618901"}},
+		{20000000000, map[string]string{"SHA1": "65353130", "SHA256": "77737706", "SHA512": "47863826"}},
+	}
+
+	for alg, seed := range seeds {
+		secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte(seed))
+		uri := "otpauth://totp/rfc6238:test?" + url.Values{
+			"secret":    {secret},
+			"algorithm": {alg},
+			"digits":    {"8"},
+		}.Encode()
+
+		key, err := otp.NewKeyFromURL(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts, err := totpOptions(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, test := range tests {
+			code, err := totp.GenerateCodeCustom(key.Secret(), time.Unix(test.Time, 0).UTC(), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := test.Codes[alg]; code != want {
+				t.Errorf("%s at %d: want: %s, got: %s", alg, test.Time, want, code)
+			}
+		}
+	}
+}
+
+func TestTOTPOptions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Query string
+		Opts  totp.ValidateOpts
+		Err   bool
+	}{
+		{"", totp.ValidateOpts{Period: 30, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}, false},
+		{"&algorithm=sha512&digits=8&period=60", totp.ValidateOpts{Period: 60, Digits: otp.DigitsEight, Algorithm: otp.AlgorithmSHA512}, false},
+		{"&algorithm=sha3", totp.ValidateOpts{}, true},
+		{"&digits=9", totp.ValidateOpts{}, true},
+	}
+
+	for i, test := range tests {
+		key, err := otp.NewKeyFromURL("otpauth://totp/test?secret=JBSWY3DPEHPK3PXP" + test.Query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts, err := totpOptions(key)
+		if test.Err {
+			if err == nil {
+				t.Errorf("%d) want an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d) %v", i, err)
+		} else if opts != test.Opts {
+			t.Errorf("%d) want: %#v, got: %#v", i, test.Opts, opts)
+		}
+	}
+}
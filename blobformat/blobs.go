@@ -23,7 +23,7 @@ var (
 type keyNotAllowed string
 
 func (k keyNotAllowed) Error() string {
-	return fmt.Sprintf("%q may not be set", k)
+	return fmt.Sprintf("%q may not be set", string(k))
 }
 
 // IsKeyNotAllowed checks if the error is a key error (some keys cannot
@@ -36,6 +36,8 @@ func IsKeyNotAllowed(err error) bool {
 // Blobs exposes operations on special keys in the blob file structure
 // All manipulation should be done via this interface or special keys like
 // updated and snapshots will probably be mishandled.
+//
+// Like the DB it wraps it isn't safe for concurrent use, reads included.
 type Blobs struct {
 	*txlogs.DB
 }
@@ -55,6 +57,9 @@ func (b Blobs) RenameDuplicates() (map[string]string, error) {
 
 	for uuid, entry := range b.Snapshot {
 		blob := Blob(entry)
+		if blob.IsTrashed() {
+			continue
+		}
 		name := blob.Name()
 
 		_, ok := names[name]
@@ -81,16 +86,16 @@ func (b Blobs) RenameDuplicates() (map[string]string, error) {
 // Search names of entries using fuzzy search and breaks on /
 // to help organization. The returned list of names is not sorted.
 //
-// If search is empty, all results names returned.
+// If search is empty, all results names returned. Entries in the trash are
+// never returned.
 //
 // Most other commands will require a fully qualified name of an entry to
 // manipulate.
 func (b Blobs) Search(search string) (entries SearchResults, err error) {
-	if err := b.UpdateSnapshot(); err != nil {
-		return nil, err
-	}
+	b.updateIndex()
 
-	if len(b.DB.Snapshot) == 0 {
+	names := b.DB.Values(KeyName)
+	if len(names) == 0 {
 		return nil, nil
 	}
 	if len(search) == 0 {
@@ -99,76 +104,62 @@ func (b Blobs) Search(search string) (entries SearchResults, err error) {
 
 	entries = make(map[string]string)
 	fragments := strings.Split(search, "/")
-	nFrags := len(fragments)
-
-AllKeys:
-	for uuid, entry := range b.DB.Snapshot {
-		blob := Blob(entry)
-		name := blob.Name()
-
-		if len(fragments) == 1 {
-			if !fuzzy.Match(name, fragments[0]) {
-				continue AllKeys
-			}
-		} else {
-			keyFrags := strings.Split(name, "/")
-			if len(keyFrags) < nFrags {
-				continue
-			}
+	trashed := b.DB.Values(KeyTrashed)
 
-			for i, f := range fragments {
-				if !fuzzy.Match(keyFrags[i], f) {
-					continue AllKeys
-				}
-			}
+	// Only the names with every character of the search can match it
+	for uuid := range b.nameCandidates(search) {
+		if _, ok := trashed[uuid]; ok {
+			continue
 		}
 
-		entries[uuid] = blob.Name()
+		if name := names[uuid]; matchName(name, fragments) {
+			entries[uuid] = name
+		}
 	}
 
 	return entries, nil
 }
 
+// matchName checks the name against a search split on /, see Search
+func matchName(name string, fragments []string) bool {
+	if len(fragments) == 1 {
+		return fuzzy.Match(name, fragments[0])
+	}
+
+	keyFrags := strings.Split(name, "/")
+	if len(keyFrags) < len(fragments) {
+		return false
+	}
+	for i, f := range fragments {
+		if !fuzzy.Match(keyFrags[i], f) {
+			return false
+		}
+	}
+	return true
+}
+
 // SearchLabels searches by finding all entries with all the labels given.
 func (b Blobs) SearchLabels(labels ...string) (entries SearchResults, err error) {
-	if err := b.UpdateSnapshot(); err != nil {
-		return nil, err
-	}
+	b.updateIndex()
 
-	if len(b.DB.Snapshot) == 0 {
+	names := b.DB.Values(KeyName)
+	if len(names) == 0 {
 		return nil, nil
 	}
 	if len(labels) == 0 {
 		return b.allEntries(), nil
 	}
 
-	entries = make(map[string]string)
-	for uuid, entry := range b.DB.Snapshot {
-		blob := Blob(entry)
-
-		lblVal := blob[KeyLabels]
-		if len(lblVal) == 0 {
-			continue
-		}
-
-		haveLabels := strings.Split(lblVal, ",")
-
-		found := 0
-		for _, want := range labels {
-			for _, have := range haveLabels {
-				if have != want {
-					continue
-				}
-
-				found++
-				if found == len(labels) {
-					break
-				}
-			}
-		}
+	terms := make([]string, len(labels))
+	for i, label := range labels {
+		terms[i] = termLabel + label
+	}
 
-		if found == len(labels) {
-			entries[uuid] = blob.Name()
+	entries = make(map[string]string)
+	trashed := b.DB.Values(KeyTrashed)
+	for uuid := range b.DB.Lookup(terms...) {
+		if _, ok := trashed[uuid]; !ok {
+			entries[uuid] = names[uuid]
 		}
 	}
 
@@ -180,15 +171,11 @@ func (b Blobs) SearchLabels(labels ...string) (entries SearchResults, err error)
 // useful because it calls UpdateSnapshot for you which does not happen
 // when accessing the map directly.
 func (b Blobs) Find(uuid string) (Blob, error) {
-	if err := b.UpdateSnapshot(); err != nil {
+	entry, err := b.DB.Entry(uuid)
+	if err != nil || entry == nil {
 		return nil, err
 	}
-
-	blob, ok := b.DB.Snapshot[uuid]
-	if !ok {
-		return nil, nil
-	}
-	return Blob(blob), nil
+	return Blob(entry), nil
 }
 
 // MustFind is Find() but never returns a nil blob, panics instead.
@@ -207,20 +194,35 @@ func (b Blobs) MustFind(uuid string) (Blob, error) {
 
 // FindByName returns "", nil if it does not find the
 // object. Error does not occur unless something unexpected
-// happened.
+// happened. Entries in the trash are not found.
 func (b Blobs) FindByName(name string) (string, Blob, error) {
-	if err := b.UpdateSnapshot(); err != nil {
+	uuid := b.findName(name)
+	if len(uuid) == 0 {
+		return "", nil, nil
+	}
+
+	blob, err := b.Find(uuid)
+	if err != nil {
 		return "", nil, err
 	}
+	return uuid, blob, nil
+}
 
-	for uuid, entry := range b.DB.Snapshot {
-		blob := Blob(entry)
-		if blob.Name() == name {
-			return uuid, blob, nil
+// findName returns the uuid of the entry not in the trash with the name, ""
+// if there's none. Only the names are materialized to find it, not the
+// snapshot.
+func (b Blobs) findName(name string) string {
+	trashed := b.DB.Values(KeyTrashed)
+	for uuid, n := range b.DB.Values(KeyName) {
+		if n != name {
+			continue
+		}
+		if _, ok := trashed[uuid]; !ok {
+			return uuid
 		}
 	}
 
-	return "", nil, nil
+	return ""
 }
 
 // FindUser return "", nil if the user could not be found.
@@ -243,14 +245,17 @@ func (b Blobs) MustFindUser(username string) (string, Blob, error) {
 }
 
 func (b Blobs) allEntries() (entries SearchResults) {
-	if len(b.DB.Snapshot) == 0 {
+	names := b.DB.Values(KeyName)
+	if len(names) == 0 {
 		return nil
 	}
 
 	entries = make(map[string]string)
-	for uuid, entry := range b.DB.Snapshot {
-		blob := Blob(entry)
-		entries[uuid] = blob.Name()
+	trashed := b.DB.Values(KeyTrashed)
+	for uuid, name := range names {
+		if _, ok := trashed[uuid]; !ok {
+			entries[uuid] = name
+		}
 	}
 	return entries
 }
@@ -283,14 +288,8 @@ func (s SearchResults) Names() []string {
 
 // Users finds all the users in the system
 func (b Blobs) Users() (results SearchResults, err error) {
-	if err = b.UpdateSnapshot(); err != nil {
-		return nil, err
-	}
-
-	for uuid, entry := range b.DB.Snapshot {
-		blob := Blob(entry)
-
-		if !IsUserEntry(blob.Name()) {
+	for uuid, name := range b.DB.Values(KeyName) {
+		if !IsUserEntry(name) {
 			continue
 		}
 
@@ -298,7 +297,7 @@ func (b Blobs) Users() (results SearchResults, err error) {
 			results = make(SearchResults)
 		}
 
-		results[uuid] = blob.Name()
+		results[uuid] = name
 	}
 
 	return results, nil
@@ -308,15 +307,8 @@ func (b Blobs) Users() (results SearchResults, err error) {
 // is not unique. The entry is not immediately inserted but instead returned
 // so things may be added to it before its stored with the Add function.
 func (b Blobs) New(name string) (uuid string, err error) {
-	if err = b.UpdateSnapshot(); err != nil {
-		return "", err
-	}
-
-	for _, entry := range b.DB.Snapshot {
-		blob := Blob(entry)
-		if name == blob.Name() {
-			return "", ErrNameNotUnique
-		}
+	if len(b.findName(name)) != 0 {
+		return "", ErrNameNotUnique
 	}
 
 	uuid, err = b.DB.Add()
@@ -330,24 +322,23 @@ func (b Blobs) New(name string) (uuid string, err error) {
 }
 
 // Rename a specific uuid to a new name, returns ErrNameNotUnique if not
-// possible.
+// possible. Links to the entry's keys are changed to the new name.
 func (b Blobs) Rename(uuid, newName string) error {
-	if err := b.UpdateSnapshot(); err != nil {
-		return err
+	if len(b.findName(newName)) != 0 {
+		return ErrNameNotUnique
 	}
 
-	for _, entry := range b.DB.Snapshot {
-		blob := Blob(entry)
-		if blob.Name() == newName {
-			return ErrNameNotUnique
-		}
+	blob, err := b.Find(uuid)
+	if err != nil {
+		return err
 	}
-
-	_, ok := b.DB.Snapshot[uuid]
-	if !ok {
+	if blob == nil {
 		return errors.New("uuid not found")
 	}
 
+	if err = b.renameLinks(blob.Name(), newName); err != nil {
+		return err
+	}
 	b.touchUpdated(uuid)
 	b.DB.Set(uuid, KeyName, newName)
 	return nil
@@ -356,13 +347,38 @@ func (b Blobs) Rename(uuid, newName string) error {
 // Set the key in name to value, properly updates 'updated' and 'snapshots'.
 // returns keyNotAllowed error if a protected key is attempted to be set.
 // To update protected keys like: labels, notes, twofactor, updated you must
-// use the specific setters.
+// use the specific setters. Setting pass keeps the one it replaces in the
+// entry's history (see PassHistory). Attachments are set with Attach. The
+// value must be of the key's type in the entry's schema (see
+// IsInvalidValue), for a link (see Link) it's the value it links to that
+// must be.
 func (b Blobs) Set(uuid, key, value string) error {
 	for _, p := range protectedKeys {
 		if strings.EqualFold(key, p) {
 			return keyNotAllowed(key)
 		}
 	}
+	if IsAttachmentKey(key) {
+		return keyNotAllowed(key)
+	}
+
+	blob, err := b.Find(uuid)
+	if err != nil {
+		return err
+	}
+	typed := value
+	if _, _, ok := ParseLink(value); ok {
+		if typed, err = b.resolve(blob.Name()+"/"+key, value, uuid, key); err != nil {
+			return err
+		}
+	}
+	if err = blob.Schema().Validate(key, typed); err != nil {
+		return err
+	}
+
+	if key == KeyPass {
+		b.recordPass(uuid, blob, value)
+	}
 
 	b.touchUpdated(uuid)
 	b.DB.Set(uuid, key, value)
@@ -370,10 +386,13 @@ func (b Blobs) Set(uuid, key, value string) error {
 }
 
 // DeleteKey from an entry, follows the rules of Set() for protected keys.
+// Deleting an attachment's key deletes its chunks too (see Detach).
 func (b Blobs) DeleteKey(uuid, key string) error {
-	switch key {
-	case KeyName, KeyUpdated:
+	switch {
+	case key == KeyName, key == KeyUpdated, IsAttachmentChunk(key):
 		return keyNotAllowed(key)
+	case IsAttachmentKey(key):
+		return b.Detach(uuid, key[len(attachmentPrefix):])
 	}
 
 	b.touchUpdated(uuid)
@@ -386,7 +405,8 @@ func (b Blobs) DeleteKey(uuid, key string) error {
 //
 // This function accepts values in two formats, it may be a simple secret
 // key value like JBSWY3DPEHPK3PXP in which case it will coerced into a totp
-// url.
+// url. The algorithm, digits and period parameters of a uri are checked to
+// be ones TwoFactor can generate codes for.
 //
 // Reference for format:
 // https://github.com/google/google-authenticator/wiki/Key-Uri-Format
@@ -403,10 +423,13 @@ func (b Blobs) SetTwofactor(uuid, uriOrKey string) error {
 		)
 	}
 
-	_, err := otp.NewKeyFromURL(uri)
+	key, err := otp.NewKeyFromURL(uri)
 	if err != nil {
 		return fmt.Errorf("could not set two factor key, uri wouldn't parse: %w", err)
 	}
+	if _, err = totpOptions(key); err != nil {
+		return fmt.Errorf("could not set two factor key: %w", err)
+	}
 
 	b.touchUpdated(uuid)
 	b.DB.Set(uuid, KeyTwoFactor, uri)
@@ -458,6 +481,81 @@ func (b Blobs) RemoveLabel(uuid string, index int) (err error) {
 	return nil
 }
 
+// Protect a key on an entry so that it requires force to modify. Returns
+// false if the key was already protected.
+func (b Blobs) Protect(uuid, key string) (bool, error) {
+	return b.addToKeyList(uuid, KeyProtected, key)
+}
+
+// Unprotect a key on an entry. Returns false if the key was not protected.
+func (b Blobs) Unprotect(uuid, key string) (bool, error) {
+	return b.removeFromKeyList(uuid, KeyProtected, key)
+}
+
+// MarkSecret marks a key on an entry as secret so it's hidden when it's
+// shown. Returns false if the key was already secret.
+func (b Blobs) MarkSecret(uuid, key string) (bool, error) {
+	return b.addToKeyList(uuid, KeySecrets, key)
+}
+
+// UnmarkSecret removes the secret mark from a key on an entry. Returns false
+// if the key was not secret.
+func (b Blobs) UnmarkSecret(uuid, key string) (bool, error) {
+	return b.removeFromKeyList(uuid, KeySecrets, key)
+}
+
+// addToKeyList adds a key to a comma separated list of keys of an entry (eg.
+// protected), false if it's already in it
+func (b Blobs) addToKeyList(uuid, listKey, key string) (bool, error) {
+	entry, err := b.MustFind(uuid)
+	if err != nil {
+		return false, err
+	}
+
+	keys := entry.keyList(listKey)
+	for _, k := range keys {
+		if k == key {
+			return false, nil
+		}
+	}
+	keys = append(keys, key)
+
+	b.touchUpdated(uuid)
+	b.DB.Set(uuid, listKey, strings.Join(keys, ","))
+	return true, nil
+}
+
+// removeFromKeyList removes a key from a comma separated list of keys of an
+// entry, false if it's not in it. The list is deleted once it's empty.
+func (b Blobs) removeFromKeyList(uuid, listKey, key string) (bool, error) {
+	entry, err := b.MustFind(uuid)
+	if err != nil {
+		return false, err
+	}
+
+	keys := entry.keyList(listKey)
+	index := -1
+	for i, k := range keys {
+		if k == key {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return false, nil
+	}
+
+	keys = append(keys[:index], keys[index+1:]...)
+
+	b.touchUpdated(uuid)
+	if len(keys) == 0 {
+		b.DB.DeleteKey(uuid, listKey)
+	} else {
+		b.DB.Set(uuid, listKey, strings.Join(keys, ","))
+	}
+	return true, nil
+}
+
 // NewSync creates a new blob with a unique name to have values set on it before
 // calling Add() to add it to the store.
 //
@@ -486,11 +584,127 @@ func (b Blobs) NewUser(name string) (uuid string, err error) {
 	return b.New(userPrefix + name)
 }
 
+// SyncLog returns the log as it should be pushed to a sync remote as well as
+// the number of entries that were excluded. Transactions belonging to entries
+// labeled nosync or any of the excludeLabels are removed as are all changes
+// to local keys (see IsLocalKey).
+//
+// An entry is considered labeled by the last value its labels key had in the
+// log so entries that were deleted while labeled stay excluded. User entries
+// are never excluded since other users would be unable to open the file.
+func (b Blobs) SyncLog(excludeLabels ...string) (log []txlogs.Tx, excluded int) {
+	labels := make(map[string]string)
+	names := make(map[string]string)
+	for _, tx := range b.DB.Log {
+		switch {
+		case tx.Kind == txlogs.TxSetKey && tx.Key == KeyLabels:
+			labels[tx.UUID] = tx.Value
+		case tx.Kind == txlogs.TxDeleteKey && tx.Key == KeyLabels:
+			delete(labels, tx.UUID)
+		case tx.Kind == txlogs.TxSetKey && tx.Key == KeyName:
+			names[tx.UUID] = tx.Value
+		}
+	}
+
+	exclude := make(map[string]struct{})
+	for uuid, lbls := range labels {
+		if IsUserEntry(names[uuid]) {
+			continue
+		}
+
+	Labels:
+		for _, l := range strings.Split(lbls, ",") {
+			if l == LabelNoSync {
+				exclude[uuid] = struct{}{}
+				break
+			}
+			for _, e := range excludeLabels {
+				if l == e {
+					exclude[uuid] = struct{}{}
+					break Labels
+				}
+			}
+		}
+	}
+
+	log = make([]txlogs.Tx, 0, len(b.DB.Log))
+	for _, tx := range b.DB.Log {
+		if _, ok := exclude[tx.UUID]; ok {
+			continue
+		}
+		if (tx.Kind == txlogs.TxSetKey || tx.Kind == txlogs.TxDeleteKey) && IsLocalKey(tx.Key) {
+			continue
+		}
+		log = append(log, tx)
+	}
+
+	return log, len(exclude)
+}
+
+// IsLocalKey checks if the key is local to this machine, these are never
+// pushed to sync remotes. Usage information and machine specific settings
+// belong in local keys.
+func IsLocalKey(key string) bool {
+	return strings.HasPrefix(key, localKeyPrefix)
+}
+
+// Settings returns the entry that holds the settings for the file, returns
+// nil if no settings have ever been set.
+func (b Blobs) Settings() (Blob, error) {
+	_, blob, err := b.FindByName(settingsName)
+	return blob, err
+}
+
+// Setting returns the value of a setting, "" if it's not set.
+func (b Blobs) Setting(key string) (string, error) {
+	blob, err := b.Settings()
+	if err != nil || blob == nil {
+		return "", err
+	}
+
+	return blob[key], nil
+}
+
+// SetSetting sets a value in the settings entry, creating it if necessary.
+// An empty value deletes the setting.
+func (b Blobs) SetSetting(key, value string) error {
+	uuid, _, err := b.FindByName(settingsName)
+	if err != nil {
+		return err
+	}
+
+	if len(uuid) == 0 {
+		if len(value) == 0 {
+			return nil
+		}
+
+		if uuid, err = b.New(settingsName); err != nil {
+			return err
+		}
+	}
+
+	if len(value) == 0 {
+		return b.DeleteKey(uuid, key)
+	}
+	return b.Set(uuid, key, value)
+}
+
+// IsSyncEntry checks to see if the name conforms to sync standards
+func IsSyncEntry(name string) bool {
+	return strings.HasPrefix(name, syncPrefix)
+}
+
 // IsUserEntry checks to see if the name conforms to user standards
 func IsUserEntry(name string) bool {
 	return strings.HasPrefix(name, userPrefix)
 }
 
+// IsSystemEntry checks to see if the name is an entry that bpass manages
+// for itself
+func IsSystemEntry(name string) bool {
+	return strings.HasPrefix(name, systemPrefix)
+}
+
 // SplitUsername returns a username from an entry name, returns empty string
 // if this was not a proper user entryname
 func SplitUsername(entryname string) string {
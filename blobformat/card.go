@@ -0,0 +1,83 @@
+package blobformat
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaskCardNumber hides all but the last 4 digits of a card number
+func MaskCardNumber(number string) string {
+	digits := CardDigits(number)
+	if len(digits) <= 4 {
+		return digits
+	}
+	return strings.Repeat("*", len(digits)-4) + digits[len(digits)-4:]
+}
+
+// CardDigits removes the spaces and dashes a card number is grouped by
+func CardDigits(number string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, number)
+}
+
+// validCardNumber checks a card number is 12 to 19 digits (grouped by spaces
+// or dashes) with a valid Luhn check digit
+func validCardNumber(number string) error {
+	digits := CardDigits(number)
+	if len(digits) < 12 || len(digits) > 19 {
+		return errors.New("it must be 12 to 19 digits")
+	}
+
+	sum := 0
+	for i := range digits {
+		c := digits[len(digits)-1-i]
+		if c < '0' || c > '9' {
+			return fmt.Errorf("%q is not a digit", c)
+		}
+
+		n := int(c - '0')
+		if i%2 == 1 {
+			if n *= 2; n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+	}
+
+	if sum%10 != 0 {
+		return errors.New("its check digit is wrong, there's a typo")
+	}
+	return nil
+}
+
+// ParseMonth parses the value of a month field like 01/28 or 01/2028, the
+// time is the start of the month
+func ParseMonth(value string) (time.Time, error) {
+	slash := strings.IndexByte(value, '/')
+	if slash < 0 {
+		return time.Time{}, fmt.Errorf("%q is not a month like 01/28", value)
+	}
+
+	month, err := strconv.Atoi(value[:slash])
+	if err != nil || month < 1 || month > 12 {
+		return time.Time{}, fmt.Errorf("%q is not a month like 01/28", value)
+	}
+	year, err := strconv.Atoi(value[slash+1:])
+	switch {
+	case err != nil || year < 0:
+		return time.Time{}, fmt.Errorf("%q is not a month like 01/28", value)
+	case len(value[slash+1:]) == 2:
+		year += 2000
+	case len(value[slash+1:]) != 4:
+		return time.Time{}, fmt.Errorf("%q is not a month like 01/28", value)
+	}
+
+	return time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC), nil
+}
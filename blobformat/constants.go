@@ -15,12 +15,119 @@ const (
 	KeyTwoFactor = "totp"
 	KeyNotes     = "notes"
 	KeyLabels    = "labels"
+	// KeyPassHistory is the passwords an entry had before, see
+	// Blob.PassHistory
+	KeyPassHistory = "passhist"
+	// KeyRecovery is the unused recovery codes of an account, one per line,
+	// KeyRecoveryUsed is the ones that were used with when, see
+	// Blobs.UseRecoveryCode
+	KeyRecovery     = "recovery"
+	KeyRecoveryUsed = "recoveryused"
+	// KeyExpires is when the entry's password or certificate expires
+	KeyExpires = "expires"
+
+	// KeyKind is the kind of entry (eg. cert), entries without it are
+	// plain logins
+	KeyKind = "kind"
+
+	// Certificate keys, the private key is stored in KeyPriv
+	KeyCert  = "cert"
+	KeyChain = "chain"
+
+	// Payment card keys
+	KeyNumber     = "number"
+	KeyExpiry     = "expiry"
+	KeyCVV        = "cvv"
+	KeyCardholder = "cardholder"
+	KeyPIN        = "pin"
+
+	// Identity keys, a passport's or id's expiry is KeyExpires
+	KeyFullName  = "fullname"
+	KeyBirthdate = "birthdate"
+	KeyAddress   = "address"
+	KeyPhone     = "phone"
+	KeyPassport  = "passport"
+	KeyIDNumber  = "idnumber"
+
+	// Wifi keys
+	KeySSID       = "ssid"
+	KeySecurity   = "security"
+	KeyPassphrase = "passphrase"
+
+	// Passkey keys, the private key is stored in KeyPriv and the account's
+	// name in KeyUser
+	KeyRPID         = "rpid"
+	KeyCredentialID = "credentialid"
+	KeyUserHandle   = "userhandle"
+	KeyCounter      = "counter"
+
+	// KeyProtected is a list of keys in the entry that require force to
+	// modify
+	KeyProtected = "protected"
+	// KeySecrets is a list of keys in the entry that are hidden like
+	// passwords when they're shown
+	KeySecrets = "secrets"
+	// KeyTrashed is when (unix nanoseconds) the entry was moved to the
+	// trash, entries in the trash are left out of searches
+	KeyTrashed = "trashed"
+	// KeyPinned is when (unix nanoseconds) the entry was pinned, pinned
+	// entries are listed first
+	KeyPinned = "pinned"
+	// KeyLocked is when (unix nanoseconds) the entry was locked, locked
+	// entries can't be changed until they're unlocked
+	KeyLocked = "locked"
+	// KeyIcon is an emoji (or any short text) the entry is listed with
+	KeyIcon = "icon"
+	// KeyColor is the name of the color the entry is listed in
+	KeyColor = "color"
 
 	// Synchronization keys in user data
 	KeySync       = "sync"
 	KeyPriv       = "privkey"
 	KeyPub        = "pubkey"
+	KeySSHCert    = "sshcert"
 	KeyKnownHosts = "knownhosts"
+	KeyTailscale  = "tailscale"
+	KeyConflicts  = "conflicts"
+	// KeyExclude is a list of labels, entries with these labels are not
+	// pushed to the sync entry
+	KeyExclude = "exclude"
+	// KeyDirection limits a sync entry to push or pull only, see the
+	// Direction constants
+	KeyDirection = "direction"
+	// KeySchedule limits when a sync entry is automatically synced, see
+	// "help sync" for the format
+	KeySchedule = "schedule"
+	// KeyJump is a [user@]host[:port] that scp sync connections are
+	// tunneled through
+	KeyJump = "jump"
+	// KeySCPPath is the scp binary to run on the remote of a scp sync entry
+	// when it's not in the PATH
+	KeySCPPath = "scppath"
+	// KeySSHFP when "true" checks unknown host keys of scp sync entries
+	// against the SSHFP records of the host in dns before asking
+	KeySSHFP = "sshfp"
+	// KeyHostKeyAlgorithms, KeyKexAlgorithms, KeyCiphers and KeyMACs are
+	// comma separated lists of the algorithms offered to the host of a scp
+	// sync entry in order of preference, they override the settings of the
+	// same names
+	KeyHostKeyAlgorithms = "hostkeyalgorithms"
+	KeyKexAlgorithms     = "kexalgorithms"
+	KeyCiphers           = "ciphers"
+	KeyMACs              = "macs"
+	// KeyHostKeyHistory records every time a known host's key was replaced
+	// after the user verified the new one, one rotation per line
+	KeyHostKeyHistory = "hostkeyhistory"
+	// KeyRemoteUser and KeyRemotePass are remembered credentials for a
+	// remote encrypted with a different user or passphrase. They're local
+	// keys so they're never pushed and the passphrase is sealed with the
+	// file's key.
+	KeyRemoteUser = localKeyPrefix + "remoteuser"
+	KeyRemotePass = localKeyPrefix + "remotepass"
+	// KeySyncBase is where the log was the last time this machine merged
+	// with the sync entry's remote and pushed to it (see txlogs.Base), it's
+	// used as the common ancestor of the next merge
+	KeySyncBase = localKeyPrefix + "syncbase"
 
 	// User keys
 	KeyIV   = "iv"
@@ -28,9 +135,111 @@ const (
 	KeyMKey = "mkey"
 )
 
+// Keys for the settings entry
+const (
+	// SettingSyncOnSave syncs all auto-sync entries whenever the file is
+	// saved
+	SettingSyncOnSave = "synconsave"
+	// SettingConflicts is the conflict resolution policy for syncing,
+	// sync entries may override it with their own conflicts key
+	SettingConflicts = "conflicts"
+	// SettingSyncRetries and SettingSyncBackoff control retrying of
+	// pulls/pushes that fail due to network trouble
+	SettingSyncRetries = "syncretries"
+	SettingSyncBackoff = "syncbackoff"
+	// SettingSyncTimeout limits how long a single pull/push may take
+	SettingSyncTimeout = "synctimeout"
+	// SettingSyncRateLimit limits scp transfers to a number of KB/s
+	SettingSyncRateLimit = "syncratelimit"
+	// SettingRequireSigned rejects pulled files that aren't signed by a
+	// trusted device
+	SettingRequireSigned = "requiresigned"
+	// SettingHostKeyAlgorithms, SettingKexAlgorithms, SettingCiphers and
+	// SettingMACs are the algorithms offered to all scp sync hosts, sync
+	// entries may override them with their own keys
+	SettingHostKeyAlgorithms = "hostkeyalgorithms"
+	SettingKexAlgorithms     = "kexalgorithms"
+	SettingCiphers           = "ciphers"
+	SettingMACs              = "macs"
+	// SettingKDF is the cost of deriving keys from passphrases (see
+	// crypt.ParseKDFParams), used whenever a passphrase or key changes
+	SettingKDF = "kdf"
+	// SettingRekeyHistory records every rekey of the file, one per line
+	SettingRekeyHistory = "rekeyhistory"
+	// SettingCipher is the cipher the file is encrypted with (see
+	// crypt.CipherVersion), used every time it's saved
+	SettingCipher = "cipher"
+	// SettingKeyCache is how long the derived key is kept in the os
+	// keychain after the passphrase is typed
+	SettingKeyCache = "keycache"
+	// SettingTPMSecret is the secret sealed to enrolled devices' tpms, it's
+	// followed by /username for users of multi-user files
+	SettingTPMSecret = "tpmsecret"
+	// SettingCompactLength and SettingCompactDays are when the log is
+	// compacted on save and how much history is kept when it is
+	SettingCompactLength = "compactlength"
+	SettingCompactDays   = "compactdays"
+	// SettingEncoding is how the log is encoded before it's encrypted
+	SettingEncoding = "encoding"
+	// SettingTrashDays is how long entries stay in the trash before they're
+	// deleted for good
+	SettingTrashDays = "trashdays"
+	// SettingPassHistory is how many old passwords each entry keeps
+	SettingPassHistory = "passhistory"
+	// SettingAliasEmail is the email address generated email aliases are
+	// plus-addressed from
+	SettingAliasEmail = "aliasemail"
+	// SettingCompacted is the unix nanosecond time history was last
+	// compacted before in files from before checkpoints
+	SettingCompacted = "compacted"
+	// SettingCheckpoint is where history was last pruned to (see
+	// txlogs.Checkpoint), merges are compacted again up to it
+	SettingCheckpoint = "checkpoint"
+	// SettingMergeRules are how keys set on both sides of a sync are merged
+	// (see ParseMergeRules), on top of the default ones
+	SettingMergeRules = "mergerules"
+	// SettingDeviceKey is this machine's key for signing pushed files
+	SettingDeviceKey = localKeyPrefix + "devicekey"
+)
+
+// Directions for sync entries, an entry without a direction is both
 const (
-	syncPrefix = "sync/"
-	userPrefix = "user/"
+	DirectionBoth = "both"
+	DirectionPush = "push"
+	DirectionPull = "pull"
+)
+
+// LabelNoSync marks an entry as local only, it is never pushed to sync
+// remotes
+const LabelNoSync = "nosync"
+
+// Kinds of entries, sync entries are the sync kind by their name rather than
+// the kind key
+const (
+	KindCert = "cert"
+	KindCard = "card"
+	// KindIdentity is personal details used to fill in forms
+	KindIdentity = "identity"
+	// KindWifi is a wireless network, see Blob.WifiQR
+	KindWifi = "wifi"
+	// KindPasskey is a webauthn credential, see Blob.Passkey
+	KindPasskey = "passkey"
+	// KindNote is a document: a multiline body in notes, labels as its tags
+	// and attachments, without a user or password
+	KindNote = "note"
+	KindSync = "sync"
+)
+
+const (
+	syncPrefix   = "sync/"
+	userPrefix   = "user/"
+	systemPrefix = "bpass/"
+
+	localKeyPrefix = "local."
+
+	settingsName = systemPrefix + "settings"
+	syncLogName  = systemPrefix + "synclog"
+	devicesName  = systemPrefix + "devices"
 )
 
 var (
@@ -45,17 +254,56 @@ var (
 		KeyTwoFactor,
 		KeyNotes,
 		KeyLabels,
+		KeyPassHistory,
+		KeyRecovery,
+		KeyRecoveryUsed,
+		KeyExpires,
+		KeyKind,
+		KeyCert,
+		KeyChain,
+		KeyNumber,
+		KeyExpiry,
+		KeyCVV,
+		KeyCardholder,
+		KeyPIN,
+		KeyFullName,
+		KeyBirthdate,
+		KeyAddress,
+		KeyPhone,
+		KeyPassport,
+		KeyIDNumber,
+		KeySSID,
+		KeySecurity,
+		KeyPassphrase,
+		KeyRPID,
+		KeyCredentialID,
+		KeyUserHandle,
+		KeyCounter,
+		KeyProtected,
+		KeySecrets,
+		KeyTrashed,
+		KeyPinned,
+		KeyLocked,
+		KeyIcon,
+		KeyColor,
 
 		KeySync,
 		KeyPriv,
 		KeyPub,
+		KeySSHCert,
 		KeyKnownHosts,
+		KeyTailscale,
+		KeyConflicts,
+		KeyExclude,
 	}
 
 	// protectedKeys is a list of keys that cannot be set to a string value
 	protectedKeys = []string{
 		// Special setters
 		KeyTwoFactor,
+		KeyProtected,
+		KeySecrets,
+		KeyPassHistory,
 
 		// Forbidden
 		KeyName,
@@ -65,5 +313,8 @@ var (
 
 		// Dates
 		KeyUpdated,
+		KeyTrashed,
+		KeyPinned,
+		KeyLocked,
 	}
 )
@@ -0,0 +1,67 @@
+package blobformat
+
+import "strconv"
+
+// Devices returns the devices trusted to sign sync payloads as device names
+// mapped to their base64 encoded ed25519 public keys.
+//
+// Machines that created the devices entry before they first synced each
+// have their own, so all entries with the name are read.
+func (b Blobs) Devices() (map[string]string, error) {
+	if err := b.UpdateSnapshot(); err != nil {
+		return nil, err
+	}
+
+	var devices map[string]string
+	for _, entry := range b.DB.Snapshot {
+		blob := Blob(entry)
+		if blob.Name() != devicesName {
+			continue
+		}
+
+		if devices == nil {
+			devices = make(map[string]string)
+		}
+		for k, v := range blob {
+			if k != KeyName && k != KeyUpdated {
+				devices[k] = v
+			}
+		}
+	}
+
+	return devices, nil
+}
+
+// AddDevice trusts a device's public key, creating the devices entry if
+// necessary. Nothing is done if the key is already trusted under any name
+// and a number is added to the name if it's used by another key.
+func (b Blobs) AddDevice(name, pubKey string) error {
+	devices, err := b.Devices()
+	if err != nil {
+		return err
+	}
+
+	for _, v := range devices {
+		if v == pubKey {
+			return nil
+		}
+	}
+
+	unique := name
+	for i := 2; len(devices[unique]) != 0; i++ {
+		unique = name + "-" + strconv.Itoa(i)
+	}
+
+	uuid, _, err := b.FindByName(devicesName)
+	if err != nil {
+		return err
+	}
+
+	if len(uuid) == 0 {
+		if uuid, err = b.New(devicesName); err != nil {
+			return err
+		}
+	}
+
+	return b.Set(uuid, unique, pubKey)
+}
@@ -0,0 +1,52 @@
+package blobformat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// iconMaxRunes is how long an icon may be, emoji are often several code
+// points joined together
+const iconMaxRunes = 10
+
+// EntryColors are the names of the colors entries may be listed in
+var EntryColors = []string{
+	"red", "green", "yellow", "blue", "magenta", "cyan", "white", "grey",
+	"brightred", "brightgreen", "brightyellow", "brightblue",
+	"brightmagenta", "brightcyan", "brightwhite",
+}
+
+// Icon returns the icon the entry is listed with, "" if it has none
+func (b Blob) Icon() string {
+	return b[KeyIcon]
+}
+
+// Color returns the name of the color the entry is listed in, "" if it has
+// none. See EntryColors.
+func (b Blob) Color() string {
+	return b[KeyColor]
+}
+
+// validIcon checks an icon is short and has no spaces
+func validIcon(icon string) error {
+	if utf8.RuneCountInString(icon) > iconMaxRunes {
+		return fmt.Errorf("it can't be longer than %d characters", iconMaxRunes)
+	}
+	if strings.IndexFunc(icon, unicode.IsSpace) >= 0 {
+		return errors.New("it can't have spaces")
+	}
+	return nil
+}
+
+// validColor checks a color is one of EntryColors
+func validColor(name string) error {
+	for _, c := range EntryColors {
+		if name == c {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not one of %s", name, strings.Join(EntryColors, ", "))
+}
@@ -0,0 +1,124 @@
+package blobformat
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Prefixes of the terms in the index, names are indexed by each of their
+// characters so fuzzy searches only have to match the names that have all
+// of the searched characters
+const (
+	termName  = "n:"
+	termLabel = "l:"
+	termWord  = "w:"
+)
+
+// maxTermLen is the longest word in a value that's indexed, anything longer
+// is likely base64 or a hash nobody is going to search for
+const maxTermLen = 32
+
+// indexedKeys are the keys whose values are indexed by word, keys that
+// aren't known may hold secrets so they're left out
+var indexedKeys = map[string]bool{
+	KeyUser:  true,
+	KeyEmail: true,
+	KeyURL:   true,
+	KeyNotes: true,
+	KeyKind:  true,
+	KeyJump:  true,
+}
+
+// indexTerms is the txlogs.Indexer of the store
+func indexTerms(key, value string) []string {
+	switch {
+	case key == KeyName:
+		terms := make([]string, 0, len(value))
+		for _, r := range strings.ToLower(value) {
+			terms = append(terms, termName+string(r))
+		}
+		return terms
+	case key == KeyLabels:
+		var terms []string
+		for _, label := range strings.Split(value, ",") {
+			if len(label) != 0 {
+				terms = append(terms, termLabel+label)
+			}
+		}
+		return terms
+	case indexedKeys[key] || IsURLKey(key):
+		return wordTerms(value)
+	}
+
+	return nil
+}
+
+// wordTerms splits text into the words it's indexed by
+func wordTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := make([]string, 0, len(words))
+	for _, w := range words {
+		if len(w) <= maxTermLen {
+			terms = append(terms, termWord+w)
+		}
+	}
+	return terms
+}
+
+// updateIndex sets the indexer the first time it's needed, the index is
+// kept up to date with the log from then on
+func (b Blobs) updateIndex() {
+	if !b.DB.Indexed() {
+		b.DB.SetIndexer(indexTerms)
+	}
+}
+
+// nameCandidates returns the entries whose names have all the characters
+// of a fuzzy search (see fuzzy.Match), the ones that could match it
+func (b Blobs) nameCandidates(search string) map[string]struct{} {
+	var terms []string
+	for _, r := range strings.ToLower(search) {
+		if r != '/' {
+			terms = append(terms, termName+string(r))
+		}
+	}
+	if len(terms) == 0 {
+		names := b.DB.Values(KeyName)
+		candidates := make(map[string]struct{}, len(names))
+		for uuid := range names {
+			candidates[uuid] = struct{}{}
+		}
+		return candidates
+	}
+
+	return b.DB.Lookup(terms...)
+}
+
+// SearchText finds the entries that have all the words of text in their
+// user, email, url, notes, kind or jump keys. Secrets and keys that aren't
+// known aren't searched. Entries in the trash are never returned.
+func (b Blobs) SearchText(text string) (entries SearchResults, err error) {
+	b.updateIndex()
+
+	terms := wordTerms(text)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	names := b.DB.Values(KeyName)
+	trashed := b.DB.Values(KeyTrashed)
+	for uuid := range b.DB.Lookup(terms...) {
+		if _, ok := trashed[uuid]; ok {
+			continue
+		}
+		if entries == nil {
+			entries = make(SearchResults)
+		}
+		entries[uuid] = names[uuid]
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,135 @@
+package blobformat
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aarondl/bpass/txlogs"
+)
+
+// legacySnapshots was the key in which old versions stored an entry's
+// history, it can't be represented in the log and is dropped.
+const legacySnapshots = "snapshots"
+
+// IsLegacy checks if the plaintext is in the format used before the
+// transaction log: a json object of entry names to entries.
+func IsLegacy(plaintext []byte) bool {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(plaintext, &top); err != nil || len(top) == 0 {
+		return false
+	}
+
+	for _, k := range []string{"version", "snapshot", "log"} {
+		if _, ok := top[k]; ok {
+			return false
+		}
+	}
+
+	for _, v := range top {
+		var entry map[string]interface{}
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ConvertLegacy reads the old format and creates a log with one add per entry
+// followed by sets for each of its current values. History is not
+// preserved.
+func ConvertLegacy(plaintext []byte) (*txlogs.DB, error) {
+	var legacy map[string]map[string]interface{}
+	if err := json.Unmarshal(plaintext, &legacy); err != nil {
+		return nil, fmt.Errorf("failed to parse legacy format: %w", err)
+	}
+
+	names := make([]string, 0, len(legacy))
+	for name := range legacy {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	db := new(txlogs.DB)
+	for _, name := range names {
+		entry := legacy[name]
+
+		uuid, err := db.Add()
+		if err != nil {
+			return nil, err
+		}
+		db.Set(uuid, KeyName, name)
+
+		keys := make([]string, 0, len(entry))
+		for k := range entry {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if k == KeyName || k == legacySnapshots {
+				continue
+			}
+
+			value, err := legacyValue(k, entry[k])
+			if err != nil {
+				return nil, fmt.Errorf("entry %q key %q: %w", name, k, err)
+			}
+			if len(value) == 0 {
+				continue
+			}
+
+			db.Set(uuid, k, value)
+		}
+	}
+
+	if err := db.UpdateSnapshot(); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// legacyValue converts the old typed values to strings, lists are joined
+// the same way the current format stores them
+func legacyValue(key string, val interface{}) (string, error) {
+	switch v := val.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		if key == KeyUpdated {
+			// Timestamps may have been stored in seconds, anything this
+			// large is already in nanoseconds
+			if v > 1e12 {
+				return strconv.FormatInt(int64(v), 10), nil
+			}
+			return strconv.FormatInt(time.Unix(int64(v), 0).UnixNano(), 10), nil
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		sep := ","
+		if key == KeyNotes {
+			sep = "\n"
+		}
+
+		strs := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := legacyValue(key, item)
+			if err != nil {
+				return "", err
+			}
+			strs = append(strs, s)
+		}
+		return strings.Join(strs, sep), nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T", val)
+	}
+}
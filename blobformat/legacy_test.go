@@ -0,0 +1,135 @@
+package blobformat
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestIsLegacy(t *testing.T) {
+	t.Parallel()
+
+	legacy, err := ioutil.ReadFile("testdata/legacy.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := new(txlogs.DB)
+	uuid, err := db.Add()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Set(uuid, KeyName, "github")
+	current, err := db.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Name   string
+		Data   []byte
+		Legacy bool
+	}{
+		{"legacy", legacy, true},
+		{"current", current, false},
+		{"empty object", []byte(`{}`), false},
+		{"not an object", []byte(`[1, 2]`), false},
+		{"entry not an object", []byte(`{"github": "hunter2"}`), false},
+		{"not json", []byte{0x82, 0xa7}, false},
+	}
+
+	for _, test := range tests {
+		if got := IsLegacy(test.Data); got != test.Legacy {
+			t.Errorf("%s: want: %t, got: %t", test.Name, test.Legacy, got)
+		}
+	}
+}
+
+func TestConvertLegacy(t *testing.T) {
+	t.Parallel()
+
+	legacy, err := ioutil.ReadFile("testdata/legacy.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := ConvertLegacy(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := Blobs{DB: db}
+
+	want := map[string]map[string]string{
+		"github": {
+			KeyUser:      "aarondl",
+			KeyPass:      "hunter2",
+			KeyEmail:     "aaron@example.com",
+			KeyURL:       "https://github.com",
+			KeyTwoFactor: "otpauth://totp/github:aarondl?secret=JBSWY3DPEHPK3PXP&issuer=github",
+			KeyLabels:    "work,code",
+			KeyNotes:     "first line\nsecond line",
+			KeyUpdated:   strconv.FormatInt(time.Unix(1546300800, 0).UnixNano(), 10),
+		},
+		"aws": {
+			KeyUser:    "root",
+			KeyPass:    "correct horse",
+			"pin":      "1234",
+			"mfa":      "true",
+			KeyUpdated: "1577836800000000000",
+		},
+		"empty": {},
+	}
+
+	for name, values := range want {
+		uuid, blob, err := store.FindByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(uuid) == 0 {
+			t.Errorf("%s was not converted", name)
+			continue
+		}
+
+		values[KeyName] = name
+		got := make(map[string]string, len(blob))
+		for k, v := range blob {
+			got[k] = v
+		}
+		if !reflect.DeepEqual(got, values) {
+			t.Errorf("%s: want: %#v, got: %#v", name, values, got)
+		}
+		if _, ok := blob[legacySnapshots]; ok {
+			t.Errorf("%s: snapshots should be dropped", name)
+		}
+	}
+
+	// The converted log saves in the current format and loads back the same
+	saved, err := db.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsLegacy(saved) {
+		t.Error("the converted file should not be legacy")
+	}
+	loaded, err := txlogs.New(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = loaded.UpdateSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Snapshot, db.Snapshot) {
+		t.Errorf("round trip changed the entries:\nwant: %#v\ngot:  %#v", db.Snapshot, loaded.Snapshot)
+	}
+	if !reflect.DeepEqual(loaded.Log, db.Log) {
+		t.Error("round trip changed the log")
+	}
+
+	if _, err = ConvertLegacy([]byte(`{"github": {"user": {"nested": true}}}`)); err == nil {
+		t.Error("nested objects can't be converted")
+	}
+}
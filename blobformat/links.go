@@ -0,0 +1,111 @@
+package blobformat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// linkPrefix starts values that are links to another entry's key, the rest
+// of the value is the entry's name and the key separated by the last slash
+// (eg. ref:work/ldap/pass). A link is followed wherever the value is used so
+// a credential shared by many entries is stored and changed in one of them.
+const linkPrefix = "ref:"
+
+// maxLinkDepth is how many links are followed before giving up, links that
+// link back to themselves would be followed forever
+const maxLinkDepth = 8
+
+// brokenLink is a link that can't be followed
+type brokenLink string
+
+func (b brokenLink) Error() string {
+	return string(b)
+}
+
+// IsBrokenLink checks if the error is a link to an entry or key that doesn't
+// exist, or one of too many links in a row
+func IsBrokenLink(err error) bool {
+	_, ok := err.(brokenLink)
+	return ok
+}
+
+// Link is the value that links to the key of the entry with the name
+func Link(name, key string) string {
+	return linkPrefix + name + "/" + key
+}
+
+// ParseLink returns the name and key a value links to, ok is false when
+// the value isn't a link
+func ParseLink(value string) (name, key string, ok bool) {
+	if !strings.HasPrefix(value, linkPrefix) {
+		return "", "", false
+	}
+
+	value = value[len(linkPrefix):]
+	i := strings.LastIndexByte(value, '/')
+	if i <= 0 || i == len(value)-1 {
+		return "", "", false
+	}
+
+	return value[:i], value[i+1:], true
+}
+
+// Resolve returns the value of an entry's key, following it when it's a
+// link. A link to an entry or key that doesn't exist is an error (see
+// IsBrokenLink).
+func (b Blobs) Resolve(blob Blob, key string) (string, error) {
+	return b.resolve(blob.Name()+"/"+key, blob[key], "", "")
+}
+
+// resolve follows value from the key named from, a link that leads back to
+// the key of the entry with the uuid self is one to itself
+func (b Blobs) resolve(from, value, self, selfKey string) (string, error) {
+	for i := 0; i < maxLinkDepth; i++ {
+		name, linkKey, ok := ParseLink(value)
+		if !ok {
+			return value, nil
+		}
+
+		uuid, target, err := b.FindByName(name)
+		if err != nil {
+			return "", err
+		}
+		if len(uuid) == 0 {
+			return "", brokenLink(fmt.Sprintf("%s links to %s which does not exist", from, name))
+		}
+		if IsAttachmentKey(linkKey) {
+			return "", brokenLink(from + " links to an attachment")
+		}
+		if uuid == self && linkKey == selfKey {
+			return "", brokenLink(from + " links to itself")
+		}
+
+		if value, ok = target[linkKey]; !ok {
+			return "", brokenLink(fmt.Sprintf("%s links to %s.%s which is not set", from, name, linkKey))
+		}
+	}
+
+	return "", brokenLink(fmt.Sprintf("%s links to more than %d links in a row (or itself)", from, maxLinkDepth))
+}
+
+// renameLinks points the links to keys of an entry at its new name, links
+// can be in any key so it takes the whole snapshot
+func (b Blobs) renameLinks(oldName, newName string) error {
+	if err := b.UpdateSnapshot(); err != nil {
+		return err
+	}
+
+	for uuid, entry := range b.DB.Snapshot {
+		for k, v := range entry {
+			name, key, ok := ParseLink(v)
+			if !ok || name != oldName {
+				continue
+			}
+
+			b.touchUpdated(uuid)
+			b.DB.Set(uuid, k, Link(newName, key))
+		}
+	}
+
+	return nil
+}
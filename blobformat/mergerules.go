@@ -0,0 +1,98 @@
+package blobformat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aarondl/bpass/txlogs"
+)
+
+// Merge rules of keys set on both sides of a sync, see txlogs.Resolve
+const (
+	// MergeNewest keeps the value that was set last
+	MergeNewest = "newest"
+	// MergeUnion keeps what both sides added and drops what either removed,
+	// labels are merged by label and everything else by line
+	MergeUnion = "union"
+	// MergeAppend keeps every line both sides have
+	MergeAppend = "append"
+)
+
+// defaultMergeRules are the rules of keys that aren't in the mergerules
+// setting
+var defaultMergeRules = map[string]string{
+	KeyNotes:      MergeUnion,
+	KeyLabels:     MergeUnion,
+	KeyKnownHosts: MergeAppend,
+	KeyPass:       MergeNewest,
+
+	// A code used on either side is gone and both sides' uses are kept
+	KeyRecovery:     MergeUnion,
+	KeyRecoveryUsed: MergeAppend,
+	KeyPassHistory:  MergeAppend,
+}
+
+// ParseMergeRules parses comma separated key=rule pairs, eg.
+// notes=union,pass=newest,knownhosts=append
+func ParseMergeRules(s string) (map[string]string, error) {
+	rules := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+
+		equals := strings.IndexByte(pair, '=')
+		if equals <= 0 {
+			return nil, fmt.Errorf("merge rule %q must be key=rule", pair)
+		}
+
+		key, rule := pair[:equals], pair[equals+1:]
+		switch rule {
+		case MergeNewest, MergeUnion, MergeAppend:
+		default:
+			return nil, fmt.Errorf("merge rule for %s must be %s, %s or %s, not %q", key, MergeNewest, MergeUnion, MergeAppend, rule)
+		}
+		rules[key] = rule
+	}
+
+	return rules, nil
+}
+
+// MergeRules returns the rule of each key that's merged by something other
+// than its newest value, the defaults with the mergerules setting on top
+func (b Blobs) MergeRules() (map[string]txlogs.MergeRule, error) {
+	names := make(map[string]string, len(defaultMergeRules))
+	for key, rule := range defaultMergeRules {
+		names[key] = rule
+	}
+
+	setting, err := b.Setting(SettingMergeRules)
+	if err != nil {
+		return nil, err
+	}
+	custom, err := ParseMergeRules(setting)
+	if err != nil {
+		return nil, err
+	}
+	for key, rule := range custom {
+		names[key] = rule
+	}
+
+	rules := make(map[string]txlogs.MergeRule)
+	for key, rule := range names {
+		sep := "\n"
+		if key == KeyLabels {
+			sep = ","
+		}
+
+		switch rule {
+		case MergeUnion:
+			rules[key] = txlogs.UnionRule(sep)
+		case MergeAppend:
+			rules[key] = txlogs.AppendRule(sep)
+		}
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,70 @@
+package blobformat
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPassHistory is how many old passwords an entry keeps when the
+// passhistory setting isn't set
+const DefaultPassHistory = 10
+
+// OldPass is a password an entry had and when it was replaced
+type OldPass struct {
+	Replaced time.Time
+	Pass     string
+}
+
+// PassHistory returns the old passwords of the entry, newest first. They're
+// kept one per line in KeyPassHistory as the time they were replaced
+// followed by the password.
+func (b Blob) PassHistory() []OldPass {
+	var old []OldPass
+	for _, line := range strings.Split(b[KeyPassHistory], "\n") {
+		space := strings.IndexByte(line, ' ')
+		if space < 0 {
+			continue
+		}
+
+		replaced, err := time.Parse(time.RFC3339, line[:space])
+		if err != nil {
+			continue
+		}
+		old = append(old, OldPass{Replaced: replaced, Pass: line[space+1:]})
+	}
+	return old
+}
+
+// recordPass adds the password the entry has to its history before it's
+// replaced by pass, only the newest of them are kept (see passHistory)
+func (b Blobs) recordPass(uuid string, blob Blob, pass string) {
+	current, ok := blob[KeyPass]
+	if !ok || current == pass || strings.ContainsRune(current, '\n') {
+		return
+	}
+
+	lines := []string{time.Now().UTC().Format(time.RFC3339) + " " + current}
+	if history := blob[KeyPassHistory]; len(history) != 0 {
+		lines = append(lines, strings.Split(history, "\n")...)
+	}
+	if keep := b.passHistory(); len(lines) > keep {
+		lines = lines[:keep]
+	}
+
+	b.DB.Set(uuid, KeyPassHistory, strings.Join(lines, "\n"))
+}
+
+// passHistory reads the passhistory setting
+func (b Blobs) passHistory() int {
+	val, err := b.Setting(SettingPassHistory)
+	if err != nil || len(val) == 0 {
+		return DefaultPassHistory
+	}
+
+	n, err := strconv.Atoi(val)
+	if err != nil || n <= 0 {
+		return DefaultPassHistory
+	}
+	return n
+}
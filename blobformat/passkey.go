@@ -0,0 +1,129 @@
+package blobformat
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Passkey is a webauthn credential of a passkey entry
+type Passkey struct {
+	// RPID is the relying party (site) the credential is for, eg.
+	// github.com
+	RPID string
+	// CredentialID identifies the credential to the relying party, it's
+	// stored base64url encoded
+	CredentialID []byte
+	// UserHandle is the relying party's id of the account, it may be empty
+	UserHandle []byte
+	// Counter is the signature count the credential last gave
+	Counter uint32
+	// Key is an ecdsa P-256 or ed25519 private key
+	Key crypto.Signer
+}
+
+// Passkey reads the credential of a passkey entry
+func (b Blob) Passkey() (Passkey, error) {
+	var p Passkey
+	if b.Kind() != KindPasskey {
+		return p, fmt.Errorf("%s is not a passkey", b.Name())
+	}
+
+	p.RPID = b[KeyRPID]
+	if len(p.RPID) == 0 {
+		return p, errors.New("rpid is not set")
+	}
+
+	var err error
+	if p.CredentialID, err = DecodeBase64URL(b[KeyCredentialID]); err != nil || len(p.CredentialID) == 0 {
+		return p, errors.New("credentialid is not set or not base64url")
+	}
+	if p.UserHandle, err = DecodeBase64URL(b[KeyUserHandle]); err != nil {
+		return p, errors.New("userhandle is not base64url")
+	}
+	if counter, ok := b[KeyCounter]; ok {
+		n, err := strconv.ParseUint(counter, 10, 32)
+		if err != nil {
+			return p, fmt.Errorf("counter is not a signature count: %v", err)
+		}
+		p.Counter = uint32(n)
+	}
+
+	if p.Key, err = ParsePasskeyKey(b[KeyPriv]); err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// ParsePasskeyKey parses the pem of a passkey's private key, a pkcs#8 or
+// sec1 ecdsa P-256 key or a pkcs#8 ed25519 key
+func ParsePasskeyKey(pemKey string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errors.New("the private key must be pem encoded")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported private key type %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("ecdsa passkeys must use the P-256 curve")
+		}
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	}
+
+	return nil, errors.New("passkeys must be ecdsa P-256 or ed25519 keys")
+}
+
+// DecodeBase64URL decodes base64url with or without padding, the way
+// webauthn ids are usually written
+func DecodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(s), "="))
+}
+
+// NextSignCount increments the signature counter of a passkey entry and
+// returns it, the count an assertion made with it must give. It's an error
+// when the counter would wrap.
+func (b Blobs) NextSignCount(uuid string) (uint32, error) {
+	blob, err := b.MustFind(uuid)
+	if err != nil {
+		return 0, err
+	}
+	p, err := blob.Passkey()
+	if err != nil {
+		return 0, err
+	}
+	if p.Counter == math.MaxUint32 {
+		return 0, errors.New("the signature counter is used up")
+	}
+
+	p.Counter++
+	b.touchUpdated(uuid)
+	b.DB.Set(uuid, KeyCounter, strconv.FormatUint(uint64(p.Counter), 10))
+	return p.Counter, nil
+}
@@ -0,0 +1,94 @@
+package blobformat
+
+import (
+	"strconv"
+	"time"
+)
+
+// IsPinned checks if the entry is pinned
+func (b Blob) IsPinned() bool {
+	_, ok := b[KeyPinned]
+	return ok
+}
+
+// PinnedAt returns when the entry was pinned, the zero time if it isn't
+func (b Blob) PinnedAt() (time.Time, error) {
+	return b.getTimestamp(KeyPinned)
+}
+
+// Pin an entry so it's listed first, returns false if it was already pinned.
+// Pinning doesn't touch updated since the entry itself doesn't change.
+func (b Blobs) Pin(uuid string) (bool, error) {
+	return b.setFlag(uuid, KeyPinned, true)
+}
+
+// Unpin an entry, returns false if it wasn't pinned
+func (b Blobs) Unpin(uuid string) (bool, error) {
+	return b.setFlag(uuid, KeyPinned, false)
+}
+
+// Pinned returns the uuids of the pinned entries, the ones in the trash are
+// left out
+func (b Blobs) Pinned() map[string]bool {
+	return b.flagged(KeyPinned)
+}
+
+// IsLocked checks if the entry is locked
+func (b Blob) IsLocked() bool {
+	_, ok := b[KeyLocked]
+	return ok
+}
+
+// LockedAt returns when the entry was locked, the zero time if it isn't
+func (b Blob) LockedAt() (time.Time, error) {
+	return b.getTimestamp(KeyLocked)
+}
+
+// Lock an entry so it can't be changed until it's unlocked, returns false if
+// it was already locked. It doesn't touch updated, see Pin.
+func (b Blobs) Lock(uuid string) (bool, error) {
+	return b.setFlag(uuid, KeyLocked, true)
+}
+
+// Unlock an entry, returns false if it wasn't locked
+func (b Blobs) Unlock(uuid string) (bool, error) {
+	return b.setFlag(uuid, KeyLocked, false)
+}
+
+// Locked returns the uuids of the locked entries, the ones in the trash are
+// left out
+func (b Blobs) Locked() map[string]bool {
+	return b.flagged(KeyLocked)
+}
+
+// setFlag sets a key that's on when it's set to when it was, false if it
+// already was as asked
+func (b Blobs) setFlag(uuid, key string, on bool) (bool, error) {
+	blob, err := b.MustFind(uuid)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := blob[key]; ok == on {
+		return false, nil
+	}
+
+	if on {
+		b.DB.Set(uuid, key, strconv.FormatInt(time.Now().UnixNano(), 10))
+	} else {
+		b.DB.DeleteKey(uuid, key)
+	}
+	return true, nil
+}
+
+// flagged returns the uuids of the entries a flag is on for, leaving out the
+// ones in the trash
+func (b Blobs) flagged(key string) map[string]bool {
+	trashed := b.DB.Values(KeyTrashed)
+	flagged := make(map[string]bool)
+	for uuid := range b.DB.Values(key) {
+		if _, ok := trashed[uuid]; !ok {
+			flagged[uuid] = true
+		}
+	}
+	return flagged
+}
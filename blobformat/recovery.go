@@ -0,0 +1,56 @@
+package blobformat
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RecoveryCodes are the unused recovery codes of the entry, one per line of
+// KeyRecovery
+func (b Blob) RecoveryCodes() []string {
+	var codes []string
+	for _, line := range strings.Split(b[KeyRecovery], "\n") {
+		if line = strings.TrimSpace(line); len(line) != 0 {
+			codes = append(codes, line)
+		}
+	}
+	return codes
+}
+
+// UseRecoveryCode takes the first unused recovery code out of an entry's
+// KeyRecovery and records when it was used in KeyRecoveryUsed so it's never
+// handed out again. The code is empty when there are none left.
+func (b Blobs) UseRecoveryCode(uuid string) (code string, left int, err error) {
+	blob, err := b.MustFind(uuid)
+	if err != nil {
+		return "", 0, err
+	}
+
+	codes := blob.RecoveryCodes()
+	if len(codes) == 0 {
+		return "", 0, nil
+	}
+	code, codes = codes[0], codes[1:]
+
+	used := fmt.Sprintf("%s %s", time.Now().UTC().Format(time.RFC3339), code)
+	if old := blob[KeyRecoveryUsed]; len(old) != 0 {
+		used = old + "\n" + used
+	}
+
+	err = b.DB.Do(func() error {
+		if len(codes) == 0 {
+			b.DB.DeleteKey(uuid, KeyRecovery)
+		} else {
+			b.DB.Set(uuid, KeyRecovery, strings.Join(codes, "\n"))
+		}
+		b.DB.Set(uuid, KeyRecoveryUsed, used)
+		b.touchUpdated(uuid)
+		return nil
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	return code, len(codes), nil
+}
@@ -0,0 +1,243 @@
+package blobformat
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldType is the type of value a key holds
+type FieldType string
+
+// Field types
+const (
+	// FieldString is a single line of text
+	FieldString FieldType = "string"
+	// FieldSecret is text that's hidden when it's shown, it may be more than
+	// one line (eg. a private key)
+	FieldSecret FieldType = "secret"
+	// FieldURL is a url with a scheme like https://
+	FieldURL FieldType = "url"
+	// FieldURLPattern is a url or a host pattern like *.example.com, it's the
+	// type of the keys after url (url2, url3...), see MatchURL
+	FieldURLPattern FieldType = "urlpattern"
+	// FieldDate is a date like 2006-01-02 or a RFC3339 time
+	FieldDate FieldType = "date"
+	// FieldInt is a whole number
+	FieldInt FieldType = "int"
+	// FieldMultiline is text of any number of lines
+	FieldMultiline FieldType = "multiline"
+	// FieldCardNumber is a payment card number with a valid check digit, it
+	// may be grouped by spaces or dashes
+	FieldCardNumber FieldType = "cardnumber"
+	// FieldMonth is a month and year like 01/28 (eg. a card's expiry)
+	FieldMonth FieldType = "month"
+	// FieldIcon is a short piece of text without spaces like an emoji
+	FieldIcon FieldType = "icon"
+	// FieldColor is the name of a color, see EntryColors
+	FieldColor FieldType = "color"
+)
+
+// FieldTypes are all the field types
+var FieldTypes = []FieldType{FieldString, FieldSecret, FieldURL, FieldURLPattern, FieldDate, FieldInt, FieldMultiline, FieldCardNumber, FieldMonth, FieldIcon, FieldColor}
+
+// Schema is the type of each key of a kind of entry, keys that aren't in it
+// may hold anything
+type Schema map[string]FieldType
+
+// dateLayout is the layout of dates without a time
+const dateLayout = "2006-01-02"
+
+var (
+	// commonSchema is the keys of every kind of entry
+	commonSchema = Schema{
+		KeyUser:      FieldString,
+		KeyEmail:     FieldString,
+		KeyURL:       FieldURL,
+		KeyPass:      FieldSecret,
+		KeyTwoFactor: FieldSecret,
+		KeyNotes:     FieldMultiline,
+		KeyExpires:   FieldDate,
+		KeyIcon:      FieldIcon,
+		KeyColor:     FieldColor,
+
+		KeyPassHistory:  FieldSecret,
+		KeyRecovery:     FieldSecret,
+		KeyRecoveryUsed: FieldMultiline,
+	}
+
+	// kindSchemas are the keys of each kind of entry on top of the common
+	// ones, sync entries are the kind sync and entries without a kind are
+	// logins
+	kindSchemas = map[string]Schema{
+		"": {},
+		KindCert: {
+			KeyCert:  FieldMultiline,
+			KeyChain: FieldMultiline,
+			KeyPriv:  FieldSecret,
+		},
+		KindCard: {
+			KeyNumber:     FieldCardNumber,
+			KeyExpiry:     FieldMonth,
+			KeyCVV:        FieldSecret,
+			KeyCardholder: FieldString,
+			KeyPIN:        FieldSecret,
+		},
+		KindIdentity: {
+			KeyFullName:  FieldString,
+			KeyBirthdate: FieldDate,
+			KeyAddress:   FieldMultiline,
+			KeyPhone:     FieldString,
+			KeyPassport:  FieldSecret,
+			KeyIDNumber:  FieldSecret,
+		},
+		KindWifi: {
+			KeySSID:       FieldString,
+			KeySecurity:   FieldString,
+			KeyPassphrase: FieldSecret,
+		},
+		KindPasskey: {
+			KeyRPID:         FieldString,
+			KeyCredentialID: FieldString,
+			KeyUserHandle:   FieldString,
+			KeyCounter:      FieldInt,
+			KeyPriv:         FieldSecret,
+		},
+		KindNote: {},
+		KindSync: {
+			KeyPriv:           FieldSecret,
+			KeyPub:            FieldString,
+			KeySSHCert:        FieldString,
+			KeyKnownHosts:     FieldMultiline,
+			KeyHostKeyHistory: FieldMultiline,
+			KeyJump:           FieldString,
+			KeySCPPath:        FieldString,
+		},
+	}
+)
+
+// invalidValue is a value that isn't of its key's type
+type invalidValue struct {
+	key string
+	typ FieldType
+	err error
+}
+
+func (i invalidValue) Error() string {
+	return fmt.Sprintf("%s must be a %s: %v", i.key, i.typ, i.err)
+}
+
+// IsInvalidValue checks if the error is a value that doesn't match its
+// key's type in the entry's schema
+func IsInvalidValue(err error) bool {
+	_, ok := err.(invalidValue)
+	return ok
+}
+
+// SchemaOf returns the schema of a kind of entry, see Blob.Schema
+func SchemaOf(kind string) Schema {
+	schema := make(Schema, len(commonSchema)+len(kindSchemas[kind]))
+	for k, t := range commonSchema {
+		schema[k] = t
+	}
+	for k, t := range kindSchemas[kind] {
+		schema[k] = t
+	}
+	return schema
+}
+
+// Schema returns the schema of the entry's kind, templates have none since
+// their values are types (see TemplateField)
+func (b Blob) Schema() Schema {
+	if IsTemplateEntry(b[KeyName]) {
+		return Schema{}
+	}
+
+	kind := b.Kind()
+	if IsSyncEntry(b[KeyName]) {
+		kind = KindSync
+	}
+	return SchemaOf(kind)
+}
+
+// Validate checks value is of the key's type, any value is valid for keys
+// that aren't in the schema. The urls after url are url patterns.
+func (s Schema) Validate(key, value string) error {
+	typ, ok := s[key]
+	if !ok && IsURLKey(key) && len(s) != 0 {
+		typ, ok = FieldURLPattern, true
+	}
+	if !ok {
+		return nil
+	}
+	if err := typ.Validate(value); err != nil {
+		return invalidValue{key: key, typ: typ, err: err}
+	}
+	return nil
+}
+
+// Validate checks value is of the type
+func (f FieldType) Validate(value string) error {
+	switch f {
+	case FieldString:
+		if strings.ContainsRune(value, '\n') {
+			return errors.New("it can't have more than one line")
+		}
+	case FieldURL:
+		uri, err := url.Parse(value)
+		if err != nil {
+			return errors.New("it doesn't parse")
+		}
+		if len(uri.Scheme) == 0 || len(uri.Opaque) != 0 {
+			return errors.New("it must include a scheme like https://")
+		}
+	case FieldDate:
+		if _, err := ParseDate(value); err != nil {
+			return err
+		}
+	case FieldInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("%q is not a whole number", value)
+		}
+	case FieldCardNumber:
+		if err := validCardNumber(value); err != nil {
+			return err
+		}
+	case FieldMonth:
+		if _, err := ParseMonth(value); err != nil {
+			return err
+		}
+	case FieldURLPattern:
+		if err := validURLPattern(value); err != nil {
+			return err
+		}
+	case FieldIcon:
+		if err := validIcon(value); err != nil {
+			return err
+		}
+	case FieldColor:
+		if err := validColor(value); err != nil {
+			return err
+		}
+	case FieldSecret, FieldMultiline:
+	default:
+		return fmt.Errorf("unknown field type %q", string(f))
+	}
+
+	return nil
+}
+
+// ParseDate parses the value of a date field
+func ParseDate(value string) (time.Time, error) {
+	if t, err := time.Parse(dateLayout, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("%q is not a date like %s", value, dateLayout)
+}
@@ -0,0 +1,121 @@
+package blobformat
+
+import (
+	"testing"
+
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestFieldTypeValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Type  FieldType
+		Value string
+		Valid bool
+	}{
+		{FieldString, "aarondl", true},
+		{FieldString, "two\nlines", false},
+		{FieldSecret, "-----BEGIN KEY-----\nabc\n-----END KEY-----", true},
+		{FieldURL, "https://example.com/login", true},
+		{FieldURL, "example.com", false},
+		{FieldURL, "mailto:me@example.com", false},
+		{FieldURL, "https://%zz", false},
+		{FieldURLPattern, "https://example.com", true},
+		{FieldURLPattern, "*.example.com", true},
+		{FieldURLPattern, "example .com", false},
+		{FieldURLPattern, "[.example.com", false},
+		{FieldDate, "2006-01-02", true},
+		{FieldDate, "2006-01-02T15:04:05Z", true},
+		{FieldDate, "01/02/2006", false},
+		{FieldInt, "-42", true},
+		{FieldInt, "4.2", false},
+		{FieldMultiline, "a\nb\nc", true},
+		{FieldCardNumber, "4111 1111 1111 1111", true},
+		{FieldCardNumber, "4111-1111-1111-1111", true},
+		{FieldCardNumber, "4111 1111 1111 1112", false},
+		{FieldCardNumber, "4111", false},
+		{FieldCardNumber, "4111 1111 1111 111a", false},
+		{FieldMonth, "01/28", true},
+		{FieldMonth, "12/2030", true},
+		{FieldMonth, "13/28", false},
+		{FieldMonth, "01/028", false},
+		{FieldMonth, "0128", false},
+		{FieldIcon, "🔑", true},
+		{FieldIcon, "a b", false},
+		{FieldIcon, "abcdefghijk", false},
+		{FieldColor, "brightblue", true},
+		{FieldColor, "purple", false},
+		{FieldType("nope"), "value", false},
+	}
+
+	for i, test := range tests {
+		err := test.Type.Validate(test.Value)
+		if test.Valid && err != nil {
+			t.Errorf("%d) %s %q should be valid: %v", i, test.Type, test.Value, err)
+		} else if !test.Valid && err == nil {
+			t.Errorf("%d) %s %q should be invalid", i, test.Type, test.Value)
+		}
+	}
+
+	// Every type has a case
+	for _, typ := range FieldTypes {
+		if err := typ.Validate(""); err != nil && err.Error() == `unknown field type "`+string(typ)+`"` {
+			t.Errorf("%s is not validated", typ)
+		}
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	t.Parallel()
+
+	schema := SchemaOf(KindCard)
+	if err := schema.Validate(KeyNumber, "4111 1111 1111 1112"); !IsInvalidValue(err) {
+		t.Error("want an invalid value error, got:", err)
+	}
+	if err := schema.Validate("url2", "not a pattern"); !IsInvalidValue(err) {
+		t.Error("urls after url should be url patterns, got:", err)
+	}
+	if err := schema.Validate("custom", "anything\ngoes"); err != nil {
+		t.Error("keys outside the schema take any value:", err)
+	}
+}
+
+func TestSetRejected(t *testing.T) {
+	t.Parallel()
+
+	store := Blobs{DB: new(txlogs.DB)}
+	uuid, err := store.New("visa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.DB.Set(uuid, KeyKind, KindCard)
+	if err = store.Set(uuid, KeyNumber, "4111 1111 1111 1111"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Key   string
+		Value string
+		Check func(error) bool
+	}{
+		{KeyNumber, "4111 1111 1111 1112", IsInvalidValue},
+		{KeyURL, "example.com", IsInvalidValue},
+		{KeyPass, Link("missing", KeyPass), IsBrokenLink},
+		{KeyName, "mastercard", IsKeyNotAllowed},
+	}
+
+	for i, test := range tests {
+		if err := store.Set(uuid, test.Key, test.Value); !test.Check(err) {
+			t.Errorf("%d) %s %q was not rejected: %v", i, test.Key, test.Value, err)
+		}
+	}
+
+	blob, err := store.MustFind(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob[KeyNumber] != "4111 1111 1111 1111" || len(blob[KeyURL]) != 0 || len(blob[KeyPass]) != 0 {
+		t.Errorf("rejected values were set: %#v", blob)
+	}
+}
@@ -0,0 +1,119 @@
+package blobformat
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// MaxSyncRecords is how many records the sync log keeps, older ones are
+// deleted as new ones are added
+const MaxSyncRecords = 200
+
+// Sync record operations
+const (
+	SyncOpPull = "pull"
+	SyncOpPush = "push"
+)
+
+// SyncRecord is a single pull or push in the sync log
+type SyncRecord struct {
+	Time  time.Time `json:"time"`
+	Op    string    `json:"op"`
+	Sync  string    `json:"sync"`
+	Host  string    `json:"host"`
+	Bytes int       `json:"bytes"`
+	// Error is empty when the operation succeeded
+	Error string `json:"error,omitempty"`
+}
+
+// AddSyncRecords appends records to the sync log entry, creating it if
+// necessary. The entry is labeled nosync since each machine keeps its own.
+//
+// Records are keyed by their time in nanoseconds, raw sets are used so the
+// entry's updated timestamp isn't touched for every sync.
+func (b Blobs) AddSyncRecords(records ...SyncRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	uuid, blob, err := b.FindByName(syncLogName)
+	if err != nil {
+		return err
+	}
+
+	if len(uuid) == 0 {
+		if uuid, err = b.New(syncLogName); err != nil {
+			return err
+		}
+		b.DB.Set(uuid, KeyLabels, LabelNoSync)
+	}
+
+	keys := syncRecordKeys(blob)
+	taken := make(map[string]struct{}, len(keys)+len(records))
+	for _, k := range keys {
+		taken[k] = struct{}{}
+	}
+
+	for _, r := range records {
+		stamp := r.Time.UnixNano()
+		key := strconv.FormatInt(stamp, 10)
+		for _, ok := taken[key]; ok; _, ok = taken[key] {
+			stamp++
+			key = strconv.FormatInt(stamp, 10)
+		}
+
+		val, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+
+		b.DB.Set(uuid, key, string(val))
+		keys = append(keys, key)
+		taken[key] = struct{}{}
+	}
+
+	sort.Strings(keys)
+	for len(keys) > MaxSyncRecords {
+		b.DB.DeleteKey(uuid, keys[0])
+		keys = keys[1:]
+	}
+
+	return nil
+}
+
+// SyncRecords returns the sync log oldest first
+func (b Blobs) SyncRecords() ([]SyncRecord, error) {
+	_, blob, err := b.FindByName(syncLogName)
+	if err != nil || blob == nil {
+		return nil, err
+	}
+
+	keys := syncRecordKeys(blob)
+	sort.Strings(keys)
+
+	records := make([]SyncRecord, 0, len(keys))
+	for _, k := range keys {
+		var r SyncRecord
+		if err := json.Unmarshal([]byte(blob[k]), &r); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+
+	return records, nil
+}
+
+// syncRecordKeys returns the keys of the blob that are records, they're
+// all the same width so they sort as strings
+func syncRecordKeys(blob Blob) []string {
+	var keys []string
+	for k := range blob {
+		if _, err := strconv.ParseInt(k, 10, 64); err == nil {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,131 @@
+package blobformat
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// templatePrefix starts the names of template entries. Each key of a
+// template is a key of the entries made from it, its value is the key's type
+// optionally followed by a default value (see TemplateField).
+const templatePrefix = "template/"
+
+// TemplateField is a key that entries made from a template have
+type TemplateField struct {
+	Key  string
+	Type FieldType
+	// Default is used when no value is given
+	Default string
+	// Generate makes the value with the password generator instead of
+	// asking for it, Policy is the generator's settings (eg. length=24)
+	Generate bool
+	Policy   string
+}
+
+// IsTemplateEntry checks to see if the name is a template's
+func IsTemplateEntry(name string) bool {
+	return strings.HasPrefix(name, templatePrefix)
+}
+
+// TemplateName returns the name of the template entry for a template
+func TemplateName(name string) string {
+	return templatePrefix + name
+}
+
+// Templates returns the template entries
+func (b Blobs) Templates() (entries SearchResults, err error) {
+	all, err := b.Search("")
+	if err != nil {
+		return nil, err
+	}
+
+	for uuid, name := range all {
+		if !IsTemplateEntry(name) {
+			continue
+		}
+		if entries == nil {
+			entries = make(SearchResults)
+		}
+		entries[uuid] = name
+	}
+
+	return entries, nil
+}
+
+// ParseTemplateField parses the value of a template's key: a field type,
+// then either a default value or "gen" for a secret that's generated, with
+// the generator's policy after a colon (eg. "secret gen:length=24").
+func ParseTemplateField(key, value string) (TemplateField, error) {
+	f := TemplateField{Key: key}
+
+	typ := value
+	if i := strings.IndexByte(value, ' '); i >= 0 {
+		typ, f.Default = value[:i], value[i+1:]
+	}
+	f.Type = FieldType(typ)
+
+	known := false
+	for _, t := range FieldTypes {
+		known = known || t == f.Type
+	}
+	if !known {
+		return f, fmt.Errorf("template key %s has unknown type %q", key, typ)
+	}
+
+	switch {
+	case f.Default == "gen" || strings.HasPrefix(f.Default, "gen:"):
+		if f.Type != FieldSecret {
+			return f, fmt.Errorf("template key %s must be a secret to be generated", key)
+		}
+		f.Generate = true
+		f.Policy = strings.TrimPrefix(strings.TrimPrefix(f.Default, "gen"), ":")
+		f.Default = ""
+	case len(f.Default) != 0:
+		if err := f.Type.Validate(f.Default); err != nil {
+			return f, fmt.Errorf("template key %s default: %w", key, err)
+		}
+	}
+
+	return f, nil
+}
+
+// TemplateFields returns the fields of a template entry, the ones the add
+// wizard asks for first followed by the others sorted by key
+func (b Blob) TemplateFields() ([]TemplateField, error) {
+	var fields []TemplateField
+	for k, v := range b {
+		switch k {
+		case KeyName, KeyUpdated, KeyProtected, KeySecrets, KeyTrashed, KeyPinned, KeyLocked:
+			continue
+		}
+		if IsLocalKey(k) || IsAttachmentKey(k) {
+			continue
+		}
+
+		f, err := ParseTemplateField(k, v)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+
+	first := []string{KeyEmail, KeyUser, KeyPass}
+	order := func(key string) int {
+		for i, k := range first {
+			if k == key {
+				return i
+			}
+		}
+		return len(first)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		oi, oj := order(fields[i].Key), order(fields[j].Key)
+		if oi != oj {
+			return oi < oj
+		}
+		return fields[i].Key < fields[j].Key
+	})
+
+	return fields, nil
+}
@@ -0,0 +1,86 @@
+package blobformat
+
+import (
+	"strconv"
+	"time"
+)
+
+// Trash moves an entry to the trash. It's kept (and synced) but it's left out
+// of searches until it's restored or the trash is emptied.
+func (b Blobs) Trash(uuid string) {
+	b.touchUpdated(uuid)
+	b.DB.Set(uuid, KeyTrashed, strconv.FormatInt(time.Now().UnixNano(), 10))
+}
+
+// Restore takes an entry out of the trash, it returns ErrNameNotUnique if
+// another entry has taken its name since it was trashed.
+func (b Blobs) Restore(uuid string) error {
+	blob, err := b.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+
+	other, _, err := b.FindByName(blob.Name())
+	if err != nil {
+		return err
+	}
+	if len(other) != 0 {
+		return ErrNameNotUnique
+	}
+
+	b.touchUpdated(uuid)
+	b.DB.DeleteKey(uuid, KeyTrashed)
+	return nil
+}
+
+// Trashed returns the entries in the trash
+func (b Blobs) Trashed() (entries SearchResults, err error) {
+	if err = b.UpdateSnapshot(); err != nil {
+		return nil, err
+	}
+
+	for uuid, entry := range b.DB.Snapshot {
+		blob := Blob(entry)
+		if !blob.IsTrashed() {
+			continue
+		}
+
+		if entries == nil {
+			entries = make(SearchResults)
+		}
+		entries[uuid] = blob.Name()
+	}
+
+	return entries, nil
+}
+
+// PurgeTrash deletes the entries that were moved to the trash before the
+// unix nanosecond timestamp for good, it returns how many were deleted. None
+// are deleted if one of them can't be.
+func (b Blobs) PurgeTrash(before int64) (purged int, err error) {
+	trashed, err := b.Trashed()
+	if err != nil {
+		return 0, err
+	}
+
+	err = b.Do(func() error {
+		for uuid := range trashed {
+			when, err := Blob(b.DB.Snapshot[uuid]).Trashed()
+			if err != nil {
+				return err
+			}
+			if when.UnixNano() >= before {
+				continue
+			}
+
+			b.DB.Delete(uuid)
+			purged++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,163 @@
+package blobformat
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IsURLKey checks if the key is one of the urls of an entry: url, url2,
+// url3 and so on
+func IsURLKey(key string) bool {
+	_, ok := urlKeyIndex(key)
+	return ok
+}
+
+// urlKeyIndex is the number of a url key, url is 1
+func urlKeyIndex(key string) (int, bool) {
+	if !strings.HasPrefix(key, KeyURL) {
+		return 0, false
+	}
+	if key == KeyURL {
+		return 1, true
+	}
+
+	n, err := strconv.Atoi(key[len(KeyURL):])
+	if err != nil || n < 2 || strconv.Itoa(n) != key[len(KeyURL):] {
+		return 0, false
+	}
+	return n, true
+}
+
+// URLKeys are the url keys the entry has in order
+func (b Blob) URLKeys() []string {
+	var keys []string
+	for k := range b {
+		if IsURLKey(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, _ := urlKeyIndex(keys[i])
+		b, _ := urlKeyIndex(keys[j])
+		return a < b
+	})
+	return keys
+}
+
+// URLs are the values of the entry's url keys in order, they may be host
+// patterns (see MatchURL)
+func (b Blob) URLs() []string {
+	keys := b.URLKeys()
+	urls := make([]string, len(keys))
+	for i, k := range keys {
+		urls[i] = b[k]
+	}
+	return urls
+}
+
+// MatchesURL checks if one of the entry's urls matches rawurl
+func (b Blob) MatchesURL(rawurl string) bool {
+	for _, u := range b.URLs() {
+		if MatchURL(u, rawurl) {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchURL finds the entries with a url that matches rawurl, see MatchURL.
+// Entries in the trash are never returned.
+func (b Blobs) SearchURL(rawurl string) (entries SearchResults, err error) {
+	if len(urlHost(rawurl)) == 0 {
+		return nil, nil
+	}
+
+	for uuid, name := range b.allEntries() {
+		if IsSystemEntry(name) {
+			continue
+		}
+
+		entry, err := b.DB.Entry(uuid)
+		if err != nil {
+			return nil, err
+		}
+		if !Blob(entry).MatchesURL(rawurl) {
+			continue
+		}
+
+		if entries == nil {
+			entries = make(SearchResults)
+		}
+		entries[uuid] = name
+	}
+
+	return entries, nil
+}
+
+// MatchURL checks if the host of rawurl matches the host of pattern. Only
+// hosts are compared, the scheme, port and path are ignored. The pattern may
+// be a url or a host with wildcards: *.example.com matches example.com and
+// all of its subdomains, other wildcards are those of path.Match (eg.
+// login.example.*).
+func MatchURL(pattern, rawurl string) bool {
+	want, host := urlHost(pattern), urlHost(rawurl)
+	if len(want) == 0 || len(host) == 0 {
+		return false
+	}
+
+	if strings.HasPrefix(want, "*.") {
+		domain := want[2:]
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	}
+
+	ok, err := path.Match(want, host)
+	return err == nil && ok
+}
+
+// urlHost is the lowercased host of a url, the url may omit its scheme
+func urlHost(rawurl string) string {
+	rawurl = strings.TrimSpace(rawurl)
+	if !strings.Contains(rawurl, "://") {
+		rawurl = "scheme://" + rawurl
+	}
+
+	// Wildcards aren't valid in hosts so they're cut out by hand
+	rest := rawurl[strings.Index(rawurl, "://")+3:]
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		rest = rest[:i]
+	}
+	if i := strings.LastIndexByte(rest, '@'); i >= 0 {
+		rest = rest[i+1:]
+	}
+	if strings.ContainsRune(rest, '*') {
+		if i := strings.LastIndexByte(rest, ':'); i >= 0 {
+			rest = rest[:i]
+		}
+		return strings.ToLower(rest)
+	}
+
+	uri, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(uri.Hostname())
+}
+
+// validURLPattern checks a value is a url with a scheme or a host pattern
+func validURLPattern(value string) error {
+	if err := FieldURL.Validate(value); err == nil {
+		return nil
+	}
+
+	if strings.ContainsAny(value, " \t\n") || len(urlHost(value)) == 0 {
+		return errors.New("it must be a url like https://example.com or a host pattern like *.example.com")
+	}
+	if _, err := path.Match(urlHost(value), ""); err != nil {
+		return errors.New("the pattern is malformed")
+	}
+	return nil
+}
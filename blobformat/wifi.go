@@ -0,0 +1,61 @@
+package blobformat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// WifiSecurity is the authentication type of a wifi entry's security as
+// it's written in a WIFI: qr code: WPA (WPA and WPA2), SAE (WPA3), WEP or
+// nopass for open networks. Security that isn't set is WPA.
+func WifiSecurity(security string) (string, error) {
+	switch strings.ToUpper(strings.TrimSpace(security)) {
+	case "", "WPA", "WPA2", "WPA/WPA2", "WPA2-PSK", "WPA-PSK":
+		return "WPA", nil
+	case "WPA3", "SAE", "WPA3-SAE":
+		return "SAE", nil
+	case "WEP":
+		return "WEP", nil
+	case "NOPASS", "NONE", "OPEN":
+		return "nopass", nil
+	}
+
+	return "", fmt.Errorf("unknown security %q, it must be WPA, WPA2, WPA3, WEP or none", security)
+}
+
+// WifiQR is the payload of the qr code phones scan to join the network of a
+// wifi entry, eg. WIFI:T:WPA;S:home;P:secret;;
+func (b Blob) WifiQR() (string, error) {
+	ssid := b[KeySSID]
+	if len(ssid) == 0 {
+		return "", errors.New("ssid is not set")
+	}
+	security, err := WifiSecurity(b[KeySecurity])
+	if err != nil {
+		return "", err
+	}
+
+	payload := "WIFI:T:" + security + ";S:" + wifiEscape(ssid) + ";"
+	if security != "nopass" {
+		pass := b[KeyPassphrase]
+		if len(pass) == 0 {
+			return "", errors.New("passphrase is not set")
+		}
+		payload += "P:" + wifiEscape(pass) + ";"
+	}
+	return payload + ";", nil
+}
+
+// wifiEscape escapes the characters that are special in WIFI: qr codes
+func wifiEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\', ';', ',', ':', '"':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/aarondl/bpass/blobformat"
+)
+
+// cardFields are what addcard asks for
+var cardFields = []kindField{
+	{Key: blobformat.KeyNumber, Prompt: "number"},
+	{Key: blobformat.KeyExpiry, Prompt: "expiry (01/28)"},
+	{Key: blobformat.KeyCVV, Prompt: "cvv", Hidden: true, Optional: true},
+	{Key: blobformat.KeyCardholder, Prompt: "cardholder", Optional: true},
+	{Key: blobformat.KeyPIN, Prompt: "pin", Hidden: true, Optional: true},
+}
+
+// addCard is a wizard to add a payment card entry
+func (u *uiContext) addCard(name string) error {
+	uuid, err := u.addKindInterruptible(name, blobformat.KindCard, cardFields)
+	if err != nil || len(uuid) == 0 {
+		return err
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+
+	infoColor.Printf("added card %s\n", blobformat.MaskCardNumber(blob[blobformat.KeyNumber]))
+	return nil
+}
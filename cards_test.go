@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestCards(t *testing.T) {
+	t.Parallel()
+
+	out := new(bytes.Buffer)
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: out}
+	uuid, err := u.store.New("visa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.store.DB.Set(uuid, blobformat.KeyKind, blobformat.KindCard)
+
+	invalid := map[string]string{
+		blobformat.KeyNumber: "4111 1111 1111 1112",
+		blobformat.KeyExpiry: "13/28",
+	}
+	for key, value := range invalid {
+		if err = u.store.Set(uuid, key, value); !blobformat.IsInvalidValue(err) {
+			t.Errorf("%s %q should be invalid: %v", key, value, err)
+		}
+	}
+
+	valid := map[string]string{
+		blobformat.KeyNumber: "4111 1111 1111 1111",
+		blobformat.KeyExpiry: "01/2028",
+		blobformat.KeyCVV:    "123",
+	}
+	for key, value := range valid {
+		if err = u.store.Set(uuid, key, value); err != nil {
+			t.Errorf("%s %q should be valid: %v", key, value, err)
+		}
+	}
+
+	if err = u.show("visa", 0, false); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	if !strings.Contains(got, "************1111") || strings.Contains(got, "4111") {
+		t.Error("the number should be masked:", got)
+	}
+
+	// Numbers of other entries aren't cards
+	uuid, err = u.store.New("phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.store.Set(uuid, blobformat.KeyNumber, "555-1234"); err != nil {
+		t.Error("only card numbers are checked:", err)
+	}
+}
+
+func TestParseMonth(t *testing.T) {
+	t.Parallel()
+
+	for value, want := range map[string]string{"01/28": "2028-01", "12/2030": "2030-12"} {
+		got, err := blobformat.ParseMonth(value)
+		if err != nil || got.Format("2006-01") != want {
+			t.Errorf("%s) want: %s, got: %v %v", value, want, got, err)
+		}
+	}
+	for _, bad := range []string{"0/28", "1-28", "01/028", "01/"} {
+		if _, err := blobformat.ParseMonth(bad); err == nil {
+			t.Error("it should not parse:", bad)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+)
+
+const (
+	// certExpiryWarning is how far ahead of a certificate's expiry we start
+	// warning about it in audit
+	certExpiryWarning = 30 * 24 * time.Hour
+)
+
+// parseCert parses the first certificate out of a pem encoded value
+func parseCert(pemData string) (*x509.Certificate, error) {
+	rest := []byte(pemData)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, errors.New("no certificate found in pem data")
+		}
+
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// certSANs returns all the subject alternative names on the certificate
+func certSANs(cert *x509.Certificate) []string {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+
+	return sans
+}
+
+// certExpiry describes how long until the certificate expires
+func certExpiry(cert *x509.Certificate, now time.Time) string {
+	left := cert.NotAfter.Sub(now)
+	switch {
+	case left < 0:
+		return errColor.Sprint("expired")
+	case left < 24*time.Hour:
+		return errColor.Sprint("expires today")
+	case left < certExpiryWarning:
+		return errColor.Sprintf("expires in %d days", left/(24*time.Hour))
+	default:
+		return fmt.Sprintf("expires in %d days", left/(24*time.Hour))
+	}
+}
+
+// showCertInfo displays the parsed metadata from a certificate
+func showCertInfo(u *uiContext, pemData string, width, indent int) {
+	cert, err := parseCert(pemData)
+	if err != nil {
+		showKeyValue(u, "cn", errColor.Sprint("failed to parse certificate: ", err), width, indent)
+		return
+	}
+
+	showKeyValue(u, "cn", cert.Subject.CommonName, width, indent)
+	if sans := certSANs(cert); len(sans) != 0 {
+		showKeyValue(u, "sans", strings.Join(sans, ", "), width, indent)
+	}
+	showKeyValue(u, "issuer", cert.Issuer.CommonName, width, indent)
+	showKeyValue(u, "notafter", fmt.Sprintf("%s (%s)",
+		cert.NotAfter.Format(time.RFC3339), certExpiry(cert, time.Now())),
+		width, indent)
+}
+
+func (u *uiContext) addCertInterruptible(name string) error {
+	err := u.addCert(name)
+	switch err {
+	case nil:
+		return nil
+	case ErrEnd:
+		errColor.Println("Aborted")
+		return nil
+	default:
+		return err
+	}
+}
+
+// addCert is a wizard to add a certificate entry
+func (u *uiContext) addCert(name string) error {
+	return u.store.Do(func() error {
+		uuid, err := u.store.New(name)
+		if err != nil {
+			if err == blobformat.ErrNameNotUnique {
+				errColor.Printf("%q already exists\n", name)
+				return nil
+			}
+			return err
+		}
+
+		infoColor.Println("certificate (pem):")
+		cert, err := u.promptMultiline(promptColor.Sprint("> "))
+		if err != nil {
+			return err
+		}
+
+		parsed, err := parseCert(cert)
+		if err != nil {
+			errColor.Println("could not parse certificate:", err)
+			return ErrEnd
+		}
+
+		infoColor.Println("private key (pem, optional):")
+		key, err := u.promptMultiline(promptColor.Sprint("> "))
+		if err != nil {
+			return err
+		}
+
+		infoColor.Println("chain (pem, optional):")
+		chain, err := u.promptMultiline(promptColor.Sprint("> "))
+		if err != nil {
+			return err
+		}
+
+		// Use raw sets here to avoid creating history spam based on timestamp
+		// additions
+		u.store.DB.Set(uuid, blobformat.KeyKind, blobformat.KindCert)
+		u.store.DB.Set(uuid, blobformat.KeyCert, cert)
+		if len(key) != 0 {
+			u.store.DB.Set(uuid, blobformat.KeyPriv, key)
+		}
+		if len(chain) != 0 {
+			u.store.DB.Set(uuid, blobformat.KeyChain, chain)
+		}
+
+		infoColor.Printf("added certificate for %q (%s)\n",
+			parsed.Subject.CommonName, certExpiry(parsed, time.Now()))
+
+		return nil
+	})
+}
+
+// auditCerts returns warnings for all certificates that are expired or close
+// to expiring
+func (u *uiContext) auditCerts(now time.Time) ([]string, error) {
+	if err := u.store.UpdateSnapshot(); err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+	for _, entry := range u.store.Snapshot {
+		blob := blobformat.Blob(entry)
+		pemData, ok := blob[blobformat.KeyCert]
+		if !ok || blob.IsTrashed() {
+			continue
+		}
+
+		cert, err := parseCert(pemData)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: failed to parse certificate: %v", blob.Name(), err))
+			continue
+		}
+
+		if cert.NotAfter.Sub(now) < certExpiryWarning {
+			warnings = append(warnings, fmt.Sprintf("%s: certificate %s (%s)",
+				blob.Name(), certExpiry(cert, now), cert.NotAfter.Format("2006-01-02")))
+		}
+	}
+
+	return warnings, nil
+}
+
+// audit checks the file for things that need attention
+func (u *uiContext) audit() error {
+	now := time.Now()
+	warnings, err := u.auditCerts(now)
+	if err != nil {
+		return err
+	}
+
+	due, invalid := u.dueEntries(now, 0)
+	for _, d := range due {
+		warnings = append(warnings, fmt.Sprintf("%s: %s (%s)",
+			d.Name, dueIn(d.Expires, now), d.Expires.Format("2006-01-02")))
+	}
+	warnings = append(warnings, invalid...)
+
+	if len(warnings) == 0 {
+		infoColor.Println("nothing to report")
+		return nil
+	}
+
+	sort.Strings(warnings)
+	for _, w := range warnings {
+		errColor.Println(w)
+	}
+
+	return nil
+}
+
+// exportCert writes the certificate, key and chain of an entry to files in
+// dir. The private key is only readable by the current user and existing
+// files are only replaced if the user says so.
+func (u *uiContext) exportCert(search, dir string) error {
+	uuid, err := u.findOne(search)
+	if err != nil {
+		return err
+	}
+	if len(uuid) == 0 {
+		return nil
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+
+	cert := blob[blobformat.KeyCert]
+	if len(cert) == 0 {
+		errColor.Printf("%s has no %q key\n", blob.Name(), blobformat.KeyCert)
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	base := path.Base(blob.Name())
+	files := []struct {
+		Key  string
+		Ext  string
+		Mode os.FileMode
+	}{
+		{Key: blobformat.KeyCert, Ext: ".crt", Mode: 0644},
+		{Key: blobformat.KeyChain, Ext: ".chain.crt", Mode: 0644},
+		{Key: blobformat.KeyPriv, Ext: ".key", Mode: 0600},
+	}
+
+	for _, f := range files {
+		val := blob[f.Key]
+		if len(val) == 0 {
+			continue
+		}
+		if !strings.HasSuffix(val, "\n") {
+			val += "\n"
+		}
+
+		filename := filepath.Join(dir, base+f.Ext)
+		wrote, err := u.writeNewFile(filename, []byte(val), f.Mode)
+		if err != nil {
+			return err
+		}
+		if wrote {
+			infoColor.Println("wrote:", filename)
+		}
+	}
+
+	return nil
+}
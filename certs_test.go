@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+// testCert makes a self-signed pem certificate for cn that's valid until
+// notAfter
+func testCert(t *testing.T, cn string, notAfter time.Time) string {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uri, err := url.Parse("spiffe://example.com/web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(1),
+		Subject:        pkix.Name{CommonName: cn},
+		NotBefore:      notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:       notAfter,
+		DNSNames:       []string{cn, "www." + cn},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		EmailAddresses: []string{"admin@" + cn},
+		URIs:           []*url.URL{uri},
+	}
+
+	der, err := x509.CreateCertificate(nil, template, template, pub, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestParseCert(t *testing.T) {
+	t.Parallel()
+
+	cert := testCert(t, "example.com", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	key := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("not a key")}))
+
+	tests := []struct {
+		Name string
+		PEM  string
+		Err  bool
+	}{
+		{Name: "cert", PEM: cert},
+		{Name: "key first", PEM: key + cert},
+		{Name: "key only", PEM: key, Err: true},
+		{Name: "not pem", PEM: "hunter2", Err: true},
+		{Name: "empty", PEM: "", Err: true},
+	}
+
+	for _, test := range tests {
+		parsed, err := parseCert(test.PEM)
+		if test.Err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.Name, err)
+			continue
+		}
+		if parsed.Subject.CommonName != "example.com" {
+			t.Errorf("%s: wrong cn: %s", test.Name, parsed.Subject.CommonName)
+		}
+	}
+}
+
+func TestCertSANs(t *testing.T) {
+	t.Parallel()
+
+	cert, err := parseCert(testCert(t, "example.com", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"example.com",
+		"www.example.com",
+		"10.0.0.1",
+		"admin@example.com",
+		"spiffe://example.com/web",
+	}
+	if got := certSANs(cert); !reflect.DeepEqual(got, want) {
+		t.Errorf("sans were wrong: %q", got)
+	}
+}
+
+func TestAuditCerts(t *testing.T) {
+	t.Parallel()
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: ioutil.Discard}
+	now := time.Date(2026, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		Name    string
+		PEM     string
+		Trashed bool
+		Warning string
+	}{
+		{Name: "expired", PEM: testCert(t, "a.com", now.Add(-time.Hour)), Warning: "expired: certificate " + errColor.Sprint("expired") + " (2026-06-15)"},
+		{Name: "today", PEM: testCert(t, "b.com", now.Add(time.Hour)), Warning: "today: certificate " + errColor.Sprint("expires today") + " (2026-06-15)"},
+		{Name: "expiring", PEM: testCert(t, "c.com", now.Add(10*24*time.Hour)), Warning: "expiring: certificate " + errColor.Sprint("expires in 10 days") + " (2026-06-25)"},
+		{Name: "fine", PEM: testCert(t, "d.com", now.Add(90*24*time.Hour))},
+		{Name: "broken", PEM: "hunter2", Warning: "broken: failed to parse certificate: no certificate found in pem data"},
+		{Name: "trashed", PEM: testCert(t, "e.com", now.Add(-time.Hour)), Trashed: true},
+	}
+
+	var want []string
+	for _, test := range tests {
+		uuid, err := u.store.New(test.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u.store.DB.Set(uuid, blobformat.KeyCert, test.PEM)
+		if test.Trashed {
+			u.store.Trash(uuid)
+		}
+		if len(test.Warning) != 0 {
+			want = append(want, test.Warning)
+		}
+	}
+
+	warnings, err := u.auditCerts(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(want)
+	sort.Strings(warnings)
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings were wrong:\n%s", strings.Join(warnings, "\n"))
+	}
+}
@@ -20,14 +20,38 @@ var (
 	flagNoColor     bool
 	flagNoClearClip bool
 	flagNoAutoSync  bool
+	flagAutoSync    time.Duration
 	flagTime        string
 	flagFile        string
+	flagKDF         string
+	flagCipher      string
+	flagKeyfile     string
+	flagNoKeyCache  bool
+	flagFormat      string
+
+	flagQuery  string
+	flagKey    string
+	flagReveal bool
+
+	flagHotkeyPick bool
+	flagHotkeyType bool
+
+	flagServeSyncListen string
+	flagServeSyncDir    string
+	flagServeSyncCert   string
+	flagServeSyncKey    string
 )
 
 var (
 	versionCmd     = flaggy.NewSubcommand("version")
 	genCmd         = flaggy.NewSubcommand("gen")
 	lpassImportCmd = flaggy.NewSubcommand("lpassimport")
+	hotkeydCmd     = flaggy.NewSubcommand("hotkeyd")
+	serveSyncCmd   = flaggy.NewSubcommand("serve-sync")
+	migrateCmd     = flaggy.NewSubcommand("migrate")
+	lsCmd          = flaggy.NewSubcommand("ls")
+	showCmd        = flaggy.NewSubcommand("show")
+	getCmd         = flaggy.NewSubcommand("get")
 )
 
 func parseCli() {
@@ -37,20 +61,49 @@ func parseCli() {
 		defaultFilePath = filepath.Join(homeDir, defaultFilePath)
 	}
 	flagFile = defaultFilePath
+	flagServeSyncListen = ":8484"
+	flagServeSyncDir = "bpass-sync"
+	if err == nil && len(homeDir) != 0 {
+		flagServeSyncDir = filepath.Join(homeDir, ".bpass-sync")
+	}
 
 	parser := flaggy.NewParser("bpass")
 	parser.Bool(&flagNoColor, "", "no-color", "Turn off color output")
 	parser.Bool(&flagNoAutoSync, "", "no-sync", "Do not sync the file automatically")
+	parser.Duration(&flagAutoSync, "", "auto-sync", "Sync in the background on an interval while the repl is open (eg. 10m)")
 	parser.Bool(&flagNoClearClip, "", "no-clear-clip", "Do not clear clipboard on exit")
 	parser.Bool(&flagHelp, "h", "help", "Show help")
 	parser.String(&flagTime, "t", "time", "Open the file read-only at a time in the past (YYYY-MM-DD HH:mm:ss)")
 	parser.String(&flagFile, "f", "file", "The file to open (can be set by $BPASS)")
+	parser.String(&flagKeyfile, "k", "keyfile", "The keyfile to open the file with (can be set by $BPASS_KEYFILE)")
+	parser.Bool(&flagNoKeyCache, "", "no-keycache", "Ask for the passphrase even if the key is cached and forget the cached key (see the keycache setting)")
+	parser.String(&flagKDF, "", "kdf", "Key derivation when creating or migrating a file: argon2id or scrypt (optionally with params, eg. scrypt,n=524288,r=8,p=1)")
+	parser.String(&flagCipher, "", "cipher", "Cipher when creating or migrating a file: cascade or chacha20poly1305")
+	parser.String(&flagFormat, "", "format", "Write ls, show and get as json or tsv for other programs (text by default)")
 
 	versionCmd.Description = "print version and exit"
 	lpassImportCmd.Description = "import lastpass csv by running `lpass export`"
 	genCmd.Description = "generate a password"
-
-	parser.AdditionalHelpAppend = "bpass respects $BPASS, $EDITOR, $PINENTRY env vars\n$PINENTRY can be set to none to prevent it from using pinentry"
+	hotkeydCmd.Description = "keep the file open in the background for a global shortcut to pick from"
+	hotkeydCmd.Bool(&flagHotkeyPick, "", "pick", "Ask the running hotkeyd to show the picker (bind this to a shortcut)")
+	hotkeydCmd.Bool(&flagHotkeyType, "", "type", "Type the password instead of copying it (use with --pick)")
+	serveSyncCmd.Description = "serve files for other bpass instances to sync with over http(s)"
+	migrateCmd.Description = "rewrite the file in the newest format with the kdf and cipher settings (or --kdf and --cipher), keeping a backup"
+	serveSyncCmd.String(&flagServeSyncListen, "l", "listen", "Address to listen on")
+	serveSyncCmd.String(&flagServeSyncDir, "d", "dir", "Directory the synced files are kept in")
+	serveSyncCmd.String(&flagServeSyncCert, "", "tls-cert", "Certificate file to serve https with")
+	serveSyncCmd.String(&flagServeSyncKey, "", "tls-key", "Key file for --tls-cert")
+	lsCmd.Description = "list the entries (matching a query) and exit"
+	lsCmd.AddPositionalValue(&flagQuery, "query", 1, false, "Restrict the entries to a fuzzy match")
+	showCmd.Description = "show an entry and exit"
+	showCmd.AddPositionalValue(&flagQuery, "query", 1, true, "The entry to show")
+	showCmd.Bool(&flagReveal, "", "reveal", "Write the values show hides with --format instead of masking them")
+	getCmd.Description = "print a key of an entry and exit"
+	getCmd.AddPositionalValue(&flagQuery, "query", 1, true, "The entry to get the key of")
+	getCmd.AddPositionalValue(&flagKey, "key", 2, true, "The key to print")
+	getCmd.Bool(&flagReveal, "", "reveal", "Print the key even if it's secret")
+
+	parser.AdditionalHelpAppend = "bpass respects $BPASS, $EDITOR, $PINENTRY env vars\n$PINENTRY can be set to none to prevent it from using pinentry\nserve-sync requires $BPASS_SYNC_TOKEN"
 
 	parser.ShowHelpWithHFlag = false
 	parser.ShowHelpOnUnexpected = false
@@ -65,6 +118,12 @@ func parseCli() {
 	parser.AttachSubcommand(versionCmd, 1)
 	parser.AttachSubcommand(genCmd, 1)
 	parser.AttachSubcommand(lpassImportCmd, 1)
+	parser.AttachSubcommand(hotkeydCmd, 1)
+	parser.AttachSubcommand(serveSyncCmd, 1)
+	parser.AttachSubcommand(migrateCmd, 1)
+	parser.AttachSubcommand(lsCmd, 1)
+	parser.AttachSubcommand(showCmd, 1)
+	parser.AttachSubcommand(getCmd, 1)
 	parser.Parse()
 
 	if flagFile == defaultFilePath {
@@ -82,6 +141,11 @@ func parseCli() {
 		}
 	}
 
+	if err := checkFormat(flagFormat); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	if flagHelp {
 		parser.ShowHelp()
 		os.Exit(0)
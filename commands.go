@@ -2,13 +2,8 @@ package main
 
 import (
 	"bytes"
-	"crypto/ed25519"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
-	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -27,7 +22,6 @@ import (
 	"github.com/aarondl/bpass/blobformat"
 	"github.com/aarondl/bpass/crypt"
 	"github.com/aarondl/bpass/osutil"
-	"golang.org/x/crypto/ssh"
 
 	"github.com/aarondl/color"
 	"github.com/atotto/clipboard"
@@ -44,22 +38,56 @@ var (
 )
 
 const (
-	syncSCP  = "scp"
-	syncFile = "file"
+	// kdfTuneTarget is how long passwd --tune aims for a key derivation to
+	// take, kdfTuneMaxMemory is the most it will use (in KiB)
+	kdfTuneTarget    = time.Second
+	kdfTuneMaxMemory = 1024 * 1024
+
+	// recoveryCodeLen is the length of codes made by adduser --recovery
+	recoveryCodeLen = 32
+)
+
+const (
+	syncSCP   = "scp"
+	syncFile  = "file"
+	syncHTTP  = "http"
+	syncHTTPS = "https"
 )
 
-func (u *uiContext) passwd(user string) error {
+// keepFactor is passed to passwd to keep the key's current second factor
+const keepFactor = -1
+
+// passwd changes the passphrase, factor is the crypt.Factor the new key
+// needs or keepFactor
+func (u *uiContext) passwd(user string, factor int) error {
+	current := u.keyFactor()
+	newFactor := current
+	if factor != keepFactor {
+		newFactor = crypt.Factor(factor)
+	}
+
+	if newFactor != current {
+		if newFactor != crypt.FactorNone && u.master.Len() != 0 && u.keyVersion() == 1 {
+			errColor.Println("this multi-user file uses an older key format, rekeyall is needed first")
+			return nil
+		}
+		if newFactor == crypt.FactorFIDO2 {
+			if err := u.fido2Enroll(); err != nil {
+				return err
+			}
+		}
+	}
+
 	pass, err := u.getPassword()
 	if err != nil {
 		return err
 	}
 
-	if len(pass) == 0 {
-		errColor.Println("refusing to use empty password")
-		return nil
+	if ok, err := u.allowEmptyPassword(pass, newFactor); err != nil || !ok {
+		return err
 	}
 
-	key, salt, err := crypt.DeriveKey(cryptVersion, []byte(pass))
+	version, key, salt, err := u.deriveKey(pass, newFactor)
 	if err != nil {
 		return err
 	}
@@ -67,18 +95,19 @@ func (u *uiContext) passwd(user string) error {
 	// Update our "fast-path" credentials if we're re-doing the current user
 	if len(u.user) == 0 || u.user == user {
 		u.pass = pass
-		u.key = key
+		u.setKey(key)
 		u.salt = salt
+		u.keyFromCache = false
 	}
 
 	// We have to update the user entry if it's a multi-user file
-	if len(u.master) != 0 {
+	if u.master.Len() != 0 {
 		uuid, _, err := u.store.MustFindUser(u.user)
 		if err != nil {
 			return err
 		}
 
-		mkey, iv, err := crypt.EncryptMasterKey(cryptVersion, key, u.master)
+		mkey, iv, err := crypt.EncryptMasterKey(version, key, u.master.Bytes())
 		if err != nil {
 			return err
 		}
@@ -88,11 +117,65 @@ func (u *uiContext) passwd(user string) error {
 		u.store.DB.Set(uuid, blobformat.KeyMKey, hex.EncodeToString(mkey))
 	}
 
+	switch {
+	case newFactor == current:
+	case newFactor == crypt.FactorNone:
+		infoColor.Printf("the %s is no longer needed to open the file\n", factorName(current))
+	default:
+		infoColor.Printf("the %s will be needed to open the file from now on\n", factorName(newFactor))
+	}
 	infoColor.Println("passphrase updated, bits will be re-encrypted with it on exit")
 	return nil
 }
 
-func (u *uiContext) adduser(user string) error {
+// allowEmptyPassword checks if pass can be used, an empty one is only
+// allowed when a second factor replaces it
+func (u *uiContext) allowEmptyPassword(pass string, factor crypt.Factor) (bool, error) {
+	if len(pass) != 0 {
+		return true, nil
+	}
+
+	if factor == crypt.FactorNone {
+		errColor.Println("refusing to use empty password")
+		return false, nil
+	}
+
+	return u.getYesNo(fmt.Sprintf("open the file with only the %s and no passphrase?", factorName(factor)))
+}
+
+// Kinds of users for adduser
+const (
+	addUserPassphrase = iota
+	addUserRecovery
+	addUserFIDO2
+)
+
+// adduser adds a user to the file. Recovery users get a generated code
+// instead of a passphrase so that one can be kept somewhere safe (printed,
+// in a safe deposit box) to open the file if everyone's passphrase is lost.
+// FIDO2 users open the file with only a fido2 authenticator, adding one for
+// each authenticator means any of them can be used.
+func (u *uiContext) adduser(user string, kind int) error {
+	if kind != addUserPassphrase && u.master.Len() == 0 {
+		errColor.Println("add yourself first (adduser <you>), this user is added as another user")
+		return nil
+	}
+	if kind == addUserFIDO2 {
+		if u.keyVersion() == 1 {
+			errColor.Println("this multi-user file uses an older key format, rekeyall is needed first")
+			return nil
+		}
+		if err := u.fido2Enroll(); err != nil {
+			return err
+		}
+	}
+
+	if u.master.Len() == 0 {
+		if ok, err := u.confirmDropSlot("a multi-user file"); err != nil || !ok {
+			return err
+		}
+	}
+
 	uuid, err := u.store.NewUser(user)
 	if err == blobformat.ErrNameNotUnique {
 		errColor.Println("user already exists")
@@ -103,28 +186,66 @@ func (u *uiContext) adduser(user string) error {
 
 	var key, salt []byte
 	var pass string
-	if len(u.master) == 0 {
-		u.master, u.ivm, err = crypt.NewMasterKey(cryptVersion)
+	version := u.keyVersion()
+	if u.master.Len() == 0 {
+		var master []byte
+		master, u.ivm, err = crypt.NewMasterKey(version)
+		u.setMaster(master)
 		if err != nil {
 			return nil
 		}
 
 		u.user = user
-		key = u.key
+		key = u.key.Bytes()
 		salt = u.salt
+	} else if kind == addUserFIDO2 {
+		version, key, salt, err = u.deriveKey("", crypt.FactorFIDO2)
+		if err != nil {
+			return err
+		}
+	} else if kind == addUserRecovery {
+		pass, err = genPassword(recoveryCodeLen, 0, 0, 0, 0, 0)
+		if err != nil {
+			return err
+		}
+
+		key, salt, err = crypt.DeriveKeyParams(version, []byte(pass), recoveryKDFParams(version))
+		if err != nil {
+			return err
+		}
 	} else {
 		pass, err = u.getPassword()
 		if err != nil {
 			return err
 		}
 
-		key, salt, err = crypt.DeriveKey(cryptVersion, []byte(pass))
+		version, key, salt, err = u.deriveKey(pass, crypt.FactorNone)
 		if err != nil {
 			return err
 		}
 	}
 
-	mkey, iv, err := crypt.EncryptMasterKey(cryptVersion, key, u.master)
+	if err = u.setUserKey(uuid, version, key, salt); err != nil {
+		return err
+	}
+
+	if kind == addUserFIDO2 {
+		infoColor.Printf("added user %s, it opens the file with only this fido2 authenticator\n", user)
+	} else if len(pass) == 0 {
+		infoColor.Printf("re-used your key to create first user: %s\n", user)
+	} else if kind == addUserRecovery {
+		infoColor.Printf("added recovery user %s, keep this code somewhere safe, it opens the file as %s:\n", user, user)
+		fmt.Fprintln(u.out, passColor.Sprint(pass))
+	} else {
+		infoColor.Printf("added user %s\npass: %s\n", user, pass)
+	}
+
+	return nil
+}
+
+// setUserKey stores the master key encrypted with the user's key
+func (u *uiContext) setUserKey(uuid string, version int, key, salt []byte) error {
+	mkey, iv, err := crypt.EncryptMasterKey(version, key, u.master.Bytes())
 	if err != nil {
 		return err
 	}
@@ -132,14 +253,17 @@ func (u *uiContext) adduser(user string) error {
 	u.store.DB.Set(uuid, blobformat.KeySalt, hex.EncodeToString(salt))
 	u.store.DB.Set(uuid, blobformat.KeyIV, hex.EncodeToString(iv))
 	u.store.DB.Set(uuid, blobformat.KeyMKey, hex.EncodeToString(mkey))
+	return nil
+}
 
-	if len(pass) == 0 {
-		infoColor.Printf("re-used your key to create first user: %s\n", user)
-	} else {
-		infoColor.Printf("added user %s\npass: %s\n", user, pass)
+// recoveryKDFParams are the cheapest params for a version, recovery codes
+// are long and random so they don't need a slow kdf to be hard to guess
+func recoveryKDFParams(version int) crypt.KDFParams {
+	if version == crypt.DefaultScryptParams.Version() {
+		return crypt.KDFParams{Algorithm: crypt.KDFScrypt, N: 1 << 14, R: 8, P: 1}
 	}
 
-	return nil
+	return crypt.KDFParams{Algorithm: crypt.KDFArgon2id, Time: 1, Memory: 8 * 1024, Threads: 1}
 }
 
 func (u *uiContext) rekey(user string) error {
@@ -148,7 +272,9 @@ func (u *uiContext) rekey(user string) error {
 	var pass string
 	var err error
 	if isCurrentUser {
-		pass = u.pass
+		if pass, err = u.currentPass(); err != nil {
+			return err
+		}
 	} else {
 		infoColor.Println("in order to rekey this user we need a new password")
 		pass, err = u.getPassword()
@@ -157,12 +283,16 @@ func (u *uiContext) rekey(user string) error {
 		}
 	}
 
-	if len(pass) == 0 {
-		errColor.Println("refusing to use empty password")
-		return nil
+	factor := crypt.FactorNone
+	if isCurrentUser {
+		factor = u.keyFactor()
+	}
+
+	if ok, err := u.allowEmptyPassword(pass, factor); err != nil || !ok {
+		return err
 	}
 
-	key, salt, err := crypt.DeriveKey(cryptVersion, []byte(pass))
+	version, key, salt, err := u.deriveKey(pass, factor)
 	if err != nil {
 		return err
 	}
@@ -170,11 +300,11 @@ func (u *uiContext) rekey(user string) error {
 	if isCurrentUser {
 		// Update fast-path credentials
 		u.pass = pass
-		u.key = key
+		u.setKey(key)
 		u.salt = salt
 	}
 
-	if len(u.master) != 0 {
+	if u.master.Len() != 0 {
 		// If we're multi-user we need to update the corresponding user entry
 		username := u.user
 		if len(user) != 0 {
@@ -186,7 +316,7 @@ func (u *uiContext) rekey(user string) error {
 			return err
 		}
 
-		mkey, iv, err := crypt.EncryptMasterKey(cryptVersion, key, u.master)
+		mkey, iv, err := crypt.EncryptMasterKey(version, key, u.master.Bytes())
 		if err != nil {
 			return err
 		}
@@ -196,32 +326,95 @@ func (u *uiContext) rekey(user string) error {
 		u.store.DB.Set(uuid, blobformat.KeyMKey, hex.EncodeToString(mkey))
 	}
 
+	what := "key"
+	if !isCurrentUser {
+		what = "key for " + user
+	}
+	if err = u.recordRekey(what); err != nil {
+		return err
+	}
+
 	infoColor.Println("key updated, bits will be re-encrypted with it on exit")
 	return nil
 }
 
-var rekeyAllBlurb = `WARNING: This will change ALL user's passwords and print new
-ones to the screen. No one will be able to access the file with the old
-passwords again after this operation.
-`
+// rekeyMaster changes the key the file's contents are encrypted with
+// without changing the current user's passphrase, so that someone with an
+// old copy of the file and its key can't open the new one. In a multi-user
+// file that's the master key, every other user gets a new passphrase since
+// the master key can only be encrypted for them with their key.
+func (u *uiContext) rekeyMaster() error {
+	if u.master.Len() == 0 {
+		// The key is derived from the salt so changing it is enough
+		return u.rekey("")
+	}
 
-func (u *uiContext) rekeyAll() error {
-	if len(u.master) == 0 {
-		infoColor.Println("this command does nothing for a single user file, see passwd/rekey")
-		return nil
+	users, err := u.store.Users()
+	if err != nil {
+		return err
+	}
+	if len(users) > 1 {
+		infoColor.Println("the other users of this file will be given new passphrases")
+		ok, err := u.getYesNo("rekey the master key?")
+		if err != nil || !ok {
+			return err
+		}
+	}
+
+	return u.rekeyAll(true)
+}
+
+// recordRekey adds a line to the file's rekey history so it's clear which
+// copies of the file (eg. old backups) are protected by which keys
+func (u *uiContext) recordRekey(what string) error {
+	history, err := u.store.Setting(blobformat.SettingRekeyHistory)
+	if err != nil {
+		return err
+	}
+
+	line := time.Now().UTC().Format(time.RFC3339) + " " + what
+	if len(u.user) != 0 {
+		line += " (by " + u.user + ")"
+	}
+	if len(history) != 0 {
+		history += "\n"
 	}
 
-	errColor.Println(rekeyAllBlurb)
-	yes, err := u.getYesNo("are you sure you wish to proceed?")
+	return u.store.SetSetting(blobformat.SettingRekeyHistory, history+line)
+}
+
+// rekeyHistory prints the rekeys recorded by recordRekey
+func (u *uiContext) rekeyHistory() error {
+	history, err := u.store.Setting(blobformat.SettingRekeyHistory)
 	if err != nil {
 		return err
 	}
+	if len(history) == 0 {
+		infoColor.Println("this file has not been rekeyed")
+		return nil
+	}
 
-	if !yes {
+	fmt.Println(history)
+	return nil
+}
+
+var rekeyAllBlurb = `WARNING: This will change ALL user's passwords and print new
+ones to the screen. No one will be able to access the file with the old
+passwords again after this operation.
+`
+
+// rekeyAll changes the master key and every user's passphrase, with
+// keepCurrent the current user keeps theirs (and their second factor)
+func (u *uiContext) rekeyAll(keepCurrent bool) error {
+	if u.master.Len() == 0 {
+		infoColor.Println("this command does nothing for a single user file, see passwd/rekey")
 		return nil
 	}
 
-	master, ivm, err := crypt.NewMasterKey(cryptVersion)
+	// Every user is being rekeyed so the file can move to the version of
+	// the kdf setting
+	kdf := u.kdfParams()
+	master, ivm, err := crypt.NewMasterKey(kdf.Version())
 	if err != nil {
 		return err
 	}
@@ -231,6 +424,13 @@ func (u *uiContext) rekeyAll() error {
 		return err
 	}
 
+	currentPass := u.pass
+	if keepCurrent {
+		if currentPass, err = u.currentPass(); err != nil {
+			return err
+		}
+	}
+
 	var width int
 	for _, name := range users {
 		username := blobformat.SplitUsername(name)
@@ -239,45 +439,107 @@ func (u *uiContext) rekeyAll() error {
 		}
 	}
 
-	for uuid, name := range users {
-		username := blobformat.SplitUsername(name)
+	// Every user is rekeyed or none are, the ones that were would be locked
+	// out of the file otherwise
+	var selfPass string
+	var selfKey, selfSalt []byte
+	newPasses := make(map[string]string)
+	err = u.store.Do(func() error {
+		for uuid, name := range users {
+			username := blobformat.SplitUsername(name)
+			keep := keepCurrent && username == u.user
 
-		pass, err := genPassword(32, 0, 0, 0, 0, 0)
-		if err != nil {
-			return err
-		}
+			userKDF := kdf
+			pass := currentPass
+			if keep {
+				userKDF.Factor = u.keyFactor()
+			} else if pass, err = genPassword(32, 0, 0, 0, 0, 0); err != nil {
+				return err
+			}
 
-		key, salt, err := crypt.DeriveKey(cryptVersion, []byte(pass))
-		if err != nil {
-			return err
-		}
+			key, salt, err := crypt.DeriveKeyParams(kdf.Version(), []byte(pass), userKDF)
+			if err != nil {
+				return err
+			}
 
-		if username == u.user {
-			// Keep these up to date!
-			u.pass = pass
-			u.key = key
-			u.salt = salt
-		}
+			if username == u.user {
+				selfPass, selfKey, selfSalt = pass, key, salt
+			}
 
-		mkey, iv, err := crypt.EncryptMasterKey(cryptVersion, key, u.master)
-		if err != nil {
-			return err
-		}
+			mkey, iv, err := crypt.EncryptMasterKey(kdf.Version(), key, master)
+			if err != nil {
+				return err
+			}
 
-		u.store.DB.Set(uuid, blobformat.KeySalt, hex.EncodeToString(salt))
-		u.store.DB.Set(uuid, blobformat.KeyIV, hex.EncodeToString(iv))
-		u.store.DB.Set(uuid, blobformat.KeyMKey, hex.EncodeToString(mkey))
+			u.store.DB.Set(uuid, blobformat.KeySalt, hex.EncodeToString(salt))
+			u.store.DB.Set(uuid, blobformat.KeyIV, hex.EncodeToString(iv))
+			u.store.DB.Set(uuid, blobformat.KeyMKey, hex.EncodeToString(mkey))
+
+			if !keep {
+				newPasses[username] = pass
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		infoColor.Printf("%*s %s\n", width, username+":", pass)
+	if selfKey != nil {
+		// Keep these up to date!
+		u.pass = selfPass
+		u.setKey(selfKey)
+		u.salt = selfSalt
+		u.keyFromCache = false
 	}
 
-	u.master = master
+	u.setMaster(master)
 	u.ivm = ivm
 
+	names := make([]string, 0, len(newPasses))
+	for username := range newPasses {
+		names = append(names, username)
+	}
+	sort.Strings(names)
+	for _, username := range names {
+		fmt.Fprintf(u.out, "%s %s\n", keyColor.Sprintf("%*s", width, username+":"), passColor.Sprint(newPasses[username]))
+	}
+
+	if err = u.recordRekey("master key"); err != nil {
+		return err
+	}
+
 	infoColor.Println("master key updated, all users have been rekeyed")
 	return nil
 }
 
+// tuneKDF measures key derivation on this machine and saves params that
+// take about a second as the kdf setting
+func (u *uiContext) tuneKDF() error {
+	infoColor.Println("measuring key derivation on this machine, this can take a few seconds")
+
+	kdf, err := crypt.TuneKDF(u.kdfParams().Algorithm, kdfTuneTarget, kdfTuneMaxMemory)
+	if err != nil {
+		return err
+	}
+
+	if err = u.store.SetSetting(blobformat.SettingKDF, kdf.String()); err != nil {
+		return err
+	}
+
+	if kdf.Algorithm == crypt.KDFScrypt {
+		infoColor.Printf("set %s to %s (%d MiB)\n", blobformat.SettingKDF, kdf, kdf.N*uint32(kdf.R)/8/1024)
+	} else {
+		infoColor.Printf("set %s to %s (%d iterations, %d MiB, %d threads)\n",
+			blobformat.SettingKDF, kdf, kdf.Time, kdf.Memory/1024, kdf.Threads)
+	}
+	if u.master.Len() != 0 && u.keyVersion() != kdf.Version() {
+		infoColor.Println("this multi-user file uses an older key format, rekeyall is needed to use them")
+	}
+
+	return nil
+}
+
 func (u *uiContext) addSyncInterruptible(kind string) error {
 	err := u.addSync(kind)
 	switch err {
@@ -293,7 +555,7 @@ func (u *uiContext) addSyncInterruptible(kind string) error {
 
 func (u *uiContext) addSync(kind string) error {
 	found := false
-	for _, k := range []string{syncSCP, syncFile} {
+	for _, k := range []string{syncSCP, syncFile, syncHTTP, syncHTTPS} {
 		if k == kind {
 			found = true
 			break
@@ -334,6 +596,10 @@ func (u *uiContext) addSync(kind string) error {
 			if uri, err = addSCPEntry(u, uuid); err != nil {
 				return err
 			}
+		case syncHTTP, syncHTTPS:
+			if uri, err = addHTTPEntry(u, uuid, kind); err != nil {
+				return err
+			}
 		}
 
 		// Use raw-er sets to avoid timestamp spam
@@ -352,6 +618,37 @@ func (u *uiContext) addSync(kind string) error {
 	})
 }
 
+// addHTTPEntry asks for the details of a serve-sync server
+func addHTTPEntry(u *uiContext, uuid, kind string) (uri url.URL, err error) {
+	for {
+		addr, err := u.getString("url")
+		if err != nil {
+			return uri, err
+		}
+
+		parsed, err := url.Parse(addr)
+		if err != nil || parsed.Scheme != kind || len(parsed.Host) == 0 || len(parsed.Path) <= 1 {
+			errColor.Printf("url must look like %s://host:8484/filename.blob\n", kind)
+			continue
+		}
+
+		uri = *parsed
+		break
+	}
+
+	if kind == syncHTTP {
+		errColor.Println("not using https, the token can be seen by anyone on the network")
+	}
+
+	token, err := u.promptPassword(promptColor.Sprint("token ($BPASS_SYNC_TOKEN of the server): "))
+	if err != nil {
+		return uri, err
+	}
+	u.store.DB.Set(uuid, blobformat.KeyPass, token)
+
+	return uri, nil
+}
+
 func addSCPEntry(u *uiContext, uuid string) (uri url.URL, err error) {
 	user, err := u.getString("user")
 	if err != nil {
@@ -394,73 +691,58 @@ func addSCPEntry(u *uiContext, uuid string) (uri url.URL, err error) {
 	uri.Host = net.JoinHostPort(host, port)
 	uri.Path = file
 
+	tailscale, err := u.getYesNo("resolve host via tailscale when available?")
+	if err != nil {
+		return uri, err
+	}
+	if tailscale {
+		u.store.DB.Set(uuid, blobformat.KeyTailscale, "true")
+	}
+
 	promptColor.Println("Key type:")
-	choice, err := u.getMenuChoice(promptColor.Sprint("> "), []string{"ED25519", "RSA 4096", "Password"})
+	choice, err := u.getMenuChoice(promptColor.Sprint("> "), []string{"ED25519", "RSA 4096", "Password", "SSH agent", "ED25519-SK (security key)"})
 	if err != nil {
 		return uri, err
 	}
 
 	switch choice {
-	case 0:
-		pub, priv, err := ed25519.GenerateKey(rand.Reader)
-		if err != nil {
-			errColor.Println("failed to generate ed25519 ssh key")
-			return uri, nil
-		}
-
-		// Marshal private key into DER ASN.1 then to PEM
-		b, err := x509.MarshalPKCS8PrivateKey(priv)
-		if err != nil {
-			errColor.Println("failed to marshal ed25519 private key with x509:", err)
+	case 0, 1:
+		kind := sshKeyED25519
+		if choice == 1 {
+			kind = sshKeyRSA
 		}
-		pemBlock := pem.Block{Type: "PRIVATE KEY", Bytes: b}
-		b = pem.EncodeToMemory(&pemBlock)
-
-		public, err := ssh.NewPublicKey(pub)
+		priv, publicStr, err := generateSSHKey(kind, "@bpass")
 		if err != nil {
-			errColor.Println("failed to parse public key:", err)
+			errColor.Println(err)
+			return uri, nil
 		}
-		publicStr := string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(public))) + " @bpass"
 
-		u.store.Set(uuid, blobformat.KeyPriv, string(bytes.TrimSpace(b)))
+		u.store.Set(uuid, blobformat.KeyPriv, priv)
 		u.store.Set(uuid, blobformat.KeyPub, publicStr)
 
-		infoColor.Printf("successfully generated new ed25519 key:\n%s\n", publicStr)
+		infoColor.Printf("successfully generated new %s key:\n%s\n", kind, publicStr)
 
-	case 1:
-		priv, err := rsa.GenerateKey(rand.Reader, 4096)
+	case 2:
+		pass, err := u.getPassword()
 		if err != nil {
-			errColor.Println("failed to generate rsa-4096 ssh key")
-			return uri, nil
+			return uri, err
 		}
 
-		// Marshal private key into DER ASN.1 then to PEM
-		b, err := x509.MarshalPKCS8PrivateKey(priv)
+		uri.User = url.UserPassword(user, pass)
+	case 3:
+		infoColor.Println("keys from the ssh-agent (SSH_AUTH_SOCK) will be used")
+	case 4:
+		infoColor.Println("running ssh-keygen, it will ask for your security key's pin and a touch")
+		publicStr, err := generateSKKey("ssh:bpass-" + host)
 		if err != nil {
-			errColor.Println("failed to marshal rsa private key with x509:", err)
+			errColor.Println("failed to generate ed25519-sk ssh key:", err)
 			return uri, nil
 		}
-		pemBlock := pem.Block{Type: "PRIVATE KEY", Bytes: b}
-		b = pem.EncodeToMemory(&pemBlock)
 
-		public, err := ssh.NewPublicKey(&priv.PublicKey)
-		if err != nil {
-			errColor.Println("failed to parse public key:", err)
-		}
-		publicStr := string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(public))) + " @bpass"
-
-		u.store.Set(uuid, blobformat.KeyPriv, string(bytes.TrimSpace(b)))
 		u.store.DB.Set(uuid, blobformat.KeyPub, publicStr)
 
-		infoColor.Printf("successfully generated new rsa-4096 key:\n%s\n", publicStr)
-
-	case 2:
-		pass, err := u.getPassword()
-		if err != nil {
-			return uri, err
-		}
-
-		uri.User = url.UserPassword(user, pass)
+		infoColor.Printf("successfully generated new ed25519-sk key:\n%s\n", publicStr)
+		infoColor.Println("the private key stays on the security key, load it into the ssh-agent with: ssh-add -K")
 	default:
 		panic("how did this happen?")
 	}
@@ -468,8 +750,13 @@ func addSCPEntry(u *uiContext, uuid string) (uri url.URL, err error) {
 	return uri, nil
 }
 
-func (u *uiContext) addNewInterruptible(name string) error {
-	err := u.addNew(name)
+func (u *uiContext) addNewInterruptible(name, template string) error {
+	var err error
+	if len(template) != 0 {
+		err = u.addFromTemplate(name, template)
+	} else {
+		err = u.addNew(name)
+	}
 	switch err {
 	case nil:
 		return nil
@@ -482,6 +769,14 @@ func (u *uiContext) addNewInterruptible(name string) error {
 }
 
 func (u *uiContext) addNew(name string) (err error) {
+	template, err := u.chooseTemplate()
+	if err != nil {
+		return err
+	}
+	if len(template) != 0 {
+		return u.addFromTemplate(name, template)
+	}
+
 	return u.store.Do(func() error {
 		uuid, err := u.store.New(name)
 		if err != nil {
@@ -492,12 +787,12 @@ func (u *uiContext) addNew(name string) (err error) {
 			return err
 		}
 
-		email, err := u.prompt(promptColor.Sprint("email: "))
+		email, err := u.promptGenerated(blobformat.KeyEmail, name)
 		if err != nil {
 			return err
 		}
 
-		user, err := u.prompt(promptColor.Sprint("user: "))
+		user, err := u.promptGenerated(blobformat.KeyUser, name)
 		if err != nil {
 			return err
 		}
@@ -524,7 +819,7 @@ func (u *uiContext) addNew(name string) (err error) {
 }
 
 func (u *uiContext) rename(src, dst string) error {
-	oldUUID, _, err := u.store.FindByName(src)
+	oldUUID, blob, err := u.store.FindByName(src)
 	if err != nil {
 		return err
 	}
@@ -532,6 +827,9 @@ func (u *uiContext) rename(src, dst string) error {
 		errColor.Println(src, "does not exist")
 		return nil
 	}
+	if !checkUnlocked(blob) {
+		return nil
+	}
 
 	if err := u.store.Rename(oldUUID, dst); err == blobformat.ErrNameNotUnique {
 		errColor.Println(dst, "already exists")
@@ -587,7 +885,7 @@ func (u *uiContext) deleteEntry(name string) error {
 	if deleteSelf {
 		// We are always the last user and so we must should clear the master
 		// key and IVM to ensure that we are not encrypted as a multi-user file
-		u.master = nil
+		u.setMaster(nil)
 		u.ivm = nil
 	}
 
@@ -597,7 +895,7 @@ func (u *uiContext) deleteEntry(name string) error {
 	return nil
 }
 
-func (u *uiContext) deleteKey(search, key string) error {
+func (u *uiContext) deleteKey(search, key string, force bool) error {
 	uuid, err := u.findOne(search)
 	if err != nil {
 		return err
@@ -611,6 +909,10 @@ func (u *uiContext) deleteKey(search, key string) error {
 		return err
 	}
 
+	if ok, err := u.guardKey(blob, key, force); err != nil || !ok {
+		return err
+	}
+
 	_, ok := blob[key]
 	if ok {
 		err := u.store.DeleteKey(uuid, key)
@@ -633,9 +935,20 @@ func (u *uiContext) list(search string) error {
 		fmt.Println("No entries found")
 		return nil
 	}
-	names := entries.Names()
-	sort.Strings(names)
-	fmt.Println(strings.Join(names, "\n"))
+
+	// Without a query the pinned entries come first
+	var uuids []string
+	var pinned int
+	if len(search) == 0 {
+		uuids, pinned = u.pinnedFirst(entries)
+	} else {
+		uuids = entries.UUIDs()
+		sort.Slice(uuids, func(i, j int) bool { return entries[uuids[i]] < entries[uuids[j]] })
+	}
+
+	for _, name := range u.listNames(uuids, entries, pinned) {
+		fmt.Fprintln(u.out, name)
+	}
 	return nil
 }
 
@@ -649,13 +962,15 @@ func (u *uiContext) listByLabels(wantLabels []string) error {
 		return nil
 	}
 
-	names := results.Names()
-	sort.Strings(names)
-	fmt.Println(strings.Join(names, "\n"))
+	uuids := results.UUIDs()
+	sort.Slice(uuids, func(i, j int) bool { return results[uuids[i]] < results[uuids[j]] })
+	for _, name := range u.listNames(uuids, results, 0) {
+		fmt.Fprintln(u.out, name)
+	}
 	return nil
 }
 
-func (u *uiContext) get(search, key string, index int, copy bool) error {
+func (u *uiContext) get(search, key string, index int, copy, raw, reveal bool) error {
 	uuid, err := u.findOne(search)
 	if err != nil {
 		return err
@@ -670,6 +985,8 @@ func (u *uiContext) get(search, key string, index int, copy bool) error {
 	}
 
 	switch key {
+	case blobformat.KeyPassHistory:
+		return u.getPassHistory(blob, index, copy)
 	case blobformat.KeyTwoFactor:
 		val, err := blob.TwoFactor()
 		if err != nil {
@@ -698,13 +1015,27 @@ func (u *uiContext) get(search, key string, index int, copy bool) error {
 			fmt.Println(val)
 		}
 	default:
-		value, ok := blob[key]
-		if !ok {
+		if _, ok := blob[key]; !ok {
 			errColor.Printf("%s.%s is not set", blob.Name(), key)
 		}
+		value, err := u.store.Resolve(blob, key)
+		if blobformat.IsBrokenLink(err) {
+			errColor.Println(err)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// Card numbers are pasted into forms without their grouping
+		if key == blobformat.KeyNumber && blob.Kind() == blobformat.KindCard {
+			value = blobformat.CardDigits(value)
+		}
 
 		if copy {
 			copyToClipboard(key, value)
+		} else if blob.IsSecret(key) && !reveal {
+			errColor.Printf("%s.%s is secret, use get --reveal to show it or cp to copy it\n", blob.Name(), key)
+		} else if key == blobformat.KeyNotes && !raw {
+			fmt.Println(renderMarkdown(value))
 		} else {
 			fmt.Println(value)
 		}
@@ -742,13 +1073,15 @@ func (u *uiContext) login(search string) error {
 	var keyVals []keyVal
 
 	for _, k := range keys {
-		value, ok := blob[k]
-		if ok {
+		if _, ok := blob[k]; ok {
+			var value string
 			if k == blobformat.KeyTwoFactor {
 				value, err = blob.TwoFactor()
-				if err != nil {
-					return err
-				}
+			} else {
+				value, err = u.store.Resolve(blob, k)
+			}
+			if err != nil {
+				return err
 			}
 			keyVals = append(keyVals, keyVal{Key: k, Val: value})
 		}
@@ -767,7 +1100,7 @@ func (u *uiContext) login(search string) error {
 	return nil
 }
 
-func (u *uiContext) set(search, key, value string) error {
+func (u *uiContext) set(search, key, value string, force, multiline bool) error {
 	uuid, err := u.findOne(search)
 	if err != nil {
 		return err
@@ -776,6 +1109,29 @@ func (u *uiContext) set(search, key, value string) error {
 		return nil
 	}
 
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+
+	if ok, err := u.guardKey(blob, key, force); err != nil || !ok {
+		return err
+	}
+
+	if multiline {
+		value, err = u.promptMultiline(promptColor.Sprint("> "))
+		if err != nil {
+			return err
+		}
+	} else if len(value) != 0 {
+		// The start of a pasted key or certificate ended up as the value,
+		// the rest of it is still coming
+		value, err = u.promptBlock(promptColor.Sprint("> "), value)
+		if err != nil {
+			return err
+		}
+	}
+
 	switch key {
 	case blobformat.KeyPass:
 		if len(value) == 0 {
@@ -785,24 +1141,13 @@ func (u *uiContext) set(search, key, value string) error {
 				return err
 			}
 		}
-
-		u.store.Set(uuid, key, value)
 	case blobformat.KeyTwoFactor:
 		if err := u.store.SetTwofactor(uuid, value); err != nil {
 			errColor.Println(err)
 			return nil
 		}
-	case blobformat.KeyURL:
-		uri, err := url.Parse(value)
-		if err != nil {
-			errColor.Println("not a valid url")
-			return nil
-		} else if uri.Scheme == "" || uri.Opaque != "" {
-			errColor.Println("url must include a scheme like https://")
-			return nil
-		}
-
-		u.store.Set(uuid, key, value)
+		infoColor.Printf("set %s = %s\n", key, value)
+		return nil
 	default:
 		// no known key was provided,  setting custom key
 
@@ -813,8 +1158,18 @@ func (u *uiContext) set(search, key, value string) error {
 				return err
 			}
 		}
+	}
 
-		u.store.Set(uuid, key, value)
+	err = u.store.Set(uuid, key, value)
+	switch {
+	case blobformat.IsKeyNotAllowed(err):
+		errColor.Println(key, "may not be set")
+		return nil
+	case blobformat.IsInvalidValue(err), blobformat.IsBrokenLink(err):
+		errColor.Println(err)
+		return nil
+	case err != nil:
+		return err
 	}
 
 	infoColor.Printf("set %s = %s\n", key, value)
@@ -822,7 +1177,7 @@ func (u *uiContext) set(search, key, value string) error {
 	return nil
 }
 
-func (u *uiContext) edit(search, key string) error {
+func (u *uiContext) edit(search, key string, force bool) error {
 	uuid, err := u.findOne(search)
 	if err != nil {
 		return err
@@ -837,6 +1192,10 @@ func (u *uiContext) edit(search, key string) error {
 		return nil
 	}
 
+	if ok, err := u.guardKey(blob, key, force); err != nil || !ok {
+		return err
+	}
+
 	// Create UUID filename
 	fuuid, err := uuidpkg.NewV4()
 	if err != nil {
@@ -923,17 +1282,112 @@ func (u *uiContext) edit(search, key string) error {
 		maxLen = len(newValue)
 	}
 
+	// Editors end the file with a newline that isn't part of a one line
+	// value
+	switch blob.Schema()[key] {
+	case blobformat.FieldMultiline, blobformat.FieldSecret:
+	default:
+		newValue = bytes.TrimSuffix(newValue, []byte("\n"))
+	}
+
 	if len(newValue) == 0 {
 		infoColor.Println("erasing value")
 		u.store.DeleteKey(uuid, key)
+	} else if err = u.store.Set(uuid, key, string(newValue)); blobformat.IsInvalidValue(err) || blobformat.IsKeyNotAllowed(err) || blobformat.IsBrokenLink(err) {
+		errColor.Println(err)
+	} else if err != nil {
+		return err
 	} else {
 		infoColor.Printf("set %s\n", key)
-		u.store.Set(uuid, key, string(newValue))
 	}
 
 	return nil
 }
 
+func (u *uiContext) protect(search, key string, protect bool) error {
+	uuid, err := u.findOne(search)
+	if err != nil {
+		return err
+	}
+	if len(uuid) == 0 {
+		return nil
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+
+	var changed bool
+	if protect {
+		changed, err = u.store.Protect(uuid, key)
+	} else {
+		changed, err = u.store.Unprotect(uuid, key)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !changed && protect:
+		infoColor.Printf("%s.%s is already protected\n", blob.Name(), key)
+	case !changed:
+		infoColor.Printf("%s.%s is not protected\n", blob.Name(), key)
+	case protect:
+		infoColor.Printf("protected %s.%s\n", blob.Name(), key)
+	default:
+		infoColor.Printf("unprotected %s.%s\n", blob.Name(), key)
+	}
+
+	return nil
+}
+
+// guardedSyncKeys are keys on sync entries that are managed by the sync
+// flows (addsync etc.), changing them by hand can easily break syncing.
+var guardedSyncKeys = []string{
+	blobformat.KeySync,
+	blobformat.KeyURL,
+	blobformat.KeyPriv,
+	blobformat.KeyKnownHosts,
+	blobformat.KeyHostKeyHistory,
+}
+
+// guardKey checks if a key on the blob may be modified, printing errors and
+// asking for confirmation where necessary. It returns false if the key should
+// be left alone.
+func (u *uiContext) guardKey(blob blobformat.Blob, key string, force bool) (bool, error) {
+	if !checkUnlocked(blob) {
+		return false, nil
+	}
+
+	if force {
+		return true, nil
+	}
+
+	if blob.IsProtected(key) {
+		errColor.Printf("%s.%s is protected, use --force to modify it\n", blob.Name(), key)
+		return false, nil
+	}
+
+	if _, ok := blob[key]; !ok {
+		return true, nil
+	}
+
+	_, isSync := blob[blobformat.KeySync]
+	if !isSync && !blobformat.IsSyncEntry(blob.Name()) {
+		return true, nil
+	}
+
+	for _, g := range guardedSyncKeys {
+		if g == key {
+			errColor.Printf("WARNING: %s is managed by sync, modifying it by hand can break syncing\n", key)
+			return u.getYesNo("are you sure you wish to proceed?")
+		}
+	}
+
+	return true, nil
+}
+
 func (u *uiContext) addLabels(search string) error {
 	uuid, err := u.findOne(search)
 	if err != nil {
@@ -1024,7 +1478,9 @@ func (u *uiContext) deleteLabel(search string, label string) error {
 	return nil
 }
 
-func (u *uiContext) show(search string, snapshot int) error {
+// show displays the keys of an entry as it was a number of snapshots ago,
+// notes are rendered as markdown unless raw is set
+func (u *uiContext) show(search string, snapshot int, raw bool) error {
 	uuid, err := u.findOne(search)
 	if err != nil {
 		return err
@@ -1059,9 +1515,20 @@ func (u *uiContext) show(search string, snapshot int) error {
 		return nil
 	}
 
+	// Attachments are shown in place of their chunks
+	attachments := make(map[string]blobformat.Attachment)
+	for _, a := range blob.Attachments() {
+		attachments[blobformat.AttachmentKey(a.Name)] = a
+	}
+	var keys []string
+	for _, k := range blob.Keys() {
+		if !blobformat.IsAttachmentChunk(k) {
+			keys = append(keys, k)
+		}
+	}
+
 	// Figure out the max width of the key names
 	width := 8
-	keys := blob.Keys()
 	for _, k := range keys {
 		if len(k) > width {
 			width = len(k) + 1 // +1 for : character
@@ -1077,6 +1544,25 @@ func (u *uiContext) show(search string, snapshot int) error {
 		blobformat.KeyEmail,
 		blobformat.KeyPass,
 		blobformat.KeyTwoFactor,
+		blobformat.KeyCardholder,
+		blobformat.KeyNumber,
+		blobformat.KeyExpiry,
+		blobformat.KeyCVV,
+		blobformat.KeyPIN,
+		blobformat.KeyFullName,
+		blobformat.KeyBirthdate,
+		blobformat.KeyPhone,
+		blobformat.KeyAddress,
+		blobformat.KeyPassport,
+		blobformat.KeyIDNumber,
+		blobformat.KeySSID,
+		blobformat.KeySecurity,
+		blobformat.KeyPassphrase,
+		blobformat.KeyRPID,
+		blobformat.KeyCredentialID,
+		blobformat.KeyUserHandle,
+		blobformat.KeyCounter,
+		blobformat.KeyRecovery,
 		blobformat.KeyLabels,
 		blobformat.KeyNotes,
 	}
@@ -1094,6 +1580,17 @@ func (u *uiContext) show(search string, snapshot int) error {
 	sort.Strings(keys)
 	keys = append(ordering, keys...)
 
+	// The body of a note is shown last, below the rest
+	isNote := blob.Kind() == blobformat.KindNote
+	if isNote {
+		for i, k := range keys {
+			if k == blobformat.KeyNotes {
+				keys = append(keys[:i:i], keys[i+1:]...)
+				break
+			}
+		}
+	}
+
 	for _, k := range keys {
 		if k == blobformat.KeyUpdated {
 			// Special case, this one shows up at the end
@@ -1105,11 +1602,61 @@ func (u *uiContext) show(search string, snapshot int) error {
 			continue
 		}
 
+		// Links show the value they link to with where it's from
+		name, linkKey, isLink := blobformat.ParseLink(val)
+		if isLink {
+			resolved, err := u.store.Resolve(blob, k)
+			if blobformat.IsBrokenLink(err) {
+				showKeyValue(u, k, val+" "+errColor.Sprint("(broken link)"), width, indent)
+				continue
+			} else if err != nil {
+				return err
+			}
+			val = resolved
+		}
+
+		if blob.IsSecret(k) {
+			showHidden(u, k, val, width, indent)
+			if isLink {
+				showLinkSource(u, name+"/"+linkKey, width, indent)
+			}
+			continue
+		}
+		if showKindValue(u, blob.Kind(), k, val, width, indent) {
+			if isLink {
+				showLinkSource(u, name+"/"+linkKey, width, indent)
+			}
+			continue
+		}
+
 		switch k {
 		case blobformat.KeyPass:
-			showHidden(u, blobformat.KeyPass, blob.Get(blobformat.KeyPass), width, indent)
+			showHidden(u, blobformat.KeyPass, val, width, indent)
 		case blobformat.KeyLabels:
 			showKeyValue(u, k, strings.ReplaceAll(val, ",", ", "), width, indent)
+		case blobformat.KeyExpires:
+			if expires, err := blobformat.ParseDate(val); err == nil {
+				val = fmt.Sprintf("%s (%s)", val, dueIn(expires, time.Now()))
+			}
+			showKeyValue(u, k, val, width, indent)
+		case blobformat.KeyPinned:
+			if pinned, err := blob.PinnedAt(); err == nil {
+				val = "since " + pinned.Format(time.RFC3339)
+			}
+			showKeyValue(u, k, val, width, indent)
+		case blobformat.KeyColor:
+			showKeyValue(u, k, entryName(val, "", val, color.Reset), width, indent)
+		case blobformat.KeyLocked:
+			if locked, err := blob.LockedAt(); err == nil {
+				val = "since " + locked.Format(time.RFC3339)
+			}
+			showKeyValue(u, k, val, width, indent)
+		case blobformat.KeyPassHistory:
+			old := blobformat.Blob{k: val}.PassHistory()
+			showKeyValue(u, k, fmt.Sprintf("%d old passwords (get %s passhist)", len(old), blob.Name()), width, indent)
+		case blobformat.KeyRecovery:
+			codes := blobformat.Blob{k: val}.RecoveryCodes()
+			showKeyValue(u, k, fmt.Sprintf("%d unused codes (use recovery)", len(codes)), width, indent)
 		case blobformat.KeyTwoFactor:
 			t, err := blob.TwoFactor()
 			if err != nil {
@@ -1117,12 +1664,33 @@ func (u *uiContext) show(search string, snapshot int) error {
 			} else if len(t) != 0 {
 				showKeyValue(u, blobformat.KeyTwoFactor, t, width, indent)
 			}
-		default:
+		case blobformat.KeyCert:
+			showMultiline(u, k, val, width, indent)
+			showCertInfo(u, val, width, indent)
+		case blobformat.KeyPub:
+			showKeyValue(u, k, val, width, indent)
+			showSSHKeyInfo(u, val, width, indent)
+		case blobformat.KeyNotes:
+			if !raw {
+				val = renderMarkdown(val)
+			}
 			if strings.ContainsRune(val, '\n') {
 				showMultiline(u, k, val, width, indent)
 			} else {
 				showKeyValue(u, k, val, width, indent)
 			}
+		default:
+			if a, ok := attachments[k]; ok {
+				showKeyValue(u, k, attachmentSummary(a), width, indent)
+			} else if strings.ContainsRune(val, '\n') {
+				showMultiline(u, k, val, width, indent)
+			} else {
+				showKeyValue(u, k, val, width, indent)
+			}
+		}
+
+		if isLink {
+			showLinkSource(u, name+"/"+linkKey, width, indent)
 		}
 	}
 
@@ -1136,6 +1704,10 @@ func (u *uiContext) show(search string, snapshot int) error {
 		showKeyValue(u, "snaps", strconv.Itoa(snaps), width, indent)
 	}
 
+	if body, ok := blob[blobformat.KeyNotes]; ok && isNote {
+		showNoteBody(u, body, raw)
+	}
+
 	return nil
 }
 
@@ -1149,6 +1721,12 @@ func showHidden(u *uiContext, key, value string, width, indent int) {
 	fmt.Fprintf(u.out, "%s%s %s\n", ind, keyColor.Sprintf("%*s", width, key+":"), hideColor.Sprint(value))
 }
 
+// showLinkSource shows where the value of a link above it is from
+func showLinkSource(u *uiContext, target string, width, indent int) {
+	ind := strings.Repeat(" ", indent)
+	fmt.Fprintf(u.out, "%s%*s %s\n", ind, width, "", infoColor.Sprint("from "+target))
+}
+
 func showMultiline(u *uiContext, key string, val string, width, indent int) {
 	lines := strings.Split(val, "\n")
 
@@ -1163,7 +1741,8 @@ func showMultiline(u *uiContext, key string, val string, width, indent int) {
 	fmt.Fprintln(u.out, lineInd+strings.TrimSpace(strings.Join(lines, "\n"+lineInd)))
 }
 
-func (u *uiContext) openurl(search string) error {
+// openurl launches the browser with url n of an entry, see urlToOpen
+func (u *uiContext) openurl(search string, n int) error {
 	uuid, err := u.findOne(search)
 	if err != nil {
 		return nil
@@ -1177,15 +1756,9 @@ func (u *uiContext) openurl(search string) error {
 		return err
 	}
 
-	link := blob.Get(blobformat.KeyURL)
-	if len(link) == 0 {
-		errColor.Printf("url not set on %s\n", blob.Name())
-		return nil
-	}
-
-	_, err = url.Parse(link)
+	link, err := u.urlToOpen(blob, n)
 	if err != nil {
-		errColor.Printf("url was not a valid url: %v\n", err)
+		errColor.Println(err)
 		return nil
 	}
 
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/crypt"
+	"github.com/aarondl/bpass/secmem"
+	"github.com/aarondl/bpass/txlogs"
+
+	"github.com/aarondl/color"
+)
+
+// openAs is what opening a multi-user file as user does
+func openAs(t *testing.T, user, pass string, data []byte) *uiContext {
+	t.Helper()
+
+	version, params, pt, err := crypt.Decrypt([]byte(user), []byte(pass), nil, nil, data)
+	if err != nil {
+		t.Fatalf("opening as %s: %v", user, err)
+	}
+	store, err := txlogs.New(pt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &uiContext{user: user, pass: pass, salt: params.Salts[params.User], store: blobformat.Blobs{DB: store}, out: new(bytes.Buffer)}
+	u.setKey(params.Keys[params.User])
+	u.setMaster(params.Master)
+	u.ivm = params.IVM
+	u.keepSlot(version, params)
+	return u
+}
+
+// newMultiUser makes a multi-user file whose first user is me:hunter42
+func newMultiUser(t *testing.T) *uiContext {
+	t.Helper()
+
+	kdf := crypt.KDFParams{Algorithm: crypt.KDFArgon2id, Time: 1, Memory: 8 * 1024, Threads: 1}
+	key, salt, err := crypt.DeriveKeyParams(kdf.Version(), []byte("hunter42"), kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &uiContext{pass: "hunter42", key: secmem.Copy(key), salt: salt, store: blobformat.Blobs{DB: new(txlogs.DB)}, out: new(bytes.Buffer)}
+	if err = u.store.SetSetting(blobformat.SettingKDF, kdf.String()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = u.store.New("github"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.adduser("me", addUserPassphrase); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestRecoveryUser(t *testing.T) {
+	t.Parallel()
+
+	u := newMultiUser(t)
+	out := u.out.(*bytes.Buffer)
+	out.Reset()
+	if err := u.adduser("rescue", addUserRecovery); err != nil {
+		t.Fatal(err)
+	}
+	code := strings.TrimSpace(color.Clean(out.String()))
+	if len(code) == 0 {
+		t.Fatal("the recovery code was not printed")
+	}
+
+	data, err := u.encryptBlob()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rescue := openAs(t, "rescue", code, data)
+	if uuid, _, err := rescue.store.FindByName("github"); err != nil || len(uuid) == 0 {
+		t.Fatal("the recovery user should see the file's entries", err)
+	}
+	if err = rescue.rekey(""); err != nil {
+		t.Fatal(err)
+	}
+	rekeyed, err := rescue.encryptBlob()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The code is the same but the key it derives is new, the other users
+	// are untouched
+	openAs(t, "rescue", code, rekeyed)
+	openAs(t, "me", "hunter42", rekeyed)
+	if _, _, _, err = crypt.Decrypt([]byte("rescue"), []byte(code+"x"), nil, nil, rekeyed); err != crypt.ErrWrongPassphrase {
+		t.Error("want wrong passphrase error, got:", err)
+	}
+}
+
+func TestRekeyMaster(t *testing.T) {
+	t.Parallel()
+
+	u := newMultiUser(t)
+	for _, user := range []string{"rescue", "spare"} {
+		if err := u.adduser(user, addUserRecovery); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldMaster := string(u.master.Bytes())
+	old, err := u.encryptBlob()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := u.out.(*bytes.Buffer)
+	out.Reset()
+	u.in = &scriptedEditor{lines: []string{"y"}}
+	if err = u.rekeyMaster(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := u.encryptBlob()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passes := map[string]string{"me": "hunter42"}
+	for _, line := range strings.Split(color.Clean(out.String()), "\n") {
+		if splits := strings.Fields(line); len(splits) == 2 {
+			passes[strings.TrimSuffix(splits[0], ":")] = splits[1]
+		}
+	}
+	if len(passes) != 3 {
+		t.Fatalf("want new passphrases for the other users, got: %q", out.String())
+	}
+
+	// Everyone opens the new file with the same master key, which isn't
+	// the old one
+	for user, pass := range passes {
+		opened := openAs(t, user, pass, data)
+		if master := string(opened.master.Bytes()); master != string(u.master.Bytes()) || master == oldMaster {
+			t.Errorf("%s opened the file with the wrong master key", user)
+		}
+		if uuid, _, err := opened.store.FindByName("github"); err != nil || len(uuid) == 0 {
+			t.Errorf("%s should see the file's entries: %v", user, err)
+		}
+	}
+
+	// The current user's passphrase opens the old file too, the others'
+	// new ones don't
+	openAs(t, "me", "hunter42", old)
+	if _, _, _, err = crypt.Decrypt([]byte("rescue"), []byte(passes["rescue"]), nil, nil, old); err != crypt.ErrWrongPassphrase {
+		t.Error("want wrong passphrase error, got:", err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+// compact squashes the log's history from before days ago, all of it when
+// days is 0
+func (u *uiContext) compact(days int) error {
+	if days == 0 {
+		infoColor.Println("all history will be removed from the log, only the current values are kept")
+	} else {
+		infoColor.Printf("history older than %d days will be removed from the log\n", days)
+	}
+	yes, err := u.getYesNo("continue?")
+	if err != nil || !yes {
+		return err
+	}
+
+	removed, err := u.compactLog(time.Now().AddDate(0, 0, -days).UnixNano())
+	if err != nil {
+		return fmt.Errorf("failed to compact: %w", err)
+	}
+
+	infoColor.Printf("removed %d transactions, %d left\n", removed, len(u.store.DB.Log))
+	if c := u.checkpoint(); c.Time != 0 {
+		infoColor.Printf("history is pruned to the checkpoint at %s (%.12s)\n", time.Unix(0, c.Time).Format(time.RFC3339), c.Hash)
+	}
+	return nil
+}
+
+// autoCompact compacts the log when the settings ask for it, with
+// compactlength only when the log is longer than it and with compactdays
+// alone every time.
+func (u *uiContext) autoCompact() error {
+	length, days := u.compactPolicy()
+	switch {
+	case length != 0 && len(u.store.DB.Log) <= length:
+		return nil
+	case length == 0 && days == 0:
+		return nil
+	}
+
+	_, err := u.compactLog(time.Now().AddDate(0, 0, -days).UnixNano())
+	return err
+}
+
+// compactPolicy reads the compactlength and compactdays settings, 0 when
+// they're unset
+func (u *uiContext) compactPolicy() (length, days int) {
+	if val, err := u.store.Setting(blobformat.SettingCompactLength); err == nil && len(val) != 0 {
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			length = n
+		}
+	}
+	if val, err := u.store.Setting(blobformat.SettingCompactDays); err == nil && len(val) != 0 {
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			days = n
+		}
+	}
+
+	return length, days
+}
+
+// compactLog prunes the history before the unix nanosecond time and records
+// the checkpoint in the file so merges with copies that still have the
+// history are compacted the same way (see recompact). The checkpoint is a
+// change like any other so it's signed by this device.
+func (u *uiContext) compactLog(before int64) (int, error) {
+	if current := u.compactedBefore(); current > before {
+		before = current
+	}
+
+	c, removed, err := u.store.DB.Prune(before)
+	if err != nil || removed == 0 {
+		return removed, err
+	}
+
+	if err = u.recordCheckpoint(c); err != nil {
+		return removed, err
+	}
+
+	return removed, nil
+}
+
+// checkpoint is where the history was last pruned to, the zero value if it
+// never was
+func (u *uiContext) checkpoint() txlogs.Checkpoint {
+	val, err := u.store.Setting(blobformat.SettingCheckpoint)
+	if err != nil {
+		return txlogs.Checkpoint{}
+	}
+
+	c, _ := txlogs.ParseCheckpoint(val)
+	return c
+}
+
+// recordCheckpoint records the checkpoint unless it's the current one
+func (u *uiContext) recordCheckpoint(c txlogs.Checkpoint) error {
+	if c == u.checkpoint() {
+		return nil
+	}
+	return u.store.SetSetting(blobformat.SettingCheckpoint, c.String())
+}
+
+// compactedBefore is when the history was last compacted before, 0 if it
+// never was
+func (u *uiContext) compactedBefore() int64 {
+	if c := u.checkpoint(); c.Time != 0 {
+		return c.Time
+	}
+
+	val, err := u.store.Setting(blobformat.SettingCompacted)
+	if err != nil || len(val) == 0 {
+		return 0
+	}
+
+	before, _ := strconv.ParseInt(val, 10, 64)
+	return before
+}
+
+// recompact compacts a merged log again up to where it was compacted
+// before. Merging with a copy that still has the history brings it back,
+// changes that were made in it before it was synced are kept since they're
+// merged in before the history is squashed again, the checkpoint moves to
+// include them.
+func (u *uiContext) recompact() error {
+	before := u.compactedBefore()
+	if before == 0 {
+		return nil
+	}
+
+	c, _, err := u.store.DB.Prune(before)
+	if err != nil {
+		return err
+	}
+	return u.recordCheckpoint(c)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestAutoCompact(t *testing.T) {
+	t.Parallel()
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}}
+	uuid, err := u.store.New("entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"1", "2", "3"} {
+		u.store.DB.Set(uuid, "a", v)
+	}
+	if err = u.store.SetSetting(blobformat.SettingCompactLength, "100"); err != nil {
+		t.Fatal(err)
+	}
+	history := append([]txlogs.Tx{}, u.store.DB.Log...)
+
+	if err = u.autoCompact(); err != nil {
+		t.Fatal(err)
+	}
+	if len(u.store.DB.Log) != len(history) || u.compactedBefore() != 0 {
+		t.Error("a log shorter than compactlength should not be compacted")
+	}
+
+	if err = u.store.SetSetting(blobformat.SettingCompactLength, "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.autoCompact(); err != nil {
+		t.Fatal(err)
+	}
+	if len(u.store.DB.Log) >= len(history) {
+		t.Error("the log should have been compacted:", len(u.store.DB.Log))
+	}
+	if u.compactedBefore() == 0 {
+		t.Error("when the log was compacted should be remembered")
+	}
+	checkpoint := u.checkpoint()
+	if hash, err := u.store.DB.StateHash(checkpoint.Time); err != nil || hash != checkpoint.Hash {
+		t.Error("the checkpoint should have the entries from before it:", checkpoint, err)
+	}
+	if err = u.store.UpdateSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if got := u.store.Snapshot[uuid]["a"]; got != "3" {
+		t.Error("value was wrong:", got)
+	}
+	compacted := len(u.store.DB.Log)
+
+	// Merging a copy that still has the history doesn't bring it back
+	merged, conflicts := txlogs.Merge(u.store.DB.Log, history, nil)
+	if len(conflicts) != 0 {
+		t.Fatal("merge had conflicts:", conflicts)
+	}
+	u.store.ResetSnapshot()
+	u.store.DB.Log = merged
+	if err = u.recompact(); err != nil {
+		t.Fatal(err)
+	}
+	if len(u.store.DB.Log) != compacted {
+		t.Error("the merged history should be compacted again:", len(u.store.DB.Log), compacted)
+	}
+	if u.checkpoint() != checkpoint {
+		t.Error("the checkpoint shouldn't move when the history had nothing new:", u.checkpoint())
+	}
+	if err = u.store.UpdateSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if got := u.store.Snapshot[uuid]["a"]; got != "3" {
+		t.Error("value was wrong:", got)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/rand"
 	"errors"
 	"fmt"
 	"strconv"
@@ -56,6 +55,8 @@ type config struct {
 	decrypt    decryptFn
 	keygen     keyFn
 	mkeygen    mkeyFn
+	salt       saltFn
+	newSalt    newSaltFn
 }
 
 type cipherAlg struct {
@@ -74,6 +75,8 @@ type (
 	decryptFn     func(c config, user, passphrase, key, salt, encrypted []byte) (p Params, pt []byte, err error)
 	keyFn         func(c config, passphrase, salt []byte) (key []byte, err error)
 	mkeyFn        func(c config) (master, iv []byte, err error)
+	saltFn        func(c config, user, encrypted []byte) (salt []byte, err error)
+	newSaltFn     func(c config, kdf KDFParams) (salt []byte, err error)
 )
 
 var (
@@ -87,12 +90,119 @@ var (
 
 func init() {
 	// Create all the versioned configurations
-	makeVersion(1, encryptV1, encryptMasterKeyV1, decryptV1, deriveKeyV1, newMasterKeyV1, 32, "AES", "Camellia", "CAST5")
+	makeVersion(1, encryptV1, encryptMasterKeyV1, decryptV1, deriveKeyV1, newMasterKeyV1, saltV1, newSaltV1, 32, "AES", "Camellia", "CAST5")
+	makeVersion(2, encryptV1, encryptMasterKeyV1, decryptV1, deriveKeyV2, newMasterKeyV1, saltV1, newSaltV2, saltRandomSize+kdfParamsSize, "AES", "Camellia", "CAST5")
+	makeVersion(3, encryptV1, encryptMasterKeyV1, decryptV1, deriveKeyV3, newMasterKeyV1, saltV1, newSaltV3, saltRandomSize+scryptParamsSize, "AES", "Camellia", "CAST5")
+	// These share keys with 2 and 3, the cascade is only used for master keys
+	makeVersion(4, encryptV4, encryptMasterKeyV1, decryptV4, deriveKeyV2, newMasterKeyV1, saltV1, newSaltV2, saltRandomSize+kdfParamsSize, "AES", "Camellia", "CAST5")
+	makeVersion(5, encryptV4, encryptMasterKeyV1, decryptV4, deriveKeyV3, newMasterKeyV1, saltV1, newSaltV3, saltRandomSize+scryptParamsSize, "AES", "Camellia", "CAST5")
+	// These are 4 and 5 for single-user files with a second slot
+	makeVersion(6, encryptV6, encryptMasterKeyV1, decryptV6, deriveKeyV2, newMasterKeyV1, saltV6, newSaltV2, saltRandomSize+kdfParamsSize, "AES", "Camellia", "CAST5")
+	makeVersion(7, encryptV6, encryptMasterKeyV1, decryptV6, deriveKeyV3, newMasterKeyV1, saltV6, newSaltV3, saltRandomSize+scryptParamsSize, "AES", "Camellia", "CAST5")
+}
+
+// Ciphers the payload can be encrypted with, see CipherVersion
+const (
+	// CipherCascade is AES, Camellia and CAST5 in CBC mode with a sha512 of
+	// the plaintext (versions 1 to 3)
+	CipherCascade = "cascade"
+	// CipherChaCha20Poly1305 is the XChaCha20-Poly1305 aead (versions 4 and
+	// 5), it's much faster than the cascade on cpus without aes instructions
+	CipherChaCha20Poly1305 = "chacha20poly1305"
+)
+
+// chachaVersions are the chacha20poly1305 versions that use the same keys as
+// each cascade version
+var chachaVersions = map[int]int{2: 4, 3: 5}
+
+// slotVersions are the versions with a second slot for each chacha20poly1305
+// version
+var slotVersions = map[int]int{4: 6, 5: 7}
+
+// CipherVersion finds the version that encrypts with cipher using keys
+// derived for version. Keys (and the master keys of multi-user files) work
+// with every version that uses the same kdf so a file can change ciphers
+// by being encrypted again with the version this returns.
+func CipherVersion(version int, cipher string) (int, error) {
+	if _, err := getVersion(version); err != nil {
+		return 0, err
+	}
+	version = kdfVersion(version)
+
+	switch cipher {
+	case CipherCascade:
+		return version, nil
+	case CipherChaCha20Poly1305:
+		if v, ok := chachaVersions[version]; ok {
+			return v, nil
+		}
+		return 0, fmt.Errorf("version %d keys can't be used with %s, rekey first", version, cipher)
+	default:
+		return 0, fmt.Errorf("unknown cipher %q (want %s or %s)", cipher, CipherCascade, CipherChaCha20Poly1305)
+	}
+}
+
+// VersionCipher is the cipher a version encrypts the payload with
+func VersionCipher(version int) string {
+	for _, v := range chachaVersions {
+		if v == version {
+			return CipherChaCha20Poly1305
+		}
+	}
+	if _, ok := slotVersionOf(version); ok {
+		return CipherChaCha20Poly1305
+	}
+	return CipherCascade
+}
+
+// SlotVersion finds the version of a chacha20poly1305 version that has a
+// second slot, which holds a second dataset opened by another passphrase
+// (see NewSlot) or filler that can't be told apart from one. Only
+// single-user files can use them.
+func SlotVersion(version int) (int, error) {
+	if _, ok := slotVersionOf(version); ok {
+		return version, nil
+	}
+	if v, ok := slotVersions[version]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("version %d doesn't have a version with slots, it must use %s", version, CipherChaCha20Poly1305)
+}
+
+// slotVersionOf returns the chacha20poly1305 version of a version with
+// slots
+func slotVersionOf(version int) (int, bool) {
+	for chacha, v := range slotVersions {
+		if v == version {
+			return chacha, true
+		}
+	}
+	return 0, false
+}
+
+// kdfVersion is the first version that derives keys the same way as version,
+// versions that share a kdf have the same salts and keys
+func kdfVersion(version int) int {
+	c := versions[version]
+	for v, other := range versions {
+		if v < version && other.saltSize == c.saltSize {
+			version = v
+		}
+	}
+	return version
+}
+
+// defaultKDFParams are the params DeriveKey uses for a version
+func defaultKDFParams(version int) KDFParams {
+	if kdfVersion(version) == DefaultScryptParams.Version() {
+		return DefaultScryptParams
+	}
+	return DefaultKDFParams
 }
 
 // makeVersion is a helper for calculating block and key size from the
 // constant list of algorithms and putting the entry in versions
-func makeVersion(version int, e encryptFn, ek encryptMKeyFn, d decryptFn, k keyFn, mk mkeyFn, saltSize int, algs ...string) config {
+func makeVersion(version int, e encryptFn, ek encryptMKeyFn, d decryptFn, k keyFn, mk mkeyFn, s saltFn, ns newSaltFn, saltSize int, algs ...string) config {
 	c := config{
 		version:    version,
 		saltSize:   saltSize,
@@ -101,6 +211,8 @@ func makeVersion(version int, e encryptFn, ek encryptMKeyFn, d decryptFn, k keyF
 		decrypt:    d,
 		keygen:     k,
 		mkeygen:    mk,
+		salt:       s,
+		newSalt:    ns,
 	}
 
 	for _, a := range algs {
@@ -212,6 +324,19 @@ func Decrypt(user, passphrase, key, salt, encrypted []byte) (version int, p Para
 	return version, p, pt, nil
 }
 
+// FileVersion reads the version a file was encrypted with without decrypting
+// it
+func FileVersion(encrypted []byte) (int, error) {
+	if len(encrypted) < magicLen {
+		return 0, ErrInvalidFileFormat
+	}
+	if bytes.Equal(v0Header, encrypted[:len(v0Header)]) {
+		return 0, nil
+	}
+
+	return verifyMagic(encrypted)
+}
+
 // IsMultiUser checks if a file is multi user. This check is not necessary
 // but it avoids having to call Decrypt with a valid passphrase from a user
 // before they are prompted to enter their username. In duplicates some of
@@ -232,23 +357,56 @@ func IsMultiUser(encrypted []byte) (ok bool, err error) {
 	return i != 0, nil
 }
 
+// Salt returns the salt that the user's key was derived with for an encrypted
+// payload without decrypting anything. Combined with the fast-path of
+// Decrypt this allows callers holding keys that were previously derived to
+// avoid deriving them again.
+//
+// If the file is multi-user and user is empty ErrNeedUser is returned, if
+// the user is not found in the file ErrUnknownUser is returned.
+func Salt(user, encrypted []byte) (salt []byte, err error) {
+	if len(encrypted) < magicLen {
+		return nil, ErrInvalidFileFormat
+	}
+
+	version, err := verifyMagic(encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := getVersion(version)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.salt(c, user, encrypted)
+}
+
 // DeriveKey from a passphrase. It returns both the key that was derived and
-// the salt used to create it.
+// the salt used to create it. The default params for the version's kdf are
+// used.
 //
 // DeriveKey uses cpu and memory hard algorithms, this is very taxing on the
 // computer on which its run and so if a rekey is necessary it should
 // probably occur after a save, or early in the lifecycle due to the
 // likelihood of crashing the program given the high resource usages.
 func DeriveKey(version int, passphrase []byte) (key, salt []byte, err error) {
+	return DeriveKeyParams(version, passphrase, defaultKDFParams(version))
+}
+
+// DeriveKeyParams is DeriveKey with the cost of the key derivation given
+// explicitly. The params are ignored by versions that don't support them
+// (version 1's scrypt parameters are fixed).
+func DeriveKeyParams(version int, passphrase []byte, kdf KDFParams) (key, salt []byte, err error) {
 	c, err := getVersion(version)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	// Secure random salt for passphrase derivation
-	salt = make([]byte, c.saltSize)
-	if n, err := rand.Read(salt); n != c.saltSize || err != nil {
-		return nil, nil, fmt.Errorf("failed to get randomness for salt: %w", err)
+	salt, err = c.newSalt(c, kdf)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	key, err = c.keygen(c, passphrase, salt)
@@ -259,6 +417,40 @@ func DeriveKey(version int, passphrase []byte) (key, salt []byte, err error) {
 	return key, salt, nil
 }
 
+// SaltVersion finds the version a salt was created for by DeriveKey so that
+// a key can be used with the same version it was derived for. Each kdf's
+// salts are a different size to make this possible. Versions that share a
+// kdf share keys, the first of them is returned (see CipherVersion).
+func SaltVersion(salt []byte) (version int, err error) {
+	for v, c := range versions {
+		if c.saltSize == len(salt) && (version == 0 || v < version) {
+			version = v
+		}
+	}
+	if version == 0 {
+		return 0, ErrInvalidSalt
+	}
+
+	return version, nil
+}
+
+// SaltKDFParams returns the params a key was derived with from its salt
+func SaltKDFParams(salt []byte) (KDFParams, error) {
+	version, err := SaltVersion(salt)
+	if err != nil {
+		return KDFParams{}, err
+	}
+
+	switch version {
+	case 2:
+		return kdfParamsV2(salt)
+	case 3:
+		return kdfParamsV3(salt)
+	default:
+		return DefaultScryptParams, nil
+	}
+}
+
 func getVersion(version int) (c config, err error) {
 	config, ok := versions[version]
 	if !ok {
@@ -42,6 +42,9 @@ func TestCrypt(t *testing.T) {
 		if bytes.Contains(ciphertext, plaintext) {
 			t.Errorf("%d) the plain text is visible", v)
 		}
+		if got, err := FileVersion(ciphertext); err != nil || got != v {
+			t.Errorf("%d) file version was wrong: %d %v", v, got, err)
+		}
 
 		version, p, gotPlaintext, err := Decrypt(nil, passphrase, nil, nil, ciphertext)
 		if err != nil {
@@ -98,6 +101,11 @@ func TestCryptMulti(t *testing.T) {
 	sort.Ints(versionNumbers)
 
 	for _, v := range versionNumbers {
+		if _, ok := slotVersionOf(v); ok {
+			// These are single-user only
+			continue
+		}
+
 		key1, salt1, err := DeriveKey(v, passphrase1)
 		if err != nil {
 			t.Errorf("%d) failed to derive key: %v", v, err)
@@ -209,6 +217,86 @@ func TestCryptMulti(t *testing.T) {
 	}
 }
 
+func TestSalt(t *testing.T) {
+	t.Parallel()
+
+	c := versions[1]
+	key := make([]byte, c.keySize)
+	salt1 := bytes.Repeat([]byte{1}, c.saltSize)
+	salt2 := bytes.Repeat([]byte{2}, c.saltSize)
+
+	var p Params
+	p.Keys = [][]byte{key}
+	p.Salts = [][]byte{salt1}
+	ciphertext, err := Encrypt(1, &p, []byte("plaintext"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Salt(nil, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, salt1) {
+		t.Error("salt was wrong")
+	}
+
+	master, miv, err := NewMasterKey(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mkey, iv, err := EncryptMasterKey(1, key, master)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user1Sum := sha256.Sum256([]byte("user1"))
+	user2Sum := sha256.Sum256([]byte("user2"))
+	p = Params{
+		NUsers: 2,
+		Users:  [][]byte{user1Sum[:], user2Sum[:]},
+		Keys:   [][]byte{key, nil},
+		Salts:  [][]byte{salt1, salt2},
+		MKeys:  [][]byte{mkey, mkey},
+		IVs:    [][]byte{iv, iv},
+		Master: master,
+		IVM:    miv,
+	}
+	ciphertext, err = Encrypt(1, &p, []byte("plaintext"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = Salt(nil, ciphertext); err != ErrNeedUser {
+		t.Error("want need user error, got:", err)
+	}
+	if _, err = Salt([]byte("user3"), ciphertext); err != ErrUnknownUser {
+		t.Error("want unknown user error, got:", err)
+	}
+
+	got, err = Salt([]byte("user2"), ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, salt2) {
+		t.Error("salt was wrong")
+	}
+}
+
+func TestFileVersion(t *testing.T) {
+	t.Parallel()
+
+	if v, err := FileVersion(v0Header); err != nil || v != 0 {
+		t.Error("want version 0, got:", v, err)
+	}
+	if v, err := FileVersion([]byte("blobpass00030000")); err != nil || v != 3 {
+		t.Error("want version 3, got:", v, err)
+	}
+	if _, err := FileVersion([]byte("short")); err != ErrInvalidFileFormat {
+		t.Error("want invalid file format, got:", err)
+	}
+}
+
 func TestDecryptV0(t *testing.T) {
 	t.Parallel()
 
@@ -102,36 +102,11 @@ func encryptV1Multi(c config, p *Params, plaintext []byte) (encrypted []byte, er
 		return nil, err
 	}
 
-	userSize := sha256.Size + c.saltSize + c.blockSize + c.keySize
-	plaintextHeader := make([]byte,
-		magicLen+(userSize*p.NUsers)+c.blockSize,
-	)
-	copy(plaintextHeader, fmt.Sprintf("%s%04d%04d", magicStr, c.version, p.NUsers))
-
-	// Copy all user data into the plaintext header
-	offset := magicLen
-	for i := 0; i < p.NUsers; i++ {
-		key := p.Keys[i]
-		if len(key) != 0 && len(key) != c.keySize {
-			return nil, ErrInvalidKey
-		}
-		if len(p.Salts[i]) != c.saltSize {
-			return nil, ErrInvalidSalt
-		}
-
-		// These should always be here
-		copy(plaintextHeader[offset:], p.Users[i])
-		offset += sha256.Size
-		copy(plaintextHeader[offset:], p.Salts[i])
-		offset += c.saltSize
-		copy(plaintextHeader[offset:], p.IVs[i])
-		offset += c.blockSize
-		copy(plaintextHeader[offset:], p.MKeys[i])
-		offset += c.keySize
+	plaintextHeader, err := multiHeaderV1(c, p)
+	if err != nil {
+		return nil, err
 	}
 
-	copy(plaintextHeader[offset:], p.IVM)
-
 	ciphers, err := makeCiphers(p.Master, cipherSuite)
 	if err != nil {
 		return nil, err
@@ -164,6 +139,42 @@ func encryptV1Multi(c config, p *Params, plaintext []byte) (encrypted []byte, er
 	return append(plaintextHeader, work...), nil
 }
 
+// multiHeaderV1 creates the plaintext header of a multi-user file, everything
+// before the payload (ivm included)
+func multiHeaderV1(c config, p *Params) ([]byte, error) {
+	userSize := sha256.Size + c.saltSize + c.blockSize + c.keySize
+	plaintextHeader := make([]byte,
+		magicLen+(userSize*p.NUsers)+c.blockSize,
+	)
+	copy(plaintextHeader, fmt.Sprintf("%s%04d%04d", magicStr, c.version, p.NUsers))
+
+	// Copy all user data into the plaintext header
+	offset := magicLen
+	for i := 0; i < p.NUsers; i++ {
+		key := p.Keys[i]
+		if len(key) != 0 && len(key) != c.keySize {
+			return nil, ErrInvalidKey
+		}
+		if len(p.Salts[i]) != c.saltSize {
+			return nil, ErrInvalidSalt
+		}
+
+		// These should always be here
+		copy(plaintextHeader[offset:], p.Users[i])
+		offset += sha256.Size
+		copy(plaintextHeader[offset:], p.Salts[i])
+		offset += c.saltSize
+		copy(plaintextHeader[offset:], p.IVs[i])
+		offset += c.blockSize
+		copy(plaintextHeader[offset:], p.MKeys[i])
+		offset += c.keySize
+	}
+
+	copy(plaintextHeader[offset:], p.IVM)
+
+	return plaintextHeader, nil
+}
+
 func encryptMasterKeyV1(c config, userKey []byte, master []byte) (cryptedMaster, iv []byte, err error) {
 	if len(master) != c.keySize {
 		return nil, nil, errors.New("master key wrong size")
@@ -206,15 +217,11 @@ func encryptMasterKeyV1(c config, userKey []byte, master []byte) (cryptedMaster,
 }
 
 func decryptV1(c config, user, passphrase, key, salt, encrypted []byte) (p Params, plaintext []byte, err error) {
-	nUserBytes := encrypted[12:16]
-
-	var nUsers int
-	i, err := strconv.ParseInt(string(nUserBytes), 10, 32)
+	nUsers, err := fileUsersV1(encrypted)
 	if err != nil {
-		return p, nil, ErrInvalidFileFormat
+		return p, nil, err
 	}
 
-	nUsers = int(i)
 	if nUsers != 0 && len(user) == 0 {
 		return p, nil, ErrNeedUser
 	}
@@ -231,20 +238,9 @@ func decryptV1Single(c config, passphrase, key, salt, encrypted []byte) (p Param
 		return p, nil, err
 	}
 
-	// Pull salt out and derive key
-	newSalt := encrypted[magicLen : magicLen+c.saltSize]
-	doDerive := !bytes.Equal(salt, newSalt)
-
-	if key == nil || doDerive {
-		if len(passphrase) == 0 {
-			return p, nil, ErrWrongPassphrase
-		}
-
-		salt = newSalt
-		key, err = c.keygen(c, passphrase, salt)
-		if err != nil {
-			return p, nil, err
-		}
+	key, salt, err = singleKeyV1(c, passphrase, key, salt, encrypted)
+	if err != nil {
+		return p, nil, err
 	}
 
 	ciphers, err := makeCiphers(key, suite)
@@ -291,28 +287,106 @@ func decryptV1Single(c config, passphrase, key, salt, encrypted []byte) (p Param
 		return p, nil, ErrWrongPassphrase
 	}
 
-	if len(p.Keys) == 0 {
-		p.Keys = [][]byte{nil}
+	p.Keys = [][]byte{key}
+	p.Salts = [][]byte{salt}
+	p.IVs = [][]byte{iv}
+	return p, plaintext, nil
+}
+
+// singleKeyV1 derives the key for a single-user file unless the key passed
+// in was derived with the file's salt
+func singleKeyV1(c config, passphrase, key, salt, encrypted []byte) ([]byte, []byte, error) {
+	// Pull salt out and derive key
+	newSalt := encrypted[magicLen : magicLen+c.saltSize]
+	if key != nil && bytes.Equal(salt, newSalt) {
+		return key, salt, nil
 	}
-	if len(p.Salts) == 0 {
-		p.Salts = [][]byte{nil}
+
+	if len(passphrase) == 0 && !passphraseOptional(newSalt) {
+		return nil, nil, ErrWrongPassphrase
 	}
-	if len(p.IVs) == 0 {
-		p.IVs = [][]byte{nil}
+
+	key, err := c.keygen(c, passphrase, newSalt)
+	if err != nil {
+		return nil, nil, err
 	}
-	p.Keys[0] = key
-	p.Salts[0] = salt
-	p.IVs[0] = iv
-	return p, plaintext, nil
+
+	return key, newSalt, nil
 }
 
 func decryptV1Multi(c config, nUsers int, user, passphrase, key, salt, encrypted []byte) (p Params, plaintext []byte, err error) {
+	p, header, err := decryptUsersV1(c, nUsers, user, passphrase, key, salt, encrypted)
+	if err != nil {
+		return p, nil, err
+	}
+
+	suite, err := cipherSuite(c)
+	if err != nil {
+		return p, nil, err
+	}
+
+	// Use the decrypted master key to instantiate the cipher suite
+	ciphers, err := makeCiphers(p.Master, suite)
+	if err != nil {
+		return p, nil, err
+	}
+
+	// Copy the ciphertext (everything after the header) to where we can
+	// decode it
+	ciphertext := make([]byte, len(encrypted)-len(header))
+	copy(ciphertext, encrypted[len(header):])
+
+	iv := p.IVM
+	ivOffset := len(iv)
+	for i := len(ciphers) - 1; i >= 0; i-- {
+		c := ciphers[i]
+
+		cipherBlockSize := c.BlockSize()
+		// Read iv encrypted reverse since we're doing each algorithm encrypted reverse now
+		cbc := cipher.NewCBCDecrypter(c, iv[ivOffset-cipherBlockSize:ivOffset])
+		ivOffset -= cipherBlockSize
+
+		// decrypt & discard padding
+		cbc.CryptBlocks(ciphertext, ciphertext)
+		ciphertext, err = pkcs7.Unpad(ciphertext)
+		if err != nil {
+			// We assume we aren't getting padding failures unless we've
+			// been given the wrong passphrase to deal with.
+			return p, nil, ErrWrongPassphrase
+		}
+	}
+
+	oldHash := ciphertext[:sha512.Size]
+	plaintext = ciphertext[sha512.Size:]
+
+	newHash := sha512.New()
+	_, _ = newHash.Write(header)
+	_, _ = newHash.Write(plaintext)
+	shaSum := newHash.Sum(nil)
+
+	if !bytes.Equal(shaSum, oldHash) {
+		return p, nil, ErrWrongPassphrase
+	}
+
+	return p, plaintext, nil
+}
+
+// decryptUsersV1 reads the users from a multi-user file's header and
+// decrypts the master key with the user's key. The header it returns is
+// everything before the payload.
+func decryptUsersV1(c config, nUsers int, user, passphrase, key, salt, encrypted []byte) (p Params, header []byte, err error) {
 	p.NUsers = nUsers
 	p.User = -1
 
 	s := sha256.Sum256(user)
 	userHash := s[:]
 
+	userSize := sha256.Size + c.saltSize + c.blockSize + c.keySize
+	headerLen := magicLen + userSize*nUsers + c.blockSize
+	if len(encrypted) < headerLen {
+		return p, nil, ErrInvalidFileFormat
+	}
+	header = encrypted[:headerLen]
 	plaintextHeader := encrypted[magicLen:]
 
 	for i := 0; i < nUsers; i++ {
@@ -340,9 +414,14 @@ func decryptV1Multi(c config, nUsers int, user, passphrase, key, salt, encrypted
 	if p.User < 0 {
 		// Here we could return ErrUnknownUser, but this is bad
 		// for security, lets just create some 0 bytes for the headers etc
-		// and let the cryptography fail.
+		// and let the cryptography fail. The salt has to be one that a key
+		// can be derived from though.
+		dummySalt, err := c.newSalt(c, defaultKDFParams(c.version))
+		if err != nil {
+			return p, nil, err
+		}
 		p.Keys = append(p.Keys, nil)
-		p.Salts = append(p.Salts, make([]byte, c.saltSize))
+		p.Salts = append(p.Salts, dummySalt)
 		p.IVs = append(p.IVs, make([]byte, c.blockSize))
 		p.MKeys = append(p.MKeys, make([]byte, c.keySize))
 		p.User = len(p.Keys) - 1
@@ -353,10 +432,9 @@ func decryptV1Multi(c config, nUsers int, user, passphrase, key, salt, encrypted
 
 	p.IVM = make([]byte, c.blockSize)
 	copy(p.IVM, plaintextHeader[:c.blockSize])
-	plaintextHeader = plaintextHeader[c.blockSize:]
 
 	if len(key) == 0 || !bytes.Equal(salt, p.Salts[p.User]) {
-		if len(passphrase) == 0 {
+		if len(passphrase) == 0 && !passphraseOptional(p.Salts[p.User]) {
 			return p, nil, ErrWrongPassphrase
 		}
 		// The salt was changed so the resulting key won't be the same as
@@ -397,53 +475,77 @@ func decryptV1Multi(c config, nUsers int, user, passphrase, key, salt, encrypted
 		cbc.CryptBlocks(p.Master, p.Master)
 	}
 
-	// Use the decrypted master key to instantiate the cipher suite
-	ciphers, err = makeCiphers(p.Master, suite)
+	return p, header, nil
+}
+
+// fileUsersV1 reads the number of users from the header, 0 is a single-user
+// file
+func fileUsersV1(encrypted []byte) (int, error) {
+	i, err := strconv.ParseInt(string(encrypted[12:16]), 10, 32)
 	if err != nil {
-		return p, nil, err
+		return 0, ErrInvalidFileFormat
 	}
 
-	// Copy the ciphertext (remainder of plaintextHeader) to where we can
-	// decode it
-	ciphertext := make([]byte, len(plaintextHeader))
-	copy(ciphertext, plaintextHeader)
-
-	iv = p.IVM
-	ivOffset = len(iv)
-	for i := len(ciphers) - 1; i >= 0; i-- {
-		c := ciphers[i]
+	return int(i), nil
+}
 
-		cipherBlockSize := c.BlockSize()
-		// Read iv encrypted reverse since we're doing each algorithm encrypted reverse now
-		cbc := cipher.NewCBCDecrypter(c, iv[ivOffset-cipherBlockSize:ivOffset])
-		ivOffset -= cipherBlockSize
+// saltV1 finds the salt for the user by walking the plaintext header
+func saltV1(c config, user, encrypted []byte) ([]byte, error) {
+	nUsers, err := fileUsersV1(encrypted)
+	if err != nil {
+		return nil, err
+	}
 
-		// decrypt & discard padding
-		cbc.CryptBlocks(ciphertext, ciphertext)
-		ciphertext, err = pkcs7.Unpad(ciphertext)
-		if err != nil {
-			// We assume we aren't getting padding failures unless we've
-			// been given the wrong passphrase to deal with.
-			return p, nil, ErrWrongPassphrase
+	salt := make([]byte, c.saltSize)
+	if nUsers == 0 {
+		if len(encrypted) < magicLen+c.saltSize {
+			return nil, ErrInvalidFileFormat
 		}
+
+		copy(salt, encrypted[magicLen:])
+		return salt, nil
 	}
 
+	if len(user) == 0 {
+		return nil, ErrNeedUser
+	}
+
+	userHash := sha256.Sum256(user)
 	userSize := sha256.Size + c.saltSize + c.blockSize + c.keySize
-	oldHash := ciphertext[:sha512.Size]
-	plaintext = ciphertext[sha512.Size:]
+	plaintextHeader := encrypted[magicLen:]
+	for i := 0; i < nUsers; i++ {
+		if len(plaintextHeader) < userSize {
+			return nil, ErrInvalidFileFormat
+		}
 
-	newHash := sha512.New()
-	_, _ = newHash.Write(encrypted[:magicLen+(userSize*p.NUsers)+c.blockSize])
-	_, _ = newHash.Write(plaintext)
-	shaSum := newHash.Sum(nil)
+		if bytes.Equal(userHash[:], plaintextHeader[:sha256.Size]) {
+			copy(salt, plaintextHeader[sha256.Size:])
+			return salt, nil
+		}
 
-	if !bytes.Equal(shaSum, oldHash) {
-		return p, nil, ErrWrongPassphrase
+		plaintextHeader = plaintextHeader[userSize:]
 	}
 
-	return p, plaintext, nil
+	return nil, ErrUnknownUser
 }
 
+// deriveKeyV1 uses scrypt, it's important to note that the parallelism
+// parameter is part of the file format (changing it changes the key)
+// so it cannot be tuned to the number of cores available.
 func deriveKeyV1(c config, passphrase, salt []byte) ([]byte, error) {
 	return scrypt.Key(passphrase, salt, 524288 /* 2<<18 */, 8, 1, c.keySize)
 }
+
+// newSaltV1 is purely random, there's nothing to configure for scrypt
+func newSaltV1(c config, kdf KDFParams) ([]byte, error) {
+	if kdf.Factor != FactorNone {
+		return nil, errors.New("version 1 keys can't have a second factor")
+	}
+
+	salt := make([]byte, c.saltSize)
+	if n, err := rand.Read(salt); n != c.saltSize || err != nil {
+		return nil, fmt.Errorf("failed to get randomness for salt: %w", err)
+	}
+
+	return salt, nil
+}
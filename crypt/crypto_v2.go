@@ -0,0 +1,70 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// saltRandomSize is the random part of every salt
+	saltRandomSize = 32
+	// kdfParamsSize is the size of the encoded KDFParams at the end of
+	// v2 salts: 4:time|4:memory|1:threads
+	kdfParamsSize = 9
+)
+
+// newSaltV2 creates this format:
+// 32:random|4:time|4:memory|1:(3 bits factor, 5 bits threads)
+// Putting the argon2 params in the salt means the file format stays the
+// same as v1 and each user in a multi-user file can have their own.
+func newSaltV2(c config, kdf KDFParams) ([]byte, error) {
+	if err := kdf.Validate(); err != nil {
+		return nil, err
+	}
+	if kdf.Version() != kdfVersion(c.version) {
+		return nil, fmt.Errorf("%s kdf params are for version %d", kdf.Algorithm, kdf.Version())
+	}
+
+	salt := make([]byte, c.saltSize)
+	if n, err := rand.Read(salt[:saltRandomSize]); n != saltRandomSize || err != nil {
+		return nil, fmt.Errorf("failed to get randomness for salt: %w", err)
+	}
+
+	params := salt[saltRandomSize:]
+	binary.BigEndian.PutUint32(params, kdf.Time)
+	binary.BigEndian.PutUint32(params[4:], kdf.Memory)
+	params[8] = kdf.Threads | byte(kdf.Factor)<<kdfFactorShift
+
+	return salt, nil
+}
+
+// kdfParamsV2 reads the params out of a v2 salt
+func kdfParamsV2(salt []byte) (KDFParams, error) {
+	if len(salt) != saltRandomSize+kdfParamsSize {
+		return KDFParams{}, ErrInvalidSalt
+	}
+
+	params := salt[saltRandomSize:]
+	k := KDFParams{
+		Algorithm: KDFArgon2id,
+		Time:      binary.BigEndian.Uint32(params),
+		Memory:    binary.BigEndian.Uint32(params[4:]),
+		Threads:   params[8] & kdfThreadsMask,
+		Factor:    Factor(params[8] >> kdfFactorShift),
+	}
+
+	return k, k.Validate()
+}
+
+// deriveKeyV2 uses argon2id with the params stored in the salt, the
+// whole salt (params included) is used as the argon2 salt so they can't be
+// changed without changing the key.
+func deriveKeyV2(c config, passphrase, salt []byte) ([]byte, error) {
+	kdf, err := kdfParamsV2(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return deriveKDF(kdf, passphrase, salt, c.keySize)
+}
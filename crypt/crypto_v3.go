@@ -0,0 +1,66 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+)
+
+// scryptParamsSize is the size of the encoded scrypt KDFParams at the end
+// of v3 salts: 4:n|1:r|1:p
+const scryptParamsSize = 6
+
+// newSaltV3 creates this format:
+// 32:random|4:n|1:r|1:(3 bits factor, 5 bits p)
+// Version 3 is version 2 with scrypt in place of argon2id for places where
+// argon2 isn't available or scrypt is required.
+func newSaltV3(c config, kdf KDFParams) ([]byte, error) {
+	if err := kdf.Validate(); err != nil {
+		return nil, err
+	}
+	if kdf.Version() != kdfVersion(c.version) {
+		return nil, fmt.Errorf("%s kdf params are for version %d", kdf.Algorithm, kdf.Version())
+	}
+
+	salt := make([]byte, c.saltSize)
+	if n, err := rand.Read(salt[:saltRandomSize]); n != saltRandomSize || err != nil {
+		return nil, fmt.Errorf("failed to get randomness for salt: %w", err)
+	}
+
+	params := salt[saltRandomSize:]
+	binary.BigEndian.PutUint32(params, kdf.N)
+	params[4] = kdf.R
+	params[5] = kdf.P | byte(kdf.Factor)<<kdfFactorShift
+
+	return salt, nil
+}
+
+// kdfParamsV3 reads the params out of a v3 salt
+func kdfParamsV3(salt []byte) (KDFParams, error) {
+	if len(salt) != saltRandomSize+scryptParamsSize {
+		return KDFParams{}, ErrInvalidSalt
+	}
+
+	params := salt[saltRandomSize:]
+	k := KDFParams{
+		Algorithm: KDFScrypt,
+		N:         binary.BigEndian.Uint32(params),
+		R:         params[4],
+		P:         params[5] & kdfThreadsMask,
+		Factor:    Factor(params[5] >> kdfFactorShift),
+	}
+
+	return k, k.Validate()
+}
+
+// deriveKeyV3 uses scrypt with the params stored in the salt, like v2 the
+// whole salt is used so the params can't be changed without changing the
+// key.
+func deriveKeyV3(c config, passphrase, salt []byte) ([]byte, error) {
+	kdf, err := kdfParamsV3(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return deriveKDF(kdf, passphrase, salt, c.keySize)
+}
@@ -0,0 +1,126 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// aeadInfo separates the chacha20poly1305 key from the cascade's, the same
+// key is used by both
+const aeadInfo = "bpass xchacha20poly1305"
+
+// newAEADV4 creates the aead for a key. Keys are the size of the cascade's
+// so that they (and master keys) can move between versions without a rekey,
+// hkdf turns them into a key of the right size.
+func newAEADV4(key []byte) (cipher.AEAD, error) {
+	aeadKey := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(aeadInfo)), aeadKey); err != nil {
+		return nil, err
+	}
+
+	return chacha20poly1305.NewX(aeadKey)
+}
+
+// encryptV4 creates these formats which are the same as v1's up until the
+// payload:
+// 8:magic|4:version|4:0|saltSize:salt|24:nonce|(data|16:tag)
+// 8:magic|4:version|4:nusers|32:u1|saltSize:s1|blockSize:iv1|keySize:(mk)|...|blockSize:ivm|24:nonce|(data|16:tag)
+// The payload is encrypted with xchacha20poly1305 instead of the cascade,
+// the master keys are still encrypted with the cascade so a file can switch
+// between versions without every user's key. The ivm isn't used but it's
+// kept for the same reason. The nonce is random since the file is saved
+// with the same key many times, everything before the payload is
+// authenticated.
+func encryptV4(c config, p *Params, plaintext []byte) (encrypted []byte, err error) {
+	var header, key []byte
+	if p.NUsers == 0 {
+		if len(p.Keys[0]) != c.keySize {
+			return nil, ErrInvalidKey
+		}
+		if len(p.Salts[0]) != c.saltSize {
+			return nil, ErrInvalidSalt
+		}
+
+		header = make([]byte, magicLen+c.saltSize)
+		copy(header, fmt.Sprintf("%s%04d%04d", magicStr, c.version, 0))
+		copy(header[magicLen:], p.Salts[0])
+		key = p.Keys[0]
+	} else {
+		if header, err = multiHeaderV1(c, p); err != nil {
+			return nil, err
+		}
+		key = p.Master
+	}
+
+	aead, err := newAEADV4(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to get randomness for nonce: %w", err)
+	}
+	header = append(header, nonce...)
+
+	return append(header, aead.Seal(nil, nonce, plaintext, header)...), nil
+}
+
+func decryptV4(c config, user, passphrase, key, salt, encrypted []byte) (p Params, plaintext []byte, err error) {
+	nUsers, err := fileUsersV1(encrypted)
+	if err != nil {
+		return p, nil, err
+	}
+	if nUsers != 0 && len(user) == 0 {
+		return p, nil, ErrNeedUser
+	}
+
+	var header []byte
+	if nUsers == 0 {
+		if len(encrypted) < magicLen+c.saltSize {
+			return p, nil, ErrInvalidFileFormat
+		}
+
+		key, salt, err = singleKeyV1(c, passphrase, key, salt, encrypted)
+		if err != nil {
+			return p, nil, err
+		}
+
+		header = encrypted[:magicLen+c.saltSize]
+		p.Keys = [][]byte{key}
+		p.Salts = [][]byte{salt}
+	} else {
+		p, header, err = decryptUsersV1(c, nUsers, user, passphrase, key, salt, encrypted)
+		if err != nil {
+			return p, nil, err
+		}
+		key = p.Master
+	}
+
+	aead, err := newAEADV4(key)
+	if err != nil {
+		return p, nil, err
+	}
+
+	if len(encrypted) < len(header)+aead.NonceSize()+aead.Overhead() {
+		return p, nil, ErrInvalidFileFormat
+	}
+	header = encrypted[:len(header)+aead.NonceSize()]
+	nonce := header[len(header)-aead.NonceSize():]
+
+	plaintext, err = aead.Open(nil, nonce, encrypted[len(header):], header)
+	if err != nil {
+		return p, nil, ErrWrongPassphrase
+	}
+
+	if nUsers == 0 {
+		p.IVs = [][]byte{nonce}
+	}
+	return p, plaintext, nil
+}
@@ -0,0 +1,136 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestCipherVersion(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Version int
+		Cipher  string
+		Want    int
+		Err     bool
+	}{
+		{Version: 2, Cipher: CipherCascade, Want: 2},
+		{Version: 2, Cipher: CipherChaCha20Poly1305, Want: 4},
+		{Version: 3, Cipher: CipherChaCha20Poly1305, Want: 5},
+		{Version: 4, Cipher: CipherCascade, Want: 2},
+		{Version: 5, Cipher: CipherChaCha20Poly1305, Want: 5},
+		{Version: 1, Cipher: CipherCascade, Want: 1},
+		{Version: 1, Cipher: CipherChaCha20Poly1305, Err: true},
+		{Version: 2, Cipher: "rot13", Err: true},
+		{Version: 99, Cipher: CipherCascade, Err: true},
+	}
+
+	for i, test := range tests {
+		got, err := CipherVersion(test.Version, test.Cipher)
+		if test.Err {
+			if err == nil {
+				t.Errorf("%d) want an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d) %v", i, err)
+		} else if got != test.Want {
+			t.Errorf("%d) want: %d, got: %d", i, test.Want, got)
+		}
+		if cipher := VersionCipher(got); cipher != test.Cipher {
+			t.Errorf("%d) cipher was wrong: %s", i, cipher)
+		}
+	}
+}
+
+func TestSwitchCipher(t *testing.T) {
+	t.Parallel()
+
+	kdf := KDFParams{Algorithm: KDFArgon2id, Time: 1, Memory: kdfMinMemory, Threads: 1}
+	plaintext := []byte("plaintext goes here")
+
+	key, salt, err := DeriveKeyParams(2, []byte("hunter42"), kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := SaltVersion(salt); err != nil || v != 2 {
+		t.Error("salt version was wrong:", v, err)
+	}
+
+	master, ivm, err := NewMasterKey(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mkey, iv, err := EncryptMasterKey(2, key, master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userSum := sha256.Sum256([]byte("user"))
+
+	single := &Params{Keys: [][]byte{key}, Salts: [][]byte{salt}}
+	multi := &Params{
+		NUsers: 1,
+		Users:  [][]byte{userSum[:]},
+		Keys:   [][]byte{key},
+		Salts:  [][]byte{salt},
+		IVs:    [][]byte{iv},
+		MKeys:  [][]byte{mkey},
+		IVM:    ivm,
+		Master: master,
+	}
+
+	for _, p := range []*Params{single, multi} {
+		var user []byte
+		if p.NUsers != 0 {
+			user = []byte("user")
+		}
+
+		// The same key and master key work with both ciphers
+		for _, v := range []int{2, 4, 2} {
+			ct, err := Encrypt(v, p, plaintext)
+			if err != nil {
+				t.Fatalf("%d) %v", v, err)
+			}
+			if bytes.Contains(ct, plaintext) {
+				t.Errorf("%d) the plain text is visible", v)
+			}
+
+			version, got, pt, err := Decrypt(user, nil, key, salt, ct)
+			if err != nil {
+				t.Fatalf("%d) %v", v, err)
+			}
+			if version != v || !bytes.Equal(pt, plaintext) {
+				t.Errorf("%d) decrypted wrong: %d %s", v, version, pt)
+			}
+			if p.NUsers != 0 && !bytes.Equal(got.Master, master) {
+				t.Errorf("%d) master key was wrong", v)
+			}
+
+			if v != 4 {
+				continue
+			}
+
+			// Encrypting again must not reuse the nonce
+			again, err := Encrypt(v, p, plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(again, ct) {
+				t.Error("encrypting twice gave the same ciphertext")
+			}
+
+			// The header is authenticated
+			tampered := append([]byte(nil), ct...)
+			tampered[magicLen]++
+			if _, _, _, err = Decrypt(user, []byte("hunter42"), key, salt, tampered); err == nil {
+				t.Error("want an error for a tampered header")
+			}
+
+			if _, _, _, err = Decrypt(user, []byte("hunter41"), nil, nil, ct); err != ErrWrongPassphrase {
+				t.Error("want wrong passphrase, got:", err)
+			}
+		}
+	}
+}
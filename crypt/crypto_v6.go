@@ -0,0 +1,401 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// slotPadding is what slot plaintexts are padded to a multiple of
+	slotPadding = 4096
+	// slotLenSize is the size of the ciphertext and plaintext lengths
+	slotLenSize = 4
+	// slotNonceSize is the size of the xchacha20poly1305 nonces
+	slotNonceSize = 24
+	// slotTagSize is the size of the poly1305 tag
+	slotTagSize = 16
+	// slotKeySize is the size of a wrapped payload key with its nonce
+	slotKeySize = slotNonceSize + chacha20poly1305.KeySize + slotTagSize
+)
+
+// ErrSlotsMultiUser is returned when encrypting a multi-user file with a
+// version that has slots
+var ErrSlotsMultiUser = errors.New("only single-user files can have a second slot")
+
+// encryptV6 creates this format:
+// 8:magic|4:version|4:0|slot|slot
+// slot = saltSize:salt|24:knonce|48:(payloadKey|16:tag)|4:ctLen|24:nonce|ctLen:(4:len|data|padding|16:tag)
+//
+// Each slot's payload is encrypted with xchacha20poly1305 and a random
+// payload key (Params.PayloadKey), the payload key is wrapped with a key
+// derived from the slot's own salt. A passphrase opens whichever slot its
+// key unwraps, changing it only needs the payload key wrapped again (see
+// Rewrap). The other slot is either a second dataset (a decoy) or filler
+// that's made to look the same: a salt with the same kdf params and random
+// bytes the size of the first slot. It's kept verbatim (Params.Other) so a
+// file's slots can't be told apart without a key that opens them.
+func encryptV6(c config, p *Params, plaintext []byte) ([]byte, error) {
+	if p.NUsers != 0 {
+		return nil, ErrSlotsMultiUser
+	}
+	if len(p.Keys[0]) != c.keySize {
+		return nil, ErrInvalidKey
+	}
+	if len(p.Salts[0]) != c.saltSize {
+		return nil, ErrInvalidSalt
+	}
+
+	if p.PayloadKey == nil {
+		p.PayloadKey = make([]byte, chacha20poly1305.KeySize)
+		if _, err := io.ReadFull(rand.Reader, p.PayloadKey); err != nil {
+			return nil, fmt.Errorf("failed to get randomness for payload key: %w", err)
+		}
+	} else if len(p.PayloadKey) != chacha20poly1305.KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	header := []byte(fmt.Sprintf("%s%04d%04d", magicStr, c.version, 0))
+
+	ours, err := newSlotV6(c, header, p.Keys[0], p.Salts[0], p.PayloadKey, plaintext, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Other == nil {
+		if p.Other, err = fillerSlotV6(c, p.Salts[0], len(ours)); err != nil {
+			return nil, err
+		}
+
+		var b [1]byte
+		if _, err = io.ReadFull(rand.Reader, b[:]); err != nil {
+			return nil, fmt.Errorf("failed to get randomness for slot: %w", err)
+		}
+		p.Slot = int(b[0] & 1)
+	} else if _, err = splitSlotV6(c, p.Other); err != nil {
+		return nil, err
+	}
+
+	return joinSlotsV6(header, p.Slot, ours, p.Other), nil
+}
+
+// joinSlotsV6 puts ours at index slot and other in the remaining one
+func joinSlotsV6(header []byte, slot int, ours, other []byte) []byte {
+	encrypted := make([]byte, 0, len(header)+len(ours)+len(other))
+	encrypted = append(encrypted, header...)
+	if slot == 0 {
+		return append(append(encrypted, ours...), other...)
+	}
+	return append(append(encrypted, other...), ours...)
+}
+
+// slotHeaderSizeV6 is the size of a slot before its payload ciphertext
+func slotHeaderSizeV6(c config) int {
+	return c.saltSize + slotKeySize + slotLenSize + slotNonceSize
+}
+
+// newSlotV6 encrypts a slot, the ciphertext is at least minSize bytes
+func newSlotV6(c config, header, key, salt, payloadKey, plaintext []byte, minSize int) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(payloadKey)
+	if err != nil {
+		return nil, err
+	}
+
+	size := slotLenSize + len(plaintext)
+	size += slotPadding - size%slotPadding
+	if size+aead.Overhead() < minSize {
+		size = minSize - aead.Overhead()
+	}
+
+	padded := make([]byte, size)
+	binary.BigEndian.PutUint32(padded, uint32(len(plaintext)))
+	copy(padded[slotLenSize:], plaintext)
+
+	headerSize := slotHeaderSizeV6(c)
+	slot := make([]byte, headerSize, headerSize+size+aead.Overhead())
+	if err = wrapKeyV6(c, header, slot, key, salt, payloadKey); err != nil {
+		return nil, err
+	}
+
+	lenOff := c.saltSize + slotKeySize
+	binary.BigEndian.PutUint32(slot[lenOff:], uint32(size+aead.Overhead()))
+	nonce := slot[lenOff+slotLenSize:]
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to get randomness for nonce: %w", err)
+	}
+
+	ad := append(append([]byte{}, header...), slot[lenOff:lenOff+slotLenSize]...)
+	return aead.Seal(slot, nonce, padded, ad), nil
+}
+
+// wrapKeyV6 writes salt and payloadKey wrapped with key into the start of
+// slot, the rest of the slot is untouched
+func wrapKeyV6(c config, header, slot, key, salt, payloadKey []byte) error {
+	aead, err := newAEADV4(key)
+	if err != nil {
+		return err
+	}
+
+	copy(slot, salt)
+	nonce := slot[c.saltSize : c.saltSize+slotNonceSize]
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("failed to get randomness for nonce: %w", err)
+	}
+
+	ad := append(append([]byte{}, header...), salt...)
+	aead.Seal(slot[c.saltSize+slotNonceSize:c.saltSize+slotNonceSize], nonce, payloadKey, ad)
+	return nil
+}
+
+// fillerSlotV6 makes a slot of random bytes the same size as a real one with
+// a salt that has the same kdf params as salt
+func fillerSlotV6(c config, salt []byte, size int) ([]byte, error) {
+	kdf, err := SaltKDFParams(salt)
+	if err != nil {
+		return nil, err
+	}
+	fillerSalt, err := c.newSalt(c, kdf)
+	if err != nil {
+		return nil, err
+	}
+
+	slot := make([]byte, size)
+	if _, err = io.ReadFull(rand.Reader, slot); err != nil {
+		return nil, fmt.Errorf("failed to get randomness for slot: %w", err)
+	}
+	copy(slot, fillerSalt)
+	binary.BigEndian.PutUint32(slot[c.saltSize+slotKeySize:], uint32(size-slotHeaderSizeV6(c)))
+
+	return slot, nil
+}
+
+// splitSlotV6 returns the length of the slot at the start of b
+func splitSlotV6(c config, b []byte) (int, error) {
+	if len(b) < slotHeaderSizeV6(c) {
+		return 0, ErrInvalidFileFormat
+	}
+
+	size := slotHeaderSizeV6(c) + int(binary.BigEndian.Uint32(b[c.saltSize+slotKeySize:]))
+	if size > len(b) {
+		return 0, ErrInvalidFileFormat
+	}
+
+	return size, nil
+}
+
+// slotsV6 splits a file into its two slots
+func slotsV6(c config, encrypted []byte) (slots [2][]byte, err error) {
+	nUsers, err := fileUsersV1(encrypted)
+	if err != nil {
+		return slots, err
+	}
+	if nUsers != 0 {
+		return slots, ErrInvalidFileFormat
+	}
+
+	rest := encrypted[magicLen:]
+	for i := range slots {
+		size, err := splitSlotV6(c, rest)
+		if err != nil {
+			return slots, err
+		}
+		slots[i], rest = rest[:size], rest[size:]
+	}
+	if len(rest) != 0 {
+		return slots, ErrInvalidFileFormat
+	}
+
+	return slots, nil
+}
+
+// openSlotV6 unwraps the payload key of a slot and decrypts its payload
+func openSlotV6(c config, header, key, slot []byte) (payloadKey, plaintext []byte, err error) {
+	wrap, err := newAEADV4(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	salt := slot[:c.saltSize]
+	nonce := slot[c.saltSize : c.saltSize+slotNonceSize]
+	ad := append(append([]byte{}, header...), salt...)
+	payloadKey, err = wrap.Open(nil, nonce, slot[c.saltSize+slotNonceSize:c.saltSize+slotKeySize], ad)
+	if err != nil {
+		return nil, nil, ErrWrongPassphrase
+	}
+
+	aead, err := chacha20poly1305.NewX(payloadKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The key was unwrapped so the payload failing is corruption, not a
+	// wrong passphrase
+	lenOff := c.saltSize + slotKeySize
+	nonce = slot[lenOff+slotLenSize : lenOff+slotLenSize+slotNonceSize]
+	ad = append(append([]byte{}, header...), slot[lenOff:lenOff+slotLenSize]...)
+	padded, err := aead.Open(nil, nonce, slot[slotHeaderSizeV6(c):], ad)
+	if err != nil {
+		return nil, nil, ErrInvalidFileFormat
+	}
+
+	if len(padded) < slotLenSize {
+		return nil, nil, ErrInvalidFileFormat
+	}
+	n := int(binary.BigEndian.Uint32(padded))
+	if n > len(padded)-slotLenSize {
+		return nil, nil, ErrInvalidFileFormat
+	}
+
+	return payloadKey, padded[slotLenSize : slotLenSize+n], nil
+}
+
+// decryptV6 tries the key against both slots, or derives a key from the
+// passphrase for each slot's salt. Both keys are always derived so opening
+// takes as long whichever slot opens (or if there's no second dataset).
+func decryptV6(c config, user, passphrase, key, salt, encrypted []byte) (p Params, plaintext []byte, err error) {
+	slots, err := slotsV6(c, encrypted)
+	if err != nil {
+		return p, nil, err
+	}
+	header := encrypted[:magicLen]
+
+	var keys [2][]byte
+	if len(key) != 0 {
+		keys = [2][]byte{key, key}
+	} else if len(passphrase) != 0 || passphraseOptional(slots[0][:c.saltSize]) || passphraseOptional(slots[1][:c.saltSize]) {
+		for i, slot := range slots {
+			if keys[i], err = c.keygen(c, passphrase, slot[:c.saltSize]); err != nil {
+				return p, nil, err
+			}
+		}
+	}
+
+	for i, slot := range slots {
+		if keys[i] == nil {
+			continue
+		}
+
+		payloadKey, pt, err := openSlotV6(c, header, keys[i], slot)
+		if err == ErrWrongPassphrase {
+			continue
+		} else if err != nil {
+			return p, nil, err
+		}
+
+		p.Keys = [][]byte{keys[i]}
+		p.Salts = [][]byte{append([]byte{}, slot[:c.saltSize]...)}
+		p.PayloadKey = payloadKey
+		p.Slot = i
+		p.Other = append([]byte{}, slots[1-i]...)
+		return p, pt, nil
+	}
+
+	// A key that opens neither slot is treated like v1's fast path, the
+	// passphrase is tried next
+	if len(key) != 0 && len(passphrase) != 0 {
+		return decryptV6(c, user, passphrase, nil, nil, encrypted)
+	}
+
+	return p, nil, ErrWrongPassphrase
+}
+
+// saltV6 returns the first slot's salt, the key for the other slot can be
+// used with it in Decrypt's fast path since both slots are tried
+func saltV6(c config, user, encrypted []byte) ([]byte, error) {
+	slots, err := slotsV6(c, encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{}, slots[0][:c.saltSize]...), nil
+}
+
+// NewSlot encrypts plaintext for the second slot of a file in a version
+// with slots (see SlotVersion), pass it to Encrypt as Params.Other. Its
+// ciphertext is made at least as big as other's so it can replace it
+// without the file changing size.
+func NewSlot(version int, key, salt, other, plaintext []byte) ([]byte, error) {
+	c, err := getVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := slotVersionOf(version); !ok {
+		return nil, fmt.Errorf("version %d doesn't have slots", version)
+	}
+	if len(key) != c.keySize {
+		return nil, ErrInvalidKey
+	}
+	if len(salt) != c.saltSize {
+		return nil, ErrInvalidSalt
+	}
+
+	var minSize int
+	if len(other) != 0 {
+		if minSize, err = splitSlotV6(c, other); err != nil {
+			return nil, err
+		}
+		minSize -= slotHeaderSizeV6(c)
+	}
+
+	payloadKey := make([]byte, chacha20poly1305.KeySize)
+	if _, err = io.ReadFull(rand.Reader, payloadKey); err != nil {
+		return nil, fmt.Errorf("failed to get randomness for payload key: %w", err)
+	}
+
+	header := []byte(fmt.Sprintf("%s%04d%04d", magicStr, c.version, 0))
+	return newSlotV6(c, header, key, salt, payloadKey, plaintext, minSize)
+}
+
+// Rewrap wraps the payload key (Params.PayloadKey) of a file in a version
+// with slots with the key and salt in p without encrypting the payload
+// again, which is all that's needed when only the passphrase changed. The
+// payload is copied from the slot at p.Slot in encrypted and p.Other is put
+// in the other one, so p must be the params encrypted was decrypted or
+// encrypted with.
+func Rewrap(version int, p *Params, encrypted []byte) ([]byte, error) {
+	c, err := getVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := slotVersionOf(version); !ok {
+		return nil, fmt.Errorf("version %d has no payload key to rewrap", version)
+	}
+	if fileVersion, err := verifyMagic(encrypted); err != nil {
+		return nil, err
+	} else if fileVersion != version {
+		return nil, fmt.Errorf("can't rewrap a version %d file as version %d", fileVersion, version)
+	}
+
+	if err = p.validate(c); err != nil {
+		return nil, fmt.Errorf("params were invalid: %w", err)
+	}
+	if p.NUsers != 0 {
+		return nil, ErrSlotsMultiUser
+	}
+	if len(p.PayloadKey) != chacha20poly1305.KeySize {
+		return nil, ErrInvalidKey
+	}
+	if p.Slot != 0 && p.Slot != 1 {
+		return nil, errors.New("slot must be 0 or 1")
+	}
+	if _, err = splitSlotV6(c, p.Other); err != nil {
+		return nil, err
+	}
+
+	slots, err := slotsV6(c, encrypted)
+	if err != nil {
+		return nil, err
+	}
+	header := encrypted[:magicLen]
+
+	ours := append([]byte{}, slots[p.Slot]...)
+	if err = wrapKeyV6(c, header, ours, p.Keys[0], p.Salts[0], p.PayloadKey); err != nil {
+		return nil, err
+	}
+
+	return joinSlotsV6(header, p.Slot, ours, p.Other), nil
+}
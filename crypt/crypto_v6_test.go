@@ -0,0 +1,203 @@
+package crypt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSlotVersion(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Version int
+		Want    int
+		Err     bool
+	}{
+		{Version: 4, Want: 6},
+		{Version: 5, Want: 7},
+		{Version: 6, Want: 6},
+		{Version: 2, Err: true},
+		{Version: 1, Err: true},
+	}
+
+	for i, test := range tests {
+		got, err := SlotVersion(test.Version)
+		if test.Err {
+			if err == nil {
+				t.Errorf("%d) want an error", i)
+			}
+			continue
+		}
+		if err != nil || got != test.Want {
+			t.Errorf("%d) want: %d, got: %d %v", i, test.Want, got, err)
+		}
+	}
+
+	if VersionCipher(7) != CipherChaCha20Poly1305 {
+		t.Error("cipher of 7 was wrong")
+	}
+	if v, err := CipherVersion(6, CipherCascade); err != nil || v != 2 {
+		t.Error("cascade version of 6 was wrong:", v, err)
+	}
+}
+
+func TestSlots(t *testing.T) {
+	t.Parallel()
+
+	kdf := KDFParams{Algorithm: KDFArgon2id, Time: 1, Memory: kdfMinMemory, Threads: 1}
+	realData, decoyData := []byte("real data"), []byte("decoy data")
+
+	key, salt, err := DeriveKeyParams(6, []byte("hunter42"), kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Params{Keys: [][]byte{key}, Salts: [][]byte{salt}}
+	withFiller, err := Encrypt(6, p, realData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Other == nil {
+		t.Fatal("encrypt should have made filler")
+	}
+	filler := p.Other
+
+	_, got, pt, err := Decrypt(nil, []byte("hunter42"), nil, nil, withFiller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pt, realData) || got.Slot != p.Slot || !bytes.Equal(got.Other, filler) {
+		t.Error("decrypted the wrong slot")
+	}
+
+	// Saving again keeps the filler where it was
+	again, err := Encrypt(6, &got, realData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != len(withFiller) || !bytes.Contains(again, filler) {
+		t.Error("the filler should be kept verbatim")
+	}
+
+	// A decoy replaces the filler without changing the file's size
+	decoyKey, decoySalt, err := DeriveKeyParams(6, []byte("duress"), kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Other, err = NewSlot(6, decoyKey, decoySalt, got.Other, decoyData); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Other) != len(filler) {
+		t.Error("decoy slot size was different from the filler:", len(got.Other), len(filler))
+	}
+	withDecoy, err := Encrypt(6, &got, realData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(withDecoy) != len(withFiller) {
+		t.Error("file size changed")
+	}
+
+	_, realParams, pt, err := Decrypt(nil, []byte("hunter42"), nil, nil, withDecoy)
+	if err != nil || !bytes.Equal(pt, realData) {
+		t.Error("real passphrase opened the wrong slot:", err)
+	}
+	_, decoyParams, pt, err := Decrypt(nil, []byte("duress"), nil, nil, withDecoy)
+	if err != nil || !bytes.Equal(pt, decoyData) {
+		t.Error("decoy passphrase opened the wrong slot:", err)
+	}
+	if realParams.Slot == decoyParams.Slot {
+		t.Error("both passphrases opened the same slot")
+	}
+
+	// Keys work with the fast path whichever salt they're given with
+	salt0, err := Salt(nil, withDecoy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range [][]byte{key, decoyKey} {
+		if _, _, _, err = Decrypt(nil, nil, k, salt0, withDecoy); err != nil {
+			t.Error("fast path failed:", err)
+		}
+	}
+
+	if _, _, _, err = Decrypt(nil, []byte("wrong"), nil, nil, withDecoy); err != ErrWrongPassphrase {
+		t.Error("want wrong passphrase, got:", err)
+	}
+
+	// Saving from the decoy keeps the real slot
+	saved, err := Encrypt(6, &decoyParams, []byte("more decoy data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, pt, err = Decrypt(nil, nil, key, nil, saved); err != nil || !bytes.Equal(pt, realData) {
+		t.Error("real slot was lost:", err)
+	}
+
+	multi := &Params{NUsers: 1, Keys: [][]byte{key}, Salts: [][]byte{salt}}
+	if _, err = encryptV6(versions[6], multi, realData); err != ErrSlotsMultiUser {
+		t.Error("want multi-user error, got:", err)
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	t.Parallel()
+
+	kdf := KDFParams{Algorithm: KDFArgon2id, Time: 1, Memory: kdfMinMemory, Threads: 1}
+	data := []byte("data")
+
+	key, salt, err := DeriveKeyParams(6, []byte("hunter42"), kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Params{Keys: [][]byte{key}, Salts: [][]byte{salt}}
+	encrypted, err := Encrypt(6, p, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.PayloadKey) == 0 {
+		t.Fatal("encrypt should have made a payload key")
+	}
+
+	_, got, _, err := Decrypt(nil, []byte("hunter42"), nil, nil, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.PayloadKey, p.PayloadKey) {
+		t.Error("decrypt returned the wrong payload key")
+	}
+
+	newKey, newSalt, err := DeriveKeyParams(6, []byte("hunter43"), kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Keys, got.Salts = [][]byte{newKey}, [][]byte{newSalt}
+	rewrapped, err := Rewrap(6, &got, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rewrapped) != len(encrypted) {
+		t.Error("size changed")
+	}
+	payload := encrypted[magicLen+got.Slot*len(got.Other)+slotHeaderSizeV6(versions[6]):]
+	payload = payload[:len(got.Other)-slotHeaderSizeV6(versions[6])]
+	if !bytes.Contains(rewrapped, payload) || !bytes.Contains(rewrapped, got.Other) {
+		t.Error("the payload and other slot should be kept verbatim")
+	}
+
+	if _, _, pt, err := Decrypt(nil, []byte("hunter43"), nil, nil, rewrapped); err != nil || !bytes.Equal(pt, data) {
+		t.Error("new passphrase didn't open it:", err)
+	}
+	if _, _, _, err = Decrypt(nil, []byte("hunter42"), nil, nil, rewrapped); err != ErrWrongPassphrase {
+		t.Error("old passphrase should not open it:", err)
+	}
+
+	if _, err = Rewrap(4, &got, encrypted); err == nil {
+		t.Error("versions without slots can't be rewrapped")
+	}
+	got.PayloadKey = nil
+	if _, err = Rewrap(6, &got, encrypted); err != ErrInvalidKey {
+		t.Error("want invalid key without a payload key, got:", err)
+	}
+}
@@ -0,0 +1,348 @@
+package crypt
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/bits"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Key derivation algorithms for KDFParams
+const (
+	KDFArgon2id = "argon2id"
+	KDFScrypt   = "scrypt"
+)
+
+// Limits on KDFParams, these stop a file that was tampered with from making
+// us allocate all the memory in the machine or spin forever
+const (
+	kdfMinMemory  = 8 * 1024
+	kdfMaxMemory  = 4 * 1024 * 1024
+	kdfMaxTime    = 1000
+	kdfMaxThreads = 31
+
+	// scrypt uses 128*N*R bytes
+	kdfMinScryptN = 1 << 14
+	kdfMaxScryptR = 32
+
+	// kdfTuneThreads is the most threads TuneKDF will pick, more than this
+	// makes the file painful to open on a machine with fewer cores
+	kdfTuneThreads = 4
+
+	// kdfFactorShift is where the Factor is kept in the threads/p byte of
+	// the salt, those bits are free since they can't be more than
+	// kdfMaxThreads. There's room for 7 kinds of Factor.
+	kdfFactorShift = 5
+	kdfThreadsMask = 1<<kdfFactorShift - 1
+)
+
+// Factor is a second factor mixed into the passphrase when deriving a key
+type Factor uint8
+
+// Second factors, the app decides how to get responses from them
+const (
+	FactorNone Factor = iota
+	// FactorYubikey is a yubikey's hmac-sha1 challenge-response slot
+	FactorYubikey
+	// FactorFIDO2 is a fido2 authenticator's hmac-secret extension
+	FactorFIDO2
+	// FactorKeyfile is a file of random bytes (eg. kept on a usb stick)
+	FactorKeyfile
+	// FactorSmartcard is an rsa key on a piv smartcard or pkcs#11 token,
+	// its pkcs#1 v1.5 signatures are the same every time
+	FactorSmartcard
+	// FactorTPM is a secret sealed to the tpm of each enrolled device
+	FactorTPM
+)
+
+// ErrNoSecondFactor is returned when deriving a key that needs a second
+// factor and SecondFactor is not set
+var ErrNoSecondFactor = errors.New("key needs a second factor but there's no way to get one")
+
+// SecondFactor answers challenges for keys that were derived with a
+// KDFParams.Factor (eg. with a hardware token's hmac challenge-response).
+// The challenge is the random part of the key's salt so it changes whenever
+// the key does. The response must always be the same for a challenge.
+var SecondFactor func(factor Factor, challenge []byte) (response []byte, err error)
+
+// KDFParams are the parameters used to derive keys from passphrases for
+// versions that support them. They're stored in the salt so that each user
+// in a file can have different ones and files can always be opened no
+// matter what the defaults are.
+type KDFParams struct {
+	// Algorithm is KDFArgon2id or KDFScrypt, empty means KDFArgon2id
+	Algorithm string
+
+	// Time is the number of argon2id iterations
+	Time uint32
+	// Memory is how much argon2id uses in KiB
+	Memory uint32
+	// Threads is the argon2id parallelism, unlike scrypt's P this does not
+	// change the key's strength but it is part of the key
+	Threads uint8
+
+	// N is scrypt's cost, it must be a power of 2
+	N uint32
+	// R is scrypt's block size
+	R uint8
+	// P is scrypt's parallelism
+	P uint8
+
+	// Factor mixes the response to a challenge into the passphrase
+	// (see the SecondFactor var). Keys with a factor may have an empty
+	// passphrase, then the factor replaces it.
+	Factor Factor
+}
+
+// DefaultKDFParams are used by DeriveKey, they take roughly a second on a
+// recent laptop. They use a thread for each cpu.
+var DefaultKDFParams = KDFParams{Algorithm: KDFArgon2id, Time: 3, Memory: 64 * 1024, Threads: kdfThreads(runtime.NumCPU())}
+
+// kdfThreads caps a number of cpus at kdfMaxThreads
+func kdfThreads(cpus int) uint8 {
+	switch {
+	case cpus < 1:
+		return 1
+	case cpus > kdfMaxThreads:
+		return kdfMaxThreads
+	}
+	return uint8(cpus)
+}
+
+// DefaultScryptParams are the scrypt params version 1 files always used
+var DefaultScryptParams = KDFParams{Algorithm: KDFScrypt, N: 1 << 19, R: 8, P: 1}
+
+// Version is the crypt version that keys with these params are derived for
+func (k KDFParams) Version() int {
+	if k.Algorithm == KDFScrypt {
+		return 3
+	}
+	return 2
+}
+
+// String formats the params the way ParseKDFParams reads them:
+// t=3,m=65536,p=4 for argon2id or scrypt,n=524288,r=8,p=1 for scrypt
+func (k KDFParams) String() string {
+	if k.Algorithm == KDFScrypt {
+		return fmt.Sprintf("%s,n=%d,r=%d,p=%d", KDFScrypt, k.N, k.R, k.P)
+	}
+	return fmt.Sprintf("t=%d,m=%d,p=%d", k.Time, k.Memory, k.Threads)
+}
+
+// Validate checks that the params are within the limits bpass will use
+func (k KDFParams) Validate() error {
+	if k.Factor > FactorTPM {
+		return fmt.Errorf("unknown second factor %d", k.Factor)
+	}
+
+	switch k.Algorithm {
+	case "", KDFArgon2id:
+		if k.Time == 0 || k.Time > kdfMaxTime {
+			return fmt.Errorf("kdf time must be between 1 and %d", kdfMaxTime)
+		}
+		if k.Threads == 0 || k.Threads > kdfMaxThreads {
+			return fmt.Errorf("kdf threads must be between 1 and %d", kdfMaxThreads)
+		}
+		if k.Memory < kdfMinMemory || k.Memory > kdfMaxMemory {
+			return fmt.Errorf("kdf memory must be between %d and %d KiB", kdfMinMemory, kdfMaxMemory)
+		}
+	case KDFScrypt:
+		if k.N < kdfMinScryptN || bits.OnesCount32(k.N) != 1 {
+			return fmt.Errorf("scrypt n must be a power of 2 and at least %d", kdfMinScryptN)
+		}
+		if k.R == 0 || k.R > kdfMaxScryptR {
+			return fmt.Errorf("scrypt r must be between 1 and %d", kdfMaxScryptR)
+		}
+		if k.P == 0 || k.P > kdfMaxThreads {
+			return fmt.Errorf("scrypt p must be between 1 and %d", kdfMaxThreads)
+		}
+		if uint64(k.N)*uint64(k.R)/8 > kdfMaxMemory {
+			return fmt.Errorf("scrypt n*r must use at most %d KiB", kdfMaxMemory)
+		}
+	default:
+		return fmt.Errorf("unknown kdf %q (want %s or %s)", k.Algorithm, KDFArgon2id, KDFScrypt)
+	}
+
+	return nil
+}
+
+// ParseKDFParams reads params in the form t=3,m=65536,p=4 (m is in KiB) for
+// argon2id or scrypt,n=524288,r=8,p=1 for scrypt. The algorithm may also be
+// given as the first field for argon2id. Any params that are left out are
+// taken from DefaultKDFParams or DefaultScryptParams.
+func ParseKDFParams(s string) (KDFParams, error) {
+	fields := strings.Split(s, ",")
+
+	k := DefaultKDFParams
+	switch alg := strings.TrimSpace(fields[0]); alg {
+	case KDFArgon2id:
+		fields = fields[1:]
+	case KDFScrypt:
+		k = DefaultScryptParams
+		fields = fields[1:]
+	}
+
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if len(field) == 0 {
+			continue
+		}
+
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return k, fmt.Errorf("kdf param %q must look like name=value", field)
+		}
+
+		n, err := strconv.ParseUint(kv[1], 10, 32)
+		if err != nil {
+			return k, fmt.Errorf("kdf param %q must be a number", kv[0])
+		}
+
+		small := n <= 255
+		switch {
+		case k.Algorithm == KDFScrypt && kv[0] == "n":
+			k.N = uint32(n)
+		case k.Algorithm == KDFScrypt && kv[0] == "r" && small:
+			k.R = uint8(n)
+		case k.Algorithm == KDFScrypt && kv[0] == "p" && small:
+			k.P = uint8(n)
+		case k.Algorithm != KDFScrypt && kv[0] == "t":
+			k.Time = uint32(n)
+		case k.Algorithm != KDFScrypt && kv[0] == "m":
+			k.Memory = uint32(n)
+		case k.Algorithm != KDFScrypt && kv[0] == "p" && small:
+			k.Threads = uint8(n)
+		case kv[0] == "p" || kv[0] == "r":
+			return k, fmt.Errorf("kdf param %q is too large", kv[0])
+		default:
+			return k, fmt.Errorf("unknown %s param %q", k.Algorithm, kv[0])
+		}
+	}
+
+	return k, k.Validate()
+}
+
+// TuneKDF measures this machine to find params for algorithm that take
+// about target to derive a key with. Memory is raised first (up to
+// maxMemory KiB) since that's what makes guessing expensive on gpus, then
+// iterations (or scrypt's parallelism) make up the rest of the time.
+func TuneKDF(algorithm string, target time.Duration, maxMemory uint32) (KDFParams, error) {
+	if maxMemory > kdfMaxMemory {
+		maxMemory = kdfMaxMemory
+	}
+	if maxMemory < kdfMinMemory {
+		return KDFParams{}, errors.New("kdf memory must be at least 8 MiB")
+	}
+
+	var k KDFParams
+	var memory func() uint32
+	var double func()
+	var scale func(times uint64)
+	switch algorithm {
+	case "", KDFArgon2id:
+		threads := runtime.NumCPU()
+		if threads > kdfTuneThreads {
+			threads = kdfTuneThreads
+		}
+
+		k = KDFParams{Algorithm: KDFArgon2id, Time: 1, Memory: kdfMinMemory, Threads: uint8(threads)}
+		memory = func() uint32 { return k.Memory }
+		double = func() { k.Memory *= 2 }
+		scale = func(times uint64) {
+			if times > kdfMaxTime {
+				times = kdfMaxTime
+			}
+			k.Time = uint32(times)
+		}
+	case KDFScrypt:
+		// Unlike argon2 scrypt's p doesn't use more cores in this
+		// implementation, it only repeats the work so it acts like time
+		k = KDFParams{Algorithm: KDFScrypt, N: kdfMinScryptN, R: 8, P: 1}
+		memory = func() uint32 { return k.N * uint32(k.R) / 8 }
+		double = func() { k.N *= 2 }
+		scale = func(times uint64) {
+			if times > kdfMaxThreads {
+				times = kdfMaxThreads
+			}
+			k.P = uint8(times)
+		}
+	default:
+		return k, fmt.Errorf("unknown kdf %q (want %s or %s)", algorithm, KDFArgon2id, KDFScrypt)
+	}
+
+	salt := make([]byte, saltRandomSize)
+	if _, err := rand.Read(salt); err != nil {
+		return k, fmt.Errorf("failed to get randomness for salt: %w", err)
+	}
+
+	measure := func() (time.Duration, error) {
+		start := time.Now()
+		if _, err := deriveKDF(k, []byte("bpass tuning"), salt, 32); err != nil {
+			return 0, err
+		}
+		return time.Since(start), nil
+	}
+
+	took, err := measure()
+	if err != nil {
+		return k, err
+	}
+	for took*2 <= target && memory()*2 <= maxMemory {
+		double()
+		if took, err = measure(); err != nil {
+			return k, err
+		}
+	}
+
+	if took < target {
+		// The time scales linearly so there's no need to measure again
+		if took <= 0 {
+			took = 1
+		}
+		scale(uint64(target / took))
+	}
+
+	return k, nil
+}
+
+// deriveKDF derives a key with already validated params
+func deriveKDF(k KDFParams, passphrase, salt []byte, keySize int) ([]byte, error) {
+	if k.Factor != FactorNone {
+		if SecondFactor == nil {
+			return nil, ErrNoSecondFactor
+		}
+
+		response, err := SecondFactor(k.Factor, salt[:saltRandomSize])
+		if err != nil {
+			return nil, fmt.Errorf("failed to get second factor: %w", err)
+		}
+
+		// The passphrase is used as the message so an hmac with a
+		// weak response is still at least as strong as the passphrase
+		mac := hmac.New(sha256.New, response)
+		_, _ = mac.Write(passphrase)
+		passphrase = mac.Sum(nil)
+	}
+
+	if k.Algorithm == KDFScrypt {
+		return scrypt.Key(passphrase, salt, int(k.N), int(k.R), int(k.P), keySize)
+	}
+
+	return argon2.IDKey(passphrase, salt, k.Time, k.Memory, k.Threads, uint32(keySize)), nil
+}
+
+// passphraseOptional checks if a key can be derived without a passphrase,
+// keys with a second factor can be opened with only the factor
+func passphraseOptional(salt []byte) bool {
+	kdf, err := SaltKDFParams(salt)
+	return err == nil && kdf.Factor != FactorNone
+}
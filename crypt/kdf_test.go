@@ -0,0 +1,277 @@
+package crypt
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestParseKDFParams(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		In   string
+		Want KDFParams
+		Err  bool
+	}{
+		{In: "", Want: DefaultKDFParams},
+		{In: "t=1,m=8192,p=1", Want: KDFParams{Algorithm: KDFArgon2id, Time: 1, Memory: 8192, Threads: 1}},
+		{In: " t=5 ", Want: KDFParams{Algorithm: KDFArgon2id, Time: 5, Memory: DefaultKDFParams.Memory, Threads: DefaultKDFParams.Threads}},
+		{In: "argon2id,p=1", Want: KDFParams{Algorithm: KDFArgon2id, Time: DefaultKDFParams.Time, Memory: DefaultKDFParams.Memory, Threads: 1}},
+		{In: "scrypt", Want: DefaultScryptParams},
+		{In: "scrypt,n=16384,r=4,p=2", Want: KDFParams{Algorithm: KDFScrypt, N: 16384, R: 4, P: 2}},
+		{In: "scrypt,n=20000", Err: true},
+		{In: "scrypt,n=2097152,r=32", Err: true},
+		{In: "scrypt,t=1", Err: true},
+		{In: "bcrypt", Err: true},
+		{In: "t=0", Err: true},
+		{In: "m=1024", Err: true},
+		{In: "p=32", Err: true},
+		{In: "p=300", Err: true},
+		{In: "x=1", Err: true},
+		{In: "t", Err: true},
+		{In: "t=-1", Err: true},
+	}
+
+	for i, test := range tests {
+		got, err := ParseKDFParams(test.In)
+		if test.Err {
+			if err == nil {
+				t.Errorf("%d) want an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d) %v", i, err)
+		} else if got != test.Want {
+			t.Errorf("%d) want: %v, got: %v", i, test.Want, got)
+		}
+
+		if again, err := ParseKDFParams(got.String()); err != nil || again != got {
+			t.Errorf("%d) string did not round trip: %s", i, got)
+		}
+	}
+}
+
+func TestKDFThreads(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		CPUs int
+		Want uint8
+	}{
+		{0, 1},
+		{1, 1},
+		{8, 8},
+		{kdfMaxThreads, kdfMaxThreads},
+		{128, kdfMaxThreads},
+	}
+
+	for i, test := range tests {
+		if got := kdfThreads(test.CPUs); got != test.Want {
+			t.Errorf("%d) want: %d, got: %d", i, test.Want, got)
+		}
+	}
+
+	if want := kdfThreads(runtime.NumCPU()); DefaultKDFParams.Threads != want {
+		t.Errorf("default threads want: %d, got: %d", want, DefaultKDFParams.Threads)
+	}
+	if err := DefaultKDFParams.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKDFSaltV2(t *testing.T) {
+	t.Parallel()
+
+	c := versions[2]
+	kdf := KDFParams{Algorithm: KDFArgon2id, Time: 1, Memory: kdfMinMemory, Threads: 1}
+
+	salt, err := newSaltV2(c, kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := kdfParamsV2(salt); err != nil || got != kdf {
+		t.Error("params were wrong:", got, err)
+	}
+	if v, err := SaltVersion(salt); err != nil || v != 2 {
+		t.Error("version was wrong:", v, err)
+	}
+
+	key, err := deriveKeyV2(c, []byte("hunter42"), salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != c.keySize {
+		t.Error("keysize was wrong:", len(key))
+	}
+
+	// The params are part of the key
+	other := append([]byte(nil), salt...)
+	other[len(other)-kdfParamsSize+3]++
+	otherKey, err := deriveKeyV2(c, []byte("hunter42"), other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(key, otherKey) {
+		t.Error("changing the params should change the key")
+	}
+
+	// Tampered params must not be used
+	huge := append([]byte(nil), salt...)
+	for i := saltRandomSize; i < saltRandomSize+8; i++ {
+		huge[i] = 0xff
+	}
+	if _, err = deriveKeyV2(c, []byte("hunter42"), huge); err == nil {
+		t.Error("want an error for huge params")
+	}
+
+	if _, err = newSaltV2(c, KDFParams{}); err == nil {
+		t.Error("want an error for empty params")
+	}
+	if _, err = newSaltV2(c, DefaultScryptParams); err == nil {
+		t.Error("want an error for scrypt params")
+	}
+	if _, err = SaltVersion(make([]byte, 3)); err != ErrInvalidSalt {
+		t.Error("want invalid salt, got:", err)
+	}
+}
+
+func TestKDFSaltV3(t *testing.T) {
+	t.Parallel()
+
+	c := versions[3]
+	kdf := KDFParams{Algorithm: KDFScrypt, N: kdfMinScryptN, R: 8, P: 1}
+
+	salt, err := newSaltV3(c, kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := kdfParamsV3(salt); err != nil || got != kdf {
+		t.Error("params were wrong:", got, err)
+	}
+	if v, err := SaltVersion(salt); err != nil || v != 3 {
+		t.Error("version was wrong:", v, err)
+	}
+
+	key, err := deriveKeyV3(c, []byte("hunter42"), salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != c.keySize {
+		t.Error("keysize was wrong:", len(key))
+	}
+
+	if _, err = newSaltV3(c, DefaultKDFParams); err == nil {
+		t.Error("want an error for argon2id params")
+	}
+
+	salt[saltRandomSize+4] = 0
+	if _, err = deriveKeyV3(c, []byte("hunter42"), salt); err == nil {
+		t.Error("want an error for r=0")
+	}
+}
+
+func TestTuneKDF(t *testing.T) {
+	t.Parallel()
+
+	for _, alg := range []string{KDFArgon2id, KDFScrypt} {
+		kdf, err := TuneKDF(alg, time.Millisecond, kdfMinMemory*2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kdf.Algorithm != alg {
+			t.Error("algorithm was wrong:", kdf.Algorithm)
+		}
+		if err = kdf.Validate(); err != nil {
+			t.Error(err)
+		}
+		if kdf.Memory > kdfMinMemory*2 || kdf.N*uint32(kdf.R)/8 > kdfMinMemory*2 {
+			t.Error("memory was over the max:", kdf)
+		}
+	}
+
+	if _, err := TuneKDF(KDFArgon2id, time.Millisecond, 1024); err == nil {
+		t.Error("want an error for too little memory")
+	}
+	if _, err := TuneKDF("bcrypt", time.Millisecond, kdfMinMemory); err == nil {
+		t.Error("want an error for an unknown algorithm")
+	}
+}
+
+func TestSecondFactor(t *testing.T) {
+	// Not parallel, it changes the SecondFactor hook
+	old := SecondFactor
+	defer func() { SecondFactor = old }()
+
+	c := versions[2]
+	kdf := KDFParams{Algorithm: KDFArgon2id, Time: 1, Memory: kdfMinMemory, Threads: 1, Factor: FactorFIDO2}
+
+	salt, err := newSaltV2(c, kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := SaltKDFParams(salt); err != nil || got != kdf {
+		t.Error("params were wrong:", got, err)
+	}
+
+	SecondFactor = nil
+	if _, err = deriveKeyV2(c, []byte("hunter42"), salt); err != ErrNoSecondFactor {
+		t.Error("want no second factor error, got:", err)
+	}
+
+	var challenge []byte
+	response := []byte("response")
+	SecondFactor = func(f Factor, c []byte) ([]byte, error) {
+		if f != FactorFIDO2 {
+			t.Error("factor was wrong:", f)
+		}
+		challenge = c
+		return response, nil
+	}
+
+	key1, err := deriveKeyV2(c, []byte("hunter42"), salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(challenge, salt[:saltRandomSize]) {
+		t.Error("challenge was wrong")
+	}
+
+	response = []byte("other")
+	key2, err := deriveKeyV2(c, []byte("hunter42"), salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(key1, key2) {
+		t.Error("the response should change the key")
+	}
+
+	// The factor has to fit next to the most threads
+	most := KDFParams{Algorithm: KDFArgon2id, Time: 1, Memory: kdfMinMemory, Threads: kdfMaxThreads, Factor: FactorTPM}
+	mostSalt, err := newSaltV2(c, most)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := SaltKDFParams(mostSalt); err != nil || got != most {
+		t.Error("params were wrong:", got, err)
+	}
+
+	if _, err = newSaltV1(versions[1], kdf); err == nil {
+		t.Error("want an error for a second factor in v1")
+	}
+
+	// The factor can replace the passphrase
+	key, salt, err := DeriveKeyParams(2, nil, kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := Encrypt(2, &Params{Keys: [][]byte{key}, Salts: [][]byte{salt}}, []byte("plaintext"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, pt, err := Decrypt(nil, nil, nil, nil, ct); err != nil || string(pt) != "plaintext" {
+		t.Error("failed to decrypt with only the factor:", err)
+	}
+}
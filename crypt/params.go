@@ -48,6 +48,17 @@ type Params struct {
 	// Master is the master key, decrypted from one of the master key blocks
 	// If the master key is nil, it will be generated.
 	Master []byte
+
+	// These fields are only used by versions with slots (see SlotVersion)
+	// Slot is the index of the slot that Keys[0] opens
+	Slot int
+	// Other is the other slot, kept verbatim. If it's nil filler is made
+	// and Encrypt sets Slot and Other.
+	Other []byte
+	// PayloadKey is the random key the payload is encrypted with, Keys[0]
+	// only wraps it so the passphrase can change without the payload being
+	// encrypted again (see Rewrap). If it's nil Encrypt makes and sets it.
+	PayloadKey []byte
 }
 
 // validate the encryption params for encrypting
@@ -0,0 +1,67 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Seal encrypts a small value (a passphrase for example) with a key that's
+// already been derived. This is not a file format, it's used for secrets
+// stored inside an already encrypted file so they're also bound to the
+// key of the file they came from.
+//
+// The key is hashed so it may be any size, AES-256-GCM is used and the nonce
+// is prepended to the output.
+func Seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := sealCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("error generating randomness for nonce: %w", err)
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts a value created by Seal, it returns ErrWrongPassphrase if
+// the key is not the one that was used to seal it.
+func Open(key, sealed []byte) ([]byte, error) {
+	gcm, err := sealCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed value too short")
+	}
+
+	nonce, ct := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	pt, err := gcm.Open(nil, nonce, ct, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	return pt, nil
+}
+
+func sealCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) == 0 {
+		return nil, ErrInvalidKey
+	}
+
+	k := sha256.Sum256(key)
+	block, err := aes.NewCipher(k[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,35 @@
+package crypt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSeal(t *testing.T) {
+	t.Parallel()
+
+	key := []byte("key")
+	sealed, err := Seal(key, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(sealed, []byte("passphrase")) {
+		t.Error("sealed value contains the plaintext")
+	}
+
+	pt, err := Open(key, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pt) != "passphrase" {
+		t.Errorf("want: passphrase, got: %s", pt)
+	}
+
+	if _, err = Open([]byte("other"), sealed); err != ErrWrongPassphrase {
+		t.Error("want wrong passphrase error, got:", err)
+	}
+	if _, err = Seal(nil, []byte("x")); err != ErrInvalidKey {
+		t.Error("want invalid key error, got:", err)
+	}
+}
@@ -0,0 +1,54 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+)
+
+// ErrBadSignature is returned when a signed payload's signature does not
+// match its contents
+var ErrBadSignature = errors.New("signature verification failed")
+
+// sigHeader marks a signed payload, it's followed by the signer's public
+// key, the signature and then the payload itself
+var sigHeader = []byte(":BPASSSIG:1")
+
+// Sign wraps an encrypted payload with an ed25519 signature and the public
+// key to verify it with.
+func Sign(priv ed25519.PrivateKey, payload []byte) []byte {
+	sig := ed25519.Sign(priv, payload)
+	pub := priv.Public().(ed25519.PublicKey)
+
+	out := make([]byte, 0, len(sigHeader)+len(pub)+len(sig)+len(payload))
+	out = append(out, sigHeader...)
+	out = append(out, pub...)
+	out = append(out, sig...)
+	return append(out, payload...)
+}
+
+// Verify checks the signature of a payload created by Sign and returns the
+// public key that signed it along with the payload. Payloads that were never
+// signed are returned as is with a nil public key.
+//
+// The caller must still decide whether or not the public key is trusted.
+func Verify(signed []byte) (pub ed25519.PublicKey, payload []byte, err error) {
+	if !bytes.HasPrefix(signed, sigHeader) {
+		return nil, signed, nil
+	}
+
+	rest := signed[len(sigHeader):]
+	if len(rest) < ed25519.PublicKeySize+ed25519.SignatureSize {
+		return nil, nil, ErrInvalidFileFormat
+	}
+
+	pub = ed25519.PublicKey(rest[:ed25519.PublicKeySize])
+	sig := rest[ed25519.PublicKeySize : ed25519.PublicKeySize+ed25519.SignatureSize]
+	payload = rest[ed25519.PublicKeySize+ed25519.SignatureSize:]
+
+	if !ed25519.Verify(pub, payload, sig) {
+		return nil, nil, ErrBadSignature
+	}
+
+	return pub, payload, nil
+}
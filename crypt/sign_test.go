@@ -0,0 +1,49 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte("ciphertext")
+	signed := Sign(priv, payload)
+
+	gotPub, got, err := Verify(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gotPub, pub) {
+		t.Error("public key was wrong")
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("want: %s, got: %s", payload, got)
+	}
+
+	// Tamper with the payload
+	signed[len(signed)-1] ^= 1
+	if _, _, err = Verify(signed); err != ErrBadSignature {
+		t.Error("want bad signature error, got:", err)
+	}
+
+	// Unsigned payloads pass through untouched
+	gotPub, got, err = Verify(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotPub != nil || !bytes.Equal(got, payload) {
+		t.Error("unsigned payload should be returned as is")
+	}
+
+	if _, _, err = Verify(sigHeader); err != ErrInvalidFileFormat {
+		t.Error("want invalid format error, got:", err)
+	}
+}
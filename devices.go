@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// deviceStats is what a device wrote to the log
+type deviceStats struct {
+	id      string
+	name    string
+	changes int
+	last    int64
+}
+
+// devices lists the devices that wrote to the file by how many changes they
+// made, named by the trusted devices where they're known
+func (u *uiContext) devices() error {
+	trusted, err := u.store.Devices()
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]string, len(trusted))
+	for name, encoded := range trusted {
+		pub, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			continue
+		}
+		names[deviceID(pub)] = name
+	}
+
+	stats := make(map[string]*deviceStats)
+	unstamped := 0
+	for _, tx := range u.store.DB.Log {
+		if len(tx.Device) == 0 {
+			unstamped++
+			continue
+		}
+
+		s, ok := stats[tx.Device]
+		if !ok {
+			s = &deviceStats{id: tx.Device, name: names[tx.Device]}
+			stats[tx.Device] = s
+		}
+		s.changes++
+		if tx.Time > s.last {
+			s.last = tx.Time
+		}
+	}
+
+	list := make([]*deviceStats, 0, len(stats))
+	for _, s := range stats {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].changes != list[j].changes {
+			return list[i].changes > list[j].changes
+		}
+		return list[i].id < list[j].id
+	})
+
+	this := u.store.DB.Device()
+	for _, s := range list {
+		name := s.name
+		if len(name) == 0 {
+			name = "(unknown)"
+		}
+		if s.id == this {
+			name += " (this device)"
+		}
+
+		when := time.Unix(0, s.last).Format(time.RFC3339)
+		fmt.Fprintf(u.out, "  %s %s %d changes, last at %s\n", keyColor.Sprint(s.id), name, s.changes, when)
+	}
+
+	if unstamped != 0 {
+		infoColor.Printf("%d changes were made before devices were recorded\n", unstamped)
+	} else if len(list) == 0 {
+		infoColor.Println("no changes have been made")
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestDevices(t *testing.T) {
+	t.Parallel()
+
+	out := new(bytes.Buffer)
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: out}
+	if _, err := u.store.New("before"); err != nil {
+		t.Fatal(err)
+	}
+
+	priv, err := u.deviceKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := deviceID(priv.Public().(ed25519.PublicKey))
+	if u.store.DB.Device() != id {
+		t.Error("the device should be set:", u.store.DB.Device())
+	}
+
+	if _, err = u.store.New("after"); err != nil {
+		t.Fatal(err)
+	}
+	if last := u.store.DB.Log[len(u.store.DB.Log)-1]; last.Device != id || len(last.Clock) == 0 {
+		t.Error("new changes should be stamped:", last)
+	}
+
+	if err = u.devices(); err != nil {
+		t.Fatal(err)
+	}
+	line := out.String()
+	if !strings.Contains(line, id) || !strings.Contains(line, "(this device)") {
+		t.Error("this device should be listed:", line)
+	}
+	if hostname, err := os.Hostname(); err == nil && len(hostname) != 0 && !strings.Contains(line, hostname) {
+		t.Error("the device should be named:", line)
+	}
+}
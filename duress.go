@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/crypt"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+// duressSet puts an empty file in the second slot that another passphrase
+// opens instead of this one, either a decoy for a duress passphrase or a
+// hidden file, kind is which one for the prompts. Files with a second slot
+// look the same with or without anything in it so it can't be shown that
+// there's anything else in the file.
+//
+// There's only the one slot for both kinds. Since filler can't be told
+// apart from a slot in use the user has to confirm replacing it whenever
+// the file has one.
+func (u *uiContext) duressSet(kind string) error {
+	if u.master.Len() != 0 {
+		errColor.Printf("only single-user files can have a %s passphrase\n", kind)
+		return nil
+	}
+	if u.readOnly {
+		errColor.Println("a file opened at a time in the past can't be changed")
+		return nil
+	}
+
+	if crypt.VersionCipher(u.fileVersion()) != crypt.CipherChaCha20Poly1305 {
+		infoColor.Println("the file will be encrypted with chacha20poly1305 from now on, only it has a second slot")
+		yes, err := u.getYesNo("continue?")
+		if err != nil || !yes {
+			return err
+		}
+		if err = u.store.SetSetting(blobformat.SettingCipher, crypt.CipherChaCha20Poly1305); err != nil {
+			return err
+		}
+	}
+
+	if u.otherSlot != nil {
+		infoColor.Println("duress and hidden passphrases share the file's one second slot, if either is set what it opens is lost")
+		yes, err := u.getYesNo(fmt.Sprintf("replace the second slot with the %s passphrase?", kind))
+		if err != nil || !yes {
+			return err
+		}
+	}
+
+	version := u.fileVersion()
+	if u.slotVersion != version {
+		// Encrypting once makes the filler the decoy replaces
+		if _, err := u.encryptBlob(); err != nil {
+			return err
+		}
+	}
+
+	infoColor.Printf("the %s passphrase opens an empty file\n", kind)
+	pass, err := u.promptPassword(promptColor.Sprintf("%s passphrase: ", kind))
+	if err != nil {
+		return err
+	}
+	if len(pass) == 0 {
+		errColor.Printf("the %s passphrase can't be empty\n", kind)
+		return nil
+	}
+	verify, err := u.promptPassword(promptColor.Sprintf("verify %s passphrase: ", kind))
+	if err != nil {
+		return err
+	}
+	if pass != verify {
+		errColor.Println("passphrases did not match")
+		return nil
+	}
+
+	current, err := u.currentPass()
+	if err != nil {
+		return err
+	}
+	if pass == current {
+		errColor.Printf("the %s passphrase must be different from the file's\n", kind)
+		return nil
+	}
+
+	slot, err := u.newOtherSlot(version, pass)
+	if err != nil {
+		return err
+	}
+
+	u.otherSlot = slot
+	if err = u.saveBlob(); err != nil {
+		return err
+	}
+
+	infoColor.Printf("set the %s passphrase, open the file with it to add to the file it opens\n", kind)
+	return nil
+}
+
+// newOtherSlot creates a second slot for version that pass opens to an
+// empty file, it takes the place of the current one
+func (u *uiContext) newOtherSlot(version int, pass string) ([]byte, error) {
+	kdf, err := crypt.SaltKDFParams(u.salt)
+	if err != nil {
+		return nil, err
+	}
+	key, salt, err := crypt.DeriveKeyParams(kdf.Version(), []byte(pass), kdf)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without the cipher setting a save from the other slot would drop this
+	// one
+	decoy := blobformat.Blobs{DB: new(txlogs.DB)}
+	for _, setting := range []string{blobformat.SettingCipher, blobformat.SettingKDF} {
+		value, err := u.store.Setting(setting)
+		if err != nil {
+			return nil, err
+		}
+		if len(value) == 0 {
+			continue
+		}
+		if err = decoy.SetSetting(setting, value); err != nil {
+			return nil, err
+		}
+	}
+	empty, err := decoy.Save()
+	if err != nil {
+		return nil, err
+	}
+
+	return crypt.NewSlot(version, key, salt, u.otherSlot, empty)
+}
+
+// duressRemove replaces the second slot with filler
+func (u *uiContext) duressRemove(kind string) error {
+	if u.slotVersion == 0 || u.otherSlot == nil {
+		errColor.Println("this file has no second slot")
+		return nil
+	}
+
+	yes, err := u.getYesNo(fmt.Sprintf("anything opened by the %s passphrase will be lost, continue?", kind))
+	if err != nil || !yes {
+		return err
+	}
+
+	u.otherSlot = nil
+	return u.saveBlob()
+}
+
+// confirmDropSlot asks before a change that means the file can't have a
+// second slot, where a duress or hidden passphrase would stop working
+func (u *uiContext) confirmDropSlot(why string) (bool, error) {
+	if u.otherSlot == nil {
+		return true, nil
+	}
+
+	infoColor.Printf("%s has no second slot, a duress or hidden passphrase will stop working\n", why)
+	return u.getYesNo("continue?")
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/crypt"
+	"github.com/aarondl/bpass/secmem"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestSlots(t *testing.T) {
+	t.Parallel()
+
+	kdf := crypt.KDFParams{Algorithm: crypt.KDFArgon2id, Time: 1, Memory: 8 * 1024, Threads: 1}
+	key, salt, err := crypt.DeriveKeyParams(kdf.Version(), []byte("hunter42"), kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &uiContext{key: secmem.Copy(key), salt: salt, store: blobformat.Blobs{DB: new(txlogs.DB)}}
+	if v := u.fileVersion(); v != 2 {
+		t.Error("want the cascade by default, got:", v)
+	}
+	if err = u.store.SetSetting(blobformat.SettingCipher, crypt.CipherChaCha20Poly1305); err != nil {
+		t.Fatal(err)
+	}
+	if v := u.fileVersion(); v != 6 {
+		t.Error("single-user chacha20poly1305 files should have slots, got:", v)
+	}
+
+	first, err := u.encryptPayload([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.otherSlot == nil || u.slotVersion != 6 {
+		t.Fatal("the filler should be kept")
+	}
+	filler := u.otherSlot
+
+	second, err := u.encryptPayload([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != len(second) || !bytes.Contains(second, filler) {
+		t.Error("the filler should be written back verbatim")
+	}
+
+	decoyKey, decoySalt, err := crypt.DeriveKeyParams(kdf.Version(), []byte("duress"), kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.otherSlot, err = crypt.NewSlot(6, decoyKey, decoySalt, u.otherSlot, []byte("decoy")); err != nil {
+		t.Fatal(err)
+	}
+	withDecoy, err := u.encryptPayload([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, pt, err := crypt.Decrypt(nil, nil, decoyKey, nil, withDecoy); err != nil || string(pt) != "decoy" {
+		t.Error("failed to open the decoy:", err)
+	}
+
+	// Losing the setting doesn't lose the decoy
+	if err = u.store.SetSetting(blobformat.SettingCipher, ""); err != nil {
+		t.Fatal(err)
+	}
+	if v := u.fileVersion(); v != 6 {
+		t.Error("want the slot version kept, got:", v)
+	}
+
+	// Multi-user files can't have slots
+	u.setMaster(make([]byte, len(key)))
+	if err = u.store.SetSetting(blobformat.SettingCipher, crypt.CipherChaCha20Poly1305); err != nil {
+		t.Fatal(err)
+	}
+	if v := u.fileVersion(); v != 4 {
+		t.Error("want no slots for multi-user files, got:", v)
+	}
+}
+
+func TestRewrapBlob(t *testing.T) {
+	t.Parallel()
+
+	kdf := crypt.KDFParams{Algorithm: crypt.KDFArgon2id, Time: 1, Memory: 8 * 1024, Threads: 1}
+	key, salt, err := crypt.DeriveKeyParams(kdf.Version(), []byte("hunter42"), kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &uiContext{key: secmem.Copy(key), salt: salt, store: blobformat.Blobs{DB: new(txlogs.DB)}}
+	if err = u.store.SetSetting(blobformat.SettingCipher, crypt.CipherChaCha20Poly1305); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := u.rewrapBlob(); err != nil || data != nil {
+		t.Error("nothing is on disk to rewrap:", err)
+	}
+
+	onDisk, err := u.encryptBlob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.markSaved(onDisk)
+
+	newKey, newSalt, err := crypt.DeriveKeyParams(kdf.Version(), []byte("hunter43"), kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.setKey(newKey)
+	u.salt = newSalt
+
+	rewrapped, err := u.rewrapBlob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewrapped == nil {
+		t.Fatal("an unchanged log should be rewrapped")
+	}
+	if _, _, _, err = crypt.Decrypt(nil, []byte("hunter43"), nil, nil, rewrapped); err != nil {
+		t.Error("new passphrase didn't open it:", err)
+	}
+
+	if err = u.store.SetSetting(blobformat.SettingSyncOnSave, "true"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := u.rewrapBlob(); err != nil || data != nil {
+		t.Error("a changed log must be encrypted again:", err)
+	}
+}
+
+func TestHiddenSlot(t *testing.T) {
+	t.Parallel()
+
+	kdf := crypt.KDFParams{Algorithm: crypt.KDFArgon2id, Time: 1, Memory: 8 * 1024, Threads: 1}
+	key, salt, err := crypt.DeriveKeyParams(kdf.Version(), []byte("hunter42"), kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outer := &uiContext{key: secmem.Copy(key), salt: salt, store: blobformat.Blobs{DB: new(txlogs.DB)}}
+	if err = outer.store.SetSetting(blobformat.SettingCipher, crypt.CipherChaCha20Poly1305); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = outer.store.New("github"); err != nil {
+		t.Fatal(err)
+	}
+	plain, err := outer.encryptBlob()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// open is what opening the file with a passphrase does
+	open := func(pass string, data []byte) *uiContext {
+		t.Helper()
+
+		version, params, pt, err := crypt.Decrypt(nil, []byte(pass), nil, nil, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store, err := txlogs.New(pt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u := &uiContext{salt: params.Salts[params.User], store: blobformat.Blobs{DB: store}}
+		u.setKey(params.Keys[params.User])
+		u.keepSlot(version, params)
+		return u
+	}
+
+	has := func(u *uiContext, name string) bool {
+		t.Helper()
+
+		uuid, _, err := u.store.FindByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(uuid) != 0
+	}
+
+	slot, err := outer.newOtherSlot(outer.fileVersion(), "hidden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer.otherSlot = slot
+	withHidden, err := outer.encryptBlob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(withHidden) != len(plain) {
+		t.Error("the hidden slot changed the size of the file")
+	}
+
+	hidden := open("hidden", withHidden)
+	if has(hidden, "github") {
+		t.Error("the hidden file should start empty")
+	}
+	if _, err = hidden.store.New("secrets"); err != nil {
+		t.Fatal(err)
+	}
+	fromHidden, err := hidden.encryptBlob()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The outer file only has its own entries and saving it keeps the
+	// hidden one as it is
+	outer = open("hunter42", fromHidden)
+	if !has(outer, "github") || has(outer, "secrets") {
+		t.Error("the outer file should only have its entry")
+	}
+	for _, tx := range outer.store.DB.Log {
+		if tx.Key == blobformat.KeyName && tx.Value == "secrets" {
+			t.Error("the outer log has the hidden entry")
+		}
+	}
+	if _, err = outer.store.New("aws"); err != nil {
+		t.Fatal(err)
+	}
+	fromOuter, err := outer.encryptBlob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fromOuter) != len(plain) {
+		t.Error("the file should be the same size as one with filler")
+	}
+
+	hidden = open("hidden", fromOuter)
+	if !has(hidden, "secrets") || has(hidden, "aws") {
+		t.Error("the hidden file should round trip")
+	}
+
+	// The slot is shared, replacing it has to be confirmed
+	editor := &scriptedEditor{lines: []string{"n"}}
+	outer.in = editor
+	outer.out = new(bytes.Buffer)
+	before := outer.otherSlot
+	if err = outer.duressSet("duress"); err != nil {
+		t.Fatal(err)
+	}
+	if len(editor.lines) != 0 {
+		t.Error("replacing the second slot should be confirmed")
+	}
+	if !bytes.Equal(outer.otherSlot, before) {
+		t.Error("the second slot was replaced without confirming")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+)
+
+// dueWarning is how far ahead of an entry's expires date due lists it when
+// it's not given a number of days
+const dueWarning = 30 * 24 * time.Hour
+
+// dueEntry is an entry whose expires date has passed or is close
+type dueEntry struct {
+	Name    string
+	Expires time.Time
+}
+
+// dueEntries finds the entries outside the trash that expire before now plus
+// within, oldest first. Entries whose expires isn't a date are returned as
+// warnings.
+func (u *uiContext) dueEntries(now time.Time, within time.Duration) ([]dueEntry, []string) {
+	names := u.store.DB.Values(blobformat.KeyName)
+	trashed := u.store.DB.Values(blobformat.KeyTrashed)
+
+	var due []dueEntry
+	var warnings []string
+	for uuid, value := range u.store.DB.Values(blobformat.KeyExpires) {
+		if _, ok := trashed[uuid]; ok {
+			continue
+		}
+
+		expires, err := blobformat.ParseDate(value)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %v", names[uuid], err))
+			continue
+		}
+		if expires.Sub(now) < within {
+			due = append(due, dueEntry{Name: names[uuid], Expires: expires})
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].Expires.Equal(due[j].Expires) {
+			return due[i].Expires.Before(due[j].Expires)
+		}
+		return due[i].Name < due[j].Name
+	})
+	sort.Strings(warnings)
+
+	return due, warnings
+}
+
+// dueIn describes when something expires relative to now
+func dueIn(expires, now time.Time) string {
+	left := expires.Sub(now)
+	switch {
+	case left < -24*time.Hour:
+		return fmt.Sprintf("expired %d days ago", -left/(24*time.Hour))
+	case left < 0:
+		return "expired"
+	case left < 24*time.Hour:
+		return "expires today"
+	default:
+		return fmt.Sprintf("expires in %d days", left/(24*time.Hour))
+	}
+}
+
+// due lists the entries that expired or expire in the next days (or
+// dueWarning when days is empty)
+func (u *uiContext) due(days string) error {
+	within := dueWarning
+	if len(days) != 0 {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			errColor.Println("days must be a positive number")
+			return nil
+		}
+		within = time.Duration(n) * 24 * time.Hour
+	}
+
+	now := time.Now()
+	due, warnings := u.dueEntries(now, within)
+	if len(due) == 0 && len(warnings) == 0 {
+		infoColor.Printf("nothing expires in the next %d days\n", within/(24*time.Hour))
+		return nil
+	}
+
+	for _, d := range due {
+		msg := fmt.Sprintf("%s %s", dueIn(d.Expires, now), d.Expires.Format("(2006-01-02)"))
+		if d.Expires.Before(now) {
+			msg = errColor.Sprint(msg)
+		}
+		fmt.Fprintf(u.out, "  %s %s\n", keyColor.Sprint(d.Name+":"), msg)
+	}
+	for _, w := range warnings {
+		errColor.Println(w)
+	}
+
+	return nil
+}
+
+// warnExpired shows a banner when entries have expired, it's shown when the
+// repl starts so rotations aren't forgotten
+func (u *uiContext) warnExpired(now time.Time) {
+	due, _ := u.dueEntries(now, 0)
+	switch len(due) {
+	case 0:
+		return
+	case 1:
+		errColor.Printf("%s has expired (%s), see \"due\"\n", due[0].Name, dueIn(due[0].Expires, now))
+	default:
+		errColor.Printf("%d entries have expired, see \"due\"\n", len(due))
+	}
+}
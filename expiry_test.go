@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestDueEntries(t *testing.T) {
+	t.Parallel()
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: ioutil.Discard}
+	now := time.Date(2026, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	entries := map[string]string{
+		"expired": "2026-06-01",
+		"soon":    "2026-06-20",
+		"later":   "2027-01-01",
+		"bad":     "whenever",
+		"trashed": "2026-01-01",
+		"none":    "",
+	}
+	for name, expires := range entries {
+		uuid, err := u.store.New(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(expires) != 0 {
+			// Set would refuse the bad date
+			u.store.DB.Set(uuid, blobformat.KeyExpires, expires)
+		}
+		if name == "trashed" {
+			u.store.Trash(uuid)
+		}
+	}
+
+	due, warnings := u.dueEntries(now, dueWarning)
+	if len(due) != 2 || due[0].Name != "expired" || due[1].Name != "soon" {
+		t.Errorf("due was wrong: %#v", due)
+	}
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "bad: ") {
+		t.Errorf("warnings were wrong: %q", warnings)
+	}
+
+	if due, _ = u.dueEntries(now, 0); len(due) != 1 || due[0].Name != "expired" {
+		t.Errorf("only the expired entry should be due now: %#v", due)
+	}
+	if got := dueIn(due[0].Expires, now); got != "expired 14 days ago" {
+		t.Error("wrong description:", got)
+	}
+
+	out := new(bytes.Buffer)
+	u.out = out
+	if err := u.due("365"); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); !strings.Contains(got, "later:") || !strings.Contains(got, "(2026-06-20)") {
+		t.Error("due should list everything expiring in a year:", got)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aarondl/bpass/crypt"
+	"github.com/aarondl/bpass/secmem"
+)
+
+var errFactorAbsent = errors.New("the second factor for this key is needed (a recovery user can open multi-user files without it)")
+
+// secondFactorResponse answers crypt.SecondFactor challenges with the
+// token for the factor. Responses are remembered for the session so the
+// token is only touched once for each key.
+func (u *uiContext) secondFactorResponse(factor crypt.Factor, challenge []byte) ([]byte, error) {
+	u.factorLock.Lock()
+	defer u.factorLock.Unlock()
+
+	id := fmt.Sprintf("%d:%x", factor, challenge)
+	if response, ok := u.factorResponses[id]; ok {
+		return response.Bytes(), nil
+	}
+
+	var response []byte
+	var err error
+	switch factor {
+	case crypt.FactorYubikey:
+		response, err = u.yubikeyResponse(challenge)
+	case crypt.FactorFIDO2:
+		response, err = u.fido2Response(challenge)
+	case crypt.FactorKeyfile:
+		response, err = u.keyfileResponse(challenge)
+	case crypt.FactorSmartcard:
+		response, err = u.smartcardResponse(challenge)
+	case crypt.FactorTPM:
+		response, err = u.tpmResponse(challenge)
+	default:
+		err = fmt.Errorf("unknown second factor %d, try upgrading bpass", factor)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if u.factorResponses == nil {
+		u.factorResponses = make(map[string]*secmem.Buffer)
+	}
+	u.factorResponses[id] = secmem.Copy(response)
+	return response, nil
+}
+
+// keyFactor is the second factor the current key needs
+func (u *uiContext) keyFactor() crypt.Factor {
+	kdf, err := crypt.SaltKDFParams(u.salt)
+	if err != nil {
+		return crypt.FactorNone
+	}
+
+	return kdf.Factor
+}
+
+// factorName is what the user calls the factor's token
+func factorName(factor crypt.Factor) string {
+	switch factor {
+	case crypt.FactorYubikey:
+		return "yubikey"
+	case crypt.FactorFIDO2:
+		return "fido2 authenticator"
+	case crypt.FactorKeyfile:
+		return "keyfile"
+	case crypt.FactorSmartcard:
+		return "smartcard"
+	case crypt.FactorTPM:
+		return "tpm"
+	default:
+		return "second factor"
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	// fido2RPID is the relying party of the resident credential bpass
+	// keeps on each authenticator, fido2UserID is its user. Every file
+	// shares the one credential, they each use different salts with it.
+	fido2RPID   = "bpass"
+	fido2UserID = "bpass"
+)
+
+// fido2Command finds one of the libfido2 tools
+func fido2Command(name string) (string, error) {
+	command, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("%s (libfido2) is needed to use a fido2 authenticator", name)
+	}
+
+	return command, nil
+}
+
+// fido2Response answers a challenge with the authenticator's hmac-secret
+// for the bpass credential
+func (u *uiContext) fido2Response(challenge []byte) ([]byte, error) {
+	for {
+		response, err := u.fido2Assert(challenge)
+		if err == nil {
+			return response, nil
+		}
+
+		errColor.Println("fido2 authenticator failed:", err)
+		if u.headless {
+			return nil, errFactorAbsent
+		}
+
+		u.fido2Device = ""
+		retry, err := u.getYesNo("insert your authenticator and try again?")
+		if err != nil {
+			return nil, err
+		}
+		if !retry {
+			return nil, errFactorAbsent
+		}
+	}
+}
+
+// fido2Assert gets the hmac-secret for the salt from the bpass credential
+// using fido2-assert, it asks for the pin itself if one is needed
+func (u *uiContext) fido2Assert(salt []byte) ([]byte, error) {
+	command, err := fido2Command("fido2-assert")
+	if err != nil {
+		return nil, err
+	}
+
+	device, err := u.pickFIDO2Device()
+	if err != nil {
+		return nil, err
+	}
+
+	cdh := make([]byte, 32)
+	if _, err = rand.Read(cdh); err != nil {
+		return nil, err
+	}
+
+	// client data hash, relying party, hmac salt (the credential id is left
+	// out since it's resident)
+	input := fmt.Sprintf("%s\n%s\n%s\n",
+		base64.StdEncoding.EncodeToString(cdh), fido2RPID, base64.StdEncoding.EncodeToString(salt))
+
+	infoColor.Println("touch your fido2 authenticator")
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(command, "-G", "-h", "-r", device)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.New(strings.TrimSpace(stderr.String()))
+	}
+
+	// client data hash, relying party, auth data, signature, user id,
+	// hmac-secret
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) < 6 {
+		return nil, errors.New("fido2-assert did not return an hmac-secret")
+	}
+
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(lines[5]))
+}
+
+// fido2Enroll makes sure the authenticator has the bpass credential,
+// an existing one is kept since other files may be using it
+func (u *uiContext) fido2Enroll() error {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return err
+	}
+
+	infoColor.Println("checking the authenticator for a bpass credential")
+	if _, err := u.fido2Assert(challenge); err == nil {
+		return nil
+	}
+
+	command, err := fido2Command("fido2-cred")
+	if err != nil {
+		return err
+	}
+
+	device, err := u.pickFIDO2Device()
+	if err != nil {
+		return err
+	}
+
+	cdh := make([]byte, 32)
+	if _, err = rand.Read(cdh); err != nil {
+		return err
+	}
+
+	// client data hash, relying party, user name, user id
+	input := fmt.Sprintf("%s\n%s\n%s\n%s\n",
+		base64.StdEncoding.EncodeToString(cdh), fido2RPID, fido2UserID,
+		base64.StdEncoding.EncodeToString([]byte(fido2UserID)))
+
+	infoColor.Println("creating a bpass credential, touch your fido2 authenticator")
+
+	cmd := exec.Command(command, "-M", "-h", "-r", device)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = nil
+	cmd.Stderr = os.Stderr
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("fido2-cred failed: %w", err)
+	}
+
+	return nil
+}
+
+// pickFIDO2Device finds the authenticator to use, asking which one if
+// there's more than one. The answer is kept for the session.
+func (u *uiContext) pickFIDO2Device() (string, error) {
+	if len(u.fido2Device) != 0 {
+		return u.fido2Device, nil
+	}
+
+	command, err := fido2Command("fido2-token")
+	if err != nil {
+		return "", err
+	}
+
+	out, err := exec.Command(command, "-L").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to list fido2 authenticators: %w", err)
+	}
+
+	devices, names := parseFIDO2Devices(string(out))
+	switch len(devices) {
+	case 0:
+		return "", errors.New("no fido2 authenticator found")
+	case 1:
+		u.fido2Device = devices[0]
+		return u.fido2Device, nil
+	}
+
+	for i, name := range names {
+		infoColor.Printf(" %d. %s\n", i+1, name)
+	}
+	for {
+		answer, err := u.prompt(promptColor.Sprint("authenticator: "))
+		if err != nil {
+			return "", err
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(answer))
+		if err != nil || n < 1 || n > len(devices) {
+			errColor.Println("pick one of the numbers above")
+			continue
+		}
+
+		u.fido2Device = devices[n-1]
+		return u.fido2Device, nil
+	}
+}
+
+// parseFIDO2Devices reads the output of fido2-token -L, each line is
+// the device path followed by a description:
+// /dev/hidraw4: vendor=0x1050, product=0x0407 (Yubico YubiKey)
+func parseFIDO2Devices(out string) (devices, names []string) {
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		i := strings.Index(line, ": ")
+		if i <= 0 {
+			continue
+		}
+
+		devices = append(devices, line[:i])
+		names = append(names, line)
+	}
+
+	return devices, names
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFIDO2Devices(t *testing.T) {
+	t.Parallel()
+
+	out := "/dev/hidraw4: vendor=0x1050, product=0x0407 (Yubico YubiKey OTP+FIDO+CCID)\n" +
+		"\n" +
+		"garbage\n" +
+		"ioreg://4294971278: vendor=0x096e, product=0x0858 (FT U2F)\n"
+
+	devices, names := parseFIDO2Devices(out)
+	want := []string{"/dev/hidraw4", "ioreg://4294971278"}
+	if !reflect.DeepEqual(devices, want) {
+		t.Error("devices were wrong:", devices)
+	}
+	if len(names) != 2 || names[1] != "ioreg://4294971278: vendor=0x096e, product=0x0858 (FT U2F)" {
+		t.Error("names were wrong:", names)
+	}
+}
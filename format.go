@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+)
+
+// Formats ls, show and get can write their output in for other programs to
+// read instead of the one for people (text)
+const (
+	formatText = "text"
+	formatJSON = "json"
+	formatTSV  = "tsv"
+)
+
+// formatFlags are the flags that pick the format of ls, show and get in the
+// repl, see parseFormat
+var formatFlags = []string{"--format=" + formatText, "--format=" + formatJSON, "--format=" + formatTSV}
+
+// checkFormat checks format is one of the formats, "" is text
+func checkFormat(format string) error {
+	switch format {
+	case "", formatText, formatJSON, formatTSV:
+		return nil
+	}
+	return fmt.Errorf("unknown format %q, it must be %s, %s or %s", format, formatText, formatJSON, formatTSV)
+}
+
+// parseFormat takes the format flags out of the leading flags of args (flags
+// are all of the command's), format is def when there's none. The format is
+// "" for text.
+func parseFormat(args, flags []string, def string) (rest []string, format string) {
+	format = def
+	n := countFlags(args, flags)
+	for _, arg := range args[:n] {
+		if isFlag(arg, formatFlags) {
+			format = strings.TrimPrefix(arg, "--format=")
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	if format == formatText {
+		format = ""
+	}
+
+	return append(rest, args[n:]...), format
+}
+
+// writeJSON writes v as indented json
+func (u *uiContext) writeJSON(v interface{}) error {
+	enc := json.NewEncoder(u.out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeTSV writes a line of tab separated values, see tsvEscape
+func (u *uiContext) writeTSV(values ...string) error {
+	for i, v := range values {
+		values[i] = tsvEscape(v)
+	}
+	_, err := fmt.Fprintln(u.out, strings.Join(values, "\t"))
+	return err
+}
+
+// tsvEscape escapes what would break up a tab separated value: backslashes,
+// tabs and newlines
+func tsvEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
+
+// listedEntry is an entry as ls writes it in json
+type listedEntry struct {
+	UUID   string   `json:"uuid"`
+	Name   string   `json:"name"`
+	Kind   string   `json:"kind,omitempty"`
+	Labels []string `json:"labels,omitempty"`
+	Pinned bool     `json:"pinned,omitempty"`
+}
+
+// listAs is ls in a format other than text. The entries are sorted by name,
+// as tsv each is a line of its uuid, name, kind and labels (split by commas).
+func (u *uiContext) listAs(search, format string) error {
+	entries, err := u.store.Search(search)
+	if err != nil {
+		return err
+	}
+
+	uuids := entries.UUIDs()
+	sort.Slice(uuids, func(i, j int) bool { return entries[uuids[i]] < entries[uuids[j]] })
+
+	kinds := u.store.DB.Values(blobformat.KeyKind)
+	labels := u.store.DB.Values(blobformat.KeyLabels)
+	pinned := u.store.Pinned()
+
+	if format == formatTSV {
+		for _, uuid := range uuids {
+			if err = u.writeTSV(uuid, entries[uuid], kinds[uuid], labels[uuid]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	listed := make([]listedEntry, len(uuids))
+	for i, uuid := range uuids {
+		listed[i] = listedEntry{
+			UUID:   uuid,
+			Name:   entries[uuid],
+			Kind:   kinds[uuid],
+			Pinned: pinned[uuid],
+		}
+		if len(labels[uuid]) != 0 {
+			listed[i].Labels = strings.Split(labels[uuid], ",")
+		}
+	}
+	return u.writeJSON(listed)
+}
+
+// shownEntry is an entry as show writes it in json
+type shownEntry struct {
+	UUID        string            `json:"uuid"`
+	Name        string            `json:"name"`
+	Values      map[string]string `json:"values"`
+	Attachments []shownAttachment `json:"attachments,omitempty"`
+}
+
+type shownAttachment struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// maskedValue is written in place of the values show hides
+const maskedValue = "********"
+
+// showAs is show in a format other than text. The values are the entry's
+// keys with links resolved, times (updated, pinned...) as RFC3339 and the
+// current totp code in place of its secret like show has them. Old
+// passwords are left to get passhist. Unless reveal is set the values show
+// hides (passwords, secret keys, recovery codes, private keys and the
+// secrets of kinds like cards) are written as maskedValue, card numbers are
+// masked like show does and passkeys give only the type of their key.
+//
+// As tsv each value is a line of the key and the value after a line for
+// the uuid and name. Attachments are left out of the values, tsv has a line
+// of attachment.<name> and the size for each.
+func (u *uiContext) showAs(search string, snapshot int, reveal bool, format string) error {
+	uuid, err := u.findOne(search)
+	if err != nil {
+		return err
+	}
+	if len(uuid) == 0 {
+		return nil
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+	if snapshot != 0 {
+		entry, err := u.store.EntrySnapshotAt(uuid, snapshot)
+		if err != nil {
+			errColor.Println(err)
+			return nil
+		}
+		blob = blobformat.Blob(entry)
+	}
+
+	shown := shownEntry{UUID: uuid, Name: blob.Name(), Values: make(map[string]string)}
+	for _, k := range blob.Keys() {
+		switch {
+		case k == blobformat.KeyName, k == blobformat.KeyPassHistory, blobformat.IsAttachmentKey(k):
+			continue
+		case k == blobformat.KeyTwoFactor:
+			if code, err := blob.TwoFactor(); err == nil {
+				shown.Values[k] = code
+			}
+			continue
+		}
+
+		value, err := u.store.Resolve(blob, k)
+		if blobformat.IsBrokenLink(err) {
+			value = blob[k]
+		} else if err != nil {
+			return err
+		}
+		if !reveal {
+			value = maskValue(blob, k, value)
+		}
+		shown.Values[k] = formatTimestamp(blob, k, value)
+	}
+	for _, a := range blob.Attachments() {
+		shown.Attachments = append(shown.Attachments, shownAttachment{Name: a.Name, Size: a.Size, SHA256: a.Sum})
+	}
+
+	if format == formatJSON {
+		return u.writeJSON(shown)
+	}
+
+	keys := make([]string, 0, len(shown.Values))
+	for k := range shown.Values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if err = u.writeTSV("uuid", uuid); err != nil {
+		return err
+	}
+	if err = u.writeTSV(blobformat.KeyName, shown.Name); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err = u.writeTSV(k, shown.Values[k]); err != nil {
+			return err
+		}
+	}
+	for _, a := range shown.Attachments {
+		if err = u.writeTSV(blobformat.AttachmentKey(a.Name), strconv.Itoa(a.Size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maskValue masks a value of the entry that show hides or masks, other
+// values are returned as they are
+func maskValue(blob blobformat.Blob, key, value string) string {
+	switch key {
+	case blobformat.KeyPass, blobformat.KeyRecovery, blobformat.KeyPriv:
+		if blob.Kind() != blobformat.KindPasskey || key != blobformat.KeyPriv {
+			return maskedValue
+		}
+	}
+	if blob.IsSecret(key) {
+		return maskedValue
+	}
+
+	masked, hidden, ok := maskKindValue(blob.Kind(), key, value)
+	switch {
+	case !ok:
+		return value
+	case hidden:
+		return maskedValue
+	default:
+		return masked
+	}
+}
+
+// formatTimestamp gives the keys that are times in unix nanoseconds as
+// RFC3339, other values are returned as they are
+func formatTimestamp(blob blobformat.Blob, key, value string) string {
+	var at func() (time.Time, error)
+	switch key {
+	case blobformat.KeyUpdated:
+		at = blob.Updated
+	case blobformat.KeyTrashed:
+		at = blob.Trashed
+	case blobformat.KeyPinned:
+		at = blob.PinnedAt
+	case blobformat.KeyLocked:
+		at = blob.LockedAt
+	default:
+		return value
+	}
+
+	if t, err := at(); err == nil {
+		return t.Format(time.RFC3339)
+	}
+	return value
+}
+
+// gotValue is a value as get writes it in json
+type gotValue struct {
+	Name  string `json:"name"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// oldPassword is an old password as get writes it in json
+type oldPassword struct {
+	Replaced string `json:"replaced"`
+	Pass     string `json:"pass"`
+}
+
+// getAs is get in a format other than text, as tsv the value is written on
+// its own. passhist without an index is every old password, as tsv each is
+// a line of when it was replaced and the password.
+func (u *uiContext) getAs(search, key string, index int, reveal bool, format string) error {
+	uuid, err := u.findOne(search)
+	if err != nil {
+		return err
+	}
+	if len(uuid) == 0 {
+		return nil
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+
+	var value string
+	switch key {
+	case blobformat.KeyPassHistory:
+		old := blob.PassHistory()
+		if index < 0 {
+			return u.writeOldPasswords(old, format)
+		}
+		if index == 0 || index > len(old) {
+			errColor.Printf("%s has %d old passwords\n", blob.Name(), len(old))
+			return nil
+		}
+		value = old[index-1].Pass
+	case blobformat.KeyTwoFactor:
+		value, err = blob.TwoFactor()
+		if err != nil {
+			errColor.Println(err)
+			return nil
+		}
+	default:
+		if _, ok := blob[key]; !ok {
+			errColor.Printf("%s.%s is not set\n", blob.Name(), key)
+			return nil
+		}
+		if blob.IsSecret(key) && !reveal {
+			errColor.Printf("%s.%s is secret, use get --reveal to show it or cp to copy it\n", blob.Name(), key)
+			return nil
+		}
+
+		value, err = u.store.Resolve(blob, key)
+		if blobformat.IsBrokenLink(err) {
+			errColor.Println(err)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		value = formatTimestamp(blob, key, value)
+	}
+
+	if format == formatTSV {
+		return u.writeTSV(value)
+	}
+	return u.writeJSON(gotValue{Name: blob.Name(), Key: key, Value: value})
+}
+
+func (u *uiContext) writeOldPasswords(old []blobformat.OldPass, format string) error {
+	passwords := make([]oldPassword, len(old))
+	for i, o := range old {
+		passwords[i] = oldPassword{Replaced: o.Replaced.Format(time.RFC3339), Pass: o.Pass}
+	}
+
+	if format == formatJSON {
+		return u.writeJSON(passwords)
+	}
+	for _, p := range passwords {
+		if err := u.writeTSV(p.Replaced, p.Pass); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runQuery runs the ls, show or get subcommand and exits, the file is only
+// read
+func runQuery(u *uiContext) error {
+	u.recent = loadRecent(recentPath(u.user, u.salt))
+
+	switch {
+	case lsCmd.Used && len(u.format) != 0:
+		return u.listAs(flagQuery, u.format)
+	case lsCmd.Used:
+		return u.list(flagQuery)
+	case showCmd.Used && len(u.format) != 0:
+		return u.showAs(flagQuery, 0, flagReveal, u.format)
+	case showCmd.Used:
+		return u.show(flagQuery, 0, false)
+	case len(u.format) != 0:
+		return u.getAs(flagQuery, flagKey, -1, flagReveal, u.format)
+	default:
+		return u.get(flagQuery, flagKey, -1, false, false, flagReveal)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestParseFormat(t *testing.T) {
+	t.Parallel()
+
+	flags := append([]string{"--raw"}, formatFlags...)
+	tests := []struct {
+		Args   []string
+		Def    string
+		Rest   []string
+		Format string
+	}{
+		{[]string{"github"}, "", []string{"github"}, ""},
+		{[]string{"github"}, formatJSON, []string{"github"}, formatJSON},
+		{[]string{"--format=tsv", "--raw", "github"}, "", []string{"--raw", "github"}, formatTSV},
+		{[]string{"--format=text", "github"}, formatJSON, []string{"github"}, ""},
+		{[]string{"github", "--format=json"}, "", []string{"github", "--format=json"}, ""},
+	}
+
+	for i, test := range tests {
+		rest, format := parseFormat(test.Args, flags, test.Def)
+		if !reflect.DeepEqual(rest, test.Rest) || format != test.Format {
+			t.Errorf("%d) want: %q %q, got: %q %q", i, test.Rest, test.Format, rest, format)
+		}
+	}
+}
+
+func TestFormats(t *testing.T) {
+	t.Parallel()
+
+	buf := new(bytes.Buffer)
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: buf}
+	uuid, err := u.store.New("github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.store.Set(uuid, blobformat.KeyUser, "aarondl"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.store.Set(uuid, blobformat.KeyNotes, "a\tb\nc"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.store.AddLabel(uuid, "work"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = u.store.New("aws"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = u.listAs("", formatJSON); err != nil {
+		t.Fatal(err)
+	}
+	var listed []listedEntry
+	if err = json.Unmarshal(buf.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 2 || listed[0].Name != "aws" || listed[1].UUID != uuid || !reflect.DeepEqual(listed[1].Labels, []string{"work"}) {
+		t.Errorf("entries were wrong: %#v", listed)
+	}
+
+	buf.Reset()
+	if err = u.listAs("git", formatTSV); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != uuid+"\tgithub\t\twork\n" {
+		t.Errorf("tsv was wrong: %q", got)
+	}
+
+	buf.Reset()
+	if err = u.showAs("github", 0, false, formatJSON); err != nil {
+		t.Fatal(err)
+	}
+	var shown shownEntry
+	if err = json.Unmarshal(buf.Bytes(), &shown); err != nil {
+		t.Fatal(err)
+	}
+	if shown.Name != "github" || shown.Values[blobformat.KeyUser] != "aarondl" || shown.Values[blobformat.KeyNotes] != "a\tb\nc" {
+		t.Errorf("entry was wrong: %#v", shown)
+	}
+	if _, ok := shown.Values[blobformat.KeyName]; ok {
+		t.Error("the name shouldn't be in the values")
+	}
+
+	buf.Reset()
+	if err = u.showAs("github", 0, false, formatTSV); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); !strings.Contains(got, "\nnotes\ta\\tb\\nc\n") || !strings.HasPrefix(got, "uuid\t"+uuid+"\nname\tgithub\n") {
+		t.Errorf("tsv was wrong: %q", got)
+	}
+
+	buf.Reset()
+	if err = u.getAs("github", blobformat.KeyUser, -1, false, formatJSON); err != nil {
+		t.Fatal(err)
+	}
+	var got gotValue
+	if err = json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != (gotValue{Name: "github", Key: blobformat.KeyUser, Value: "aarondl"}) {
+		t.Errorf("value was wrong: %#v", got)
+	}
+
+	// Secrets still need --reveal
+	if _, err = u.store.MarkSecret(uuid, blobformat.KeyUser); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err = u.getAs("github", blobformat.KeyUser, -1, false, formatTSV); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.getAs("github", blobformat.KeyUser, -1, true, formatTSV); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "aarondl\n" {
+		t.Errorf("only the revealed value should be written: %q", got)
+	}
+}
+
+func TestShowAsMasks(t *testing.T) {
+	t.Parallel()
+
+	buf := new(bytes.Buffer)
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: buf}
+	uuid, err := u.store.New("visa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.store.DB.Set(uuid, blobformat.KeyKind, blobformat.KindCard)
+	values := map[string]string{
+		blobformat.KeyPass:   "hunter2",
+		blobformat.KeyNumber: "4111111111111111",
+		blobformat.KeyCVV:    "123",
+		"apikey":             "abcdef",
+		blobformat.KeyUser:   "aarondl",
+	}
+	for k, v := range values {
+		if err = u.store.Set(uuid, k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = u.store.MarkSecret(uuid, "apikey"); err != nil {
+		t.Fatal(err)
+	}
+
+	masked := map[string]string{
+		blobformat.KeyPass:   maskedValue,
+		blobformat.KeyNumber: blobformat.MaskCardNumber("4111111111111111"),
+		blobformat.KeyCVV:    maskedValue,
+		"apikey":             maskedValue,
+		blobformat.KeyUser:   "aarondl",
+	}
+
+	for _, reveal := range []bool{false, true} {
+		want := masked
+		if reveal {
+			want = values
+		}
+
+		buf.Reset()
+		if err = u.showAs("visa", 0, reveal, formatJSON); err != nil {
+			t.Fatal(err)
+		}
+		var shown shownEntry
+		if err = json.Unmarshal(buf.Bytes(), &shown); err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range want {
+			if got := shown.Values[k]; got != v {
+				t.Errorf("json reveal=%t %s: want %q, got %q", reveal, k, v, got)
+			}
+		}
+
+		buf.Reset()
+		if err = u.showAs("visa", 0, reveal, formatTSV); err != nil {
+			t.Fatal(err)
+		}
+		got := buf.String()
+		for k, v := range want {
+			if !strings.Contains(got, "\n"+k+"\t"+v+"\n") {
+				t.Errorf("tsv reveal=%t %s: want %q in %q", reveal, k, v, got)
+			}
+		}
+		if !reveal && strings.Contains(got, "hunter2") {
+			t.Error("the password was written without --reveal")
+		}
+	}
+}
@@ -3,15 +3,17 @@ module github.com/aarondl/bpass
 go 1.13
 
 require (
+	filippo.io/age v1.0.0
 	github.com/aarondl/color v0.0.0-20191031162153-2a82c25a0dcf
 	github.com/aarondl/readline v0.0.1
 	github.com/atotto/clipboard v0.1.2
+	github.com/boombuler/barcode v1.0.1-0.20190219062509-6c824513bacc
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/enceve/crypto v0.0.0-20160707101852-34d48bb93815
 	github.com/gofrs/uuid v3.2.0+incompatible
 	github.com/integrii/flaggy v1.2.2
 	github.com/mattn/go-colorable v0.1.4
 	github.com/pquerna/otp v1.2.0
-	golang.org/x/crypto v0.0.0-20191002192127-34f69633bfdc
-	golang.org/x/sys v0.0.0-20191010194322-b09406accb47
+	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5
+	golang.org/x/sys v0.0.0-20210903071746-97244b99971b
 )
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aarondl/bpass/fuzzy"
+)
+
+// helpTopics are the long form help texts that aren't about a single command
+var helpTopics = map[string]string{
+	"sync":      syncHelp,
+	"users":     usersHelp,
+	"keys":      keysHelp,
+	"entries":   entriesHelp,
+	"templates": templatesHelp,
+	"output":    outputHelp,
+	"changes":   changesHelp,
+	"other":     otherHelp,
+}
+
+func init() {
+	// help is attached here since it refers to replCmds which would be an
+	// initialization loop otherwise
+	cmd := replCmds["help"]
+	cmd.Run = helpCmd
+	replCmds["help"] = cmd
+}
+
+func helpCmd(r *repl, cmd string, args []string) error {
+	if len(args) == 0 {
+		fmt.Print(replHelp)
+		return nil
+	}
+
+	if args[0] == "search" && len(args) > 1 {
+		helpSearch(strings.Join(args[1:], " "))
+		return nil
+	}
+
+	if topic, ok := helpTopics[args[0]]; ok {
+		fmt.Print(topic)
+		return nil
+	}
+
+	c, ok := replCmds[args[0]]
+	if !ok {
+		errColor.Printf("no help for %q%s\n", args[0], didYouMean(args[0]))
+		return nil
+	}
+
+	showCmdHelp(args[0], c)
+	return nil
+}
+
+func showCmdHelp(name string, c replCmd) {
+	keyColor.Println("usage:", c.Usage)
+	fmt.Println()
+	fmt.Println(wrap(c.Desc, 78))
+	if len(c.Examples) != 0 {
+		fmt.Println()
+		keyColor.Println("examples:")
+		for _, e := range c.Examples {
+			fmt.Println(" ", e)
+		}
+	}
+	if c.ReadOnly {
+		fmt.Println()
+		hideColor.Println("(available in read-only mode)")
+	}
+}
+
+// helpSearch fuzzy matches the query against command names, usages,
+// descriptions and help topics
+func helpSearch(query string) {
+	var names []string
+	for name, c := range replCmds {
+		if fuzzy.MatchFold(name, query) ||
+			strings.Contains(strings.ToLower(c.Usage), strings.ToLower(query)) ||
+			strings.Contains(strings.ToLower(c.Desc), strings.ToLower(query)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var topics []string
+	for name, text := range helpTopics {
+		if fuzzy.MatchFold(name, query) || strings.Contains(strings.ToLower(text), strings.ToLower(query)) {
+			topics = append(topics, name)
+		}
+	}
+	sort.Strings(topics)
+
+	if len(names) == 0 && len(topics) == 0 {
+		infoColor.Printf("nothing found for %q\n", query)
+		return
+	}
+
+	for _, name := range names {
+		fmt.Printf("%s - %s\n", keyColor.Sprintf("%-10s", name), firstSentence(replCmds[name].Desc))
+	}
+	for _, t := range topics {
+		fmt.Printf("%s - help topic (help %s)\n", keyColor.Sprintf("%-10s", t), t)
+	}
+}
+
+// didYouMean returns a suggestion (with leading punctuation) for a mistyped
+// command or an empty string if there's nothing close
+func didYouMean(cmd string) string {
+	// Short commands are all within a couple edits of each other
+	maxDist := 2
+	if len(cmd) <= 3 {
+		maxDist = 1
+	}
+
+	var near []string
+	for name := range replCmds {
+		if levenshtein(cmd, name) <= maxDist || (len(cmd) > 1 && strings.HasPrefix(name, cmd)) {
+			near = append(near, name)
+		}
+	}
+	for name := range helpTopics {
+		if _, ok := replCmds[name]; !ok && levenshtein(cmd, name) <= maxDist {
+			near = append(near, name)
+		}
+	}
+
+	if len(near) == 0 {
+		return ""
+	}
+
+	sort.Strings(near)
+	return fmt.Sprintf(", did you mean: %s?", strings.Join(near, ", "))
+}
+
+// levenshtein is the edit distance between a and b
+func levenshtein(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ar); i++ {
+		cur[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+
+			cur[j] = prev[j] + 1
+			if ins := cur[j-1] + 1; ins < cur[j] {
+				cur[j] = ins
+			}
+			if sub := prev[j-1] + cost; sub < cur[j] {
+				cur[j] = sub
+			}
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(br)]
+}
+
+func firstSentence(s string) string {
+	if i := strings.Index(s, ". "); i >= 0 {
+		return s[:i+1]
+	}
+	return s
+}
+
+// wrap breaks s into lines no longer than width at spaces
+func wrap(s string, width int) string {
+	var b strings.Builder
+	lineLen := 0
+	for i, word := range strings.Fields(s) {
+		if i != 0 {
+			if lineLen+1+len(word) > width {
+				b.WriteByte('\n')
+				lineLen = 0
+			} else {
+				b.WriteByte(' ')
+				lineLen++
+			}
+		}
+		b.WriteString(word)
+		lineLen += len(word)
+	}
+
+	return b.String()
+}
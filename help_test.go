@@ -0,0 +1,63 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestReplCmdsDocumented(t *testing.T) {
+	t.Parallel()
+
+	topicRef := regexp.MustCompile(`"help ([a-z]+)"`)
+	for name, c := range replCmds {
+		if len(c.Usage) == 0 || len(c.Desc) == 0 {
+			t.Errorf("%s is missing usage or description", name)
+		}
+		// Details go in help topics, the description is one short line
+		if len(c.Desc) > 100 || strings.Contains(c.Desc, ". ") {
+			t.Errorf("%s description should be one short sentence: %q", name, c.Desc)
+		}
+		for _, ref := range topicRef.FindAllStringSubmatch(c.Desc, -1) {
+			if _, ok := helpTopics[ref[1]]; !ok {
+				t.Errorf("%s refers to a help topic that doesn't exist: %s", name, ref[1])
+			}
+		}
+		if c.Run == nil {
+			t.Errorf("%s has no run function", name)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		A, B string
+		Dist int
+	}{
+		{"", "", 0},
+		{"ls", "ls", 0},
+		{"", "abc", 3},
+		{"sho", "show", 1},
+		{"rmlable", "rmlabel", 2},
+		{"kitten", "sitting", 3},
+	}
+
+	for i, test := range tests {
+		if got := levenshtein(test.A, test.B); got != test.Dist {
+			t.Errorf("%d) %q %q want: %d got: %d", i, test.A, test.B, test.Dist, got)
+		}
+	}
+}
+
+func TestDidYouMean(t *testing.T) {
+	t.Parallel()
+
+	if got := didYouMean("shwo"); got != ", did you mean: show?" {
+		t.Error("wrong suggestion:", got)
+	}
+	if got := didYouMean("zzzzzzzz"); got != "" {
+		t.Error("should have no suggestion:", got)
+	}
+}
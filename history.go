@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+// history lists the changes made to an entry newest first, numbered by
+// the snapshot they made so they can be given to show and diff
+func (u *uiContext) history(search string) error {
+	uuid, err := u.findOne(search)
+	if err != nil {
+		return err
+	}
+	if len(uuid) == 0 {
+		return nil
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+
+	txs := u.store.EntryHistory(uuid)
+	width := len(strconv.Itoa(len(txs) - 1))
+	for i := len(txs) - 1; i >= 0; i-- {
+		tx := txs[i]
+		// Every change touches updated, it would only double the list, and
+		// attachments are listed without their chunks
+		if tx.Kind == txlogs.TxSetKey && tx.Key == blobformat.KeyUpdated || blobformat.IsAttachmentChunk(tx.Key) {
+			continue
+		}
+
+		var change string
+		switch tx.Kind {
+		case txlogs.TxAdd:
+			change = "added"
+		case txlogs.TxDelete:
+			change = "deleted"
+		case txlogs.TxSetKey:
+			change = fmt.Sprintf("set %s %s", keyColor.Sprint(tx.Key), historyValue(blob, tx.Key, tx.Value))
+		case txlogs.TxDeleteKey:
+			change = fmt.Sprintf("rmk %s", keyColor.Sprint(tx.Key))
+		}
+
+		when := time.Unix(0, tx.Time).Format(time.RFC3339)
+		fmt.Fprintf(u.out, "  %*d %s %s\n", width, len(txs)-1-i, when, change)
+	}
+
+	if len(txs) == 0 {
+		infoColor.Printf("%s has no history\n", blob.Name())
+	}
+
+	return nil
+}
+
+// diff shows what changed in an entry from one snapshot to another, see
+// show for the numbering
+func (u *uiContext) diff(search string, from, to int) error {
+	uuid, err := u.findOne(search)
+	if err != nil {
+		return err
+	}
+	if len(uuid) == 0 {
+		return nil
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+
+	snaps := u.store.NVersions(uuid)
+	entries := make([]txlogs.Entry, 2)
+	for i, snapshot := range []int{from, to} {
+		switch {
+		case snapshot < 0 || snapshot > snaps:
+			errColor.Printf("%s only has %d snapshots\n", blob.Name(), snaps)
+			return nil
+		case snapshot == snaps:
+			// The entry didn't exist yet
+			continue
+		}
+
+		entries[i], err = u.store.EntrySnapshotAt(uuid, snapshot)
+		if err != nil {
+			errColor.Println(err)
+			return nil
+		}
+	}
+
+	diffs := txlogs.DiffEntries(entries[0], entries[1])
+	if len(diffs) == 0 {
+		infoColor.Println("no differences")
+		return nil
+	}
+
+	for _, d := range diffs {
+		if blobformat.IsAttachmentChunk(d.Key) {
+			continue
+		}
+
+		key := keyColor.Sprint(d.Key + ":")
+		switch {
+		case d.Added:
+			fmt.Fprintf(u.out, "  + %s %s\n", key, historyValue(blob, d.Key, d.New))
+		case d.Removed:
+			fmt.Fprintf(u.out, "  - %s %s\n", key, historyValue(blob, d.Key, d.Old))
+		default:
+			fmt.Fprintf(u.out, "  ~ %s %s -> %s\n", key, historyValue(blob, d.Key, d.Old), historyValue(blob, d.Key, d.New))
+		}
+	}
+
+	return nil
+}
+
+// historyValue formats a value to fit on one line, secrets (and the keys the
+// entry has marked secret) are hidden the way show hides passwords
+func historyValue(blob blobformat.Blob, key, value string) string {
+	if blob.IsSecret(key) {
+		return hideColor.Sprint(value)
+	}
+
+	switch key {
+	case blobformat.KeyUpdated:
+		if nanos, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.Unix(0, nanos).Format(time.RFC3339)
+		}
+	case blobformat.KeyPass, blobformat.KeyTwoFactor, blobformat.KeyRemotePass, blobformat.KeyPassHistory:
+		return hideColor.Sprint(value)
+	}
+
+	if n := strings.Count(value, "\n"); n != 0 {
+		return fmt.Sprintf("(%d lines)", n+1)
+	}
+	return value
+}
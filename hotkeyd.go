@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/crypt"
+	"github.com/aarondl/bpass/osutil"
+	"github.com/aarondl/bpass/secmem"
+	"github.com/aarondl/bpass/txlogs"
+
+	"github.com/atotto/clipboard"
+)
+
+const (
+	hotkeyModeCopy = "copy"
+	hotkeyModeType = "type"
+
+	// hotkeyClipClear is how long a copied password stays in the clipboard
+	hotkeyClipClear = 45 * time.Second
+)
+
+// hotkeyd keeps the file open in the background and serves pick requests
+// over a unix socket. The global shortcut itself is bound with whatever the
+// desktop uses (sxhkd, i3, skhd, gnome etc.) to run: bpass hotkeyd --pick
+type hotkeyd struct {
+	u       *uiContext
+	modTime time.Time
+}
+
+// hotkeydSocket is the path to the socket for the current user
+func hotkeydSocket() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if len(dir) == 0 {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, fmt.Sprintf("bpass-hotkeyd-%d.sock", os.Getuid()))
+}
+
+// runHotkeyd serves pick requests until interrupted, the file must already
+// be loaded into u.
+func runHotkeyd(u *uiContext) error {
+	sock := hotkeydSocket()
+	if conn, err := net.Dial("unix", sock); err == nil {
+		_ = conn.Close()
+		return errors.New("hotkeyd is already running")
+	}
+	// Remove a socket left behind by a daemon that did not exit cleanly
+	_ = os.Remove(sock)
+
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(sock, 0600); err != nil {
+		_ = listener.Close()
+		return err
+	}
+
+	h := hotkeyd{u: u}
+	if stat, err := os.Stat(flagFile); err == nil {
+		h.modTime = stat.ModTime()
+	}
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+		_ = listener.Close()
+	}()
+
+	infoColor.Println("hotkeyd listening on:", sock)
+	infoColor.Println(`bind a global shortcut to: bpass hotkeyd --pick [--type]`)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-stop:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		h.serve(conn)
+	}
+}
+
+func (h *hotkeyd) serve(conn net.Conn) {
+	defer conn.Close()
+
+	// Leave plenty of time for the user to choose in the picker
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Minute))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+
+	if err = h.pick(strings.TrimSpace(line)); err != nil {
+		errColor.Println("pick failed:", err)
+		fmt.Fprintln(conn, "error:", err)
+		return
+	}
+
+	fmt.Fprintln(conn, "ok")
+}
+
+// pick pops up the picker and copies or types the chosen entry's password
+func (h *hotkeyd) pick(mode string) error {
+	if mode != hotkeyModeCopy && mode != hotkeyModeType {
+		return fmt.Errorf("unknown mode: %q", mode)
+	}
+
+	if err := h.reload(); err != nil {
+		return fmt.Errorf("failed to reload file: %w", err)
+	}
+
+	entries, err := h.u.store.Search("")
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, name := range entries.Names() {
+		if blobformat.IsSyncEntry(name) || blobformat.IsUserEntry(name) || blobformat.IsSystemEntry(name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	choice, err := osutil.Pick("bpass", names)
+	if err != nil || len(choice) == 0 {
+		return err
+	}
+
+	_, blob, err := h.u.store.FindByName(choice)
+	if err != nil {
+		return err
+	} else if blob == nil {
+		return fmt.Errorf("could not find entry: %q", choice)
+	}
+
+	pass, err := h.u.store.Resolve(blob, blobformat.KeyPass)
+	if err != nil {
+		return err
+	}
+	if len(pass) == 0 {
+		return fmt.Errorf("%s has no password", choice)
+	}
+
+	if mode == hotkeyModeType {
+		return osutil.TypeText(pass)
+	}
+
+	if err = clipboard.WriteAll(pass); err != nil {
+		return err
+	}
+
+	go func() {
+		time.Sleep(hotkeyClipClear)
+		if current, err := clipboard.ReadAll(); err == nil && current == pass {
+			_ = clipboard.WriteAll("")
+		}
+	}()
+
+	return nil
+}
+
+// reload re-reads the file if it has changed since we loaded it, the
+// credentials from the initial load are used so there's no prompting.
+func (h *hotkeyd) reload() error {
+	stat, err := os.Stat(flagFile)
+	if err != nil {
+		return err
+	}
+	if !stat.ModTime().After(h.modTime) {
+		return nil
+	}
+
+	payload, err := ioutil.ReadFile(flagFile)
+	if err != nil {
+		return err
+	}
+
+	u := h.u
+	version, params, pt, err := crypt.Decrypt([]byte(u.user), []byte(u.pass), u.key.Bytes(), u.salt, payload)
+	if err != nil {
+		return err
+	}
+
+	store, err := txlogs.New(pt)
+	secmem.Wipe(pt)
+	if err != nil {
+		return err
+	}
+	if store == nil {
+		store = new(txlogs.DB)
+	}
+
+	u.setKey(params.Keys[params.User])
+	u.salt = params.Salts[params.User]
+	u.setMaster(params.Master)
+	u.ivm = params.IVM
+	u.keepSlot(version, params)
+	u.store = blobformat.Blobs{DB: store}
+	h.modTime = stat.ModTime()
+
+	return nil
+}
+
+// hotkeyPick asks a running hotkeyd to pop up the picker
+func hotkeyPick(mode string) error {
+	conn, err := net.Dial("unix", hotkeydSocket())
+	if err != nil {
+		return errors.New("could not connect to hotkeyd, is it running?")
+	}
+	defer conn.Close()
+
+	if _, err = fmt.Fprintln(conn, mode); err != nil {
+		return err
+	}
+
+	resp, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+
+	resp = strings.TrimSpace(resp)
+	if resp != "ok" {
+		return errors.New(strings.TrimPrefix(resp, "error: "))
+	}
+
+	return nil
+}
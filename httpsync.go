@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+const (
+	// serveSyncTokenEnv holds the token clients must present to serve-sync
+	serveSyncTokenEnv = "BPASS_SYNC_TOKEN"
+	// serveSyncMaxBlob is the largest file serve-sync accepts
+	serveSyncMaxBlob = 64 << 20
+)
+
+// serveSyncName is what file names on a serve-sync server may look like,
+// they're only ever a single path element
+var serveSyncName = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// syncServer stores encrypted files pushed to it by bpass instances and
+// serves them back to be pulled. It never decrypts anything, the files are
+// only as readable as they are when they're pushed to an scp server.
+type syncServer struct {
+	dir   string
+	token string
+}
+
+// runServeSync serves sync files over http(s) until interrupted
+func runServeSync() error {
+	token := os.Getenv(serveSyncTokenEnv)
+	if len(token) == 0 {
+		return fmt.Errorf("$%s must be set to the token clients sync with", serveSyncTokenEnv)
+	}
+
+	dir, err := filepath.Abs(flagServeSyncDir)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	useTLS := len(flagServeSyncCert) != 0 || len(flagServeSyncKey) != 0
+	if useTLS && (len(flagServeSyncCert) == 0 || len(flagServeSyncKey) == 0) {
+		return errors.New("--tls-cert and --tls-key must be used together")
+	}
+
+	server := &http.Server{
+		Addr:         flagServeSyncListen,
+		Handler:      syncServer{dir: dir, token: token},
+		ReadTimeout:  time.Minute,
+		WriteTimeout: time.Minute,
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		_ = server.Shutdown(context.Background())
+	}()
+
+	if useTLS {
+		infoColor.Printf("serving %s on https://%s\n", dir, flagServeSyncListen)
+		err = server.ListenAndServeTLS(flagServeSyncCert, flagServeSyncKey)
+	} else {
+		infoColor.Printf("serving %s on http://%s\n", dir, flagServeSyncListen)
+		errColor.Println("not using tls, the token can be seen by anyone on the network")
+		err = server.ListenAndServe()
+	}
+
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (s syncServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") ||
+		subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(s.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if !serveSyncName.MatchString(name) {
+		http.Error(w, "bad file name", http.StatusBadRequest)
+		return
+	}
+	path := filepath.Join(s.dir, name)
+
+	switch r.Method {
+	case http.MethodGet:
+		b, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "failed to read file", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(b)
+	case http.MethodPut:
+		b, err := ioutil.ReadAll(io.LimitReader(r.Body, serveSyncMaxBlob+1))
+		if err != nil {
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		} else if len(b) > serveSyncMaxBlob {
+			http.Error(w, "file too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if err = writeFileAtomic(path, b); err != nil {
+			http.Error(w, "failed to write file", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeFileAtomic writes to a temporary file in the same directory and
+// renames it over path so readers never see a partial file
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+// httpPull downloads a file from a serve-sync server
+func httpPull(s syncSettings, entry txlogs.Entry) ([]byte, error) {
+	resp, err := httpSyncRequest(s, entry, http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return ioutil.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, errNotFound
+	default:
+		return nil, httpSyncErr(resp)
+	}
+}
+
+// httpPush uploads a file to a serve-sync server
+func httpPush(s syncSettings, entry txlogs.Entry, payload []byte) error {
+	resp, err := httpSyncRequest(s, entry, http.MethodPut, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return httpSyncErr(resp)
+	}
+	return nil
+}
+
+func httpSyncRequest(s syncSettings, entry txlogs.Entry, method string, body []byte) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+
+	req, err := http.NewRequest(method, entry[blobformat.KeyURL], bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+entry[blobformat.KeyPass])
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// The body must be read before the timeout is cancelled
+	resp.Body = cancelCloser{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// httpSyncErr turns a failed response into an error
+func httpSyncErr(resp *http.Response) error {
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("server said %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// cancelCloser cancels a context when it's closed
+type cancelCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelCloser) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
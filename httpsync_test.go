@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestSyncServer(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "bpass-serve-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewServer(syncServer{dir: dir, token: "hunter2"})
+	defer server.Close()
+
+	do := func(method, path, token string, body []byte) (int, []byte) {
+		t.Helper()
+
+		req, err := http.NewRequest(method, server.URL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(token) != 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, b
+	}
+
+	if code, _ := do(http.MethodGet, "/file.blob", "", nil); code != http.StatusUnauthorized {
+		t.Error("want unauthorized without a token, got:", code)
+	}
+	if code, _ := do(http.MethodGet, "/file.blob", "hunter3", nil); code != http.StatusUnauthorized {
+		t.Error("want unauthorized with a bad token, got:", code)
+	}
+	if code, _ := do(http.MethodGet, "/file.blob", "hunter2", nil); code != http.StatusNotFound {
+		t.Error("want not found, got:", code)
+	}
+	for _, path := range []string{"/", "/.hidden", "/a/b", "/..%2fescape"} {
+		if code, _ := do(http.MethodPut, path, "hunter2", []byte("x")); code != http.StatusBadRequest {
+			t.Errorf("%s: want bad request, got: %d", path, code)
+		}
+	}
+	if code, _ := do(http.MethodDelete, "/file.blob", "hunter2", nil); code != http.StatusMethodNotAllowed {
+		t.Error("want method not allowed, got:", code)
+	}
+
+	if code, _ := do(http.MethodPut, "/file.blob", "hunter2", []byte("contents")); code != http.StatusNoContent {
+		t.Error("want no content, got:", code)
+	}
+	code, b := do(http.MethodGet, "/file.blob", "hunter2", nil)
+	if code != http.StatusOK || string(b) != "contents" {
+		t.Errorf("want the pushed file, got: %d %q", code, b)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "file.blob" {
+		t.Error("want only the pushed file in the directory, got:", len(files))
+	}
+	if _, err = os.Stat(filepath.Join(dir, "file.blob")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHTTPPullPush(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "bpass-serve-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewServer(syncServer{dir: dir, token: "hunter2"})
+	defer server.Close()
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}}
+	s := u.syncSettings()
+	entry := txlogs.Entry{
+		blobformat.KeyURL:  server.URL + "/file.blob",
+		blobformat.KeyPass: "hunter2",
+	}
+
+	if _, err = httpPull(s, entry); err != errNotFound {
+		t.Error("want not found, got:", err)
+	}
+	if err = httpPush(s, entry, []byte("contents")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := httpPull(s, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "contents" {
+		t.Errorf("want the pushed file, got: %q", b)
+	}
+
+	entry[blobformat.KeyPass] = "wrong"
+	if err = httpPush(s, entry, []byte("contents")); err == nil {
+		t.Error("want an error with the wrong token")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"github.com/aarondl/bpass/blobformat"
+
+	"github.com/aarondl/color"
+)
+
+// entryColors are the colors of blobformat.EntryColors by name
+var entryColors = map[string]color.Color{
+	"red":           color.FgRed,
+	"green":         color.FgGreen,
+	"yellow":        color.FgYellow,
+	"blue":          color.FgBlue,
+	"magenta":       color.FgMagenta,
+	"cyan":          color.FgCyan,
+	"white":         color.FgWhite,
+	"grey":          color.FgGrey,
+	"brightred":     color.FgBrightRed,
+	"brightgreen":   color.FgBrightGreen,
+	"brightyellow":  color.FgBrightYellow,
+	"brightblue":    color.FgBrightBlue,
+	"brightmagenta": color.FgBrightMagenta,
+	"brightcyan":    color.FgBrightCyan,
+	"brightwhite":   color.FgBrightWhite,
+}
+
+// iconPadding lines up the names of entries without an icon with the ones
+// that have one, emoji are two columns wide
+const iconPadding = "   "
+
+// entryName is an entry's name the way it's listed: in its color (def when
+// it has none, plain when def is color.Reset) with its icon in front of it
+func entryName(name, icon, col string, def color.Color) string {
+	c, ok := entryColors[col]
+	if !ok {
+		c = def
+	}
+	if c != color.Reset {
+		name = c.Sprint(name)
+	}
+
+	if len(icon) != 0 {
+		name = icon + " " + name
+	}
+	return name
+}
+
+// listNames are the names of entries the way ls lists them, pinned are how
+// many of them come first and are pinned
+func (u *uiContext) listNames(uuids []string, names blobformat.SearchResults, pinned int) []string {
+	icons := u.store.DB.Values(blobformat.KeyIcon)
+	colors := u.store.DB.Values(blobformat.KeyColor)
+
+	var hasIcons bool
+	for _, uuid := range uuids {
+		if len(icons[uuid]) != 0 {
+			hasIcons = true
+			break
+		}
+	}
+
+	listed := make([]string, len(uuids))
+	for i, uuid := range uuids {
+		def := color.Reset
+		if i < pinned {
+			def = keyColor
+		}
+
+		listed[i] = entryName(names[uuid], icons[uuid], colors[uuid], def)
+		if hasIcons && len(icons[uuid]) == 0 {
+			listed[i] = iconPadding + listed[i]
+		}
+	}
+
+	return listed
+}
+
+// entryPrompt is the prompt when cd'd into an entry, its name is shown like
+// ls lists it
+func entryPrompt(filename string, blob blobformat.Blob) string {
+	return mainPromptColor.Sprintf("(%s):", filename) +
+		entryName(blob.Name(), blob.Icon(), blob.Color(), mainPromptColor) +
+		mainPromptColor.Sprint("> ")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+
+	"github.com/aarondl/color"
+)
+
+func TestEntryColors(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range blobformat.EntryColors {
+		if _, ok := entryColors[name]; !ok {
+			t.Errorf("%s has no color", name)
+		}
+	}
+	if len(entryColors) != len(blobformat.EntryColors) {
+		t.Error("there are colors that can't be set")
+	}
+}
+
+func TestListIcons(t *testing.T) {
+	t.Parallel()
+
+	buf := new(bytes.Buffer)
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: buf}
+	for _, name := range []string{"aws", "github"} {
+		if _, err := u.store.New(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	uuid, _, _ := u.store.FindByName("github")
+	if err := u.store.Set(uuid, blobformat.KeyIcon, "🐙"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.store.Set(uuid, blobformat.KeyColor, "magenta"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.store.Set(uuid, blobformat.KeyColor, "mauve"); !blobformat.IsInvalidValue(err) {
+		t.Error("want an invalid value error, got:", err)
+	}
+	if err := u.store.Set(uuid, blobformat.KeyIcon, "an icon"); !blobformat.IsInvalidValue(err) {
+		t.Error("want an invalid value error, got:", err)
+	}
+
+	if err := u.list(""); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("want 2 entries: %q", lines)
+	}
+	if lines[0] != iconPadding+"aws" {
+		t.Errorf("entries without an icon should line up: %q", lines[0])
+	}
+	if want := "🐙 " + color.FgMagenta.Sprint("github"); lines[1] != want {
+		t.Errorf("want: %q, got: %q", want, lines[1])
+	}
+
+	blob, _ := u.store.MustFind(uuid)
+	if prompt := entryPrompt("file", blob); !strings.Contains(prompt, "🐙 "+color.FgMagenta.Sprint("github")) {
+		t.Errorf("the prompt should have the icon and color: %q", prompt)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/aarondl/bpass/blobformat"
+)
+
+// identityFields are what addidentity asks for
+var identityFields = []kindField{
+	{Key: blobformat.KeyFullName, Prompt: "full name"},
+	{Key: blobformat.KeyBirthdate, Prompt: "birthdate (2006-01-02)", Optional: true},
+	{Key: blobformat.KeyEmail, Prompt: "email", Optional: true},
+	{Key: blobformat.KeyPhone, Prompt: "phone", Optional: true},
+	{Key: blobformat.KeyAddress, Prompt: "address", Multiline: true, Optional: true},
+	{Key: blobformat.KeyPassport, Prompt: "passport number", Hidden: true, Optional: true},
+	{Key: blobformat.KeyIDNumber, Prompt: "id number", Hidden: true, Optional: true},
+	{Key: blobformat.KeyExpires, Prompt: "passport or id expires (2006-01-02)", Optional: true},
+}
+
+// addIdentity is a wizard to add an identity entry
+func (u *uiContext) addIdentity(name string) error {
+	uuid, err := u.addKindInterruptible(name, blobformat.KindIdentity, identityFields)
+	if err != nil || len(uuid) == 0 {
+		return err
+	}
+
+	infoColor.Printf("added identity %s\n", name)
+	return nil
+}
@@ -36,78 +36,90 @@ func importLastpass(u *uiContext) error {
 		return err
 	}
 
-	reader := csv.NewReader(bytes.NewReader(out))
-
-	for i := 0; ; i++ {
-		record, err := reader.Read()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
-		}
-
-		if i == 0 {
-			if strings.Join(record, ",") != "url,username,password,extra,name,grouping,fav" {
-				return errors.New("lastpass csv format not recognized")
-			}
-		}
+	if err = importLastpassCSV(u, bytes.NewReader(out)); err != nil {
+		return err
+	}
 
-		// Fields:
-		//  0    1         2       3     4    5        6
-		// url,username,password,extra,name,grouping,fav
+	infoColor.Println("import complete")
 
-		// Create the
-		var uuid string
+	return nil
+}
 
-		// Create the new entry, make sure the name is unique
-		oldName := strings.ReplaceAll(strings.ToLower(record[4]), " ", "_")
-		newName := oldName
-		for {
-			uuid, err = u.store.New(newName)
+// importLastpassCSV adds an entry for each record of a lastpass export
+func importLastpassCSV(u *uiContext, r io.Reader) error {
+	// Everything is imported or nothing is, a bad record part way through
+	// doesn't leave half of them in the file
+	reader := csv.NewReader(r)
+	return u.store.Do(func() error {
+		for i := 0; ; i++ {
+			record, err := reader.Read()
 			if err != nil {
-				if err == blobformat.ErrNameNotUnique {
-					newName += "1"
-					continue
+				if err == io.EOF {
+					break
 				}
-
 				return err
 			}
 
-			if oldName == newName {
-				infoColor.Println("importing:", oldName)
-			} else {
-				infoColor.Printf("importing: %s => %s\n", oldName, newName)
+			if i == 0 {
+				if strings.Join(record, ",") != "url,username,password,extra,name,grouping,fav" {
+					return errors.New("lastpass csv format not recognized")
+				}
 			}
-			break
-		}
 
-		if len(record[1]) != 0 {
-			u.store.DB.Set(uuid, blobformat.KeyUser, record[1])
-		}
-		if len(record[2]) != 0 {
-			u.store.DB.Set(uuid, blobformat.KeyPass, record[2])
-		}
-		if len(record[0]) != 0 {
-			u.store.DB.Set(uuid, blobformat.KeyURL, record[0])
-		}
-		if len(record[3]) != 0 {
-			u.store.DB.Set(uuid, blobformat.KeyNotes, record[3])
-		}
+			// Fields:
+			//  0    1         2       3     4    5        6
+			// url,username,password,extra,name,grouping,fav
+
+			// Create the
+			var uuid string
+
+			// Create the new entry, make sure the name is unique
+			oldName := strings.ReplaceAll(strings.ToLower(record[4]), " ", "_")
+			newName := oldName
+			for {
+				uuid, err = u.store.New(newName)
+				if err != nil {
+					if err == blobformat.ErrNameNotUnique {
+						newName += "1"
+						continue
+					}
+
+					return err
+				}
 
-		var labels []string
-		if len(record[5]) != 0 {
-			labels = append(labels, strings.ToLower(record[5]))
-		}
-		if record[6] == "1" {
-			labels = append(labels, "lpfav")
-		}
-		if len(labels) != 0 {
-			u.store.DB.Set(uuid, blobformat.KeyLabels, strings.Join(labels, ","))
-		}
-	}
+				if oldName == newName {
+					infoColor.Println("importing:", oldName)
+				} else {
+					infoColor.Printf("importing: %s => %s\n", oldName, newName)
+				}
+				break
+			}
 
-	infoColor.Println("import complete")
+			if len(record[1]) != 0 {
+				u.store.DB.Set(uuid, blobformat.KeyUser, record[1])
+			}
+			if len(record[2]) != 0 {
+				u.store.DB.Set(uuid, blobformat.KeyPass, record[2])
+			}
+			if len(record[0]) != 0 {
+				u.store.DB.Set(uuid, blobformat.KeyURL, record[0])
+			}
+			if len(record[3]) != 0 {
+				u.store.DB.Set(uuid, blobformat.KeyNotes, record[3])
+			}
 
-	return nil
+			var labels []string
+			if len(record[5]) != 0 {
+				labels = append(labels, strings.ToLower(record[5]))
+			}
+			if record[6] == "1" {
+				labels = append(labels, "lpfav")
+			}
+			if len(labels) != 0 {
+				u.store.DB.Set(uuid, blobformat.KeyLabels, strings.Join(labels, ","))
+			}
+		}
+
+		return nil
+	})
 }
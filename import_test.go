@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestImportLastpassCSV(t *testing.T) {
+	t.Parallel()
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}}
+
+	const header = "url,username,password,extra,name,grouping,fav\n"
+	good := header + "https://github.com,me,hunter2,,GitHub,dev,1\n"
+	if err := importLastpassCSV(u, strings.NewReader(good)); err != nil {
+		t.Fatal(err)
+	}
+
+	uuid, blob, err := u.store.FindByName("github")
+	if err != nil || len(uuid) == 0 {
+		t.Fatal("github should be imported:", err)
+	}
+	if blob[blobformat.KeyPass] != "hunter2" || blob[blobformat.KeyLabels] != "dev,lpfav" {
+		t.Error("wrong entry:", blob)
+	}
+
+	// The last record has too few fields
+	n := len(u.store.Log)
+	bad := header + "https://gitlab.com,me,hunter3,,GitLab,dev,0\nhttps://example.com,me\n"
+	if err = importLastpassCSV(u, strings.NewReader(bad)); err == nil {
+		t.Error("a malformed record should fail the import")
+	}
+	if len(u.store.Log) != n || u.store.InTx() {
+		t.Error("nothing from a failed import should be left in the file:", len(u.store.Log)-n)
+	}
+}
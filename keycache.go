@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/crypt"
+	"github.com/aarondl/bpass/osutil"
+)
+
+// keyCacheName is what the key for the file and user is cached under
+func keyCacheName(user string) string {
+	return "bpass-" + fileUserID(user)
+}
+
+// fileUserID identifies the file and user on this machine, it's hashed so
+// the file's path isn't visible where it's used
+func fileUserID(user string) string {
+	path, err := filepath.Abs(flagFile)
+	if err != nil {
+		path = flagFile
+	}
+
+	sum := sha256.Sum256([]byte(path + "\x00" + user))
+	return hex.EncodeToString(sum[:16])
+}
+
+// cachedKey looks up the cached key for the user and when it expires, the
+// key is nil if there's none or it's expired
+func cachedKey(user string) ([]byte, time.Time) {
+	data, err := osutil.KeychainLoad(keyCacheName(user))
+	if err != nil {
+		if err != osutil.ErrKeyNotFound {
+			errColor.Println("failed to read cached key:", err)
+		}
+		return nil, time.Time{}
+	}
+
+	// Not every keychain expires things itself
+	if len(data) <= 8 {
+		forgetKey(user)
+		return nil, time.Time{}
+	}
+	expires := time.Unix(int64(binary.BigEndian.Uint64(data)), 0)
+	if time.Now().After(expires) {
+		forgetKey(user)
+		return nil, time.Time{}
+	}
+
+	return data[8:], expires
+}
+
+// forgetKey removes the user's cached key
+func forgetKey(user string) {
+	err := osutil.KeychainRemove(keyCacheName(user))
+	if err != nil && err != osutil.ErrKeyNotFound {
+		errColor.Println("failed to remove cached key:", err)
+	}
+}
+
+// keyCacheTTL is how long keys are cached for, 0 when they aren't
+func (u *uiContext) keyCacheTTL() time.Duration {
+	value, err := u.store.Setting(blobformat.SettingKeyCache)
+	if err != nil || len(value) == 0 {
+		return 0
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		return 0
+	}
+
+	return ttl
+}
+
+// cacheKey caches the current key with the keycache setting's ttl, counted
+// from when the passphrase was typed, so reopening the file doesn't need it.
+// When the setting is off the key is removed instead.
+func (u *uiContext) cacheKey() {
+	ttl := u.keyCacheTTL()
+	if ttl == 0 || flagNoKeyCache {
+		if !u.keyExpires.IsZero() {
+			forgetKey(u.user)
+			u.keyExpires = time.Time{}
+		}
+		return
+	}
+
+	expires := time.Now().Add(ttl)
+	if !u.keyExpires.IsZero() && u.keyExpires.Before(expires) {
+		expires = u.keyExpires
+	}
+	if !expires.After(time.Now()) {
+		forgetKey(u.user)
+		return
+	}
+
+	data := make([]byte, 8+u.key.Len())
+	binary.BigEndian.PutUint64(data, uint64(expires.Unix()))
+	copy(data[8:], u.key.Bytes())
+
+	if err := osutil.KeychainStore(keyCacheName(u.user), data, time.Until(expires)); err != nil {
+		errColor.Println("failed to cache key:", err)
+		return
+	}
+	u.keyExpires = expires
+}
+
+// currentPass is the current user's passphrase, when the file was opened
+// with a cached key it has to be asked for first
+func (u *uiContext) currentPass() (string, error) {
+	if !u.keyFromCache {
+		return u.pass, nil
+	}
+
+	payload, err := ioutil.ReadFile(flagFile)
+	if err != nil {
+		return "", err
+	}
+
+	infoColor.Println("the file was opened with a cached key, the passphrase is needed")
+	for i := 0; i < 3; i++ {
+		pass, err := u.promptPassword(promptColor.Sprintf("%s passphrase: ", u.shortFilename))
+		if err != nil {
+			return "", err
+		}
+
+		_, _, _, err = crypt.Decrypt([]byte(u.user), []byte(pass), nil, nil, payload)
+		if err == crypt.ErrWrongPassphrase {
+			errColor.Println("wrong passphrase")
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		u.pass = pass
+		u.keyFromCache = false
+		return pass, nil
+	}
+
+	return "", crypt.ErrWrongPassphrase
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/osutil"
+	"github.com/aarondl/bpass/secmem"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestKeyCache(t *testing.T) {
+	t.Parallel()
+
+	// Only the kernel keyring can be used without touching the user's keychain
+	if runtime.GOOS != "linux" {
+		t.Skip("keychain tests only run on linux")
+	}
+
+	user := "keycache-test-" + time.Now().Format("150405.000000000")
+	if err := osutil.KeychainStore(keyCacheName(user), []byte("probe"), time.Minute); err != nil {
+		t.Skip("kernel keyring unavailable:", err)
+	}
+	defer forgetKey(user)
+
+	u := &uiContext{
+		user:  user,
+		key:   secmem.Copy([]byte("key")),
+		store: blobformat.Blobs{DB: new(txlogs.DB)},
+	}
+
+	// Off by default, nothing is cached
+	u.cacheKey()
+	if key, _ := cachedKey(user); key != nil {
+		t.Error("want no key cached, got:", key)
+	}
+
+	if err := u.store.SetSetting(blobformat.SettingKeyCache, "1m"); err != nil {
+		t.Fatal(err)
+	}
+	u.cacheKey()
+	key, expires := cachedKey(user)
+	if !bytes.Equal(key, u.key.Bytes()) {
+		t.Errorf("want the key cached, got: %q", key)
+	}
+	if until := time.Until(expires); until <= 0 || until > time.Minute {
+		t.Error("expiry was wrong:", expires)
+	}
+
+	// The expiry is from when the passphrase was typed, later saves don't
+	// extend it
+	u.keyExpires = time.Now().Add(-time.Second)
+	u.cacheKey()
+	if key, _ := cachedKey(user); key != nil {
+		t.Error("want the expired key forgotten, got:", key)
+	}
+
+	u.keyExpires = time.Now().Add(time.Minute)
+	u.cacheKey()
+	if err := u.store.SetSetting(blobformat.SettingKeyCache, ""); err != nil {
+		t.Fatal(err)
+	}
+	u.cacheKey()
+	if key, _ := cachedKey(user); key != nil {
+		t.Error("want the key forgotten when the setting is off, got:", key)
+	}
+}
+
+func TestKeyCacheName(t *testing.T) {
+	t.Parallel()
+
+	if keyCacheName("a") == keyCacheName("b") {
+		t.Error("users should have different names")
+	}
+	if keyCacheName("a") != keyCacheName("a") {
+		t.Error("names should be stable")
+	}
+}
+
+func TestDerivedKey(t *testing.T) {
+	t.Parallel()
+
+	u := new(uiContext)
+	salt := []byte("salt")
+	u.rememberKey("pass", salt, []byte("key"))
+
+	if got := u.derivedKey("pass", salt); string(got) != "key" {
+		t.Errorf("want the remembered key, got: %q", got)
+	}
+	if got := u.derivedKey("wrong", salt); got != nil {
+		t.Errorf("want no key for another passphrase, got: %q", got)
+	}
+
+	// The ids can't be checked against a guess without the random key
+	for id := range u.derived {
+		if id == sha256.Sum256([]byte("passsalt")) {
+			t.Error("id is an unkeyed hash of the passphrase")
+		}
+	}
+	u.wipeSecrets()
+}
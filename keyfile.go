@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aarondl/bpass/crypt"
+)
+
+const (
+	// keyfileEnv can be set to the keyfile instead of using --keyfile
+	keyfileEnv = "BPASS_KEYFILE"
+	// keyfileSize is how many random bytes keyfile new writes
+	keyfileSize = 64
+	// keyfileMinSize is the smallest file that can be used as a keyfile
+	keyfileMinSize = 32
+)
+
+// newKeyfile writes a new random keyfile, an existing file is never
+// overwritten since it may be the keyfile for another file
+func newKeyfile(path string) error {
+	b := make([]byte, keyfileSize)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0400)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+
+	return nil
+}
+
+// hashKeyfile hashes the keyfile's contents, any file that's big enough can
+// be used
+func hashKeyfile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	if n < keyfileMinSize {
+		return nil, errors.New("keyfile is too small, make one with keyfile new")
+	}
+
+	return h.Sum(nil), nil
+}
+
+// keyfileResponse answers a challenge with an hmac keyed with the keyfile,
+// it's found with --keyfile, $BPASS_KEYFILE or by asking
+func (u *uiContext) keyfileResponse(challenge []byte) ([]byte, error) {
+	path := u.keyfile
+	if len(path) == 0 {
+		path = os.Getenv(keyfileEnv)
+	}
+
+	for {
+		if len(path) == 0 {
+			var err error
+			path, err = u.prompt(promptColor.Sprint("keyfile: "))
+			if err == errHeadless {
+				return nil, errFactorAbsent
+			} else if err != nil {
+				return nil, err
+			}
+			path = strings.TrimSpace(path)
+			if len(path) == 0 {
+				return nil, errFactorAbsent
+			}
+		}
+
+		sum, err := hashKeyfile(path)
+		if err != nil {
+			errColor.Println("failed to read keyfile:", err)
+			path = ""
+			continue
+		}
+
+		u.keyfile = path
+		mac := hmac.New(sha256.New, sum)
+		_, _ = mac.Write(challenge)
+		return mac.Sum(nil), nil
+	}
+}
+
+// keyfileAdd makes the current user's key need the keyfile at path
+func (u *uiContext) keyfileAdd(path string) error {
+	if _, err := hashKeyfile(path); err != nil {
+		errColor.Println("failed to read keyfile:", err)
+		return nil
+	}
+
+	if current := u.keyFactor(); current != crypt.FactorNone && current != crypt.FactorKeyfile {
+		infoColor.Printf("the keyfile will replace the %s\n", factorName(current))
+	}
+
+	u.keyfile = path
+	infoColor.Println("enter the passphrase to use with the keyfile")
+	return u.passwd("", int(crypt.FactorKeyfile))
+}
+
+// keyfileRemove stops the current user's key needing a keyfile
+func (u *uiContext) keyfileRemove() error {
+	if u.keyFactor() != crypt.FactorKeyfile {
+		errColor.Println("this key doesn't need a keyfile")
+		return nil
+	}
+
+	return u.passwd("", int(crypt.FactorNone))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyfile(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "bpass-keyfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "bpass.key")
+	if err = newKeyfile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err = newKeyfile(path); err == nil {
+		t.Error("want an error, the keyfile must not be overwritten")
+	}
+
+	u := &uiContext{keyfile: path}
+	challenge := []byte("challenge")
+	r1, err := u.keyfileResponse(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := u.keyfileResponse(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r1, r2) {
+		t.Error("responses should be the same for a challenge")
+	}
+	if r3, _ := u.keyfileResponse([]byte("other")); bytes.Equal(r1, r3) {
+		t.Error("responses should be different for each challenge")
+	}
+
+	small := filepath.Join(dir, "small")
+	if err = ioutil.WriteFile(small, []byte("short"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = hashKeyfile(small); err == nil {
+		t.Error("want an error for a small keyfile")
+	}
+}
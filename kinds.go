@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+)
+
+// kindField is a key the wizard of a kind of entry asks for, Check is
+// called on values that are of the key's type when it's set
+type kindField struct {
+	Key       string
+	Prompt    string
+	Hidden    bool
+	Multiline bool
+	Optional  bool
+	Check     func(value string) error
+}
+
+// addKindInterruptible is addKind but aborting is not an error
+func (u *uiContext) addKindInterruptible(name, kind string, fields []kindField) (string, error) {
+	uuid, err := u.addKind(name, kind, fields)
+	switch err {
+	case nil:
+		return uuid, nil
+	case ErrEnd:
+		errColor.Println("Aborted")
+		return "", nil
+	default:
+		return "", err
+	}
+}
+
+// addKind is a wizard to add an entry of a kind, it asks for each field
+// until it's of its type in the kind's schema. The uuid is empty when the
+// name is taken.
+func (u *uiContext) addKind(name, kind string, fields []kindField) (uuid string, err error) {
+	err = u.store.Do(func() error {
+		uuid, err = u.store.New(name)
+		if err != nil {
+			if err == blobformat.ErrNameNotUnique {
+				errColor.Printf("%q already exists\n", name)
+				return nil
+			}
+			return err
+		}
+
+		schema := blobformat.SchemaOf(kind)
+		values := make(map[string]string, len(fields))
+		for _, f := range fields {
+			prompt := promptColor.Sprint(f.Prompt + ": ")
+			for {
+				var value string
+				switch {
+				case f.Hidden:
+					value, err = u.promptPassword(prompt)
+				case f.Multiline:
+					infoColor.Println(f.Prompt + ":")
+					value, err = u.promptMultiline(promptColor.Sprint("> "))
+				default:
+					value, err = u.prompt(prompt)
+				}
+				if err != nil {
+					return err
+				}
+
+				if len(value) == 0 {
+					if f.Optional {
+						break
+					}
+					errColor.Println(f.Prompt, "cannot be empty")
+					continue
+				}
+				if err = schema.Validate(f.Key, value); err != nil {
+					errColor.Println(err)
+					continue
+				}
+				if f.Check != nil {
+					if err = f.Check(value); err != nil {
+						errColor.Println(err)
+						continue
+					}
+				}
+
+				values[f.Key] = value
+				break
+			}
+		}
+
+		// Use raw sets here to avoid creating history spam based on timestamp
+		// additions
+		u.store.DB.Set(uuid, blobformat.KeyKind, kind)
+		for _, f := range fields {
+			if value, ok := values[f.Key]; ok {
+				u.store.DB.Set(uuid, f.Key, value)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return uuid, nil
+}
+
+// maskKindValue masks the keys a kind of entry doesn't show as they are, it's
+// false for the ones it does. hidden is set for the ones that are hidden
+// rather than masked.
+func maskKindValue(kind, key, value string) (masked string, hidden, ok bool) {
+	switch {
+	case kind == blobformat.KindCard && key == blobformat.KeyNumber:
+		return blobformat.MaskCardNumber(value), false, true
+	case kind == blobformat.KindCard && (key == blobformat.KeyCVV || key == blobformat.KeyPIN),
+		kind == blobformat.KindIdentity && (key == blobformat.KeyPassport || key == blobformat.KeyIDNumber),
+		kind == blobformat.KindWifi && key == blobformat.KeyPassphrase:
+		return "", true, true
+	case kind == blobformat.KindPasskey && key == blobformat.KeyPriv:
+		return passkeyKeyType(value), false, true
+	}
+
+	return "", false, false
+}
+
+// showKindValue shows the keys a kind of entry shows differently from other
+// entries, it's false for the ones it doesn't
+func showKindValue(u *uiContext, kind, key, value string, width, indent int) bool {
+	if masked, hidden, ok := maskKindValue(kind, key, value); ok {
+		if hidden {
+			showHidden(u, key, value, width, indent)
+		} else {
+			showKeyValue(u, key, masked, width, indent)
+		}
+		return true
+	}
+
+	switch {
+	case kind == blobformat.KindIdentity && key == blobformat.KeyBirthdate:
+		if born, err := blobformat.ParseDate(value); err == nil {
+			value = fmt.Sprintf("%s (%d years old)", value, yearsOld(born, time.Now()))
+		}
+		showKeyValue(u, key, value, width, indent)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// yearsOld is how many birthdays someone born then has had by now
+func yearsOld(born, now time.Time) int {
+	years := now.Year() - born.Year()
+	if now.Month() < born.Month() || now.Month() == born.Month() && now.Day() < born.Day() {
+		years--
+	}
+	return years
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestAddKind(t *testing.T) {
+	t.Parallel()
+
+	in := &scriptedEditor{}
+	out := new(bytes.Buffer)
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: out, in: in}
+
+	fields := []kindField{
+		{Key: blobformat.KeyFullName, Prompt: "full name"},
+		{Key: blobformat.KeyBirthdate, Prompt: "birthdate", Optional: true},
+		{Key: blobformat.KeyPhone, Prompt: "phone", Optional: true},
+	}
+	in.lines = []string{"", "Jo Doe", "yesterday", "1990-06-15", ""}
+	uuid, err := u.addKind("me", blobformat.KindIdentity, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob.Kind() != blobformat.KindIdentity || blob[blobformat.KeyFullName] != "Jo Doe" || blob[blobformat.KeyBirthdate] != "1990-06-15" {
+		t.Error("the prompts should be asked again until they're valid:", blob)
+	}
+	if _, ok := blob[blobformat.KeyPhone]; ok {
+		t.Error("optional fields left empty should not be set")
+	}
+
+	if err = u.show("me", 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "years old") {
+		t.Error("the birthdate should have an age:", out.String())
+	}
+
+	// Aborting adds nothing
+	in.lines = nil
+	if uuid, err = u.addKindInterruptible("other", blobformat.KindIdentity, fields); err != nil || len(uuid) != 0 {
+		t.Error("it should have been aborted:", uuid, err)
+	}
+	if _, blob, _ = u.store.FindByName("other"); blob != nil {
+		t.Error("the aborted entry should be rolled back")
+	}
+}
+
+func TestYearsOld(t *testing.T) {
+	t.Parallel()
+
+	born := time.Date(2000, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := map[time.Time]int{
+		time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC):  23,
+		time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC):   24,
+		time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC): 24,
+	}
+	for now, want := range tests {
+		if got := yearsOld(born, now); got != want {
+			t.Errorf("%s) want: %d, got: %d", now.Format("2006-01-02"), want, got)
+		}
+	}
+}
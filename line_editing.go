@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"io"
+	"strings"
+	"sync"
 )
 
 // Handle-able error codes that arise from line editors
@@ -16,6 +19,9 @@ var (
 type LineEditor interface {
 	// Line returns a line of text as read from the user
 	Line(prompt string) (string, error)
+	// LineBlock is like Line but a paste keeps its line breaks, it's used
+	// for commands and multi-line values.
+	LineBlock(prompt string) (string, error)
 	// LineHidden returns a line of text as read from the user, but does not
 	// show what's typed to the user.
 	LineHidden(prompt string) (string, error)
@@ -31,3 +37,115 @@ type LineEditor interface {
 	// Close the line editor, restoring any terminal magic to its proper place
 	Close() error
 }
+
+// Bracketed paste: once the terminal is sent bracketedPasteOn it puts
+// pasteStartSeq before pasted text and pasteEndSeq after it
+const (
+	bracketedPasteOn  = "\x1b[?2004h"
+	bracketedPasteOff = "\x1b[?2004l"
+	pasteStartSeq     = "\x1b[200~"
+	pasteEndSeq       = "\x1b[201~"
+)
+
+// pasteNewline is what pasteReader turns the line breaks in a paste into, a
+// private use rune so the line editor doesn't end the line there
+const pasteNewline = "\uE000"
+
+// pasteReader turns a bracketed paste into a single line so that a pasted
+// key or certificate arrives as one value instead of a line at a time. The
+// paste markers are removed and the line breaks between them become
+// pasteNewline, see pastedLine.
+type pasteReader struct {
+	sync.Mutex
+
+	r       io.ReadCloser
+	buf     []byte
+	out     []byte
+	err     error
+	inPaste bool
+	cr      bool
+	// pending is the start of a paste marker split across reads
+	pending []byte
+}
+
+func newPasteReader(r io.ReadCloser) *pasteReader {
+	return &pasteReader{r: r, buf: make([]byte, 4096)}
+}
+
+// Read implements io.Reader
+func (p *pasteReader) Read(b []byte) (int, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	for len(p.out) == 0 {
+		if p.err != nil {
+			return 0, p.err
+		}
+
+		n, err := p.r.Read(p.buf)
+		p.err = err
+		p.translate(append(p.pending, p.buf[:n]...), err != nil)
+	}
+
+	n := copy(b, p.out)
+	p.out = p.out[n:]
+	return n, nil
+}
+
+// Close implements io.Closer
+func (p *pasteReader) Close() error {
+	return p.r.Close()
+}
+
+// translate removes the paste markers from data and replaces line breaks
+// in pastes, the start of a marker at the end is kept for the next read
+// unless final is set.
+func (p *pasteReader) translate(data []byte, final bool) {
+	p.pending = nil
+	for len(data) != 0 {
+		switch {
+		case bytes.HasPrefix(data, []byte(pasteStartSeq)):
+			p.inPaste = true
+			data = data[len(pasteStartSeq):]
+		case bytes.HasPrefix(data, []byte(pasteEndSeq)):
+			p.inPaste = false
+			data = data[len(pasteEndSeq):]
+		case !final && isPasteSeqStart(data):
+			p.pending = append([]byte(nil), data...)
+			return
+		case p.inPaste && p.cr && data[0] == '\n':
+			// \r\n is one line break
+			p.cr = false
+			data = data[1:]
+		case p.inPaste && (data[0] == '\r' || data[0] == '\n'):
+			p.cr = data[0] == '\r'
+			p.out = append(p.out, pasteNewline...)
+			data = data[1:]
+		default:
+			p.cr = false
+			p.out = append(p.out, data[0])
+			data = data[1:]
+		}
+	}
+}
+
+// isPasteSeqStart checks if data is the start of a paste marker
+func isPasteSeqStart(data []byte) bool {
+	if len(data) >= len(pasteStartSeq) {
+		return false
+	}
+	return strings.HasPrefix(pasteStartSeq, string(data)) || strings.HasPrefix(pasteEndSeq, string(data))
+}
+
+// pastedLine puts back the line breaks of a paste in a line read through a
+// pasteReader. A line break at the end of the line is the enter of a copied
+// line so it's dropped.
+func pastedLine(line string) string {
+	return strings.Replace(strings.TrimRight(line, pasteNewline), pasteNewline, "\n", -1)
+}
+
+// pastedSingleLine is pastedLine for prompts that want a single line, the
+// line breaks of a paste become spaces like they do in a browser's text box
+func pastedSingleLine(line string) string {
+	return strings.Replace(strings.TrimRight(line, pasteNewline), pasteNewline, " ", -1)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestPasteReader(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name string
+		In   string
+		Want string
+	}{
+		{"typed", "set github user me\r", "set github user me\r"},
+		{"arrow keys", "ab\x1b[D\x1b[Cc\r", "ab\x1b[D\x1b[Cc\r"},
+		{"escape at the end", "ab\x1b", "ab\x1b"},
+		{
+			"paste",
+			"set server privkey \x1b[200~-----BEGIN KEY-----\r\nabc\r\n\r\n-----END KEY-----\x1b[201~\r",
+			"set server privkey -----BEGIN KEY-----" + pasteNewline + "abc" + pasteNewline + pasteNewline + "-----END KEY-----\r",
+		},
+		{"lf paste", "\x1b[200~a\nb\x1b[201~\r", "a" + pasteNewline + "b\r"},
+		{"one line paste", "\x1b[200~hunter2\x1b[201~\r", "hunter2\r"},
+	}
+
+	for _, test := range tests {
+		// A byte at a time splits every marker across reads
+		for _, oneByte := range []bool{false, true} {
+			r := ioutil.NopCloser(strings.NewReader(test.In))
+			if oneByte {
+				r = ioutil.NopCloser(iotest.OneByteReader(r))
+			}
+
+			got, err := ioutil.ReadAll(newPasteReader(r))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.Want {
+				t.Errorf("%s (one byte: %t): want: %q, got: %q", test.Name, oneByte, test.Want, got)
+			}
+		}
+	}
+
+	if got := pastedLine("a" + pasteNewline + "b" + pasteNewline); got != "a\nb" {
+		t.Errorf("line breaks should be put back but the last dropped: %q", got)
+	}
+	if got := pastedSingleLine("a" + pasteNewline + "b" + pasteNewline); got != "a b" {
+		t.Errorf("a single line should have spaces for line breaks: %q", got)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestLinks(t *testing.T) {
+	t.Parallel()
+
+	out := new(bytes.Buffer)
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: out}
+
+	ldap, err := u.store.New("work/ldap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki, err := u.store.New("wiki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.store.Set(ldap, blobformat.KeyPass, "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = u.store.Set(wiki, blobformat.KeyPass, "ref:work/ldap/pass"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.store.Set(wiki, blobformat.KeyUser, "ref:work/ldap/user"); !blobformat.IsBrokenLink(err) {
+		t.Error("a link to a key that isn't set should be broken:", err)
+	}
+	// Only possible from before urls were checked
+	u.store.DB.Set(ldap, blobformat.KeyURL, "not a url")
+	if err = u.store.Set(wiki, blobformat.KeyURL, "ref:work/ldap/url"); !blobformat.IsInvalidValue(err) {
+		t.Error("the value linked to should be of the key's type:", err)
+	}
+
+	blob, err := u.store.MustFind(wiki)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pass, err := u.store.Resolve(blob, blobformat.KeyPass); err != nil || pass != "hunter2" {
+		t.Error("the link should be followed:", pass, err)
+	}
+
+	if err = u.show("wiki", 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if s := out.String(); !strings.Contains(s, "hunter2") || !strings.Contains(s, "from work/ldap/pass") {
+		t.Errorf("show should have the linked value and where it's from:\n%s", s)
+	}
+
+	if err = u.store.Rename(ldap, "work/directory"); err != nil {
+		t.Fatal(err)
+	}
+	if blob, err = u.store.MustFind(wiki); err != nil {
+		t.Fatal(err)
+	}
+	if link := blob[blobformat.KeyPass]; link != blobformat.Link("work/directory", blobformat.KeyPass) {
+		t.Error("renaming should change the links to it:", link)
+	}
+
+	if err = u.store.Set(ldap, blobformat.KeyPass, "ref:wiki/pass"); err == nil {
+		t.Error("a link back to itself should be broken")
+	}
+
+	u.store.Delete(ldap)
+	if _, err = u.store.Resolve(blob, blobformat.KeyPass); !blobformat.IsBrokenLink(err) {
+		t.Error("a link to a deleted entry should be broken:", err)
+	}
+	out.Reset()
+	if err = u.show("wiki", 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "broken link") {
+		t.Errorf("show should say the link is broken:\n%s", out.String())
+	}
+}
+
+func TestSetBrokenLink(t *testing.T) {
+	t.Parallel()
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: new(bytes.Buffer)}
+	uuid, err := u.store.New("wiki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.store.Set(uuid, blobformat.KeyPass, "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{blobformat.KeyPass, blobformat.KeyUser} {
+		if err = u.set("wiki", key, blobformat.Link("missing", key), false, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob[blobformat.KeyPass] != "hunter2" || len(blob[blobformat.KeyUser]) != 0 {
+		t.Errorf("broken links should not be set: %#v", blob)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// lockoutFree is how many wrong passphrases are allowed before each
+	// attempt has to wait
+	lockoutFree = 3
+	// lockoutAttempts is how many wrong passphrases it takes before the file
+	// can't be tried again until the cool-down is over
+	lockoutAttempts = 10
+	lockoutCooldown = time.Hour
+)
+
+// lockout counts wrong passphrases against a file. It lives outside the file
+// since the point is that whoever is guessing can't read it.
+type lockout struct {
+	path     string
+	failures int
+	last     time.Time
+}
+
+// lockoutPath is where failed attempts are counted for the file, or for one
+// of its sync entries when sync isn't empty. It isn't per user so trying
+// other user names doesn't get more guesses.
+func lockoutPath(sync string) string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	id := ""
+	if len(sync) != 0 {
+		id = "\x00sync\x00" + sync
+	}
+	return filepath.Join(dir, "bpass", "lockout", fileUserID(id))
+}
+
+// loadLockout reads the failed attempts at path, a missing or unreadable file
+// is no failures
+func loadLockout(path string) *lockout {
+	l := &lockout{path: path}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return l
+	}
+
+	var unix int64
+	if _, err = fmt.Sscan(string(b), &l.failures, &unix); err != nil {
+		l.failures = 0
+		return l
+	}
+	l.last = time.Unix(unix, 0)
+	return l
+}
+
+// lockoutDelay is how long after the last failure the next attempt has to
+// wait, it doubles with every failure until the cool-down
+func lockoutDelay(failures int) time.Duration {
+	switch {
+	case failures < lockoutFree:
+		return 0
+	case failures >= lockoutAttempts:
+		return lockoutCooldown
+	}
+
+	return time.Second << uint(failures-lockoutFree)
+}
+
+// remaining is how long until another attempt may be made
+func (l *lockout) remaining(now time.Time) time.Duration {
+	left := l.last.Add(lockoutDelay(l.failures)).Sub(now)
+	if left < 0 {
+		return 0
+	}
+	// A clock that went backwards shouldn't lock things out forever
+	if max := lockoutDelay(l.failures); left > max {
+		return max
+	}
+	return left
+}
+
+// wait blocks until another attempt may be made, once there have been too
+// many failures it refuses instead.
+func (l *lockout) wait(name string) error {
+	left := l.remaining(time.Now())
+	if left == 0 {
+		return nil
+	}
+
+	if l.failures >= lockoutAttempts {
+		return fmt.Errorf("too many wrong passphrases for %s, try again in %v", name, left.Round(time.Minute))
+	}
+
+	infoColor.Printf("waiting %v after %d wrong passphrases\n", left.Round(time.Second), l.failures)
+	time.Sleep(left)
+	return nil
+}
+
+// fail records a wrong passphrase
+func (l *lockout) fail() {
+	l.failures++
+	l.last = time.Now()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		errColor.Println("failed to record wrong passphrase:", err)
+		return
+	}
+
+	data := fmt.Sprintf("%d %d\n", l.failures, l.last.Unix())
+	if err := ioutil.WriteFile(l.path, []byte(data), 0600); err != nil {
+		errColor.Println("failed to record wrong passphrase:", err)
+	}
+}
+
+// reset forgets the failures after the right passphrase is given
+func (l *lockout) reset() {
+	if l.failures == 0 {
+		return
+	}
+
+	l.failures = 0
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		errColor.Println("failed to reset wrong passphrases:", err)
+	}
+}
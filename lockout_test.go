@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockoutDelay(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Failures int
+		Want     time.Duration
+	}{
+		{0, 0},
+		{lockoutFree - 1, 0},
+		{lockoutFree, time.Second},
+		{lockoutFree + 1, 2 * time.Second},
+		{lockoutAttempts - 1, time.Second << (lockoutAttempts - 1 - lockoutFree)},
+		{lockoutAttempts, lockoutCooldown},
+		{lockoutAttempts + 5, lockoutCooldown},
+	}
+
+	for i, test := range tests {
+		if got := lockoutDelay(test.Failures); got != test.Want {
+			t.Errorf("%d) want: %v, got: %v", i, test.Want, got)
+		}
+	}
+}
+
+func TestLockout(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "bpass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "lockout", "id")
+	l := loadLockout(path)
+	if l.failures != 0 || l.remaining(time.Now()) != 0 {
+		t.Error("a missing file should have no failures")
+	}
+
+	for i := 0; i < lockoutAttempts; i++ {
+		l.fail()
+	}
+
+	l = loadLockout(path)
+	if l.failures != lockoutAttempts {
+		t.Error("failures were not persisted:", l.failures)
+	}
+	if left := l.remaining(time.Now()); left <= lockoutCooldown-time.Minute {
+		t.Error("should be cooling down, remaining:", left)
+	}
+	if left := l.remaining(time.Now().Add(-24 * time.Hour)); left != lockoutCooldown {
+		t.Error("a clock that went backwards should not lock longer than the cool-down:", left)
+	}
+	if left := l.remaining(time.Now().Add(lockoutCooldown)); left != 0 {
+		t.Error("cool-down should be over:", left)
+	}
+	if err = l.wait("file"); err == nil {
+		t.Error("expected an error while cooling down")
+	}
+
+	l.reset()
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Error("file should be removed on reset:", err)
+	}
+	if l = loadLockout(path); l.failures != 0 {
+		t.Error("failures should be gone after reset:", l.failures)
+	}
+
+	if err = ioutil.WriteFile(path, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if l = loadLockout(path); l.failures != 0 {
+		t.Error("garbage should be no failures:", l.failures)
+	}
+}
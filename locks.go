@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+// lockEntry locks an entry so it can't be changed or removed, or unlocks it
+func (u *uiContext) lockEntry(search string, lock bool) error {
+	uuid, err := u.findOne(search)
+	if err != nil {
+		return err
+	}
+	if len(uuid) == 0 {
+		return nil
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+
+	var changed bool
+	if lock {
+		changed, err = u.store.Lock(uuid)
+	} else {
+		changed, err = u.store.Unlock(uuid)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !changed && lock:
+		infoColor.Printf("%s is already locked\n", blob.Name())
+	case !changed:
+		infoColor.Printf("%s is not locked\n", blob.Name())
+	case lock:
+		infoColor.Printf("locked %s, it can't be changed or removed until it's unlocked\n", blob.Name())
+	default:
+		infoColor.Printf("unlocked %s\n", blob.Name())
+	}
+
+	return nil
+}
+
+// checkUnlocked tells the user the entry is locked, it's false if it is
+func checkUnlocked(blob blobformat.Blob) bool {
+	if !blob.IsLocked() {
+		return true
+	}
+
+	errColor.Printf("%s is locked, unlock %s to change it\n", blob.Name(), blob.Name())
+	return false
+}
+
+// changedLocked finds a locked entry the transactions change, whether it's
+// locked or pinned isn't a change to it
+func changedLocked(txs []txlogs.Tx, locked map[string]bool) (string, bool) {
+	for _, tx := range txs {
+		if !locked[tx.UUID] {
+			continue
+		}
+		if tx.Kind != txlogs.TxDelete && (tx.Key == blobformat.KeyLocked || tx.Key == blobformat.KeyPinned) {
+			continue
+		}
+		return tx.UUID, true
+	}
+
+	return "", false
+}
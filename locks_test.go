@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestLockEntry(t *testing.T) {
+	t.Parallel()
+
+	buf := new(bytes.Buffer)
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: buf}
+	uuid, err := u.store.New("bank")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.store.Set(uuid, "user", "me"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := u.lockEntry("bank", true); err != nil {
+		t.Fatal(err)
+	}
+	blob, _ := u.store.MustFind(uuid)
+	if !blob.IsLocked() {
+		t.Fatal("it should be locked")
+	}
+	if changed, err := u.store.Lock(uuid); err != nil || changed {
+		t.Error("it should already be locked", err)
+	}
+
+	// The commands that check it themselves refuse before asking anything
+	if ok, err := u.guardKey(blob, "user", true); err != nil || ok {
+		t.Error("a locked entry's keys can't be set, even when forced", err)
+	}
+	if err := u.trashEntry("bank"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.rename("bank", "vault"); err != nil {
+		t.Fatal(err)
+	}
+	if blob, _ = u.store.MustFind(uuid); blob.IsTrashed() || blob.Name() != "bank" {
+		t.Error("it should not have been removed or renamed:", blob)
+	}
+
+	// The rest are rolled back, but pinning is fine
+	r := &repl{ctx: u}
+	set := entryLockMiddleware(replCmd{Entry: true}, func(r *repl, cmd string, args []string) error {
+		return r.ctx.store.Set(uuid, args[0], args[1])
+	})
+	if err := set(r, "cmd", []string{"user", "you"}); err != nil {
+		t.Fatal(err)
+	}
+	pin := entryLockMiddleware(replCmd{Entry: true}, func(r *repl, cmd string, args []string) error {
+		_, err := r.ctx.store.Pin(uuid)
+		return err
+	})
+	if err := pin(r, "cmd", nil); err != nil {
+		t.Fatal(err)
+	}
+	if blob, _ = u.store.MustFind(uuid); blob.Get("user") != "me" || !blob.IsPinned() {
+		t.Error("the user should not have changed and it should be pinned:", blob)
+	}
+	if u.store.DB.InTx() {
+		t.Error("the transaction should be over")
+	}
+
+	if err := u.lockEntry("bank", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := set(r, "cmd", []string{"user", "you"}); err != nil {
+		t.Fatal(err)
+	}
+	if blob, _ = u.store.MustFind(uuid); blob.IsLocked() || blob.Get("user") != "you" {
+		t.Error("it should have changed once unlocked:", blob)
+	}
+}
@@ -7,9 +7,11 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/aarondl/bpass/blobformat"
 	"github.com/aarondl/bpass/crypt"
+	"github.com/aarondl/bpass/secmem"
 	"github.com/aarondl/bpass/txlogs"
 
 	"github.com/aarondl/color"
@@ -19,7 +21,7 @@ import (
 
 var (
 	version      = "unknown"
-	cryptVersion = 1
+	cryptVersion = 2
 )
 
 func main() {
@@ -33,6 +35,19 @@ func main() {
 		return
 	}
 
+	if hotkeydCmd.Used && flagHotkeyPick {
+		mode := hotkeyModeCopy
+		if flagHotkeyType {
+			mode = hotkeyModeType
+		}
+
+		if err = hotkeyPick(mode); err != nil {
+			fmt.Println("failed to pick:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	ctx := new(uiContext)
 	if flagNoColor {
 		color.Disable = true
@@ -45,6 +60,19 @@ func main() {
 	if !historyTime.IsZero() {
 		ctx.readOnly = true
 	}
+	ctx.keyfile = flagKeyfile
+	if flagFormat != formatText {
+		ctx.format = flagFormat
+	}
+	crypt.SecondFactor = ctx.secondFactorResponse
+
+	if serveSyncCmd.Used {
+		if err = runServeSync(); err != nil {
+			fmt.Println("serve-sync failed:", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	// setup readline needs to have the filenames parsed and ready
 	// to use from above
@@ -79,6 +107,21 @@ func main() {
 	}
 
 	switch {
+	case hotkeydCmd.Used:
+		if err = runHotkeyd(ctx); err != nil {
+			fmt.Println("hotkeyd failed:", err)
+		}
+		goto Exit
+	case lsCmd.Used, showCmd.Used, getCmd.Used:
+		if err = runQuery(ctx); err != nil {
+			fmt.Println("error occurred:", err)
+		}
+		goto Exit
+	case migrateCmd.Used:
+		if err = runMigrate(ctx); err != nil {
+			fmt.Println("migrate failed:", err)
+		}
+		goto Exit
 	case lpassImportCmd.Used:
 		if err = importLastpass(ctx); err != nil {
 			fmt.Printf("error occurred: %+v\nexiting without saving", err)
@@ -92,6 +135,9 @@ func main() {
 			}
 		}
 
+		ctx.recent = loadRecent(recentPath(ctx.user, ctx.salt))
+		ctx.warnExpired(time.Now())
+
 		if err = r.run(); err != nil {
 			if err == ErrInterrupt {
 				fmt.Println("exiting, did not save file")
@@ -102,7 +148,8 @@ func main() {
 		}
 
 		wrote := ctx.startTx != len(ctx.store.DB.Log)
-		if wrote && !ctx.readOnly && !flagNoAutoSync {
+		syncOnSave := ctx.settingBool(blobformat.SettingSyncOnSave)
+		if (wrote || syncOnSave) && !ctx.readOnly && !flagNoAutoSync {
 			if err = ctx.sync("", true, true); err != nil {
 				fmt.Println("failed to synchronize:", err)
 				goto Exit
@@ -117,6 +164,8 @@ func main() {
 	}
 
 Exit:
+	ctx.wipeSecrets()
+
 	if !flagNoClearClip {
 		if err = clipboard.WriteAll(""); err != nil {
 			fmt.Println("failed to clear the clipboard")
@@ -169,13 +218,23 @@ func (u *uiContext) loadBlob() error {
 			return errors.New("passphrases did not match")
 		}
 
+		kdf, err := crypt.ParseKDFParams(flagKDF)
+		if err != nil {
+			return fmt.Errorf("--kdf was invalid: %w", err)
+		}
+		if len(flagCipher) != 0 {
+			if _, err = crypt.CipherVersion(kdf.Version(), flagCipher); err != nil {
+				return fmt.Errorf("--cipher was invalid: %w", err)
+			}
+		}
+
 		// Derive a new key from the password for later encryption
-		key, salt, err := crypt.DeriveKey(cryptVersion, []byte(pwd))
+		key, salt, err := crypt.DeriveKeyParams(kdf.Version(), []byte(pwd), kdf)
 		if err != nil {
 			return err
 		}
 
-		u.key = key
+		u.setKey(key)
 		u.salt = salt
 	} else {
 		// Read in the file, decrypt it, parse the blob data.
@@ -194,25 +253,70 @@ func (u *uiContext) loadBlob() error {
 				return err
 			}
 		}
+		// Second factors that are kept per user need it before decrypting
+		u.user = user
 
-		pwd, err = u.promptPassword(promptColor.Sprintf("%s passphrase: ", u.shortFilename))
-		if err != nil {
-			return err
+		// A cached key that no longer opens the file was rekeyed elsewhere
+		var version int
+		var params crypt.Params
+		var pt []byte
+		if flagNoKeyCache {
+			forgetKey(user)
+		} else if key, expires := cachedKey(user); key != nil {
+			salt, err := crypt.Salt([]byte(user), payload)
+			if err == nil {
+				version, params, pt, err = crypt.Decrypt([]byte(user), nil, key, salt, payload)
+			}
+			if err == nil {
+				u.keyFromCache = true
+				u.keyExpires = expires
+			} else {
+				forgetKey(user)
+			}
 		}
 
-		_, params, pt, err := crypt.Decrypt([]byte(user), []byte(pwd), nil, nil, payload)
-		if err != nil {
-			return err
+		if !u.keyFromCache {
+			lock := loadLockout(lockoutPath(""))
+			if err = lock.wait(u.shortFilename); err != nil {
+				return err
+			}
+
+			pwd, err = u.promptPassword(promptColor.Sprintf("%s passphrase: ", u.shortFilename))
+			if err != nil {
+				return err
+			}
+			if isShare(pwd) {
+				if pwd, err = u.combineShares(pwd); err != nil {
+					return err
+				}
+			}
+
+			version, params, pt, err = crypt.Decrypt([]byte(user), []byte(pwd), nil, nil, payload)
+			if err == crypt.ErrWrongPassphrase {
+				lock.fail()
+			}
+			if err != nil {
+				return err
+			}
+			lock.reset()
 		}
 
 		u.user = user
 		u.pass = pwd
-		u.key = params.Keys[params.User]
+		u.setKey(params.Keys[params.User])
 		u.salt = params.Salts[params.User]
-		u.master = params.Master
+		u.setMaster(params.Master)
 		u.ivm = params.IVM
+		u.keepSlot(version, params)
+		u.onDisk = payload
 
-		store, err := txlogs.New(pt)
+		var store *txlogs.DB
+		if blobformat.IsLegacy(pt) {
+			store, err = u.convertLegacy(payload, pt)
+		} else {
+			store, err = txlogs.New(pt)
+		}
+		secmem.Wipe(pt)
 		if err != nil {
 			return err
 		}
@@ -223,6 +327,17 @@ func (u *uiContext) loadBlob() error {
 	// It's possible the store was empty/null even on a load, just create it
 	if u.store.DB == nil {
 		u.store = blobformat.Blobs{DB: new(txlogs.DB)}
+		// Remember the kdf the file was created with for later rekeys
+		if u.created && len(flagKDF) != 0 {
+			if err = u.store.SetSetting(blobformat.SettingKDF, flagKDF); err != nil {
+				return err
+			}
+		}
+		if u.created && len(flagCipher) != 0 {
+			if err = u.store.SetSetting(blobformat.SettingCipher, flagCipher); err != nil {
+				return err
+			}
+		}
 	} else if u.readOnly {
 		infoColor.Println("opened file in read-only mode at:", historyTime.Format("January 02, 2006 - 15:04:05"))
 		u.store.DB.ResetSnapshot()
@@ -240,31 +355,161 @@ func (u *uiContext) loadBlob() error {
 
 	// Save this to know if we've actually edited the database in some way
 	u.startTx = len(u.store.DB.Log)
+	u.markSaved(u.onDisk)
+
+	// Changes are stamped with this device, creating its key counts as one
+	// so it's saved
+	if !u.readOnly {
+		if _, err := u.deviceKey(); err != nil {
+			return err
+		}
+	}
+
+	if !u.created && !u.keyFromCache {
+		u.cacheKey()
+	}
+	u.tpmFinishEnroll()
 
 	return nil
 }
 
+// convertLegacy asks to convert a file from the storage format used before
+// the transaction log. The original file is backed up next to the current
+// one before anything happens.
+func (u *uiContext) convertLegacy(payload, plaintext []byte) (*txlogs.DB, error) {
+	backup := flagFile + ".legacy"
+
+	infoColor.Println("this file uses an old storage format and must be converted to be opened")
+	infoColor.Println("entries keep their current values but their history (snapshots) will be lost")
+	if u.readOnly {
+		return nil, errors.New("cannot convert an old file in read-only mode")
+	}
+
+	yes, err := u.getYesNo(fmt.Sprintf("convert it? (the original is kept at %s)", backup))
+	if err != nil {
+		return nil, err
+	}
+	if !yes {
+		return nil, errors.New("refusing to open file in old format")
+	}
+
+	if err = ioutil.WriteFile(backup, payload, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	store, err := blobformat.ConvertLegacy(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	infoColor.Printf("converted %d entries\n", len(store.Snapshot))
+	return store, nil
+}
+
 func (u *uiContext) saveBlob() error {
 	if u.readOnly {
 		return nil
 	}
 
-	data, err := u.store.Save()
+	if err := u.purgeTrash(); err != nil {
+		errColor.Println("failed to empty the trash:", err)
+	}
+	if err := u.autoCompact(); err != nil {
+		errColor.Println("failed to compact the log:", err)
+	}
+
+	data, err := u.rewrapBlob()
 	if err != nil {
 		return err
 	}
+	if data == nil {
+		if data, err = u.encryptBlob(); err != nil {
+			return err
+		}
+	}
+
+	if err = ioutil.WriteFile(flagFile, data, 0600); err != nil {
+		return err
+	}
+
+	u.markSaved(data)
+	u.cacheKey()
+	return nil
+}
+
+// rewrapBlob rewraps the payload key of the file on disk when the log hasn't
+// changed since it was written, a passphrase change then doesn't encrypt
+// the whole file again. It returns nil when the file must be encrypted.
+func (u *uiContext) rewrapBlob() ([]byte, error) {
+	version := u.fileVersion()
+	if u.onDisk == nil || u.payloadKey.Len() == 0 || u.slotVersion != version || !u.logSaved() {
+		return nil, nil
+	}
+	if v, err := crypt.FileVersion(u.onDisk); err != nil || v != version {
+		return nil, nil
+	}
 
 	params, err := u.makeParams()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	return crypt.Rewrap(version, params, u.onDisk)
+}
+
+// encryptBlob encrypts the store the way saveBlob writes it
+func (u *uiContext) encryptBlob() ([]byte, error) {
+	data, err := u.encodeStore(u.store.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	defer secmem.Wipe(data)
+	return u.encryptPayload(data)
+}
+
+// encodeStore encodes db in the encoding setting's encoding
+func (u *uiContext) encodeStore(db *txlogs.DB) ([]byte, error) {
+	if encoding, err := u.store.Setting(blobformat.SettingEncoding); err == nil && encoding == encodingBinary {
+		return db.SaveBinary()
 	}
 
-	data, err = crypt.Encrypt(cryptVersion, params, data)
+	return db.Save()
+}
+
+// encryptPayload encrypts plaintext with the current key in the version the
+// settings ask for
+func (u *uiContext) encryptPayload(plaintext []byte) ([]byte, error) {
+	params, err := u.makeParams()
 	if err != nil {
+		return nil, err
+	}
+
+	version := u.fileVersion()
+	ct, err := crypt.Encrypt(version, params, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	u.keepSlot(version, *params)
+	return ct, nil
+}
+
+// save writes the file to disk, if the file is configured to sync on save
+// it syncs before writing.
+func (u *uiContext) save() error {
+	if u.settingBool(blobformat.SettingSyncOnSave) && !flagNoAutoSync {
+		if err := u.sync("", true, true); err != nil {
+			errColor.Println("failed to synchronize:", err)
+		}
+	}
+
+	if err := u.saveBlob(); err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(flagFile, data, 0600)
+	infoColor.Println("saved:", u.shortFilename)
+	return nil
 }
 
 func shortPath(filename string) string {
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/aarondl/color"
+)
+
+var (
+	mdHeadingColor = color.FgBrightCyan
+	mdCodeColor    = color.FgYellow
+	mdLinkColor    = color.FgBrightBlue
+	mdQuoteColor   = color.FgGrey
+	mdStrongColor  = color.FgBrightWhite
+	mdBulletColor  = color.FgBrightMagenta
+)
+
+var (
+	rgxMDFence   = regexp.MustCompile("^\\s*(```|~~~)")
+	rgxMDHeading = regexp.MustCompile(`^\s*(#{1,6})\s+(.*?)(\s+#+)?\s*$`)
+	rgxMDList    = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+	rgxMDQuote   = regexp.MustCompile(`^\s*>\s?(.*)$`)
+	rgxMDCode    = regexp.MustCompile("`([^`]+)`")
+	rgxMDLink    = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	rgxMDStrong  = regexp.MustCompile(`(\*\*|__)([^*_]+)(\*\*|__)`)
+)
+
+// renderMarkdown styles the basic markdown of notes for the terminal:
+// headings, lists, quotes, code blocks and spans, links and bold text.
+// Anything else is left as it is.
+func renderMarkdown(text string) string {
+	lines := strings.Split(text, "\n")
+	out := make([]string, 0, len(lines))
+
+	inFence := false
+	for _, line := range lines {
+		if rgxMDFence.MatchString(line) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			out = append(out, mdCodeColor.Sprint(line))
+			continue
+		}
+
+		if m := rgxMDHeading.FindStringSubmatch(line); m != nil {
+			heading := m[2]
+			if len(m[1]) == 1 {
+				heading = strings.ToUpper(heading)
+			}
+			out = append(out, mdHeadingColor.Sprint(heading))
+			continue
+		}
+		if m := rgxMDQuote.FindStringSubmatch(line); m != nil {
+			out = append(out, mdQuoteColor.Sprint("│ ")+renderInline(m[1]))
+			continue
+		}
+		if m := rgxMDList.FindStringSubmatch(line); m != nil {
+			bullet := m[2]
+			if bullet == "-" || bullet == "*" || bullet == "+" {
+				bullet = "•"
+			}
+			out = append(out, m[1]+mdBulletColor.Sprint(bullet)+" "+renderInline(m[3]))
+			continue
+		}
+
+		out = append(out, renderInline(line))
+	}
+
+	return strings.Join(out, "\n")
+}
+
+// renderInline styles the code spans, links and bold text of a line, code
+// spans are left alone otherwise
+func renderInline(line string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range rgxMDCode.FindAllStringSubmatchIndex(line, -1) {
+		b.WriteString(renderText(line[last:m[0]]))
+		b.WriteString(mdCodeColor.Sprint(line[m[2]:m[3]]))
+		last = m[1]
+	}
+	b.WriteString(renderText(line[last:]))
+
+	return b.String()
+}
+
+// renderText styles the links and bold text of text outside code spans
+func renderText(text string) string {
+	text = rgxMDLink.ReplaceAllStringFunc(text, func(link string) string {
+		m := rgxMDLink.FindStringSubmatch(link)
+		if m[1] == m[2] {
+			return mdLinkColor.Sprint(m[2])
+		}
+		return m[1] + " " + mdLinkColor.Sprint("("+m[2]+")")
+	})
+	return rgxMDStrong.ReplaceAllStringFunc(text, func(strong string) string {
+		m := rgxMDStrong.FindStringSubmatch(strong)
+		if m[1] != m[3] {
+			return strong
+		}
+		return mdStrongColor.Sprint(m[2])
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aarondl/color"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	t.Parallel()
+
+	notes := strings.Join([]string{
+		"# Deploy",
+		"## Steps ##",
+		"1. Run `make **release**`",
+		"  - see [the wiki](https://wiki.example.com)",
+		"* **never** on fridays",
+		"> ask ops first",
+		"```",
+		"# not a heading",
+		"```",
+		"plain __text__ and https://example.com",
+	}, "\n")
+
+	want := strings.Join([]string{
+		"DEPLOY",
+		"Steps",
+		"1. Run make **release**",
+		"  • see the wiki (https://wiki.example.com)",
+		"• never on fridays",
+		"│ ask ops first",
+		"# not a heading",
+		"plain text and https://example.com",
+	}, "\n")
+
+	rendered := renderMarkdown(notes)
+	if got := color.Clean(rendered); got != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, got)
+	}
+	if !color.Disable && rendered == color.Clean(rendered) {
+		t.Error("it should be styled")
+	}
+
+	if got := renderMarkdown("just a note"); got != "just a note" {
+		t.Error("notes without markdown should be left alone:", got)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/aarondl/bpass/blobformat"
@@ -14,6 +15,8 @@ type mergeResult struct {
 	Key, Salt   []byte
 	Master, IVM []byte
 	Log         []txlogs.Tx
+	// Resolved are sets of keys changed on both sides, see resolveKeys
+	Resolved []txlogs.Tx
 }
 
 // mergeBlobs has the insane task of merging each entry into the local entry
@@ -64,21 +67,24 @@ func mergeBlobs(u *uiContext, remotes []blobParts) (m mergeResult, err error) {
 	// our current stuff
 	m = mergeResult{
 		User: u.user, Pass: u.pass,
-		Key: u.key, Salt: u.salt,
-		Master: u.master, IVM: u.ivm,
+		Key: u.key.Bytes(), Salt: u.salt,
+		Master: u.master.Bytes(), IVM: u.ivm,
 		Log: make([]txlogs.Tx, len(u.store.Log)),
 	}
 	copy(m.Log, u.store.Log)
+	local := m.Log
+	var remoteLogs []txlogs.Tx
 
 	for _, r := range remotes {
+		remoteLogs = append(remoteLogs, r.Log...)
 		takeRemoteCreds := false
-		merged, err := mergeLogs(u, m.Log, r.Log)
+		merged, err := mergeLogs(u, m.Log, r.Log, r.Base, r.Exclude, r.Trusted, u.conflictPolicy(r.Name))
 		if err != nil {
 			return m, err
 		}
 
-		if len(u.master) == 0 && len(r.Params.Master) == 0 {
-			if !bytes.Equal(u.key, r.Params.Keys[r.Params.User]) {
+		if u.master.Len() == 0 && len(r.Params.Master) == 0 {
+			if !bytes.Equal(u.key.Bytes(), r.Params.Keys[r.Params.User]) {
 				// Key has changed
 				// Either the salt has changed or the password has changed, either
 				// way we'll try to determine who has the latest updates in the log
@@ -96,7 +102,7 @@ func mergeBlobs(u *uiContext, remotes []blobParts) (m mergeResult, err error) {
 					}
 				}
 			}
-		} else if len(u.master) != len(r.Params.Master) {
+		} else if u.master.Len() != len(r.Params.Master) {
 			// There's been a single->multi or multi->single change
 			// We have to instantiate the merged blob and check user counts
 			db := &txlogs.DB{Log: merged}
@@ -106,20 +112,20 @@ func mergeBlobs(u *uiContext, remotes []blobParts) (m mergeResult, err error) {
 				return m, err
 			}
 
-			if len(users) == 0 && len(u.master) != 0 {
+			if len(users) == 0 && u.master.Len() != 0 {
 				// There's no users remaining in the merged DB, and the local
 				// file is multi, meaning that the remote wins the merge
 				// and we become a single-user file
 				infoColor.Println("local file converted to single-user file")
 				takeRemoteCreds = true
-			} else if len(users) != 0 && len(u.master) == 0 {
+			} else if len(users) != 0 && u.master.Len() == 0 {
 				// There's users remaining in the merged DB, and the local file
 				// is not multi, meaning that the remote wins the merge
 				// and we become a multi-user file.
 				infoColor.Println("local file converted to multi-user file")
 				takeRemoteCreds = true
 			}
-		} else if !bytes.Equal(u.master, r.Params.Master) {
+		} else if !bytes.Equal(u.master.Bytes(), r.Params.Master) {
 			// There's been a master change in a multi multi
 
 			localStore := blobformat.Blobs{DB: &txlogs.DB{Log: m.Log}}
@@ -199,7 +205,38 @@ func mergeBlobs(u *uiContext, remotes []blobParts) (m mergeResult, err error) {
 		m.Log = merged
 	}
 
-	return m, nil
+	m.Resolved, err = u.resolveKeys(local, remoteLogs, m.Log)
+	return m, err
+}
+
+// resolveKeys returns the sets that merge the keys both the local and the
+// remote log changed since they forked by the merge rules (see
+// txlogs.Resolve), they're applied once the merged log replaced ours
+func (u *uiContext) resolveKeys(local, remote, merged []txlogs.Tx) ([]txlogs.Tx, error) {
+	rules, err := u.store.MergeRules()
+	if err != nil {
+		return nil, err
+	}
+
+	return txlogs.Resolve(local, remote, merged, rules), nil
+}
+
+// applyResolved makes the sets from resolveKeys
+func (u *uiContext) applyResolved(sets []txlogs.Tx) error {
+	for _, tx := range sets {
+		blob, err := u.store.Find(tx.UUID)
+		if err != nil {
+			return err
+		}
+		if blob == nil {
+			continue
+		}
+
+		u.store.DB.Set(tx.UUID, tx.Key, tx.Value)
+		infoColor.Printf("merged %s of %q from both sides\n", tx.Key, blob.Name())
+	}
+
+	return nil
 }
 
 var syncNoCommonAncestryWarning = `WARNING: There is no common ancestry between
@@ -207,15 +244,86 @@ the local and the remote file. What this probably means is that the wrong
 file is in the sync location, and proceeding would mean that both files become
 merged into one instead of remaining separate.`
 
-func mergeLogs(u *uiContext, local []txlogs.Tx, remote []txlogs.Tx) ([]txlogs.Tx, error) {
+// Conflict resolution policies
+const (
+	conflictInteractive  = "interactive"
+	conflictPreferNewest = "prefer-newest"
+	conflictPreferLocal  = "prefer-local"
+	conflictPreferRemote = "prefer-remote"
+)
+
+var conflictPolicies = []string{
+	conflictInteractive,
+	conflictPreferNewest,
+	conflictPreferLocal,
+	conflictPreferRemote,
+}
+
+func isConflictPolicy(value string) bool {
+	for _, p := range conflictPolicies {
+		if p == value {
+			return true
+		}
+	}
+	return false
+}
+
+// conflictPolicy finds the policy for the sync entry, falling back to the
+// file's setting. When running in the background interactive is not
+// possible so prefer-newest is used in its place.
+func (u *uiContext) conflictPolicy(syncName string) string {
+	var policy string
+	if _, blob, err := u.store.FindByName(syncName); err == nil && blob != nil {
+		policy = blob[blobformat.KeyConflicts]
+	}
+	if !isConflictPolicy(policy) {
+		policy, _ = u.store.Setting(blobformat.SettingConflicts)
+	}
+	if !isConflictPolicy(policy) {
+		policy = conflictInteractive
+	}
+
+	if policy == conflictInteractive && u.headless {
+		return conflictPreferNewest
+	}
+	return policy
+}
+
+// hasTx checks if the log contains the transaction
+func hasTx(log []txlogs.Tx, tx txlogs.Tx) bool {
+	for _, t := range log {
+		if t.Time == tx.Time && t.UUID == tx.UUID {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeLogs merges the remote log into the local one, asking about conflicts
+// or resolving them by policy. base is from the last merge with the remote
+// (the zero base when there wasn't one) and exclude is what's left out of
+// pushes to it, see txlogs.Merge3. The remote log is rejected if it was
+// tampered with, trusted are the device keys trusted so far (see verifyLog).
+func mergeLogs(u *uiContext, local []txlogs.Tx, remote []txlogs.Tx, base txlogs.Base, exclude []string, trusted map[string]bool, policy string) ([]txlogs.Tx, error) {
 	if len(remote) == 0 {
 		return local, nil
 	}
 
+	if err := u.verifyLog(local, remote, trusted); err != nil {
+		return nil, err
+	}
+
+	// What the remote would have of the local log, without a base it's
+	// not needed
+	var shared []txlogs.Tx
+	if base.Count != 0 {
+		shared, _ = blobformat.Blobs{DB: &txlogs.DB{Log: local}}.SyncLog(exclude...)
+	}
+
 	var c []txlogs.Tx
 	var conflicts []txlogs.Conflict
 	for {
-		c, conflicts = txlogs.Merge(local, remote, conflicts)
+		c, conflicts = txlogs.Merge3(base, local, shared, remote, conflicts)
 
 		if len(conflicts) == 0 {
 			break
@@ -223,6 +331,13 @@ func mergeLogs(u *uiContext, local []txlogs.Tx, remote []txlogs.Tx) ([]txlogs.Tx
 
 		infoColor.Println(len(conflicts), "conflicts occurred during syncing!")
 
+		if policy != conflictInteractive {
+			if err := resolveConflicts(conflicts, local, remote, policy); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
 		for i, c := range conflicts {
 			switch c.Kind {
 			case txlogs.ConflictKindRoot:
@@ -256,6 +371,7 @@ func mergeLogs(u *uiContext, local []txlogs.Tx, remote []txlogs.Tx) ([]txlogs.Tx
 					)
 				}
 
+			Prompt:
 				for {
 					line, err := u.prompt(promptColor.Sprint("[R]estore item? [D]elete item? (r/R/d/D): "))
 					if err != nil {
@@ -270,6 +386,7 @@ func mergeLogs(u *uiContext, local []txlogs.Tx, remote []txlogs.Tx) ([]txlogs.Tx
 					default:
 						continue
 					}
+					break Prompt
 				}
 			}
 		}
@@ -277,3 +394,38 @@ func mergeLogs(u *uiContext, local []txlogs.Tx, remote []txlogs.Tx) ([]txlogs.Tx
 
 	return c, nil
 }
+
+// resolveConflicts resolves all conflicts without asking according to the
+// policy. Logs with no common ancestry are never merged automatically.
+//
+// A delete-set conflict is a set that happened after a delete or without
+// knowing of it, so prefer-newest restores the entry. prefer-local and
+// prefer-remote keep the delete only if the preferred side deleted the
+// entry and did not change it afterwards.
+func resolveConflicts(conflicts []txlogs.Conflict, local, remote []txlogs.Tx, policy string) error {
+	for i, c := range conflicts {
+		switch c.Kind {
+		case txlogs.ConflictKindRoot:
+			errColor.Println(syncNoCommonAncestryWarning)
+			return fmt.Errorf("sync target was a total fork (refusing to merge with %s policy)", policy)
+		case txlogs.ConflictKindDeleteSet:
+			var preferred []txlogs.Tx
+			switch policy {
+			case conflictPreferLocal:
+				preferred = local
+			case conflictPreferRemote:
+				preferred = remote
+			}
+
+			if preferred != nil && hasTx(preferred, c.Initial) && !hasTx(preferred, c.Conflict) {
+				infoColor.Printf("entry %q: keeping delete (%s)\n", c.Initial.UUID, policy)
+				conflicts[i].DiscardConflict()
+			} else {
+				infoColor.Printf("entry %q: restoring deleted entry (%s)\n", c.Initial.UUID, policy)
+				conflicts[i].DiscardInitial()
+			}
+		}
+	}
+
+	return nil
+}
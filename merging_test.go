@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestMergePolicies(t *testing.T) {
+	t.Parallel()
+
+	// The local copy deleted entry 1 that the remote changed afterwards and
+	// the remote deleted entry 2 that the local copy changed afterwards
+	local := []txlogs.Tx{
+		{Time: 1, Kind: txlogs.TxAdd, UUID: "1"},
+		{Time: 2, Kind: txlogs.TxSetKey, UUID: "1", Key: "name", Value: "github"},
+		{Time: 3, Kind: txlogs.TxAdd, UUID: "2"},
+		{Time: 4, Kind: txlogs.TxSetKey, UUID: "2", Key: "name", Value: "aws"},
+		{Time: 10, Kind: txlogs.TxDelete, UUID: "1"},
+		{Time: 13, Kind: txlogs.TxSetKey, UUID: "2", Key: "pass", Value: "local"},
+	}
+	remote := []txlogs.Tx{
+		{Time: 1, Kind: txlogs.TxAdd, UUID: "1"},
+		{Time: 2, Kind: txlogs.TxSetKey, UUID: "1", Key: "name", Value: "github"},
+		{Time: 3, Kind: txlogs.TxAdd, UUID: "2"},
+		{Time: 4, Kind: txlogs.TxSetKey, UUID: "2", Key: "name", Value: "aws"},
+		{Time: 11, Kind: txlogs.TxSetKey, UUID: "1", Key: "pass", Value: "remote"},
+		{Time: 12, Kind: txlogs.TxDelete, UUID: "2"},
+	}
+
+	tests := []struct {
+		Policy string
+		Has1   bool
+		Has2   bool
+	}{
+		{conflictPreferNewest, true, true},
+		{conflictPreferLocal, false, true},
+		{conflictPreferRemote, true, false},
+	}
+
+	for _, test := range tests {
+		u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: ioutil.Discard}
+		merged, err := mergeLogs(u, local, remote, txlogs.Base{}, nil, nil, test.Policy)
+		if err != nil {
+			t.Errorf("%s: %v", test.Policy, err)
+			continue
+		}
+
+		db := &txlogs.DB{Log: merged}
+		if err = db.UpdateSnapshot(); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := db.Snapshot["1"]; ok != test.Has1 {
+			t.Errorf("%s: entry 1 should exist: %t", test.Policy, test.Has1)
+		}
+		if _, ok := db.Snapshot["2"]; ok != test.Has2 {
+			t.Errorf("%s: entry 2 should exist: %t", test.Policy, test.Has2)
+		}
+	}
+
+	// Logs without common ancestry are never merged by a policy
+	unrelated := []txlogs.Tx{
+		{Time: 5, Kind: txlogs.TxAdd, UUID: "3"},
+		{Time: 6, Kind: txlogs.TxSetKey, UUID: "3", Key: "name", Value: "other"},
+	}
+	for _, policy := range []string{conflictPreferNewest, conflictPreferLocal, conflictPreferRemote} {
+		u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: ioutil.Discard}
+		if _, err := mergeLogs(u, local, unrelated, txlogs.Base{}, nil, nil, policy); err == nil {
+			t.Errorf("%s: a fork should not be merged", policy)
+		}
+	}
+}
+
+func TestConflictPolicy(t *testing.T) {
+	t.Parallel()
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}}
+	if got := u.conflictPolicy("sync/scp"); got != conflictInteractive {
+		t.Error("the default should be interactive, got:", got)
+	}
+	u.headless = true
+	if got := u.conflictPolicy("sync/scp"); got != conflictPreferNewest {
+		t.Error("headless can't be interactive, got:", got)
+	}
+	u.headless = false
+
+	if err := u.store.SetSetting(blobformat.SettingConflicts, conflictPreferRemote); err != nil {
+		t.Fatal(err)
+	}
+	uuid, err := u.store.New("sync/scp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.conflictPolicy("sync/scp"); got != conflictPreferRemote {
+		t.Error("the setting should be used, got:", got)
+	}
+
+	u.store.DB.Set(uuid, blobformat.KeyConflicts, conflictPreferLocal)
+	if got := u.conflictPolicy("sync/scp"); got != conflictPreferLocal {
+		t.Error("the entry's policy should win, got:", got)
+	}
+
+	u.store.DB.Set(uuid, blobformat.KeyConflicts, "prefer-mine")
+	if got := u.conflictPolicy("sync/scp"); got != conflictPreferRemote {
+		t.Error("an unknown policy should fall back to the setting, got:", got)
+	}
+}
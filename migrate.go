@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/crypt"
+)
+
+// runMigrate rewrites the file with a new key derived with the kdf setting
+// and encrypted with the cipher setting (--kdf and --cipher change them
+// first). The original file is kept next to it and the new one replaces it
+// in a single rename so there's never a half written file.
+func runMigrate(u *uiContext) error {
+	if u.created {
+		errColor.Println("there is no file to migrate at", flagFile)
+		return nil
+	}
+	if u.readOnly {
+		errColor.Println("a file opened at a time in the past can't be migrated")
+		return nil
+	}
+
+	original, err := ioutil.ReadFile(flagFile)
+	if err != nil {
+		return err
+	}
+	oldVersion, err := crypt.FileVersion(original)
+	if err != nil {
+		return err
+	}
+
+	if len(flagKDF) != 0 {
+		if !isKDFParams(flagKDF) {
+			_, err = crypt.ParseKDFParams(flagKDF)
+			return fmt.Errorf("--kdf was invalid: %w", err)
+		}
+		if err = u.store.SetSetting(blobformat.SettingKDF, flagKDF); err != nil {
+			return err
+		}
+	}
+	if len(flagCipher) != 0 {
+		if !isCipher(flagCipher) {
+			return fmt.Errorf("--cipher was invalid: %q", flagCipher)
+		}
+		if err = u.store.SetSetting(blobformat.SettingCipher, flagCipher); err != nil {
+			return err
+		}
+	}
+
+	kdf := u.kdfParams()
+	current, err := crypt.SaltKDFParams(u.salt)
+	if err == nil && oldVersion != 0 && u.keyVersion() == kdf.Version() {
+		current.Factor = crypt.FactorNone
+		if current == kdf && oldVersion == u.fileVersion() {
+			infoColor.Printf("%s is already version %d with the kdf setting's params\n", u.shortFilename, oldVersion)
+			return nil
+		}
+	}
+
+	// A multi-user file changing kdf needs every user rekeyed, otherwise
+	// only our key changes
+	if u.master.Len() != 0 && u.keyVersion() != kdf.Version() {
+		if err = u.rekeyMaster(); err != nil {
+			return err
+		}
+		if u.keyVersion() != kdf.Version() {
+			infoColor.Println("migration cancelled")
+			return nil
+		}
+	} else if err = u.rekey(""); err != nil {
+		return err
+	}
+
+	data, err := u.encryptBlob()
+	if err != nil {
+		return err
+	}
+	newVersion, err := crypt.FileVersion(data)
+	if err != nil {
+		return err
+	}
+
+	backup := fmt.Sprintf("%s.v%d-%s.bak", flagFile, oldVersion, time.Now().Format("20060102150405"))
+	if err = writeNewFile(backup, original); err != nil {
+		return fmt.Errorf("failed to back up the file: %w", err)
+	}
+	if err = writeFileAtomic(flagFile, data); err != nil {
+		return err
+	}
+
+	infoColor.Printf("migrated %s from version %d to %d, the original is in %s\n", u.shortFilename, oldVersion, newVersion, backup)
+	return nil
+}
+
+// writeNewFile writes a file that must not exist yet
+func writeNewFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+	}
+
+	return err
+}
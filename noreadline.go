@@ -47,6 +47,12 @@ func (s *scanEditor) Line(prompt string) (string, error) {
 	return s.Scanner.Text(), nil
 }
 
+// LineBlock implements LineEditor.LineBlock, pastes aren't bracketed here so
+// they arrive a line at a time
+func (s *scanEditor) LineBlock(prompt string) (string, error) {
+	return s.Line(prompt)
+}
+
 // LineHidden implements LineEditor.LineHidden
 func (s *scanEditor) LineHidden(prompt string) (string, error) {
 	stdinHandle, err := windows.GetStdHandle(stdInputHandle)
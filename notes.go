@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/aarondl/bpass/blobformat"
+)
+
+// noteFields are what addnote asks for, the tags are the note's labels
+var noteFields = []kindField{
+	{Key: blobformat.KeyNotes, Prompt: "body (markdown)", Multiline: true},
+	{Key: blobformat.KeyLabels, Prompt: "tags (separated by commas)", Optional: true, Check: checkTags},
+}
+
+// checkTags checks tags are labels, see validateLabel
+func checkTags(tags string) error {
+	seen := make(map[string]bool)
+	for _, tag := range strings.Split(tags, ",") {
+		switch {
+		case len(tag) == 0:
+			return fmt.Errorf("%q has an empty tag", tags)
+		case strings.IndexFunc(tag, unicode.IsSpace) >= 0:
+			return fmt.Errorf("tags cannot contain spaces: %q", tag)
+		case strings.IndexFunc(tag, unicode.IsUpper) >= 0:
+			return fmt.Errorf("tags cannot contain uppercase: %q", tag)
+		case seen[tag]:
+			return fmt.Errorf("%q is there twice", tag)
+		}
+		seen[tag] = true
+	}
+	return nil
+}
+
+// addNote is a wizard to add a note entry
+func (u *uiContext) addNote(name string) error {
+	uuid, err := u.addKindInterruptible(name, blobformat.KindNote, noteFields)
+	if err != nil || len(uuid) == 0 {
+		return err
+	}
+
+	infoColor.Printf("added note %s, attach %s <path> adds files to it\n", name, name)
+	return nil
+}
+
+// showNoteBody shows the body of a note entry below its other keys like a
+// document, without a key in front of each line
+func showNoteBody(u *uiContext, body string, raw bool) {
+	if !raw {
+		body = renderMarkdown(body)
+	}
+	fmt.Fprintln(u.out)
+	fmt.Fprintln(u.out, strings.TrimSpace(body))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestAddNote(t *testing.T) {
+	t.Parallel()
+
+	in := &scriptedEditor{lines: []string{
+		"# Will", "with the lawyer", ".",
+		"legal, Family", "legal,legal", "legal,family",
+	}}
+	out := new(bytes.Buffer)
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: out, in: in}
+
+	if err := u.addNote("notes/will"); err != nil {
+		t.Fatal(err)
+	}
+	uuid, blob, err := u.store.FindByName("notes/will")
+	if err != nil || blob == nil {
+		t.Fatal("the note should have been added", err)
+	}
+	if blob.Kind() != blobformat.KindNote {
+		t.Error("kind was wrong:", blob.Kind())
+	}
+	if got := blob.Labels(); len(got) != 2 || got[0] != "legal" || got[1] != "family" {
+		t.Error("tags were wrong:", got)
+	}
+	if err = u.store.Attach(uuid, "will.pdf", []byte("%PDF")); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	if err = u.show("notes/will", 0, true); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	if !strings.HasSuffix(got, "\n\n# Will\nwith the lawyer\n") {
+		t.Errorf("the body should be last as it is: %q", got)
+	}
+	if !strings.Contains(got, "will.pdf") {
+		t.Errorf("the attachment should be shown: %q", got)
+	}
+	if strings.Contains(got, "notes:") || strings.Contains(got, "pass:") {
+		t.Errorf("the body shouldn't have a key or a password: %q", got)
+	}
+}
@@ -0,0 +1,7 @@
+package osutil
+
+import "errors"
+
+// ErrKeyNotFound is returned by KeychainLoad when nothing is stored under
+// the name or it has expired
+var ErrKeyNotFound = errors.New("key not found in keychain")
@@ -0,0 +1,66 @@
+package osutil
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// keychainService is the service keychain items are stored under
+const keychainService = "bpass"
+
+// keychainNotFound is the exit status of security when there's no item
+const keychainNotFound = 44
+
+// KeychainStore saves data in the login keychain with the security program.
+// The keychain has no expiry so the ttl is up to the caller to check.
+func KeychainStore(name string, data []byte, ttl time.Duration) error {
+	// security -i reads commands from stdin which keeps the data out of
+	// the process list
+	command := fmt.Sprintf("add-generic-password -U -a %s -s %s -w %s\n",
+		name, keychainService, hex.EncodeToString(data))
+
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(command)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("security failed: %w: %s", err, out)
+	} else if len(bytes.TrimSpace(out)) != 0 {
+		return fmt.Errorf("security failed: %s", bytes.TrimSpace(out))
+	}
+
+	return nil
+}
+
+// KeychainLoad reads data from the login keychain
+func KeychainLoad(name string) ([]byte, error) {
+	cmd := exec.Command("security", "find-generic-password", "-a", name, "-s", keychainService, "-w")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, keychainErr(err)
+	}
+
+	return hex.DecodeString(strings.TrimSpace(string(out)))
+}
+
+// KeychainRemove removes data from the login keychain
+func KeychainRemove(name string) error {
+	cmd := exec.Command("security", "delete-generic-password", "-a", name, "-s", keychainService)
+	if err := cmd.Run(); err != nil {
+		return keychainErr(err)
+	}
+
+	return nil
+}
+
+func keychainErr(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == keychainNotFound {
+		return ErrKeyNotFound
+	}
+
+	return fmt.Errorf("security failed: %w", err)
+}
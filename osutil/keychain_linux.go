@@ -0,0 +1,75 @@
+package osutil
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// keyPerm lets the owner (as possessor or user) use the key, the default
+// only allows possessors to read it and the user keyring isn't always
+// possessed
+const keyPerm = 0x3f3f0000
+
+// KeychainStore saves data in the kernel's user keyring, the kernel removes
+// it once the ttl is up
+func KeychainStore(name string, data []byte, ttl time.Duration) error {
+	id, err := unix.AddKey("user", name, data, unix.KEY_SPEC_USER_KEYRING)
+	if err != nil {
+		return err
+	}
+
+	if _, err = unix.KeyctlInt(unix.KEYCTL_SETPERM, id, keyPerm, 0, 0); err != nil {
+		return err
+	}
+
+	secs := int(ttl / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	_, err = unix.KeyctlInt(unix.KEYCTL_SET_TIMEOUT, id, secs, 0, 0)
+	return err
+}
+
+// KeychainLoad reads data from the kernel's user keyring
+func KeychainLoad(name string) ([]byte, error) {
+	id, err := keychainSearch(name)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, size)
+	if _, err = unix.KeyctlBuffer(unix.KEYCTL_READ, id, data, 0); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// KeychainRemove removes data from the kernel's user keyring
+func KeychainRemove(name string) error {
+	id, err := keychainSearch(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = unix.KeyctlInt(unix.KEYCTL_INVALIDATE, id, 0, 0, 0)
+	return err
+}
+
+func keychainSearch(name string) (int, error) {
+	id, err := unix.KeyctlSearch(unix.KEY_SPEC_USER_KEYRING, "user", name, 0)
+	switch err {
+	case nil:
+		return id, nil
+	case unix.ENOKEY, unix.EKEYEXPIRED, unix.EKEYREVOKED:
+		return 0, ErrKeyNotFound
+	default:
+		return 0, err
+	}
+}
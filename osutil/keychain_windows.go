@@ -0,0 +1,98 @@
+package osutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// KeychainStore encrypts data with DPAPI for the current user and keeps it
+// in the user's cache directory. DPAPI has no expiry so the ttl is up to
+// the caller to check.
+func KeychainStore(name string, data []byte, ttl time.Duration) error {
+	path, err := keychainPath(name)
+	if err != nil {
+		return err
+	}
+
+	protected, err := dpapi(data, true)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, protected, 0600)
+}
+
+// KeychainLoad reads and decrypts data stored by KeychainStore
+func KeychainLoad(name string) ([]byte, error) {
+	path, err := keychainPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	protected, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return dpapi(protected, false)
+}
+
+// KeychainRemove removes data stored by KeychainStore
+func KeychainRemove(name string) error {
+	path, err := keychainPath(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return ErrKeyNotFound
+	}
+	return err
+}
+
+func keychainPath(name string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "bpass", "keys", name), nil
+}
+
+// dpapi protects or unprotects data
+func dpapi(data []byte, protect bool) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no data for dpapi")
+	}
+
+	in := windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+	var out windows.DataBlob
+
+	var err error
+	if protect {
+		err = windows.CryptProtectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	} else {
+		err = windows.CryptUnprotectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dpapi failed: %w", err)
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+
+	result := make([]byte, out.Size)
+	copy(result, (*[1 << 30]byte)(unsafe.Pointer(out.Data))[:out.Size:out.Size])
+	return result, nil
+}
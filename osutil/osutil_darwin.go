@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 )
 
 // OpenURL uses the open program on darwin
@@ -44,3 +45,32 @@ func RunEditor(filename string) error {
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
+
+// Pick shows the options using choose (https://github.com/chipsenkbeil/choose)
+// and returns the chosen one, an empty string is returned if nothing was
+// chosen.
+func Pick(prompt string, options []string) (string, error) {
+	command, err := exec.LookPath("choose")
+	if err != nil {
+		return "", errors.New("could not find choose in path")
+	}
+
+	return runPicker(command, nil, options)
+}
+
+// TypeText types the text into the focused window using osascript
+func TypeText(text string) error {
+	// Pass the text through stdin so it never shows up in the process list
+	script := `on run
+	set txt to do shell script "cat"
+	tell application "System Events" to keystroke txt
+end run`
+
+	cmd := exec.Command("osascript", "-e", script)
+	cmd.Stdin = strings.NewReader(text)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("osascript failed: %w: %s", err, out)
+	}
+
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 	"syscall"
 )
 
@@ -53,3 +54,36 @@ func RunEditor(filename string) error {
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
+
+// Pick shows the options in rofi or dmenu (whichever is found first) and
+// returns the chosen one, an empty string is returned if nothing was chosen.
+func Pick(prompt string, options []string) (string, error) {
+	var args []string
+	command, err := exec.LookPath("rofi")
+	if err == nil {
+		args = []string{"-dmenu", "-i", "-p", prompt}
+	} else if command, err = exec.LookPath("dmenu"); err == nil {
+		args = []string{"-i", "-p", prompt}
+	} else {
+		return "", errors.New("could not find rofi or dmenu in path")
+	}
+
+	return runPicker(command, args, options)
+}
+
+// TypeText types the text into the focused window using xdotool
+func TypeText(text string) error {
+	command, err := exec.LookPath("xdotool")
+	if err != nil {
+		return errors.New("could not find xdotool in path")
+	}
+
+	// Read the text from stdin so it never shows up in the process list
+	cmd := exec.Command(command, "type", "--clearmodifiers", "--file", "-")
+	cmd.Stdin = strings.NewReader(text)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("xdotool failed: %w: %s", err, out)
+	}
+
+	return nil
+}
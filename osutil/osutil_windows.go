@@ -49,3 +49,13 @@ func RunEditor(filename string) error {
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
+
+// Pick is not supported on windows
+func Pick(prompt string, options []string) (string, error) {
+	return "", errors.New("picking is not supported on windows")
+}
+
+// TypeText is not supported on windows
+func TypeText(text string) error {
+	return errors.New("typing is not supported on windows")
+}
@@ -0,0 +1,26 @@
+package osutil
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+)
+
+// runPicker runs a dmenu style picker, options are given one per line on
+// stdin and the choice is read from stdout. Pickers exit non-zero when
+// they're dismissed so that's not treated as an error.
+func runPicker(command string, args []string, options []string) (string, error) {
+	out := new(bytes.Buffer)
+	cmd := exec.Command(command, args...)
+	cmd.Stdin = strings.NewReader(strings.Join(options, "\n"))
+	cmd.Stdout = out
+
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return strings.TrimSpace(out.String()), nil
+}
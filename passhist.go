@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+)
+
+// getPassHistory lists the old passwords of an entry newest first, or shows
+// or copies the one at index (1 is the newest)
+func (u *uiContext) getPassHistory(blob blobformat.Blob, index int, copy bool) error {
+	old := blob.PassHistory()
+	if len(old) == 0 {
+		infoColor.Printf("%s has no old passwords\n", blob.Name())
+		return nil
+	}
+
+	if index < 0 {
+		if copy {
+			errColor.Println("syntax: cp <query> passhist <index>")
+			return nil
+		}
+
+		width := len(fmt.Sprint(len(old)))
+		for i, o := range old {
+			fmt.Fprintf(u.out, "  %*d %s %s\n", width, i+1,
+				o.Replaced.Local().Format(time.RFC3339), hideColor.Sprint(o.Pass))
+		}
+		return nil
+	}
+
+	if index == 0 || index > len(old) {
+		errColor.Printf("%s has %d old passwords\n", blob.Name(), len(old))
+		return nil
+	}
+
+	pass := old[index-1].Pass
+	if copy {
+		copyToClipboard(blobformat.KeyPassHistory, pass)
+	} else {
+		fmt.Println(pass)
+	}
+	return nil
+}
+
+// restorePass sets an entry's pass back to one of its old passwords, the
+// one it replaces goes into the history like any other
+func (u *uiContext) restorePass(search string, index int) error {
+	uuid, err := u.findOne(search)
+	if err != nil {
+		return err
+	}
+	if len(uuid) == 0 {
+		return nil
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+
+	old := blob.PassHistory()
+	if index <= 0 || index > len(old) {
+		errColor.Printf("%s has %d old passwords, see get %s passhist\n", blob.Name(), len(old), blob.Name())
+		return nil
+	}
+
+	if err = u.store.Set(uuid, blobformat.KeyPass, old[index-1].Pass); err != nil {
+		return err
+	}
+
+	infoColor.Printf("restored the password of %s from %s\n",
+		blob.Name(), old[index-1].Replaced.Local().Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestPassHistory(t *testing.T) {
+	t.Parallel()
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: ioutil.Discard}
+	uuid, err := u.store.New("github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.store.SetSetting(blobformat.SettingPassHistory, "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pass := range []string{"one", "two", "two", "three", "four"} {
+		if err = u.store.Set(uuid, blobformat.KeyPass, pass); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := blob.PassHistory()
+	if len(old) != 2 || old[0].Pass != "three" || old[1].Pass != "two" {
+		t.Fatalf("old passwords were wrong: %#v", old)
+	}
+	if err = u.store.Set(uuid, blobformat.KeyPassHistory, ""); !blobformat.IsKeyNotAllowed(err) {
+		t.Error("the history should only be kept by setting pass:", err)
+	}
+
+	if err = u.restorePass("github", 2); err != nil {
+		t.Fatal(err)
+	}
+	blob, _ = u.store.MustFind(uuid)
+	if blob[blobformat.KeyPass] != "two" {
+		t.Error("the password should have been restored:", blob[blobformat.KeyPass])
+	}
+	if old = blob.PassHistory(); len(old) != 2 || old[0].Pass != "four" || old[1].Pass != "three" {
+		t.Errorf("the restored over password should be in the history: %#v", old)
+	}
+}
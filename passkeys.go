@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"errors"
+
+	"github.com/aarondl/bpass/blobformat"
+)
+
+// passkeyFields are what addpasskey asks for, they're what passkeys are
+// exported with
+var passkeyFields = []kindField{
+	{Key: blobformat.KeyRPID, Prompt: "relying party id (eg. github.com)"},
+	{Key: blobformat.KeyUser, Prompt: "username", Optional: true},
+	{Key: blobformat.KeyCredentialID, Prompt: "credential id (base64url)", Check: checkCredentialID},
+	{Key: blobformat.KeyUserHandle, Prompt: "user handle (base64url)", Optional: true, Check: checkUserHandle},
+	{Key: blobformat.KeyCounter, Prompt: "signature counter", Optional: true},
+	{Key: blobformat.KeyPriv, Prompt: "private key (pem)", Multiline: true, Check: checkPasskeyKey},
+}
+
+func checkCredentialID(id string) error {
+	if _, err := blobformat.DecodeBase64URL(id); err != nil {
+		return errors.New("the credential id must be base64url")
+	}
+	return nil
+}
+
+func checkUserHandle(handle string) error {
+	if _, err := blobformat.DecodeBase64URL(handle); err != nil {
+		return errors.New("the user handle must be base64url")
+	}
+	return nil
+}
+
+func checkPasskeyKey(pem string) error {
+	_, err := blobformat.ParsePasskeyKey(pem)
+	return err
+}
+
+// addPasskey is a wizard to add a passkey entry
+func (u *uiContext) addPasskey(name string) error {
+	uuid, err := u.addKindInterruptible(name, blobformat.KindPasskey, passkeyFields)
+	if err != nil || len(uuid) == 0 {
+		return err
+	}
+
+	infoColor.Printf("added passkey %s\n", name)
+	return nil
+}
+
+// passkeyKeyType describes the private key of a passkey without showing it
+func passkeyKeyType(pem string) string {
+	key, err := blobformat.ParsePasskeyKey(pem)
+	if err != nil {
+		return errColor.Sprint("(" + err.Error() + ")")
+	}
+
+	switch key.(type) {
+	case *ecdsa.PrivateKey:
+		return "ecdsa P-256 private key"
+	case ed25519.PrivateKey:
+		return "ed25519 private key"
+	}
+	return "private key"
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestAddPasskey(t *testing.T) {
+	t.Parallel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})))
+
+	other, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err = x509.MarshalECPrivateKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384PEM := strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})))
+
+	in := &scriptedEditor{}
+	out := new(bytes.Buffer)
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: out, in: in}
+
+	in.lines = []string{"github.com", "aarondl", "not base64!", "q83vEjRWeJA", "", "41"}
+	in.lines = append(in.lines, strings.Split(p384PEM, "\n")...)
+	in.lines = append(in.lines, ".")
+	in.lines = append(in.lines, strings.Split(keyPEM, "\n")...)
+	in.lines = append(in.lines, ".")
+	if err = u.addPasskey("passkeys/github"); err != nil {
+		t.Fatal(err)
+	}
+
+	uuid, blob, err := u.store.FindByName("passkeys/github")
+	if err != nil || blob == nil {
+		t.Fatal("the passkey should have been added:", err)
+	}
+	passkey, err := blob.Passkey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if passkey.RPID != "github.com" || !bytes.Equal(passkey.CredentialID, []byte{0xab, 0xcd, 0xef, 0x12, 0x34, 0x56, 0x78, 0x90}) ||
+		len(passkey.UserHandle) != 0 || passkey.Counter != 41 {
+		t.Errorf("passkey was wrong: %#v", passkey)
+	}
+	if signer, ok := passkey.Key.(*ecdsa.PrivateKey); !ok || signer.D.Cmp(key.D) != 0 {
+		t.Error("the private key was wrong")
+	}
+
+	if n, err := u.store.NextSignCount(uuid); err != nil || n != 42 {
+		t.Error("want counter 42, got:", n, err)
+	}
+	blob, _ = u.store.MustFind(uuid)
+	if blob[blobformat.KeyCounter] != "42" {
+		t.Error("the counter should be stored:", blob[blobformat.KeyCounter])
+	}
+
+	if err = u.show("passkeys/github", 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "ecdsa P-256 private key") || strings.Contains(out.String(), "BEGIN PRIVATE KEY") {
+		t.Error("show should describe the key without showing it:", out.String())
+	}
+
+	// Logins aren't passkeys
+	login, err := u.store.New("github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, _ = u.store.MustFind(login)
+	if _, err = blob.Passkey(); err == nil {
+		t.Error("want an error for a login")
+	}
+}
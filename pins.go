@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/aarondl/bpass/blobformat"
+)
+
+// pin pins an entry so ls lists it first, or unpins it
+func (u *uiContext) pin(search string, pin bool) error {
+	uuid, err := u.findOne(search)
+	if err != nil {
+		return err
+	}
+	if len(uuid) == 0 {
+		return nil
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+
+	var changed bool
+	if pin {
+		changed, err = u.store.Pin(uuid)
+	} else {
+		changed, err = u.store.Unpin(uuid)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !changed && pin:
+		infoColor.Printf("%s is already pinned\n", blob.Name())
+	case !changed:
+		infoColor.Printf("%s is not pinned\n", blob.Name())
+	case pin:
+		infoColor.Printf("pinned %s\n", blob.Name())
+	default:
+		infoColor.Printf("unpinned %s\n", blob.Name())
+	}
+
+	return nil
+}
+
+// pinnedFirst sorts the uuids of entries by name with the pinned ones first,
+// it returns how many of them are pinned
+func (u *uiContext) pinnedFirst(entries blobformat.SearchResults) ([]string, int) {
+	pinned := u.store.Pinned()
+
+	uuids := entries.UUIDs()
+	sort.Slice(uuids, func(i, j int) bool {
+		a, b := uuids[i], uuids[j]
+		if pinned[a] != pinned[b] {
+			return pinned[a]
+		}
+		return entries[a] < entries[b]
+	})
+
+	n := 0
+	for _, uuid := range uuids {
+		if pinned[uuid] {
+			n++
+		}
+	}
+	return uuids, n
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestPin(t *testing.T) {
+	t.Parallel()
+
+	buf := new(bytes.Buffer)
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: buf}
+	for _, name := range []string{"aws", "github", "zoom"} {
+		if _, err := u.store.New(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := u.pin("zoom", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.pin("github", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.list(""); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	if i, j, k := strings.Index(got, "github"), strings.Index(got, "zoom"), strings.Index(got, "aws"); i > j || j > k {
+		t.Errorf("pinned entries should be first: %q", got)
+	}
+
+	uuid, _, _ := u.store.FindByName("zoom")
+	if changed, err := u.store.Pin(uuid); err != nil || changed {
+		t.Error("it should already be pinned", err)
+	}
+	if err := u.pin("zoom", false); err != nil {
+		t.Fatal(err)
+	}
+	blob, _ := u.store.MustFind(uuid)
+	if blob.IsPinned() {
+		t.Error("it should be unpinned")
+	}
+
+	// Trashed entries aren't pinned
+	gh, _, _ := u.store.FindByName("github")
+	u.store.Trash(gh)
+	if pinned := u.store.Pinned(); len(pinned) != 0 {
+		t.Error("nothing should be pinned:", pinned)
+	}
+
+	if err := u.store.Set(uuid, blobformat.KeyPinned, "1"); !blobformat.IsKeyNotAllowed(err) {
+		t.Error("want a not allowed error, got:", err)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"os"
 	"sort"
 
+	"github.com/aarondl/bpass/blobformat"
+
 	"github.com/aarondl/readline"
 )
 
@@ -24,18 +26,27 @@ type readlineEditor struct {
 	promptNeedsReset bool
 	instance         *readline.Instance
 	out              io.Writer
+	stdin            *pasteReader
+	bracketedPaste   bool
 }
 
 func newReadlineEditor(out io.Writer, fn completer) (readlineEditor, error) {
-	instance, err := readline.NewEx(readlineConfig(out, fn))
+	stdin := newPasteReader(os.Stdin)
+	instance, err := readline.NewEx(readlineConfig(out, stdin, fn))
 	if err != nil {
 		return readlineEditor{}, err
 	}
 
-	return readlineEditor{instance: instance, out: out}, nil
+	// Pastes are only bracketed when we ask the terminal to, see pasteReader
+	bracketedPaste := readline.DefaultIsTerminal()
+	if bracketedPaste {
+		fmt.Fprint(out, bracketedPasteOn)
+	}
+
+	return readlineEditor{instance: instance, out: out, stdin: stdin, bracketedPaste: bracketedPaste}, nil
 }
 
-func readlineConfig(out io.Writer, entryCompleter completer) *readline.Config {
+func readlineConfig(out io.Writer, stdin io.ReadCloser, entryCompleter completer) *readline.Config {
 	var completer readline.AutoCompleter
 	if entryCompleter != nil {
 		completer = readlineAutocompleter(entryCompleter)
@@ -53,7 +64,7 @@ func readlineConfig(out io.Writer, entryCompleter completer) *readline.Config {
 		InterruptPrompt: "interrupt",
 		EOFPrompt:       "exit",
 
-		Stdin:  os.Stdin,
+		Stdin:  stdin,
 		Stdout: out,
 		Stderr: os.Stderr,
 
@@ -63,6 +74,18 @@ func readlineConfig(out io.Writer, entryCompleter completer) *readline.Config {
 
 // Line implements LineEditor.Line
 func (r readlineEditor) Line(prompt string) (string, error) {
+	s, err := r.readline(prompt)
+	return pastedSingleLine(s), err
+}
+
+// LineBlock implements LineEditor.LineBlock
+func (r readlineEditor) LineBlock(prompt string) (string, error) {
+	s, err := r.readline(prompt)
+	return pastedLine(s), err
+}
+
+// readline reads a line with the line breaks of a paste still pasteNewline
+func (r readlineEditor) readline(prompt string) (string, error) {
 	if r.currentPrompt != prompt || r.promptNeedsReset {
 		r.currentPrompt = prompt
 		r.promptNeedsReset = false
@@ -88,7 +111,7 @@ func (r readlineEditor) LineHidden(prompt string) (string, error) {
 	byt, err := r.instance.ReadPassword(prompt)
 	switch err {
 	case nil:
-		return string(byt), nil
+		return pastedSingleLine(string(byt)), nil
 	case io.EOF:
 		r.promptNeedsReset = true
 		return "", ErrEnd
@@ -109,12 +132,20 @@ func (r readlineEditor) AddHistory(line string) {
 
 // SetEntryCompleter sets a completion function for entries.
 func (r readlineEditor) SetEntryCompleter(entryCompleter func(string) []string) {
-	r.instance.SetConfig(readlineConfig(r.out, entryCompleter))
+	r.instance.SetConfig(readlineConfig(r.out, r.stdin, entryCompleter))
 }
 
 func entryCompleter(u *uiContext) func(string) []string {
 	return func(s string) []string {
-		if u == nil || u.store.DB == nil {
+		if u == nil {
+			return nil
+		}
+
+		// A background sync may be changing the store
+		u.bgSync.Lock()
+		defer u.bgSync.Unlock()
+
+		if u.store.DB == nil {
 			return nil
 		}
 
@@ -130,12 +161,58 @@ func entryCompleter(u *uiContext) func(string) []string {
 	}
 }
 
+// colorItems complete the names of the colors entries may be listed in
+func colorItems() []readline.PrefixCompleterInterface {
+	items := make([]readline.PrefixCompleterInterface, len(blobformat.EntryColors))
+	for i, name := range blobformat.EntryColors {
+		items[i] = readline.PcItem(name)
+	}
+	return items
+}
+
 func readlineAutocompleter(entryCompleter func(string) []string) readline.AutoCompleter {
 	return readline.NewPrefixCompleter(
 		readline.PcItem("passwd"),
-		readline.PcItem("help"),
+		readline.PcItem("help",
+			readline.PcItem("search"),
+			readline.PcItem("sync"),
+			readline.PcItem("users"),
+			readline.PcItem("other"),
+		),
 		readline.PcItem("exit"),
+		readline.PcItem("save"),
+		readline.PcItem("config",
+			readline.PcItem("synconsave"),
+			readline.PcItem("conflicts"),
+			readline.PcItem("syncretries"),
+			readline.PcItem("syncbackoff"),
+		),
 		readline.PcItem("add"),
+		readline.PcItem("addcert"),
+		readline.PcItem("addcard"),
+		readline.PcItem("addidentity"),
+		readline.PcItem("addwifi"),
+		readline.PcItem("addnote"),
+		readline.PcItem("addpasskey"),
+		readline.PcItem("qr", readline.PcItemDynamic(entryCompleter)),
+		readline.PcItem("sshkey",
+			readline.PcItem("gen", readline.PcItemDynamic(entryCompleter)),
+			readline.PcItem("export", readline.PcItemDynamic(entryCompleter)),
+		),
+		readline.PcItem("audit"),
+		readline.PcItem("stats"),
+		readline.PcItem("recent"),
+		readline.PcItem("pin", readline.PcItemDynamic(entryCompleter)),
+		readline.PcItem("unpin", readline.PcItemDynamic(entryCompleter)),
+		readline.PcItem("lock", readline.PcItemDynamic(entryCompleter)),
+		readline.PcItem("unlock", readline.PcItemDynamic(entryCompleter)),
+		readline.PcItem("due"),
+		readline.PcItem("log",
+			readline.PcItem("export"),
+		),
+		readline.PcItem("export",
+			readline.PcItem("cert", readline.PcItemDynamic(entryCompleter)),
+		),
 		readline.PcItem("rm", readline.PcItemDynamic(entryCompleter)),
 		readline.PcItem("mv", readline.PcItemDynamic(entryCompleter)),
 		readline.PcItem("ls"),
@@ -148,7 +225,10 @@ func readlineAutocompleter(entryCompleter func(string) []string) readline.AutoCo
 				readline.PcItem("user"),
 				readline.PcItem("pass"),
 				readline.PcItem("totp"),
+				readline.PcItem("recovery"),
 				readline.PcItem("notes"),
+				readline.PcItem("icon"),
+				readline.PcItem("color", colorItems()...),
 			),
 		),
 		readline.PcItem("get",
@@ -157,6 +237,7 @@ func readlineAutocompleter(entryCompleter func(string) []string) readline.AutoCo
 				readline.PcItem("user"),
 				readline.PcItem("pass"),
 				readline.PcItem("totp"),
+				readline.PcItem("recovery"),
 				readline.PcItem("notes"),
 			),
 		),
@@ -166,6 +247,7 @@ func readlineAutocompleter(entryCompleter func(string) []string) readline.AutoCo
 				readline.PcItem("user"),
 				readline.PcItem("pass"),
 				readline.PcItem("totp"),
+				readline.PcItem("recovery"),
 				readline.PcItem("notes"),
 			),
 		),
@@ -175,26 +257,47 @@ func readlineAutocompleter(entryCompleter func(string) []string) readline.AutoCo
 				readline.PcItem("user"),
 				readline.PcItem("pass"),
 				readline.PcItem("totp"),
+				readline.PcItem("recovery"),
 				readline.PcItem("notes"),
 			),
 		),
 		readline.PcItem("open", readline.PcItemDynamic(entryCompleter)),
+		readline.PcItem("match"),
 		readline.PcItem("rmk",
 			readline.PcItemDynamic(entryCompleter,
 				readline.PcItem("email"),
 				readline.PcItem("user"),
 				readline.PcItem("pass"),
 				readline.PcItem("totp"),
+				readline.PcItem("recovery"),
 				readline.PcItem("notes"),
 			),
 		),
+		readline.PcItem("restorepass", readline.PcItemDynamic(entryCompleter)),
 		readline.PcItem("label", readline.PcItemDynamic(entryCompleter)),
 		readline.PcItem("rmlabel", readline.PcItemDynamic(entryCompleter)),
+		readline.PcItem("protect", readline.PcItemDynamic(entryCompleter)),
+		readline.PcItem("unprotect", readline.PcItemDynamic(entryCompleter)),
+		readline.PcItem("secret", readline.PcItemDynamic(entryCompleter)),
+		readline.PcItem("unsecret", readline.PcItemDynamic(entryCompleter)),
 		readline.PcItem("pass", readline.PcItemDynamic(entryCompleter)),
 		readline.PcItem("user", readline.PcItemDynamic(entryCompleter)),
 		readline.PcItem("email", readline.PcItemDynamic(entryCompleter)),
 		readline.PcItem("totp", readline.PcItemDynamic(entryCompleter)),
-		readline.PcItem("sync", readline.PcItemDynamic(entryCompleter)),
+		readline.PcItem("fullname", readline.PcItemDynamic(entryCompleter)),
+		readline.PcItem("phone", readline.PcItemDynamic(entryCompleter)),
+		readline.PcItem("address", readline.PcItemDynamic(entryCompleter)),
+		readline.PcItem("use", readline.PcItem("recovery", readline.PcItemDynamic(entryCompleter))),
+		readline.PcItem("sync",
+			readline.PcItem("auto"),
+			readline.PcItem("status", readline.PcItemDynamic(entryCompleter)),
+			readline.PcItem("log"),
+			readline.PcItem("ls"),
+			readline.PcItem("rm", readline.PcItemDynamic(entryCompleter)),
+			readline.PcItem("test", readline.PcItemDynamic(entryCompleter)),
+			readline.PcItem("init", readline.PcItemDynamic(entryCompleter)),
+			readline.PcItemDynamic(entryCompleter),
+		),
 		readline.PcItem("addsync"),
 		readline.PcItem("adduser"),
 		readline.PcItem("rekey"),
@@ -203,5 +306,8 @@ func readlineAutocompleter(entryCompleter func(string) []string) readline.AutoCo
 
 // Close the liner editor
 func (r readlineEditor) Close() error {
+	if r.bracketedPaste {
+		fmt.Fprint(r.out, bracketedPasteOff)
+	}
 	return r.instance.Close()
 }
@@ -0,0 +1,86 @@
+// +build linux darwin
+
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+
+	"github.com/aarondl/readline"
+)
+
+func TestReadlinePastes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name   string
+		In     string
+		Hidden bool
+		Block  bool
+		Want   string
+	}{
+		{"hidden paste with enter", "\x1b[200~hunter2\n\x1b[201~\r", true, false, "hunter2"},
+		{"hidden paste", "\x1b[200~hunter2\x1b[201~\r", true, false, "hunter2"},
+		{"paste with enter", "\x1b[200~me@example.com\r\n\x1b[201~\r", false, false, "me@example.com"},
+		{"lines on one line", "\x1b[200~a\nb\n\x1b[201~\r", false, false, "a b"},
+		{"block", "\x1b[200~a\nb\n\x1b[201~\r", false, true, "a\nb"},
+	}
+
+	for _, test := range tests {
+		stdin := newPasteReader(ioutil.NopCloser(strings.NewReader(test.In)))
+		instance, err := readline.NewEx(readlineConfig(ioutil.Discard, stdin, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := readlineEditor{instance: instance, out: ioutil.Discard, stdin: stdin}
+
+		var got string
+		switch {
+		case test.Hidden:
+			got, err = r.LineHidden("> ")
+		case test.Block:
+			got, err = r.LineBlock("> ")
+		default:
+			got, err = r.Line("> ")
+		}
+		r.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", test.Name, err)
+		}
+		if got != test.Want {
+			t.Errorf("%s: want: %q, got: %q", test.Name, test.Want, got)
+		}
+	}
+}
+
+func TestEntryCompleterWaitsForSync(t *testing.T) {
+	t.Parallel()
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}}
+	u.bgSync.Lock()
+
+	names := make(chan []string)
+	go func() {
+		names <- entryCompleter(u)("")
+	}()
+
+	select {
+	case <-names:
+		t.Fatal("completion should wait for the sync")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, err := u.store.New("github"); err != nil {
+		t.Fatal(err)
+	}
+	u.bgSync.Unlock()
+
+	if got := <-names; len(got) != 1 || got[0] != "github" {
+		t.Error("want the entry added by the sync, got:", got)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+)
+
+const (
+	// recentMax is how many entries are remembered as recently used
+	recentMax = 50
+	// recentDefault is how many recent lists when it's not told
+	recentDefault = 10
+)
+
+// recentEntries are the entries of the file that were used last on this
+// machine, newest first. They're kept outside the file so using an entry
+// doesn't change it, only the uuids are written there.
+type recentEntries struct {
+	path  string
+	uuids []string
+	used  []time.Time
+}
+
+// recentPath is where the recently used entries of the file are kept for
+// the user. It's by the salt of the slot the file was opened with as well so
+// the decoy or hidden file in a second slot (see duressSet) has its own list
+// and neither gives away the other's entries. A new passphrase starts a new
+// list.
+func recentPath(user string, salt []byte) string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	sum := sha256.Sum256(append([]byte(fileUserID(user)+"\x00"), salt...))
+	return filepath.Join(dir, "bpass", "recent", hex.EncodeToString(sum[:16]))
+}
+
+// loadRecent reads the recently used entries at path, a missing or
+// unreadable file is none
+func loadRecent(path string) *recentEntries {
+	r := &recentEntries{path: path}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return r
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		unix, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		r.uuids = append(r.uuids, fields[1])
+		r.used = append(r.used, time.Unix(unix, 0))
+	}
+
+	return r
+}
+
+// touch records that the entry was used now, it's moved to the front
+func (r *recentEntries) touch(uuid string, now time.Time) {
+	for i, u := range r.uuids {
+		if u == uuid {
+			r.uuids = append(r.uuids[:i], r.uuids[i+1:]...)
+			r.used = append(r.used[:i], r.used[i+1:]...)
+			break
+		}
+	}
+
+	r.uuids = append([]string{uuid}, r.uuids...)
+	r.used = append([]time.Time{now}, r.used...)
+	if len(r.uuids) > recentMax {
+		r.uuids, r.used = r.uuids[:recentMax], r.used[:recentMax]
+	}
+
+	if len(r.path) == 0 {
+		return
+	}
+	if err := r.save(); err != nil {
+		errColor.Println("failed to record recently used entry:", err)
+	}
+}
+
+func (r *recentEntries) save() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	for i, uuid := range r.uuids {
+		fmt.Fprintf(buf, "%d %s\n", r.used[i].Unix(), uuid)
+	}
+	return ioutil.WriteFile(r.path, buf.Bytes(), 0600)
+}
+
+// touchRecent records that an entry was used, it does nothing when recently
+// used entries aren't kept (eg. running a single command)
+func (u *uiContext) touchRecent(uuid string) {
+	if u.recent != nil {
+		u.recent.touch(uuid, time.Now())
+	}
+}
+
+// listRecent lists the n entries that were used last, the ones that are gone
+// or in the trash are skipped
+func (u *uiContext) listRecent(n int) error {
+	if u.recent == nil || len(u.recent.uuids) == 0 {
+		fmt.Fprintln(u.out, "No entries were used recently")
+		return nil
+	}
+
+	names := u.store.DB.Values(blobformat.KeyName)
+	trashed := u.store.DB.Values(blobformat.KeyTrashed)
+	for i, uuid := range u.recent.uuids {
+		if n == 0 {
+			break
+		}
+		name, ok := names[uuid]
+		if _, inTrash := trashed[uuid]; !ok || inTrash {
+			continue
+		}
+
+		fmt.Fprintf(u.out, "%s %s\n", name, infoColor.Sprint(u.recent.used[i].Format(time.RFC3339)))
+		n--
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestRecent(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "bpass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "recent", "file")
+
+	buf := new(bytes.Buffer)
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: buf}
+	u.recent = loadRecent(path)
+	uuids := make(map[string]string)
+	for _, name := range []string{"aws", "github", "zoom"} {
+		if uuids[name], err = u.store.New(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, query := range []string{"aws", "zoom", "gith", "zoom"} {
+		if _, err = u.findOne(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{uuids["zoom"], uuids["github"], uuids["aws"]}
+	if !reflect.DeepEqual(u.recent.uuids, want) {
+		t.Error("recent was wrong:", u.recent.uuids)
+	}
+	if r := loadRecent(path); !reflect.DeepEqual(r.uuids, want) {
+		t.Error("recent wasn't saved:", r.uuids)
+	}
+
+	u.store.Trash(uuids["github"])
+	if err = u.listRecent(2); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "zoom ") || !strings.HasPrefix(lines[1], "aws ") {
+		t.Errorf("wrong entries listed: %q", lines)
+	}
+
+	// Only so many are remembered
+	r := &recentEntries{}
+	for i := 0; i < recentMax+5; i++ {
+		r.touch(strings.Repeat("x", i+1), time.Now())
+	}
+	if len(r.uuids) != recentMax || r.uuids[0] != strings.Repeat("x", recentMax+5) {
+		t.Error("wrong entries kept:", len(r.uuids))
+	}
+}
+
+func TestRecentPathSlots(t *testing.T) {
+	t.Parallel()
+
+	// The decoy or hidden file in the second slot has a salt of its own
+	if recentPath("", []byte("slot0")) == recentPath("", []byte("slot1")) {
+		t.Error("both slots of a file share their recent entries")
+	}
+	if recentPath("", []byte("slot0")) != recentPath("", []byte("slot0")) {
+		t.Error("the same slot should have the same recent entries")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/aarondl/bpass/blobformat"
+)
+
+// recoveryLow is how few recovery codes are left before use warns about it
+const recoveryLow = 2
+
+// useRecovery copies the next unused recovery code of an entry to the
+// clipboard and marks it used
+func (u *uiContext) useRecovery(search string) error {
+	uuid, err := u.findOne(search)
+	if err != nil {
+		return err
+	}
+	if len(uuid) == 0 {
+		return nil
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+
+	code, left, err := u.store.UseRecoveryCode(uuid)
+	if err != nil {
+		return err
+	}
+	if len(code) == 0 {
+		errColor.Println(blob.Name(), "has no unused recovery codes")
+		return nil
+	}
+
+	copyToClipboard(blobformat.KeyRecovery, code)
+	switch {
+	case left == 0:
+		errColor.Printf("that was the last recovery code of %s, generate new ones\n", blob.Name())
+	case left <= recoveryLow:
+		errColor.Printf("%s has only %d recovery codes left\n", blob.Name(), left)
+	default:
+		infoColor.Printf("%s has %d recovery codes left\n", blob.Name(), left)
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestUseRecovery(t *testing.T) {
+	t.Parallel()
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: ioutil.Discard}
+	uuid, err := u.store.New("github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.store.Set(uuid, blobformat.KeyRecovery, "aaaa-1111\n\n bbbb-2222 \n"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []string{"aaaa-1111", "bbbb-2222", ""} {
+		code, left, err := u.store.UseRecoveryCode(uuid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != want {
+			t.Errorf("%d) want code: %q, got: %q", i, want, code)
+		}
+		if want != "" && left != 1-i {
+			t.Errorf("%d) want %d left, got: %d", i, 1-i, left)
+		}
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := blob[blobformat.KeyRecovery]; ok {
+		t.Error("the used up codes should be deleted")
+	}
+	used := strings.Split(blob[blobformat.KeyRecoveryUsed], "\n")
+	if len(used) != 2 || !strings.HasSuffix(used[0], " aaaa-1111") || !strings.HasSuffix(used[1], " bbbb-2222") {
+		t.Errorf("used codes were wrong: %q", used)
+	}
+
+	// The repl command uses them the same way
+	if err = u.store.Set(uuid, blobformat.KeyRecovery, "cccc-3333"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.useRecovery("github"); err != nil {
+		t.Fatal(err)
+	}
+	blob, _ = u.store.MustFind(uuid)
+	if len(blob.RecoveryCodes()) != 0 || !strings.HasSuffix(blob[blobformat.KeyRecoveryUsed], " cccc-3333") {
+		t.Error("the code should have been used:", blob)
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/crypt"
 	"github.com/aarondl/color"
 )
 
@@ -17,38 +19,96 @@ the entry name as a query in the key commands below.
 
 General Commands:
  passwd       - Change the file's password for current user
- help [topic] - This help (how did you find this without seeing this help?)
+ save         - Save the file without exiting
+ undo [n]     - Revert the changes made by the last n commands
+ redo [n]     - Revert the last n undos
+ compact [days] - Squash the history older than days (all of it by default) to shrink the file
+ config [key] [value] - Show or change settings for this file
+ audit        - Report on entries needing attention (eg. expired passwords)
+ due [days]   - List entries that expired or expire soon (see the expires key)
+ devices      - List the devices that changed the file
+ stats        - Show the size of the file, its entries, history and attachments
+ log export <file> - Write who changed what and when (no values) as json lines
+ export cert <query> [--dir dir] - Write an entry's cert, key and chain to files
+ export age <file> <recipient...> - Write the file encrypted to age recipients
+ import age <file> <identity>     - Merge in an age export
+ help [topic]       - This help (how did you find this without seeing this help?)
+ help <command>     - Detailed usage and examples for a command
+ help search <text> - Search commands and help topics
  exit         - Exit the repl
 
 Entry Commands (manage entries in the file):
  add <name>      - Add a new entry
- rm  <name>      - Delete an entry
+ add <name> --template <tpl> - Add a new entry from a template
+ template ls         - List templates
+ template add <name> - Add a template (see "help templates")
+ addcert <name>  - Add a new certificate entry (cert, private key and chain)
+ addcard <name>  - Add a new payment card entry (number, expiry, cvv, cardholder and pin)
+ addidentity <name> - Add a new identity entry (name, address, phone, passport...)
+ addwifi <name>  - Add a new wifi entry (ssid, security and passphrase)
+ qr <query>      - Show the qr code phones scan to join a wifi entry's network
+ addpasskey <name> - Add a new passkey entry (relying party, credential id and private key)
+ addnote <name>  - Add a new note entry (a markdown body and tags, files can be attached)
+ sshkey gen <query> [ed25519|rsa] - Generate an ssh keypair for an entry
+ sshkey export <query> <file>     - Write an entry's ssh private key in the openssh format
+ rm  <name>      - Move an entry to the trash
+ trash ls        - List entries in the trash
+ trash restore <name> - Take an entry out of the trash
+ trash empty     - Delete the entries in the trash for good
  mv  <old> <new> - Rename an entry
- ls  [query]     - Lists entries, query restricts entries to a fuzzy match
+ ls  [query]     - Lists entries, query restricts entries to a fuzzy match (pinned first without one)
+ pin <query>     - Pin an entry so ls lists it first
+ unpin <query>   - Unpin an entry
+ lock <query>    - Lock an entry against changes and removal
+ unlock <query>  - Unlock an entry
+ recent [n]      - List the n entries used last on this machine (10 by default)
  cd  [query]     - "cd" into an entry, omit argument to return to root
  labels <lbl...> - List entries by labels (entry must have all given labels)
 
 Key commands (manage keys in entries, use "cd" command to omit query from these commands):
  show <query> [snapshot]    - Show all keys for an entry (optionally at a specific snapshot)
+ show --raw <query>         - Show notes as they're written instead of rendering their markdown
+ show --format=json <query> - Write an entry as json (or tsv) for other programs, ls and get take it too
+ history <query>            - List the changes to an entry by snapshot
+ diff <query> <snap> <snap> - Show the keys that changed between two snapshots
  set  <query> <key> [value] - Set a value on an entry (omit value for multi-line or password gen)
- get  <query> <key>         - Show a specific key of an entry
+ set  <query> <key> --multiline - Set a value using the multi-line editor (for any key)
+ set  <query> user|email --gen  - Generate a username or an alias of the aliasemail setting
+ set  <query> <key> ref:<name>/<key> - Link a key to another entry's key (eg. a shared password)
+ get  <query> <key>         - Show a specific key of an entry (--reveal for secret ones)
+ get  <query> passhist [index] - Show the passwords an entry had before
+ restorepass <query> <index> - Set the password back to an old one
  cp   <query> <key>         - Copy a specific key of an entry to the clipboard
  edit <query> <key>         - Open $EDITOR to edit an existing value
- open <query>               - Launch browser using value in url key
+ open <query> [n]           - Launch browser using value in url key (or urln)
+ match <url>                - List entries whose url, url2... match a url's host
  rmk  <query> <key>         - Delete a key from an entry
 
+ attach      <query> <path>        - Attach a file to an entry
+ attachments <query>               - List the files attached to an entry
+ extract     <query> <name> <path> - Write an attached file to path
+
+ protect   <query> <key>    - Require --force to set, edit or rmk a key (eg. set --force ...)
+ unprotect <query> <key>    - Remove the protection from a key
+ secret    <query> <key>    - Hide a key like the password, get needs --reveal
+ unsecret  <query> <key>    - Show a secret key again
+
  label   <query>            - Add labels in an easier way than with set
  rmlabel <query> <label>    - Remove labels in an easier way than with edit
 
 Clipboard copy shortcuts (alias of cp <query> <key>):
- pass  <query>       - Copy password to clipboard
- user  <query>       - Copy username to clipboard
- email <query>       - Copy email to clipboard
- totp  <query>       - Copy twofactor to clipboard
- login <query>       - Copy username, email, password and totp one after another
+ pass  <query>        - Copy password to clipboard
+ user  <query>        - Copy username to clipboard
+ email <query>        - Copy email to clipboard
+ totp  <query>        - Copy twofactor to clipboard
+ login <query>        - Copy username, email, password and totp one after another
+ fullname <query>     - Copy the full name of an identity to clipboard
+ phone <query>        - Copy the phone number of an identity to clipboard
+ address <query>      - Copy the address of an identity to clipboard
+ use recovery <query> - Copy the next unused recovery code and mark it used
 
 Other help topics (use help <topic>):
- sync, users, other
+ sync, users, keys, entries, templates, output, changes, other
 
 Common Arguments:
   name:   a fully qualified name
@@ -69,7 +129,121 @@ Additionally if the "sync" key of an entry is set to "true" then this entry
 will be automatically synchronized when an auto-sync occurs (usually
 when opening/closing the file, or running "sync" with no arguments)
 
-Types of sync: scp, file
+Conflicts that occur while merging are resolved by asking unless a policy is
+set with the "conflicts" key of the sync entry or the file's "conflicts"
+setting (see "config"). Policies are: interactive, prefer-newest, prefer-local
+and prefer-remote. Background syncs use prefer-newest instead of interactive.
+Files that share no history are never merged without asking.
+
+A key changed on both sides since they last synced keeps the newest value,
+except for the keys with a merge rule: notes and labels keep what either side
+added and lose what either removed, knownhosts keep every line. The
+"mergerules" setting changes them or adds others (eg. url=newest,history=append).
+
+The "direction" key of a sync entry limits it to "push" (a backup that's never
+merged from) or "pull" (a mirror that's never written to), the default is
+"both".
+
+The "schedule" key of a sync entry restricts when it's synced automatically
+(opening, saving, closing and background syncs), running "sync" by hand always
+syncs. It's a ; separated list of windows with a days and an hours field like
+cron, hour ranges end before the last hour:
+ schedule: mon-fri 9-17
+ schedule: sat,sun *; mon-fri 18-23
+
+Pulls and pushes that fail because of network trouble are retried with an
+increasing wait between attempts, see the "syncretries" and "syncbackoff"
+settings. A remote that stops responding is given up on after the
+"synctimeout" setting (default 1m). To keep syncing from saturating a slow
+or metered connection set the "syncratelimit" setting (KB/s).
+
+Entries labeled "nosync" are never pushed to remotes (not even encrypted),
+they stay in the local file only. The "exclude" key of a sync entry is a list
+of labels (eg. work,keys) whose entries are not pushed to that entry. Keys
+that start with "local." (eg. local.notes) are never pushed to any remote.
+
+When a remote was encrypted with a different passphrase you're asked for it
+and offered to have it remembered on this machine. It's kept in the sync
+entry's "local.remotepass" key, encrypted with the file's key, use rmk to
+forget it. Changing your passphrase also forgets it.
+
+Pushed files are signed with a key belonging to the machine that pushed them.
+Each machine's public key is kept in the "bpass/devices" entry and pulled
+files signed by a device that isn't in it are rejected unless you choose to
+trust it. Unsigned files (from older versions) are accepted unless the
+"requiresigned" setting is true. Remove a device from bpass/devices with rmk
+to stop trusting it.
+
+Each change is signed by the device that made it as well and chained to the
+one before it. A pulled file with a change that was altered, isn't signed by
+its device or is signed by a device that isn't trusted (or added by one that
+is) aborts the sync, as does a new unsigned change once the file has signed
+ones. Changes made before a machine had its key that weren't synced yet are
+signed when the key is created. With "requiresigned" any unsigned change
+does.
+
+Closing the file only syncs if something was changed unless the file's
+"synconsave" setting is true (see "config"), in which case every save and
+exit will sync.
+
+Types of sync: scp, file, http, https
+
+The http and https types sync with another machine running "bpass serve-sync",
+which keeps the files pushed to it in a directory and serves them back. Files
+are encrypted and signed the same as with scp, the server never decrypts
+them. The entry's "pass" key is the server's $BPASS_SYNC_TOKEN:
+ url: https://myserver:8484/filename.blob
+
+If the "tailscale" key of an scp entry is set to "true" the host is resolved
+using the tailscale cli (magic dns) when the tailnet is reachable, otherwise
+the host is dialed directly.
+
+Scp entries authenticate with the password in the url, the "privkey" key and
+any keys held by a running ssh-agent (SSH_AUTH_SOCK). For servers that require
+an openssh certificate put the certificate (the contents of the -cert.pub
+file signed for the privkey) in the "sshcert" key.
+
+Security keys (fido2/u2f) can hold the ssh key instead of the file, choose
+ED25519-SK in addsync (needs openssh 8.2+). Only the public key is stored in
+the "pubkey" key, the private key never leaves the security key and is used
+through the ssh-agent, load it with "ssh-add -K" before syncing.
+
+To reach a host through a bastion put it in the "jump" key of the scp entry
+as [user@]host[:port] (like ssh -J). The bastion's host key is verified and
+saved the same way. The bastion is logged into with the ssh-agent and the
+IdentityFile keys for it in ~/.ssh/config, never the entry's password or key,
+and its user defaults to the one in ~/.ssh/config and then the one in the url.
+
+Details left out of an scp url are taken from ~/.ssh/config when the url's host
+matches a Host block there (HostName, Port, User, IdentityFile and ProxyJump),
+so the url can be as short as scp://myalias/folder/filename.blob.
+
+Host keys of scp entries are saved in the "knownhosts" key the first time you
+accept them. If a known host's key changes you're shown the old and new
+fingerprints and can accept the new key by typing in its fingerprint, get it
+from the host's owner (ssh-keygen -lf on the host) and never from the
+connection that's being checked. Accepted rotations are recorded in the
+"hostkeyhistory" key of the entry.
+
+Set the "sshfp" key of an scp entry to "true" to check new host keys against
+the host's SSHFP records in dns first. Keys matching records that your
+resolver (the first nameserver in /etc/resolv.conf) validated with DNSSEC are
+saved without asking and keys contradicting them are refused, otherwise you're
+told what was found and asked as usual. Only use this with a resolver you
+trust, it's the one doing the DNSSEC validation.
+
+To connect to old or hardened servers set the algorithms offered to them as
+comma separated lists in order of preference, either for one scp entry with
+its "hostkeyalgorithms", "kexalgorithms", "ciphers" and "macs" keys or for all
+of them with the settings of the same names (see "config"). Lists that aren't
+set use the defaults, an entry's keys take precedence over the settings:
+ kexalgorithms: diffie-hellman-group14-sha1
+ ciphers: aes256-ctr,aes128-ctr
+
+If scp isn't in the remote's PATH set the "scppath" key of the scp entry to
+where it is. Remotes that can't run scp at all (sftp only accounts) are synced
+using sftp instead. When a push is interrupted and retried the retry continues
+from where it stopped using sftp if the remote has it.
 
 Example of values in an auto-sync scp account:
  url: scp://myuser@localhost.com:22/folder/filename.blob
@@ -79,6 +253,13 @@ Example of values in an auto-sync scp account:
 
 Sync Commands:
  sync    [name]  - Sync (Pull, Merge, Push) the file to all auto-sync accounts (or a given account)
+ sync auto <on|off> [minutes] - Sync in the background on an interval while the repl is open
+ sync status [name] - Show if local is ahead, behind or diverged from each remote (changes nothing)
+ sync log [n]    - Show the last n pulls and pushes made from this machine
+ sync ls         - List sync entries and when they were last synced
+ sync rm <name>  - Delete a sync entry
+ sync init <name> - Push the file to a new remote, creating its directories
+ sync test <name> - Check a sync entry can be reached and its file opened (no merge)
  addsync <kind>  - Sync entry setup wizard (help sync for more details)
 `
 
@@ -95,11 +276,272 @@ from those user entries are unremarkable and can be renamed with mv or deleted
 with rm. (Remember when renaming to keep the user/ prefix intact or the user
 will no longer be considered a user).
 
+Typing a long passphrase every time the file is opened can be avoided by
+setting "keycache" to a duration, eg. 15m (see "config"). After the passphrase
+is typed the key it derives (never the passphrase) is kept that long in the
+macOS keychain, encrypted with DPAPI on Windows, or in the kernel keyring on
+Linux. Reopening the file before it expires uses the key without asking for the
+passphrase or second factor. Start bpass with --no-keycache to ignore and forget
+it.
+
+A recovery user (adduser --recovery) opens the file with a generated code
+instead of a passphrase, so it can be kept somewhere safe (printed, in a safe
+deposit box) to open the file if everyone's passphrase is lost. passwd --split
+adds one whose code is split into n shares (printed, and as qr codes when
+qrencode is installed) so that any k of them open the file as that user when
+they're entered at the passphrase prompt, it's named recovery unless given a
+name. A fido2 user (adduser --fido2) opens the file with only the fido2
+authenticator that was plugged in, add one for each authenticator to be able
+to use any of them.
+
 User/Password Commands:
  adduser <user> - Add user to the file (first add should use current user's username)
- passwd  [user] - Change the file's password for current user, or a specific user
+ adduser --recovery <user> - Add a user that opens the file with a generated recovery code
+ adduser --fido2 <user>    - Add a user that opens the file with only a fido2 authenticator
+ passwd  [--tune] [user] - Change the file's password for current user, or a specific user
+ passwd  --split <n> <k> [user] - Add a recovery user whose code is split into n shares, k open the file
  rekey   [user] - Rekey the file (change salt) for current user, or a specific user
  rekeyall       - Nuclear button, change all passwords & master key for all users
+
+Second factors, keyfiles and the other passphrases are in "help keys".
+`
+
+var keysHelp = `The key that opens the file is derived from the passphrase and can be made to
+need more than it.
+
+passwd changes the passphrase of the current user, or of a user of a
+multi-user file. --tune first measures this machine and sets the kdf setting
+so deriving the key takes about a second. Second factors make the key need
+something as well as the passphrase:
+
+ --add-yubikey   - a yubikey's hmac-sha1 challenge-response (slot 2, or
+                   $BPASS_YUBIKEY_SLOT)
+ --add-fido2     - a fido2 authenticator's hmac-secret
+ --add-smartcard - a signature from an rsa key on a piv smartcard or pkcs#11
+                   token through pkcs11-tool, which asks for the pin. The key
+                   is $BPASS_PKCS11_ID, 03 (piv slot 9d) by default, and
+                   $BPASS_PKCS11_MODULE picks a module other than opensc's.
+
+The passphrase may then be left empty to open the file with only the token,
+--remove-factor stops needing it.
+
+A keyfile (eg. on a usb stick) is much the same: keyfile new writes a random
+one, keyfile add makes your key need it and keyfile rm stops needing it. Open
+the file with --keyfile or $BPASS_KEYFILE, or you'll be asked for it.
+
+tpm on binds your key to enrolled devices so the file can't be opened
+anywhere else even with the passphrase, the key needs a secret sealed to the
+device's tpm (with tpm2-tools). tpm code prints a code that enrolls another
+device when it's entered while opening the file there, unenroll forgets this
+device's sealed secret, reset changes the secret so every other device has to
+be enrolled again and off stops needing the tpm.
+
+Every single-user chacha20poly1305 file has a second slot that's filler unless
+it holds a duress or a hidden passphrase, without the passphrase they can't be
+told apart. A duress passphrase opens a separate, empty decoy file instead of
+this one (open it with the duress passphrase to fill it with things worth
+finding). A hidden passphrase opens a hidden file so things can be kept where
+it can't be proven they exist from the file alone, fill it and hand out this
+one's passphrase. There is only the one slot so a file has a duress or a
+hidden passphrase, never both, and setting one replaces the other (set asks
+first). set switches the file to chacha20poly1305 if needed and rm puts filler
+back. Opening a file derives a key for both slots so it takes twice as long.
+Adding a user or changing the cipher setting to cascade drops the slot.
+
+rekey changes the salt of the current user, or a given user. --master also
+changes the key the file's contents are encrypted with (the master key in a
+multi-user file) while keeping your passphrase, so an old copy of the file and
+its passphrase can't be used to open new ones. The other users of a
+multi-user file are given new passphrases. Rekeys are recorded in the file and
+--history shows them.
+
+Key Commands:
+ passwd  --tune         - Make deriving the key take about a second on this machine
+ passwd  --add-yubikey  - Make opening the file need your yubikey too (--remove-factor to undo)
+ passwd  --add-fido2    - Make opening the file need a fido2 authenticator too
+ passwd  --add-smartcard - Make opening the file need an rsa key on a piv/pkcs#11 smartcard too
+ keyfile new|add <path> - Create a keyfile or make opening the file need it too
+ keyfile rm             - Stop needing a keyfile
+ tpm     on|off         - Make opening the file need this device's tpm (enrolled devices only)
+ tpm     code|unenroll|reset - Enroll another device, de-enroll this one, or de-enroll all others
+ duress  set|rm         - Set a passphrase that opens a decoy file instead (single-user files only)
+ hidden  set|rm         - Set a passphrase that opens a hidden file instead (uses duress's slot)
+ rekey   --master  - Change the key the file is encrypted with, keeping your passphrase
+ rekey   --history - Show when the file was rekeyed
+`
+
+var templatesHelp = `Templates are the keys a kind of entry should have, like the host, port,
+user and generated password of a database login.
+
+A template is an entry named template/<name>, each of its keys is a key the
+entries made from it get and the value is the key's type optionally followed
+by a default. The types are string, secret, url, date, int and multiline. A
+secret's default can be "gen" to generate it without asking, with the password
+generator's settings after a colon:
+
+  host  string
+  port  int 5432
+  pass  secret gen:length=24,extra=off
+  notes multiline
+
+The settings are length, upper, lower, number, basic and extra. Each character
+class is "off", "any" or how many there must be at least, the ones left out
+are what the password generator starts with.
+
+"add <name> --template <name>" asks for each of the template's keys, leaving
+one empty uses its default. Plain "add" asks which template to use when there
+are any.
+
+Template Commands:
+ template ls         - List templates and their keys
+ template add <name> - Add a template, asking for each key's type and default
+ add <name> --template <tpl> - Add a new entry from a template
+`
+
+var entriesHelp = `Entries are a name and keys with values, the kind of entry decides what keys
+it has and what they must look like.
+
+set checks the keys it knows are of their type: url must be a url with a
+scheme, expires a date like 2006-01-02 and user and email one line. Omit the
+value to be prompted (multi-line input, or password generation for pass),
+--multiline forces the multi-line editor for any key. --gen generates a user
+(a random word pair like brisk_otter42) or an email alias
+(me+github@example.com from the aliasemail setting, see config). Protected
+keys require --force. Secret keys are hidden by show, history and diff like
+the password and get only prints them with --reveal, cp copies them as usual.
+
+A value of ref:<name>/<key> links the key to another entry's key, get, cp and
+show use the value it links to so a shared credential only has to be changed
+in one place. Renaming the entry keeps its links working.
+
+icon is an emoji (or other short text) that ls and the cd prompt show in front
+of the entry's name and color the color they show the name in: red, green,
+yellow, blue, magenta, cyan, white, grey or a bright one like brightred.
+
+Set expires to be reminded to rotate a password, due lists the entries past it
+or in the next 30 days (or the given number of days) oldest first. Expired
+entries are also pointed out when the file is opened and by audit.
+
+Entries may have more urls in url2, url3 and so on, open <query> n opens one
+of them (url is 1) and without n the first url that isn't a host pattern. match
+uses them as host patterns: *.example.com matches example.com and its
+subdomains and login.example.* uses shell wildcards.
+
+totp copies the current code of the twofactor key using the algorithm
+(SHA1, SHA256, SHA512), digits and period of its otpauth:// uri, SHA1, 6 digits
+and 30 seconds when it has none. The recovery key holds an account's recovery
+codes one per line (set <query> recovery --multiline), use recovery copies the
+next one and moves it to recoveryused with the time it was used so it's never
+handed out again.
+
+Files (eg. an ssh key or a recovery pdf) are attached under their file name,
+attaching one with the same name replaces it. They're read and stored in
+chunks and can be up to 10 MiB, rmk <query> attachment.<name> removes one.
+extract writes one only readable by you a chunk at a time and only replaces
+path once it's checked against the checksum it was attached with.
+
+Kinds of entries:
+ add         - email, user and password, or a template's keys (see "help templates")
+ addcert     - a pem encoded certificate, private key and chain. export cert
+               writes them to files, the key only readable by you.
+ addcard     - number, expiry, cvv, cardholder and pin. The number must have a
+               valid check digit and the expiry be like 01/28. show masks the
+               number and hides the cvv and pin, cp <query> number copies the
+               number without spaces.
+ addidentity - full name, birthdate, email, phone, address, passport and id
+               numbers and when they expire (see due). show gives the age of
+               the birthdate and hides the numbers, fullname, phone and
+               address copy them.
+ addwifi     - ssid, security (WPA covers WPA2, WPA3, WEP or none for open
+               networks) and passphrase. show hides the passphrase and qr
+               shows the code phones scan with their camera to join.
+ addnote     - a markdown body and tags (labels, separated by commas) for
+               keeping documents. show lists the tags and attachments and then
+               the body, edit <query> notes changes the body.
+ addpasskey  - relying party id, username, credential id and user handle
+               (base64url), signature counter and the ecdsa P-256 or ed25519
+               private key as pem. show only gives the type of the key, get
+               <query> privkey prints it.
+
+sshkey gen puts an ssh keypair (ed25519 by default, rsa is 4096 bits) in any
+entry's privkey and pubkey keys like addsync does, replacing the ones it has
+after asking. get <query> pub shows the public key for authorized_keys and show
+its fingerprint. sshkey export writes the private key in the openssh format ssh
+and ssh-keygen use, only readable by you.
+`
+
+var outputHelp = `ls, show and get write entries for reading them in the terminal unless
+they're given a --format, bpass --format sets it for the session.
+
+Notes are rendered as markdown (headings, lists, quotes, code, links and bold
+text) by show and get, --raw shows them as they're written. show masks
+passwords, secret keys and the like and get only prints secret keys with
+--reveal.
+
+--format=json writes:
+ ls   - an array of objects with the uuid, name, kind, labels and whether
+        they're pinned
+ show - an object with the uuid, name, values (by key, as they're written) and
+        attachments
+ get  - an object with the entry's name, the key and the value (passhist
+        without an index is an array of when each was replaced and the
+        password)
+
+--format=tsv writes:
+ ls   - lines of the uuid, name, kind and labels separated by tabs
+ show - lines of a key and its value separated by a tab
+ get  - the value on a line
+
+Tabs, newlines and backslashes in tsv values are escaped like \t. ls sorts by
+name in both (without a query it lists pinned entries first otherwise) and
+show masks what it hides unless --reveal is given.
+`
+
+var changesHelp = `Every change to the file is kept in its log, which is what show <query>
+[snapshot], history, diff, undo and opening with --time use.
+
+undo reverts the changes made by the last n commands that changed entries, the
+changes it makes are kept in the history like any other. rm moves entries to
+the trash, they're deleted for good after the trashdays setting (30 by
+default) or by trash empty.
+
+get <query> passhist lists the passwords an entry had before newest first,
+with an index it shows one of them. restorepass sets the password back to one
+of them, the password it replaces is kept in the history like any other. The
+passhistory setting is how many old passwords are kept (10 by default).
+
+compact squashes the history from before days ago (all of it by default) into
+the fewest transactions that keep the current values and deletions. Old values
+and the keys of deleted entries are gone for good, as is the history that
+show <query> [snapshot], rekey --history and opening with --time use. A
+years-old file's log slows down every command and sync, this shrinks it. It
+records a checkpoint, when the history was pruned to with a hash of the entries
+it made, as a change that's signed and synced like any other. Compacting has
+to leave the entries the same as the hash or the log is left alone. When
+syncing with copies that still have the history it's merged (along with any
+changes made in them) and compacted again up to the checkpoint. Set
+compactlength or compactdays (see config) to compact automatically on save.
+
+stats shows what takes up the file: its size on disk, how many entries there
+are, how big their values are now and with their history in the log, how many
+attachments there are and how big, and the largest entries.
+
+log export writes the log as a line of json for each change with its time,
+kind (add, del, setk or delk), uuid, the entry's name then, the key and the
+device that made it so other tools can audit who changed what and when. Values
+are never written. The file is only readable by you, - writes to the terminal.
+devices lists the devices that made changes, named by the trusted devices that
+sign synced files. recent lists the entries used last on this machine, up to
+50 are remembered outside the file so using one doesn't change it. Each
+passphrase has its own list, a duress or hidden one doesn't show the other's.
+
+export age writes the whole file (its history included) encrypted to age
+recipients so it can be handed to someone or backed up and opened with age.
+Like a push it leaves out local keys and nosync entries. Each recipient is an
+age1 public key or a file of age1 or ssh public keys like age -R takes. import
+age merges such a file into this one with an age identity file (from
+age-keygen) or an unencrypted ssh private key, conflicts are handled like
+syncing.
 `
 
 var otherHelp = `Debug commands:
@@ -110,7 +552,6 @@ var otherHelp = `Debug commands:
 const (
 	mainPromptColor = color.FgBrightBlue
 	normalPrompt    = "(%s)> "
-	dirPrompt       = "(%s):%s> "
 )
 
 var (
@@ -122,14 +563,22 @@ type repl struct {
 
 	prompt   string
 	ctxEntry string
+
+	// line is the line the current command was read from
+	line string
 }
 
 func (r *repl) run() error {
 	r.prompt = mainPromptColor.Sprintf(normalPrompt, r.ctx.shortFilename)
 	r.ctxEntry = ""
 
+	if flagAutoSync > 0 && !r.ctx.readOnly {
+		r.ctx.startAutoSync(flagAutoSync)
+	}
+	defer r.ctx.stopAutoSync(true)
+
 	for {
-		line, err := r.ctx.in.Line(r.prompt)
+		line, err := r.ctx.in.LineBlock(r.prompt)
 		switch err {
 		case ErrInterrupt:
 			return err
@@ -158,56 +607,241 @@ func (r *repl) run() error {
 
 		replCommand, ok := replCmds[cmd]
 		if !ok {
-			fmt.Println(`unknown command, try "help"`)
-			continue
-		}
-
-		if r.ctx.readOnly && !replCommand.ReadOnly {
-			errColor.Println("cannot use write commands in read-only mode")
+			if suggestion := didYouMean(cmd); len(suggestion) != 0 {
+				fmt.Println("unknown command" + suggestion)
+			} else {
+				fmt.Println(`unknown command, try "help"`)
+			}
 			continue
 		}
 
-		err = replCommand.Run(r, cmd, args)
+		r.line = line
+		err = replCommand.run(r, cmd, args)
 		if err == errExit {
 			return nil
 		} else if err != nil {
 			return err
 		}
-
-		r.ctx.in.AddHistory(line)
 	}
 }
 
+// replFunc runs a command, args are everything following the command name
+type replFunc func(r *repl, cmd string, args []string) error
+
 type replCmd struct {
+	// ReadOnly commands may be used in read-only mode
 	ReadOnly bool
-	Run      func(r *repl, cmd string, args []string) error
+	Run      replFunc
+
+	// Entry means the first argument is an entry query, it's filled in with
+	// the entry we're cd'd into when there is one. Flags are the flags that
+	// may come before it and MinArgs is the number of arguments required
+	// (including the entry but not the flags).
+	Entry   bool
+	Flags   []string
+	MinArgs int
+
+	// Destructive commands ask for confirmation (showing Warning) and are
+	// not added to the history
+	Destructive bool
+	Warning     string
+
+	// Undoable commands' changes to the file can be reverted with undo
+	Undoable bool
+
+	// Usage is the syntax of the command, Desc a short description of what
+	// it does and Examples are full command lines, these are used by help
+	Usage    string
+	Desc     string
+	Examples []string
 }
 
 var replCmds = map[string]replCmd{
 	"passwd": {
+		Usage:    "passwd [--tune] [--add-yubikey | --add-fido2 | --add-smartcard | --remove-factor] [user] | passwd --split <n> <k> [user]",
+		Desc:     "Change the passphrase or second factors of you or a user, see \"help keys\".",
+		Examples: []string{"passwd", "passwd alice", "passwd --tune", "passwd --add-yubikey", "passwd --add-fido2", "passwd --add-smartcard", "passwd --split 5 3"},
+		Flags:    []string{"--tune", "--add-yubikey", "--add-fido2", "--add-smartcard", "--remove-factor", "--split"},
 		Run: func(r *repl, cmd string, args []string) error {
+			if len(args) > 0 && args[0] == "--split" {
+				if len(args) < 3 || len(args) > 4 {
+					errColor.Println("syntax: passwd --split <n> <k> [user]")
+					return nil
+				}
+				n, errN := strconv.Atoi(args[1])
+				k, errK := strconv.Atoi(args[2])
+				if errN != nil || errK != nil {
+					errColor.Println("n and k must be numbers")
+					return nil
+				}
+
+				user := defaultShareUser
+				if len(args) == 4 {
+					user = args[3]
+				}
+				return r.ctx.splitRecovery(user, n, k)
+			}
+
+			tune := false
+			factor := keepFactor
+			for n := countFlags(args, []string{"--tune", "--add-yubikey", "--add-fido2", "--add-smartcard", "--remove-factor"}); n > 0; n-- {
+				switch args[0] {
+				case "--tune":
+					tune = true
+				case "--add-yubikey":
+					factor = int(crypt.FactorYubikey)
+				case "--add-fido2":
+					factor = int(crypt.FactorFIDO2)
+				case "--add-smartcard":
+					factor = int(crypt.FactorSmartcard)
+				case "--remove-factor":
+					factor = int(crypt.FactorNone)
+				}
+				args = args[1:]
+			}
+
+			if tune {
+				if err := r.ctx.tuneKDF(); err != nil {
+					return err
+				}
+			}
+
 			var user string
 			if len(args) > 0 {
 				user = args[0]
 			}
 
-			return r.ctx.passwd(user)
+			return r.ctx.passwd(user, factor)
 		},
 	},
 
 	"adduser": {
+		Usage:    "adduser [--recovery | --fido2] <user>",
+		Desc:     "Add a user, the first makes this a multi-user file, see \"help users\".",
+		Examples: []string{"adduser me", "adduser alice", "adduser --recovery recovery", "adduser --fido2 me-backupkey"},
+		Flags:    []string{"--recovery", "--fido2"},
+		MinArgs:  1,
 		Run: func(r *repl, _ string, args []string) error {
-			if len(args) == 0 {
-				errColor.Println("syntax: adduser <user>")
+			kind := addUserPassphrase
+			switch args[0] {
+			case "--recovery":
+				kind = addUserRecovery
+				args = args[1:]
+			case "--fido2":
+				kind = addUserFIDO2
+				args = args[1:]
+			}
+
+			return r.ctx.adduser(args[0], kind)
+		},
+	},
+
+	"keyfile": {
+		Usage:    "keyfile new <path> | keyfile add <path> | keyfile rm",
+		Desc:     "Make opening the file need a keyfile too, see \"help keys\".",
+		Examples: []string{"keyfile new /media/usb/bpass.key", "keyfile add /media/usb/bpass.key", "keyfile rm"},
+		MinArgs:  1,
+		Run: func(r *repl, _ string, args []string) error {
+			switch {
+			case args[0] == "new" && len(args) == 2:
+				if err := newKeyfile(args[1]); err != nil {
+					errColor.Println("failed to create keyfile:", err)
+					return nil
+				}
+				infoColor.Println("created keyfile", args[1])
 				return nil
+			case args[0] == "add" && len(args) == 2:
+				return r.ctx.keyfileAdd(args[1])
+			case args[0] == "rm" && len(args) == 1:
+				return r.ctx.keyfileRemove()
+			}
+
+			errColor.Println("syntax: keyfile new <path> | keyfile add <path> | keyfile rm")
+			return nil
+		},
+	},
+
+	"duress": {
+		Usage:    "duress set | duress rm",
+		Desc:     "Set a passphrase that opens a decoy file instead, see \"help keys\".",
+		Examples: []string{"duress set", "duress rm"},
+		MinArgs:  1,
+		Run: func(r *repl, _ string, args []string) error {
+			if len(args) == 1 {
+				switch args[0] {
+				case "set":
+					return r.ctx.duressSet("duress")
+				case "rm":
+					return r.ctx.duressRemove("duress")
+				}
+			}
+
+			errColor.Println("syntax: duress set | duress rm")
+			return nil
+		},
+	},
+
+	"hidden": {
+		Usage:    "hidden set | hidden rm",
+		Desc:     "Set a passphrase that opens a hidden file instead, see \"help keys\".",
+		Examples: []string{"hidden set", "hidden rm"},
+		MinArgs:  1,
+		Run: func(r *repl, _ string, args []string) error {
+			if len(args) == 1 {
+				switch args[0] {
+				case "set":
+					return r.ctx.duressSet("hidden")
+				case "rm":
+					return r.ctx.duressRemove("hidden")
+				}
+			}
+
+			errColor.Println("syntax: hidden set | hidden rm")
+			return nil
+		},
+	},
+
+	"tpm": {
+		Usage:    "tpm on | tpm off | tpm code | tpm unenroll | tpm reset",
+		Desc:     "Make opening the file need an enrolled device's tpm, see \"help keys\".",
+		Examples: []string{"tpm on", "tpm code", "tpm unenroll", "tpm reset", "tpm off"},
+		MinArgs:  1,
+		Run: func(r *repl, _ string, args []string) error {
+			if len(args) == 1 {
+				switch args[0] {
+				case "on":
+					return r.ctx.tpmOn()
+				case "off":
+					return r.ctx.tpmOff()
+				case "code":
+					return r.ctx.tpmCode()
+				case "unenroll":
+					return r.ctx.tpmUnenroll()
+				case "reset":
+					return r.ctx.tpmReset()
+				}
 			}
 
-			return r.ctx.adduser(args[0])
+			errColor.Println("syntax: tpm on | tpm off | tpm code | tpm unenroll | tpm reset")
+			return nil
 		},
 	},
 
 	"rekey": {
+		Usage:    "rekey [user] | rekey --master | rekey --history",
+		Desc:     "Change the salt, or with --master the key, of the file, see \"help keys\".",
+		Examples: []string{"rekey", "rekey alice", "rekey --master", "rekey --history"},
+		Flags:    []string{"--master", "--history"},
 		Run: func(r *repl, _ string, args []string) error {
+			if len(args) > 0 {
+				switch args[0] {
+				case "--master":
+					return r.ctx.rekeyMaster()
+				case "--history":
+					return r.ctx.rekeyHistory()
+				}
+			}
+
 			var user string
 			if len(args) > 0 {
 				user = args[0]
@@ -218,40 +852,294 @@ var replCmds = map[string]replCmd{
 	},
 
 	"rekeyall": {
+		Usage:       "rekeyall",
+		Desc:        "Change all passwords and the master key, every user gets a new password.",
+		Destructive: true,
+		Warning:     rekeyAllBlurb,
 		Run: func(r *repl, _ string, args []string) error {
-			return r.ctx.rekeyAll()
+			return r.ctx.rekeyAll(false)
 		},
 	},
 
 	"add": {
+		Usage:    "add <name> [--template <template>]",
+		Desc:     "Add a new entry, prompting for its keys, see \"help templates\".",
+		Examples: []string{"add github", "add work/vpn", "add work/db --template database"},
+		Flags:    []string{"--template"},
+		MinArgs:  1,
+		Undoable: true,
 		Run: func(r *repl, _ string, args []string) error {
-			if len(args) < 1 {
-				errColor.Println("syntax: add <name>")
+			var template string
+			if len(args) == 3 && args[1] == "--template" {
+				template = args[2]
+			} else if len(args) != 1 {
+				errColor.Println("syntax: add <name> [--template <template>]")
 				return nil
 			}
-			return r.ctx.addNewInterruptible(args[0])
+
+			return r.ctx.addNewInterruptible(args[0], template)
 		},
 	},
 
-	"mv": {
+	"template": {
+		Usage:    "template ls | template add <name>",
+		Desc:     "List templates or add one, see \"help templates\".",
+		Examples: []string{"template ls", "template add database"},
+		MinArgs:  1,
+		Undoable: true,
 		Run: func(r *repl, _ string, args []string) error {
-			if len(args) < 2 {
-				errColor.Println("syntax: mv <old> <new>")
+			switch {
+			case args[0] == "ls" && len(args) == 1:
+				return r.ctx.templateList()
+			case args[0] == "add" && len(args) == 2:
+				err := r.ctx.templateAdd(args[1])
+				if err == ErrEnd {
+					errColor.Println("Aborted")
+					return nil
+				}
+				return err
+			}
+
+			errColor.Println("syntax: template ls | template add <name>")
+			return nil
+		},
+	},
+
+	"addcert": {
+		Usage:    "addcert <name>",
+		Desc:     "Add a new certificate entry, prompts for the pem encoded certificate, private key and chain.",
+		Examples: []string{"addcert tls/example.com"},
+		MinArgs:  1,
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.addCertInterruptible(args[0])
+		},
+	},
+
+	"addcard": {
+		Usage:    "addcard <name>",
+		Desc:     "Add a new payment card entry, see \"help entries\".",
+		Examples: []string{"addcard cards/visa", "cp cards/visa number"},
+		MinArgs:  1,
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.addCard(args[0])
+		},
+	},
+
+	"addidentity": {
+		Usage:    "addidentity <name>",
+		Desc:     "Add a new identity entry for filling in forms, see \"help entries\".",
+		Examples: []string{"addidentity me", "phone me"},
+		MinArgs:  1,
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.addIdentity(args[0])
+		},
+	},
+
+	"addwifi": {
+		Usage:    "addwifi <name>",
+		Desc:     "Add a new wifi entry, see \"help entries\".",
+		Examples: []string{"addwifi wifi/home", "qr wifi/home"},
+		MinArgs:  1,
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.addWifi(args[0])
+		},
+	},
+
+	"addnote": {
+		Usage:    "addnote <name>",
+		Desc:     "Add a new note entry with a markdown body and tags, see \"help entries\".",
+		Examples: []string{"addnote notes/will", "attach notes/will ~/Documents/will.pdf"},
+		MinArgs:  1,
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.addNote(args[0])
+		},
+	},
+
+	"addpasskey": {
+		Usage:    "addpasskey <name>",
+		Desc:     "Add a new passkey (webauthn credential) entry, see \"help entries\".",
+		Examples: []string{"addpasskey passkeys/github"},
+		MinArgs:  1,
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.addPasskey(args[0])
+		},
+	},
+
+	"qr": {
+		Usage:    "qr <query>",
+		Desc:     "Show the qr code phones scan to join a wifi entry's network.",
+		Examples: []string{"qr wifi/home"},
+		ReadOnly: true,
+		Entry:    true,
+		MinArgs:  1,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.showQR(args[0])
+		},
+	},
+
+	"sshkey": {
+		Usage:    "sshkey gen <query> [ed25519|rsa] | sshkey export <query> <file>",
+		Desc:     "Generate an ssh keypair for an entry or export it, see \"help entries\".",
+		Examples: []string{"sshkey gen servers/web", "sshkey gen legacy/router rsa", "get servers/web pub", "sshkey export servers/web ~/.ssh/id_web"},
+		MinArgs:  2,
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			switch {
+			case args[0] == "gen" && len(args) <= 3:
+				kind := sshKeyED25519
+				if len(args) == 3 {
+					kind = args[2]
+				}
+				return r.ctx.sshKeyGen(args[1], kind)
+			case args[0] == "export" && len(args) == 3:
+				return r.ctx.sshKeyExport(args[1], args[2])
+			}
+
+			errColor.Println("syntax: sshkey gen <query> [ed25519|rsa] | sshkey export <query> <file>")
+			return nil
+		},
+	},
+
+	"audit": {
+		Usage:    "audit",
+		Desc:     "Report on entries that need attention, like expired certificates.",
+		ReadOnly: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.audit()
+		},
+	},
+
+	"due": {
+		Usage:    "due [days]",
+		Desc:     "List the entries past their expires date or due in 30 (or n) days.",
+		Examples: []string{"due", "due 90", "set work/ldap expires 2027-01-31"},
+		ReadOnly: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			if len(args) > 1 {
+				errColor.Println("syntax: due [days]")
 				return nil
 			}
 
+			var days string
+			if len(args) == 1 {
+				days = args[0]
+			}
+			return r.ctx.due(days)
+		},
+	},
+
+	"devices": {
+		Usage:    "devices",
+		Desc:     "List the devices that changed the file, how often and when last.",
+		ReadOnly: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.devices()
+		},
+	},
+
+	"stats": {
+		Usage:    "stats",
+		Desc:     "Show the size of the file, its entries, history and attachments.",
+		ReadOnly: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.stats()
+		},
+	},
+
+	"log": {
+		Usage:    "log export <file>",
+		Desc:     "Write who changed what and when (no values) as json lines, see \"help changes\".",
+		Examples: []string{"log export audit.jsonl", "log export - | jq 'select(.key == \"pass\")'"},
+		ReadOnly: true,
+		MinArgs:  2,
+		Run: func(r *repl, cmd string, args []string) error {
+			if args[0] != "export" || len(args) != 2 {
+				errColor.Println("syntax: log export <file>")
+				return nil
+			}
+
+			return r.ctx.logExport(args[1])
+		},
+	},
+
+	"export": {
+		Usage:    "export cert <query> [--dir dir] | export age <file> <recipient...>",
+		Desc:     "Write a cert entry to files or the file encrypted to age recipients.",
+		Examples: []string{"export cert example.com --dir /etc/ssl/private", "export age backup.age age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p", "export age backup.age ~/.ssh/id_ed25519.pub"},
+		ReadOnly: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			syntaxErr := func() error {
+				errColor.Println("syntax: export cert <query> [--dir dir] | export age <file> <recipient...>")
+				return nil
+			}
+
+			if len(args) >= 3 && args[0] == "age" {
+				return r.ctx.ageExport(args[1], args[2:])
+			}
+			if len(args) == 0 || args[0] != "cert" {
+				return syntaxErr()
+			}
+			args = args[1:]
+
+			dir := "."
+			if len(args) >= 2 && args[len(args)-2] == "--dir" {
+				dir = args[len(args)-1]
+				args = args[:len(args)-2]
+			}
+
+			name := r.ctxEntry
+			if len(args) != 0 {
+				name = args[0]
+			}
+			if len(name) == 0 {
+				return syntaxErr()
+			}
+
+			return r.ctx.exportCert(name, dir)
+		},
+	},
+
+	"import": {
+		Usage:    "import age <file> <identity>",
+		Desc:     "Merge a file written by export age into this one, see \"help changes\".",
+		Examples: []string{"import age backup.age ~/.config/age/key.txt"},
+		MinArgs:  3,
+		Run: func(r *repl, cmd string, args []string) error {
+			if args[0] != "age" {
+				errColor.Println("syntax: import age <file> <identity>")
+				return nil
+			}
+
+			return r.ctx.ageImport(args[1], args[2])
+		},
+	},
+
+	"mv": {
+		Usage:    "mv <old> <new>",
+		Desc:     "Rename an entry.",
+		Examples: []string{"mv github work/github"},
+		MinArgs:  2,
+		Undoable: true,
+		Run: func(r *repl, _ string, args []string) error {
 			return r.ctx.rename(args[0], args[1])
 		},
 	},
 
 	"rm": {
+		Usage:    "rm <name>",
+		Desc:     "Move an entry to the trash (see trash), users and sync entries are deleted.",
+		Examples: []string{"rm github"},
+		MinArgs:  1,
+		Undoable: true,
 		Run: func(r *repl, _ string, args []string) error {
-			if len(args) < 1 {
-				errColor.Println("syntax: rm <name>")
-				return nil
-			}
 			name := args[0]
-			err := r.ctx.deleteEntry(name)
+			err := r.ctx.trashEntry(name)
 
 			if err == nil && r.ctxEntry == name {
 				r.ctxEntry = ""
@@ -262,35 +1150,178 @@ var replCmds = map[string]replCmd{
 		},
 	},
 
+	"trash": {
+		Usage:    "trash ls | trash restore <name> | trash empty",
+		Desc:     "List the entries in the trash, restore one or empty it for good.",
+		Examples: []string{"trash ls", "trash restore github", "trash empty"},
+		MinArgs:  1,
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			switch {
+			case args[0] == "ls":
+				return r.ctx.trashList()
+			case args[0] == "restore" && len(args) == 2:
+				return r.ctx.trashRestore(args[1])
+			case args[0] == "empty":
+				return r.ctx.trashEmpty()
+			}
+
+			errColor.Println("syntax: trash ls | trash restore <name> | trash empty")
+			return nil
+		},
+	},
+
 	"rmk": {
+		Usage:    "rmk [--force] <query> <key>",
+		Desc:     "Delete a key from an entry, protected keys require --force.",
+		Examples: []string{"rmk github notes"},
+		Entry:    true,
+		Flags:    []string{"--force"},
+		MinArgs:  2,
+		Undoable: true,
 		Run: func(r *repl, _ string, args []string) error {
-			name := r.ctxEntry
-			if len(args) < 1 || (len(name) == 0 && len(args) < 2) {
-				errColor.Println("syntax: rmk <query> <key>")
-				return nil
-			}
+			args, force := parseForce(args)
+			return r.ctx.deleteKey(args[0], args[1], force)
+		},
+	},
 
-			if len(name) == 0 {
-				name = args[0]
-				args = args[1:]
-			}
+	"protect": {
+		Usage:    "protect <query> <key>",
+		Desc:     "Protect a key so that set, edit and rmk require --force to change it.",
+		Examples: []string{"protect github pass"},
+		Entry:    true,
+		MinArgs:  2,
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			return protectCmd(r, cmd, args, true)
+		},
+	},
 
-			return r.ctx.deleteKey(name, args[0])
+	"unprotect": {
+		Usage:    "unprotect <query> <key>",
+		Desc:     "Remove the protection from a key.",
+		Examples: []string{"unprotect github pass"},
+		Entry:    true,
+		MinArgs:  2,
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			return protectCmd(r, cmd, args, false)
+		},
+	},
+
+	"secret": {
+		Usage:    "secret <query> <key>",
+		Desc:     "Hide a key like the password, get only prints it with --reveal.",
+		Examples: []string{"secret bank security-answer"},
+		Entry:    true,
+		MinArgs:  2,
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.markSecret(args[0], args[1], true)
+		},
+	},
+
+	"unsecret": {
+		Usage:    "unsecret <query> <key>",
+		Desc:     "Remove the secret mark from a key.",
+		Examples: []string{"unsecret bank security-answer"},
+		Entry:    true,
+		MinArgs:  2,
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.markSecret(args[0], args[1], false)
 		},
 	},
 
 	"ls": {
+		Usage:    "ls [--format=json|tsv] [query]",
+		Desc:     "List entries, the query restricts them to a fuzzy match, see \"help output\".",
+		Examples: []string{"ls", "ls work/", "ls --format=json work/"},
 		ReadOnly: true,
+		Flags:    formatFlags,
 		Run: func(r *repl, _ string, args []string) error {
+			args, format := parseFormat(args, formatFlags, r.ctx.format)
 			query := ""
 			if len(args) != 0 {
 				query = args[0]
 			}
+			if len(format) != 0 {
+				return r.ctx.listAs(query, format)
+			}
 			return r.ctx.list(query)
 		},
 	},
 
+	"pin": {
+		Usage:    "pin <query>",
+		Desc:     "Pin an entry so that ls without a query lists it first.",
+		Examples: []string{"pin github"},
+		Entry:    true,
+		MinArgs:  1,
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.pin(args[0], true)
+		},
+	},
+
+	"unpin": {
+		Usage:    "unpin <query>",
+		Desc:     "Unpin an entry.",
+		Examples: []string{"unpin github"},
+		Entry:    true,
+		MinArgs:  1,
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.pin(args[0], false)
+		},
+	},
+
+	"lock": {
+		Usage:    "lock <query>",
+		Desc:     "Lock an entry so that it can't be changed or removed until it's unlocked.",
+		Examples: []string{"lock bank"},
+		Entry:    true,
+		MinArgs:  1,
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.lockEntry(args[0], true)
+		},
+	},
+
+	"unlock": {
+		Usage:    "unlock <query>",
+		Desc:     "Unlock a locked entry so that it can be changed again.",
+		Examples: []string{"unlock bank"},
+		Entry:    true,
+		MinArgs:  1,
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.lockEntry(args[0], false)
+		},
+	},
+
+	"recent": {
+		Usage:    "recent [n]",
+		Desc:     "List the n (10 by default) entries used last on this machine.",
+		Examples: []string{"recent", "recent 20"},
+		ReadOnly: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			n := recentDefault
+			if len(args) != 0 {
+				var err error
+				if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+					errColor.Println("n must be a number of 1 or more")
+					return nil
+				}
+			}
+			return r.ctx.listRecent(n)
+		},
+	},
+
 	"cd": {
+		Usage:    "cd [query]",
+		Desc:     "\"cd\" into an entry to omit it from key commands, or back to the root.",
+		Examples: []string{"cd github", "cd"},
 		ReadOnly: true,
 		Run: func(r *repl, _ string, args []string) error {
 			switch len(args) {
@@ -314,7 +1345,7 @@ var replCmds = map[string]replCmd{
 				}
 
 				r.ctxEntry = blob.Name()
-				r.prompt = mainPromptColor.Sprintf(dirPrompt, r.ctx.shortFilename, r.ctxEntry)
+				r.prompt = entryPrompt(r.ctx.shortFilename, blob)
 			default:
 				fmt.Println("cd needs an argument")
 			}
@@ -323,219 +1354,430 @@ var replCmds = map[string]replCmd{
 		},
 	},
 
-	"cp":                    {ReadOnly: true, Run: getCopy},
-	"get":                   {ReadOnly: true, Run: getCopy},
-	blobformat.KeyUser:      {ReadOnly: true, Run: quickCopy},
-	blobformat.KeyPass:      {ReadOnly: true, Run: quickCopy},
-	blobformat.KeyEmail:     {ReadOnly: true, Run: quickCopy},
-	blobformat.KeyTwoFactor: {ReadOnly: true, Run: quickCopy},
+	"cp": {
+		ReadOnly: true,
+		Usage:    "cp <query> <key> [index]",
+		Entry:    true,
+		MinArgs:  2,
+		Desc:     "Copy a key of an entry to the clipboard.",
+		Examples: []string{"cp github pass"},
+		Run:      getCopy,
+	},
 
-	"login": {
+	"get": {
+		ReadOnly: true,
+		Usage:    "get [--raw] [--reveal] [--format=json|tsv] <query> <key> [index]",
+		Entry:    true,
+		Flags:    getFlags,
+		MinArgs:  2,
+		Desc:     "Show a key of an entry, see \"help output\".",
+		Examples: []string{"get github user", "get github passhist", "get github passhist 2", "get --reveal bank security-answer", "get --format=json github user"},
+		Run:      getCopy,
+	},
+
+	blobformat.KeyUser: {
+		ReadOnly: true,
+		Usage:    "user <query>",
+		Entry:    true,
+		MinArgs:  1,
+		Desc:     "Copy the username of an entry to the clipboard.",
+		Run:      quickCopy,
+	},
+
+	blobformat.KeyPass: {
+		ReadOnly: true,
+		Usage:    "pass <query>",
+		Entry:    true,
+		MinArgs:  1,
+		Desc:     "Copy the password of an entry to the clipboard.",
+		Run:      quickCopy,
+	},
+
+	blobformat.KeyEmail: {
+		ReadOnly: true,
+		Usage:    "email <query>",
+		Entry:    true,
+		MinArgs:  1,
+		Desc:     "Copy the email of an entry to the clipboard.",
+		Run:      quickCopy,
+	},
+
+	blobformat.KeyTwoFactor: {
+		ReadOnly: true,
+		Usage:    "totp <query>",
+		Entry:    true,
+		MinArgs:  1,
+		Desc:     "Copy the current two factor code of an entry, see \"help entries\".",
+		Run:      quickCopy,
+	},
+
+	"use": {
+		Usage:    "use recovery <query>",
+		Desc:     "Copy the next unused recovery code of an entry, see \"help entries\".",
+		Examples: []string{"use recovery github"},
+		MinArgs:  1,
+		Undoable: true,
 		Run: func(r *repl, cmd string, args []string) error {
-			name := r.ctxEntry
-			if len(args) >= 1 {
-				name = args[0]
+			if args[0] != blobformat.KeyRecovery || len(args) > 2 {
+				errColor.Println("syntax: use recovery <query>")
+				return nil
 			}
 
+			name := r.ctxEntry
+			if len(args) == 2 {
+				name = args[1]
+			}
 			if len(name) == 0 {
-				errColor.Println("syntax: login <query>")
+				errColor.Println("syntax: use recovery <query>")
 				return nil
 			}
 
-			return r.ctx.login(name)
+			return r.ctx.useRecovery(name)
 		},
 	},
 
-	"set": {
-		Run: func(r *repl, cmd string, args []string) error {
-			name := r.ctxEntry
-			var key, value string
+	blobformat.KeyFullName: {
+		ReadOnly: true,
+		Usage:    "fullname <query>",
+		Entry:    true,
+		MinArgs:  1,
+		Desc:     "Copy the full name of an identity to the clipboard.",
+		Run:      quickCopy,
+	},
+
+	blobformat.KeyPhone: {
+		ReadOnly: true,
+		Usage:    "phone <query>",
+		Entry:    true,
+		MinArgs:  1,
+		Desc:     "Copy the phone number of an identity to the clipboard.",
+		Run:      quickCopy,
+	},
+
+	blobformat.KeyAddress: {
+		ReadOnly: true,
+		Usage:    "address <query>",
+		Entry:    true,
+		MinArgs:  1,
+		Desc:     "Copy the address of an identity to the clipboard.",
+		Run:      quickCopy,
+	},
 
-			// with context:
-			// set key
-			// set key val
-			// without context:
-			// set name key
-			// set name key value
+	"login": {
+		Usage:   "login <query>",
+		Desc:    "Copy the username, email, password and two factor code one after another.",
+		Entry:   true,
+		MinArgs: 1,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.login(args[0])
+		},
+	},
 
+	"set": {
+		Usage:    "set [--force] <query> <key> [value | --multiline | --gen]",
+		Desc:     "Set a value on an entry, see \"help entries\" for what keys take.",
+		Examples: []string{"set github user me", "set github pass", "set github user --gen", "set github email --gen", "set github expires 2027-01-31", "set server privkey --multiline", "set work/wiki pass ref:work/ldap/pass"},
+		Entry:    true,
+		Flags:    []string{"--force"},
+		MinArgs:  2,
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
 			// Set's args are a special case, they are given from
 			// strings.Split not strings.Fields which means there are
 			// potentially empty string arguments lurking around.
+			args, force := parseForce(args)
 
-			syntaxErr := func() error {
-				errColor.Println("syntax: set <query> <key> [value]")
-				return nil
-			}
-
-			if len(args) < 1 || (len(name) == 0 && len(args) < 2) {
-				return syntaxErr()
-			}
-
-			if len(name) == 0 {
-				name = args[0]
-				args = args[1:]
-			}
-
-			key = args[0]
-			args = args[1:]
+			name, key := args[0], args[1]
+			args = args[2:]
 
 			if len(name) == 0 || len(key) == 0 {
-				return syntaxErr()
+				errColor.Println("syntax: set [--force] <query> <key> [value | --multiline | --gen]")
+				return nil
 			}
 
-			if len(args) == 1 {
+			var value string
+			multiline := false
+			if len(args) == 1 && args[0] == "--multiline" {
+				multiline = true
+			} else if len(args) == 1 && args[0] == genFlag {
+				return r.ctx.setGenerated(name, key, force)
+			} else if len(args) == 1 {
 				value = args[0]
 			} else if len(args) > 1 {
 				value = strings.Join(args, " ")
 			}
 
-			return r.ctx.set(name, key, value)
+			return r.ctx.set(name, key, value, force, multiline)
 		},
 	},
 
 	"edit": {
+		Usage:    "edit [--force] <query> <key>",
+		Desc:     "Open $EDITOR to edit an existing value.",
+		Examples: []string{"edit github notes"},
+		Entry:    true,
+		Flags:    []string{"--force"},
+		MinArgs:  2,
+		Undoable: true,
 		Run: func(r *repl, cmd string, args []string) error {
-			name := r.ctxEntry
-			if len(args) < 1 || (len(name) == 0 && len(args) < 2) {
-				errColor.Println("syntax: edit <query> <key>")
-				return nil
-			}
-
-			if len(name) == 0 {
-				name = args[0]
-				args = args[1:]
-			}
-
-			key := args[0]
-			return r.ctx.edit(name, key)
+			args, force := parseForce(args)
+			return r.ctx.edit(args[0], args[1], force)
 		},
 	},
 
 	"open": {
+		Usage:    "open <query> [n]",
+		Desc:     "Launch the browser using the url (or urln) key, see \"help entries\".",
+		Examples: []string{"open github", "open google 2"},
 		ReadOnly: true,
+		Entry:    true,
+		MinArgs:  1,
 		Run: func(r *repl, cmd string, args []string) error {
-			name := r.ctxEntry
-			if len(name) == 0 {
-				if len(args) == 0 {
-					errColor.Println("syntax: open <query>")
+			n := 0
+			if len(args) > 1 {
+				var err error
+				if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+					errColor.Println("n must be a number of 1 or more")
 					return nil
 				}
-				name = args[0]
 			}
+			return r.ctx.openurl(args[0], n)
+		},
+	},
 
-			return r.ctx.openurl(name)
+	"match": {
+		Usage:    "match <url>",
+		Desc:     "List the entries with a url matching a url's host, see \"help entries\".",
+		Examples: []string{"match https://mail.google.com/inbox", "match accounts.google.com"},
+		ReadOnly: true,
+		MinArgs:  1,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.matchURL(args[0])
 		},
 	},
 
 	"label": {
+		Usage:    "label <query>",
+		Desc:     "Add labels to an entry.",
+		Entry:    true,
+		MinArgs:  1,
+		Undoable: true,
 		Run: func(r *repl, cmd string, args []string) error {
-			name := r.ctxEntry
-			if len(name) == 0 {
-				if len(args) == 0 {
-					errColor.Println("syntax: label <query>")
-					return nil
-				}
-				name = args[0]
-			}
-
-			return r.ctx.addLabels(name)
+			return r.ctx.addLabels(args[0])
 		},
 	},
 
 	"rmlabel": {
+		Usage:    "rmlabel <query> <label>",
+		Desc:     "Remove a label from an entry.",
+		Examples: []string{"rmlabel github work"},
+		Entry:    true,
+		MinArgs:  2,
+		Undoable: true,
 		Run: func(r *repl, cmd string, args []string) error {
-			name := r.ctxEntry
-			if len(args) < 1 || (len(name) == 0 && len(args) < 2) {
-				errColor.Println("syntax: rmlabel <query> <label>")
-				return nil
-			}
-
-			if len(name) == 0 {
-				name = args[0]
-				args = args[1:]
-			}
-
-			return r.ctx.deleteLabel(name, args[0])
+			return r.ctx.deleteLabel(args[0], args[1])
 		},
 	},
 
 	"labels": {
+		Usage:    "labels <label...>",
+		Desc:     "List entries by labels, entries must have all the given labels.",
+		Examples: []string{"labels work", "labels work vpn"},
 		ReadOnly: true,
+		MinArgs:  1,
 		Run: func(r *repl, cmd string, args []string) error {
-			if len(args) == 0 {
-				errColor.Println("syntax: labels <label...>")
-				return nil
-			}
-
 			return r.ctx.listByLabels(args)
 		},
 	},
 
 	"show": {
+		Usage:    "show [--raw] [--format=json|tsv [--reveal]] <query> [snapshot]",
+		Desc:     "Show all keys of an entry, optionally snapshots ago, see \"help output\".",
+		Examples: []string{"show github", "show github 2", "show --raw runbooks/deploy", "show --format=json github"},
 		ReadOnly: true,
+		Entry:    true,
+		Flags:    showFlags,
+		MinArgs:  1,
 		Run: func(r *repl, cmd string, args []string) error {
-			name := r.ctxEntry
+			args, format := parseFormat(args, showFlags, r.ctx.format)
+			args, raw, reveal := parseGetFlags(args)
 			snapshot := 0
-			var err error
-			if len(name) == 0 {
-				// We need to get a name
-				if len(args) == 0 {
-					errColor.Println("syntax: show <query> [snapshot]")
-					return nil
-				}
-				name = args[0]
-				args = args[1:]
-
-			}
-			if len(args) != 0 {
+			if len(args) > 1 {
 				// The user gave us a snapshot ^_^
-				snapshot, err = strconv.Atoi(args[0])
+				var err error
+				snapshot, err = strconv.Atoi(args[1])
 				if err != nil {
 					snapshot = 0
 				}
 			}
-			return r.ctx.show(name, snapshot)
+			if len(format) != 0 {
+				return r.ctx.showAs(args[0], snapshot, reveal, format)
+			}
+			return r.ctx.show(args[0], snapshot, raw)
+		},
+	},
+
+	"restorepass": {
+		Usage:    "restorepass <query> <index>",
+		Desc:     "Set the password of an entry back to an old one, see \"help changes\".",
+		Examples: []string{"restorepass github 1"},
+		Entry:    true,
+		MinArgs:  2,
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			index, err := strconv.Atoi(args[1])
+			if err != nil {
+				errColor.Println("Index must be an integer")
+				return nil
+			}
+			return r.ctx.restorePass(args[0], index)
+		},
+	},
+
+	"history": {
+		Usage:    "history <query>",
+		Desc:     "List the changes made to an entry newest first, numbered by the snapshot to give to show or diff.",
+		Examples: []string{"history github"},
+		ReadOnly: true,
+		Entry:    true,
+		MinArgs:  1,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.history(args[0])
+		},
+	},
+
+	"diff": {
+		Usage:    "diff <query> <snapshot> <snapshot>",
+		Desc:     "Show the keys of an entry that changed between two snapshots (0 is now).",
+		Examples: []string{"diff github 4 0"},
+		ReadOnly: true,
+		Entry:    true,
+		MinArgs:  3,
+		Run: func(r *repl, cmd string, args []string) error {
+			from, err := strconv.Atoi(args[1])
+			if err != nil {
+				errColor.Println("snapshot must be a number:", args[1])
+				return nil
+			}
+			to, err := strconv.Atoi(args[2])
+			if err != nil {
+				errColor.Println("snapshot must be a number:", args[2])
+				return nil
+			}
+			return r.ctx.diff(args[0], from, to)
+		},
+	},
+
+	"attach": {
+		Usage:    "attach <query> <path>",
+		Desc:     "Attach a file to an entry, see \"help entries\".",
+		Examples: []string{"attach github ~/Documents/github-recovery-codes.pdf"},
+		Entry:    true,
+		MinArgs:  2,
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.attach(args[0], args[1])
+		},
+	},
+
+	"attachments": {
+		Usage:    "attachments <query>",
+		Desc:     "List the files attached to an entry with their sizes and checksums.",
+		Examples: []string{"attachments github"},
+		ReadOnly: true,
+		Entry:    true,
+		MinArgs:  1,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.attachments(args[0])
+		},
+	},
+
+	"extract": {
+		Usage:    "extract <query> <name> <path>",
+		Desc:     "Write a file attached to an entry to path, see \"help entries\".",
+		Examples: []string{"extract github github-recovery-codes.pdf ~/Downloads"},
+		ReadOnly: true,
+		Entry:    true,
+		MinArgs:  3,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.extract(args[0], args[1], args[2])
 		},
 	},
 
 	"sync": {
+		Usage:    "sync [name] | sync auto <on|off> [minutes] | sync status [name] | sync log [n] | sync ls | sync rm <name> | sync test <name> | sync init <name>",
+		Desc:     "Sync the file with its auto-sync entries or a given one, see \"help sync\".",
+		Examples: []string{"sync", "sync sync/scp", "sync auto on 5", "sync status", "sync log 50", "sync test sync/scp"},
 		Run: func(r *repl, cmd string, args []string) error {
 			var name string
 			if len(args) > 0 {
 				name = args[0]
 			}
 
+			switch name {
+			case "auto":
+				return syncAuto(r, args[1:])
+			case "status":
+				var name string
+				if len(args) > 1 {
+					name = args[1]
+				}
+				return r.ctx.syncStatus(name)
+			case "log":
+				n := defaultSyncLogLines
+				if len(args) > 1 {
+					var err error
+					if n, err = strconv.Atoi(args[1]); err != nil || n <= 0 {
+						errColor.Println("syntax: sync log [n]")
+						return nil
+					}
+				}
+				return r.ctx.syncLog(n)
+			case "ls":
+				return r.ctx.listSyncs()
+			case "rm", "test", "init":
+				if len(args) < 2 {
+					errColor.Printf("syntax: sync %s <name>\n", name)
+					return nil
+				}
+				switch name {
+				case "rm":
+					return r.ctx.removeSync(args[1])
+				case "init":
+					return r.ctx.initSync(args[1])
+				}
+				return r.ctx.testSync(args[1])
+			}
+
 			return r.ctx.sync(name, false, true)
 		},
 	},
 
 	"addsync": {
+		Usage:    "addsync <kind>",
+		Desc:     "Start the setup wizard for a sync entry (scp, file, http or https).",
+		Examples: []string{"addsync scp"},
+		MinArgs:  1,
+		Undoable: true,
 		Run: func(r *repl, cmd string, args []string) error {
-			if len(args) == 0 {
-				errColor.Println("syntax: addsync <kind>")
-				return nil
-			}
 			return r.ctx.addSyncInterruptible(args[0])
 		},
 	},
 
 	"dump": {
+		Usage:    "dump <query>",
+		Desc:     "Dump an entire entry in debug mode.",
 		ReadOnly: true,
+		Entry:    true,
+		MinArgs:  1,
 		Run: func(r *repl, cmd string, args []string) error {
-			name := r.ctxEntry
-			if len(name) == 0 {
-				if len(args) == 0 {
-					errColor.Println("syntax: dump <query>")
-					return nil
-				}
-				name = args[0]
-			}
-
-			return r.ctx.dump(name)
+			return r.ctx.dump(args[0])
 		},
 	},
 
 	"dumpall": {
+		Usage:    "dumpall",
+		Desc:     "Dump the entire store in debug mode.",
 		ReadOnly: true,
 		Run: func(r *repl, cmd string, args []string) error {
 			return r.ctx.dumpall()
@@ -543,22 +1785,80 @@ var replCmds = map[string]replCmd{
 	},
 
 	"help": {
+		Usage:    "help [topic | command] | help search <text>",
+		Desc:     "Show help for a topic or a command, or search through the help.",
+		Examples: []string{"help", "help set", "help search label"},
 		ReadOnly: true,
+		// Run is set in init() to avoid an initialization loop
+	},
+
+	"save": {
+		Usage: "save",
+		Desc:  "Save the file without exiting.",
 		Run: func(r *repl, cmd string, args []string) error {
-			if len(args) == 0 {
-				fmt.Print(replHelp)
-			} else if args[0] == "sync" {
-				fmt.Print(syncHelp)
-			} else if args[0] == "users" {
-				fmt.Print(usersHelp)
-			} else if args[0] == "other" {
-				fmt.Print(otherHelp)
+			return r.ctx.save()
+		},
+	},
+
+	"compact": {
+		Usage:    "compact [days]",
+		Desc:     "Squash the history older than days to shrink the file, see \"help changes\".",
+		Examples: []string{"compact", "compact 90"},
+		Run: func(r *repl, cmd string, args []string) error {
+			days := 0
+			if len(args) != 0 {
+				var err error
+				if days, err = strconv.Atoi(args[0]); err != nil || days < 0 {
+					errColor.Println("syntax: compact [days]")
+					return nil
+				}
 			}
-			return nil
+
+			return r.ctx.compact(days)
+		},
+	},
+
+	"undo": {
+		Usage:    "undo [n]",
+		Desc:     "Revert the changes made by the last n (default 1) commands.",
+		Examples: []string{"undo", "undo 3"},
+		Run: func(r *repl, cmd string, args []string) error {
+			n, ok := undoCount(args)
+			if !ok {
+				errColor.Println("syntax: undo [n]")
+				return nil
+			}
+			return r.ctx.undo(n)
+		},
+	},
+
+	"redo": {
+		Usage:    "redo [n]",
+		Desc:     "Revert the last n (default 1) undos.",
+		Examples: []string{"redo"},
+		Run: func(r *repl, cmd string, args []string) error {
+			n, ok := undoCount(args)
+			if !ok {
+				errColor.Println("syntax: redo [n]")
+				return nil
+			}
+			return r.ctx.redo(n)
+		},
+	},
+
+	"config": {
+		Usage:    "config [key] [value]",
+		Desc:     "Show all settings, a single setting, or change a setting for this file.",
+		Examples: []string{"config", "config synconsave true"},
+		Undoable: true,
+		Run: func(r *repl, cmd string, args []string) error {
+			return r.ctx.config(args)
 		},
 	},
 
 	"exit": {
+		Usage:    "exit",
+		Desc:     "Exit the repl (the file is saved).",
 		ReadOnly: true,
 		Run: func(r *repl, cmd string, args []string) error {
 			return errExit
@@ -566,20 +1866,94 @@ var replCmds = map[string]replCmd{
 	},
 }
 
-func getCopy(r *repl, cmd string, args []string) error {
-	name := r.ctxEntry
-	if len(args) < 1 || (len(args) < 2 && len(name) == 0) {
-		errColor.Printf("syntax: %s <query> <key> [index]\n", cmd)
+func protectCmd(r *repl, cmd string, args []string, protect bool) error {
+	return r.ctx.protect(args[0], args[1], protect)
+}
+
+// parseForce strips a leading --force off of args
+func parseForce(args []string) ([]string, bool) {
+	if len(args) != 0 && args[0] == "--force" {
+		return args[1:], true
+	}
+
+	return args, false
+}
+
+func parseRaw(args []string) ([]string, bool) {
+	if len(args) != 0 && args[0] == "--raw" {
+		return args[1:], true
+	}
+
+	return args, false
+}
+
+// parseGetFlags strips the leading --raw and --reveal flags off of args in
+// any order
+func parseGetFlags(args []string) (rest []string, raw, reveal bool) {
+	n := countFlags(args, []string{"--raw", "--reveal"})
+	for _, arg := range args[:n] {
+		switch arg {
+		case "--raw":
+			raw = true
+		case "--reveal":
+			reveal = true
+		}
+	}
+
+	return args[n:], raw, reveal
+}
+
+func syncAuto(r *repl, args []string) error {
+	if len(args) == 0 {
+		if r.ctx.autoSync == nil {
+			infoColor.Println("background sync is off")
+		} else {
+			infoColor.Println("background sync is on, every", r.ctx.autoSync.interval)
+		}
 		return nil
 	}
 
-	if len(name) == 0 {
-		name = args[0]
-		args = args[1:]
+	switch args[0] {
+	case "on":
+		interval := defaultAutoSyncInterval
+		if len(args) > 1 {
+			minutes, err := strconv.Atoi(args[1])
+			if err != nil || minutes <= 0 {
+				errColor.Println("minutes must be a positive integer")
+				return nil
+			}
+			interval = time.Duration(minutes) * time.Minute
+		}
+
+		r.ctx.startAutoSync(interval)
+		infoColor.Println("background sync enabled, every", interval)
+	case "off":
+		// We're holding the lock so we mustn't wait
+		r.ctx.stopAutoSync(false)
+		infoColor.Println("background sync disabled")
+	default:
+		errColor.Println("syntax: sync auto <on|off> [minutes]")
 	}
 
-	key := args[0]
-	args = args[1:]
+	return nil
+}
+
+// showFlags are the flags of show, --reveal only matters with a format
+var showFlags = append([]string{"--raw", "--reveal"}, formatFlags...)
+
+// getFlags are the flags of get, cp ignores the format
+var getFlags = append([]string{"--raw", "--reveal"}, formatFlags...)
+
+func getCopy(r *repl, cmd string, args []string) error {
+	args, format := parseFormat(args, getFlags, r.ctx.format)
+	args, raw, reveal := parseGetFlags(args)
+	name, key := args[0], args[1]
+	args = args[2:]
+
+	// pub is short for the public key of an ssh key
+	if key == "pub" {
+		key = blobformat.KeyPub
+	}
 
 	index := -1
 	if len(args) != 0 {
@@ -591,20 +1965,12 @@ func getCopy(r *repl, cmd string, args []string) error {
 		index = i
 	}
 
-	return r.ctx.get(name, key, index, cmd == "cp")
+	if cmd == "get" && len(format) != 0 {
+		return r.ctx.getAs(name, key, index, reveal, format)
+	}
+	return r.ctx.get(name, key, index, cmd == "cp", raw, reveal)
 }
 
 func quickCopy(r *repl, cmd string, args []string) error {
-	name := r.ctxEntry
-	if len(args) < 1 && len(name) == 0 {
-		errColor.Printf("syntax: %s <query>\n", cmd)
-		return nil
-	}
-
-	if len(name) == 0 {
-		name = args[0]
-		args = args[1:]
-	}
-
-	return r.ctx.get(name, cmd, -1, true)
+	return r.ctx.get(args[0], cmd, -1, true, false, false)
 }
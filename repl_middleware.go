@@ -0,0 +1,206 @@
+package main
+
+import "github.com/aarondl/bpass/blobformat"
+
+// replMiddleware wraps a command's Run, it's given the command being run so
+// it can act on the command's declared behavior.
+type replMiddleware func(c replCmd, next replFunc) replFunc
+
+// replMiddlewares are applied to every command, the first one is the
+// outermost (it's the first to see the command and the last to see its
+// result).
+var replMiddlewares = []replMiddleware{
+	historyMiddleware,
+	readOnlyMiddleware,
+	argsMiddleware,
+	lockMiddleware,
+	entryLockMiddleware,
+	undoMiddleware,
+	confirmMiddleware,
+}
+
+// run calls the command's Run wrapped in all the middleware
+func (c replCmd) run(r *repl, cmd string, args []string) error {
+	fn := c.Run
+	for i := len(replMiddlewares) - 1; i >= 0; i-- {
+		fn = replMiddlewares[i](c, fn)
+	}
+
+	return fn(r, cmd, args)
+}
+
+// historyMiddleware adds the line to the history when the command succeeds.
+// Destructive commands are left out so they can't be repeated by accident.
+func historyMiddleware(c replCmd, next replFunc) replFunc {
+	return func(r *repl, cmd string, args []string) error {
+		err := next(r, cmd, args)
+		if err == nil && !c.Destructive && len(r.line) != 0 {
+			r.ctx.in.AddHistory(r.line)
+		}
+
+		return err
+	}
+}
+
+func readOnlyMiddleware(c replCmd, next replFunc) replFunc {
+	return func(r *repl, cmd string, args []string) error {
+		if r.ctx.readOnly && !c.ReadOnly {
+			errColor.Println("cannot use write commands in read-only mode")
+			return nil
+		}
+
+		return next(r, cmd, args)
+	}
+}
+
+// argsMiddleware fills in the entry we're cd'd into for commands that take
+// one and checks that there are enough arguments
+func argsMiddleware(c replCmd, next replFunc) replFunc {
+	return func(r *repl, cmd string, args []string) error {
+		if c.Entry && len(r.ctxEntry) != 0 {
+			args = insertEntry(args, r.ctxEntry, c.Flags)
+		}
+
+		if len(args)-countFlags(args, c.Flags) < c.MinArgs {
+			errColor.Println("syntax:", c.Usage)
+			return nil
+		}
+
+		return next(r, cmd, args)
+	}
+}
+
+// lockMiddleware prevents background work (auto-sync) from touching the
+// store while the command runs
+func lockMiddleware(c replCmd, next replFunc) replFunc {
+	return func(r *repl, cmd string, args []string) error {
+		r.ctx.Lock()
+		defer r.ctx.Unlock()
+
+		return next(r, cmd, args)
+	}
+}
+
+// entryLockMiddleware rolls back what commands that change an entry did to
+// locked entries, commands check the lock themselves before asking anything
+// (see checkUnlocked) but this makes sure nothing gets by them
+func entryLockMiddleware(c replCmd, next replFunc) replFunc {
+	if !c.Entry || c.ReadOnly {
+		return next
+	}
+
+	return func(r *repl, cmd string, args []string) error {
+		store := r.ctx.store
+		locked := store.Locked()
+		if len(locked) == 0 {
+			return next(r, cmd, args)
+		}
+
+		start := len(store.Log)
+		store.DB.Begin()
+		if err := next(r, cmd, args); err != nil {
+			store.DB.Commit()
+			return err
+		}
+
+		if uuid, ok := changedLocked(store.Log[start:], locked); ok {
+			store.DB.Rollback()
+			name := store.DB.Values(blobformat.KeyName)[uuid]
+			errColor.Printf("%s is locked, unlock %s to change it (nothing was changed)\n", name, name)
+			return nil
+		}
+
+		store.DB.Commit()
+		return nil
+	}
+}
+
+// undoMiddleware records what undoable commands add to the log so undo can
+// revert it. Adding or removing users isn't recorded since their keys are
+// involved.
+func undoMiddleware(c replCmd, next replFunc) replFunc {
+	if !c.Undoable {
+		return next
+	}
+
+	return func(r *repl, cmd string, args []string) error {
+		start := len(r.ctx.store.Log)
+		users, err := r.ctx.store.Users()
+		if err != nil {
+			return err
+		}
+
+		if err = next(r, cmd, args); err != nil {
+			return err
+		}
+		if len(r.ctx.store.Log) <= start {
+			return nil
+		}
+
+		after, err := r.ctx.store.Users()
+		if err != nil {
+			return err
+		}
+		if len(after) == len(users) {
+			r.ctx.recordUndo(r.line, r.ctx.store.Log[start:])
+		}
+
+		return nil
+	}
+}
+
+// confirmMiddleware asks before running destructive commands
+func confirmMiddleware(c replCmd, next replFunc) replFunc {
+	if !c.Destructive {
+		return next
+	}
+
+	return func(r *repl, cmd string, args []string) error {
+		if len(c.Warning) != 0 {
+			errColor.Println(c.Warning)
+		}
+
+		yes, err := r.ctx.getYesNo("are you sure you wish to proceed?")
+		if err != nil {
+			return err
+		}
+		if !yes {
+			return nil
+		}
+
+		return next(r, cmd, args)
+	}
+}
+
+// insertEntry puts the entry after any leading flags
+func insertEntry(args []string, entry string, flags []string) []string {
+	n := countFlags(args, flags)
+
+	out := make([]string, 0, len(args)+1)
+	out = append(out, args[:n]...)
+	out = append(out, entry)
+	return append(out, args[n:]...)
+}
+
+// countFlags counts how many of the leading args are flags
+func countFlags(args []string, flags []string) int {
+	n := 0
+	for _, arg := range args {
+		if !isFlag(arg, flags) {
+			break
+		}
+		n++
+	}
+
+	return n
+}
+
+func isFlag(arg string, flags []string) bool {
+	for _, f := range flags {
+		if arg == f {
+			return true
+		}
+	}
+
+	return false
+}
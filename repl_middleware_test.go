@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInsertEntry(t *testing.T) {
+	t.Parallel()
+
+	flags := []string{"--force"}
+	tests := []struct {
+		Args []string
+		Want []string
+	}{
+		{nil, []string{"e"}},
+		{[]string{"key"}, []string{"e", "key"}},
+		{[]string{"--force", "key", "val"}, []string{"--force", "e", "key", "val"}},
+		{[]string{"key", "--force"}, []string{"e", "key", "--force"}},
+	}
+
+	for i, test := range tests {
+		got := insertEntry(test.Args, "e", flags)
+		if !reflect.DeepEqual(got, test.Want) {
+			t.Errorf("%d) want: %q, got: %q", i, test.Want, got)
+		}
+	}
+}
+
+func TestArgsMiddleware(t *testing.T) {
+	t.Parallel()
+
+	c := replCmd{Entry: true, Flags: []string{"--force"}, MinArgs: 2}
+
+	var got []string
+	fn := argsMiddleware(c, func(r *repl, cmd string, args []string) error {
+		got = args
+		return nil
+	})
+
+	tests := []struct {
+		Entry string
+		Args  []string
+		Want  []string
+	}{
+		{"", []string{"github", "pass"}, []string{"github", "pass"}},
+		{"github", []string{"pass"}, []string{"github", "pass"}},
+		{"github", []string{"--force", "pass"}, []string{"--force", "github", "pass"}},
+		// Not enough arguments, run is never called
+		{"", []string{"github"}, nil},
+		{"", []string{"--force", "github"}, nil},
+	}
+
+	for i, test := range tests {
+		got = nil
+		if err := fn(&repl{ctxEntry: test.Entry}, "cmd", test.Args); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, test.Want) {
+			t.Errorf("%d) want: %q, got: %q", i, test.Want, got)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+)
+
+const (
+	defaultSyncRetries = 3
+	defaultSyncBackoff = time.Second
+	maxSyncBackoff     = 30 * time.Second
+	defaultSyncTimeout = time.Minute
+)
+
+// syncSettings are the file's settings that pulls and pushes use. They're
+// read before the pulls and pushes start since those run concurrently and
+// the store can't be used from more than one goroutine.
+type syncSettings struct {
+	// attempts is how many times a transfer is tried, backoff how long to
+	// wait before the first retry
+	attempts int
+	backoff  time.Duration
+	// timeout is how long a single pull/push may take
+	timeout time.Duration
+	// rateLimit is the most bytes per second transfers may use, 0 is
+	// unlimited
+	rateLimit int
+	// algorithms are the ssh algorithm settings by setting name
+	algorithms map[string]string
+}
+
+// syncSettings reads the settings pulls and pushes use from the file
+func (u *uiContext) syncSettings() syncSettings {
+	s := syncSettings{
+		attempts:   defaultSyncRetries,
+		backoff:    defaultSyncBackoff,
+		timeout:    defaultSyncTimeout,
+		algorithms: make(map[string]string),
+	}
+
+	if val, err := u.store.Setting(blobformat.SettingSyncRetries); err == nil && len(val) != 0 {
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			s.attempts = n
+		}
+	}
+	if val, err := u.store.Setting(blobformat.SettingSyncBackoff); err == nil && len(val) != 0 {
+		if d, err := time.ParseDuration(val); err == nil && d > 0 {
+			s.backoff = d
+		}
+	}
+	if val, err := u.store.Setting(blobformat.SettingSyncTimeout); err == nil && len(val) != 0 {
+		if d, err := time.ParseDuration(val); err == nil && d > 0 {
+			s.timeout = d
+		}
+	}
+	if val, err := u.store.Setting(blobformat.SettingSyncRateLimit); err == nil && len(val) != 0 {
+		if kb, err := strconv.Atoi(val); err == nil && kb > 0 {
+			s.rateLimit = kb * 1024
+		}
+	}
+
+	for _, setting := range []string{blobformat.SettingHostKeyAlgorithms, blobformat.SettingKexAlgorithms, blobformat.SettingCiphers, blobformat.SettingMACs} {
+		s.algorithms[setting], _ = u.store.Setting(setting)
+	}
+
+	return s
+}
+
+// retry runs fn until it succeeds, fails with an error that isn't
+// transient or runs out of attempts. Each retry waits twice as long as the
+// last one did.
+func (s syncSettings) retry(name string, fn func() error) error {
+	backoff := s.backoff
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= s.attempts || !isTransient(err) {
+			return err
+		}
+
+		errColor.Printf("%s: %v (retrying in %s, attempt %d of %d)\n", name, err, backoff, attempt+1, s.attempts)
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > maxSyncBackoff {
+			backoff = maxSyncBackoff
+		}
+	}
+}
+
+// isTransient checks if an error is the kind that may go away if we try
+// again: network errors that timed out or say they're temporary and
+// connections that were reset or timed out. Things like authentication
+// failures, refused connections or missing files are not.
+func isTransient(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && (netErr.Timeout() || netErr.Temporary()) {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ETIMEDOUT)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// netError is a net.Error that's only as transient as it says
+type netError struct {
+	timeout, temporary bool
+}
+
+func (n netError) Error() string   { return "network error" }
+func (n netError) Timeout() bool   { return n.timeout }
+func (n netError) Temporary() bool { return n.temporary }
+
+func TestIsTransient(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name      string
+		Err       error
+		Transient bool
+	}{
+		{"timeout", netError{timeout: true}, true},
+		{"temporary", netError{temporary: true}, true},
+		{"permanent net error", netError{}, false},
+		{"wrapped timeout", fmt.Errorf("pull: %w", netError{timeout: true}), true},
+		{"deadline", context.DeadlineExceeded, true},
+		{"reset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{"timed out", fmt.Errorf("dial: %w", syscall.ETIMEDOUT), true},
+		{"refused", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, false},
+		{"unknown host", &net.DNSError{Err: "no such host", Name: "nope.example.com"}, false},
+		{"missing file", os.ErrNotExist, false},
+		{"eof", io.EOF, false},
+		{"other", errors.New("authentication failed"), false},
+	}
+
+	for _, test := range tests {
+		if got := isTransient(test.Err); got != test.Transient {
+			t.Errorf("%s: want: %t, got: %t", test.Name, test.Transient, got)
+		}
+	}
+}
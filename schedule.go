@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// syncWindow is a set of days and hours in which a sync entry may be
+// automatically synced
+type syncWindow struct {
+	days  [7]bool
+	hours [24]bool
+}
+
+var weekdays = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// parseSchedule parses a sync entry's schedule key. It's a ; separated list
+// of windows, each is a days field and an hours field similar to cron:
+//
+//	mon-fri 9-17; sat 10-12
+//
+// Days are names (sun-sat) or numbers (0-6, 0 is sunday). Hour ranges
+// include the start hour but not the end hour so 9-17 ends at 17:00. Both
+// fields may be * for any, comma separated lists and ranges that wrap
+// around (fri-mon, 22-6).
+func parseSchedule(schedule string) ([]syncWindow, error) {
+	var windows []syncWindow
+	for _, w := range strings.Split(schedule, ";") {
+		fields := strings.Fields(w)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("window %q must have a days and an hours field", strings.TrimSpace(w))
+		}
+
+		var window syncWindow
+		if err := parseScheduleField(fields[0], window.days[:], weekdays); err != nil {
+			return nil, fmt.Errorf("days %q: %w", fields[0], err)
+		}
+		if err := parseScheduleField(fields[1], window.hours[:], nil); err != nil {
+			return nil, fmt.Errorf("hours %q: %w", fields[1], err)
+		}
+
+		windows = append(windows, window)
+	}
+
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("schedule %q has no windows", schedule)
+	}
+
+	return windows, nil
+}
+
+// parseScheduleField sets the values in set that the field covers, names
+// are alternatives to numbers
+func parseScheduleField(field string, set []bool, names map[string]int) error {
+	isHours := names == nil
+
+	for _, part := range strings.Split(field, ",") {
+		if part == "*" {
+			for i := range set {
+				set[i] = true
+			}
+			continue
+		}
+
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := scheduleValue(bounds[0], len(set), names)
+		if err != nil {
+			return err
+		}
+
+		if len(bounds) == 1 {
+			set[start] = true
+			continue
+		}
+
+		// Hours may end at 24 (midnight)
+		max := len(set)
+		if isHours {
+			max++
+		}
+		end, err := scheduleValue(bounds[1], max, names)
+		if err != nil {
+			return err
+		}
+
+		if !isHours {
+			// Day ranges include the end day
+			end = (end + 1) % len(set)
+		} else {
+			end %= len(set)
+		}
+
+		for i := start; ; i = (i + 1) % len(set) {
+			set[i] = true
+			if (i+1)%len(set) == end {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func scheduleValue(s string, max int, names map[string]int) (int, error) {
+	if n, ok := names[strings.ToLower(s)]; ok {
+		return n, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n >= max {
+		return 0, fmt.Errorf("%q is not valid", s)
+	}
+
+	return n, nil
+}
+
+// inSchedule checks if t falls in any of the windows
+func inSchedule(windows []syncWindow, t time.Time) bool {
+	for _, w := range windows {
+		if w.days[t.Weekday()] && w.hours[t.Hour()] {
+			return true
+		}
+	}
+
+	return false
+}
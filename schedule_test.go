@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	t.Parallel()
+
+	// 2020-06-22 is a monday
+	at := func(day, hour int) time.Time {
+		return time.Date(2020, 6, 22+day, hour, 30, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		Schedule string
+		Time     time.Time
+		In       bool
+	}{
+		{"mon-fri 9-17", at(0, 9), true},
+		{"mon-fri 9-17", at(0, 16), true},
+		{"mon-fri 9-17", at(0, 17), false},
+		{"mon-fri 9-17", at(0, 8), false},
+		{"mon-fri 9-17", at(4, 12), true},
+		{"mon-fri 9-17", at(5, 12), false},
+		{"* *", at(6, 3), true},
+		{"1-5 9-17", at(2, 10), true},
+		{"sat,sun *", at(6, 0), true},
+		{"sat,sun *", at(0, 0), false},
+		{"fri-mon 22-6", at(0, 23), true},
+		{"fri-mon 22-6", at(6, 2), true},
+		{"fri-mon 22-6", at(6, 6), false},
+		{"fri-mon 22-6", at(2, 23), false},
+		{"mon 0-24", at(0, 23), true},
+		{"mon-fri 9-12; sat 10", at(5, 10), true},
+		{"mon-fri 9-12; sat 10", at(5, 11), false},
+	}
+
+	for i, test := range tests {
+		windows, err := parseSchedule(test.Schedule)
+		if err != nil {
+			t.Errorf("%d) %q: %v", i, test.Schedule, err)
+			continue
+		}
+
+		if in := inSchedule(windows, test.Time); in != test.In {
+			t.Errorf("%d) %q at %s: want %t, got %t", i, test.Schedule, test.Time.Format("Mon 15:04"), test.In, in)
+		}
+	}
+
+	bad := []string{"", "mon", "mon-fri 9-17 x", "xyz 9", "mon 25", "7 9", "mon 9-25"}
+	for _, s := range bad {
+		if _, err := parseSchedule(s); err == nil {
+			t.Errorf("%q should not parse", s)
+		}
+	}
+}
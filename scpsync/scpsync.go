@@ -14,6 +14,9 @@
 //  Messages (these are strings followed by \n):
 //    Cmmmm <length> <filename>\nDATA\n
 //      single file copy, mmmm = mode (eg, 0644), length = bytes, DATA = contents
+//    T<mtime> 0 <atime> 0\n
+//      timestamps (unix seconds, the 0's are microseconds) of the file that
+//      the next C message is for, only sent when scp is run with -p
 //
 //  Replies:
 //    0 (OK)
@@ -33,8 +36,12 @@
 //  client: 0
 //  scp -f: process exits 0
 //
+// With -p the T message comes first and is acked like the C message.
+//
 // And the way it works in sink mode (-t):
 //
+//  client: Tmtime 0 atime 0\n (with -p)
+//  scp -t: 0
 //  client: Cmmmm <length> <filename>\n
 //  client: DATA (length bytes)
 //  client: 0
@@ -46,25 +53,64 @@ package scpsync
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
+var (
+	// ErrNoChecksum is returned when the remote host has no way of
+	// checksumming a file (sha256sum or shasum)
+	ErrNoChecksum = errors.New("remote has no sha256sum or shasum")
+	// ErrChecksumMismatch is returned when a file sent to the remote does not
+	// have the same checksum as what was sent
+	ErrChecksumMismatch = errors.New("remote file checksum does not match what was sent")
+
+	// errNoExec is returned when the remote can't run a command for us
+	// (scp is missing, or the account is sftp only)
+	errNoExec = errors.New("remote could not run command")
+)
+
 type readWriter struct {
 	io.Reader
 	io.Writer
 }
 
+// Conn transfers files over an existing ssh connection. scp is used when
+// the remote can run it, otherwise the sftp subsystem is.
+//
+// Every method leaves the connection open unless the context is done before
+// it finishes, then the connection is closed to interrupt it.
+type Conn struct {
+	Client *ssh.Client
+	// SCPPath is the scp binary to run on the remote, if empty scp is
+	// found in the remote's PATH
+	SCPPath string
+	// RateLimit is the most bytes per second to transfer, 0 is unlimited
+	RateLimit int
+}
+
 // Recv connects to host:port via tcp with a given client configuration
 // and uses scp to download the file contents from the remote host.
 func Recv(hostport string, config *ssh.ClientConfig, filename string) (content []byte, err error) {
-	client, err := ssh.Dial("tcp", hostport, config)
+	return RecvContext(context.Background(), hostport, config, filename)
+}
+
+// RecvContext is Recv but gives up on connecting or downloading when the
+// context is done.
+func RecvContext(ctx context.Context, hostport string, config *ssh.ClientConfig, filename string) (content []byte, err error) {
+	client, err := DialContext(ctx, hostport, config)
 	if err != nil {
 		return nil, err
 	}
@@ -72,7 +118,7 @@ func Recv(hostport string, config *ssh.ClientConfig, filename string) (content [
 	// Make sure we close the client connection
 	defer func() {
 		closeErr := client.Close()
-		if closeErr != nil {
+		if closeErr != nil && ctx.Err() == nil {
 			if err != nil {
 				err = fmt.Errorf("%w, and failed to close ssh connection: %w", err, closeErr)
 			} else {
@@ -81,50 +127,78 @@ func Recv(hostport string, config *ssh.ClientConfig, filename string) (content [
 		}
 	}()
 
-	session, err := client.NewSession()
+	file, err := Conn{Client: client}.Recv(ctx, filename)
+	return file.Contents, err
+}
+
+// Recv downloads the file from the remote host along with its mode and
+// timestamps.
+func (c Conn) Recv(ctx context.Context, filename string) (file File, err error) {
+	err = watch(ctx, c.Client.Close, func() error {
+		file, err = c.recvSCP(filename)
+		if err == errNoExec {
+			file, err = c.recvSFTP(filename)
+		}
+		return err
+	})
+
+	return file, err
+}
+
+func (c Conn) recvSCP(filename string) (file File, err error) {
+	session, err := c.Client.NewSession()
 	if err != nil {
-		return nil, err
+		return file, err
 	}
 
 	write, err := session.StdinPipe()
 	if err != nil {
-		return nil, err
+		return file, err
 	}
 	read, err := session.StdoutPipe()
 	if err != nil {
-		return nil, err
+		return file, err
 	}
 
-	stream := readWriter{Reader: read, Writer: write}
+	r, w := c.throttled(read, write)
+	stream := readWriter{Reader: r, Writer: w}
 
-	if err = session.Start("scp -qf " + filename); err != nil {
-		return nil, err
+	if err = session.Start(c.scp() + " -qpf " + filename); err != nil {
+		return file, errNoExec
 	}
 
-	var file scpFile
 	file, err = readFile(stream)
 	if err != nil {
-		return nil, err
+		_ = write.Close()
+		if isCommandMissing(session.Wait()) {
+			return file, errNoExec
+		}
+		return file, err
 	}
 
 	if err = write.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close write stream: %w", err)
+		return file, fmt.Errorf("failed to close write stream: %w", err)
 	}
 
 	if err = session.Wait(); err != nil {
-		return nil, fmt.Errorf("failed to wait for scp: %w", err)
+		return file, fmt.Errorf("failed to wait for scp: %w", err)
 	}
 
-	// Set this so the defer can nil it
-	content = file.Contents
-	return content, err
+	return file, nil
 }
 
 // Send connects to host:port via tcp with a given client configuration
 // and uses scp to write the file contents to the remote host to 'filename' with
-// the given mode. As per SCP semantics, the mode is ignored if the file exists.
+// the given mode. The file is replaced (see Conn.Send) so the mode applies
+// even if the file exists.
 func Send(hostport string, config *ssh.ClientConfig, filename string, mode int, contents []byte) (err error) {
-	client, err := ssh.Dial("tcp", hostport, config)
+	return SendContext(context.Background(), hostport, config, filename, mode, contents)
+}
+
+// SendContext is Send but gives up on connecting or uploading when the
+// context is done.
+func SendContext(ctx context.Context, hostport string, config *ssh.ClientConfig, filename string, mode int, contents []byte) (err error) {
+	client, err := DialContext(ctx, hostport, config)
 	if err != nil {
 		return err
 	}
@@ -132,7 +206,7 @@ func Send(hostport string, config *ssh.ClientConfig, filename string, mode int,
 	// Make sure we close the client connection
 	defer func() {
 		closeErr := client.Close()
-		if closeErr != nil {
+		if closeErr != nil && ctx.Err() == nil {
 			if err != nil {
 				err = fmt.Errorf("%w, and failed to close ssh connection: %w", err, closeErr)
 			} else {
@@ -141,7 +215,64 @@ func Send(hostport string, config *ssh.ClientConfig, filename string, mode int,
 		}
 	}()
 
-	session, err := client.NewSession()
+	return Conn{Client: client}.Send(ctx, filename, mode, time.Time{}, contents)
+}
+
+// Send writes the file contents to the remote host. If modTime is not zero
+// the remote file's modification and access times are set to it.
+//
+// The contents are written to a temporary file next to filename which is
+// then moved over it so an interrupted upload never leaves a partial file
+// behind in its place. Before it's moved the temporary file's checksum is
+// compared to the contents' (when the remote can checksum files) and
+// ErrChecksumMismatch is returned if they differ.
+//
+// The temporary file is named after the contents so when an upload is
+// interrupted the next Send of the same contents (a retry) picks up where
+// it left off using sftp, which unlike scp can write at an offset.
+func (c Conn) Send(ctx context.Context, filename string, mode int, modTime time.Time, contents []byte) error {
+	tmp := tempName(filename, contents)
+
+	return watch(ctx, c.Client.Close, func() error {
+		if off := c.partial(tmp, len(contents)); off > 0 {
+			return c.sendSFTP(filename, tmp, mode, modTime, contents, off)
+		}
+
+		// Temporary files of uploads that were never retried are left
+		// behind, best effort since the remote may not run commands
+		_ = run(c.Client, "find", fmt.Sprintf(staleTempCmd, shellQuote(path.Dir(tmp)), shellQuote(path.Base(filename))))
+
+		err := c.sendSCP(tmp, mode, modTime, contents)
+		if err == errNoExec {
+			return c.sendSFTP(filename, tmp, mode, modTime, contents, 0)
+		} else if err != nil {
+			// What made it is kept for the next attempt to resume from
+			return err
+		}
+
+		sum, err := checksum(c.Client, tmp)
+		if err != nil && err != ErrNoChecksum {
+			_ = run(c.Client, "rm", "rm -f "+shellQuote(tmp))
+			return err
+		} else if err == nil {
+			if want := sha256.Sum256(contents); !bytes.Equal(sum, want[:]) {
+				_ = run(c.Client, "rm", "rm -f "+shellQuote(tmp))
+				return fmt.Errorf("%s: %w", filename, ErrChecksumMismatch)
+			}
+		}
+
+		if err := run(c.Client, "mv", "mv -f "+shellQuote(tmp)+" "+shellQuote(filename)); err != nil {
+			_ = run(c.Client, "rm", "rm -f "+shellQuote(tmp))
+			return err
+		}
+
+		return nil
+	})
+}
+
+// sendSCP uses scp to write the file contents to filename
+func (c Conn) sendSCP(filename string, mode int, modTime time.Time, contents []byte) error {
+	session, err := c.Client.NewSession()
 	if err != nil {
 		return err
 	}
@@ -155,13 +286,22 @@ func Send(hostport string, config *ssh.ClientConfig, filename string, mode int,
 		return err
 	}
 
-	stream := readWriter{Reader: read, Writer: write}
-	if err = session.Start("scp -qt " + filename); err != nil {
-		return err
+	r, w := c.throttled(read, write)
+	stream := readWriter{Reader: r, Writer: w}
+	flags := " -qt "
+	if !modTime.IsZero() {
+		flags = " -qpt "
+	}
+	if err = session.Start(c.scp() + flags + filename); err != nil {
+		return errNoExec
 	}
 
-	err = sendFile(stream, bytes.NewReader(contents), filename, int64(len(contents)), mode)
+	err = sendFile(stream, bytes.NewReader(contents), filename, int64(len(contents)), mode, modTime)
 	if err != nil {
+		_ = write.Close()
+		if isCommandMissing(session.Wait()) {
+			return errNoExec
+		}
 		return err
 	}
 
@@ -173,15 +313,239 @@ func Send(hostport string, config *ssh.ClientConfig, filename string, mode int,
 		return fmt.Errorf("failed to wait for scp: %w", err)
 	}
 
-	// Always return err so defer can change it if it's nil
+	return nil
+}
+
+func (c Conn) scp() string {
+	if len(c.SCPPath) == 0 {
+		return "scp"
+	}
+	return shellQuote(c.SCPPath)
+}
+
+// isCommandMissing checks if the error is the shell telling us that the
+// command couldn't be found or run
+func isCommandMissing(err error) bool {
+	exitErr, ok := err.(*ssh.ExitError)
+	return ok && (exitErr.ExitStatus() == 126 || exitErr.ExitStatus() == 127)
+}
+
+// Checksum returns the sha256 checksum of a remote file. It relies on the
+// remote having sha256sum or shasum, ErrNoChecksum is returned if it has
+// neither.
+func (c Conn) Checksum(ctx context.Context, filename string) (sum []byte, err error) {
+	err = watch(ctx, c.Client.Close, func() error {
+		sum, err = checksum(c.Client, filename)
+		return err
+	})
+	return sum, err
+}
+
+// checksumCmd reads the file on stdin so the output is only the checksum
+const checksumCmd = `if command -v sha256sum >/dev/null 2>&1; then sha256sum < %[1]s; ` +
+	`elif command -v shasum >/dev/null 2>&1; then shasum -a 256 < %[1]s; ` +
+	`else exit 127; fi`
+
+func checksum(client *ssh.Client, filename string) ([]byte, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := session.Output(fmt.Sprintf(checksumCmd, shellQuote(filename)))
+	if exitErr, ok := err.(*ssh.ExitError); ok && exitErr.ExitStatus() == 127 {
+		return nil, ErrNoChecksum
+	} else if err != nil {
+		return nil, fmt.Errorf("checksum failed: %w", err)
+	}
+
+	return parseChecksum(out)
+}
+
+// parseChecksum parses the output of sha256sum/shasum: <hex>  <filename>
+func parseChecksum(out []byte) ([]byte, error) {
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return nil, errors.New("checksum output was empty")
+	}
+
+	sum, err := hex.DecodeString(fields[0])
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("checksum output was not a sha256 sum: %q", fields[0])
+	}
+
+	return sum, nil
+}
+
+// tempName creates a hidden name in the same directory as filename so that
+// moving it over filename is a rename on the same filesystem. It's unique to
+// the contents so a partial upload can only ever be resumed with the rest of
+// the same contents.
+func tempName(filename string, contents []byte) string {
+	sum := sha256.Sum256(contents)
+
+	dir, base := path.Split(filename)
+	return fmt.Sprintf("%s.%s.tmp-%x", dir, base, sum[:6])
+}
+
+// staleTempCmd removes temporary files of a file that haven't been written
+// to in an hour, they're from uploads that died and were never resumed
+const staleTempCmd = `find %[1]s -maxdepth 1 -name .%[2]s'.tmp-*' -mmin +60 -exec rm -f {} +`
+
+// partial finds how much of contents an earlier attempt uploaded to tmp,
+// 0 if there's nothing to resume (or sftp can't tell us)
+func (c Conn) partial(tmp string, ln int) int64 {
+	s, err := c.newSFTP()
+	if err != nil {
+		return 0
+	}
+	defer s.close()
+
+	attrs, err := s.stat(tmp)
+	if err != nil || attrs.flags&sftpAttrSize == 0 || attrs.size > uint64(ln) {
+		return 0
+	}
+
+	return int64(attrs.size)
+}
+
+// Mkdir connects to host:port via tcp with a given client configuration
+// and creates the directory dir on the remote host along with any parents
+// that are missing (mkdir -p).
+func Mkdir(hostport string, config *ssh.ClientConfig, dir string) (err error) {
+	client, err := ssh.Dial("tcp", hostport, config)
+	if err != nil {
+		return err
+	}
+
+	// Make sure we close the client connection
+	defer func() {
+		closeErr := client.Close()
+		if closeErr != nil {
+			if err != nil {
+				err = fmt.Errorf("%w, and failed to close ssh connection: %w", err, closeErr)
+			} else {
+				err = fmt.Errorf("failed to close ssh connection: %w", closeErr)
+			}
+		}
+	}()
+
+	return Conn{Client: client}.Mkdir(context.Background(), dir)
+}
+
+// Mkdir creates the directory dir and any missing parents on the remote
+// host.
+func (c Conn) Mkdir(ctx context.Context, dir string) error {
+	return watch(ctx, c.Client.Close, func() error {
+		err := run(c.Client, "mkdir", "mkdir -p "+shellQuote(dir))
+		if err == errNoExec {
+			return c.mkdirSFTP(dir)
+		}
+		return err
+	})
+}
+
+// run runs a shell command on the remote host, name is used to describe
+// the command in errors along with anything it output. errNoExec is returned
+// if the remote won't run commands at all (sftp only accounts).
+func run(client *ssh.Client, name, cmd string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+
+	out, err := session.CombinedOutput(cmd)
+	if err != nil {
+		if _, ok := err.(*ssh.ExitError); !ok {
+			return errNoExec
+		}
+		if msg := strings.TrimSpace(string(out)); len(msg) != 0 {
+			return fmt.Errorf("%s failed: %w (%s)", name, err, msg)
+		}
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+
+	return nil
+}
+
+// DialContext connects to host:port via tcp with a given client
+// configuration. The context limits both connecting and the ssh handshake,
+// config.Timeout still applies to connecting as well.
+func DialContext(ctx context.Context, hostport string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	dialer := net.Dialer{Timeout: config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", hostport)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClientContext(ctx, conn, hostport, config)
+}
+
+// NewClientContext does the ssh handshake over an existing connection, for
+// example one tunneled through another ssh connection. The context limits
+// the handshake and conn is closed if it fails.
+func NewClientContext(ctx context.Context, conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	var client *ssh.Client
+	err := watch(ctx, conn.Close, func() error {
+		c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+		if err != nil {
+			return err
+		}
+		client = ssh.NewClient(c, chans, reqs)
+		return nil
+	})
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// watch runs fn and calls abort if the context is done before fn returns,
+// abort should make fn return (by closing its connection). When the context
+// is done its error is returned rather than whatever error fn returned as a
+// result of being aborted.
+func watch(ctx context.Context, abort func() error, fn func() error) error {
+	if ctx.Done() == nil {
+		return fn()
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = abort()
+		case <-done:
+		}
+	}()
+
+	err := fn()
+	close(done)
+	<-stopped
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
 	return err
 }
 
-type scpFile struct {
-	Filename string
-	Length   int64
-	Mode     int
-	Contents []byte
+// shellQuote quotes s for a posix shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// File is a file received with scp. The times are only set when the remote
+// sent them.
+type File struct {
+	Filename   string
+	Length     int64
+	Mode       int
+	ModTime    time.Time
+	AccessTime time.Time
+	Contents   []byte
 }
 
 // Err is a response error from the binary saying that something went wrong.
@@ -199,7 +563,17 @@ func (e Err) Error() string {
 	return errStr
 }
 
-func sendFile(stream io.ReadWriter, file io.Reader, filename string, ln int64, mode int) error {
+func sendFile(stream io.ReadWriter, file io.Reader, filename string, ln int64, mode int, modTime time.Time) error {
+	if !modTime.IsZero() {
+		unix := modTime.Unix()
+		if _, err := fmt.Fprintf(stream, "T%d 0 %d 0\n", unix, unix); err != nil {
+			return fmt.Errorf("failed to send times message: %w", err)
+		}
+		if err := readResponse(stream); err != nil {
+			return err
+		}
+	}
+
 	// Send header
 	_, err := fmt.Fprintf(stream, "C0%o %d %s\n", mode, ln, filepath.Base(filename))
 	if err != nil {
@@ -222,7 +596,7 @@ func sendFile(stream io.ReadWriter, file io.Reader, filename string, ln int64, m
 	return readResponse(stream)
 }
 
-func readFile(stream io.ReadWriter) (file scpFile, err error) {
+func readFile(stream io.ReadWriter) (file File, err error) {
 	// First 0 byte acknowledges the beginning of the transfer (why????)
 	if err = sendOKResponse(stream); err != nil {
 		return file, err
@@ -236,6 +610,25 @@ func readFile(stream io.ReadWriter) (file scpFile, err error) {
 		return file, errors.New("empty request")
 	}
 
+	if str[0] == 'T' {
+		file.ModTime, file.AccessTime, err = parseTimes(str[1:])
+		if err != nil {
+			return file, err
+		}
+
+		// Acknowledge the times, the file header follows
+		if err = sendOKResponse(stream); err != nil {
+			return file, err
+		}
+
+		str, err = reader.ReadString('\n')
+		if err != nil {
+			return file, fmt.Errorf("failed to read file header: %w", err)
+		} else if len(str) == 0 {
+			return file, errors.New("empty request")
+		}
+	}
+
 	switch str[0] {
 	case 'C':
 		// This is a happy case, let it go
@@ -292,6 +685,24 @@ func readFile(stream io.ReadWriter) (file scpFile, err error) {
 	return file, nil
 }
 
+// parseTimes parses the body of a T message: mtime 0 atime 0
+func parseTimes(str string) (mtime, atime time.Time, err error) {
+	fields := strings.Fields(str)
+	if len(fields) != 4 {
+		return mtime, atime, fmt.Errorf("times message demands 4 fields, got %d", len(fields))
+	}
+
+	var secs [2]int64
+	for i, f := range []string{fields[0], fields[2]} {
+		secs[i], err = strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return mtime, atime, fmt.Errorf("failed to parse the time: %q (%w)", f, err)
+		}
+	}
+
+	return time.Unix(secs[0], 0), time.Unix(secs[1], 0), nil
+}
+
 func sendOKResponse(stream io.Writer) error {
 	_, err := stream.Write([]byte{0})
 	return err
@@ -325,11 +736,13 @@ func readResponse(stream io.Reader) error {
 // IsNotFoundErr checks to see if the error was a file not found error
 // from the server.
 func IsNotFoundErr(err error) bool {
-	e, ok := err.(Err)
-	if !ok {
-		return false
+	switch e := err.(type) {
+	case Err:
+		return e.Code == 1 &&
+			strings.Contains(strings.ToLower(e.Msg), "no such file or directory")
+	case SFTPErr:
+		return e.Code == sftpStatusNoSuchFile
 	}
 
-	return e.Code == 1 &&
-		strings.Contains(strings.ToLower(e.Msg), "no such file or directory")
+	return false
 }
@@ -2,7 +2,10 @@ package scpsync
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
@@ -152,6 +155,145 @@ func TestRecv(t *testing.T) {
 	}
 }
 
+func TestMkdir(t *testing.T) {
+	// No t.Parallel(), sshd will bind to same port
+
+	if testing.Short() {
+		t.Skip("short skipping sshd test")
+	}
+
+	tmp, err := ioutil.TempDir("", "scpmkdirtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	sshd := testSSHDServerCommand(t)
+	if testing.Verbose() {
+		sshd.Stderr = os.Stderr
+		sshd.Stdout = os.Stdout
+	}
+
+	if err := sshd.Start(); err != nil {
+		t.Fatal("failed to start ssh:", err)
+	}
+
+	// We have to wait for the sshd server to get up and running, could
+	// poll on the port but I'm lazy
+	time.Sleep(3 * time.Second)
+
+	dir := filepath.Join(tmp, "a b", "it's")
+	config := testSSHClientConfig(t)
+	if err = Mkdir("127.0.0.1:22222", config, dir); err != nil {
+		t.Error(err)
+	}
+
+	if err := sshd.Process.Kill(); err != nil {
+		t.Fatal("failed to kill ssh")
+	}
+
+	// We expect it to die and exit 1, we just want to know it's done
+	_ = sshd.Wait()
+
+	if stat, err := os.Stat(dir); err != nil {
+		t.Error(err)
+	} else if !stat.IsDir() {
+		t.Error("expected a directory")
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		In, Out string
+	}{
+		{"dir", `'dir'`},
+		{"a b", `'a b'`},
+		{"it's", `'it'\''s'`},
+	}
+
+	for i, test := range tests {
+		if got := shellQuote(test.In); got != test.Out {
+			t.Errorf("%d) want: %s, got: %s", i, test.Out, got)
+		}
+	}
+}
+
+func TestTempName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		In, Prefix string
+	}{
+		{"file.blob", ".file.blob.tmp-"},
+		{"dir/file.blob", "dir/.file.blob.tmp-"},
+		{"/abs/dir/file.blob", "/abs/dir/.file.blob.tmp-"},
+	}
+
+	for i, test := range tests {
+		got := tempName(test.In, []byte("contents"))
+		if !strings.HasPrefix(got, test.Prefix) || len(got) != len(test.Prefix)+12 {
+			t.Errorf("%d) want: %sXXXXXXXXXXXX, got: %s", i, test.Prefix, got)
+		}
+	}
+
+	if tempName("file", []byte("a")) != tempName("file", []byte("a")) {
+		t.Error("temp names should be the same for the same contents")
+	}
+	if tempName("file", []byte("a")) == tempName("file", []byte("b")) {
+		t.Error("temp names should differ for different contents")
+	}
+}
+
+func TestParseChecksum(t *testing.T) {
+	t.Parallel()
+
+	want := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	for _, out := range []string{want + "  -\n", want + "  file.blob\n"} {
+		sum, err := parseChecksum([]byte(out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprintf("%x", sum); got != want {
+			t.Errorf("want: %s, got: %s", want, got)
+		}
+	}
+
+	for _, out := range []string{"", "abcd  -\n", "nothex  -\n"} {
+		if _, err := parseChecksum([]byte(out)); err == nil {
+			t.Errorf("want an error for %q", out)
+		}
+	}
+}
+
+func TestChecksumCmd(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh to run the checksum command with")
+	}
+
+	cmd := fmt.Sprintf(checksumCmd, shellQuote("testdata/sshd_config"))
+	out, err := exec.Command("sh", "-c", cmd).Output()
+	if err != nil {
+		t.Skip("no checksum tool:", err)
+	}
+
+	b, err := ioutil.ReadFile("testdata/sshd_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum, err := parseChecksum(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sha256.Sum256(b); !bytes.Equal(sum, want[:]) {
+		t.Errorf("want: %x, got: %x", want, sum)
+	}
+}
+
 func testSSHClientConfig(t *testing.T) *ssh.ClientConfig {
 	t.Helper()
 
@@ -269,7 +411,7 @@ func TestSendFileProcess(t *testing.T) {
 	go func() {
 		err := sendFile(
 			stream, strings.NewReader(payload),
-			"whocares", int64(len(payload)), 0644)
+			"whocares", int64(len(payload)), 0644, time.Time{})
 		if err != nil {
 			t.Error(err)
 		}
@@ -323,7 +465,9 @@ func TestRecvFileProcess(t *testing.T) {
 
 		b, err := ioutil.ReadFile("scpsync_test.go")
 		if err != nil {
-			t.Fatal(err)
+			t.Error(err)
+			close(waiter)
+			return
 		}
 
 		if file.Filename != "scpsync_test.go" {
@@ -355,3 +499,198 @@ func TestRecvFileProcess(t *testing.T) {
 	// Wait for our goroutine before leaving the test
 	<-waiter
 }
+
+func TestSendFileTimesProcess(t *testing.T) {
+	t.Parallel()
+
+	payload := "test"
+	modTime := time.Unix(1500000000, 0)
+
+	tmp, err := ioutil.TempFile("", "scpsendtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	scpCmd := exec.Command("scp", "-qpt", tmp.Name())
+	writePipe, err := scpCmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	readPipe, err := scpCmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := readWriter{readPipe, writePipe}
+
+	if err = scpCmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	waiter := make(chan struct{})
+	go func() {
+		err := sendFile(
+			stream, strings.NewReader(payload),
+			"whocares", int64(len(payload)), 0644, modTime)
+		if err != nil {
+			t.Error(err)
+		}
+		if err = writePipe.Close(); err != nil {
+			t.Error(err)
+		}
+
+		close(waiter)
+	}()
+
+	if err = scpCmd.Wait(); err != nil {
+		t.Error(err)
+	}
+	<-waiter
+
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("mod time want: %v, got: %v", modTime, info.ModTime())
+	}
+}
+
+func TestRecvFileTimesProcess(t *testing.T) {
+	t.Parallel()
+
+	info, err := os.Stat("scpsync_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scpCmd := exec.Command("scp", "-qpf", "scpsync_test.go")
+	writePipe, err := scpCmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	readPipe, err := scpCmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := readWriter{readPipe, writePipe}
+
+	if err = scpCmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	waiter := make(chan struct{})
+	go func() {
+		defer close(waiter)
+
+		file, err := readFile(stream)
+		if err != nil {
+			t.Error(err)
+		}
+		if err = writePipe.Close(); err != nil {
+			t.Error(err)
+		}
+
+		if want := info.ModTime().Unix(); file.ModTime.Unix() != want {
+			t.Errorf("mod time want: %d, got: %d", want, file.ModTime.Unix())
+		}
+		if file.AccessTime.IsZero() {
+			t.Error("access time was not set")
+		}
+		if int64(len(file.Contents)) != info.Size() {
+			t.Errorf("contents want %d bytes, got: %d", info.Size(), len(file.Contents))
+		}
+	}()
+
+	if err = scpCmd.Wait(); err != nil {
+		t.Error(err)
+	}
+	<-waiter
+}
+
+func TestParseTimes(t *testing.T) {
+	t.Parallel()
+
+	mtime, atime, err := parseTimes("1500000000 0 1600000000 0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mtime.Unix() != 1500000000 || atime.Unix() != 1600000000 {
+		t.Error("times were wrong:", mtime, atime)
+	}
+
+	if _, _, err = parseTimes("1500000000 0\n"); err == nil {
+		t.Error("want an error for missing fields")
+	}
+	if _, _, err = parseTimes("abc 0 1 0\n"); err == nil {
+		t.Error("want an error for bad times")
+	}
+}
+
+func TestDialContextTimeout(t *testing.T) {
+	t.Parallel()
+
+	// A server that accepts connections but never says anything, like a
+	// remote that has died part way
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = ioutil.ReadAll(conn)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	config := &ssh.ClientConfig{
+		User:            "user",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+
+	start := time.Now()
+	_, err = RecvContext(ctx, ln.Addr().String(), config, "file")
+	if err != context.DeadlineExceeded {
+		t.Error("want deadline exceeded, got:", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Error("took too long to give up:", elapsed)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	aborted := make(chan struct{})
+	err := watch(ctx, func() error { close(aborted); return nil }, func() error {
+		// Hang like a dead transfer until aborted
+		select {
+		case <-aborted:
+		case <-time.After(5 * time.Second):
+			t.Error("abort was not called")
+		}
+		return io.EOF
+	})
+	if err != context.Canceled {
+		t.Error("want canceled error, got:", err)
+	}
+
+	called := false
+	err = watch(context.Background(), nil, func() error { called = true; return nil })
+	if err != nil || !called {
+		t.Error("fn should run as is without a context that can be done")
+	}
+}
@@ -0,0 +1,588 @@
+package scpsync
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// This is the bare minimum of sftp (version 3, draft-ietf-secsh-filexfer-02)
+// needed to do what we do with scp for servers that can't run scp. Requests
+// are made one at a time and every packet looks like:
+//
+//  uint32 length, byte type, uint32 request-id (except init/version), data
+//
+// Strings are a uint32 length followed by the bytes.
+
+// sftp packet types
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpWrite    = 6
+	sftpFstat    = 8
+	sftpSetstat  = 9
+	sftpRemove   = 13
+	sftpMkdir    = 14
+	sftpStat     = 17
+	sftpRename   = 18
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpAttrs    = 105
+	sftpExtended = 200
+)
+
+const (
+	sftpFlagRead  = 0x01
+	sftpFlagWrite = 0x02
+	sftpFlagCreat = 0x08
+	sftpFlagTrunc = 0x10
+
+	sftpAttrSize        = 0x01
+	sftpAttrUIDGID      = 0x02
+	sftpAttrPermissions = 0x04
+	sftpAttrACModTime   = 0x08
+	sftpAttrExtended    = 0x80000000
+
+	sftpStatusOK         = 0
+	sftpStatusEOF        = 1
+	sftpStatusNoSuchFile = 2
+
+	// sftpChunk is how much is read or written per request, servers must
+	// accept at least 32k
+	sftpChunk = 32768
+	// sftpMaxPacket guards against allocating whatever a broken server
+	// claims a packet's length is
+	sftpMaxPacket = 256 * 1024
+
+	// sftpPosixRename is the openssh extension for a rename that replaces
+	// the target, plain sftp renames fail if the target exists
+	sftpPosixRename = "posix-rename@openssh.com"
+)
+
+var errSFTPShort = errors.New("sftp packet was too short")
+
+// SFTPErr is a status error from the sftp server
+type SFTPErr struct {
+	Code uint32
+	Msg  string
+}
+
+// Error interface
+func (e SFTPErr) Error() string {
+	errStr := fmt.Sprintf("sftp error code %d", e.Code)
+	if len(e.Msg) != 0 {
+		errStr += " (" + e.Msg + ")"
+	}
+	return errStr
+}
+
+type sftpFileAttrs struct {
+	flags        uint32
+	size         uint64
+	perm         uint32
+	atime, mtime uint32
+}
+
+type sftpClient struct {
+	w     io.Writer
+	r     io.Reader
+	close func() error
+
+	id   uint32
+	exts map[string]string
+}
+
+// newSFTP starts the sftp subsystem on the remote host
+func (c Conn) newSFTP() (*sftpClient, error) {
+	session, err := c.Client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = session.RequestSubsystem("sftp"); err != nil {
+		_ = session.Close()
+		return nil, fmt.Errorf("failed to start sftp: %w", err)
+	}
+
+	tr, tw := c.throttled(r, w)
+	s, err := initSFTP(tw, tr, func() error {
+		_ = w.Close()
+		return session.Close()
+	})
+	if err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// initSFTP does the version exchange with an sftp server
+func initSFTP(w io.Writer, r io.Reader, close func() error) (*sftpClient, error) {
+	s := &sftpClient{w: w, r: r, close: close, exts: make(map[string]string)}
+
+	if err := s.writePacket(sftpInit, sftpPacket(nil).u32(3)); err != nil {
+		return nil, err
+	}
+
+	typ, data, err := s.readPacket()
+	if err != nil {
+		return nil, err
+	} else if typ != sftpVersion {
+		return nil, fmt.Errorf("want sftp version packet, got type %d", typ)
+	}
+
+	buf := &sftpReader{b: data}
+	buf.u32()
+	for len(buf.b) != 0 && buf.err == nil {
+		name := buf.str()
+		s.exts[name] = buf.str()
+	}
+
+	return s, buf.err
+}
+
+// recv downloads a file like recvSCP
+func (s *sftpClient) recv(filename string) (file File, err error) {
+	contents, attrs, err := s.readAll(filename)
+	if err != nil {
+		return file, err
+	}
+
+	file.Filename = path.Base(filename)
+	file.Length = int64(len(contents))
+	file.Mode = int(attrs.perm & 0777)
+	file.Contents = contents
+	if attrs.flags&sftpAttrACModTime != 0 {
+		file.ModTime = time.Unix(int64(attrs.mtime), 0)
+		file.AccessTime = time.Unix(int64(attrs.atime), 0)
+	}
+
+	return file, nil
+}
+
+// send uploads a file like Conn.Send: to tmp first which is read back to
+// verify it and then renamed over filename
+func (s *sftpClient) send(filename, tmp string, mode int, modTime time.Time, contents []byte, off int64) error {
+	// What made it is kept for the next attempt to resume from
+	if err := s.writeFrom(tmp, uint32(mode), contents, off); err != nil {
+		return err
+	}
+	if !modTime.IsZero() {
+		if err := s.setTimes(tmp, modTime); err != nil {
+			return err
+		}
+	}
+
+	sent, _, err := s.readAll(tmp)
+	if err == nil && !bytes.Equal(sent, contents) {
+		// Resuming this would only make the same mess
+		_ = s.remove(tmp)
+		return fmt.Errorf("%s: %w", filename, ErrChecksumMismatch)
+	} else if err != nil {
+		return err
+	}
+
+	return s.rename(tmp, filename)
+}
+
+// mkdirAll creates dir and any missing parents (mkdir -p)
+func (s *sftpClient) mkdirAll(dir string) error {
+	cur := ""
+	if strings.HasPrefix(dir, "/") {
+		cur = "/"
+	}
+
+	for _, part := range strings.Split(dir, "/") {
+		if len(part) == 0 {
+			continue
+		}
+		cur = path.Join(cur, part)
+
+		_, err := s.stat(cur)
+		if err == nil {
+			continue
+		} else if !IsNotFoundErr(err) {
+			return err
+		}
+
+		if err = s.mkdir(cur, 0700); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *sftpClient) readAll(filename string) (contents []byte, attrs sftpFileAttrs, err error) {
+	handle, err := s.open(filename, sftpFlagRead, 0)
+	if err != nil {
+		return nil, attrs, err
+	}
+	defer s.closeHandle(handle)
+
+	attrs, err = s.fstat(handle)
+	if err != nil {
+		return nil, attrs, err
+	}
+
+	for {
+		data, err := s.read(handle, uint64(len(contents)), sftpChunk)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, attrs, err
+		}
+		contents = append(contents, data...)
+	}
+
+	return contents, attrs, nil
+}
+
+// writeFrom writes contents to filename starting at off, the file is
+// truncated when starting from the beginning. Writes are made in order so
+// if this fails part way the file is always a prefix of contents.
+func (s *sftpClient) writeFrom(filename string, perm uint32, contents []byte, off int64) error {
+	flags := uint32(sftpFlagWrite | sftpFlagCreat)
+	if off == 0 {
+		flags |= sftpFlagTrunc
+	}
+
+	handle, err := s.open(filename, flags, perm)
+	if err != nil {
+		return err
+	}
+
+	for off := int(off); off < len(contents); off += sftpChunk {
+		end := off + sftpChunk
+		if end > len(contents) {
+			end = len(contents)
+		}
+
+		if err = s.write(handle, uint64(off), contents[off:end]); err != nil {
+			_ = s.closeHandle(handle)
+			return err
+		}
+	}
+
+	// Errors writing can show up on close
+	return s.closeHandle(handle)
+}
+
+func (s *sftpClient) open(filename string, flags, perm uint32) (string, error) {
+	p := sftpPacket(nil).str(filename).u32(flags)
+	if perm != 0 {
+		p = p.u32(sftpAttrPermissions).u32(perm)
+	} else {
+		p = p.u32(0)
+	}
+
+	typ, r, err := s.request(sftpOpen, p)
+	if err != nil {
+		return "", err
+	}
+	if err = expect(sftpHandle, typ, r); err != nil {
+		return "", err
+	}
+
+	handle := r.str()
+	return handle, r.err
+}
+
+func (s *sftpClient) closeHandle(handle string) error {
+	return s.statusRequest(sftpClose, sftpPacket(nil).str(handle))
+}
+
+func (s *sftpClient) read(handle string, off uint64, n uint32) ([]byte, error) {
+	typ, r, err := s.request(sftpRead, sftpPacket(nil).str(handle).u64(off).u32(n))
+	if err != nil {
+		return nil, err
+	}
+
+	if typ == sftpStatus {
+		if err = status(r); err == nil {
+			err = errors.New("sftp read returned no data")
+		} else if e, ok := err.(SFTPErr); ok && e.Code == sftpStatusEOF {
+			err = io.EOF
+		}
+		return nil, err
+	}
+	if err = expect(sftpData, typ, r); err != nil {
+		return nil, err
+	}
+
+	data := r.str()
+	return []byte(data), r.err
+}
+
+func (s *sftpClient) write(handle string, off uint64, data []byte) error {
+	return s.statusRequest(sftpWrite, sftpPacket(nil).str(handle).u64(off).str(string(data)))
+}
+
+func (s *sftpClient) fstat(handle string) (sftpFileAttrs, error) {
+	return s.attrsRequest(sftpFstat, sftpPacket(nil).str(handle))
+}
+
+func (s *sftpClient) stat(filename string) (sftpFileAttrs, error) {
+	return s.attrsRequest(sftpStat, sftpPacket(nil).str(filename))
+}
+
+func (s *sftpClient) setTimes(filename string, t time.Time) error {
+	unix := uint32(t.Unix())
+	return s.statusRequest(sftpSetstat, sftpPacket(nil).str(filename).u32(sftpAttrACModTime).u32(unix).u32(unix))
+}
+
+func (s *sftpClient) remove(filename string) error {
+	return s.statusRequest(sftpRemove, sftpPacket(nil).str(filename))
+}
+
+func (s *sftpClient) mkdir(dir string, perm uint32) error {
+	return s.statusRequest(sftpMkdir, sftpPacket(nil).str(dir).u32(sftpAttrPermissions).u32(perm))
+}
+
+// rename moves from over to, replacing it
+func (s *sftpClient) rename(from, to string) error {
+	if _, ok := s.exts[sftpPosixRename]; ok {
+		return s.statusRequest(sftpExtended, sftpPacket(nil).str(sftpPosixRename).str(from).str(to))
+	}
+
+	// Without the extension there's a moment where to doesn't exist
+	if err := s.remove(to); err != nil && !IsNotFoundErr(err) {
+		return err
+	}
+	return s.statusRequest(sftpRename, sftpPacket(nil).str(from).str(to))
+}
+
+func (s *sftpClient) attrsRequest(typ byte, p sftpPacket) (attrs sftpFileAttrs, err error) {
+	respTyp, r, err := s.request(typ, p)
+	if err != nil {
+		return attrs, err
+	}
+	if err = expect(sftpAttrs, respTyp, r); err != nil {
+		return attrs, err
+	}
+
+	return parseAttrs(r)
+}
+
+func (s *sftpClient) statusRequest(typ byte, p sftpPacket) error {
+	respTyp, r, err := s.request(typ, p)
+	if err != nil {
+		return err
+	}
+	if respTyp != sftpStatus {
+		return fmt.Errorf("want sftp status packet, got type %d", respTyp)
+	}
+
+	return status(r)
+}
+
+// request sends a request and reads its response, the response's request
+// id has already been read from r
+func (s *sftpClient) request(typ byte, p sftpPacket) (byte, *sftpReader, error) {
+	s.id++
+	id := s.id
+
+	if err := s.writePacket(typ, sftpPacket(nil).u32(id).bytes(p)); err != nil {
+		return 0, nil, err
+	}
+
+	respTyp, data, err := s.readPacket()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	r := &sftpReader{b: data}
+	if respID := r.u32(); r.err != nil {
+		return 0, nil, r.err
+	} else if respID != id {
+		return 0, nil, fmt.Errorf("sftp response was for request %d, want %d", respID, id)
+	}
+
+	return respTyp, r, nil
+}
+
+func (s *sftpClient) writePacket(typ byte, p sftpPacket) error {
+	out := sftpPacket(nil).u32(uint32(len(p) + 1))
+	out = append(out, typ)
+	out = append(out, p...)
+
+	_, err := s.w.Write(out)
+	return err
+}
+
+func (s *sftpClient) readPacket() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(s.r, header[:]); err != nil {
+		return 0, nil, fmt.Errorf("failed to read sftp packet: %w", err)
+	}
+
+	length := binary.BigEndian.Uint32(header[:4])
+	if length == 0 || length > sftpMaxPacket {
+		return 0, nil, fmt.Errorf("sftp packet length %d is invalid", length)
+	}
+
+	data := make([]byte, length-1)
+	if _, err := io.ReadFull(s.r, data); err != nil {
+		return 0, nil, fmt.Errorf("failed to read sftp packet: %w", err)
+	}
+
+	return header[4], data, nil
+}
+
+// expect checks the response is of type want, status responses are turned
+// into errors
+func expect(want, got byte, r *sftpReader) error {
+	if got == want {
+		return nil
+	}
+	if got == sftpStatus {
+		if err := status(r); err != nil {
+			return err
+		}
+	}
+
+	return fmt.Errorf("want sftp packet type %d, got %d", want, got)
+}
+
+// status turns a status response into an error, nil if it's OK
+func status(r *sftpReader) error {
+	code := r.u32()
+	msg := r.str()
+	if r.err != nil {
+		return r.err
+	}
+
+	if code == sftpStatusOK {
+		return nil
+	}
+	return SFTPErr{Code: code, Msg: msg}
+}
+
+func parseAttrs(r *sftpReader) (attrs sftpFileAttrs, err error) {
+	attrs.flags = r.u32()
+	if attrs.flags&sftpAttrSize != 0 {
+		attrs.size = r.u64()
+	}
+	if attrs.flags&sftpAttrUIDGID != 0 {
+		r.u32()
+		r.u32()
+	}
+	if attrs.flags&sftpAttrPermissions != 0 {
+		attrs.perm = r.u32()
+	}
+	if attrs.flags&sftpAttrACModTime != 0 {
+		attrs.atime = r.u32()
+		attrs.mtime = r.u32()
+	}
+	if attrs.flags&sftpAttrExtended != 0 {
+		n := r.u32()
+		for i := uint32(0); i < n && r.err == nil; i++ {
+			r.str()
+			r.str()
+		}
+	}
+
+	return attrs, r.err
+}
+
+// sftpPacket builds the data of a packet
+type sftpPacket []byte
+
+func (p sftpPacket) u32(v uint32) sftpPacket {
+	return append(p, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (p sftpPacket) u64(v uint64) sftpPacket {
+	return p.u32(uint32(v >> 32)).u32(uint32(v))
+}
+
+func (p sftpPacket) str(s string) sftpPacket {
+	return append(p.u32(uint32(len(s))), s...)
+}
+
+func (p sftpPacket) bytes(b []byte) sftpPacket {
+	return append(p, b...)
+}
+
+// sftpReader reads the data of a packet, the first error is kept and
+// everything read after it is zero
+type sftpReader struct {
+	b   []byte
+	err error
+}
+
+func (r *sftpReader) u32() uint32 {
+	if r.err != nil || len(r.b) < 4 {
+		r.err = errSFTPShort
+		return 0
+	}
+
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *sftpReader) u64() uint64 {
+	return uint64(r.u32())<<32 | uint64(r.u32())
+}
+
+func (r *sftpReader) str() string {
+	n := r.u32()
+	if r.err != nil || uint32(len(r.b)) < n {
+		r.err = errSFTPShort
+		return ""
+	}
+
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
+
+func (c Conn) recvSFTP(filename string) (File, error) {
+	s, err := c.newSFTP()
+	if err != nil {
+		return File{}, err
+	}
+	defer s.close()
+
+	return s.recv(filename)
+}
+
+func (c Conn) sendSFTP(filename, tmp string, mode int, modTime time.Time, contents []byte, off int64) error {
+	s, err := c.newSFTP()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	return s.send(filename, tmp, mode, modTime, contents, off)
+}
+
+func (c Conn) mkdirSFTP(dir string) error {
+	s, err := c.newSFTP()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	return s.mkdirAll(dir)
+}
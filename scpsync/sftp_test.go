@@ -0,0 +1,295 @@
+package scpsync
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSFTPServer is just enough of an sftp server to test the client with,
+// files are kept in memory
+type fakeSFTPServer struct {
+	mut     sync.Mutex
+	files   map[string][]byte
+	perms   map[string]uint32
+	mtimes  map[string]uint32
+	dirs    map[string]bool
+	handles map[string]string
+	// posixRename advertises the posix-rename extension
+	posixRename bool
+}
+
+func newFakeSFTP(t *testing.T, server *fakeSFTPServer) *sftpClient {
+	t.Helper()
+
+	if server.files == nil {
+		server.files = make(map[string][]byte)
+	}
+	server.perms = make(map[string]uint32)
+	server.mtimes = make(map[string]uint32)
+	server.dirs = make(map[string]bool)
+	server.handles = make(map[string]string)
+
+	client, conn := net.Pipe()
+	go server.serve(conn)
+
+	s, err := initSFTP(client, client, client.Close)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func (f *fakeSFTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		var header [5]byte
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header[:4])-1)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+
+		r := &sftpReader{b: data}
+		var resp sftpPacket
+		var typ byte
+
+		if header[4] == sftpInit {
+			typ, resp = sftpVersion, sftpPacket(nil).u32(3)
+			if f.posixRename {
+				resp = resp.str(sftpPosixRename).str("1")
+			}
+		} else {
+			id := r.u32()
+			typ, resp = f.handle(header[4], r)
+			resp = sftpPacket(nil).u32(id).bytes(resp)
+		}
+
+		out := sftpPacket(nil).u32(uint32(len(resp) + 1))
+		out = append(out, typ)
+		if _, err := conn.Write(append(out, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+func fakeStatus(code uint32) (byte, sftpPacket) {
+	return sftpStatus, sftpPacket(nil).u32(code).str("").str("")
+}
+
+func (f *fakeSFTPServer) handle(typ byte, r *sftpReader) (byte, sftpPacket) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	switch typ {
+	case sftpOpen:
+		name, flags := r.str(), r.u32()
+		attrs, _ := parseAttrs(r)
+		if _, ok := f.files[name]; !ok {
+			if flags&sftpFlagCreat == 0 {
+				return fakeStatus(sftpStatusNoSuchFile)
+			}
+			f.perms[name] = attrs.perm
+		}
+		if flags&sftpFlagTrunc != 0 || f.files[name] == nil {
+			f.files[name] = []byte{}
+		}
+		handle := "h" + name
+		f.handles[handle] = name
+		return sftpHandle, sftpPacket(nil).str(handle)
+	case sftpClose:
+		delete(f.handles, r.str())
+		return fakeStatus(sftpStatusOK)
+	case sftpRead:
+		name, off, n := f.handles[r.str()], r.u64(), r.u32()
+		file := f.files[name]
+		if off >= uint64(len(file)) {
+			return fakeStatus(sftpStatusEOF)
+		}
+		end := off + uint64(n)
+		if end > uint64(len(file)) {
+			end = uint64(len(file))
+		}
+		return sftpData, sftpPacket(nil).str(string(file[off:end]))
+	case sftpWrite:
+		name, off, data := f.handles[r.str()], r.u64(), r.str()
+		file := f.files[name]
+		for uint64(len(file)) < off+uint64(len(data)) {
+			file = append(file, 0)
+		}
+		copy(file[off:], data)
+		f.files[name] = file
+		return fakeStatus(sftpStatusOK)
+	case sftpFstat, sftpStat:
+		name := r.str()
+		if typ == sftpFstat {
+			name = f.handles[name]
+		}
+		if f.dirs[name] {
+			return sftpAttrs, sftpPacket(nil).u32(sftpAttrPermissions).u32(040700)
+		}
+		file, ok := f.files[name]
+		if !ok {
+			return fakeStatus(sftpStatusNoSuchFile)
+		}
+		return sftpAttrs, sftpPacket(nil).u32(sftpAttrSize|sftpAttrPermissions|sftpAttrACModTime).
+			u64(uint64(len(file))).u32(f.perms[name]).u32(f.mtimes[name]).u32(f.mtimes[name])
+	case sftpSetstat:
+		name := r.str()
+		attrs, _ := parseAttrs(r)
+		f.mtimes[name] = attrs.mtime
+		return fakeStatus(sftpStatusOK)
+	case sftpRemove:
+		name := r.str()
+		if _, ok := f.files[name]; !ok {
+			return fakeStatus(sftpStatusNoSuchFile)
+		}
+		delete(f.files, name)
+		return fakeStatus(sftpStatusOK)
+	case sftpMkdir:
+		f.dirs[r.str()] = true
+		return fakeStatus(sftpStatusOK)
+	case sftpRename, sftpExtended:
+		if typ == sftpExtended && r.str() != sftpPosixRename {
+			return fakeStatus(8)
+		}
+		from, to := r.str(), r.str()
+		if _, ok := f.files[to]; ok && typ == sftpRename {
+			// Like openssh without the extension
+			return fakeStatus(4)
+		}
+		f.files[to], f.perms[to], f.mtimes[to] = f.files[from], f.perms[from], f.mtimes[from]
+		delete(f.files, from)
+		return fakeStatus(sftpStatusOK)
+	}
+
+	return fakeStatus(8)
+}
+
+func TestSFTPSendRecv(t *testing.T) {
+	t.Parallel()
+
+	for _, posixRename := range []bool{true, false} {
+		server := &fakeSFTPServer{posixRename: posixRename}
+		s := newFakeSFTP(t, server)
+		defer s.close()
+
+		// Bigger than a chunk to make sure it's split up
+		contents := bytes.Repeat([]byte("bpass"), sftpChunk)
+		modTime := time.Unix(1500000000, 0)
+
+		// Once to create and once to replace
+		for i := 0; i < 2; i++ {
+			if err := s.send("dir/file.blob", "dir/.file.blob.tmp", 0600, modTime, contents, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if len(server.files) != 1 {
+			t.Error("temporary file was left behind:", len(server.files))
+		}
+
+		file, err := s.recv("dir/file.blob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(file.Contents, contents) {
+			t.Error("contents were wrong, got bytes:", len(file.Contents))
+		}
+		if file.Filename != "file.blob" || file.Mode != 0600 || file.Length != int64(len(contents)) {
+			t.Errorf("file was wrong: %s %o %d", file.Filename, file.Mode, file.Length)
+		}
+		if !file.ModTime.Equal(modTime) {
+			t.Error("mod time was wrong:", file.ModTime)
+		}
+
+		if _, err = s.recv("missing"); !IsNotFoundErr(err) {
+			t.Error("want not found error, got:", err)
+		}
+	}
+}
+
+func TestSFTPSendResume(t *testing.T) {
+	t.Parallel()
+
+	contents := bytes.Repeat([]byte("bpass"), sftpChunk)
+	half := len(contents) / 2
+
+	server := &fakeSFTPServer{files: map[string][]byte{
+		"dir/.file.blob.tmp": append([]byte(nil), contents[:half]...),
+	}}
+	s := newFakeSFTP(t, server)
+	defer s.close()
+
+	if err := s.send("dir/file.blob", "dir/.file.blob.tmp", 0600, time.Time{}, contents, int64(half)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(server.files["dir/file.blob"], contents) {
+		t.Error("resumed contents were wrong, got bytes:", len(server.files["dir/file.blob"]))
+	}
+	if _, ok := server.files["dir/.file.blob.tmp"]; ok {
+		t.Error("temporary file was left behind")
+	}
+
+	// A partial file that isn't a prefix of the contents must not be moved
+	// into place or kept around to be resumed again
+	server.files["dir/.file.blob.tmp"] = bytes.Repeat([]byte("x"), half)
+	err := s.send("dir/file.blob", "dir/.file.blob.tmp", 0600, time.Time{}, contents, int64(half))
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Error("want checksum mismatch, got:", err)
+	}
+	if _, ok := server.files["dir/.file.blob.tmp"]; ok {
+		t.Error("bad temporary file was left behind")
+	}
+}
+
+func TestSFTPMkdirAll(t *testing.T) {
+	t.Parallel()
+
+	server := &fakeSFTPServer{}
+	s := newFakeSFTP(t, server)
+	defer s.close()
+
+	if err := s.mkdirAll("/a/b/c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.mkdirAll("/a/b/c/d"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range []string{"/a", "/a/b", "/a/b/c", "/a/b/c/d"} {
+		if !server.dirs[dir] {
+			t.Error("dir was not made:", dir)
+		}
+	}
+	if len(server.dirs) != 4 {
+		t.Error("made the wrong dirs:", server.dirs)
+	}
+}
+
+func TestSFTPReaderShort(t *testing.T) {
+	t.Parallel()
+
+	r := &sftpReader{b: sftpPacket(nil).u32(10).bytes([]byte("short"))}
+	if s := r.str(); len(s) != 0 || r.err != errSFTPShort {
+		t.Error("want short error, got:", s, r.err)
+	}
+	if r.u32() != 0 || r.err != errSFTPShort {
+		t.Error("reads after an error should stay failed")
+	}
+
+	if err := status(&sftpReader{b: sftpPacket(nil).u32(4).str("it broke").str("en")}); err == nil ||
+		!strings.Contains(err.Error(), "it broke") {
+		t.Error("want the status message in the error, got:", err)
+	}
+}
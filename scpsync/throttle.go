@@ -0,0 +1,87 @@
+package scpsync
+
+import (
+	"io"
+	"time"
+)
+
+// throttle keeps the average rate of bytes going through it at or below
+// rate bytes per second by sleeping
+type throttle struct {
+	rate  int
+	start time.Time
+	n     int64
+}
+
+func newThrottle(rate int) *throttle {
+	return &throttle{rate: rate, start: time.Now()}
+}
+
+// chunk is the most that should be transferred at once so that the bytes
+// are spread out rather than sent in bursts (10 per second)
+func (t *throttle) chunk() int {
+	if c := t.rate / 10; c > 0 {
+		return c
+	}
+	return 1
+}
+
+// wait sleeps until n more bytes are allowed through
+func (t *throttle) wait(n int) {
+	t.n += int64(n)
+	allowed := time.Duration(float64(t.n) / float64(t.rate) * float64(time.Second))
+	if elapsed := time.Since(t.start); elapsed < allowed {
+		time.Sleep(allowed - elapsed)
+	}
+}
+
+type throttledReader struct {
+	r io.Reader
+	t *throttle
+}
+
+func (t throttledReader) Read(p []byte) (int, error) {
+	if c := t.t.chunk(); len(p) > c {
+		p = p[:c]
+	}
+
+	n, err := t.r.Read(p)
+	t.t.wait(n)
+	return n, err
+}
+
+type throttledWriter struct {
+	w io.Writer
+	t *throttle
+}
+
+func (t throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) != 0 {
+		chunk := p
+		if c := t.t.chunk(); len(chunk) > c {
+			chunk = chunk[:c]
+		}
+
+		t.t.wait(len(chunk))
+		n, err := t.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// throttled limits reads and writes of a stream to the connection's
+// RateLimit, reads and writes share the limit
+func (c Conn) throttled(r io.Reader, w io.Writer) (io.Reader, io.Writer) {
+	if c.RateLimit <= 0 {
+		return r, w
+	}
+
+	t := newThrottle(c.RateLimit)
+	return throttledReader{r: r, t: t}, throttledWriter{w: w, t: t}
+}
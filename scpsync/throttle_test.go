@@ -0,0 +1,46 @@
+package scpsync
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	t.Parallel()
+
+	payload := bytes.Repeat([]byte("a"), 3000)
+	c := Conn{RateLimit: 10000}
+
+	out := new(bytes.Buffer)
+	r, w := c.throttled(bytes.NewReader(payload), out)
+
+	start := time.Now()
+	if _, err := w.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	if !bytes.Equal(out.Bytes(), payload) || !bytes.Equal(b, payload) {
+		t.Error("bytes were lost going through the throttle")
+	}
+	// 6000 bytes at 10000 bytes/s shared between the reader and writer
+	if elapsed < 500*time.Millisecond {
+		t.Error("went too fast:", elapsed)
+	}
+}
+
+func TestThrottleUnlimited(t *testing.T) {
+	t.Parallel()
+
+	out := new(bytes.Buffer)
+	r, w := Conn{}.throttled(nil, out)
+	if r != nil || w != out {
+		t.Error("no rate limit should use the reader and writer as is")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestSearchIndex(t *testing.T) {
+	t.Parallel()
+
+	store := blobformat.Blobs{DB: new(txlogs.DB)}
+	github, err := store.New("work/GitHub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitlab, err := store.New("home/gitlab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.AddLabel(github, "dev"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.AddLabel(gitlab, "dev"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Set(github, blobformat.KeyUser, "octo.cat"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Set(gitlab, blobformat.KeyPass, "octo"); err != nil {
+		t.Fatal(err)
+	}
+
+	found := func(results blobformat.SearchResults, err error) []string {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results.Names()
+	}
+
+	if got := found(store.Search("gh")); len(got) != 1 || got[0] != "work/GitHub" {
+		t.Error("wrong fuzzy search:", got)
+	}
+	if got := found(store.Search("GH")); len(got) != 1 {
+		t.Error("uppercase should match uppercase:", got)
+	}
+	if got := found(store.Search("w/g")); len(got) != 1 || got[0] != "work/GitHub" {
+		t.Error("wrong fragment search:", got)
+	}
+	if got := found(store.SearchLabels("dev")); len(got) != 2 {
+		t.Error("both have the label:", got)
+	}
+	if got := found(store.SearchText("Octo")); len(got) != 1 || got[0] != "work/GitHub" {
+		t.Error("only the user should be searched, not the password:", got)
+	}
+
+	// The index follows changes
+	if err = store.Rename(github, "work/hub"); err != nil {
+		t.Fatal(err)
+	}
+	if got := found(store.Search("gh")); len(got) != 0 {
+		t.Error("the old name should be gone:", got)
+	}
+	if err = store.RemoveLabel(gitlab, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := found(store.SearchLabels("dev")); len(got) != 1 || got[0] != "work/hub" {
+		t.Error("the removed label should be gone:", got)
+	}
+	store.Trash(github)
+	if got := found(store.SearchText("octo")); len(got) != 0 {
+		t.Error("entries in the trash shouldn't be found:", got)
+	}
+}
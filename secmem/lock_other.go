@@ -0,0 +1,14 @@
+// +build !linux,!darwin,!windows
+
+package secmem
+
+// Memory isn't locked where there's no mlock to do it with, buffers are still
+// wiped when they're destroyed
+
+func lock(b []byte) error {
+	return nil
+}
+
+func unlock(b []byte) error {
+	return nil
+}
@@ -0,0 +1,13 @@
+// +build linux darwin
+
+package secmem
+
+import "golang.org/x/sys/unix"
+
+func lock(b []byte) error {
+	return unix.Mlock(b)
+}
+
+func unlock(b []byte) error {
+	return unix.Munlock(b)
+}
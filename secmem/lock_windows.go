@@ -0,0 +1,15 @@
+package secmem
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func lock(b []byte) error {
+	return windows.VirtualLock(uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)))
+}
+
+func unlock(b []byte) error {
+	return windows.VirtualUnlock(uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)))
+}
@@ -0,0 +1,103 @@
+// Package secmem keeps secrets (keys and decrypted data) in memory that's
+// locked into ram where the os allows it, so it's never written to swap,
+// and wiped as soon as it's no longer needed.
+package secmem
+
+import (
+	"os"
+	"runtime"
+	"unsafe"
+)
+
+var pageSize = os.Getpagesize()
+
+// Buffer is a secret in locked memory. The zero value and nil are empty
+// buffers so a nil *Buffer can be used where there's no secret.
+type Buffer struct {
+	b []byte
+	// locked is the pages b is in when they were locked
+	locked []byte
+}
+
+// New allocates a buffer of size bytes. The buffer has its pages to itself
+// so unlocking it never unlocks another buffer's memory.
+func New(size int) *Buffer {
+	if size == 0 {
+		return &Buffer{}
+	}
+
+	pages := (size + pageSize - 1) / pageSize
+	mem := make([]byte, (pages+1)*pageSize)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&mem[0])) % uintptr(pageSize)); rem != 0 {
+		offset = pageSize - rem
+	}
+
+	buf := &Buffer{b: mem[offset : offset+size : offset+size]}
+	if pagesMem := mem[offset : offset+pages*pageSize]; lock(pagesMem) == nil {
+		buf.locked = pagesMem
+	}
+	return buf
+}
+
+// Copy copies b into a new buffer, nil is returned for an empty b
+func Copy(b []byte) *Buffer {
+	if len(b) == 0 {
+		return nil
+	}
+
+	buf := New(len(b))
+	copy(buf.b, b)
+	return buf
+}
+
+// Take copies b into a new buffer and wipes b, for secrets that were made
+// in ordinary memory and aren't used after
+func Take(b []byte) *Buffer {
+	buf := Copy(b)
+	Wipe(b)
+	return buf
+}
+
+// Bytes is the secret, it must not be used after Destroy
+func (b *Buffer) Bytes() []byte {
+	if b == nil {
+		return nil
+	}
+	return b.b
+}
+
+// Len is the length of the secret
+func (b *Buffer) Len() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.b)
+}
+
+// Locked is true if the os locked the buffer into ram
+func (b *Buffer) Locked() bool {
+	return b != nil && b.locked != nil
+}
+
+// Destroy wipes and unlocks the buffer
+func (b *Buffer) Destroy() {
+	if b == nil || b.b == nil {
+		return
+	}
+
+	Wipe(b.b)
+	if b.locked != nil {
+		_ = unlock(b.locked)
+	}
+	b.b = nil
+	b.locked = nil
+}
+
+// Wipe zeroes b
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+	runtime.KeepAlive(b)
+}
@@ -0,0 +1,56 @@
+package secmem
+
+import (
+	"bytes"
+	"testing"
+	"unsafe"
+)
+
+func TestBuffer(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("hunter42")
+	buf := Take(secret)
+	if !bytes.Equal(secret, make([]byte, len(secret))) {
+		t.Error("take should wipe its argument")
+	}
+	if string(buf.Bytes()) != "hunter42" || buf.Len() != 8 {
+		t.Errorf("buffer was wrong: %q", buf.Bytes())
+	}
+	if addr := uintptr(unsafe.Pointer(&buf.Bytes()[0])); addr%uintptr(pageSize) != 0 {
+		t.Error("buffer should start on a page")
+	}
+	// Nothing is allowed to grow into the buffer's pages
+	if cap(buf.Bytes()) != buf.Len() {
+		t.Error("buffer's capacity should be its length")
+	}
+
+	b := buf.Bytes()
+	buf.Destroy()
+	if !bytes.Equal(b, make([]byte, len(b))) {
+		t.Error("destroy should wipe the buffer")
+	}
+	if buf.Bytes() != nil || buf.Len() != 0 || buf.Locked() {
+		t.Error("destroyed buffer should be empty")
+	}
+	buf.Destroy()
+
+	var none *Buffer
+	if none.Bytes() != nil || none.Len() != 0 || none.Locked() {
+		t.Error("nil buffer should be empty")
+	}
+	none.Destroy()
+
+	if Copy(nil) != nil {
+		t.Error("want nil for an empty copy")
+	}
+	if New(0).Len() != 0 {
+		t.Error("want an empty buffer")
+	}
+
+	big := New(3*pageSize + 1)
+	if big.Len() != 3*pageSize+1 {
+		t.Error("len was wrong:", big.Len())
+	}
+	big.Destroy()
+}
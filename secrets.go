@@ -0,0 +1,41 @@
+package main
+
+// markSecret marks a key of an entry secret so it's hidden like the password,
+// or removes the mark
+func (u *uiContext) markSecret(search, key string, secret bool) error {
+	uuid, err := u.findOne(search)
+	if err != nil {
+		return err
+	}
+	if len(uuid) == 0 {
+		return nil
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+
+	var changed bool
+	if secret {
+		changed, err = u.store.MarkSecret(uuid, key)
+	} else {
+		changed, err = u.store.UnmarkSecret(uuid, key)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !changed && secret:
+		infoColor.Printf("%s.%s is already secret\n", blob.Name(), key)
+	case !changed:
+		infoColor.Printf("%s.%s is not secret\n", blob.Name(), key)
+	case secret:
+		infoColor.Printf("%s.%s is secret\n", blob.Name(), key)
+	default:
+		infoColor.Printf("%s.%s is no longer secret\n", blob.Name(), key)
+	}
+
+	return nil
+}
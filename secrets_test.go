@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestMarkSecret(t *testing.T) {
+	t.Parallel()
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: ioutil.Discard}
+	uuid, err := u.store.New("bank")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.store.Set(uuid, "answer", "rex"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = u.markSecret("bank", "answer", true); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.markSecret("bank", "pin", true); err != nil {
+		t.Fatal(err)
+	}
+	blob, _ := u.store.MustFind(uuid)
+	if !blob.IsSecret("answer") || !blob.IsSecret("pin") || blob.IsSecret(blobformat.KeyName) {
+		t.Error("secrets were wrong:", blob.Secrets())
+	}
+
+	if changed, err := u.store.MarkSecret(uuid, "answer"); err != nil || changed {
+		t.Error("it should already be secret", err)
+	}
+	if err = u.markSecret("bank", "pin", false); err != nil {
+		t.Fatal(err)
+	}
+	blob, _ = u.store.MustFind(uuid)
+	if !reflect.DeepEqual(blob.Secrets(), []string{"answer"}) {
+		t.Error("secrets were wrong:", blob.Secrets())
+	}
+
+	if err = u.markSecret("bank", "answer", false); err != nil {
+		t.Fatal(err)
+	}
+	blob, _ = u.store.MustFind(uuid)
+	if _, ok := blob[blobformat.KeySecrets]; ok {
+		t.Error("the empty list should be deleted")
+	}
+}
+
+func TestParseGetFlags(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Args        []string
+		Rest        []string
+		Raw, Reveal bool
+	}{
+		{[]string{"bank", "answer"}, []string{"bank", "answer"}, false, false},
+		{[]string{"--reveal", "bank", "answer"}, []string{"bank", "answer"}, false, true},
+		{[]string{"--reveal", "--raw", "bank", "notes"}, []string{"bank", "notes"}, true, true},
+		{[]string{"bank", "--raw"}, []string{"bank", "--raw"}, false, false},
+	}
+
+	for i, test := range tests {
+		rest, raw, reveal := parseGetFlags(test.Args)
+		if !reflect.DeepEqual(rest, test.Rest) || raw != test.Raw || reveal != test.Reveal {
+			t.Errorf("%d) want: %q %t %t, got: %q %t %t", i, test.Rest, test.Raw, test.Reveal, rest, raw, reveal)
+		}
+	}
+}
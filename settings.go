@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/crypt"
+)
+
+// Encodings of the log, see the encoding setting
+const (
+	encodingJSON   = "json"
+	encodingBinary = "binary"
+)
+
+type setting struct {
+	Desc  string
+	Valid func(value string) bool
+}
+
+// knownSettings are the settings that can be set via the config command
+var knownSettings = map[string]setting{
+	blobformat.SettingSyncOnSave: {
+		Desc:  "sync all auto-sync entries every time the file is saved (true/false)",
+		Valid: isBool,
+	},
+	blobformat.SettingConflicts: {
+		Desc:  "how sync conflicts are resolved (interactive, prefer-newest, prefer-local, prefer-remote)",
+		Valid: isConflictPolicy,
+	},
+	blobformat.SettingMergeRules: {
+		Desc:  "how keys changed on both sides of a sync are merged, key=rule pairs with rules newest, union (by line, labels by label) or append, eg. url=newest,history=append (default notes=union,labels=union,knownhosts=append,pass=newest)",
+		Valid: isMergeRules,
+	},
+	blobformat.SettingSyncRetries: {
+		Desc:  "attempts made to pull/push when the network fails (default 3)",
+		Valid: isPositiveInt,
+	},
+	blobformat.SettingSyncBackoff: {
+		Desc:  "wait before the first retry, doubles each retry (default 1s)",
+		Valid: isDuration,
+	},
+	blobformat.SettingSyncTimeout: {
+		Desc:  "time allowed for each pull/push before giving up on the remote (default 1m)",
+		Valid: isDuration,
+	},
+	blobformat.SettingSyncRateLimit: {
+		Desc:  "most KB/s scp pulls/pushes may use (default unlimited)",
+		Valid: isPositiveInt,
+	},
+	blobformat.SettingRequireSigned: {
+		Desc:  "reject pulled files and changes that are not signed by a device (true/false)",
+		Valid: isBool,
+	},
+	blobformat.SettingHostKeyAlgorithms: {
+		Desc:  "host key algorithms offered to scp sync hosts, comma separated (default ssh library's)",
+		Valid: isAlgorithmList,
+	},
+	blobformat.SettingKexAlgorithms: {
+		Desc:  "key exchange algorithms offered to scp sync hosts, comma separated (default ssh library's)",
+		Valid: isAlgorithmList,
+	},
+	blobformat.SettingCiphers: {
+		Desc:  "ciphers offered to scp sync hosts, comma separated (default ssh library's)",
+		Valid: isAlgorithmList,
+	},
+	blobformat.SettingMACs: {
+		Desc:  "macs offered to scp sync hosts, comma separated (default ssh library's)",
+		Valid: isAlgorithmList,
+	},
+	blobformat.SettingKDF: {
+		Desc:  "cost of new keys, argon2id: t=iterations,m=KiB,p=threads or scrypt,n=cost,r=blocksize,p=parallelism (default t=3,m=65536,p=<cpus>, see passwd --tune)",
+		Valid: isKDFParams,
+	},
+	blobformat.SettingCipher: {
+		Desc:  "cipher the file is encrypted with, cascade (aes, camellia and cast5) or chacha20poly1305 which is faster without aes instructions (default cascade, used from the next save, single-user chacha20poly1305 files have a second slot for duress)",
+		Valid: isCipher,
+	},
+	blobformat.SettingCompactLength: {
+		Desc:  "compact the log when it's saved with more transactions than this (default never, see compact)",
+		Valid: isPositiveInt,
+	},
+	blobformat.SettingCompactDays: {
+		Desc:  "days of history kept when the log is compacted on save, alone it compacts every save (default all of it is compacted, see compact)",
+		Valid: isPositiveInt,
+	},
+	blobformat.SettingTrashDays: {
+		Desc:  "days entries stay in the trash before they're deleted for good (default 30)",
+		Valid: isPositiveInt,
+	},
+	blobformat.SettingPassHistory: {
+		Desc:  "old passwords each entry keeps when its pass is set, see get <query> passhist (default 10)",
+		Valid: isPositiveInt,
+	},
+	blobformat.SettingAliasEmail: {
+		Desc:  "email address that set <query> email --gen and add make aliases of for each entry by plus-addressing, eg. me@example.com gives me+github@example.com",
+		Valid: isEmail,
+	},
+	blobformat.SettingEncoding: {
+		Desc:  "how the log is encoded inside the file, json or binary which is smaller and faster for big files but older versions of bpass can't read it (default json, used from the next save)",
+		Valid: isEncoding,
+	},
+	blobformat.SettingKeyCache: {
+		Desc:  "how long the key is cached in the os keychain (keychain, dpapi or kernel keyring) after typing the passphrase so reopening doesn't need it, eg. 15m (default off)",
+		Valid: isDuration,
+	},
+}
+
+func isBool(value string) bool {
+	_, err := strconv.ParseBool(value)
+	return err == nil
+}
+
+func isPositiveInt(value string) bool {
+	n, err := strconv.Atoi(value)
+	return err == nil && n > 0
+}
+
+func isMergeRules(value string) bool {
+	_, err := blobformat.ParseMergeRules(value)
+	return err == nil
+}
+
+func isAlgorithmList(value string) bool {
+	return len(splitAlgorithms(value)) != 0 && !strings.ContainsAny(value, " \t")
+}
+
+func isKDFParams(value string) bool {
+	_, err := crypt.ParseKDFParams(value)
+	return err == nil
+}
+
+func isCipher(value string) bool {
+	return value == crypt.CipherCascade || value == crypt.CipherChaCha20Poly1305
+}
+
+func isEmail(value string) bool {
+	at := strings.LastIndexByte(value, '@')
+	return at > 0 && at < len(value)-1 && !strings.ContainsAny(value, " \t")
+}
+
+func isEncoding(value string) bool {
+	return value == encodingJSON || value == encodingBinary
+}
+
+func isDuration(value string) bool {
+	d, err := time.ParseDuration(value)
+	return err == nil && d > 0
+}
+
+// settingBool returns the setting parsed as a bool, false if it's not set
+// or can't be parsed
+func (u *uiContext) settingBool(key string) bool {
+	value, err := u.store.Setting(key)
+	if err != nil {
+		return false
+	}
+
+	b, _ := strconv.ParseBool(value)
+	return b
+}
+
+// config shows all settings, a single setting, or sets a setting depending
+// on the number of arguments given
+func (u *uiContext) config(args []string) error {
+	if len(args) > 0 {
+		if _, ok := knownSettings[args[0]]; !ok {
+			errColor.Printf("unknown setting: %q\n", args[0])
+			return nil
+		}
+	}
+
+	switch len(args) {
+	case 0:
+		names := make([]string, 0, len(knownSettings))
+		for name := range knownSettings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			value, err := u.store.Setting(name)
+			if err != nil {
+				return err
+			}
+			if len(value) == 0 {
+				value = hideColor.Sprint("(unset)")
+			}
+
+			keyColor.Printf("%s: ", name)
+			fmt.Println(value)
+			hideColor.Printf("  %s\n", knownSettings[name].Desc)
+		}
+	case 1:
+		value, err := u.store.Setting(args[0])
+		if err != nil {
+			return err
+		}
+		if len(value) == 0 {
+			value = hideColor.Sprint("(unset)")
+		}
+		fmt.Println(value)
+	default:
+		value := args[1]
+		if !knownSettings[args[0]].Valid(value) {
+			errColor.Printf("invalid value for %s: %q\n", args[0], value)
+			return nil
+		}
+
+		if err := u.store.SetSetting(args[0], value); err != nil {
+			return err
+		}
+		infoColor.Printf("set %s\n", args[0])
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+// Package shamir implements Shamir's secret sharing over GF(256). A secret
+// is split into n shares, any k of them recover it and fewer than k reveal
+// nothing about it.
+package shamir
+
+import (
+	"crypto/rand"
+	"errors"
+)
+
+var (
+	errThreshold      = errors.New("shamir: need 2 <= threshold <= shares <= 255")
+	errEmptySecret    = errors.New("shamir: cannot split an empty secret")
+	errTooFewShares   = errors.New("shamir: need at least 2 shares to combine")
+	errShareLength    = errors.New("shamir: shares are not all the same length")
+	errDuplicateShare = errors.New("shamir: the same share was given twice")
+)
+
+// Split the secret into n shares where k of them are needed to Combine it.
+// Each share is one byte longer than the secret, the last byte is the share's
+// x coordinate.
+func Split(secret []byte, n, k int) ([][]byte, error) {
+	if k < 2 || k > n || n > 255 {
+		return nil, errThreshold
+	}
+	if len(secret) == 0 {
+		return nil, errEmptySecret
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1)
+	}
+
+	// A random polynomial of degree k-1 for each byte with the secret
+	// byte as its constant term
+	coeffs := make([]byte, k)
+	for b, s := range secret {
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, err
+		}
+		coeffs[0] = s
+
+		for _, share := range shares {
+			share[b] = eval(coeffs, share[len(secret)])
+		}
+	}
+
+	return shares, nil
+}
+
+// Combine recovers the secret from at least the threshold of shares it was
+// split into. Fewer shares give a wrong secret rather than an error since
+// the threshold isn't kept in the shares.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errTooFewShares
+	}
+
+	size := len(shares[0])
+	if size < 2 {
+		return nil, errShareLength
+	}
+
+	xs := make([]byte, len(shares))
+	for i, share := range shares {
+		if len(share) != size {
+			return nil, errShareLength
+		}
+
+		xs[i] = share[size-1]
+		for j := 0; j < i; j++ {
+			if xs[j] == xs[i] {
+				return nil, errDuplicateShare
+			}
+		}
+	}
+
+	// Lagrange interpolation at x = 0, in GF(256) subtraction is xor
+	secret := make([]byte, size-1)
+	for i, share := range shares {
+		basis := byte(1)
+		for j := range shares {
+			if i != j {
+				basis = mul(basis, div(xs[j], xs[j]^xs[i]))
+			}
+		}
+
+		for b := range secret {
+			secret[b] ^= mul(share[b], basis)
+		}
+	}
+
+	return secret, nil
+}
+
+// eval evaluates the polynomial at x using Horner's method
+func eval(coeffs []byte, x byte) byte {
+	var y byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ coeffs[i]
+	}
+	return y
+}
+
+// mul multiplies in GF(256) with the AES polynomial x^8+x^4+x^3+x+1, it
+// doesn't branch on its inputs so its time doesn't depend on the secret
+func mul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		carry := -(a >> 7)
+		a = a<<1 ^ carry&0x1b
+		b >>= 1
+	}
+	return p
+}
+
+// div divides in GF(256), b must not be 0
+func div(a, b byte) byte {
+	// b^254 is b's inverse
+	inv := b
+	for i := 0; i < 6; i++ {
+		inv = mul(mul(inv, inv), b)
+	}
+	return mul(a, mul(inv, inv))
+}
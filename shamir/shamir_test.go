@@ -0,0 +1,95 @@
+package shamir
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitCombine(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("correct horse battery staple")
+	tests := []struct {
+		N, K int
+	}{
+		{N: 2, K: 2},
+		{N: 5, K: 3},
+		{N: 255, K: 255},
+	}
+
+	for i, test := range tests {
+		shares, err := Split(secret, test.N, test.K)
+		if err != nil {
+			t.Fatalf("%d) %v", i, err)
+		}
+		if len(shares) != test.N {
+			t.Errorf("%d) want %d shares, got: %d", i, test.N, len(shares))
+		}
+
+		// Any k shares work, take them from the end so they're not in order
+		got, err := Combine(shares[test.N-test.K:])
+		if err != nil {
+			t.Errorf("%d) %v", i, err)
+		} else if !bytes.Equal(got, secret) {
+			t.Errorf("%d) secret was wrong: %q", i, got)
+		}
+
+		// As do more than k
+		if got, err = Combine(shares); err != nil || !bytes.Equal(got, secret) {
+			t.Errorf("%d) all shares failed: %q %v", i, got, err)
+		}
+
+		// But not fewer
+		if test.K > 2 {
+			if got, err = Combine(shares[:test.K-1]); err == nil && bytes.Equal(got, secret) {
+				t.Errorf("%d) fewer than k shares should not recover the secret", i)
+			}
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	t.Parallel()
+
+	if _, err := Split([]byte("x"), 3, 1); err != errThreshold {
+		t.Error("want threshold error, got:", err)
+	}
+	if _, err := Split([]byte("x"), 2, 3); err != errThreshold {
+		t.Error("want threshold error, got:", err)
+	}
+	if _, err := Split([]byte("x"), 256, 2); err != errThreshold {
+		t.Error("want threshold error, got:", err)
+	}
+	if _, err := Split(nil, 3, 2); err != errEmptySecret {
+		t.Error("want empty secret error, got:", err)
+	}
+
+	shares, err := Split([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Combine(shares[:1]); err != errTooFewShares {
+		t.Error("want too few shares error, got:", err)
+	}
+	if _, err = Combine([][]byte{shares[0], shares[0]}); err != errDuplicateShare {
+		t.Error("want duplicate share error, got:", err)
+	}
+	if _, err = Combine([][]byte{shares[0], shares[1][1:]}); err != errShareLength {
+		t.Error("want share length error, got:", err)
+	}
+}
+
+func TestGF256(t *testing.T) {
+	t.Parallel()
+
+	// From FIPS-197 section 4.2
+	if got := mul(0x57, 0x83); got != 0xc1 {
+		t.Errorf("want c1, got: %x", got)
+	}
+
+	for a := 1; a < 256; a++ {
+		if got := mul(div(1, byte(a)), byte(a)); got != 1 {
+			t.Errorf("%x * 1/%x = %x", a, a, got)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/crypt"
+	"github.com/aarondl/bpass/shamir"
+)
+
+const (
+	// sharePrefix starts every recovery share, entering one at the
+	// passphrase prompt asks for the rest
+	sharePrefix = "bpass-share-"
+	// defaultShareUser is the recovery user passwd --split adds
+	defaultShareUser = "recovery"
+)
+
+var errBadShare = errors.New("that is not a recovery share (check it for typos)")
+
+// splitRecovery adds a recovery user whose code is split into n shares, any
+// k of them open the file as that user. Giving them to different people
+// means the file can be recovered without any one of them being able to.
+func (u *uiContext) splitRecovery(user string, n, k int) error {
+	if u.master.Len() == 0 {
+		errColor.Println("add yourself first (adduser <you>), the shares open the file as another user")
+		return nil
+	}
+	if k < 2 || k > n || n > 255 {
+		errColor.Println("need 2 <= k <= n <= 255 (passwd --split <n> <k>)")
+		return nil
+	}
+
+	pass, err := genPassword(recoveryCodeLen, 0, 0, 0, 0, 0)
+	if err != nil {
+		return err
+	}
+	shares, err := shamir.Split([]byte(pass), n, k)
+	if err != nil {
+		return err
+	}
+
+	version := u.keyVersion()
+	key, salt, err := crypt.DeriveKeyParams(version, []byte(pass), recoveryKDFParams(version))
+	if err != nil {
+		return err
+	}
+
+	uuid, err := u.store.NewUser(user)
+	if err == blobformat.ErrNameNotUnique {
+		errColor.Println("user already exists")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err = u.setUserKey(uuid, version, key, salt); err != nil {
+		return err
+	}
+
+	infoColor.Printf("added recovery user %s, give each share to a different person.\n", user)
+	infoColor.Printf("any %d of them open the file as %s by entering them at the passphrase prompt:\n", k, user)
+	for i, share := range shares {
+		encoded := encodeShare(k, share)
+		infoColor.Printf("share %d of %d:\n", i+1, n)
+		passColor.Println(encoded)
+		printQR(encoded)
+	}
+
+	return nil
+}
+
+// combineShares asks for shares until there's enough to recover the code
+// that first (the share entered at the passphrase prompt) was split from
+func (u *uiContext) combineShares(first string) (string, error) {
+	k, share, err := decodeShare(first)
+	if err != nil {
+		return "", err
+	}
+
+	shares := [][]byte{share}
+	for len(shares) < k {
+		line, err := u.promptPassword(promptColor.Sprintf("share %d of %d: ", len(shares)+1, k))
+		if err != nil {
+			return "", err
+		}
+
+		shareK, share, err := decodeShare(strings.TrimSpace(line))
+		if err != nil {
+			errColor.Println(err)
+			continue
+		}
+		if shareK != k {
+			errColor.Println("that share is from a different split")
+			continue
+		}
+
+		dupe := false
+		for _, s := range shares {
+			dupe = dupe || bytes.Equal(s, share)
+		}
+		if dupe {
+			errColor.Println("that share was already entered")
+			continue
+		}
+
+		shares = append(shares, share)
+	}
+
+	secret, err := shamir.Combine(shares)
+	if err != nil {
+		return "", err
+	}
+
+	return string(secret), nil
+}
+
+// isShare checks if a passphrase is really a recovery share
+func isShare(pass string) bool {
+	return strings.HasPrefix(pass, sharePrefix)
+}
+
+// encodeShare makes a share printable: bpass-share-<k>-<share>-<check>
+// where the check is part of a hash of the rest to catch typos
+func encodeShare(k int, share []byte) string {
+	s := fmt.Sprintf("%s%d-%x", sharePrefix, k, share)
+	sum := sha256.Sum256([]byte(s))
+	return fmt.Sprintf("%s-%x", s, sum[:2])
+}
+
+// decodeShare reads a share made by encodeShare
+func decodeShare(s string) (k int, share []byte, err error) {
+	if !isShare(s) {
+		return 0, nil, errBadShare
+	}
+
+	i := strings.LastIndexByte(s, '-')
+	sum := sha256.Sum256([]byte(s[:i]))
+	if s[i+1:] != hex.EncodeToString(sum[:2]) {
+		return 0, nil, errBadShare
+	}
+
+	fields := strings.Split(s[len(sharePrefix):i], "-")
+	if len(fields) != 2 {
+		return 0, nil, errBadShare
+	}
+
+	k, err = strconv.Atoi(fields[0])
+	if err != nil || k < 2 {
+		return 0, nil, errBadShare
+	}
+	share, err = hex.DecodeString(fields[1])
+	if err != nil {
+		return 0, nil, errBadShare
+	}
+
+	return k, share, nil
+}
+
+// printQR shows s as a qr code when qrencode is installed, it's only a
+// convenience for printing shares so it's quiet when that fails
+func printQR(s string) {
+	command, err := exec.LookPath("qrencode")
+	if err != nil {
+		return
+	}
+
+	cmd := exec.Command(command, "-t", "UTF8", s)
+	cmd.Stdout = os.Stdout
+	_ = cmd.Run()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aarondl/bpass/shamir"
+)
+
+func TestShareEncoding(t *testing.T) {
+	t.Parallel()
+
+	shares, err := shamir.Split([]byte("recovery code"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded := encodeShare(2, shares[1])
+	if !isShare(encoded) {
+		t.Error("encoded share was not recognized:", encoded)
+	}
+
+	k, share, err := decodeShare(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k != 2 || string(share) != string(shares[1]) {
+		t.Error("share did not round trip:", k, share)
+	}
+
+	// A typo anywhere is caught by the check
+	typo := []byte(encoded)
+	typo[len(sharePrefix)+4]++
+	if _, _, err = decodeShare(string(typo)); err != errBadShare {
+		t.Error("want bad share error, got:", err)
+	}
+
+	bad := []string{
+		"hunter2",
+		sharePrefix,
+		sharePrefix + "2-zz-0000",
+		encodeShare(1, shares[0]),
+	}
+	for i, b := range bad {
+		if _, _, err = decodeShare(b); err != errBadShare {
+			t.Errorf("%d) want bad share error, got: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/crypt"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+var (
+	errUnsigned        = errors.New("file is not signed and the requiresigned setting is on")
+	errUntrustedDevice = errors.New("file was signed by an untrusted device")
+)
+
+// deviceKey returns this machine's key for signing pushed files. It's
+// created the first time and its public key is added to the trusted devices
+// under this machine's hostname so other machines learn of it when they sync.
+// Transactions are stamped with the id of its public key and signed with it
+// from then on, the ones made before it that haven't been synced yet are
+// signed when it's created.
+func (u *uiContext) deviceKey() (ed25519.PrivateKey, error) {
+	seed, err := u.store.Setting(blobformat.SettingDeviceKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(seed) != 0 {
+		b, err := base64.StdEncoding.DecodeString(seed)
+		if err != nil || len(b) != ed25519.SeedSize {
+			return nil, fmt.Errorf("%s setting is corrupt", blobformat.SettingDeviceKey)
+		}
+		priv := ed25519.NewKeyFromSeed(b)
+		u.store.SetDevice(deviceID(priv.Public().(ed25519.PublicKey)), priv)
+		return priv, nil
+	}
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, err
+	}
+	u.store.SetDevice(deviceID(pub), priv)
+	// Remotes that are already signed won't take our unsigned changes
+	u.store.DB.SignFrom(u.unsyncedFrom())
+
+	hostname, err := os.Hostname()
+	if err != nil || len(hostname) == 0 {
+		hostname = unnamedDevice(pub)
+	}
+
+	if err = u.store.SetSetting(blobformat.SettingDeviceKey, base64.StdEncoding.EncodeToString(priv.Seed())); err != nil {
+		return nil, err
+	}
+	if err = u.store.AddDevice(hostname, base64.StdEncoding.EncodeToString(pub)); err != nil {
+		return nil, err
+	}
+
+	infoColor.Printf("created signing key for this device (%s): %s\n", hostname, keyFingerprint(pub))
+	return priv, nil
+}
+
+// unsyncedFrom returns the index of the first transaction that's newer than
+// every sync base, the ones no remote we merge with has yet. It's 0 when
+// nothing has been synced.
+func (u *uiContext) unsyncedFrom() int {
+	var newest int64
+	for _, entry := range u.store.Snapshot {
+		base, err := txlogs.ParseBase(entry[blobformat.KeySyncBase])
+		if err == nil && base.Time > newest {
+			newest = base.Time
+		}
+	}
+
+	log := u.store.DB.Log
+	return sort.Search(len(log), func(i int) bool { return log[i].Time > newest })
+}
+
+// verifyPayload checks the signature of a pulled file and returns the
+// encrypted file inside of it. Files signed by devices we don't know about
+// are only accepted if the user trusts the device, trusted holds the keys
+// trusted so far this sync so we only ask once.
+func (u *uiContext) verifyPayload(name string, signed []byte, trusted map[string]bool) ([]byte, error) {
+	pub, ct, err := crypt.Verify(signed)
+	if err != nil {
+		return nil, err
+	}
+
+	if pub == nil {
+		if required, _ := u.store.Setting(blobformat.SettingRequireSigned); required == "true" {
+			return nil, errUnsigned
+		}
+		return ct, nil
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(pub)
+	if trusted[encoded] {
+		return ct, nil
+	}
+
+	devices, err := u.store.Devices()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range devices {
+		if d == encoded {
+			return ct, nil
+		}
+	}
+
+	infoColor.Printf("%s was signed by an unknown device: %s\n", name, keyFingerprint(pub))
+	yes, err := u.getYesNo("trust this device?")
+	if err != nil || !yes {
+		return nil, errUntrustedDevice
+	}
+
+	trusted[encoded] = true
+	return ct, nil
+}
+
+// trustDevices adds the keys trusted during a sync to the devices that
+// aren't already known (likely by name after a merge).
+func (u *uiContext) trustDevices(trusted map[string]bool) error {
+	for encoded := range trusted {
+		pub, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return err
+		}
+
+		if err = u.store.AddDevice(unnamedDevice(pub), encoded); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyLog checks that a pulled log wasn't changed by anyone but the devices
+// that wrote it before it's merged into local, see txlogs.Verify. trusted are
+// the keys trusted so far this sync.
+func (u *uiContext) verifyLog(local, remote []txlogs.Tx, trusted map[string]bool) error {
+	keys, err := u.txKeys(remote, trusted)
+	if err != nil {
+		return err
+	}
+
+	required, _ := u.store.Setting(blobformat.SettingRequireSigned)
+	return txlogs.Verify(local, remote, keys, required == "true")
+}
+
+// txKeys returns the keys transactions may be signed with by device id. They
+// are the trusted devices, the ones trusted this sync and the ones added to
+// the remote's devices by a change signed with one of those (or one added
+// that way).
+func (u *uiContext) txKeys(remote []txlogs.Tx, trusted map[string]bool) (map[string]ed25519.PublicKey, error) {
+	devices, err := u.store.Devices()
+	if err != nil {
+		return nil, err
+	}
+	remoteDevices, err := blobformat.Blobs{DB: &txlogs.DB{Log: remote}}.Devices()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]ed25519.PublicKey)
+	add := func(encoded string) bool {
+		pub, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			return false
+		}
+		id := deviceID(pub)
+		if _, ok := keys[id]; ok {
+			return false
+		}
+		keys[id] = pub
+		return true
+	}
+
+	for _, encoded := range devices {
+		add(encoded)
+	}
+	for encoded := range trusted {
+		add(encoded)
+	}
+
+	for added := true; added; {
+		added = false
+		for _, encoded := range remoteDevices {
+			for _, tx := range remote {
+				if tx.Kind == txlogs.TxSetKey && tx.Value == encoded && txlogs.VerifyTx(tx, keys[tx.Device]) {
+					added = add(encoded) || added
+					break
+				}
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// unnamedDevice makes up a name for a device we only know the key of
+func unnamedDevice(pub ed25519.PublicKey) string {
+	return "device-" + deviceID(pub)
+}
+
+// deviceID is the short id of a device's key that its transactions are
+// stamped with
+func deviceID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return fmt.Sprintf("%x", sum[:4])
+}
+
+// keyFingerprint formats a public key the way ssh does
+func keyFingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestVerifyLog(t *testing.T) {
+	t.Parallel()
+
+	local := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}}
+	if _, err := local.deviceKey(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another device syncs the file and makes changes
+	pushed, _ := local.store.SyncLog()
+	other := &uiContext{store: blobformat.Blobs{DB: &txlogs.DB{Log: pushed}}}
+	otherKey, err := other.deviceKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.store.New("entry"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = local.verifyLog(local.store.Log, other.store.Log, nil); !txlogs.IsTamperError(err) {
+		t.Error("an unknown device should be rejected:", err)
+	}
+	trusted := map[string]bool{
+		base64.StdEncoding.EncodeToString(otherKey.Public().(ed25519.PublicKey)): true,
+	}
+	if err = local.verifyLog(local.store.Log, other.store.Log, trusted); err != nil {
+		t.Error(err)
+	}
+
+	// The other device trusts a third one that makes changes too
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = other.store.AddDevice("third", base64.StdEncoding.EncodeToString(pub)); err != nil {
+		t.Fatal(err)
+	}
+	other.store.SetDevice(deviceID(pub), key)
+	if _, err = other.store.New("third entry"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = local.verifyLog(local.store.Log, other.store.Log, trusted); err != nil {
+		t.Error("a device added by a trusted one should be trusted:", err)
+	}
+
+	// Doctored by someone without a key
+	doctored := append([]txlogs.Tx(nil), other.store.Log...)
+	doctored[len(doctored)-1].Value = "doctored"
+	if err = local.verifyLog(local.store.Log, doctored, trusted); !txlogs.IsTamperError(err) {
+		t.Error("a doctored log should be rejected:", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// smartcardModuleEnv is the pkcs#11 module to load, pkcs11-tool uses
+	// opensc's (which handles piv cards) when it's not set
+	smartcardModuleEnv = "BPASS_PKCS11_MODULE"
+	// smartcardIDEnv is the id of the key on the token
+	smartcardIDEnv = "BPASS_PKCS11_ID"
+	// smartcardDefaultID is the piv key management slot (9d) in opensc
+	smartcardDefaultID = "03"
+)
+
+// smartcardResponse answers a challenge with the token's rsa signature of
+// it using pkcs11-tool (opensc), which asks for the pin itself. Only rsa
+// keys work since ecdsa signatures are different every time.
+func (u *uiContext) smartcardResponse(challenge []byte) ([]byte, error) {
+	command, err := exec.LookPath("pkcs11-tool")
+	if err != nil {
+		return nil, errors.New("pkcs11-tool (opensc) is needed to use a smartcard")
+	}
+
+	if u.headless {
+		errColor.Println("a smartcard can't be used without a terminal to enter the pin")
+		return nil, errFactorAbsent
+	}
+
+	for {
+		response, err := smartcardSign(command, challenge)
+		if err == nil {
+			return response, nil
+		}
+
+		errColor.Println("smartcard signature failed:", err)
+		retry, err := u.getYesNo("insert your smartcard and try again?")
+		if err != nil {
+			return nil, err
+		}
+		if !retry {
+			return nil, errFactorAbsent
+		}
+	}
+}
+
+// smartcardSign signs the challenge with the key in $BPASS_PKCS11_ID
+func smartcardSign(command string, challenge []byte) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "bpass")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in := filepath.Join(dir, "challenge")
+	out := filepath.Join(dir, "signature")
+	if err = ioutil.WriteFile(in, challenge, 0600); err != nil {
+		return nil, err
+	}
+
+	id := os.Getenv(smartcardIDEnv)
+	if len(id) == 0 {
+		id = smartcardDefaultID
+	}
+
+	var args []string
+	if module := os.Getenv(smartcardModuleEnv); len(module) != 0 {
+		args = append(args, "--module", module)
+	}
+	args = append(args, "--login", "--sign", "--mechanism", "SHA256-RSA-PKCS",
+		"--id", id, "--input-file", in, "--output-file", out)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(command, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) == 0 {
+			msg = err.Error()
+		}
+		return nil, errors.New(msg)
+	}
+
+	signature, err := ioutil.ReadFile(out)
+	if err != nil {
+		return nil, err
+	}
+	if len(signature) == 0 {
+		return nil, errors.New("pkcs11-tool did not return a signature")
+	}
+
+	return signature, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentSigners returns signers for the keys held by the running ssh-agent
+// (SSH_AUTH_SOCK). No agent, or one that can't be reached, simply means
+// there are no keys.
+func agentSigners() []ssh.Signer {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if len(sock) == 0 {
+		return nil
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return nil
+	}
+
+	signers := make([]ssh.Signer, 0, len(keys))
+	for _, k := range keys {
+		pub, err := ssh.ParsePublicKey(k.Blob)
+		if err != nil {
+			continue
+		}
+		signers = append(signers, agentSigner{sock: sock, pub: pub})
+	}
+
+	return signers
+}
+
+// agentSigner signs using a key in the ssh-agent. Each signature is made
+// over its own connection to the agent so there's nothing to keep open (or
+// remember to close) for the lifetime of the ssh connection.
+type agentSigner struct {
+	sock string
+	pub  ssh.PublicKey
+}
+
+// PublicKey implements ssh.Signer
+func (a agentSigner) PublicKey() ssh.PublicKey {
+	return a.pub
+}
+
+// Sign implements ssh.Signer
+func (a agentSigner) Sign(_ io.Reader, data []byte) (*ssh.Signature, error) {
+	if isSKKey(a.pub.Type()) {
+		infoColor.Println("(ssh) confirm on your security key")
+	}
+
+	conn, err := net.Dial("unix", a.sock)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return agent.NewClient(conn).Sign(a.pub, data)
+}
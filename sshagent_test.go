@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAgentSigners(t *testing.T) {
+	// No t.Parallel(), modifies the environment
+
+	dir, err := ioutil.TempDir("", "bpassagent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skip("unix sockets unavailable:", err)
+	}
+	defer listener.Close()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err = keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = agent.ServeAgent(keyring, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	old := os.Getenv("SSH_AUTH_SOCK")
+	os.Setenv("SSH_AUTH_SOCK", sock)
+	defer os.Setenv("SSH_AUTH_SOCK", old)
+
+	signers := agentSigners()
+	if len(signers) != 1 {
+		t.Fatal("want 1 signer, got:", len(signers))
+	}
+
+	data := []byte("data")
+	sig, err := signers[0].Sign(rand.Reader, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := ssh.NewPublicKey(priv.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pub.Verify(data, sig); err != nil {
+		t.Error(err)
+	}
+
+	os.Setenv("SSH_AUTH_SOCK", "")
+	if signers = agentSigners(); len(signers) != 0 {
+		t.Error("want no signers without an agent")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// sshHostOptions are the options from the user's ssh config that apply to
+// a host
+type sshHostOptions struct {
+	HostName      string
+	Port          string
+	User          string
+	ProxyJump     string
+	IdentityFiles []string
+}
+
+type sshConfigBlock struct {
+	patterns []string
+	options  [][2]string
+}
+
+// sshUserConfig is a parsed openssh client config (~/.ssh/config), only
+// Host blocks are understood, Match blocks never apply and Include is
+// ignored.
+type sshUserConfig []sshConfigBlock
+
+// loadSSHConfig reads ~/.ssh/config, a missing or unreadable file is the
+// same as an empty one
+func loadSSHConfig() sshUserConfig {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+
+	f, err := os.Open(filepath.Join(home, ".ssh", "config"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	config, err := parseSSHConfig(f)
+	if err != nil {
+		return nil
+	}
+
+	return config
+}
+
+// parseSSHConfig parses an openssh client config. Keywords are case
+// insensitive and may be separated from their value by spaces or =.
+func parseSSHConfig(r io.Reader) (sshUserConfig, error) {
+	// Options before the first Host line apply to every host
+	config := sshUserConfig{{patterns: []string{"*"}}}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		i := strings.IndexAny(line, " \t=")
+		if i < 0 {
+			continue
+		}
+		keyword := strings.ToLower(line[:i])
+		value := strings.TrimLeft(line[i:], " \t=")
+		value = strings.TrimSpace(value)
+
+		switch keyword {
+		case "host":
+			config = append(config, sshConfigBlock{patterns: strings.Fields(value)})
+		case "match":
+			// We can't evaluate match criteria so nothing in the block applies
+			config = append(config, sshConfigBlock{})
+		default:
+			last := &config[len(config)-1]
+			last.options = append(last.options, [2]string{keyword, strings.Trim(value, `"`)})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// lookup finds the options for host, like ssh the first value found for an
+// option is the one used except for IdentityFile which accumulates.
+func (s sshUserConfig) lookup(host string) sshHostOptions {
+	var opts sshHostOptions
+	for _, block := range s {
+		if !matchSSHHost(block.patterns, host) {
+			continue
+		}
+
+		for _, opt := range block.options {
+			switch opt[0] {
+			case "hostname":
+				if len(opts.HostName) == 0 {
+					opts.HostName = strings.ReplaceAll(opt[1], "%h", host)
+				}
+			case "port":
+				if len(opts.Port) == 0 {
+					opts.Port = opt[1]
+				}
+			case "user":
+				if len(opts.User) == 0 {
+					opts.User = opt[1]
+				}
+			case "proxyjump":
+				if len(opts.ProxyJump) == 0 {
+					opts.ProxyJump = opt[1]
+				}
+			case "identityfile":
+				opts.IdentityFiles = append(opts.IdentityFiles, expandSSHPath(opt[1], host))
+			}
+		}
+	}
+
+	return opts
+}
+
+// matchSSHHost checks host against a Host line's patterns, a negated
+// pattern (!pattern) that matches excludes the host regardless of the others
+func matchSSHHost(patterns []string, host string) bool {
+	host = strings.ToLower(host)
+
+	matched := false
+	for _, p := range patterns {
+		negate := strings.HasPrefix(p, "!")
+		p = strings.ToLower(strings.TrimPrefix(p, "!"))
+
+		if ok, _ := path.Match(p, host); !ok {
+			continue
+		}
+		if negate {
+			return false
+		}
+		matched = true
+	}
+
+	return matched
+}
+
+// expandSSHPath expands ~ and the %d (home) and %h (host) tokens
+func expandSSHPath(p, host string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		p = home + p[1:]
+	}
+	p = strings.ReplaceAll(p, "%d", home)
+	p = strings.ReplaceAll(p, "%h", host)
+
+	return filepath.FromSlash(p)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSSHConfigLookup(t *testing.T) {
+	t.Parallel()
+
+	config, err := parseSSHConfig(strings.NewReader(`
+# Global defaults
+User everyone
+
+Host vault
+	HostName vault.example.com
+	Port 2222
+	IdentityFile ~/.ssh/vault_ed25519
+	ProxyJump bastion
+
+Host *.example.com !secret.example.com
+	User=example
+	IdentityFile "/keys/example"
+
+Match host vault
+	Port 1
+
+Host *
+	Port 22
+	IdentityFile /keys/default
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := config.lookup("vault")
+	if opts.HostName != "vault.example.com" {
+		t.Error("hostname wrong:", opts.HostName)
+	}
+	if opts.Port != "2222" {
+		t.Error("port wrong:", opts.Port)
+	}
+	if opts.User != "everyone" {
+		t.Error("user wrong:", opts.User)
+	}
+	if opts.ProxyJump != "bastion" {
+		t.Error("proxyjump wrong:", opts.ProxyJump)
+	}
+	if len(opts.IdentityFiles) != 2 ||
+		opts.IdentityFiles[0] != filepath.Join(home, ".ssh", "vault_ed25519") ||
+		opts.IdentityFiles[1] != filepath.FromSlash("/keys/default") {
+		t.Error("identity files wrong:", opts.IdentityFiles)
+	}
+
+	opts = config.lookup("www.EXAMPLE.com")
+	if opts.User != "everyone" || opts.Port != "22" || len(opts.HostName) != 0 {
+		t.Errorf("www options wrong: %#v", opts)
+	}
+	if len(opts.IdentityFiles) != 2 || opts.IdentityFiles[0] != filepath.FromSlash("/keys/example") {
+		t.Error("identity files wrong:", opts.IdentityFiles)
+	}
+
+	opts = config.lookup("secret.example.com")
+	if len(opts.IdentityFiles) != 1 {
+		t.Error("negated pattern should not have matched:", opts.IdentityFiles)
+	}
+}
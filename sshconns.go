@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aarondl/bpass/txlogs"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshConnCache holds ssh connections open for the length of a sync so that
+// each host is only dialed (and authenticated with) once for both the pull
+// and the push. Connections are keyed by user, address and jump host.
+type sshConnCache struct {
+	mut   sync.Mutex
+	conns map[string]*sshConn
+}
+
+type sshConn struct {
+	// mut is held while dialing so concurrent syncs to the same host wait
+	// for one connection rather than each making their own
+	mut    sync.Mutex
+	client *ssh.Client
+}
+
+func newSSHConnCache() *sshConnCache {
+	return &sshConnCache{conns: make(map[string]*sshConn)}
+}
+
+// get returns the connection for key, dialing it if there isn't one
+func (c *sshConnCache) get(key string, dial func() (*ssh.Client, error)) (*ssh.Client, error) {
+	c.mut.Lock()
+	conn, ok := c.conns[key]
+	if !ok {
+		conn = new(sshConn)
+		c.conns[key] = conn
+	}
+	c.mut.Unlock()
+
+	conn.mut.Lock()
+	defer conn.mut.Unlock()
+
+	if conn.client != nil {
+		return conn.client, nil
+	}
+
+	client, err := dial()
+	if err != nil {
+		return nil, err
+	}
+
+	conn.client = client
+	return client, nil
+}
+
+// drop closes and forgets a connection that failed so the next user dials
+// a new one
+func (c *sshConnCache) drop(key string, client *ssh.Client) {
+	c.mut.Lock()
+	conn := c.conns[key]
+	c.mut.Unlock()
+
+	conn.mut.Lock()
+	defer conn.mut.Unlock()
+
+	if conn.client == client {
+		_ = client.Close()
+		conn.client = nil
+	}
+}
+
+// close closes all the connections
+func (c *sshConnCache) close() {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	for _, conn := range c.conns {
+		conn.mut.Lock()
+		if conn.client != nil {
+			_ = conn.client.Close()
+			conn.client = nil
+		}
+		conn.mut.Unlock()
+	}
+}
+
+// sshConnect connects to the ssh server of a sync entry, reusing the
+// connection from earlier in the sync if there is one. done must be called
+// with the outcome of using the connection, it closes the connection when
+// it's not being kept for reuse (or has failed).
+func sshConnect(ctx context.Context, u *uiContext, s syncSettings, entry txlogs.Entry) (client *ssh.Client, path, hostentry string, done func(error), err error) {
+	if u.sshConns == nil {
+		client, path, hostentry, err = sshDial(ctx, u, s, entry)
+		if err != nil {
+			return nil, "", hostentry, nil, err
+		}
+		return client, path, hostentry, func(error) { _ = client.Close() }, nil
+	}
+
+	address, _, path, jump, config, err := sshConfig(entry)
+	if err != nil {
+		return nil, "", "", nil, err
+	}
+	key := config.User + "@" + address + " " + jump
+
+	client, err = u.sshConns.get(key, func() (*ssh.Client, error) {
+		var c *ssh.Client
+		c, _, hostentry, err = sshDial(ctx, u, s, entry)
+		return c, err
+	})
+	if err != nil {
+		return nil, "", hostentry, nil, err
+	}
+
+	done = func(err error) {
+		if err != nil {
+			u.sshConns.drop(key, client)
+		}
+	}
+	return client, path, hostentry, done, nil
+}
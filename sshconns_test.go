@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHConnCacheGet(t *testing.T) {
+	t.Parallel()
+
+	cache := newSSHConnCache()
+
+	var mut sync.Mutex
+	dials := 0
+	dial := func() (*ssh.Client, error) {
+		mut.Lock()
+		defer mut.Unlock()
+		dials++
+		return new(ssh.Client), nil
+	}
+
+	clients := make([]*ssh.Client, 8)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			clients[i], err = cache.get("user@host:22 ", dial)
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if dials != 1 {
+		t.Error("want one dial, got:", dials)
+	}
+	for i, c := range clients {
+		if c != clients[0] {
+			t.Errorf("%d) got a different client", i)
+		}
+	}
+
+	other, err := cache.get("other@host:22 ", dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == clients[0] || dials != 2 {
+		t.Error("a different key should get its own connection")
+	}
+
+	// Failed dials aren't kept
+	failed := errors.New("failed")
+	_, err = cache.get("down:22 ", func() (*ssh.Client, error) { return nil, failed })
+	if err != failed {
+		t.Error("want the dial error, got:", err)
+	}
+	if _, err = cache.get("down:22 ", dial); err != nil || dials != 3 {
+		t.Error("should have dialed again after a failure")
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sshfpTimeout = 5 * time.Second
+
+	dnsTypeSSHFP = 44
+	dnsTypeOPT   = 41
+	dnsClassIN   = 1
+
+	// dns header flags
+	dnsFlagResponse  = 1 << 15
+	dnsFlagTruncated = 1 << 9
+	dnsFlagRecursion = 1 << 8
+	dnsFlagAuthentic = 1 << 5
+	// dnsFlagDNSSECOk is in the ttl of the edns0 opt record
+	dnsFlagDNSSECOk = 1 << 15
+)
+
+// sshfpRecord is a host key fingerprint published in dns (RFC 4255)
+type sshfpRecord struct {
+	Algorithm   byte
+	Type        byte
+	Fingerprint []byte
+}
+
+var errDNSShort = errors.New("dns response was too short")
+
+// lookupSSHFP asks the first nameserver in /etc/resolv.conf for the SSHFP
+// records of host. authenticated is true when the resolver says it
+// validated the answer with DNSSEC, we can't do that ourselves so this is
+// only as trustworthy as the resolver and the network in between.
+func lookupSSHFP(host string) (records []sshfpRecord, authenticated bool, err error) {
+	server, err := resolvConfNameserver("/etc/resolv.conf")
+	if err != nil {
+		return nil, false, err
+	}
+
+	return querySSHFP(server, host)
+}
+
+// resolvConfNameserver finds the first nameserver in a resolv.conf
+func resolvConfNameserver(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+
+		// Strip ipv6 zones, they're not understood by JoinHostPort
+		ip := fields[1]
+		if i := strings.IndexByte(ip, '%'); i >= 0 {
+			ip = ip[:i]
+		}
+		if net.ParseIP(ip) != nil {
+			return net.JoinHostPort(ip, "53"), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", errors.New("no nameserver found in " + path)
+}
+
+// querySSHFP queries server (host:port) for the SSHFP records of host over
+// udp, retrying over tcp when the answer doesn't fit in a datagram
+func querySSHFP(server, host string) (records []sshfpRecord, authenticated bool, err error) {
+	query, id, err := sshfpQuery(host)
+	if err != nil {
+		return nil, false, err
+	}
+
+	resp, err := dnsExchange("udp", server, query)
+	if err != nil {
+		return nil, false, err
+	}
+
+	records, authenticated, truncated, err := parseSSHFPResponse(resp, id)
+	if err != nil || !truncated {
+		return records, authenticated, err
+	}
+
+	if resp, err = dnsExchange("tcp", server, query); err != nil {
+		return nil, false, err
+	}
+	records, authenticated, _, err = parseSSHFPResponse(resp, id)
+	return records, authenticated, err
+}
+
+// dnsExchange sends a query and reads the response, tcp messages are
+// prefixed with their length
+func dnsExchange(network, server string, query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout(network, server, sshfpTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err = conn.SetDeadline(time.Now().Add(sshfpTimeout)); err != nil {
+		return nil, err
+	}
+
+	if network == "udp" {
+		if _, err = conn.Write(query); err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+
+	msg := make([]byte, 2, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	if _, err = conn.Write(append(msg, query...)); err != nil {
+		return nil, err
+	}
+
+	var ln [2]byte
+	if _, err = io.ReadFull(conn, ln[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(ln[:]))
+	if _, err = io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// sshfpQuery builds a recursive query for the SSHFP records of host that
+// asks for DNSSEC validation (the AD flag and an edns0 record with DO set)
+func sshfpQuery(host string) (query []byte, id uint16, err error) {
+	var idBytes [2]byte
+	if _, err = rand.Read(idBytes[:]); err != nil {
+		return nil, 0, err
+	}
+	id = binary.BigEndian.Uint16(idBytes[:])
+
+	var b bytes.Buffer
+	header := [12]byte{}
+	binary.BigEndian.PutUint16(header[0:], id)
+	binary.BigEndian.PutUint16(header[2:], dnsFlagRecursion|dnsFlagAuthentic)
+	binary.BigEndian.PutUint16(header[4:], 1)  // questions
+	binary.BigEndian.PutUint16(header[10:], 1) // additional (opt)
+	b.Write(header[:])
+
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, 0, fmt.Errorf("invalid host name for dns: %q", host)
+		}
+		b.WriteByte(byte(len(label)))
+		b.WriteString(label)
+	}
+	b.WriteByte(0)
+
+	var question [4]byte
+	binary.BigEndian.PutUint16(question[0:], dnsTypeSSHFP)
+	binary.BigEndian.PutUint16(question[2:], dnsClassIN)
+	b.Write(question[:])
+
+	// Root name, type, udp payload size (class), ttl (DO flag), no rdata
+	var opt [11]byte
+	binary.BigEndian.PutUint16(opt[1:], dnsTypeOPT)
+	binary.BigEndian.PutUint16(opt[3:], 1232)
+	binary.BigEndian.PutUint32(opt[5:], dnsFlagDNSSECOk)
+	b.Write(opt[:])
+
+	return b.Bytes(), id, nil
+}
+
+// parseSSHFPResponse reads the SSHFP records out of the answer section of
+// a dns response, other records (like the signatures) are ignored
+func parseSSHFPResponse(resp []byte, id uint16) (records []sshfpRecord, authenticated, truncated bool, err error) {
+	if len(resp) < 12 {
+		return nil, false, false, errDNSShort
+	}
+
+	flags := binary.BigEndian.Uint16(resp[2:])
+	if binary.BigEndian.Uint16(resp) != id || flags&dnsFlagResponse == 0 {
+		return nil, false, false, errors.New("dns response did not match the query")
+	}
+	if flags&dnsFlagTruncated != 0 {
+		return nil, false, true, nil
+	}
+	switch rcode := flags & 0xf; rcode {
+	case 0:
+	case 3:
+		// The name doesn't exist, so there are no records
+		return nil, false, false, nil
+	default:
+		return nil, false, false, fmt.Errorf("dns server failed with rcode %d", rcode)
+	}
+
+	questions := int(binary.BigEndian.Uint16(resp[4:]))
+	answers := int(binary.BigEndian.Uint16(resp[6:]))
+
+	off := 12
+	for i := 0; i < questions; i++ {
+		if off, err = skipDNSName(resp, off); err != nil {
+			return nil, false, false, err
+		}
+		off += 4
+	}
+
+	for i := 0; i < answers; i++ {
+		if off, err = skipDNSName(resp, off); err != nil {
+			return nil, false, false, err
+		}
+		if off+10 > len(resp) {
+			return nil, false, false, errDNSShort
+		}
+
+		typ := binary.BigEndian.Uint16(resp[off:])
+		ln := int(binary.BigEndian.Uint16(resp[off+8:]))
+		off += 10
+		if off+ln > len(resp) {
+			return nil, false, false, errDNSShort
+		}
+
+		if typ == dnsTypeSSHFP && ln > 2 {
+			rdata := resp[off : off+ln]
+			records = append(records, sshfpRecord{
+				Algorithm:   rdata[0],
+				Type:        rdata[1],
+				Fingerprint: append([]byte(nil), rdata[2:]...),
+			})
+		}
+		off += ln
+	}
+
+	return records, flags&dnsFlagAuthentic != 0, false, nil
+}
+
+// skipDNSName returns the offset after the (possibly compressed) name
+// starting at off
+func skipDNSName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, errDNSShort
+		}
+
+		ln := int(msg[off])
+		switch {
+		case ln == 0:
+			return off + 1, nil
+		case ln&0xc0 == 0xc0:
+			// A pointer ends the name
+			return off + 2, nil
+		default:
+			off += 1 + ln
+		}
+	}
+}
+
+// sshfpAlgorithm is the SSHFP algorithm number of a key type, 0 if it has
+// none
+func sshfpAlgorithm(keyType string) byte {
+	switch keyType {
+	case ssh.KeyAlgoRSA:
+		return 1
+	case ssh.KeyAlgoDSA:
+		return 2
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		return 3
+	case ssh.KeyAlgoED25519:
+		return 4
+	}
+
+	return 0
+}
+
+// matchSSHFP reports if any of the records are for key. Records of the
+// key's algorithm that don't match it are reported as a mismatch so a
+// changed key can be told apart from a host that publishes no records.
+func matchSSHFP(records []sshfpRecord, key ssh.PublicKey) (matched, mismatched bool) {
+	algo := sshfpAlgorithm(key.Type())
+	if algo == 0 {
+		return false, false
+	}
+
+	blob := key.Marshal()
+	for _, r := range records {
+		if r.Algorithm != algo {
+			continue
+		}
+
+		var sum []byte
+		switch r.Type {
+		case 1:
+			s := sha1.Sum(blob)
+			sum = s[:]
+		case 2:
+			s := sha256.Sum256(blob)
+			sum = s[:]
+		default:
+			continue
+		}
+
+		if bytes.Equal(sum, r.Fingerprint) {
+			return true, false
+		}
+		mismatched = true
+	}
+
+	return false, mismatched
+}
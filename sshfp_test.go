@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeSSHFPResponse answers a query with records, the question is copied
+// and answers point back to its name
+func fakeSSHFPResponse(query []byte, authenticated, truncated bool, records []sshfpRecord) []byte {
+	// The question ends before the opt record
+	question := query[12 : len(query)-11]
+
+	resp := make([]byte, 12)
+	copy(resp, query[:2])
+	flags := uint16(dnsFlagResponse | dnsFlagRecursion)
+	if authenticated {
+		flags |= dnsFlagAuthentic
+	}
+	if truncated {
+		flags |= dnsFlagTruncated
+	}
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(records)+1))
+	resp = append(resp, question...)
+
+	// An unrelated record (like an RRSIG) to skip over
+	resp = append(resp, 0xc0, 12, 0, 46, 0, 1, 0, 0, 0, 60, 0, 3, 1, 2, 3)
+
+	for _, r := range records {
+		resp = append(resp, 0xc0, 12, 0, dnsTypeSSHFP, 0, 1, 0, 0, 0, 60)
+		resp = append(resp, 0, byte(2+len(r.Fingerprint)), r.Algorithm, r.Type)
+		resp = append(resp, r.Fingerprint...)
+	}
+
+	return resp
+}
+
+func TestQuerySSHFP(t *testing.T) {
+	t.Parallel()
+
+	want := sshfpRecord{Algorithm: 4, Type: 2, Fingerprint: make([]byte, 32)}
+	want.Fingerprint[0] = 0xaa
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+
+	// The tcp server has to be on the same port, udp is only ever truncated
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		t.Skip("could not listen on the same tcp port:", err)
+	}
+	defer tcp.Close()
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = udp.WriteTo(fakeSSHFPResponse(buf[:n], false, true, nil), addr)
+		}
+	}()
+	go func() {
+		conn, err := tcp.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var ln [2]byte
+		if _, err = io.ReadFull(conn, ln[:]); err != nil {
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(ln[:]))
+		if _, err = io.ReadFull(conn, query); err != nil {
+			return
+		}
+
+		resp := fakeSSHFPResponse(query, true, false, []sshfpRecord{want})
+		binary.BigEndian.PutUint16(ln[:], uint16(len(resp)))
+		_, _ = conn.Write(append(ln[:], resp...))
+	}()
+
+	records, authenticated, err := querySSHFP(udp.LocalAddr().String(), "host.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !authenticated {
+		t.Error("want authenticated")
+	}
+	if len(records) != 1 || records[0].Algorithm != 4 || records[0].Type != 2 ||
+		string(records[0].Fingerprint) != string(want.Fingerprint) {
+		t.Errorf("records were wrong: %#v", records)
+	}
+}
+
+func TestParseSSHFPResponse(t *testing.T) {
+	t.Parallel()
+
+	query, id, err := sshfpQuery("host.example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := fakeSSHFPResponse(query, false, false, nil)
+	if _, _, _, err = parseSSHFPResponse(resp, id+1); err == nil {
+		t.Error("want an error for the wrong id")
+	}
+	if _, _, _, err = parseSSHFPResponse(resp[:len(resp)-2], id); err != errDNSShort {
+		t.Error("want a short error, got:", err)
+	}
+
+	records, authenticated, truncated, err := parseSSHFPResponse(resp, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 || authenticated || truncated {
+		t.Error("want nothing, got:", records, authenticated, truncated)
+	}
+
+	if _, _, err = sshfpQuery("bad..host"); err == nil {
+		t.Error("want an error for an empty label")
+	}
+}
+
+func TestMatchSSHFP(t *testing.T) {
+	t.Parallel()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(key.Marshal())
+
+	good := sshfpRecord{Algorithm: 4, Type: 2, Fingerprint: sum[:]}
+	bad := sshfpRecord{Algorithm: 4, Type: 2, Fingerprint: make([]byte, 32)}
+	rsa := sshfpRecord{Algorithm: 1, Type: 2, Fingerprint: make([]byte, 32)}
+
+	tests := []struct {
+		Records    []sshfpRecord
+		Matched    bool
+		Mismatched bool
+	}{
+		{Records: nil},
+		{Records: []sshfpRecord{rsa}},
+		{Records: []sshfpRecord{rsa, good}, Matched: true},
+		{Records: []sshfpRecord{bad, good}, Matched: true},
+		{Records: []sshfpRecord{bad}, Mismatched: true},
+	}
+
+	for i, test := range tests {
+		matched, mismatched := matchSSHFP(test.Records, key)
+		if matched != test.Matched || mismatched != test.Mismatched {
+			t.Errorf("%d) want: %t %t, got: %t %t", i, test.Matched, test.Mismatched, matched, mismatched)
+		}
+	}
+}
+
+func TestResolvConfNameserver(t *testing.T) {
+	t.Parallel()
+
+	f, err := ioutil.TempFile("", "resolv.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString("# comment\nsearch example.com\nnameserver fe80::1%eth0\nnameserver 1.1.1.1\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := resolvConfNameserver(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server != "[fe80::1]:53" {
+		t.Error("server was wrong:", server)
+	}
+}
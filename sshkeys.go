@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/aarondl/bpass/blobformat"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Kinds of ssh keys generateSSHKey makes
+const (
+	sshKeyED25519 = "ed25519"
+	sshKeyRSA     = "rsa"
+)
+
+// generateSSHKey makes an ssh keypair of a kind, the private key is pkcs8 pem
+// and the public key an authorized_keys line ending in comment
+func generateSSHKey(kind, comment string) (priv, pub string, err error) {
+	var key interface{}
+	var public interface{}
+	switch kind {
+	case sshKeyED25519:
+		edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to generate ed25519 ssh key: %w", err)
+		}
+		key, public = edPriv, edPub
+	case sshKeyRSA:
+		rsaPriv, err := rsa.GenerateKey(rand.Reader, 4096)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to generate rsa-4096 ssh key: %w", err)
+		}
+		key, public = rsaPriv, &rsaPriv.PublicKey
+	default:
+		return "", "", fmt.Errorf("unknown ssh key kind %q, it must be %s or %s", kind, sshKeyED25519, sshKeyRSA)
+	}
+
+	// Marshal private key into DER ASN.1 then to PEM
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal %s private key with x509: %w", kind, err)
+	}
+	b = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b})
+
+	sshPub, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse public key: %w", err)
+	}
+	pub = string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(sshPub))) + " " + comment
+
+	return string(bytes.TrimSpace(b)), pub, nil
+}
+
+// sshKeyGen generates an ssh keypair for an entry into its privkey and
+// pubkey keys, replacing the ones it has after asking
+func (u *uiContext) sshKeyGen(search, kind string) error {
+	uuid, err := u.findOne(search)
+	if err != nil {
+		return err
+	}
+	if len(uuid) == 0 {
+		return nil
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+	if !checkUnlocked(blob) {
+		return nil
+	}
+
+	if _, ok := blob[blobformat.KeyPriv]; ok {
+		yes, err := u.getYesNo(fmt.Sprintf("%s already has a private key, replace it?", blob.Name()))
+		if err != nil || !yes {
+			return err
+		}
+	}
+
+	priv, pub, err := generateSSHKey(kind, "@bpass")
+	if err != nil {
+		errColor.Println(err)
+		return nil
+	}
+
+	err = u.store.Do(func() error {
+		if err := u.store.Set(uuid, blobformat.KeyPriv, priv); err != nil {
+			return err
+		}
+		return u.store.Set(uuid, blobformat.KeyPub, pub)
+	})
+	if err != nil {
+		return err
+	}
+
+	infoColor.Printf("successfully generated new %s key:\n%s\n", kind, pub)
+	return nil
+}
+
+// sshKeyExport writes the private key of an entry to a file in the openssh
+// format ssh and ssh-keygen use, it's only readable by the current user.
+// An existing file is only replaced if the user says so.
+func (u *uiContext) sshKeyExport(search, filename string) error {
+	uuid, err := u.findOne(search)
+	if err != nil {
+		return err
+	}
+	if len(uuid) == 0 {
+		return nil
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+
+	privPEM, err := u.store.Resolve(blob, blobformat.KeyPriv)
+	if err != nil {
+		return err
+	}
+	if len(privPEM) == 0 {
+		errColor.Printf("%s has no %q key\n", blob.Name(), blobformat.KeyPriv)
+		return nil
+	}
+
+	key, err := ssh.ParseRawPrivateKey([]byte(privPEM))
+	if err != nil {
+		errColor.Println("failed to parse private key:", err)
+		return nil
+	}
+	comment := blob.Name()
+	if fields := strings.Fields(blob[blobformat.KeyPub]); len(fields) > 2 {
+		comment = strings.Join(fields[2:], " ")
+	}
+	b, err := marshalOpenSSHKey(key, comment)
+	if err != nil {
+		errColor.Println(err)
+		return nil
+	}
+
+	wrote, err := u.writeNewFile(filename, b, 0600)
+	if err != nil {
+		return err
+	}
+	if wrote {
+		infoColor.Println("wrote:", filename)
+	}
+	return nil
+}
+
+// showSSHKeyInfo displays the fingerprint of an authorized_keys line
+func showSSHKeyInfo(u *uiContext, pub string, width, indent int) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pub))
+	if err != nil {
+		showKeyValue(u, "fingerprint", errColor.Sprint("failed to parse public key: ", err), width, indent)
+		return
+	}
+
+	showKeyValue(u, "fingerprint", ssh.FingerprintSHA256(key), width, indent)
+}
+
+// marshalOpenSSHKey encodes an unencrypted private key in the
+// openssh-key-v1 format (PROTOCOL.key in openssh), the pem block ssh-keygen
+// writes
+func marshalOpenSSHKey(key interface{}, comment string) ([]byte, error) {
+	var keyType string
+	var pub ssh.PublicKey
+	var fields []byte
+	var err error
+
+	// Keys parsed from the openssh format are pointers
+	if k, ok := key.(*ed25519.PrivateKey); ok {
+		key = *k
+	}
+
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		keyType = ssh.KeyAlgoED25519
+		if pub, err = ssh.NewPublicKey(k.Public()); err != nil {
+			return nil, err
+		}
+		fields = ssh.Marshal(struct {
+			Pub  []byte
+			Priv []byte
+		}{[]byte(k.Public().(ed25519.PublicKey)), []byte(k)})
+	case *rsa.PrivateKey:
+		keyType = ssh.KeyAlgoRSA
+		if pub, err = ssh.NewPublicKey(&k.PublicKey); err != nil {
+			return nil, err
+		}
+		if len(k.Primes) != 2 {
+			return nil, fmt.Errorf("rsa keys with %d primes can't be written", len(k.Primes))
+		}
+		k.Precompute()
+		fields = ssh.Marshal(struct {
+			N, E, D, Iqmp, P, Q *big.Int
+		}{k.N, big.NewInt(int64(k.E)), k.D, k.Precomputed.Qinv, k.Primes[0], k.Primes[1]})
+	default:
+		return nil, fmt.Errorf("%T keys can't be written in the openssh format", key)
+	}
+
+	// Both check ints are the same random number, they tell whether
+	// decryption worked
+	var check [4]byte
+	if _, err = rand.Read(check[:]); err != nil {
+		return nil, err
+	}
+	checkInt := binary.BigEndian.Uint32(check[:])
+
+	block := ssh.Marshal(struct {
+		Check1, Check2 uint32
+		KeyType        string
+	}{checkInt, checkInt, keyType})
+	block = append(block, fields...)
+	block = append(block, ssh.Marshal(struct{ Comment string }{comment})...)
+	for i := byte(1); len(block)%8 != 0; i++ {
+		block = append(block, i)
+	}
+
+	b := []byte("openssh-key-v1\x00")
+	b = append(b, ssh.Marshal(struct {
+		CipherName, KDFName, KDFOptions string
+		NumKeys                         uint32
+		PubKey, PrivKeyBlock            []byte
+	}{"none", "none", "", 1, pub.Marshal(), block})...)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: b}), nil
+}
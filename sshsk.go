@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// isSKKey checks if a key type is held on a security key (fido2/u2f)
+func isSKKey(keyType string) bool {
+	switch keyType {
+	case ssh.KeyAlgoSKED25519, ssh.KeyAlgoSKECDSA256:
+		return true
+	}
+
+	return false
+}
+
+// generateSKKey creates a resident ed25519-sk key on the user's security key
+// using ssh-keygen (openssh 8.2+) which asks for the pin and touch itself.
+// Only the public key is returned, the key handle that ssh-keygen writes
+// is thrown away since resident keys can be loaded into the ssh-agent
+// straight from the security key (ssh-add -K).
+//
+// Resident keys with the same application overwrite each other so each
+// host should get its own.
+func generateSKKey(application string) (string, error) {
+	command, err := exec.LookPath("ssh-keygen")
+	if err != nil {
+		return "", errors.New("ssh-keygen (openssh 8.2 or later) is needed to create security key ssh keys")
+	}
+
+	dir, err := ioutil.TempDir("", "bpass-sk")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "id_ed25519_sk")
+	cmd := exec.Command(command, "-t", "ed25519-sk", "-O", "resident", "-O", "application="+application,
+		"-N", "", "-C", "@bpass", "-f", file)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err = cmd.Run(); err != nil {
+		return "", fmt.Errorf("ssh-keygen failed: %w", err)
+	}
+
+	pub, err := ioutil.ReadFile(file + ".pub")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(pub)), nil
+}
+
+// preferAgentKey moves the agent's signer for pub to the front so it's
+// offered first, false if the agent doesn't have it
+func preferAgentKey(signers []ssh.Signer, pub ssh.PublicKey) ([]ssh.Signer, bool) {
+	want := string(pub.Marshal())
+	for i, s := range signers {
+		if string(s.PublicKey().Marshal()) != want {
+			continue
+		}
+
+		sorted := make([]ssh.Signer, 0, len(signers))
+		sorted = append(sorted, s)
+		sorted = append(sorted, signers[:i]...)
+		sorted = append(sorted, signers[i+1:]...)
+		return sorted, true
+	}
+
+	return signers, false
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestPreferAgentKey(t *testing.T) {
+	t.Parallel()
+
+	var signers []ssh.Signer
+	for i := 0; i < 3; i++ {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers = append(signers, signer)
+	}
+
+	want := signers[2]
+	sorted, ok := preferAgentKey(signers, want.PublicKey())
+	if !ok {
+		t.Fatal("key should have been found")
+	}
+	if len(sorted) != 3 || sorted[0] != want || sorted[1] != signers[0] || sorted[2] != signers[1] {
+		t.Error("order was wrong")
+	}
+	if signers[2] != want {
+		t.Error("original was modified")
+	}
+
+	if _, ok = preferAgentKey(signers[:2], signers[2].PublicKey()); ok {
+		t.Error("key should not have been found")
+	}
+}
+
+func TestIsSKKey(t *testing.T) {
+	t.Parallel()
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte("sk-ssh-ed25519@openssh.com AAAAGnNrLXNzaC1lZDI1NTE5QG9wZW5zc2guY29tAAAAICFo/k5LU8863u66YC9eUO2170QduohPURkQnbLa/dczAAAABHNzaDo= @bpass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSKKey(pub.Type()) {
+		t.Error("should be a security key:", pub.Type())
+	}
+	if isSKKey(ssh.KeyAlgoED25519) {
+		t.Error("ed25519 is not a security key")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aarondl/bpass/blobformat"
+)
+
+// statsLargest is how many of the largest entries stats shows
+const statsLargest = 5
+
+// stats shows what takes up the file: how many entries there are, how big
+// their values and attachments are and how much of the log is history
+func (u *uiContext) stats() error {
+	if err := u.store.UpdateSnapshot(); err != nil {
+		return err
+	}
+
+	type entrySize struct {
+		name string
+		size int64
+	}
+
+	var entries, trashed, attachments int
+	var values, attached int64
+	sizes := make([]entrySize, 0, len(u.store.DB.Snapshot))
+	for _, entry := range u.store.DB.Snapshot {
+		blob := blobformat.Blob(entry)
+		if blob.IsTrashed() {
+			trashed++
+		} else {
+			entries++
+		}
+
+		var size int64
+		for k, v := range entry {
+			size += int64(len(k) + len(v))
+		}
+		values += size
+		sizes = append(sizes, entrySize{name: blob.Name(), size: size})
+
+		for _, a := range blob.Attachments() {
+			attachments++
+			attached += int64(a.Size)
+		}
+	}
+
+	var history int64
+	for _, tx := range u.store.DB.Log {
+		history += int64(len(tx.Key) + len(tx.Value))
+	}
+
+	onDisk := "not saved yet"
+	if info, err := os.Stat(u.filename); err == nil {
+		onDisk = byteSize(info.Size()) + " on disk"
+	}
+
+	sort.Slice(sizes, func(i, j int) bool {
+		if sizes[i].size != sizes[j].size {
+			return sizes[i].size > sizes[j].size
+		}
+		return sizes[i].name < sizes[j].name
+	})
+	if len(sizes) > statsLargest {
+		sizes = sizes[:statsLargest]
+	}
+	largest := make([]string, len(sizes))
+	for i, s := range sizes {
+		largest[i] = fmt.Sprintf("%s (%s)", s.name, byteSize(s.size))
+	}
+
+	fmt.Fprintf(u.out, "  %s %s\n", keyColor.Sprint("file:       "), onDisk)
+	fmt.Fprintf(u.out, "  %s %d (%d in the trash)\n", keyColor.Sprint("entries:    "), entries, trashed)
+	fmt.Fprintf(u.out, "  %s %s, %s with the history in %d changes (see compact)\n",
+		keyColor.Sprint("values:     "), byteSize(values), byteSize(history), len(u.store.DB.Log))
+	fmt.Fprintf(u.out, "  %s %d (%s)\n", keyColor.Sprint("attachments:"), attachments, byteSize(attached))
+	if len(largest) != 0 {
+		fmt.Fprintf(u.out, "  %s %s\n", keyColor.Sprint("largest:    "), strings.Join(largest, ", "))
+	}
+
+	return nil
+}
+
+// byteSize formats a number of bytes in the largest binary unit it has one
+// of
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
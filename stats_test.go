@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	out := new(bytes.Buffer)
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: out}
+	uuid, err := u.store.New("small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.store.Attach(uuid, "key.bin", make([]byte, 2048)); err != nil {
+		t.Fatal(err)
+	}
+	uuid, err = u.store.New("trashed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.store.Trash(uuid)
+
+	if err = u.stats(); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{"not saved yet", "1 (1 in the trash)", "1 (2.0 KiB)", "small (2.8 KiB), trashed"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	t.Parallel()
+
+	tests := map[int64]string{
+		0:           "0 B",
+		1023:        "1023 B",
+		1024:        "1.0 KiB",
+		1536:        "1.5 KiB",
+		10 << 20:    "10.0 MiB",
+		3 << 30 / 2: "1.5 GiB",
+	}
+	for n, want := range tests {
+		if got := byteSize(n); got != want {
+			t.Errorf("%d) want: %q, got: %q", n, want, got)
+		}
+	}
+}
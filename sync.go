@@ -1,20 +1,30 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
 	"crypto/sha256"
 	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aarondl/bpass/blobformat"
 	"github.com/aarondl/bpass/crypt"
 	"github.com/aarondl/bpass/scpsync"
+	"github.com/aarondl/bpass/secmem"
 	"github.com/aarondl/bpass/txlogs"
 
 	"golang.org/x/crypto/ssh"
@@ -31,6 +41,13 @@ type blobParts struct {
 	Creds  credentials
 	Params crypt.Params
 	Log    []txlogs.Tx
+
+	// Base is the common ancestor of the local log and Log, see
+	// txlogs.Merge3. Exclude is what's left out of pushes to the remote.
+	// Trusted are the device keys trusted during the sync, see verifyLog.
+	Base    txlogs.Base
+	Exclude []string
+	Trusted map[string]bool
 }
 
 var (
@@ -43,39 +60,70 @@ func (u *uiContext) sync(name string, auto, push bool) error {
 		return err
 	}
 
-	var syncs []string
-	if len(name) != 0 {
-		uuid, _, err := u.store.FindByName(name)
-		if err != nil {
-			return err
-		}
+	syncs, err := u.findSyncs(name)
+	if err != nil || len(syncs) == 0 {
+		return err
+	}
 
-		if len(uuid) == 0 {
-			errColor.Printf("could not find entry with name: %q\n", name)
+	if auto {
+		syncs = scheduledSyncs(u.store, syncs, time.Now())
+		if len(syncs) == 0 {
 			return nil
 		}
+	}
 
-		syncs = []string{uuid}
-	} else {
-		syncs, err = collectSyncs(u.store)
-		if err != nil {
-			return err
+	// Hosts are dialed once and used for both the pull and the push
+	u.sshConns = newSSHConnCache()
+	defer func() {
+		u.sshConns.close()
+		u.sshConns = nil
+	}()
+
+	// Push-only entries are never pulled from (or merged), they're always
+	// pushed to
+	var pushOnly []string
+	pulled := make([]string, 0, len(syncs))
+	for _, uuid := range syncs {
+		if syncDirection(u.store.Snapshot[uuid]) == blobformat.DirectionPush {
+			pushOnly = append(pushOnly, uuid)
+		} else {
+			pulled = append(pulled, uuid)
 		}
 	}
+	syncs = pulled
 
 	// From this point on we don't worry about keys not being present for
 	// the most part since collectSyncs should only return valid things
 	hosts := make(map[string]string)
 	dupeCheck := make([][64]byte, 0, len(syncs))
 	blobs := make([]blobParts, 0, len(syncs))
+	remotes := make(map[string]remoteState, len(syncs))
+	remembers := make(map[string]credentials)
+	trusted := make(map[string]bool)
+
+	// Ensure we have a key to sign with before anything is pushed
+	signKey, err := u.deviceKey()
+	if err != nil {
+		return err
+	}
+
+	// Every pull and push is recorded once we're done, after the merge has
+	// replaced the log
+	var records []blobformat.SyncRecord
+	defer func() {
+		if err := u.store.AddSyncRecords(records...); err != nil {
+			errColor.Println("failed to write sync log:", err)
+		}
+	}()
+
+	pulls := pullAll(u, syncs)
 Syncs:
 	for i, uuid := range syncs {
 		entry := u.store.Snapshot[uuid]
 		name, _ := entry[blobformat.KeyName]
 
-		infoColor.Println("pull:", name)
-
-		ct, hostentry, err := pullBlob(u, uuid)
+		ct, hostentry, err := pulls[i].ct, pulls[i].hostentry, pulls[i].err
+		records = append(records, newSyncRecord(blobformat.SyncOpPull, entry, len(ct), err))
 
 		// Add to known hosts
 		if len(hostentry) != 0 {
@@ -90,6 +138,12 @@ Syncs:
 			continue
 		}
 
+		if ct, err = u.verifyPayload(name, ct, trusted); err != nil {
+			errColor.Printf("rejecting %q: %v\n", name, err)
+			syncs[i] = ""
+			continue
+		}
+
 		hash := sha512.Sum512(ct)
 		for _, d := range dupeCheck {
 			if hash == d {
@@ -97,8 +151,9 @@ Syncs:
 				continue Syncs
 			}
 		}
+		dupeCheck = append(dupeCheck, hash)
 
-		params, creds, pt, err := decryptBlob(u, name, ct)
+		params, creds, pt, err := decryptBlob(u, entry, ct)
 		if err != nil {
 			errColor.Printf("failed to decrypt %q: %v\n", name, err)
 			syncs[i] = ""
@@ -110,113 +165,710 @@ Syncs:
 		}
 
 		log, err := txlogs.NewLog(pt)
+		secmem.Wipe(pt)
 		if err != nil {
 			errColor.Printf("failed parsing log %q: %v\n", name, err)
 			syncs[i] = ""
 			continue
 		}
 
-		if len(log) == len(u.store.DB.Log) &&
+		if remember, err := u.offerRememberCreds(entry, creds); err == nil && remember {
+			remembers[uuid] = creds
+		}
+
+		salt, _ := crypt.Salt([]byte(creds.User), ct)
+		remotes[uuid] = remoteState{Log: log, Salt: salt}
+
+		if len(log) != 0 && len(log) == len(u.store.DB.Log) &&
 			log[0].Time == u.store.DB.Log[0].Time &&
 			log[len(log)-1].Time == u.store.DB.Log[len(u.store.DB.Log)-1].Time {
 			infoColor.Printf("skip: %s (no changes)\n", name)
-			syncs[i] = ""
 			continue
 		}
 
+		// A base that doesn't parse is no base, the merge is two-way
+		base, _ := txlogs.ParseBase(entry[blobformat.KeySyncBase])
 		blobs = append(blobs, blobParts{
-			Name:   name,
-			Creds:  creds,
-			Params: params,
-			Log:    log,
+			Name:    name,
+			Creds:   creds,
+			Params:  params,
+			Log:     log,
+			Base:    base,
+			Exclude: syncExcludes(entry),
+			Trusted: trusted,
 		})
 	}
 
 	out, err := mergeBlobs(u, blobs)
 	if err != nil {
 		errColor.Println("aborting sync, failed to merge logs:", err)
+		// Not something to carry on from as if the remote was unreachable
+		if txlogs.IsTamperError(err) {
+			return err
+		}
 		return nil
 	}
 
 	u.user, u.pass = out.User, out.Pass
-	u.key, u.salt = out.Key, out.Salt
-	u.master, u.ivm = out.Master, out.IVM
+	u.setKey(out.Key)
+	u.salt = out.Salt
+	u.setMaster(out.Master)
+	u.ivm = out.IVM
 
-	u.store.ResetSnapshot()
-	u.store.Log = out.Log
-	if err = u.store.UpdateSnapshot(); err != nil {
+	if err = u.store.DB.ReplaceLog(out.Log); err != nil {
 		errColor.Println("failed to rebuild snapshot, poisoned by sync:", err)
 		errColor.Println("exiting to avoid corrupting local file")
 		os.Exit(1)
 	}
+	if err = u.applyResolved(out.Resolved); err != nil {
+		return err
+	}
+	if err = u.recompact(); err != nil {
+		errColor.Println("failed to compact the merged log:", err)
+	}
 
 	if err = saveHosts(u.store.DB, hosts); err != nil {
 		return err
 	}
 
+	if err = u.trustDevices(trusted); err != nil {
+		return err
+	}
+
+	// Saved after the merge since it may have changed our key
+	for uuid, creds := range remembers {
+		if err = u.rememberRemoteCreds(uuid, creds); err != nil {
+			return err
+		}
+	}
+
 	if !push {
 		return nil
 	}
 
-	// Save & encrypt in memory
-	var pt, ct []byte
-	if pt, err = u.store.Save(); err != nil {
+	// Push back to other machines, an empty uuid is a signal that pulling
+	// did not work so don't attempt to push there
+	hosts = make(map[string]string)
+	pushes := make([]string, 0, len(syncs)+len(pushOnly))
+	for _, uuid := range append(syncs, pushOnly...) {
+		if len(uuid) == 0 {
+			continue
+		}
+		if syncDirection(u.store.Snapshot[uuid]) == blobformat.DirectionPull {
+			continue
+		}
+
+		if remote, ok := remotes[uuid]; ok && u.remoteUpToDate(uuid, remote) {
+			infoColor.Printf("skip push: %s (up to date)\n", u.store.Snapshot[uuid][blobformat.KeyName])
+			u.saveSyncBase(uuid)
+			continue
+		}
+
+		pushes = append(pushes, uuid)
+	}
+
+	// Save & encrypt in memory for each target, leaving out anything the
+	// target shouldn't have. Targets excluding the same things share.
+	payloads := make(map[string][]byte)
+	cts := make([][]byte, len(pushes))
+	for i, uuid := range pushes {
+		entry := u.store.Snapshot[uuid]
+		exclude := syncExcludes(entry)
+		cacheKey := strings.Join(exclude, ",")
+
+		ct, ok := payloads[cacheKey]
+		if !ok {
+			var excluded int
+			ct, excluded, err = u.syncPayload(signKey, exclude)
+			if err != nil {
+				return err
+			}
+			payloads[cacheKey] = ct
+
+			if excluded != 0 {
+				infoColor.Printf("not pushing %d entries to %s (excluded by labels)\n", excluded, entry[blobformat.KeyName])
+			}
+		}
+		cts[i] = ct
+	}
+
+	settings := u.syncSettings()
+	pushEntries := make([]txlogs.Entry, len(pushes))
+	for i, uuid := range pushes {
+		pushEntries[i] = u.store.Snapshot[uuid]
+	}
+
+	hostentries := make([]string, len(pushes))
+	errs := make([]error, len(pushes))
+	parallel(len(pushes), func(i int) {
+		name := pushEntries[i][blobformat.KeyName]
+		infoColor.Println("push:", name)
+
+		hostentries[i], errs[i] = pushBlob(u, settings, pushEntries[i], cts[i])
+		if errs[i] != nil {
+			errColor.Printf("error pushing to %q: %v\n", name, errs[i])
+		}
+	})
+
+	for i, hostentry := range hostentries {
+		if len(hostentry) != 0 {
+			hosts[pushes[i]] = hostentry
+		}
+
+		records = append(records, newSyncRecord(blobformat.SyncOpPush, u.store.Snapshot[pushes[i]], len(cts[i]), errs[i]))
+		if errs[i] == nil {
+			u.saveSyncBase(pushes[i])
+		}
+	}
+
+	if err = saveHosts(u.store.DB, hosts); err != nil {
 		return err
 	}
-	params, err := u.makeParams()
+
+	return nil
+}
+
+// sealKey is the key secrets stored inside the file are sealed with, the
+// master key for multi-user files and the user's key otherwise. Anything
+// sealed can no longer be opened once the key changes (passwd, rekey).
+func (u *uiContext) sealKey() []byte {
+	if u.master.Len() != 0 {
+		return u.master.Bytes()
+	}
+	return u.key.Bytes()
+}
+
+// remoteCreds returns the credentials remembered on a sync entry, ok is
+// false if there are none or they can't be opened with our key.
+func (u *uiContext) remoteCreds(entry txlogs.Entry) (creds credentials, ok bool) {
+	sealed, err := base64.StdEncoding.DecodeString(entry[blobformat.KeyRemotePass])
+	if err != nil || len(sealed) == 0 {
+		return creds, false
+	}
+
+	pass, err := crypt.Open(u.sealKey(), sealed)
+	if err != nil {
+		return creds, false
+	}
+
+	creds.User = entry[blobformat.KeyRemoteUser]
+	creds.Pass = string(pass)
+	return creds, true
+}
+
+// offerRememberCreds asks if the credentials that were typed in to decrypt
+// a remote should be remembered. There's nothing to ask when they're our
+// own or the ones already remembered.
+func (u *uiContext) offerRememberCreds(entry txlogs.Entry, creds credentials) (bool, error) {
+	if creds.User == u.user && creds.Pass == u.pass {
+		return false, nil
+	}
+	if remembered, ok := u.remoteCreds(entry); ok &&
+		creds.Pass == remembered.Pass && (creds.User == u.user || creds.User == remembered.User) {
+		return false, nil
+	}
+
+	return u.getYesNo(fmt.Sprintf("remember the passphrase for %s on this machine?", entry[blobformat.KeyName]))
+}
+
+// rememberRemoteCreds seals the credentials onto the sync entry
+func (u *uiContext) rememberRemoteCreds(uuid string, creds credentials) error {
+	if creds.User == u.user && creds.Pass == u.pass {
+		// The merge took on the remote's credentials
+		return nil
+	}
+
+	sealed, err := crypt.Seal(u.sealKey(), []byte(creds.Pass))
 	if err != nil {
 		return err
 	}
-	if ct, err = crypt.Encrypt(cryptVersion, params, pt); err != nil {
+
+	// Raw sets so the sync entry isn't updated (and pushed) because of keys
+	// that never leave this machine
+	if creds.User != u.user {
+		u.store.DB.Set(uuid, blobformat.KeyRemoteUser, creds.User)
+	}
+	u.store.DB.Set(uuid, blobformat.KeyRemotePass, base64.StdEncoding.EncodeToString(sealed))
+	return nil
+}
+
+// listSyncs shows all sync entries, their kind and when they were last
+// synced from this machine
+func (u *uiContext) listSyncs() error {
+	if err := u.store.UpdateSnapshot(); err != nil {
 		return err
 	}
 
-	// Push back to other machines
-	hosts = make(map[string]string)
-	for _, uuid := range syncs {
-		if len(uuid) == 0 {
-			// This is a signal that pulling did not work so don't attempt
-			// to push here.
+	records, err := u.store.SyncRecords()
+	if err != nil {
+		return err
+	}
+	lastSynced := make(map[string]blobformat.SyncRecord)
+	for _, r := range records {
+		if len(r.Error) == 0 {
+			lastSynced[r.Sync] = r
+		}
+	}
+
+	var names []string
+	kinds := make(map[string]string)
+	auto := make(map[string]bool)
+	directions := make(map[string]string)
+	for _, entry := range u.store.Snapshot {
+		blob := blobformat.Blob(entry)
+		if !blobformat.IsSyncEntry(blob.Name()) {
 			continue
 		}
 
-		entry := u.store.Snapshot[uuid]
-		name, _ := entry[blobformat.KeyName]
+		names = append(names, blob.Name())
+		kinds[blob.Name()] = "?"
+		if uri, err := url.Parse(blob[blobformat.KeyURL]); err == nil && len(uri.Scheme) != 0 {
+			kinds[blob.Name()] = uri.Scheme
+		}
+		auto[blob.Name()] = blob[blobformat.KeySync] == "true"
+		directions[blob.Name()] = syncDirection(entry)
+	}
 
-		infoColor.Println("push:", name)
+	if len(names) == 0 {
+		infoColor.Println("no sync entries, see addsync")
+		return nil
+	}
 
-		hostentry, err := pushBlob(u, uuid, ct)
-		if err != nil {
-			errColor.Printf("error pushing to %q: %v\n", name, err)
+	sort.Strings(names)
+	for _, name := range names {
+		var flags []string
+		if auto[name] {
+			flags = append(flags, "auto")
+		}
+		if dir := directions[name]; dir != blobformat.DirectionBoth {
+			flags = append(flags, dir+"-only")
 		}
 
-		if len(hostentry) != 0 {
-			hosts[uuid] = hostentry
+		last := hideColor.Sprint("never synced")
+		if r, ok := lastSynced[name]; ok {
+			last = fmt.Sprintf("last %s %s", r.Op, r.Time.Local().Format("2006-01-02 15:04:05"))
 		}
+
+		fmt.Printf("%s %-4s %-14s %s\n", keyColor.Sprintf("%-20s", name), kinds[name], strings.Join(flags, ","), last)
 	}
 
-	if err = saveHosts(u.store.DB, hosts); err != nil {
+	return nil
+}
+
+// findSyncEntry looks up a single sync entry by query
+func (u *uiContext) findSyncEntry(search string) (string, error) {
+	uuid, err := u.findOne(search)
+	if err != nil || len(uuid) == 0 {
+		return "", err
+	}
+
+	// The callers use the snapshot
+	if err = u.store.UpdateSnapshot(); err != nil {
+		return "", err
+	}
+
+	entry := u.store.Snapshot[uuid]
+	if _, ok := entry[blobformat.KeyURL]; !ok || !blobformat.IsSyncEntry(entry[blobformat.KeyName]) {
+		errColor.Printf("%s is not a sync entry\n", entry[blobformat.KeyName])
+		return "", nil
+	}
+
+	return uuid, nil
+}
+
+// removeSync deletes a sync entry
+func (u *uiContext) removeSync(search string) error {
+	uuid, err := u.findSyncEntry(search)
+	if err != nil || len(uuid) == 0 {
+		return err
+	}
+
+	return u.deleteEntry(u.store.Snapshot[uuid][blobformat.KeyName])
+}
+
+// testSync pulls from a single sync entry to check that it can be reached,
+// that we're allowed in and that the file there can be opened. Nothing is
+// merged or pushed.
+func (u *uiContext) testSync(search string) error {
+	uuid, err := u.findSyncEntry(search)
+	if err != nil || len(uuid) == 0 {
 		return err
 	}
 
+	entry := u.store.Snapshot[uuid]
+	name := entry[blobformat.KeyName]
+
+	infoColor.Println("pull:", name)
+	start := time.Now()
+	ct, hostentry, err := pullEntry(u, u.syncSettings(), entry)
+	elapsed := time.Since(start).Round(time.Millisecond)
+
+	if len(hostentry) != 0 {
+		if err := saveHosts(u.store.DB, map[string]string{uuid: hostentry}); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case err == errNotFound:
+		infoColor.Printf("ok: connected in %s, there is no remote file yet\n", elapsed)
+		return nil
+	case err != nil:
+		errColor.Printf("failed after %s: %v\n", elapsed, err)
+		return nil
+	}
+	infoColor.Printf("ok: pulled %d bytes in %s\n", len(ct), elapsed)
+
+	if ct, err = u.verifyPayload(name, ct, make(map[string]bool)); err != nil {
+		errColor.Println("signature:", err)
+		return nil
+	}
+
+	if _, _, pt, err := decryptBlob(u, entry, ct); err != nil || len(pt) == 0 {
+		errColor.Println("failed to decrypt:", err)
+		return nil
+	}
+	infoColor.Println("ok: remote file decrypted")
+
 	return nil
 }
 
+// initSync pushes the file to a sync entry that has no remote file yet,
+// creating the directories it goes in first.
+func (u *uiContext) initSync(search string) error {
+	uuid, err := u.findSyncEntry(search)
+	if err != nil || len(uuid) == 0 {
+		return err
+	}
+
+	entry := u.store.Snapshot[uuid]
+	name := entry[blobformat.KeyName]
+	if syncDirection(entry) == blobformat.DirectionPull {
+		errColor.Printf("%s is pull-only, it can't be pushed to\n", name)
+		return nil
+	}
+
+	settings := u.syncSettings()
+	_, hostentry, err := pullEntry(u, settings, entry)
+	if len(hostentry) != 0 {
+		if err := saveHosts(u.store.DB, map[string]string{uuid: hostentry}); err != nil {
+			return err
+		}
+		entry = withKnownHost(entry, hostentry)
+	}
+
+	switch {
+	case err == nil:
+		errColor.Printf("%s already has a remote file, use sync instead\n", name)
+		return nil
+	case err != errNotFound:
+		errColor.Printf("error pulling %q: %v\n", name, err)
+		return nil
+	}
+
+	if err = mkdirEntry(u, settings, entry); err != nil {
+		errColor.Printf("failed to create directories for %q: %v\n", name, err)
+		return nil
+	}
+
+	signKey, err := u.deviceKey()
+	if err != nil {
+		return err
+	}
+	ct, excluded, err := u.syncPayload(signKey, syncExcludes(entry))
+	if err != nil {
+		return err
+	}
+	if excluded != 0 {
+		infoColor.Printf("not pushing %d entries to %s (excluded by labels)\n", excluded, name)
+	}
+
+	infoColor.Println("push:", name)
+	err = settings.retry(name, func() error {
+		_, err := pushEntry(u, settings, entry, ct)
+		return err
+	})
+	if recErr := u.store.AddSyncRecords(newSyncRecord(blobformat.SyncOpPush, entry, len(ct), err)); recErr != nil {
+		return recErr
+	}
+	if err != nil {
+		errColor.Printf("error pushing to %q: %v\n", name, err)
+		return nil
+	}
+
+	infoColor.Printf("initialized %s\n", name)
+	return nil
+}
+
+// newSyncRecord creates a sync log record for an operation that just finished
+func newSyncRecord(op string, entry txlogs.Entry, bytes int, err error) blobformat.SyncRecord {
+	r := blobformat.SyncRecord{
+		Time:  time.Now(),
+		Op:    op,
+		Sync:  entry[blobformat.KeyName],
+		Host:  "localhost",
+		Bytes: bytes,
+	}
+
+	if uri, err := url.Parse(entry[blobformat.KeyURL]); err == nil && len(uri.Host) != 0 {
+		r.Host = uri.Host
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	return r
+}
+
+// syncLog shows the last n records of the sync log
+func (u *uiContext) syncLog(n int) error {
+	records, err := u.store.SyncRecords()
+	if err != nil {
+		return err
+	}
+
+	if len(records) == 0 {
+		infoColor.Println("nothing has been synced yet")
+		return nil
+	}
+
+	if n > 0 && len(records) > n {
+		records = records[len(records)-n:]
+	}
+
+	for _, r := range records {
+		result := "ok"
+		if len(r.Error) != 0 {
+			result = errColor.Sprint(r.Error)
+		}
+
+		fmt.Printf("%s %s %s (%s) %d bytes: %s\n",
+			hideColor.Sprint(r.Time.Local().Format("2006-01-02 15:04:05")),
+			keyColor.Sprintf("%-4s", r.Op), r.Sync, r.Host, r.Bytes, result)
+	}
+
+	return nil
+}
+
+// remoteState is what we learned about a remote when we pulled from it
+type remoteState struct {
+	Log  []txlogs.Tx
+	Salt []byte
+}
+
+// remoteUpToDate checks if the remote already has exactly what we would push
+// to it, encrypted with our current key.
+func (u *uiContext) remoteUpToDate(uuid string, remote remoteState) bool {
+	if !bytes.Equal(remote.Salt, u.salt) {
+		return false
+	}
+
+	log, _ := u.store.SyncLog(syncExcludes(u.store.Snapshot[uuid])...)
+	ahead, behind := txlogs.Diverge(log, remote.Log)
+	return ahead == 0 && behind == 0
+}
+
+// saveSyncBase records that the remote of the sync entry has what we would
+// push to it now, it's the base of the next merge with it. Entries that
+// aren't merged with don't need one.
+func (u *uiContext) saveSyncBase(uuid string) {
+	entry := u.store.Snapshot[uuid]
+	if syncDirection(entry) != blobformat.DirectionBoth {
+		return
+	}
+
+	log, _ := u.store.SyncLog(syncExcludes(entry)...)
+	base := txlogs.NewBase(log).String()
+	if entry[blobformat.KeySyncBase] != base {
+		// Raw set, it's a local key like the remembered credentials
+		u.store.DB.Set(uuid, blobformat.KeySyncBase, base)
+	}
+}
+
+// syncExcludes returns the labels excluded from a sync entry
+func syncExcludes(entry txlogs.Entry) []string {
+	var exclude []string
+	for _, l := range strings.Split(entry[blobformat.KeyExclude], ",") {
+		if l = strings.TrimSpace(l); len(l) != 0 {
+			exclude = append(exclude, l)
+		}
+	}
+	sort.Strings(exclude)
+
+	return exclude
+}
+
+// syncPayload creates the encrypted file to push to a remote. It contains
+// only the log, the remote can rebuild the snapshot itself.
+func (u *uiContext) syncPayload(signKey ed25519.PrivateKey, exclude []string) (ct []byte, excluded int, err error) {
+	log, excluded := u.store.SyncLog(exclude...)
+
+	pt, err := u.encodeStore(&txlogs.DB{Log: log})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ct, err = u.encryptPayload(pt)
+	secmem.Wipe(pt)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return crypt.Sign(signKey, ct), excluded, nil
+}
+
+// findSyncs returns the sync entry by name, or all the auto-sync entries if
+// name is empty. If the named entry isn't found an error is printed and
+// no syncs are returned.
+func (u *uiContext) findSyncs(name string) ([]string, error) {
+	if len(name) == 0 {
+		return collectSyncs(u.store)
+	}
+
+	uuid, _, err := u.store.FindByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(uuid) == 0 {
+		errColor.Printf("could not find entry with name: %q\n", name)
+		return nil, nil
+	}
+
+	return []string{uuid}, nil
+}
+
+// syncStatus pulls from each sync entry and reports how the remote's log
+// compares to ours without merging or pushing anything.
+func (u *uiContext) syncStatus(name string) error {
+	if err := u.store.UpdateSnapshot(); err != nil {
+		return err
+	}
+
+	syncs, err := u.findSyncs(name)
+	if err != nil {
+		return err
+	}
+
+	// Any new host keys or devices that are accepted are not saved, there's
+	// no writing to the log in this command
+	trusted := make(map[string]bool)
+	pulls := pullAll(u, syncs)
+	for i, uuid := range syncs {
+		name := u.store.Snapshot[uuid][blobformat.KeyName]
+
+		ct, err := pulls[i].ct, pulls[i].err
+		if err == errNotFound {
+			fmt.Printf("%s: %s\n", keyColor.Sprint(name), infoColor.Sprint("no remote file (push would create it)"))
+			continue
+		} else if err != nil {
+			errColor.Printf("error pulling %q: %v\n", name, err)
+			continue
+		}
+
+		if ct, err = u.verifyPayload(name, ct, trusted); err != nil {
+			errColor.Printf("rejecting %q: %v\n", name, err)
+			continue
+		}
+
+		_, _, pt, err := decryptBlob(u, u.store.Snapshot[uuid], ct)
+		if err != nil || len(pt) == 0 {
+			errColor.Printf("failed to decrypt %q: %v\n", name, err)
+			continue
+		}
+
+		log, err := txlogs.NewLog(pt)
+		secmem.Wipe(pt)
+		if err != nil {
+			errColor.Printf("failed parsing log %q: %v\n", name, err)
+			continue
+		}
+		if err = u.verifyLog(u.store.DB.Log, log, trusted); err != nil {
+			errColor.Printf("rejecting %q: %v\n", name, err)
+			continue
+		}
+
+		var status string
+		syncLog, _ := u.store.SyncLog(syncExcludes(u.store.Snapshot[uuid])...)
+		ahead, behind := txlogs.Diverge(syncLog, log)
+		switch {
+		case ahead == 0 && behind == 0:
+			status = infoColor.Sprint("up to date")
+		case behind == 0:
+			status = infoColor.Sprintf("ahead by %d", ahead)
+		case ahead == 0:
+			status = infoColor.Sprintf("behind by %d", behind)
+		default:
+			status = errColor.Sprintf("diverged (ahead by %d, behind by %d)", ahead, behind)
+		}
+
+		fmt.Printf("%s: %s\n", keyColor.Sprint(name), status)
+	}
+
+	return nil
+}
+
+// saveHosts adds the known hosts lines the user accepted while syncing to
+// their sync entries. Hosts whose key was rotated have their old line
+// replaced and the rotation is recorded in the entry's host key history.
 func saveHosts(store *txlogs.DB, newHosts map[string]string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+
 	for uuid, hostentry := range newHosts {
 		entry := store.Snapshot[uuid]
-		hosts := entry[blobformat.KeyKnownHosts]
-		if len(hosts) == 0 {
-			store.Set(uuid, blobformat.KeyKnownHosts, hostentry)
+		known, rotations := mergeKnownHosts(entry[blobformat.KeyKnownHosts], hostentry)
+		store.Set(uuid, blobformat.KeyKnownHosts, known)
+
+		if len(rotations) == 0 {
 			continue
 		}
 
-		hostLines := strings.Split(hosts, "\n")
-		hostLines = append(hostLines, hostentry)
-		store.Set(uuid, blobformat.KeyKnownHosts, strings.Join(hostLines, "\n"))
+		history := entry[blobformat.KeyHostKeyHistory]
+		for _, r := range rotations {
+			if len(history) != 0 {
+				history += "\n"
+			}
+			history += now + " " + r
+		}
+		store.Set(uuid, blobformat.KeyHostKeyHistory, history)
+	}
+
+	return store.UpdateSnapshot()
+}
+
+// mergeKnownHosts adds new known hosts lines to known, replacing any lines
+// for the same hosts. Replaced lines with a different key are returned as
+// rotations: `hostname old-type old-fingerprint -> new-type new-fingerprint`
+func mergeKnownHosts(known, hostentry string) (merged string, rotations []string) {
+	var lines []string
+	if len(known) != 0 {
+		lines = strings.Split(known, "\n")
+	}
+
+	for _, newLine := range strings.Split(hostentry, "\n") {
+		newVals := strings.Split(newLine, " ")
+		if len(newVals) < 4 {
+			continue
+		}
+
+		kept := make([]string, 0, len(lines)+1)
+		for _, line := range lines {
+			vals := strings.Split(line, " ")
+			if len(vals) < 4 || vals[0] != newVals[0] {
+				kept = append(kept, line)
+				continue
+			}
+
+			if vals[2] != newVals[2] || vals[3] != newVals[3] {
+				rotations = append(rotations, fmt.Sprintf("%s %s %s -> %s %s", newVals[0],
+					vals[2], fingerprintSHA256(vals[3]), newVals[2], fingerprintSHA256(newVals[3])))
+			}
+		}
+
+		lines = append(kept, newLine)
 	}
 
-	return store.UpdateSnapshot()
+	return strings.Join(lines, "\n"), rotations
 }
 
 // collectSyncs attempts to gather automatic sync entries and ensure that basic
@@ -249,8 +901,20 @@ func collectSyncs(store blobformat.Blobs) ([]string, error) {
 			continue
 		}
 
+		if schedule := entry[blobformat.KeySchedule]; len(schedule) != 0 {
+			if _, err := parseSchedule(schedule); err != nil {
+				errColor.Printf("%q has a bad schedule: %v (skipping)\n", name, err)
+				continue
+			}
+		}
+
+		if !isSyncDirection(entry[blobformat.KeyDirection]) {
+			errColor.Printf("%q has an unknown direction %q (skipping)\n", name, entry[blobformat.KeyDirection])
+			continue
+		}
+
 		switch u.Scheme {
-		case syncSCP, syncFile:
+		case syncSCP, syncFile, syncHTTP, syncHTTPS:
 			validSyncs = append(validSyncs, uuid)
 		default:
 			errColor.Printf("entry %q is a %q sync account, but this kind is unknown (old bpass version?)\n", name, u.Scheme)
@@ -260,15 +924,116 @@ func collectSyncs(store blobformat.Blobs) ([]string, error) {
 	return validSyncs, nil
 }
 
+// scheduledSyncs filters out the sync entries whose schedule doesn't allow
+// them to be automatically synced at the given time
+func scheduledSyncs(store blobformat.Blobs, syncs []string, now time.Time) []string {
+	allowed := make([]string, 0, len(syncs))
+	for _, uuid := range syncs {
+		entry := store.Snapshot[uuid]
+		if schedule := entry[blobformat.KeySchedule]; len(schedule) != 0 {
+			windows, err := parseSchedule(schedule)
+			if err != nil || !inSchedule(windows, now) {
+				infoColor.Printf("skip: %s (outside of its schedule)\n", entry[blobformat.KeyName])
+				continue
+			}
+		}
+
+		allowed = append(allowed, uuid)
+	}
+
+	return allowed
+}
+
+// syncDirection returns which way a sync entry syncs
+func syncDirection(entry txlogs.Entry) string {
+	if dir := entry[blobformat.KeyDirection]; len(dir) != 0 {
+		return dir
+	}
+	return blobformat.DirectionBoth
+}
+
+func isSyncDirection(dir string) bool {
+	switch dir {
+	case "", blobformat.DirectionBoth, blobformat.DirectionPush, blobformat.DirectionPull:
+		return true
+	default:
+		return false
+	}
+}
+
+// maxSyncWorkers bounds how many remotes are talked to at once
+const maxSyncWorkers = 4
+
+// defaultSyncLogLines is how many records sync log shows by default
+const defaultSyncLogLines = 20
+
+type pullResult struct {
+	ct        []byte
+	hostentry string
+	err       error
+}
+
+// pullAll pulls from each of the sync entries concurrently, the results are
+// in the same order as syncs.
+func pullAll(u *uiContext, syncs []string) []pullResult {
+	// The pulls can't touch the store, everything they need is read first
+	settings := u.syncSettings()
+	entries := make([]txlogs.Entry, len(syncs))
+	for i, uuid := range syncs {
+		entries[i] = u.store.Snapshot[uuid]
+	}
+
+	results := make([]pullResult, len(syncs))
+	parallel(len(syncs), func(i int) {
+		infoColor.Println("pull:", entries[i][blobformat.KeyName])
+
+		r := &results[i]
+		r.ct, r.hostentry, r.err = pullBlob(u, settings, entries[i])
+	})
+
+	return results
+}
+
+// parallel calls fn for 0 to n-1 using at most maxSyncWorkers goroutines
+// and waits for them all to finish.
+func parallel(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxSyncWorkers)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
 // pullBlob tries to download a file from the given sync entry
-func pullBlob(u *uiContext, uuid string) (ct []byte, hostentry string, err error) {
-	entry := u.store.Snapshot[uuid]
+func pullBlob(u *uiContext, s syncSettings, entry txlogs.Entry) (ct []byte, hostentry string, err error) {
+	err = s.retry(entry[blobformat.KeyName], func() error {
+		var newHost string
+		ct, newHost, err = pullEntry(u, s, entry)
+		if len(newHost) != 0 {
+			// Don't ask about the same host key again on retry
+			hostentry = newHost
+			entry = withKnownHost(entry, newHost)
+		}
+		return err
+	})
+
+	return ct, hostentry, err
+}
+
+func pullEntry(u *uiContext, s syncSettings, entry txlogs.Entry) (ct []byte, hostentry string, err error) {
 	// We know this parses because we parsed it once before
 	uri, _ := url.Parse(entry[blobformat.KeyURL])
 
 	switch uri.Scheme {
 	case syncSCP:
-		hostentry, ct, err = sshPull(u, entry)
+		hostentry, ct, err = sshPull(u, s, entry)
 		if scpsync.IsNotFoundErr(err) {
 			return nil, hostentry, errNotFound
 		}
@@ -278,6 +1043,9 @@ func pullBlob(u *uiContext, uuid string) (ct []byte, hostentry string, err error
 		if os.IsNotExist(err) {
 			return nil, "", errNotFound
 		}
+	case syncHTTP, syncHTTPS:
+		// Already errNotFound when it's missing
+		ct, err = httpPull(s, entry)
 	}
 
 	if err != nil {
@@ -288,28 +1056,110 @@ func pullBlob(u *uiContext, uuid string) (ct []byte, hostentry string, err error
 }
 
 // pushBlob uploads a file to a given sync entry
-func pushBlob(u *uiContext, uuid string, payload []byte) (hostentry string, err error) {
-	entry := u.store.Snapshot[uuid]
+func pushBlob(u *uiContext, s syncSettings, entry txlogs.Entry, payload []byte) (hostentry string, err error) {
+	err = s.retry(entry[blobformat.KeyName], func() error {
+		var newHost string
+		newHost, err = pushEntry(u, s, entry, payload)
+		if len(newHost) != 0 {
+			hostentry = newHost
+			entry = withKnownHost(entry, newHost)
+		}
+		return err
+	})
+
+	return hostentry, err
+}
+
+func pushEntry(u *uiContext, s syncSettings, entry txlogs.Entry, payload []byte) (hostentry string, err error) {
 	uri, _ := url.Parse(entry[blobformat.KeyURL])
 
 	switch uri.Scheme {
 	case syncSCP:
-		hostentry, err = sshPush(u, entry, payload)
+		hostentry, err = sshPush(u, s, entry, payload)
 	case syncFile:
 		path := filepath.FromSlash(uri.Path)
 		err = ioutil.WriteFile(path, payload, 0600)
+	case syncHTTP, syncHTTPS:
+		err = httpPush(s, entry, payload)
 	}
 
 	return hostentry, err
 }
 
-func decryptBlob(u *uiContext, name string, ct []byte) (params crypt.Params, creds credentials, pt []byte, err error) {
+// mkdirEntry creates the directories the sync entry's file lives in
+func mkdirEntry(u *uiContext, s syncSettings, entry txlogs.Entry) error {
+	uri, _ := url.Parse(entry[blobformat.KeyURL])
+
+	switch uri.Scheme {
+	case syncSCP:
+		_, _, file, _, _, err := sshConfig(entry)
+		if err != nil {
+			return err
+		}
+
+		dir := path.Dir(file)
+		if dir == "." || dir == "/" {
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+
+		client, _, _, err := sshDial(ctx, u, s, entry)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		return s.scpConn(client, entry).Mkdir(ctx, dir)
+	case syncFile:
+		return os.MkdirAll(filepath.Dir(filepath.FromSlash(uri.Path)), 0700)
+	}
+
+	return nil
+}
+
+// withKnownHost returns a copy of the entry with hostentry added to its
+// known hosts
+func withKnownHost(entry txlogs.Entry, hostentry string) txlogs.Entry {
+	cpy := make(txlogs.Entry, len(entry))
+	for k, v := range entry {
+		cpy[k] = v
+	}
+
+	cpy[blobformat.KeyKnownHosts], _ = mergeKnownHosts(cpy[blobformat.KeyKnownHosts], hostentry)
+
+	return cpy
+}
+
+// decryptBlob decrypts a pulled file, trying our own credentials first then
+// the ones remembered on the sync entry before prompting.
+func decryptBlob(u *uiContext, entry txlogs.Entry, ct []byte) (params crypt.Params, creds credentials, pt []byte, err error) {
+	name := entry[blobformat.KeyName]
+	remembered, hasRemembered := u.remoteCreds(entry)
+	triedUser, triedPass := false, false
+	// Only passphrases typed in count against the lockout
+	var lock *lockout
+
 	creds.User, creds.Pass = u.user, u.pass
-	creds.Key, creds.Salt = u.key, u.salt
+	creds.Key, creds.Salt = u.key.Bytes(), u.salt
 	for {
+		// If the file's salt differs from ours we may have already derived
+		// a key for it earlier in this session
+		key, salt := creds.Key, creds.Salt
+		if fileSalt, err := crypt.Salt([]byte(creds.User), ct); err == nil && !bytes.Equal(fileSalt, salt) {
+			if k := u.derivedKey(creds.Pass, fileSalt); k != nil {
+				key, salt = k, fileSalt
+			}
+		}
+
 		// Decrypt payload with our loaded key
-		_, params, pt, err = crypt.Decrypt([]byte(creds.User), []byte(creds.Pass), creds.Key, creds.Salt, ct)
+		_, params, pt, err = crypt.Decrypt([]byte(creds.User), []byte(creds.Pass), key, salt, ct)
 		if err == nil {
+			if lock != nil {
+				lock.reset()
+			}
+			u.rememberKey(creds.Pass, params.Salts[params.User], params.Keys[params.User])
 			return params, creds, pt, err
 		}
 
@@ -317,11 +1167,32 @@ func decryptBlob(u *uiContext, name string, ct []byte) (params crypt.Params, cre
 		default:
 			return params, creds, nil, err
 		case crypt.ErrNeedUser, crypt.ErrUnknownUser:
+			if hasRemembered && !triedUser && len(remembered.User) != 0 {
+				triedUser = true
+				creds.User = remembered.User
+				continue
+			}
+
 			creds.User, err = u.prompt(promptColor.Sprintf("%s user: ", name))
 			if err != nil {
 				return params, creds, nil, nil
 			}
 		case crypt.ErrWrongPassphrase:
+			if hasRemembered && !triedPass {
+				triedPass = true
+				creds.Pass = remembered.Pass
+				continue
+			}
+
+			if lock == nil {
+				lock = loadLockout(lockoutPath(name))
+			} else {
+				lock.fail()
+			}
+			if err = lock.wait(name); err != nil {
+				return params, creds, nil, err
+			}
+
 			creds.Pass, err = u.promptPassword(promptColor.Sprintf("%s passphrase: ", name))
 			if err != nil || len(creds.Pass) == 0 {
 				return params, creds, nil, nil
@@ -330,47 +1201,213 @@ func decryptBlob(u *uiContext, name string, ct []byte) (params crypt.Params, cre
 	}
 }
 
-func sshPull(u *uiContext, entry txlogs.Entry) (hostentry string, ct []byte, err error) {
-	address, path, config, err := sshConfig(entry)
+func sshPull(u *uiContext, s syncSettings, entry txlogs.Entry) (hostentry string, ct []byte, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	client, path, hostentry, done, err := sshConnect(ctx, u, s, entry)
 	if err != nil {
-		return "", nil, err
+		return hostentry, nil, err
 	}
 
-	known := entry[blobformat.KeyKnownHosts]
-	asker := &hostAsker{u: u, known: known}
-	config.HostKeyCallback = asker.callback
+	file, err := s.scpConn(client, entry).Recv(ctx, path)
+	// A missing file doesn't mean the connection is bad
+	if scpsync.IsNotFoundErr(err) {
+		done(nil)
+	} else {
+		done(err)
+	}
+	if err != nil {
+		return hostentry, nil, err
+	}
+
+	return hostentry, file.Contents, nil
+}
+
+func sshPush(u *uiContext, s syncSettings, entry txlogs.Entry, ct []byte) (hostentry string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	client, path, hostentry, done, err := sshConnect(ctx, u, s, entry)
+	if err != nil {
+		return hostentry, err
+	}
 
-	payload, err := scpsync.Recv(address, config, path)
+	err = s.scpConn(client, entry).Send(ctx, path, 0600, time.Time{}, ct)
+	done(err)
 	if err != nil {
-		return asker.newHost, nil, err
+		return hostentry, err
+	}
+
+	return hostentry, nil
+}
+
+// scpConn sets up transferring files with a connection to the sync entry
+func (s syncSettings) scpConn(client *ssh.Client, entry txlogs.Entry) scpsync.Conn {
+	return scpsync.Conn{
+		Client:    client,
+		SCPPath:   entry[blobformat.KeySCPPath],
+		RateLimit: s.rateLimit,
+	}
+}
+
+// sshAlgorithms sets the algorithms offered to the host of a sync entry
+// (and its jump host) from the entry's keys or else the file's settings.
+// Lists that aren't set anywhere are left to the ssh library's defaults.
+func (s syncSettings) sshAlgorithms(entry txlogs.Entry, config *ssh.ClientConfig) {
+	list := func(key, setting string) []string {
+		value := entry[key]
+		if len(value) == 0 {
+			value = s.algorithms[setting]
+		}
+		return splitAlgorithms(value)
+	}
+
+	config.HostKeyAlgorithms = list(blobformat.KeyHostKeyAlgorithms, blobformat.SettingHostKeyAlgorithms)
+	config.KeyExchanges = list(blobformat.KeyKexAlgorithms, blobformat.SettingKexAlgorithms)
+	config.Ciphers = list(blobformat.KeyCiphers, blobformat.SettingCiphers)
+	config.MACs = list(blobformat.KeyMACs, blobformat.SettingMACs)
+}
+
+// splitAlgorithms splits a comma separated list of algorithms, nil if
+// there are none
+func splitAlgorithms(value string) []string {
+	var algos []string
+	for _, a := range strings.Split(value, ",") {
+		if a = strings.TrimSpace(a); len(a) != 0 {
+			algos = append(algos, a)
+		}
 	}
 
-	return asker.newHost, payload, nil
+	return algos
 }
 
-func sshPush(u *uiContext, entry txlogs.Entry, ct []byte) (hostentry string, err error) {
-	address, path, config, err := sshConfig(entry)
+// sshDial connects to the ssh server of a sync entry, tunneling through its
+// jump host if it has one. hostentry has the known hosts lines of any hosts
+// the user chose to save while connecting, even if connecting failed. The
+// context limits connecting to all of the hosts.
+func sshDial(ctx context.Context, u *uiContext, s syncSettings, entry txlogs.Entry) (client *ssh.Client, path, hostentry string, err error) {
+	address, hostname, path, jump, config, err := sshConfig(entry)
 	if err != nil {
-		return "", err
+		return nil, "", "", err
 	}
+	s.sshAlgorithms(entry, config)
 
 	known := entry[blobformat.KeyKnownHosts]
-	asker := &hostAsker{u: u, known: known}
+	sshfp := entry[blobformat.KeySSHFP] == "true"
+	asker := &hostAsker{u: u, known: known, hostname: hostname, sshfp: sshfp}
 	config.HostKeyCallback = asker.callback
 
-	err = scpsync.Send(address, config, path, 0600, ct)
+	if len(jump) == 0 {
+		client, err = scpsync.DialContext(ctx, address, config)
+		return client, path, asker.newHost, err
+	}
+
+	jumpAddress, jumpHostname, jumpConfig, err := sshJumpConfig(jump, config, loadSSHConfig())
 	if err != nil {
-		return "", err
+		return nil, "", "", err
+	}
+	jumpAsker := &hostAsker{u: u, known: known, hostname: jumpHostname, sshfp: sshfp}
+	jumpConfig.HostKeyCallback = jumpAsker.callback
+
+	jumpClient, err := scpsync.DialContext(ctx, jumpAddress, jumpConfig)
+	if err != nil {
+		return nil, "", jumpAsker.newHost, fmt.Errorf("failed to connect to jump host: %w", err)
+	}
+
+	conn, err := jumpClient.Dial("tcp", address)
+	if err == nil {
+		client, err = scpsync.NewClientContext(ctx, conn, address, config)
+	}
+
+	hostentry = jumpAsker.newHost
+	if len(asker.newHost) != 0 {
+		if len(hostentry) != 0 {
+			hostentry += "\n"
+		}
+		hostentry += asker.newHost
+	}
+
+	if err != nil {
+		_ = jumpClient.Close()
+		return nil, "", hostentry, fmt.Errorf("failed to connect through jump host: %w", err)
 	}
 
-	return asker.newHost, nil
+	// Closing the client doesn't close the connection it's tunneled through
+	go func() {
+		_ = client.Wait()
+		_ = jumpClient.Close()
+	}()
+
+	return client, path, hostentry, nil
 }
 
-func sshConfig(entry txlogs.Entry) (address, path string, config *ssh.ClientConfig, err error) {
-	uri, err := url.Parse(entry[blobformat.KeyURL])
+// sshJumpConfig parses a jump host ([user@]host[:port]) and builds the
+// config to log into it with. Like the sync host, details missing from it
+// are filled in from the user's ssh config and the user otherwise defaults
+// to the one in config. Only the algorithms are taken from config, the jump
+// host is offered its own IdentityFile keys and the ssh-agent's and never
+// the sync host's password or key.
+func sshJumpConfig(jump string, config *ssh.ClientConfig, userConfig sshUserConfig) (address, hostname string, jumpConfig *ssh.ClientConfig, err error) {
+	uri, err := url.Parse("ssh://" + jump)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to parse %s: %w", blobformat.KeyJump, err)
+	}
+
+	host, port := uri.Hostname(), uri.Port()
+	if len(host) == 0 {
+		return "", "", nil, fmt.Errorf("%s missing host", blobformat.KeyJump)
+	}
+
+	opts := userConfig.lookup(host)
+	if len(port) == 0 {
+		port = opts.Port
+	}
+	if len(port) == 0 {
+		port = "22"
+	}
+	dialHost := host
+	if len(opts.HostName) != 0 {
+		dialHost = opts.HostName
+	}
+
+	jumpConfig = &ssh.ClientConfig{
+		Config:            config.Config,
+		User:              config.User,
+		HostKeyAlgorithms: config.HostKeyAlgorithms,
+		Timeout:           config.Timeout,
+	}
+	if user := uri.User.Username(); len(user) != 0 {
+		jumpConfig.User = user
+	} else if len(opts.User) != 0 {
+		jumpConfig.User = opts.User
+	}
+
+	stored, err := identitySigners(opts.IdentityFiles)
 	if err != nil {
 		return "", "", nil, err
 	}
+	if len(stored) != 0 || len(os.Getenv("SSH_AUTH_SOCK")) != 0 {
+		jumpConfig.Auth = append(jumpConfig.Auth, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			return append(stored, agentSigners()...), nil
+		}))
+	}
+
+	return net.JoinHostPort(dialHost, port), net.JoinHostPort(host, port), jumpConfig, nil
+}
+
+// sshConfig builds the ssh configuration for a sync entry. The address
+// is what will be dialed where hostname is the address as it's written in
+// the entry's url, these differ when the address has been resolved
+// via another mechanism (tailscale, HostName in ~/.ssh/config). jump is the
+// host to tunnel through if there is one.
+//
+// Details missing from the url are filled in from the user's ssh config.
+func sshConfig(entry txlogs.Entry) (address, hostname, path, jump string, config *ssh.ClientConfig, err error) {
+	uri, err := url.Parse(entry[blobformat.KeyURL])
+	if err != nil {
+		return "", "", "", "", nil, err
+	}
 
 	host := uri.Hostname()
 	port := uri.Port()
@@ -379,41 +1416,176 @@ func sshConfig(entry txlogs.Entry) (address, path string, config *ssh.ClientConf
 	secretKey := entry[blobformat.KeyPriv]
 	path = uri.Path[1:]
 
-	if len(user) == 0 {
-		return "", "", nil, errors.New("url missing user")
-	}
 	if len(host) == 0 {
-		return "", "", nil, errors.New("url missing host")
+		return "", "", "", "", nil, errors.New("url missing host")
 	}
 	if len(path) == 0 {
-		return "", "", nil, errors.New("url missing file path")
+		return "", "", "", "", nil, errors.New("url missing file path")
+	}
+
+	opts := loadSSHConfig().lookup(host)
+	if len(user) == 0 {
+		user = opts.User
+	}
+	if len(user) == 0 {
+		return "", "", "", "", nil, errors.New("url missing user")
+	}
+	if len(port) == 0 {
+		port = opts.Port
+	}
+	if len(port) == 0 {
+		port = "22"
+	}
+	dialHost := host
+	if len(opts.HostName) != 0 {
+		dialHost = opts.HostName
+	}
+
+	jump = entry[blobformat.KeyJump]
+	if len(jump) == 0 && opts.ProxyJump != "none" {
+		jump = opts.ProxyJump
+	}
+	if strings.Contains(jump, ",") {
+		return "", "", "", "", nil, errors.New("only one jump host is supported")
 	}
 
-	address = net.JoinHostPort(host, port)
+	hostname = net.JoinHostPort(host, port)
+	address = net.JoinHostPort(dialHost, port)
+	if entry[blobformat.KeyTailscale] == "true" {
+		address = tailscaleAddress(dialHost, port)
+	}
 	config = new(ssh.ClientConfig)
 	config.User = user
 	if len(pass) != 0 {
 		config.Auth = append(config.Auth, ssh.Password(pass))
 	}
 
+	// The ssh client only tries each auth method once so the stored key and
+	// the keys in the ssh-agent must all be offered together
+	var stored []ssh.Signer
 	if len(secretKey) != 0 {
 		signer, err := ssh.ParsePrivateKey([]byte(secretKey))
 		if err != nil {
-			return "", "", nil, err
+			return "", "", "", "", nil, err
+		}
+
+		if certStr := entry[blobformat.KeySSHCert]; len(certStr) != 0 {
+			certSigner, err := sshCertSigner(certStr, signer)
+			if err != nil {
+				return "", "", "", "", nil, err
+			}
+			// Servers that don't accept the certificate may still accept
+			// the plain key
+			stored = append(stored, certSigner)
+		}
+		stored = append(stored, signer)
+	}
+	identities, err := identitySigners(opts.IdentityFiles)
+	if err != nil {
+		return "", "", "", "", nil, err
+	}
+	stored = append(stored, identities...)
+
+	// A security key's private key never leaves it, the entry only has the
+	// public key and the ssh-agent does the signing
+	var skPub ssh.PublicKey
+	if len(secretKey) == 0 {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(entry[blobformat.KeyPub]))
+		if err == nil && isSKKey(pub.Type()) {
+			skPub = pub
+		}
+	}
+
+	if len(stored) != 0 || len(os.Getenv("SSH_AUTH_SOCK")) != 0 {
+		config.Auth = append(config.Auth, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			agentKeys := agentSigners()
+			if skPub != nil {
+				var ok bool
+				if agentKeys, ok = preferAgentKey(agentKeys, skPub); !ok {
+					errColor.Println("(ssh) the security key for this entry is not in the ssh-agent, load it with: ssh-add -K")
+				}
+			}
+			return append(stored, agentKeys...), nil
+		}))
+	} else if skPub != nil {
+		return "", "", "", "", nil, errors.New("entry uses a security key but there's no ssh-agent (SSH_AUTH_SOCK) to sign with it")
+	}
+
+	return address, hostname, path, jump, config, nil
+}
+
+// identitySigners reads the keys in the IdentityFiles of the user's ssh
+// config, missing files are skipped
+func identitySigners(files []string) ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	for _, file := range files {
+		pem, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		// Keys we can't parse are likely encrypted, those are only usable
+		// through the ssh-agent
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			continue
 		}
-		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+		signers = append(signers, signer)
 	}
 
-	return address, path, config, nil
+	return signers, nil
+}
+
+// sshCertSigner creates a signer that authenticates with an openssh
+// certificate for the signer's key
+func sshCertSigner(certStr string, signer ssh.Signer) (ssh.Signer, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certStr))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", blobformat.KeySSHCert, err)
+	}
+
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is a public key, not a certificate", blobformat.KeySSHCert)
+	}
+
+	if now := uint64(time.Now().Unix()); cert.ValidBefore != ssh.CertTimeInfinity && now >= cert.ValidBefore {
+		return nil, fmt.Errorf("%s expired at %s", blobformat.KeySSHCert,
+			time.Unix(int64(cert.ValidBefore), 0).Format(time.RFC3339))
+	}
+
+	return ssh.NewCertSigner(cert, signer)
 }
 
 type hostAsker struct {
-	u       *uiContext
-	known   string
+	u     *uiContext
+	known string
+	// hostname if set overrides the hostname given to the callback, this
+	// keeps known hosts consistent when the address dialed is not the one
+	// written in the url.
+	hostname string
+	// sshfp checks unknown keys against the host's SSHFP records
+	sshfp   bool
 	newHost string
 }
 
 func (h *hostAsker) callback(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	// The address dialed is the best name to look up in dns, unless it's
+	// an ip already
+	dnsName, _, _ := net.SplitHostPort(hostname)
+	if len(h.hostname) != 0 {
+		hostname = h.hostname
+		if net.ParseIP(dnsName) != nil {
+			dnsName, _, _ = net.SplitHostPort(hostname)
+		}
+	}
+
+	// Syncs run concurrently, only ask about one host at a time
+	h.u.askHost.Lock()
+	defer h.u.askHost.Unlock()
+
 	// Format is `hostname address key-type key:base64`
 	keyHashBytes := sha256.Sum256(key.Marshal())
 	keyHash := fmt.Sprintf("%x", keyHashBytes)
@@ -424,37 +1596,35 @@ func (h *hostAsker) callback(hostname string, remote net.Addr, key ssh.PublicKey
 
 	knownLines := strings.Split(h.known, "\n")
 
-	for _, h := range knownLines {
-		vals := strings.Split(h, " ")
+	for _, known := range knownLines {
+		vals := strings.Split(known, " ")
 
-		if vals[0] != hostname {
+		if len(vals) < 4 || vals[0] != hostname {
 			continue
 		}
 
 		// Same host, double check key is same
-		if vals[2] != keyType {
-			return errors.New("known host's key type has changed, could be a mitm attack")
-		}
-		if vals[3] != keyHash {
-			return errors.New("known host's key has changed, could be a mitm attack")
+		if vals[2] != keyType || vals[3] != keyHash {
+			return h.rotated(hostname, addr, vals[2], vals[3], keyType, keyHash, hostLine)
 		}
 
 		// We've seen this host before and everything is OK
 		return nil
 	}
 
-	var b strings.Builder
-	for i := 0; i < len(keyHash)-1; i += 2 {
-		if i != 0 {
-			b.WriteByte(':')
+	if h.sshfp {
+		trusted, err := h.checkSSHFP(dnsName, key)
+		if err != nil {
+			return err
+		}
+		if trusted {
+			h.newHost = hostLine
+			return nil
 		}
-		b.WriteByte(keyHash[i])
-		b.WriteByte(keyHash[i+1])
 	}
-	sha256FingerPrint := b.String()
 
-	infoColor.Printf("(ssh) connected to: %s (%s)\nverify pubkey: %s %s\n",
-		hostname, addr, keyType, sha256FingerPrint)
+	infoColor.Printf("(ssh) connected to: %s (%s)\nverify pubkey: %s %s\n               %s\n",
+		hostname, addr, keyType, fingerprintHex(keyHash), fingerprintSHA256(keyHash))
 	line, err := h.u.prompt(promptColor.Sprint("Save this host (y/N): "))
 	if err != nil {
 		return fmt.Errorf("failed to get user confirmation on host: %w", err)
@@ -468,3 +1638,98 @@ func (h *hostAsker) callback(hostname string, remote net.Addr, key ssh.PublicKey
 		return errors.New("user rejected host")
 	}
 }
+
+// checkSSHFP looks for the key in the SSHFP records of the host. Keys that
+// match records the resolver validated with DNSSEC are trusted without
+// asking, keys that contradict them are rejected. Anything less is only
+// reported and the user is asked as usual.
+func (h *hostAsker) checkSSHFP(dnsName string, key ssh.PublicKey) (trusted bool, err error) {
+	if net.ParseIP(dnsName) != nil {
+		return false, nil
+	}
+
+	records, authenticated, err := lookupSSHFP(dnsName)
+	if err != nil {
+		errColor.Printf("(ssh) failed to look up SSHFP records for %s: %v\n", dnsName, err)
+		return false, nil
+	}
+
+	matched, mismatched := matchSSHFP(records, key)
+	switch {
+	case matched && authenticated:
+		infoColor.Printf("(ssh) %s key verified by DNSSEC SSHFP records\n", dnsName)
+		return true, nil
+	case matched:
+		infoColor.Printf("(ssh) %s key matches its SSHFP records but they're not DNSSEC validated\n", dnsName)
+	case mismatched && authenticated:
+		return false, errors.New("host key doesn't match the host's DNSSEC SSHFP records, could be a mitm attack")
+	case mismatched:
+		errColor.Printf("(ssh) %s key DOESN'T match its SSHFP records (not DNSSEC validated)\n", dnsName)
+	default:
+		infoColor.Printf("(ssh) %s has no SSHFP records for its key\n", dnsName)
+	}
+
+	return false, nil
+}
+
+// rotated is called when a known host presents a different key. It's only
+// accepted if the user types in the new key's fingerprint, which they
+// should get from the host's owner (or the host's console) rather than from
+// this connection.
+func (h *hostAsker) rotated(hostname, addr, oldType, oldHash, keyType, keyHash, hostLine string) error {
+	errColor.Printf("(ssh) the key of known host %s (%s) has changed, this could be a mitm attack\n", hostname, addr)
+	fmt.Printf("old key: %s %s\n         %s\n", oldType, fingerprintHex(oldHash), fingerprintSHA256(oldHash))
+	fmt.Printf("new key: %s %s\n         %s\n", keyType, fingerprintHex(keyHash), fingerprintSHA256(keyHash))
+	infoColor.Println("If the host's key was rotated, verify the new key with its owner (ssh-keygen -lf on the host)")
+
+	line, err := h.u.prompt(promptColor.Sprint("Type the new key's fingerprint to accept it (empty to reject): "))
+	if err != nil {
+		return fmt.Errorf("failed to get user confirmation on host: %w", err)
+	}
+
+	if len(strings.TrimSpace(line)) == 0 {
+		return errors.New("known host's key has changed, could be a mitm attack")
+	}
+	if !matchFingerprint(line, keyHash) {
+		return errors.New("fingerprint did not match the host's new key, could be a mitm attack")
+	}
+
+	h.newHost = hostLine
+	return nil
+}
+
+// fingerprintHex formats a hex sha256 key hash as bytes separated by colons
+func fingerprintHex(keyHash string) string {
+	var b strings.Builder
+	for i := 0; i < len(keyHash)-1; i += 2 {
+		if i != 0 {
+			b.WriteByte(':')
+		}
+		b.WriteByte(keyHash[i])
+		b.WriteByte(keyHash[i+1])
+	}
+
+	return b.String()
+}
+
+// fingerprintSHA256 formats a hex sha256 key hash the way openssh shows it
+func fingerprintSHA256(keyHash string) string {
+	b, err := hex.DecodeString(keyHash)
+	if err != nil {
+		return keyHash
+	}
+
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(b)
+}
+
+// matchFingerprint checks a fingerprint the user typed in against a hex
+// sha256 key hash, it may be in either of the formats above or plain hex
+func matchFingerprint(typed, keyHash string) bool {
+	typed = strings.TrimSpace(typed)
+	if strings.EqualFold(strings.ReplaceAll(typed, ":", ""), keyHash) {
+		return true
+	}
+
+	typed = strings.TrimRight(strings.TrimPrefix(typed, "SHA256:"), "=")
+	return typed == strings.TrimPrefix(fingerprintSHA256(keyHash), "SHA256:")
+}
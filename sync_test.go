@@ -0,0 +1,355 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHCertSigner(t *testing.T) {
+	t.Parallel()
+
+	newSigner := func() ssh.Signer {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer
+	}
+
+	ca, user := newSigner(), newSigner()
+	certFor := func(validBefore uint64) string {
+		cert := &ssh.Certificate{
+			Key:             user.PublicKey(),
+			CertType:        ssh.UserCert,
+			ValidPrincipals: []string{"me"},
+			ValidBefore:     validBefore,
+		}
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			t.Fatal(err)
+		}
+		return string(ssh.MarshalAuthorizedKey(cert))
+	}
+
+	signer, err := sshCertSigner(certFor(ssh.CertTimeInfinity), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := signer.PublicKey().(*ssh.Certificate); !ok {
+		t.Error("signer should present the certificate")
+	}
+
+	expired := uint64(time.Now().Add(-time.Hour).Unix())
+	if _, err = sshCertSigner(certFor(expired), user); err == nil {
+		t.Error("expired certificate should be rejected")
+	}
+
+	if _, err = sshCertSigner(string(ssh.MarshalAuthorizedKey(user.PublicKey())), user); err == nil {
+		t.Error("plain public key should be rejected")
+	}
+
+	// The certificate must be for the signer's key
+	if _, err = sshCertSigner(certFor(ssh.CertTimeInfinity), newSigner()); err == nil {
+		t.Error("certificate for a different key should be rejected")
+	}
+}
+
+func TestSSHJumpConfig(t *testing.T) {
+	t.Parallel()
+
+	config := &ssh.ClientConfig{User: "me", Auth: []ssh.AuthMethod{ssh.Password("hunter2")}}
+	passwordType := fmt.Sprintf("%T", ssh.Password(""))
+
+	tests := []struct {
+		Jump    string
+		Address string
+		User    string
+		Err     bool
+	}{
+		{Jump: "bastion.com", Address: "bastion.com:22", User: "me"},
+		{Jump: "admin@bastion.com:2222", Address: "bastion.com:2222", User: "admin"},
+		{Jump: "admin@[::1]:2222", Address: "[::1]:2222", User: "admin"},
+		{Jump: "admin@", Err: true},
+	}
+
+	for i, test := range tests {
+		address, _, jumpConfig, err := sshJumpConfig(test.Jump, config, nil)
+		if test.Err {
+			if err == nil {
+				t.Errorf("%d) want an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d) %v", i, err)
+			continue
+		}
+
+		if address != test.Address {
+			t.Errorf("%d) address want: %s, got: %s", i, test.Address, address)
+		}
+		if jumpConfig.User != test.User {
+			t.Errorf("%d) user want: %s, got: %s", i, test.User, jumpConfig.User)
+		}
+		for _, auth := range jumpConfig.Auth {
+			if fmt.Sprintf("%T", auth) == passwordType {
+				t.Errorf("%d) the sync host's password was given to the jump host", i)
+			}
+		}
+	}
+
+	if config.User != "me" {
+		t.Error("original config was modified")
+	}
+}
+
+func TestSSHJumpConfigIdentity(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "bpass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "bastion_ecdsa")
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userConfig, err := parseSSHConfig(strings.NewReader(fmt.Sprintf("Host bastion\n\tUser jumper\n\tIdentityFile %q\n", keyFile)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ClientConfig{User: "me", Auth: []ssh.AuthMethod{ssh.Password("hunter2")}}
+	_, _, jumpConfig, err := sshJumpConfig("bastion", config, userConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if jumpConfig.User != "jumper" {
+		t.Error("user should come from the jump host's ssh config:", jumpConfig.User)
+	}
+	if len(jumpConfig.Auth) != 1 || fmt.Sprintf("%T", jumpConfig.Auth[0]) != fmt.Sprintf("%T", ssh.PublicKeys()) {
+		t.Errorf("the jump host should only be offered keys: %#v", jumpConfig.Auth)
+	}
+
+	signers, err := identitySigners([]string{keyFile, filepath.Join(dir, "missing")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 1 || signers[0].PublicKey().Type() != ssh.KeyAlgoECDSA256 {
+		t.Errorf("identity files wrong: %#v", signers)
+	}
+}
+
+func TestMergeKnownHosts(t *testing.T) {
+	t.Parallel()
+
+	oldHash := strings.Repeat("ab", 32)
+	newHash := strings.Repeat("cd", 32)
+	known := "a.com:22 1.1.1.1:22 ssh-rsa " + oldHash + "\nb.com:22 2.2.2.2:22 ssh-rsa " + oldHash
+
+	merged, rotations := mergeKnownHosts(known, "c.com:22 3.3.3.3:22 ssh-ed25519 "+newHash)
+	if merged != known+"\nc.com:22 3.3.3.3:22 ssh-ed25519 "+newHash {
+		t.Error("new host was not appended:", merged)
+	}
+	if len(rotations) != 0 {
+		t.Error("want no rotations, got:", rotations)
+	}
+
+	merged, rotations = mergeKnownHosts(known, "a.com:22 1.1.1.1:22 ssh-ed25519 "+newHash)
+	if merged != "b.com:22 2.2.2.2:22 ssh-rsa "+oldHash+"\na.com:22 1.1.1.1:22 ssh-ed25519 "+newHash {
+		t.Error("rotated host was not replaced:", merged)
+	}
+	want := "a.com:22 ssh-rsa " + fingerprintSHA256(oldHash) + " -> ssh-ed25519 " + fingerprintSHA256(newHash)
+	if len(rotations) != 1 || rotations[0] != want {
+		t.Errorf("rotation was wrong, want: %q, got: %q", want, rotations)
+	}
+
+	if merged, _ = mergeKnownHosts("", "a.com:22 1.1.1.1:22 ssh-rsa "+oldHash); merged != "a.com:22 1.1.1.1:22 ssh-rsa "+oldHash {
+		t.Error("want only the new host, got:", merged)
+	}
+}
+
+func TestMatchFingerprint(t *testing.T) {
+	t.Parallel()
+
+	hash := "0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c4b5a69788796a5b4c3d2e1f0"
+
+	good := []string{
+		hash,
+		strings.ToUpper(hash),
+		fingerprintHex(hash),
+		fingerprintSHA256(hash),
+		" " + fingerprintSHA256(hash) + "= ",
+		strings.TrimPrefix(fingerprintSHA256(hash), "SHA256:"),
+	}
+	for _, g := range good {
+		if !matchFingerprint(g, hash) {
+			t.Errorf("%q should match", g)
+		}
+	}
+
+	bad := []string{"", "SHA256:", hash[:32], fingerprintSHA256(strings.Repeat("00", 32))}
+	for _, b := range bad {
+		if matchFingerprint(b, hash) {
+			t.Errorf("%q should not match", b)
+		}
+	}
+}
+
+func TestSSHAlgorithms(t *testing.T) {
+	t.Parallel()
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}}
+	if err := u.store.SetSetting(blobformat.SettingCiphers, "aes256-ctr, aes128-ctr"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.store.SetSetting(blobformat.SettingMACs, "hmac-sha1"); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := txlogs.Entry{
+		blobformat.KeyKexAlgorithms: "diffie-hellman-group14-sha1",
+		blobformat.KeyMACs:          "hmac-sha2-256,",
+	}
+
+	config := new(ssh.ClientConfig)
+	u.syncSettings().sshAlgorithms(entry, config)
+
+	if config.HostKeyAlgorithms != nil {
+		t.Error("host key algorithms should be the default:", config.HostKeyAlgorithms)
+	}
+	if !reflect.DeepEqual(config.KeyExchanges, []string{"diffie-hellman-group14-sha1"}) {
+		t.Error("kex algorithms were wrong:", config.KeyExchanges)
+	}
+	if !reflect.DeepEqual(config.Ciphers, []string{"aes256-ctr", "aes128-ctr"}) {
+		t.Error("ciphers were wrong:", config.Ciphers)
+	}
+	if !reflect.DeepEqual(config.MACs, []string{"hmac-sha2-256"}) {
+		t.Error("the entry's macs should win:", config.MACs)
+	}
+}
+
+func TestSaveSyncBase(t *testing.T) {
+	t.Parallel()
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}}
+	uuid, err := u.store.New("sync/remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.store.UpdateSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	u.saveSyncBase(uuid)
+	if err = u.store.UpdateSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	log, _ := u.store.SyncLog()
+	want := txlogs.NewBase(log).String()
+	if got := u.store.Snapshot[uuid][blobformat.KeySyncBase]; got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+
+	// The base is a local key so it doesn't move itself
+	n := len(u.store.Log)
+	u.saveSyncBase(uuid)
+	if len(u.store.Log) != n {
+		t.Error("an unchanged base should not be written again")
+	}
+
+	pull, err := u.store.New("sync/pull")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.store.DB.Set(pull, blobformat.KeyDirection, blobformat.DirectionPull)
+	if err = u.store.UpdateSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	u.saveSyncBase(pull)
+	if err = u.store.UpdateSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := u.store.Snapshot[pull][blobformat.KeySyncBase]; ok {
+		t.Error("entries that are never merged with should not have a base")
+	}
+}
+
+func TestPullAll(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "bpass-pull-all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}}
+	var syncs []string
+	for i := 0; i < 2*maxSyncWorkers; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%d.blob", i))
+		if err = ioutil.WriteFile(path, []byte(path), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		uuid, err := u.store.NewSync(syncFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = u.store.Set(uuid, blobformat.KeyURL, "file://"+filepath.ToSlash(path)); err != nil {
+			t.Fatal(err)
+		}
+		syncs = append(syncs, uuid)
+	}
+	if err = u.store.UpdateSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Like the first sync on a machine the store has changed since it was
+	// last read, the pulls must not be the ones to read it (go test -race)
+	if err = u.store.SetSetting(blobformat.SettingSyncRetries, "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.store.AddDevice("laptop", "key"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, r := range pullAll(u, syncs) {
+		want := filepath.Join(dir, fmt.Sprintf("%d.blob", i))
+		if r.err != nil || string(r.ct) != want {
+			t.Errorf("%d) want: %q, got: %q (%v)", i, want, r.ct, r.err)
+		}
+	}
+}
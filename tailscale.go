@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	tailscaleDialTimeout = 3 * time.Second
+)
+
+// tailscaleAddress attempts to resolve host to an address on the tailnet
+// using the tailscale cli (which knows about magic dns names even when the
+// system resolver does not). If the tailnet address can be reached it's
+// returned, otherwise we fall back to the address that would be dialed
+// directly so that sync entries keep working on networks where the tailnet
+// is not up.
+func tailscaleAddress(host, port string) string {
+	direct := net.JoinHostPort(host, port)
+
+	ip, err := tailscaleIP(host)
+	if err != nil {
+		return direct
+	}
+
+	address := net.JoinHostPort(ip, port)
+	conn, err := net.DialTimeout("tcp", address, tailscaleDialTimeout)
+	if err != nil {
+		return direct
+	}
+	_ = conn.Close()
+
+	return address
+}
+
+// tailscaleIP asks the tailscale cli for the ipv4 address of a machine
+// on the tailnet
+func tailscaleIP(host string) (string, error) {
+	command, err := exec.LookPath("tailscale")
+	if err != nil {
+		return "", err
+	}
+
+	out, err := exec.Command(command, "ip", "-4", host).Output()
+	if err != nil {
+		return "", err
+	}
+
+	ip := strings.TrimSpace(string(out))
+	if i := strings.IndexByte(ip, '\n'); i >= 0 {
+		ip = ip[:i]
+	}
+
+	if net.ParseIP(ip) == nil {
+		return "", &net.AddrError{Err: "tailscale returned an invalid ip", Addr: ip}
+	}
+
+	return ip, nil
+}
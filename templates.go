@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aarondl/bpass/blobformat"
+)
+
+// genPolicy is how the password generator makes a template's secret, see
+// genPassword for what the numbers mean
+type genPolicy struct {
+	length, upper, lower, number, basic, extra int
+}
+
+// parseGenPolicy parses a generator policy like length=24,extra=off where
+// each character class is "off", "any" or how many there must be at least.
+// Anything left out is what the interactive generator starts with.
+func parseGenPolicy(s string) (genPolicy, error) {
+	p := genPolicy{length: 32}
+	if len(s) == 0 {
+		return p, nil
+	}
+
+	for _, setting := range strings.Split(s, ",") {
+		kv := strings.SplitN(setting, "=", 2)
+		if len(kv) != 2 {
+			return p, fmt.Errorf("malformed generator setting %q", setting)
+		}
+
+		var n int
+		switch kv[1] {
+		case "off":
+			n = -1
+		case "any":
+			n = 0
+		default:
+			var err error
+			if n, err = strconv.Atoi(kv[1]); err != nil || n < 0 {
+				return p, fmt.Errorf("generator setting %s must be off, any or a number", kv[0])
+			}
+		}
+
+		switch kv[0] {
+		case "length":
+			if n <= 0 {
+				return p, fmt.Errorf("generator length must be a number")
+			}
+			p.length = n
+		case "upper":
+			p.upper = n
+		case "lower":
+			p.lower = n
+		case "number":
+			p.number = n
+		case "basic":
+			p.basic = n
+		case "extra":
+			p.extra = n
+		default:
+			return p, fmt.Errorf("unknown generator setting %q", kv[0])
+		}
+	}
+
+	return p, nil
+}
+
+// generate makes a password with the policy
+func (p genPolicy) generate() (string, error) {
+	return genPassword(p.length, p.upper, p.lower, p.number, p.basic, p.extra)
+}
+
+// addFromTemplate adds a new entry with the keys of a template, asking for
+// each one's value
+func (u *uiContext) addFromTemplate(name, template string) error {
+	tplUUID, tpl, err := u.store.FindByName(blobformat.TemplateName(template))
+	if err != nil {
+		return err
+	}
+	if len(tplUUID) == 0 {
+		errColor.Printf("template %q does not exist\n", template)
+		return nil
+	}
+
+	fields, err := blobformat.Blob(tpl).TemplateFields()
+	if err != nil {
+		errColor.Println(err)
+		return nil
+	}
+
+	return u.store.Do(func() error {
+		uuid, err := u.store.New(name)
+		if err != nil {
+			if err == blobformat.ErrNameNotUnique {
+				errColor.Printf("%q already exists\n", name)
+				return nil
+			}
+			return err
+		}
+
+		schema := blobformat.SchemaOf("")
+		for _, f := range fields {
+			value, err := u.templateValue(f, schema)
+			if err != nil {
+				return err
+			}
+
+			// Raw sets like the add wizard to avoid updated spam
+			if len(value) != 0 {
+				u.store.DB.Set(uuid, f.Key, value)
+			}
+		}
+
+		return nil
+	})
+}
+
+// templateValue asks for the value of a template's field until it's valid
+// for both the template and the schema of the entry
+func (u *uiContext) templateValue(f blobformat.TemplateField, schema blobformat.Schema) (string, error) {
+	if f.Generate {
+		policy, err := parseGenPolicy(f.Policy)
+		if err != nil {
+			return "", err
+		}
+		value, err := policy.generate()
+		if err != nil {
+			return "", fmt.Errorf("failed to generate %s: %w", f.Key, err)
+		}
+		infoColor.Printf("generated %s\n", f.Key)
+		return value, nil
+	}
+
+	label := f.Key
+	if len(f.Default) != 0 && f.Type != blobformat.FieldSecret {
+		label += " [" + f.Default + "]"
+	}
+	label = promptColor.Sprint(label + ": ")
+
+	for {
+		var value string
+		var err error
+		switch {
+		case f.Key == blobformat.KeyPass:
+			value, err = u.getPassword()
+		case f.Type == blobformat.FieldSecret:
+			value, err = u.promptPassword(label)
+		case f.Type == blobformat.FieldMultiline:
+			fmt.Fprintln(u.out, label)
+			value, err = u.promptMultiline(promptColor.Sprint("> "))
+		default:
+			value, err = u.prompt(label)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if len(value) == 0 {
+			return f.Default, nil
+		}
+
+		if err = f.Type.Validate(value); err != nil {
+			errColor.Printf("%s must be a %s: %v\n", f.Key, f.Type, err)
+			continue
+		}
+		if err = schema.Validate(f.Key, value); err != nil {
+			errColor.Println(err)
+			continue
+		}
+
+		return value, nil
+	}
+}
+
+// chooseTemplate asks which template the add wizard should use when there
+// are any, an empty name is none
+func (u *uiContext) chooseTemplate() (string, error) {
+	templates, err := u.templateNames()
+	if err != nil || len(templates) == 0 {
+		return "", err
+	}
+
+	for {
+		choice, err := u.prompt(promptColor.Sprintf("template (%s or enter for none): ", strings.Join(templates, ", ")))
+		if err != nil {
+			return "", err
+		}
+		if len(choice) == 0 {
+			return "", nil
+		}
+
+		for _, t := range templates {
+			if t == choice {
+				return choice, nil
+			}
+		}
+		errColor.Printf("template %q does not exist\n", choice)
+	}
+}
+
+// templateNames returns the names of the templates sorted
+func (u *uiContext) templateNames() ([]string, error) {
+	entries, err := u.store.Templates()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, name := range entries {
+		names = append(names, strings.TrimPrefix(name, blobformat.TemplateName("")))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// templateList lists the templates and their keys
+func (u *uiContext) templateList() error {
+	entries, err := u.store.Templates()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		infoColor.Println("there are no templates")
+		return nil
+	}
+
+	for _, name := range entries.Names() {
+		uuid, blob, err := u.store.FindByName(name)
+		if err != nil || len(uuid) == 0 {
+			return err
+		}
+
+		fields, err := blobformat.Blob(blob).TemplateFields()
+		if err != nil {
+			fmt.Fprintf(u.out, "  %s %s\n", keyColor.Sprint(name), errColor.Sprint(err))
+			continue
+		}
+
+		keys := make([]string, len(fields))
+		for i, f := range fields {
+			keys[i] = fmt.Sprintf("%s (%s)", f.Key, f.Type)
+		}
+		fmt.Fprintf(u.out, "  %s %s\n", keyColor.Sprint(strings.TrimPrefix(name, blobformat.TemplateName(""))), strings.Join(keys, ", "))
+	}
+
+	return nil
+}
+
+// templateAdd asks for the keys of a new template
+func (u *uiContext) templateAdd(name string) error {
+	return u.store.Do(func() error {
+		uuid, err := u.store.New(blobformat.TemplateName(name))
+		if err != nil {
+			if err == blobformat.ErrNameNotUnique {
+				errColor.Printf("template %q already exists\n", name)
+				return nil
+			}
+			return err
+		}
+
+		types := make([]string, len(blobformat.FieldTypes))
+		for i, t := range blobformat.FieldTypes {
+			types[i] = string(t)
+		}
+
+		for {
+			key, err := u.prompt(promptColor.Sprint("key (enter to finish): "))
+			if err != nil {
+				return err
+			}
+			if len(key) == 0 {
+				break
+			}
+			switch key {
+			case blobformat.KeyName, blobformat.KeyUpdated, blobformat.KeyProtected, blobformat.KeySecrets, blobformat.KeyTrashed, blobformat.KeyPinned, blobformat.KeyLocked:
+				errColor.Println(key, "may not be set")
+				continue
+			}
+
+			typ, err := u.prompt(promptColor.Sprintf("type (%s) [%s]: ", strings.Join(types, ", "), blobformat.FieldString))
+			if err != nil {
+				return err
+			}
+			if len(typ) == 0 {
+				typ = string(blobformat.FieldString)
+			}
+
+			defPrompt := "default (enter for none): "
+			if typ == string(blobformat.FieldSecret) {
+				defPrompt = "default (gen or gen:length=24,extra=off to generate it, enter for none): "
+			}
+			def, err := u.prompt(promptColor.Sprint(defPrompt))
+			if err != nil {
+				return err
+			}
+
+			value := typ
+			if len(def) != 0 {
+				value += " " + def
+			}
+			f, err := blobformat.ParseTemplateField(key, value)
+			if err == nil && f.Generate {
+				_, err = parseGenPolicy(f.Policy)
+			}
+			if err != nil {
+				errColor.Println(err)
+				continue
+			}
+
+			u.store.DB.Set(uuid, key, value)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+// scriptedEditor answers prompts with lines in order, then ErrEnd
+type scriptedEditor struct {
+	lines []string
+}
+
+func (s *scriptedEditor) Line(string) (string, error) {
+	if len(s.lines) == 0 {
+		return "", ErrEnd
+	}
+	line := s.lines[0]
+	s.lines = s.lines[1:]
+	return line, nil
+}
+
+func (s *scriptedEditor) LineBlock(prompt string) (string, error)  { return s.Line(prompt) }
+func (s *scriptedEditor) LineHidden(prompt string) (string, error) { return s.Line(prompt) }
+func (s *scriptedEditor) AddHistory(string)                        {}
+func (s *scriptedEditor) SetEntryCompleter(func(string) []string)  {}
+func (s *scriptedEditor) Close() error                             { return nil }
+
+func TestParseGenPolicy(t *testing.T) {
+	t.Parallel()
+
+	p, err := parseGenPolicy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != (genPolicy{length: 32}) {
+		t.Error("the defaults should be the generator's:", p)
+	}
+
+	p, err = parseGenPolicy("length=24,upper=2,lower=any,extra=off")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != (genPolicy{length: 24, upper: 2, extra: -1}) {
+		t.Error("wrong policy:", p)
+	}
+
+	for _, bad := range []string{"length", "length=0", "upper=-2", "size=5", "extra=some"} {
+		if _, err = parseGenPolicy(bad); err == nil {
+			t.Error("it should have failed to parse:", bad)
+		}
+	}
+}
+
+func TestAddFromTemplate(t *testing.T) {
+	t.Parallel()
+
+	in := &scriptedEditor{}
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: new(bytes.Buffer), in: in}
+
+	in.lines = []string{
+		"host", "", "",
+		"port", "int", "5432",
+		"pass", "secret", "gen:length=24,extra=off",
+		"notes", "date", "gen",
+		"",
+	}
+	if err := u.templateAdd("database"); err != nil {
+		t.Fatal(err)
+	}
+
+	uuid, tpl, err := u.store.FindByName(blobformat.TemplateName("database"))
+	if err != nil || len(uuid) == 0 {
+		t.Fatal("the template should exist:", err)
+	}
+	if len(tpl) != 5 || tpl["host"] != "string" || tpl["port"] != "int 5432" || tpl["pass"] != "secret gen:length=24,extra=off" {
+		t.Error("wrong template, a date can't be generated:", tpl)
+	}
+
+	// Invalid port is asked for again, then left as the default
+	in.lines = []string{"db.example.com", "abc", ""}
+	if err = u.addNewInterruptible("work/db", "database"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, blob, err := u.store.FindByName("work/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob["host"] != "db.example.com" || blob["port"] != "5432" {
+		t.Error("wrong values:", blob)
+	}
+	if pass := blob[blobformat.KeyPass]; len(pass) != 24 {
+		t.Error("the password should be generated with the template's policy:", pass)
+	}
+
+	if err = u.addNewInterruptible("work/db2", "missing"); err != nil {
+		t.Fatal(err)
+	}
+	if uuid, _, _ = u.store.FindByName("work/db2"); len(uuid) != 0 {
+		t.Error("a missing template shouldn't add the entry")
+	}
+
+	// Running out of input aborts without leaving a half made entry
+	in.lines = []string{"db.example.com"}
+	if err = u.addNewInterruptible("work/db3", "database"); err != nil {
+		t.Fatal(err)
+	}
+	if uuid, _, _ = u.store.FindByName("work/db3"); len(uuid) != 0 {
+		t.Error("an aborted add should be rolled back")
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/crypt"
+)
+
+const (
+	// tpmCodePrefix starts every enrollment code
+	tpmCodePrefix = "bpass-tpm-"
+	// tpmSecretSize is how big the secret sealed to each device is
+	tpmSecretSize = 32
+)
+
+var errBadTPMCode = errors.New("that's not an enrollment code, they look like: bpass-tpm-...")
+
+// tpmResponse answers a challenge with an hmac keyed with the secret sealed
+// to this device's tpm. A device that isn't enrolled asks for an enrollment
+// code (see tpm code), it's sealed once the file is open.
+func (u *uiContext) tpmResponse(challenge []byte) ([]byte, error) {
+	secret, err := u.tpmUnseal()
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write(challenge)
+	return mac.Sum(nil), nil
+}
+
+// tpmUnseal gets the user's secret from this device's tpm or asks for an
+// enrollment code when it's not enrolled
+func (u *uiContext) tpmUnseal() ([]byte, error) {
+	if u.tpmEnroll != nil {
+		return u.tpmEnroll, nil
+	}
+
+	// Files that became multi-user keep using the single user's secret
+	paths := []string{tpmSealedPath(u.user)}
+	if len(u.user) != 0 {
+		paths = append(paths, tpmSealedPath(""))
+	}
+	for _, path := range paths {
+		secret, err := tpmUnsealFile(path)
+		if err == nil {
+			return secret, nil
+		} else if !os.IsNotExist(err) {
+			errColor.Println("failed to unseal the secret from the tpm:", err)
+			return nil, errFactorAbsent
+		}
+	}
+
+	if u.headless {
+		return nil, errFactorAbsent
+	}
+
+	infoColor.Printf("this device isn't enrolled to open %s, run tpm code on a device that is\n", u.shortFilename)
+	for {
+		code, err := u.promptPassword(promptColor.Sprint("enrollment code: "))
+		if err == errHeadless {
+			return nil, errFactorAbsent
+		} else if err != nil {
+			return nil, err
+		}
+		code = strings.TrimSpace(code)
+		if len(code) == 0 {
+			return nil, errFactorAbsent
+		}
+
+		secret, err := decodeTPMCode(code)
+		if err != nil {
+			errColor.Println(err)
+			continue
+		}
+
+		u.tpmEnroll = secret
+		return secret, nil
+	}
+}
+
+// tpmFinishEnroll seals the secret from an enrollment code now that it's
+// known to open the file
+func (u *uiContext) tpmFinishEnroll() {
+	if u.tpmEnroll == nil {
+		return
+	}
+
+	if err := tpmSealFile(tpmSealedPath(u.user), u.tpmEnroll); err != nil {
+		errColor.Println("failed to enroll this device:", err)
+		return
+	}
+
+	u.tpmEnroll = nil
+	infoColor.Println("enrolled this device")
+}
+
+// tpmOn makes the current user's key need a secret sealed to this device's
+// tpm, other devices have to be enrolled with tpm code
+func (u *uiContext) tpmOn() error {
+	if u.keyFactor() == crypt.FactorTPM {
+		errColor.Println("this key already needs the tpm, see tpm code to enroll other devices")
+		return nil
+	}
+	if current := u.keyFactor(); current != crypt.FactorNone {
+		infoColor.Printf("the tpm will replace the %s\n", factorName(current))
+	}
+
+	secret, err := u.tpmSecret()
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		if secret, err = u.newTPMSecret(); err != nil {
+			return err
+		}
+	} else if err = tpmSealFile(tpmSealedPath(u.user), secret); err != nil {
+		errColor.Println("failed to seal the secret to the tpm:", err)
+		return nil
+	}
+
+	infoColor.Println("enter the passphrase to use on enrolled devices")
+	return u.passwd("", int(crypt.FactorTPM))
+}
+
+// tpmOff stops the current user's key needing the tpm
+func (u *uiContext) tpmOff() error {
+	if u.keyFactor() != crypt.FactorTPM {
+		errColor.Println("this key doesn't need the tpm")
+		return nil
+	}
+
+	if err := u.passwd("", int(crypt.FactorNone)); err != nil {
+		return err
+	}
+	if u.keyFactor() == crypt.FactorTPM {
+		return nil
+	}
+
+	if err := u.store.SetSetting(tpmSetting(u.user), ""); err != nil {
+		return err
+	}
+	return removeTPMSealed(u.user)
+}
+
+// tpmCode prints the code that enrolls another device
+func (u *uiContext) tpmCode() error {
+	secret, err := u.tpmSecret()
+	if err != nil {
+		return err
+	}
+	if secret == nil || u.keyFactor() != crypt.FactorTPM {
+		errColor.Println("this key doesn't need the tpm, see tpm on")
+		return nil
+	}
+
+	code := encodeTPMCode(secret)
+	infoColor.Println("enter this code when opening the file on the device to enroll, anyone with it and the passphrase can open the file:")
+	fmt.Println(code)
+	printQR(code)
+	return nil
+}
+
+// tpmUnenroll forgets this device's sealed secret
+func (u *uiContext) tpmUnenroll() error {
+	if err := removeTPMSealed(u.user); err != nil {
+		return err
+	}
+
+	infoColor.Println("this device is no longer enrolled, opening the file here will need an enrollment code")
+	return nil
+}
+
+// tpmReset rekeys with a new secret so every other device is de-enrolled
+func (u *uiContext) tpmReset() error {
+	if u.keyFactor() != crypt.FactorTPM {
+		errColor.Println("this key doesn't need the tpm, see tpm on")
+		return nil
+	}
+
+	old, err := u.tpmSecret()
+	if err != nil {
+		return err
+	}
+	if _, err = u.newTPMSecret(); err != nil {
+		return err
+	}
+
+	if err = u.rekey(""); err != nil {
+		// Put the old secret back, the key still needs it
+		if restoreErr := u.store.SetSetting(tpmSetting(u.user), hex.EncodeToString(old)); restoreErr != nil {
+			return restoreErr
+		}
+		if sealErr := tpmSealFile(tpmSealedPath(u.user), old); sealErr != nil {
+			return sealErr
+		}
+		return err
+	}
+
+	infoColor.Println("every other device has to be enrolled again with tpm code")
+	return nil
+}
+
+// newTPMSecret creates, saves and seals a new secret for the current user
+func (u *uiContext) newTPMSecret() ([]byte, error) {
+	secret := make([]byte, tpmSecretSize)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return nil, err
+	}
+
+	if err := tpmSealFile(tpmSealedPath(u.user), secret); err != nil {
+		return nil, fmt.Errorf("failed to seal the secret to the tpm: %w", err)
+	}
+	if err := u.store.SetSetting(tpmSetting(u.user), hex.EncodeToString(secret)); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// tpmSecret is the current user's secret kept in the file for enrolling
+// devices, nil if there's none
+func (u *uiContext) tpmSecret() ([]byte, error) {
+	value, err := u.store.Setting(tpmSetting(u.user))
+	if err != nil {
+		return nil, err
+	}
+	if len(value) == 0 && len(u.user) != 0 {
+		if value, err = u.store.Setting(tpmSetting("")); err != nil {
+			return nil, err
+		}
+	}
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	return hex.DecodeString(value)
+}
+
+// tpmSetting is the setting the user's secret is kept in
+func tpmSetting(user string) string {
+	if len(user) == 0 {
+		return blobformat.SettingTPMSecret
+	}
+	return blobformat.SettingTPMSecret + "/" + user
+}
+
+// tpmSealedPath is where this device keeps the user's sealed secret, the
+// blobs in it can only be unsealed by this device's tpm
+func tpmSealedPath(user string) string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, "bpass", "tpm", fileUserID(user))
+}
+
+func removeTPMSealed(user string) error {
+	for _, user := range []string{user, ""} {
+		path := tpmSealedPath(user)
+		for _, ext := range []string{".pub", ".priv"} {
+			if err := os.Remove(path + ext); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func encodeTPMCode(secret []byte) string {
+	s := fmt.Sprintf("%s%x", tpmCodePrefix, secret)
+	sum := sha256.Sum256([]byte(s))
+	return fmt.Sprintf("%s-%x", s, sum[:2])
+}
+
+func decodeTPMCode(code string) ([]byte, error) {
+	if !strings.HasPrefix(code, tpmCodePrefix) {
+		return nil, errBadTPMCode
+	}
+
+	i := strings.LastIndexByte(code, '-')
+	sum := sha256.Sum256([]byte(code[:i]))
+	if code[i+1:] != hex.EncodeToString(sum[:2]) {
+		return nil, errBadTPMCode
+	}
+
+	secret, err := hex.DecodeString(code[len(tpmCodePrefix):i])
+	if err != nil || len(secret) != tpmSecretSize {
+		return nil, errBadTPMCode
+	}
+
+	return secret, nil
+}
+
+// tpmSealFile seals the secret to the tpm's storage primary key (created
+// again each time, it's the same for the same template) with tpm2-tools,
+// writing the sealed object to path.pub and path.priv
+func tpmSealFile(path string, secret []byte) error {
+	dir, err := ioutil.TempDir("", "bpass")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	primary := filepath.Join(dir, "primary.ctx")
+	if _, err = runTPM(nil, "tpm2_createprimary", "-Q", "-C", "o", "-g", "sha256", "-G", "ecc", "-c", primary); err != nil {
+		return err
+	}
+
+	_, err = runTPM(secret, "tpm2_create", "-Q", "-C", primary, "-i", "-", "-u", path+".pub", "-r", path+".priv")
+	return err
+}
+
+// tpmUnsealFile unseals a secret sealed by tpmSealFile, an os.IsNotExist
+// error is returned when there's nothing sealed at path
+func tpmUnsealFile(path string) ([]byte, error) {
+	if _, err := os.Stat(path + ".priv"); err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "bpass")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	primary := filepath.Join(dir, "primary.ctx")
+	sealed := filepath.Join(dir, "sealed.ctx")
+	if _, err = runTPM(nil, "tpm2_createprimary", "-Q", "-C", "o", "-g", "sha256", "-G", "ecc", "-c", primary); err != nil {
+		return nil, err
+	}
+	if _, err = runTPM(nil, "tpm2_load", "-Q", "-C", primary, "-u", path+".pub", "-r", path+".priv", "-c", sealed); err != nil {
+		return nil, err
+	}
+
+	secret, err := runTPM(nil, "tpm2_unseal", "-Q", "-c", sealed)
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) != tpmSecretSize {
+		return nil, errors.New("the tpm unsealed the wrong size of secret")
+	}
+
+	return secret, nil
+}
+
+// runTPM runs one of the tpm2-tools, the tpm it uses can be changed with
+// their $TPM2TOOLS_TCTI
+func runTPM(stdin []byte, name string, args ...string) ([]byte, error) {
+	command, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s (tpm2-tools) is needed to use the tpm", name)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(command, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) == 0 {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("%s failed: %s", name, msg)
+	}
+
+	return stdout.Bytes(), nil
+}
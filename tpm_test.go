@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTPMCode(t *testing.T) {
+	t.Parallel()
+
+	secret := bytes.Repeat([]byte{0xab}, tpmSecretSize)
+	code := encodeTPMCode(secret)
+	if !strings.HasPrefix(code, tpmCodePrefix) {
+		t.Error("code is missing the prefix:", code)
+	}
+
+	got, err := decodeTPMCode(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, secret) {
+		t.Errorf("secret was wrong: %x", got)
+	}
+
+	typo := []byte(code)
+	typo[len(tpmCodePrefix)] = 'c'
+	bad := []string{
+		"",
+		"bpass-share-2-abcd-1234",
+		string(typo),
+		encodeTPMCode(secret[:16]),
+	}
+	for _, b := range bad {
+		if _, err := decodeTPMCode(b); err != errBadTPMCode {
+			t.Errorf("%q) want a bad code error, got: %v", b, err)
+		}
+	}
+}
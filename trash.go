@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+)
+
+// defaultTrashDays is how long entries stay in the trash when the trashdays
+// setting isn't set
+const defaultTrashDays = 30
+
+// trashEntry moves an entry to the trash. Users and the entries bpass uses
+// for itself are deleted instead since they'd keep working in the trash.
+func (u *uiContext) trashEntry(name string) error {
+	if _, blob, err := u.store.FindByName(name); err != nil {
+		return err
+	} else if blob != nil && !checkUnlocked(blob) {
+		return nil
+	}
+
+	if blobformat.IsUserEntry(name) || blobformat.IsSyncEntry(name) || blobformat.IsSystemEntry(name) {
+		return u.deleteEntry(name)
+	}
+
+	uuid, _, err := u.store.FindByName(name)
+	if err != nil {
+		return err
+	}
+	if len(uuid) == 0 {
+		errColor.Printf("%q not found\n", name)
+		return nil
+	}
+
+	u.store.Trash(uuid)
+	infoColor.Printf("moved %q to the trash, it's deleted for good in %d days (see trash restore)\n", name, u.trashDays())
+	return nil
+}
+
+// trashList lists the entries in the trash and when they were trashed
+func (u *uiContext) trashList() error {
+	trashed, err := u.store.Trashed()
+	if err != nil {
+		return err
+	}
+	if len(trashed) == 0 {
+		fmt.Println("The trash is empty")
+		return nil
+	}
+
+	uuids := trashed.UUIDs()
+	sort.Slice(uuids, func(i, j int) bool { return trashed[uuids[i]] < trashed[uuids[j]] })
+	for _, uuid := range uuids {
+		when, err := blobformat.Blob(u.store.Snapshot[uuid]).Trashed()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(u.out, "%s %s\n", trashed[uuid], infoColor.Sprint(when.Format(time.RFC3339)))
+	}
+
+	return nil
+}
+
+// trashRestore takes an entry out of the trash, when several have the name
+// the one trashed last comes back
+func (u *uiContext) trashRestore(name string) error {
+	trashed, err := u.store.Trashed()
+	if err != nil {
+		return err
+	}
+
+	var uuid string
+	var last time.Time
+	for id, n := range trashed {
+		if n != name {
+			continue
+		}
+		when, err := blobformat.Blob(u.store.Snapshot[id]).Trashed()
+		if err != nil {
+			return err
+		}
+		if len(uuid) == 0 || when.After(last) {
+			uuid, last = id, when
+		}
+	}
+	if len(uuid) == 0 {
+		errColor.Printf("%q is not in the trash\n", name)
+		return nil
+	}
+
+	if err = u.store.Restore(uuid); err == blobformat.ErrNameNotUnique {
+		errColor.Printf("another entry is named %q, rename it before restoring\n", name)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	infoColor.Printf("restored %q\n", name)
+	return nil
+}
+
+// trashEmpty deletes everything in the trash for good
+func (u *uiContext) trashEmpty() error {
+	trashed, err := u.store.Trashed()
+	if err != nil {
+		return err
+	}
+	if len(trashed) == 0 {
+		fmt.Println("The trash is empty")
+		return nil
+	}
+
+	errColor.Printf("%d entries in the trash will be deleted for good\n", len(trashed))
+	yes, err := u.getYesNo("continue?")
+	if err != nil || !yes {
+		return err
+	}
+
+	purged, err := u.store.PurgeTrash(math.MaxInt64)
+	if err != nil {
+		return err
+	}
+
+	infoColor.Printf("deleted %d entries\n", purged)
+	return nil
+}
+
+// purgeTrash deletes the entries that have been in the trash longer than
+// the trashdays setting allows
+func (u *uiContext) purgeTrash() error {
+	before := time.Now().AddDate(0, 0, -u.trashDays()).UnixNano()
+	_, err := u.store.PurgeTrash(before)
+	return err
+}
+
+// trashDays reads the trashdays setting
+func (u *uiContext) trashDays() int {
+	val, err := u.store.Setting(blobformat.SettingTrashDays)
+	if err != nil || len(val) == 0 {
+		return defaultTrashDays
+	}
+
+	n, err := strconv.Atoi(val)
+	if err != nil || n <= 0 {
+		return defaultTrashDays
+	}
+	return n
+}
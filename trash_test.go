@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestTrash(t *testing.T) {
+	t.Parallel()
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}}
+	uuid, err := u.store.New("entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = u.trashEntry("entry"); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := u.store.Search(""); err != nil || len(found) != 0 {
+		t.Error("trashed entries should not be found:", found, err)
+	}
+	if trashed, err := u.store.Trashed(); err != nil || trashed[uuid] != "entry" {
+		t.Error("the entry should be in the trash:", trashed, err)
+	}
+
+	// The name is free again until it's restored
+	other, err := u.store.New("entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.store.Restore(uuid); err != blobformat.ErrNameNotUnique {
+		t.Error("restoring over a taken name should fail:", err)
+	}
+	u.store.Delete(other)
+
+	if err = u.trashRestore("entry"); err != nil {
+		t.Fatal(err)
+	}
+	if id, _, err := u.store.FindByName("entry"); err != nil || id != uuid {
+		t.Error("the entry should have been restored:", id, err)
+	}
+
+	// Only entries trashed longer ago than trashdays are purged
+	u.store.Trash(uuid)
+	if err = u.purgeTrash(); err != nil {
+		t.Fatal(err)
+	}
+	if blob, _ := u.store.Find(uuid); blob == nil {
+		t.Fatal("the entry should still be in the trash")
+	}
+
+	old := time.Now().AddDate(0, 0, -defaultTrashDays-1).UnixNano()
+	u.store.DB.Set(uuid, blobformat.KeyTrashed, strconv.FormatInt(old, 10))
+	if err = u.purgeTrash(); err != nil {
+		t.Fatal(err)
+	}
+	if blob, _ := u.store.Find(uuid); blob != nil {
+		t.Error("the entry should have been purged")
+	}
+}
@@ -0,0 +1,138 @@
+package txlogs
+
+import (
+	"fmt"
+)
+
+// Base is where two logs were the last time they were merged, their common
+// ancestor. It's the time of the last transaction they had then and how many
+// transactions there were up to it. See Merge3.
+type Base struct {
+	Time  int64
+	Count int
+}
+
+// NewBase returns the base of a log that was just merged with another (and
+// the other now has the same transactions)
+func NewBase(log []Tx) Base {
+	if len(log) == 0 {
+		return Base{}
+	}
+
+	return Base{Time: log[len(log)-1].Time, Count: len(log)}
+}
+
+// ParseBase parses a base from String, an empty string is no base
+func ParseBase(s string) (Base, error) {
+	var b Base
+	if len(s) == 0 {
+		return b, nil
+	}
+
+	if _, err := fmt.Sscan(s, &b.Time, &b.Count); err != nil {
+		return Base{}, fmt.Errorf("failed to parse merge base %q: %w", s, err)
+	}
+	return b, nil
+}
+
+// String formats the base to be stored, see ParseBase
+func (b Base) String() string {
+	return fmt.Sprintf("%d %d", b.Time, b.Count)
+}
+
+// Merge3 is Merge with the common ancestor of the logs. shared is the part of
+// a that's merged with b (a itself when nothing is left out of b) and base is
+// from the last time it was.
+//
+// Transactions from before the base that shared has and b doesn't were
+// removed from b since (a conflict resolved by discarding them, or
+// compacting) so they're removed from a too instead of coming back and
+// bringing their conflicts with them. Transactions only b has are new to a
+// as they are in Merge.
+//
+// The base is only trusted when shared still has Count transactions up to it
+// (it wasn't compacted or merged with older transactions from elsewhere since)
+// and b explains every removal: a set or delete of a key that b changed
+// later, a change to an entry b deleted or a delete of an entry b changed
+// later. Otherwise (b was replaced by an older copy for example) it's Merge.
+func Merge3(base Base, a, shared, b []Tx, resolved []Conflict) (c []Tx, conflicts []Conflict) {
+	removed := removedSince(base, shared, b)
+	if len(removed) == 0 {
+		return Merge(a, b, resolved)
+	}
+
+	kept := make([]Tx, 0, len(a))
+	for _, tx := range a {
+		if _, ok := removed[tx.Time]; !ok {
+			kept = append(kept, tx)
+		}
+	}
+
+	return Merge(kept, b, resolved)
+}
+
+// removedSince returns the times of the transactions b removed since the
+// base, nil if the base can't be trusted
+func removedSince(base Base, shared, b []Tx) map[int64]struct{} {
+	if base.Count == 0 {
+		return nil
+	}
+
+	n := 0
+	for _, tx := range shared {
+		if tx.Time <= base.Time {
+			n++
+		}
+	}
+	if n != base.Count {
+		return nil
+	}
+
+	inB := make(map[int64]struct{}, len(b))
+	lastKey := make(map[[2]string]int64)
+	lastEntry := make(map[string]int64)
+	deleted := make(map[string]struct{})
+	for _, tx := range b {
+		inB[tx.Time] = struct{}{}
+		if tx.Time > lastEntry[tx.UUID] {
+			lastEntry[tx.UUID] = tx.Time
+		}
+		switch tx.Kind {
+		case TxSetKey, TxDeleteKey:
+			if k := [2]string{tx.UUID, tx.Key}; tx.Time > lastKey[k] {
+				lastKey[k] = tx.Time
+			}
+		case TxDelete:
+			deleted[tx.UUID] = struct{}{}
+		}
+	}
+
+	var removed map[int64]struct{}
+	for _, tx := range shared {
+		if tx.Time > base.Time {
+			continue
+		}
+		if _, ok := inB[tx.Time]; ok {
+			continue
+		}
+
+		explained := false
+		switch tx.Kind {
+		case TxSetKey, TxDeleteKey:
+			_, gone := deleted[tx.UUID]
+			explained = gone || lastKey[[2]string{tx.UUID, tx.Key}] > tx.Time
+		case TxDelete:
+			explained = lastEntry[tx.UUID] > tx.Time
+		}
+		if !explained {
+			return nil
+		}
+
+		if removed == nil {
+			removed = make(map[int64]struct{})
+		}
+		removed[tx.Time] = struct{}{}
+	}
+
+	return removed
+}
@@ -0,0 +1,79 @@
+package txlogs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBaseString(t *testing.T) {
+	t.Parallel()
+
+	base := NewBase([]Tx{{Time: 1}, {Time: 5}})
+	if base != (Base{Time: 5, Count: 2}) {
+		t.Error("wrong base:", base)
+	}
+
+	parsed, err := ParseBase(base.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != base {
+		t.Errorf("want: %v, got: %v", base, parsed)
+	}
+
+	if parsed, err = ParseBase(""); err != nil || parsed != (Base{}) {
+		t.Error("empty should be no base:", parsed, err)
+	}
+	if _, err = ParseBase("garbage"); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestMerge3(t *testing.T) {
+	t.Parallel()
+
+	add := Tx{Time: 1, Kind: TxAdd, UUID: "e"}
+	set := Tx{Time: 2, Kind: TxSetKey, UUID: "e", Key: "a", Value: "1"}
+	del := Tx{Time: 3, Kind: TxDelete, UUID: "e"}
+	restore := Tx{Time: 4, Kind: TxSetKey, UUID: "e", Key: "a", Value: "2"}
+
+	// a pushed its delete, elsewhere a set was made after it and the
+	// conflict was resolved by restoring the entry
+	a := []Tx{add, set, del}
+	b := []Tx{add, set, restore}
+	base := NewBase(a)
+
+	if _, conflicts := Merge(a, b, nil); len(conflicts) != 1 {
+		t.Fatal("without the base the delete should conflict again:", conflicts)
+	}
+
+	c, conflicts := Merge3(base, a, a, b, nil)
+	if len(conflicts) != 0 {
+		t.Fatal("the resolved conflict should not come back:", conflicts)
+	}
+	if !reflect.DeepEqual(c, b) {
+		t.Errorf("want: %v, got: %v", b, c)
+	}
+
+	// Transactions left out of what's shared are never removed
+	local := Tx{Time: 3, Kind: TxSetKey, UUID: "e", Key: "local.x", Value: "1"}
+	a = []Tx{add, set, local}
+	c, _ = Merge3(NewBase([]Tx{add, set}), a, []Tx{add, set}, []Tx{add, set}, nil)
+	if !reflect.DeepEqual(c, a) {
+		t.Errorf("want: %v, got: %v", a, c)
+	}
+
+	// Changes missing from b that it doesn't explain mean it's not the b
+	// the base is from (an older copy for example), nothing is removed
+	a = []Tx{add, set}
+	c, conflicts = Merge3(NewBase(a), a, a, []Tx{add}, nil)
+	if len(conflicts) != 0 || !reflect.DeepEqual(c, a) {
+		t.Errorf("want: %v, got: %v %v", a, c, conflicts)
+	}
+
+	// So does shared no longer having the transactions the base counted
+	c, conflicts = Merge3(Base{Time: 3, Count: 5}, []Tx{add, set, del}, []Tx{add, set, del}, []Tx{add, set, restore}, nil)
+	if len(conflicts) != 1 {
+		t.Error("a base that doesn't match should be ignored:", c, conflicts)
+	}
+}
@@ -0,0 +1,367 @@
+package txlogs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// binaryMagic starts the binary encoding, json can't start with a NUL so
+// New can tell them apart. The byte after it is the encoding's version,
+// version 1 has no devices or clocks and version 2 no signatures.
+const (
+	binaryMagic   = "\x00txlogs"
+	binaryVersion = 3
+)
+
+// txKinds are the bytes kinds are written as in the binary encoding
+var txKinds = []TxKind{"", TxAdd, TxDelete, TxSetKey, TxDeleteKey}
+
+var errBinaryTruncated = errors.New("binary log is truncated")
+
+// IsBinary checks if data is in the binary encoding (see SaveBinary)
+func IsBinary(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(binaryMagic))
+}
+
+// SaveBinary marshals the same data as Save in a smaller binary encoding
+// that's faster to parse, New and NewLog read either one.
+//
+// After the header comes the snapshot's version, the snapshot and the log.
+// Strings are length prefixed, uuids and keys are written once and then
+// referred to by their index, and each transaction's time is the
+// difference from the one before it. Clocks are written as the number of
+// devices in them followed by each device and its count and signatures are
+// raw bytes. All numbers are varints.
+//
+// 7:magic|1:version|version|nentries|(uuid|nkeys|(key|value)...)...|nlog|(time|kind|uuid|key|value|device|nclock|(device|n)...|prev|sig)...
+func (s *DB) SaveBinary() ([]byte, error) {
+	if s.InTx() {
+		return nil, errors.New("refusing to save while transaction active")
+	}
+
+	// A snapshot that was never needed is only decoded to be written again
+	snapshot := s.Snapshot
+	if s.pending != nil {
+		var err error
+		if snapshot, err = decodeSnapshot(s.pending); err != nil {
+			return nil, err
+		}
+	}
+
+	e := binaryEncoder{refs: make(map[string]uint64)}
+	e.buf.WriteString(binaryMagic)
+	e.buf.WriteByte(binaryVersion)
+
+	e.uvarint(uint64(s.Version))
+
+	// Sorted so the same db always encodes the same way
+	uuids := make([]string, 0, len(snapshot))
+	for uuid := range snapshot {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+
+	e.uvarint(uint64(len(uuids)))
+	for _, uuid := range uuids {
+		entry := snapshot[uuid]
+		keys := make([]string, 0, len(entry))
+		for k := range entry {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		e.ref(uuid)
+		e.uvarint(uint64(len(keys)))
+		for _, k := range keys {
+			e.ref(k)
+			e.str(entry[k])
+		}
+	}
+
+	e.uvarint(uint64(len(s.Log)))
+	var last int64
+	for _, tx := range s.Log {
+		kind := -1
+		for i, k := range txKinds {
+			if k == tx.Kind {
+				kind = i
+			}
+		}
+		if kind < 0 {
+			return nil, fmt.Errorf("unknown transaction kind %q", tx.Kind)
+		}
+
+		e.varint(tx.Time - last)
+		last = tx.Time
+		e.buf.WriteByte(byte(kind))
+		e.ref(tx.UUID)
+		e.ref(tx.Key)
+		e.str(tx.Value)
+		e.ref(tx.Device)
+
+		clock, err := ParseClock(tx.Clock)
+		if err != nil {
+			return nil, err
+		}
+		devices := make([]string, 0, len(clock))
+		for d := range clock {
+			devices = append(devices, d)
+		}
+		sort.Strings(devices)
+		e.uvarint(uint64(len(devices)))
+		for _, d := range devices {
+			e.ref(d)
+			e.uvarint(clock[d])
+		}
+
+		sig, err := base64.StdEncoding.DecodeString(tx.Sig)
+		if err != nil {
+			return nil, fmt.Errorf("malformed signature: %w", err)
+		}
+		e.str(tx.Prev)
+		e.str(string(sig))
+	}
+
+	return e.buf.Bytes(), nil
+}
+
+// loadBinary is New for the binary encoding, the snapshot is skipped when
+// only the log is wanted. Otherwise it's kept as it's encoded until it's
+// needed (see UpdateSnapshot), entries can be materialized from the log
+// without it (see Entry).
+func loadBinary(data []byte, snapshot bool) (*DB, error) {
+	if !IsBinary(data) || len(data) < len(binaryMagic)+1 {
+		return nil, errors.New("not a binary log")
+	}
+	version := data[len(binaryMagic)]
+	if version < 1 || version > binaryVersion {
+		return nil, fmt.Errorf("unknown binary log version %d, try upgrading bpass", version)
+	}
+
+	d := binaryDecoder{b: data[len(binaryMagic)+1:]}
+	s := new(DB)
+
+	start := d.b
+	snapVersion := d.uvarint()
+	nEntries := d.count()
+	for i := 0; i < nEntries && d.err == nil; i++ {
+		// The uuids and keys are referred to by the log
+		d.ref()
+		nKeys := d.count()
+		for j := 0; j < nKeys && d.err == nil; j++ {
+			d.ref()
+			d.skip()
+		}
+	}
+	if snapshot {
+		s.Version = uint(snapVersion)
+		if nEntries != 0 {
+			s.pending = append([]byte(nil), start[:len(start)-len(d.b)]...)
+		}
+	}
+
+	nLog := d.count()
+	if nLog != 0 {
+		s.Log = make([]Tx, 0, nLog)
+	}
+	var last int64
+	for i := 0; i < nLog && d.err == nil; i++ {
+		var tx Tx
+		last += d.varint()
+		tx.Time = last
+		if kind := int(d.byte()); kind < len(txKinds) {
+			tx.Kind = txKinds[kind]
+		} else if d.err == nil {
+			d.err = fmt.Errorf("unknown transaction kind %d", kind)
+		}
+		tx.UUID = d.ref()
+		tx.Key = d.ref()
+		tx.Value = d.str()
+		if version >= 2 {
+			tx.Device = d.ref()
+			if n := d.count(); n != 0 {
+				clock := make(Clock, n)
+				for j := 0; j < n && d.err == nil; j++ {
+					dev := d.ref()
+					clock[dev] = d.uvarint()
+				}
+				tx.Clock = clock.String()
+			}
+		}
+		if version >= 3 {
+			tx.Prev = d.str()
+			if sig := d.str(); len(sig) != 0 {
+				tx.Sig = base64.StdEncoding.EncodeToString([]byte(sig))
+			}
+		}
+		s.Log = append(s.Log, tx)
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.b) != 0 {
+		return nil, errors.New("binary log has trailing data")
+	}
+
+	return s, nil
+}
+
+// decodeSnapshot decodes the snapshot loadBinary left pending
+func decodeSnapshot(b []byte) (map[string]Entry, error) {
+	d := binaryDecoder{b: b}
+	d.uvarint()
+	nEntries := d.count()
+	snap := make(map[string]Entry, nEntries)
+	for i := 0; i < nEntries && d.err == nil; i++ {
+		uuid := d.ref()
+		nKeys := d.count()
+		entry := make(Entry, nKeys)
+		for j := 0; j < nKeys && d.err == nil; j++ {
+			k := d.ref()
+			entry[k] = d.str()
+		}
+		snap[uuid] = entry
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	return snap, nil
+}
+
+type binaryEncoder struct {
+	buf  bytes.Buffer
+	refs map[string]uint64
+	tmp  [binary.MaxVarintLen64]byte
+}
+
+func (e *binaryEncoder) uvarint(n uint64) {
+	e.buf.Write(e.tmp[:binary.PutUvarint(e.tmp[:], n)])
+}
+
+func (e *binaryEncoder) varint(n int64) {
+	e.buf.Write(e.tmp[:binary.PutVarint(e.tmp[:], n)])
+}
+
+func (e *binaryEncoder) str(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf.WriteString(s)
+}
+
+// ref writes the index+1 of a string that was written before, or 0 and the
+// string the first time
+func (e *binaryEncoder) ref(s string) {
+	if i, ok := e.refs[s]; ok {
+		e.uvarint(i + 1)
+		return
+	}
+
+	e.refs[s] = uint64(len(e.refs))
+	e.uvarint(0)
+	e.str(s)
+}
+
+// binaryDecoder reads until the first error, after which everything it
+// returns is zero
+type binaryDecoder struct {
+	b    []byte
+	refs []string
+	err  error
+}
+
+func (d *binaryDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	n, size := binary.Uvarint(d.b)
+	if size <= 0 {
+		d.err = errBinaryTruncated
+		return 0
+	}
+	d.b = d.b[size:]
+	return n
+}
+
+func (d *binaryDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	n, size := binary.Varint(d.b)
+	if size <= 0 {
+		d.err = errBinaryTruncated
+		return 0
+	}
+	d.b = d.b[size:]
+	return n
+}
+
+// count reads a length that can't be more than the bytes left, so a corrupt
+// one can't make us allocate too much
+func (d *binaryDecoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.b)) {
+		if d.err == nil {
+			d.err = errBinaryTruncated
+		}
+		return 0
+	}
+	return int(n)
+}
+
+func (d *binaryDecoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.b) == 0 {
+		d.err = errBinaryTruncated
+		return 0
+	}
+
+	b := d.b[0]
+	d.b = d.b[1:]
+	return b
+}
+
+func (d *binaryDecoder) str() string {
+	n := d.count()
+	if d.err != nil {
+		return ""
+	}
+
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+// skip a string without copying it
+func (d *binaryDecoder) skip() {
+	n := d.count()
+	if d.err == nil {
+		d.b = d.b[n:]
+	}
+}
+
+func (d *binaryDecoder) ref() string {
+	i := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if i == 0 {
+		s := d.str()
+		d.refs = append(d.refs, s)
+		return s
+	}
+	if i > uint64(len(d.refs)) {
+		d.err = errors.New("binary log refers to an unknown string")
+		return ""
+	}
+
+	return d.refs[i-1]
+}
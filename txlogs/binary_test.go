@@ -0,0 +1,111 @@
+package txlogs
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"reflect"
+	"testing"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	t.Parallel()
+
+	_, key, err := ed25519.GenerateKey(nil)
+	must(t, err)
+
+	store := new(DB)
+	for i := 0; i < 10; i++ {
+		// Some from before devices, some from each of two devices and the
+		// second signs them
+		switch i {
+		case 3:
+			store.SetDevice("dev1", nil)
+		case 6:
+			store.SetDevice("dev2", key)
+		}
+
+		uuid, err := store.Add()
+		must(t, err)
+		store.Set(uuid, "name", "entry")
+		store.Set(uuid, "pass", "hunter2")
+		store.Set(uuid, "pass", "hunter3")
+		store.DeleteKey(uuid, "name")
+	}
+	must(t, store.UpdateSnapshot())
+
+	b, err := store.SaveBinary()
+	must(t, err)
+	if !IsBinary(b) {
+		t.Error("should be binary")
+	}
+
+	js, err := store.Save()
+	must(t, err)
+	if IsBinary(js) {
+		t.Error("json should not be binary")
+	}
+	if len(b) >= len(js) {
+		t.Errorf("binary should be smaller than json: %d >= %d", len(b), len(js))
+	}
+
+	got, err := New(b)
+	must(t, err)
+	if got.Snapshot != nil {
+		t.Error("the snapshot should be decoded when it's needed")
+	}
+	if entry, err := got.Entry(store.Log[0].UUID); err != nil || entry["pass"] != "hunter3" {
+		t.Error("entries should be found without the snapshot:", entry, err)
+	}
+	again, err := got.SaveBinary()
+	must(t, err)
+	if !bytes.Equal(again, b) {
+		t.Error("encoding should be the same without decoding the snapshot")
+	}
+	must(t, got.UpdateSnapshot())
+	got.lazy = nil
+
+	want, err := New(js)
+	must(t, err)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("binary and json were different:\n%#v\n%#v", got, want)
+	}
+
+	log, err := NewLog(b)
+	must(t, err)
+	if !reflect.DeepEqual(log, store.Log) {
+		t.Error("log was wrong")
+	}
+
+	again, err = got.SaveBinary()
+	must(t, err)
+	if !bytes.Equal(again, b) {
+		t.Error("encoding should be the same every time")
+	}
+
+	for i := len(binaryMagic); i < len(b); i++ {
+		if _, err = New(b[:i]); err == nil {
+			t.Errorf("truncated at %d should fail", i)
+		}
+	}
+	if _, err = New(append(b[:len(b):len(b)], 0)); err == nil {
+		t.Error("trailing data should fail")
+	}
+
+	future := append([]byte{}, b...)
+	future[len(binaryMagic)]++
+	if _, err = New(future); err == nil {
+		t.Error("unknown versions should fail")
+	}
+
+	// Version 1 has no devices or clocks: an add of "u" at time 5
+	v1 := []byte(binaryMagic + "\x01\x00\x00\x01\x0a\x01\x00\x01u\x00\x00\x00")
+	if log, err := NewLog(v1); err != nil || !reflect.DeepEqual(log, []Tx{{Time: 5, Kind: TxAdd, UUID: "u"}}) {
+		t.Errorf("version 1 was wrong: %#v %v", log, err)
+	}
+
+	empty, err := new(DB).SaveBinary()
+	must(t, err)
+	if db, err := New(empty); err != nil || !reflect.DeepEqual(db, new(DB)) {
+		t.Errorf("empty db was wrong: %#v %v", db, err)
+	}
+}
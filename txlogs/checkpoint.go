@@ -0,0 +1,105 @@
+package txlogs
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Checkpoint is where a log's history was pruned: the transactions from
+// before Time were squashed by Compact and Hash is a digest of the entries
+// they made, which pruning doesn't change.
+type Checkpoint struct {
+	Time int64
+	Hash string
+}
+
+// ParseCheckpoint parses a checkpoint from String, an empty string is none
+func ParseCheckpoint(s string) (Checkpoint, error) {
+	var c Checkpoint
+	if len(s) == 0 {
+		return c, nil
+	}
+
+	if _, err := fmt.Sscan(s, &c.Time, &c.Hash); err != nil {
+		return Checkpoint{}, fmt.Errorf("failed to parse checkpoint %q: %w", s, err)
+	}
+	return c, nil
+}
+
+// String formats the checkpoint to be stored, see ParseCheckpoint
+func (c Checkpoint) String() string {
+	return fmt.Sprintf("%d %s", c.Time, c.Hash)
+}
+
+// StateHash is a digest of the entries made by the transactions from before
+// the unix nanosecond time
+func (s *DB) StateHash(before int64) (string, error) {
+	state := make(map[string]Entry)
+	for _, tx := range s.Log {
+		if tx.Time >= before {
+			break
+		}
+		if err := applyTx(state, tx); err != nil {
+			return "", err
+		}
+	}
+
+	uuids := make([]string, 0, len(state))
+	for uuid := range state {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+
+	h := sha256.New()
+	var n [binary.MaxVarintLen64]byte
+	write := func(s string) {
+		h.Write(n[:binary.PutUvarint(n[:], uint64(len(s)))])
+		h.Write([]byte(s))
+	}
+	for _, uuid := range uuids {
+		entry := state[uuid]
+		keys := make([]string, 0, len(entry))
+		for k := range entry {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		write(uuid)
+		h.Write(n[:binary.PutUvarint(n[:], uint64(len(keys)))])
+		for _, k := range keys {
+			write(k)
+			write(entry[k])
+		}
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// Prune compacts the history from before the unix nanosecond time (see
+// Compact) and returns the checkpoint it's pruned to. When the entries the
+// history made aren't the same after it's compacted the log is left alone.
+func (s *DB) Prune(before int64) (c Checkpoint, removed int, err error) {
+	hash, err := s.StateHash(before)
+	if err != nil {
+		return c, 0, err
+	}
+
+	log := s.Log
+	if removed, err = s.Compact(before); err != nil {
+		return c, 0, err
+	}
+
+	pruned, err := s.StateHash(before)
+	if err != nil || pruned != hash {
+		s.Log = log
+		s.ResetSnapshot()
+		if err == nil {
+			err = fmt.Errorf("compacting the history before %d changed the entries it made", before)
+		}
+		return c, 0, err
+	}
+
+	return Checkpoint{Time: before, Hash: hash}, removed, nil
+}
@@ -0,0 +1,49 @@
+package txlogs
+
+import "testing"
+
+func TestPrune(t *testing.T) {
+	t.Parallel()
+
+	log := []Tx{
+		{Time: 1, Kind: TxAdd, UUID: "keep"},
+		{Time: 2, Kind: TxSetKey, UUID: "keep", Key: "a", Value: "1"},
+		{Time: 3, Kind: TxSetKey, UUID: "keep", Key: "a", Value: "2"},
+		{Time: 4, Kind: TxAdd, UUID: "gone"},
+		{Time: 5, Kind: TxDelete, UUID: "gone"},
+		{Time: 6, Kind: TxSetKey, UUID: "keep", Key: "a", Value: "3"},
+	}
+
+	store := &DB{Log: append([]Tx{}, log...)}
+	hash, err := store.StateHash(6)
+	must(t, err)
+
+	c, removed, err := store.Prune(6)
+	must(t, err)
+	if removed != 1 || len(store.Log) != len(log)-1 {
+		t.Error("the history should be compacted:", removed, len(store.Log))
+	}
+	if c.Time != 6 || c.Hash != hash {
+		t.Error("the checkpoint should have the entries from before it:", c)
+	}
+
+	parsed, err := ParseCheckpoint(c.String())
+	must(t, err)
+	if parsed != c {
+		t.Error("checkpoint didn't round trip:", parsed)
+	}
+
+	// Changes from before the checkpoint that it didn't have change the hash
+	// but not the hash of what came before them
+	store.Log = append([]Tx{log[0], {Time: 2, Kind: TxSetKey, UUID: "keep", Key: "b", Value: "1"}}, store.Log[1:]...)
+	if newHash, err := store.StateHash(6); err != nil || newHash == hash {
+		t.Error("the hash should have the new change:", err)
+	}
+	before, err := (&DB{Log: log}).StateHash(2)
+	must(t, err)
+	after, err := store.StateHash(2)
+	must(t, err)
+	if before != after {
+		t.Error("the hash should only be of the changes before the time")
+	}
+}
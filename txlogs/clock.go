@@ -0,0 +1,106 @@
+package txlogs
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Clock is a vector clock: how many transactions each device had made that
+// were known when a transaction was made. Unlike the time it tells whether
+// one change was made knowing of another or they were made concurrently.
+//
+// In a Tx it's formatted as "device:n device:n" sorted by device.
+type Clock map[string]uint64
+
+// ParseClock parses a clock from a Tx, an empty string is an empty clock
+func ParseClock(s string) (Clock, error) {
+	c := make(Clock)
+	for _, field := range strings.Fields(s) {
+		i := strings.LastIndexByte(field, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("malformed clock %q", s)
+		}
+		n, err := strconv.ParseUint(field[i+1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed clock %q: %w", s, err)
+		}
+		c[field[:i]] = n
+	}
+
+	return c, nil
+}
+
+// String formats the clock to be stored in a Tx
+func (c Clock) String() string {
+	devices := make([]string, 0, len(c))
+	for d := range c {
+		devices = append(devices, d)
+	}
+	sort.Strings(devices)
+
+	var b strings.Builder
+	for i, d := range devices {
+		if i != 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s:%d", d, c[d])
+	}
+	return b.String()
+}
+
+// Before reports whether c happened before o, o knew everything c did and
+// more
+func (c Clock) Before(o Clock) bool {
+	less := false
+	for d, n := range c {
+		switch {
+		case n > o[d]:
+			return false
+		case n < o[d]:
+			less = true
+		}
+	}
+	for d, n := range o {
+		if _, ok := c[d]; !ok && n != 0 {
+			less = true
+		}
+	}
+
+	return less
+}
+
+// Concurrent reports whether neither of the clocks happened before the
+// other, the changes were made without knowing of each other
+func (c Clock) Concurrent(o Clock) bool {
+	return !c.Before(o) && !o.Before(c)
+}
+
+// update takes the max of each device's count
+func (c Clock) update(o Clock) {
+	for d, n := range o {
+		if n > c[d] {
+			c[d] = n
+		}
+	}
+}
+
+// txsConcurrent reports whether a and b were made without knowing of each
+// other, false when either has no clock since then it can't be known
+func txsConcurrent(a, b Tx) bool {
+	if len(a.Clock) == 0 || len(b.Clock) == 0 {
+		return false
+	}
+
+	ca, err := ParseClock(a.Clock)
+	if err != nil {
+		return false
+	}
+	cb, err := ParseClock(b.Clock)
+	if err != nil {
+		return false
+	}
+
+	return ca.Concurrent(cb)
+}
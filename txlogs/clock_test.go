@@ -0,0 +1,134 @@
+package txlogs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClock(t *testing.T) {
+	t.Parallel()
+
+	a, err := ParseClock("b:1 a:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := a.String(); s != "a:2 b:1" {
+		t.Error("wrong string:", s)
+	}
+
+	tests := []struct {
+		A, B       string
+		Before     bool
+		Concurrent bool
+	}{
+		{"a:1", "a:2", true, false},
+		{"a:2", "a:1", false, false},
+		{"a:1", "a:1 b:1", true, false},
+		{"a:1", "a:1", false, true},
+		{"a:2", "a:1 b:1", false, true},
+		{"", "a:1", true, false},
+	}
+
+	for i, test := range tests {
+		a, err := ParseClock(test.A)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ParseClock(test.B)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := a.Before(b); got != test.Before {
+			t.Errorf("%d) before want: %t, got: %t", i, test.Before, got)
+		}
+		if got := a.Concurrent(b); got != test.Concurrent {
+			t.Errorf("%d) concurrent want: %t, got: %t", i, test.Concurrent, got)
+		}
+	}
+
+	for _, bad := range []string{"a", ":1", "a:x"} {
+		if _, err := ParseClock(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestStamp(t *testing.T) {
+	t.Parallel()
+
+	store := new(DB)
+	uuid, err := store.Add()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx := store.Log[0]; len(tx.Device) != 0 || len(tx.Clock) != 0 {
+		t.Error("should not be stamped without a device:", tx)
+	}
+
+	store.SetDevice("a", nil)
+	store.Set(uuid, "k", "v")
+	store.SetDevice("b", nil)
+	store.DeleteKey(uuid, "k")
+
+	if tx := store.Log[1]; tx.Device != "a" || tx.Clock != "a:1" {
+		t.Error("wrong stamp:", tx)
+	}
+	if tx := store.Log[2]; tx.Device != "b" || tx.Clock != "a:1 b:1" {
+		t.Error("wrong stamp:", tx)
+	}
+
+	// A new db over the same log picks up where the clock was
+	other := &DB{Log: append([]Tx(nil), store.Log...)}
+	other.SetDevice("a", nil)
+	other.Set(uuid, "k", "v")
+	if tx := other.Log[3]; tx.Clock != "a:2 b:1" {
+		t.Error("wrong stamp:", tx)
+	}
+}
+
+func TestMergeClocks(t *testing.T) {
+	t.Parallel()
+
+	add := Tx{Time: 1, Kind: TxAdd, UUID: "e", Device: "a", Clock: "a:1"}
+	other := Tx{Time: 2, Kind: TxAdd, UUID: "f", Device: "a", Clock: "a:2"}
+	// b's clock is fast so its delete has a later time than a's set but it
+	// never saw the set
+	set := Tx{Time: 3, Kind: TxSetKey, UUID: "e", Key: "k", Device: "a", Clock: "a:3"}
+	del := Tx{Time: 4, Kind: TxDelete, UUID: "e", Device: "b", Clock: "a:1 b:1"}
+
+	a := []Tx{add, other, set}
+	b := []Tx{add, del}
+	_, conflicts := Merge(a, b, nil)
+	if len(conflicts) != 1 {
+		t.Fatal("should conflict:", conflicts)
+	}
+	if conflicts[0].Initial != del || conflicts[0].Conflict != set {
+		t.Error("wrong conflict:", conflicts[0])
+	}
+
+	conflicts[0].DiscardInitial()
+	c, conflicts := Merge(a, b, conflicts)
+	if len(conflicts) != 0 {
+		t.Fatal("should be resolved:", conflicts)
+	}
+	if want := []Tx{add, other, set}; !reflect.DeepEqual(c, want) {
+		t.Errorf("want: %v, got: %v", want, c)
+	}
+
+	// A set the delete knew of is fine
+	del.Clock = "a:3 b:1"
+	b = []Tx{add, del}
+	if _, conflicts = Merge(a, b, nil); len(conflicts) != 0 {
+		t.Error("should not conflict:", conflicts)
+	}
+
+	// Without clocks it can't be told
+	set.Clock = ""
+	del.Clock = ""
+	a = []Tx{add, other, set}
+	b = []Tx{add, del}
+	if _, conflicts = Merge(a, b, nil); len(conflicts) != 0 {
+		t.Error("should not conflict:", conflicts)
+	}
+}
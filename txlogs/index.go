@@ -0,0 +1,139 @@
+package txlogs
+
+// Indexer returns the terms the value of an entry's key is found by, see
+// SetIndexer
+type Indexer func(key, value string) []string
+
+// index is an inverted index of the entries, which have each term and how
+// many of their keys it's from
+type index struct {
+	fn    Indexer
+	terms map[string]map[string]int
+	// keys are the terms of each key of each entry, to remove them when
+	// the key changes
+	keys map[string]map[string][]string
+}
+
+func newIndex(fn Indexer) *index {
+	return &index{
+		fn:    fn,
+		terms: make(map[string]map[string]int),
+		keys:  make(map[string]map[string][]string),
+	}
+}
+
+// set replaces the terms of an entry's key with the ones of its new value
+func (ix *index) set(uuid, key, value string) {
+	ix.remove(uuid, key)
+
+	terms := ix.fn(key, value)
+	if len(terms) == 0 {
+		return
+	}
+
+	seen := make(map[string]struct{}, len(terms))
+	unique := terms[:0:0]
+	for _, term := range terms {
+		if _, ok := seen[term]; ok {
+			continue
+		}
+		seen[term] = struct{}{}
+		unique = append(unique, term)
+
+		uuids, ok := ix.terms[term]
+		if !ok {
+			uuids = make(map[string]int)
+			ix.terms[term] = uuids
+		}
+		uuids[uuid]++
+	}
+
+	keys, ok := ix.keys[uuid]
+	if !ok {
+		keys = make(map[string][]string)
+		ix.keys[uuid] = keys
+	}
+	keys[key] = unique
+}
+
+// remove the terms of an entry's key
+func (ix *index) remove(uuid, key string) {
+	for _, term := range ix.keys[uuid][key] {
+		uuids := ix.terms[term]
+		if uuids[uuid]--; uuids[uuid] <= 0 {
+			delete(uuids, uuid)
+		}
+		if len(uuids) == 0 {
+			delete(ix.terms, term)
+		}
+	}
+	delete(ix.keys[uuid], key)
+}
+
+// removeEntry removes the terms of all of an entry's keys
+func (ix *index) removeEntry(uuid string) {
+	for key := range ix.keys[uuid] {
+		ix.remove(uuid, key)
+	}
+	delete(ix.keys, uuid)
+}
+
+// apply keeps the index up to date with a transaction appended to the log
+func (ix *index) apply(tx Tx) {
+	switch tx.Kind {
+	case TxSetKey:
+		ix.set(tx.UUID, tx.Key, tx.Value)
+	case TxDeleteKey:
+		ix.remove(tx.UUID, tx.Key)
+	case TxDelete:
+		ix.removeEntry(tx.UUID)
+	}
+}
+
+// SetIndexer indexes the entries by the terms fn returns for each key of
+// each of them, it's built from the log and kept up to date with it without
+// the snapshot. Lookup finds the entries with terms without going through all
+// of them.
+func (s *DB) SetIndexer(fn Indexer) {
+	s.index = newIndex(fn)
+	if s.lazy != nil {
+		for _, tx := range s.Log[:s.lazy.scanned] {
+			s.index.apply(tx)
+		}
+	}
+}
+
+// Indexed checks if an indexer was set, see SetIndexer
+func (s *DB) Indexed() bool {
+	return s.index != nil
+}
+
+// Lookup returns the uuids of the entries that have all the terms at the end
+// of the log, it needs an indexer (see SetIndexer).
+func (s *DB) Lookup(terms ...string) map[string]struct{} {
+	found := make(map[string]struct{})
+	if s.index == nil || len(terms) == 0 {
+		return found
+	}
+	s.scan()
+
+	// Start with the rarest term, the rest can only take entries away
+	rarest := terms[0]
+	for _, term := range terms[1:] {
+		if len(s.index.terms[term]) < len(s.index.terms[rarest]) {
+			rarest = term
+		}
+	}
+
+Entries:
+	for uuid := range s.index.terms[rarest] {
+		for _, term := range terms {
+			if _, ok := s.index.terms[term][uuid]; !ok {
+				continue Entries
+			}
+		}
+		found[uuid] = struct{}{}
+	}
+
+	return found
+}
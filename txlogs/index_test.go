@@ -0,0 +1,91 @@
+package txlogs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	t.Parallel()
+
+	words := func(key, value string) []string {
+		if key == "secret" {
+			return nil
+		}
+		return strings.Fields(value)
+	}
+
+	store := new(DB)
+	a, err := store.Add()
+	must(t, err)
+	store.Set(a, "notes", "red green")
+	must(t, store.UpdateSnapshot())
+
+	// Entries already in the snapshot are indexed
+	store.SetIndexer(words)
+	b, err := store.Add()
+	must(t, err)
+	store.Set(b, "notes", "green blue")
+	store.Set(b, "user", "blue")
+	store.Set(b, "secret", "red")
+	must(t, store.UpdateSnapshot())
+
+	lookup := func(terms ...string) []string {
+		var uuids []string
+		for uuid := range store.Lookup(terms...) {
+			uuids = append(uuids, uuid)
+		}
+		if len(uuids) == 2 && uuids[0] > uuids[1] {
+			uuids[0], uuids[1] = uuids[1], uuids[0]
+		}
+		return uuids
+	}
+	both := []string{a, b}
+	if a > b {
+		both = []string{b, a}
+	}
+
+	if got := lookup("green"); !reflect.DeepEqual(got, both) {
+		t.Error("green should be in both:", got)
+	}
+	if got := lookup("red"); !reflect.DeepEqual(got, []string{a}) {
+		t.Error("values the indexer leaves out should not be found:", got)
+	}
+	if got := lookup("green", "blue"); !reflect.DeepEqual(got, []string{b}) {
+		t.Error("all the terms should be needed:", got)
+	}
+
+	// A term from two keys stays until both are gone
+	store.DeleteKey(b, "notes")
+	must(t, store.UpdateSnapshot())
+	if got := lookup("blue"); !reflect.DeepEqual(got, []string{b}) {
+		t.Error("blue is still the user:", got)
+	}
+	if got := lookup("green"); !reflect.DeepEqual(got, []string{a}) {
+		t.Error("the deleted key's terms should be gone:", got)
+	}
+
+	store.Set(a, "notes", "blue")
+	store.Delete(b)
+	must(t, store.UpdateSnapshot())
+	if got := lookup("blue"); !reflect.DeepEqual(got, []string{a}) {
+		t.Error("the deleted entry should be gone and the new value found:", got)
+	}
+	if got := lookup("green"); len(got) != 0 {
+		t.Error("the old value should be gone:", got)
+	}
+
+	// Rolling back rebuilds it with the snapshot
+	store.Begin()
+	store.Set(a, "notes", "yellow")
+	must(t, store.UpdateSnapshot())
+	store.Rollback()
+	must(t, store.UpdateSnapshot())
+	if got := lookup("blue"); !reflect.DeepEqual(got, []string{a}) {
+		t.Error("the rolled back change should be gone:", got)
+	}
+	if got := lookup("yellow"); len(got) != 0 {
+		t.Error("the rolled back change should be gone:", got)
+	}
+}
@@ -0,0 +1,157 @@
+package txlogs
+
+// lazy is what's materialized from the log on demand instead of building the
+// whole snapshot: where each entry's transactions are, the entries that were
+// asked for and the values of the keys that were (see Entry and Values).
+//
+// It's kept up to date by going through the transactions appended since it
+// last did, other changes to the log must reset it.
+type lazy struct {
+	// scanned is how many transactions of the log have been gone through,
+	// last is the last of them to notice a log that changed under us
+	scanned int
+	last    Tx
+
+	txs     map[string][]int
+	entries map[string]Entry
+	values  map[string]map[string]string
+}
+
+// resetLazy throws away what was materialized, the keys whose values were
+// asked for are kept so they're materialized again along with the index
+func (s *DB) resetLazy() {
+	l := &lazy{
+		txs:     make(map[string][]int),
+		entries: make(map[string]Entry),
+		values:  make(map[string]map[string]string),
+	}
+	if s.lazy != nil {
+		for key := range s.lazy.values {
+			l.values[key] = make(map[string]string)
+		}
+	}
+	s.lazy = l
+
+	if s.index != nil {
+		s.index = newIndex(s.index.fn)
+	}
+}
+
+// truncateLazy is resetLazy when the log is about to be cut at n and that
+// takes away transactions that were gone through
+func (s *DB) truncateLazy(n int) {
+	if s.lazy != nil && s.lazy.scanned > n {
+		s.resetLazy()
+	}
+}
+
+// scan goes through the transactions appended to the log since the last
+// time and applies them to what was materialized
+func (s *DB) scan() *lazy {
+	l := s.lazy
+	if l == nil || l.scanned > len(s.Log) || (l.scanned != 0 && s.Log[l.scanned-1] != l.last) {
+		s.resetLazy()
+		l = s.lazy
+	}
+
+	for ; l.scanned < len(s.Log); l.scanned++ {
+		tx := s.Log[l.scanned]
+		l.txs[tx.UUID] = append(l.txs[tx.UUID], l.scanned)
+		l.last = tx
+
+		// Entries are changed in place like the snapshot's are
+		if entry, ok := l.entries[tx.UUID]; ok {
+			switch {
+			case tx.Kind == TxSetKey && entry != nil:
+				entry[tx.Key] = tx.Value
+			case tx.Kind == TxDeleteKey:
+				delete(entry, tx.Key)
+			default:
+				delete(l.entries, tx.UUID)
+			}
+		}
+
+		if tx.Kind == TxDelete {
+			for _, values := range l.values {
+				delete(values, tx.UUID)
+			}
+		} else if values, ok := l.values[tx.Key]; ok {
+			applyValue(values, tx)
+		}
+
+		if s.index != nil {
+			s.index.apply(tx)
+		}
+	}
+
+	return l
+}
+
+// applyValue applies a transaction to the values of its key
+func applyValue(values map[string]string, tx Tx) {
+	switch tx.Kind {
+	case TxSetKey:
+		values[tx.UUID] = tx.Value
+	case TxDeleteKey, TxDelete:
+		delete(values, tx.UUID)
+	}
+}
+
+// Entry returns an entry as it is at the end of the log, nil if it doesn't
+// exist. When the snapshot isn't up to date only the entry is materialized
+// from its transactions, it's kept and changed along with the log from then
+// on. It must not be modified.
+func (s *DB) Entry(uuid string) (Entry, error) {
+	if s.Snapshot != nil && s.Version == uint(len(s.Log)) {
+		return s.Snapshot[uuid], nil
+	}
+
+	l := s.scan()
+	if entry, ok := l.entries[uuid]; ok {
+		return entry, nil
+	}
+
+	snap := make(map[string]Entry, 1)
+	for _, i := range l.txs[uuid] {
+		if err := applyTx(snap, s.Log[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	entry := snap[uuid]
+	l.entries[uuid] = entry
+	return entry, nil
+}
+
+// Values returns the value of key for each entry that has it, by uuid, as
+// they are at the end of the log. They're materialized without the snapshot
+// and kept up to date with the log once they've been asked for. The map must
+// not be modified.
+func (s *DB) Values(key string) map[string]string {
+	l := s.scan()
+	if values, ok := l.values[key]; ok {
+		return values
+	}
+
+	values := make(map[string]string)
+	for _, tx := range s.Log {
+		if tx.Key == key || tx.Kind == TxDelete {
+			applyValue(values, tx)
+		}
+	}
+	l.values[key] = values
+	return values
+}
+
+// hasPrefix checks if log starts with the transactions in prefix
+func hasPrefix(log, prefix []Tx) bool {
+	if len(prefix) > len(log) {
+		return false
+	}
+	for i, tx := range prefix {
+		if log[i] != tx {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package txlogs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLazy(t *testing.T) {
+	t.Parallel()
+
+	store := new(DB)
+	a, err := store.Add()
+	must(t, err)
+	store.Set(a, "name", "a")
+	store.Set(a, "pass", "1")
+	b, err := store.Add()
+	must(t, err)
+	store.Set(b, "name", "b")
+	must(t, store.UpdateSnapshot())
+
+	data, err := store.SaveBinary()
+	must(t, err)
+	store, err = New(data)
+	must(t, err)
+
+	entry, err := store.Entry(a)
+	must(t, err)
+	if !reflect.DeepEqual(entry, Entry{"name": "a", "pass": "1"}) {
+		t.Error("entry was wrong:", entry)
+	}
+	names := store.Values("name")
+	if !reflect.DeepEqual(names, map[string]string{a: "a", b: "b"}) {
+		t.Error("names were wrong:", names)
+	}
+
+	// They follow the log like the snapshot does
+	store.Set(a, "pass", "2")
+	store.Delete(b)
+	if entry, err = store.Entry(a); err != nil || entry["pass"] != "2" {
+		t.Error("the entry should have changed:", entry, err)
+	}
+	if entry, err = store.Entry(b); err != nil || entry != nil {
+		t.Error("the entry should be gone:", entry, err)
+	}
+	if names = store.Values("name"); !reflect.DeepEqual(names, map[string]string{a: "a"}) {
+		t.Error("names were wrong:", names)
+	}
+
+	store.Begin()
+	store.Set(a, "name", "c")
+	if names = store.Values("name"); names[a] != "c" {
+		t.Error("the name should have changed:", names)
+	}
+	store.Rollback()
+	if names = store.Values("name"); names[a] != "a" {
+		t.Error("the rolled back name should be gone:", names)
+	}
+	if entry, err = store.Entry(a); err != nil || entry["name"] != "a" {
+		t.Error("the rolled back name should be gone:", entry, err)
+	}
+
+	// A log that isn't the same from the start is gone through again
+	other := new(DB)
+	c, err := other.Add()
+	must(t, err)
+	other.Set(c, "name", "c")
+	must(t, store.ReplaceLog(other.Log))
+	if names = store.Values("name"); !reflect.DeepEqual(names, map[string]string{c: "c"}) {
+		t.Error("names were wrong:", names)
+	}
+	if entry, err = store.Entry(a); err != nil || entry != nil {
+		t.Error("the entry isn't in the log anymore:", entry, err)
+	}
+}
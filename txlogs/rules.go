@@ -0,0 +1,179 @@
+package txlogs
+
+import (
+	"sort"
+	"strings"
+)
+
+// MergeRule gives the value a key should have when two logs both set it
+// since they forked, without knowing of each other. base is what it was
+// before either did (empty when it wasn't set) and older and newer are what
+// each set it to, newer is what Merge leaves it at. See Resolve.
+type MergeRule func(base, older, newer string) string
+
+// UnionRule merges lists of items split by sep: the items either side added
+// since the base are kept and the ones either side removed are gone. Items
+// are in the order of the newer value, the ones only the older added follow.
+func UnionRule(sep string) MergeRule {
+	return func(base, older, newer string) string {
+		inBase := itemSet(base, sep)
+		inOlder := itemSet(older, sep)
+		inNewer := itemSet(newer, sep)
+
+		var items []string
+		for _, item := range splitItems(newer, sep) {
+			// Removed by the older since the base
+			if _, ok := inBase[item]; ok {
+				if _, ok := inOlder[item]; !ok {
+					continue
+				}
+			}
+			items = append(items, item)
+		}
+		for _, item := range splitItems(older, sep) {
+			_, ok1 := inBase[item]
+			_, ok2 := inNewer[item]
+			if !ok1 && !ok2 {
+				items = append(items, item)
+				inNewer[item] = struct{}{}
+			}
+		}
+
+		return strings.Join(items, sep)
+	}
+}
+
+// AppendRule merges lists of items split by sep that are only added to, the
+// items of the newer value are followed by the ones only the older has
+func AppendRule(sep string) MergeRule {
+	return func(base, older, newer string) string {
+		inNewer := itemSet(newer, sep)
+
+		items := splitItems(newer, sep)
+		for _, item := range splitItems(older, sep) {
+			if _, ok := inNewer[item]; !ok {
+				items = append(items, item)
+				inNewer[item] = struct{}{}
+			}
+		}
+
+		return strings.Join(items, sep)
+	}
+}
+
+func splitItems(value, sep string) []string {
+	if len(value) == 0 {
+		return nil
+	}
+	return strings.Split(value, sep)
+}
+
+func itemSet(value, sep string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, item := range splitItems(value, sep) {
+		set[item] = struct{}{}
+	}
+	return set
+}
+
+// Resolve finds the keys that were set in both a and b since they forked
+// (by transactions the other doesn't have) and returns sets that give them
+// the value of their rule in c, the merge of a and b. Only the ones that
+// change what c has them at are returned, in the order their keys were last
+// set in c. Keys without a rule keep the newest value, as do keys one side
+// deleted and entries that are gone.
+//
+// Resolving the logs again once both have the sets returns nothing, the
+// rules give the value they already have.
+func Resolve(a, b, c []Tx, rules map[string]MergeRule) []Tx {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	inA := make(map[int64]struct{}, len(a))
+	for _, tx := range a {
+		inA[tx.Time] = struct{}{}
+	}
+	inB := make(map[int64]struct{}, len(b))
+	for _, tx := range b {
+		inB[tx.Time] = struct{}{}
+	}
+
+	type keyState struct {
+		base        string
+		last        int
+		ours, their *Tx
+	}
+	keys := make(map[[2]string]*keyState)
+	deleted := make(map[string]struct{})
+
+	for i := range c {
+		tx := &c[i]
+		if tx.Kind == TxDelete {
+			deleted[tx.UUID] = struct{}{}
+			continue
+		}
+		if tx.Kind != TxSetKey && tx.Kind != TxDeleteKey {
+			continue
+		}
+		if _, ok := rules[tx.Key]; !ok {
+			continue
+		}
+
+		k := [2]string{tx.UUID, tx.Key}
+		state, ok := keys[k]
+		if !ok {
+			state = new(keyState)
+			keys[k] = state
+		}
+		state.last = i
+
+		_, ok1 := inA[tx.Time]
+		_, ok2 := inB[tx.Time]
+		switch {
+		case ok1 && ok2:
+			state.base = tx.Value
+			state.ours, state.their = nil, nil
+		case ok1:
+			state.ours = tx
+		case ok2:
+			state.their = tx
+		}
+	}
+
+	var states []*keyState
+	for k, state := range keys {
+		if _, ok := deleted[k[0]]; ok {
+			continue
+		}
+		if state.ours == nil || state.their == nil ||
+			state.ours.Kind != TxSetKey || state.their.Kind != TxSetKey {
+			continue
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].last < states[j].last })
+
+	var sets []Tx
+	for _, state := range states {
+		older, newer := state.ours, state.their
+		if older.Time > newer.Time {
+			older, newer = newer, older
+		}
+
+		current := c[state.last]
+		value := rules[newer.Key](state.base, older.Value, newer.Value)
+		if current.Kind == TxSetKey && value == current.Value {
+			continue
+		}
+
+		sets = append(sets, Tx{
+			Kind:  TxSetKey,
+			UUID:  newer.UUID,
+			Key:   newer.Key,
+			Value: value,
+		})
+	}
+
+	return sets
+}
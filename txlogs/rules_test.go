@@ -0,0 +1,80 @@
+package txlogs
+
+import (
+	"testing"
+)
+
+func TestMergeRules(t *testing.T) {
+	t.Parallel()
+
+	union := UnionRule(",")
+	tests := []struct {
+		Rule               MergeRule
+		Base, Older, Newer string
+		Want               string
+	}{
+		{union, "a,b", "a,b,c", "a,b,d", "a,b,d,c"},
+		{union, "a,b", "a", "a,b,d", "a,d"},
+		{union, "a,b", "a,b,c", "b", "b,c"},
+		{union, "", "a", "b", "b,a"},
+		{AppendRule(","), "a,b", "a", "c", "c,a"},
+	}
+
+	for i, test := range tests {
+		if got := test.Rule(test.Base, test.Older, test.Newer); got != test.Want {
+			t.Errorf("%d) want: %q, got: %q", i, test.Want, got)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	t.Parallel()
+
+	base := new(DB)
+	uuid, err := base.Add()
+	must(t, err)
+	base.Set(uuid, "notes", "a\nb")
+	base.Set(uuid, "labels", "x")
+
+	a := &DB{Log: append([]Tx(nil), base.Log...)}
+	b := &DB{Log: append([]Tx(nil), base.Log...)}
+	a.Set(uuid, "notes", "a\nb\nc")
+	a.Set(uuid, "labels", "x,y")
+	a.Set(uuid, "pass", "1")
+	b.Set(uuid, "notes", "a\nd")
+	b.Set(uuid, "labels", "x,z")
+	b.Set(uuid, "pass", "2")
+
+	rules := map[string]MergeRule{
+		"notes":  UnionRule("\n"),
+		"labels": UnionRule(","),
+	}
+
+	merged, conflicts := Merge(a.Log, b.Log, nil)
+	if len(conflicts) != 0 {
+		t.Fatal("unexpected conflicts:", conflicts)
+	}
+	sets := Resolve(a.Log, b.Log, merged, rules)
+	if len(sets) != 2 {
+		t.Fatalf("want 2 sets, got: %#v", sets)
+	}
+	if sets[0].Key != "notes" || sets[0].Value != "a\nd\nc" {
+		t.Errorf("notes were wrong: %#v", sets[0])
+	}
+	if sets[1].Key != "labels" || sets[1].Value != "x,z,y" {
+		t.Errorf("labels were wrong: %#v", sets[1])
+	}
+
+	// Once they're made the logs agree
+	a.Log = merged
+	for _, tx := range sets {
+		a.Set(tx.UUID, tx.Key, tx.Value)
+	}
+	merged, conflicts = Merge(b.Log, a.Log, nil)
+	if len(conflicts) != 0 {
+		t.Fatal("unexpected conflicts:", conflicts)
+	}
+	if sets = Resolve(b.Log, a.Log, merged, rules); len(sets) != 0 {
+		t.Errorf("there should be nothing left to resolve: %#v", sets)
+	}
+}
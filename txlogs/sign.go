@@ -0,0 +1,217 @@
+package txlogs
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// prevHashSize is how much of the hash of the transaction before it a
+// transaction keeps
+const prevHashSize = 16
+
+// TamperError is a transaction that shows the log was changed by someone
+// other than the devices that wrote it
+type TamperError struct {
+	Tx     Tx
+	Reason string
+}
+
+// Error interface
+func (t TamperError) Error() string {
+	return fmt.Sprintf("log was tampered with: %s change to %s at %d %s", t.Tx.Kind, t.Tx.UUID, t.Tx.Time, t.Reason)
+}
+
+// IsTamperError checks if the error is a tamper error
+func IsTamperError(err error) bool {
+	_, ok := err.(TamperError)
+	return ok
+}
+
+// txHash hashes everything in a transaction but its signature
+func txHash(tx Tx) [sha256.Size]byte {
+	h := sha256.New()
+	var n [binary.MaxVarintLen64]byte
+	h.Write(n[:binary.PutVarint(n[:], tx.Time)])
+	for _, s := range []string{string(tx.Kind), tx.UUID, tx.Key, tx.Value, tx.Device, tx.Clock, tx.Prev} {
+		h.Write(n[:binary.PutUvarint(n[:], uint64(len(s)))])
+		h.Write([]byte(s))
+	}
+
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// prevOf is what a transaction following tx has as its Prev, its time and
+// the start of its hash
+func prevOf(tx Tx) string {
+	sum := txHash(tx)
+	return fmt.Sprintf("%d %x", tx.Time, sum[:prevHashSize])
+}
+
+// parsePrev splits a Prev into the time and hash of the transaction
+func parsePrev(prev string) (int64, string, error) {
+	i := strings.IndexByte(prev, ' ')
+	if i <= 0 {
+		return 0, "", fmt.Errorf("malformed prev %q", prev)
+	}
+	t, err := strconv.ParseInt(prev[:i], 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("malformed prev %q: %w", prev, err)
+	}
+	if _, err = hex.DecodeString(prev[i+1:]); err != nil {
+		return 0, "", fmt.Errorf("malformed prev %q: %w", prev, err)
+	}
+
+	return t, prev[i+1:], nil
+}
+
+// sign chains tx to the end of the log and signs it
+func (s *DB) sign(tx *Tx) {
+	if len(s.Log) != 0 {
+		tx.Prev = prevOf(s.Log[len(s.Log)-1])
+	}
+
+	sum := txHash(*tx)
+	tx.Sig = base64.StdEncoding.EncodeToString(ed25519.Sign(s.signer, sum[:]))
+}
+
+// SignFrom signs the unsigned transactions from the i'th on with the key set
+// by SetDevice, chaining each to the one before it, and returns how many it
+// signed. It's for the changes a device made before it had a key that no
+// other copy of the log has yet, signed logs won't take them unsigned (see
+// Verify). Transactions of other devices are left alone.
+func (s *DB) SignFrom(i int) int {
+	if s.signer == nil || i < 0 {
+		return 0
+	}
+
+	n := 0
+	for ; i < len(s.Log); i++ {
+		tx := s.Log[i]
+		if len(tx.Sig) != 0 || (len(tx.Device) != 0 && tx.Device != s.device) {
+			continue
+		}
+
+		tx.Device = s.device
+		tx.Prev = ""
+		if i > 0 {
+			tx.Prev = prevOf(s.Log[i-1])
+		}
+		sum := txHash(tx)
+		tx.Sig = base64.StdEncoding.EncodeToString(ed25519.Sign(s.signer, sum[:]))
+		s.Log[i] = tx
+		n++
+	}
+
+	if n != 0 {
+		// The values are the same but the transactions aren't
+		s.resetLazy()
+	}
+	return n
+}
+
+// VerifyTx checks the signature of a transaction against its device's key
+func VerifyTx(tx Tx, pub ed25519.PublicKey) bool {
+	if len(tx.Sig) == 0 || len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(tx.Sig)
+	if err != nil {
+		return false
+	}
+
+	sum := txHash(tx)
+	return ed25519.Verify(pub, sum[:], sig)
+}
+
+// Verify checks the transactions in log that aren't in known before they're
+// merged. keys are the public keys of the trusted devices by id. It returns
+// a TamperError when a known transaction has a different copy in log, a
+// signature doesn't match or is by a device without a key, a device with a
+// key has an unsigned transaction, an unsigned transaction is merged into a
+// known log that's signed or comes after the first signed one in log (any
+// unsigned transaction when requireSigned is true) or the transaction before
+// a signed one (see Tx.Prev) isn't the one it was written after. Once a log
+// has been signed nobody can add unsigned changes to it, whatever time they
+// claim to have been made at. Devices sign the changes they made before they
+// had a key when they get one (see SignFrom) so they can still be merged.
+//
+// Transactions the ones in log were written after may be missing, they're
+// removed by compacting and discarding conflicts, but they can't have been
+// changed.
+func Verify(known, log []Tx, keys map[string]ed25519.PublicKey, requireSigned bool) error {
+	byTime := make(map[int64]Tx, len(known)+len(log))
+	signed := false
+	var firstSigned int64
+	for _, tx := range known {
+		byTime[tx.Time] = tx
+		if len(tx.Sig) != 0 && (!signed || tx.Time < firstSigned) {
+			signed, firstSigned = true, tx.Time
+		}
+	}
+	knownSigned := signed
+
+	var fresh []Tx
+	for _, tx := range log {
+		have, ok := byTime[tx.Time]
+		if !ok {
+			byTime[tx.Time] = tx
+			fresh = append(fresh, tx)
+			continue
+		}
+		if have != tx {
+			return TamperError{Tx: tx, Reason: "was changed"}
+		}
+	}
+	for _, tx := range fresh {
+		if len(tx.Sig) != 0 && (!signed || tx.Time < firstSigned) {
+			signed, firstSigned = true, tx.Time
+		}
+	}
+
+	for _, tx := range fresh {
+		pub := keys[tx.Device]
+		if len(tx.Sig) == 0 {
+			if requireSigned {
+				return TamperError{Tx: tx, Reason: "is not signed"}
+			}
+			if len(tx.Device) != 0 && pub != nil {
+				return TamperError{Tx: tx, Reason: "is not signed by its device " + tx.Device}
+			}
+			if knownSigned {
+				return TamperError{Tx: tx, Reason: "is not signed and the log it's merged into is"}
+			}
+			if signed && tx.Time > firstSigned {
+				return TamperError{Tx: tx, Reason: "is not signed and was made after signing began"}
+			}
+			continue
+		}
+
+		if pub == nil {
+			return TamperError{Tx: tx, Reason: "is signed by an unknown device " + tx.Device}
+		}
+		if !VerifyTx(tx, pub) {
+			return TamperError{Tx: tx, Reason: "has a bad signature"}
+		}
+
+		if len(tx.Prev) == 0 {
+			continue
+		}
+		t, hash, err := parsePrev(tx.Prev)
+		if err != nil {
+			return TamperError{Tx: tx, Reason: err.Error()}
+		}
+		if before, ok := byTime[t]; ok && !strings.HasSuffix(prevOf(before), " "+hash) {
+			return TamperError{Tx: tx, Reason: "follows a change that was changed"}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package txlogs
+
+import (
+	"crypto/ed25519"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	pub, key, err := ed25519.GenerateKey(nil)
+	must(t, err)
+	keys := map[string]ed25519.PublicKey{"dev": pub}
+
+	// An unsigned change from before the device had a key and signed ones
+	store := new(DB)
+	uuid, err := store.Add()
+	must(t, err)
+	store.SetDevice("dev", key)
+	store.Set(uuid, "name", "entry")
+	store.Set(uuid, "pass", "hunter2")
+
+	log := store.Log
+	if !VerifyTx(log[1], pub) || VerifyTx(log[0], pub) {
+		t.Error("only the signed transactions should verify")
+	}
+	if err = Verify(nil, log, keys, false); err != nil {
+		t.Error(err)
+	}
+	if err = Verify(log, log, nil, true); err != nil {
+		t.Error("known transactions are not checked again:", err)
+	}
+
+	// Compacting or discarding leaves holes in the chain
+	if err = Verify(nil, []Tx{log[0], log[2]}, keys, false); err != nil {
+		t.Error(err)
+	}
+
+	// Someone without a key can't slip in an unsigned change after the
+	// signed ones, whether the signed ones are new or already known
+	injected := append(append([]Tx(nil), log...), Tx{
+		Time:  log[len(log)-1].Time + 1,
+		Kind:  TxSetKey,
+		UUID:  uuid,
+		Key:   "pass",
+		Value: "hunter3",
+	})
+
+	// Or one that claims to be from before signing began
+	backdated := append(append([]Tx(nil), log...), Tx{
+		Time:  log[0].Time - 1,
+		Kind:  TxSetKey,
+		UUID:  uuid,
+		Key:   "pass",
+		Value: "hunter3",
+	})
+
+	tamper := func(i int, fn func(tx *Tx)) []Tx {
+		doctored := append([]Tx(nil), log...)
+		fn(&doctored[i])
+		return doctored
+	}
+
+	tests := []struct {
+		Name          string
+		Known         []Tx
+		Log           []Tx
+		Keys          map[string]ed25519.PublicKey
+		RequireSigned bool
+	}{
+		{"changed value", nil, tamper(2, func(tx *Tx) { tx.Value = "hunter3" }), keys, false},
+		{"stripped signature", nil, tamper(2, func(tx *Tx) { tx.Sig = "" }), keys, false},
+		{"unknown device", nil, log, nil, false},
+		{"unsigned", nil, log, keys, true},
+		{"changed before it", nil, tamper(0, func(tx *Tx) { tx.UUID = "other" }), keys, false},
+		{"changed known", log[:1], tamper(0, func(tx *Tx) { tx.UUID = "other" }), keys, false},
+		{"injected unsigned", nil, injected, keys, false},
+		{"injected unsigned after known", log, injected, keys, false},
+		{"backdated unsigned", log, backdated, keys, false},
+	}
+
+	for _, test := range tests {
+		err := Verify(test.Known, test.Log, test.Keys, test.RequireSigned)
+		if !IsTamperError(err) {
+			t.Errorf("%s: expected a tamper error, got: %v", test.Name, err)
+		}
+	}
+}
+
+func TestSignFrom(t *testing.T) {
+	t.Parallel()
+
+	pub, key, err := ed25519.GenerateKey(nil)
+	must(t, err)
+	keys := map[string]ed25519.PublicKey{"dev": pub}
+
+	// The first change was synced, the rest were made after it unsigned
+	store := new(DB)
+	uuid, err := store.Add()
+	must(t, err)
+	synced := append([]Tx(nil), store.Log...)
+	store.Set(uuid, "name", "entry")
+	store.Set(uuid, "pass", "hunter2")
+
+	// A remote that's already signed
+	remote := new(DB)
+	remote.Log = append([]Tx(nil), synced...)
+	remote.SetDevice("dev", key)
+	remote.Set(uuid, "user", "me")
+
+	if n := store.SignFrom(1); n != 0 {
+		t.Error("signed without a key:", n)
+	}
+	if err = Verify(remote.Log, store.Log, keys, false); !IsTamperError(err) {
+		t.Error("unsigned changes should not merge into a signed log:", err)
+	}
+
+	store.SetDevice("dev", key)
+	if n := store.SignFrom(1); n != 2 {
+		t.Error("want 2 signed, got:", n)
+	}
+	if len(store.Log[0].Sig) != 0 {
+		t.Error("the synced change should be left alone")
+	}
+	for _, tx := range store.Log[1:] {
+		if tx.Device != "dev" || !VerifyTx(tx, pub) {
+			t.Errorf("not signed by the device: %#v", tx)
+		}
+	}
+	if entry, _ := store.Entry(uuid); entry["pass"] != "hunter2" {
+		t.Error("values changed:", entry)
+	}
+	if err = Verify(remote.Log, store.Log, keys, false); err != nil {
+		t.Error(err)
+	}
+}
@@ -27,6 +27,19 @@ type Tx struct {
 	UUID  string `msgpack:"uuid,omitempty" json:"uuid,omitempty"`
 	Key   string `msgpack:"key,omitempty" json:"key,omitempty"`
 	Value string `msgpack:"value,omitempty" json:"value,omitempty"`
+
+	// Device is the id of the device that made the change and Clock what it
+	// knew of the log then (see Clock). Both are empty for transactions
+	// from before they existed or from a DB without a device.
+	Device string `msgpack:"device,omitempty" json:"device,omitempty"`
+	Clock  string `msgpack:"clock,omitempty" json:"clock,omitempty"`
+
+	// Prev is the time and hash of the transaction that was last in the log
+	// when this one was made and Sig the device's signature of it all, the
+	// log can't be changed without it showing (see Verify). Both are empty
+	// when the device had no key.
+	Prev string `msgpack:"prev,omitempty" json:"prev,omitempty"`
+	Sig  string `msgpack:"sig,omitempty" json:"sig,omitempty"`
 }
 
 // conflict types
@@ -2,9 +2,11 @@
 package txlogs
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	uuidpkg "github.com/gofrs/uuid"
@@ -14,6 +16,10 @@ import (
 // The transaction logs are made up of Txs. You can see all the kinds possible
 // on the documentation for Tx.
 //
+// A DB isn't safe for concurrent use, not even its reads: Entry, Values and
+// the other lookups materialize what they read from the log on demand (see
+// lazy) and that writes to the DB. Callers sharing one have to lock it.
+//
 // On-disk it will look like this (uuids truncated for readability):
 //
 //   {
@@ -39,7 +45,23 @@ type DB struct {
 	// Log of all transactions.
 	Log []Tx `msgpack:"log,omitempty" json:"log,omitempty"`
 
-	txPoint int
+	// txPoints are the lengths of the log when each open transaction began,
+	// the innermost last
+	txPoints []int
+
+	// device stamps new transactions and signer signs them, clock is what
+	// the log knows of each device (nil until it's needed)
+	device string
+	signer ed25519.PrivateKey
+	clock  Clock
+
+	// pending is the snapshot as it was loaded, it's decoded the first time
+	// it's needed (see UpdateSnapshot)
+	pending []byte
+	// lazy is what was materialized from the log without the snapshot (see
+	// Entry), index is kept up to date with it once there's an indexer
+	lazy  *lazy
+	index *index
 }
 
 // Entry is a cached entry in the store, it holds the values as currently
@@ -50,8 +72,13 @@ type storeNoSnapshot struct {
 	Log []Tx `msgpack:"log,omitempty" json:"log,omitempty"`
 }
 
-// New takes a json blob and unmarshals it into a DB
+// New takes a json blob (or the binary encoding, see SaveBinary) and
+// unmarshals it into a DB
 func New(data []byte) (*DB, error) {
+	if IsBinary(data) {
+		return loadBinary(data, true)
+	}
+
 	s := new(DB)
 	if err := json.Unmarshal(data, &s); err != nil {
 		return nil, err
@@ -62,6 +89,14 @@ func New(data []byte) (*DB, error) {
 
 // NewLog parses the same data as New() but only returns the log
 func NewLog(data []byte) ([]Tx, error) {
+	if IsBinary(data) {
+		s, err := loadBinary(data, false)
+		if err != nil {
+			return nil, err
+		}
+		return s.Log, nil
+	}
+
 	s := new(storeNoSnapshot)
 	if err := json.Unmarshal(data, &s); err != nil {
 		return nil, err
@@ -72,9 +107,12 @@ func NewLog(data []byte) ([]Tx, error) {
 
 // Save marshals as json blob
 func (s *DB) Save() ([]byte, error) {
-	if s.txPoint != 0 {
+	if s.InTx() {
 		return nil, errors.New("refusing to save while transaction active")
 	}
+	if err := s.decodePending(); err != nil {
+		return nil, err
+	}
 
 	return json.Marshal(s)
 }
@@ -87,13 +125,13 @@ func (s *DB) Add() (uuid string, err error) {
 	}
 
 	// Does not use appendLog so ID/Time must be filled out by hand
-	s.Log = append(s.Log,
-		Tx{
-			Time: time.Now().UnixNano(),
-			Kind: TxAdd,
-			UUID: uuidObj.String(),
-		},
-	)
+	tx := Tx{
+		Time: time.Now().UnixNano(),
+		Kind: TxAdd,
+		UUID: uuidObj.String(),
+	}
+	s.stamp(&tx)
+	s.Log = append(s.Log, tx)
 
 	return uuidObj.String(), nil
 }
@@ -134,46 +172,105 @@ func (s *DB) DeleteKey(uuid, key string) {
 // appendLog creates a new UUID for tx.ID and appends the log
 func (s *DB) appendLog(tx Tx) {
 	tx.Time = time.Now().UnixNano()
+	s.stamp(&tx)
 	s.Log = append(s.Log, tx)
 }
 
-// Begin a transaction, will panic if commit/rollback have not been issued
-// after a previous Begin.
+// SetDevice sets the id of this device that new transactions are stamped
+// with along with their clock (see Tx.Device) and the key they're signed
+// with (see Tx.Sig). An empty id stops stamping, a nil key stops signing.
+func (s *DB) SetDevice(id string, key ed25519.PrivateKey) {
+	s.device = id
+	s.signer = key
+}
+
+// Device returns the id set by SetDevice
+func (s *DB) Device() string {
+	return s.device
+}
+
+// stamp fills in the device and clock of a new transaction
+func (s *DB) stamp(tx *Tx) {
+	if len(s.device) == 0 {
+		return
+	}
+
+	if s.clock == nil {
+		s.clock = make(Clock)
+		for _, t := range s.Log {
+			if c, err := ParseClock(t.Clock); err == nil {
+				s.clock.update(c)
+			}
+		}
+	}
+
+	s.clock[s.device]++
+	tx.Device = s.device
+	tx.Clock = s.clock.String()
+
+	if s.signer != nil {
+		s.sign(tx)
+	}
+}
+
+// Begin a transaction, everything added to the log until it's committed
+// can be rolled back at once, across any number of entries.
 //
-// We add 1 to the length to keep the 0 valid as a "no transaction started"
-// sentinel value.
+// Transactions nest: beginning one inside another starts a savepoint, rolling
+// it back only undoes what was added since it began and committing it leaves
+// its changes to the outer transaction, which can still roll them back.
 func (s *DB) Begin() {
-	s.txPoint = len(s.Log) + 1
+	s.txPoints = append(s.txPoints, len(s.Log))
 }
 
-// Commit the transactions to the log
+// Commit the innermost transaction
 func (s *DB) Commit() {
-	if s.txPoint == 0 {
+	if !s.InTx() {
 		panic("commit called before begin")
 	}
-	s.txPoint = 0
+	s.txPoints = s.txPoints[:len(s.txPoints)-1]
 }
 
-// Rollback to the last begin point, invalidates the snapshot if necessary
+// Rollback to where the innermost transaction began, invalidates the
+// snapshot if necessary
 func (s *DB) Rollback() {
-	if s.txPoint == 0 {
+	if !s.InTx() {
 		panic("rollback called before begin")
 	}
 
-	if s.Version > uint(s.txPoint) {
+	point := s.txPoints[len(s.txPoints)-1]
+	s.txPoints = s.txPoints[:len(s.txPoints)-1]
+
+	if s.Version > uint(point) {
 		s.ResetSnapshot()
 	}
+	if len(s.Log) > point {
+		// The clock counted the rolled back transactions
+		s.clock = nil
+	}
+
+	s.truncateLazy(point)
+	s.Log = s.Log[:point]
+}
 
-	s.Log = s.Log[:s.txPoint-1]
-	s.txPoint = 0
+// InTx checks if a transaction has begun and not been committed or rolled
+// back
+func (s *DB) InTx() bool {
+	return len(s.txPoints) != 0
 }
 
-// Do a transaction, if an error is returned by the lambda then
-// the transaction is rolled back.
-func (s *DB) Do(fn func() error) error {
+// Do a transaction, if an error is returned by the lambda (or it panics)
+// then the transaction is rolled back.
+func (s *DB) Do(fn func() error) (err error) {
 	s.Begin()
-	err := fn()
-	if err != nil {
+	defer func() {
+		if r := recover(); r != nil {
+			s.Rollback()
+			panic(r)
+		}
+	}()
+
+	if err = fn(); err != nil {
 		s.Rollback()
 	} else {
 		s.Commit()
@@ -197,20 +294,262 @@ func (s *DB) RollbackN(n uint) error {
 		s.ResetSnapshot()
 	}
 
+	s.truncateLazy(int(ln - n))
 	s.Log = s.Log[:ln-n]
 
 	return nil
 }
 
-// ResetSnapshot clears the current snapshot out of memory
+// Revert appends transactions that undo txs, which must still be in the log.
+// The keys they changed are put back to what they were before them and
+// entries they added are deleted. Entries they deleted are added again under
+// a new uuid since a deleted one can't come back (see Compact). Changes made
+// since to other keys are kept.
+//
+// It returns the transactions it appended, reverting those undoes the revert.
+func (s *DB) Revert(txs []Tx) ([]Tx, error) {
+	if s.InTx() {
+		return nil, errors.New("refusing to revert while transaction active")
+	}
+	if len(txs) == 0 {
+		return nil, nil
+	}
+
+	// Merges may have moved them so they're found by time like Merge does
+	want := make(map[int64]Tx, len(txs))
+	for _, tx := range txs {
+		want[tx.Time] = tx
+	}
+	first, found := -1, 0
+	for i, tx := range s.Log {
+		if w, ok := want[tx.Time]; ok && w == tx {
+			if first < 0 {
+				first = i
+			}
+			found++
+		}
+	}
+	if found != len(want) {
+		return nil, errors.New("the changes are no longer in the log")
+	}
+
+	type change struct {
+		deleted bool
+		keys    []string
+		seen    map[string]bool
+	}
+	var uuids []string
+	changes := make(map[string]*change)
+	for _, tx := range txs {
+		c, ok := changes[tx.UUID]
+		if !ok {
+			c = &change{seen: make(map[string]bool)}
+			changes[tx.UUID] = c
+			uuids = append(uuids, tx.UUID)
+		}
+
+		switch tx.Kind {
+		case TxDelete:
+			c.deleted = true
+		case TxSetKey, TxDeleteKey:
+			if !c.seen[tx.Key] {
+				c.seen[tx.Key] = true
+				c.keys = append(c.keys, tx.Key)
+			}
+		}
+	}
+
+	before := make(map[string]Entry)
+	for _, tx := range s.Log[:first] {
+		if _, ok := changes[tx.UUID]; !ok {
+			continue
+		}
+		if err := applyTx(before, tx); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.UpdateSnapshot(); err != nil {
+		return nil, err
+	}
+
+	start := len(s.Log)
+	for _, uuid := range uuids {
+		old, existed := before[uuid]
+		cur, exists := s.Snapshot[uuid]
+
+		switch {
+		case exists && !existed:
+			s.Delete(uuid)
+		case existed && !exists:
+			// It may have been deleted since by something else
+			if !changes[uuid].deleted {
+				continue
+			}
+
+			added, err := s.Add()
+			if err != nil {
+				s.Log = s.Log[:start]
+				return nil, err
+			}
+			keys := make([]string, 0, len(old))
+			for k := range old {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				s.Set(added, k, old[k])
+			}
+		case existed && exists:
+			for _, k := range changes[uuid].keys {
+				was, had := old[k]
+				is, has := cur[k]
+				switch {
+				case had && (!has || is != was):
+					s.Set(uuid, k, was)
+				case !had && has:
+					s.DeleteKey(uuid, k)
+				}
+			}
+		}
+	}
+
+	return append([]Tx(nil), s.Log[start:]...), nil
+}
+
+// Compact squashes the transactions in the log from before the unix
+// nanosecond timestamp into the fewest that result in the same snapshot and
+// still merge correctly: the add of every entry, the delete of the deleted
+// ones and the last set or delete of each of their keys. Overwritten values
+// and the keys of deleted entries disappear along with their history. The
+// deletes are kept so a merge with a copy that still has the history (or
+// changes made after it) sees them. The transactions that are kept are
+// untouched so they're still recognized by Merge.
+//
+// It returns how many transactions were removed.
+func (s *DB) Compact(before int64) (removed int, err error) {
+	if s.InTx() {
+		return 0, errors.New("refusing to compact while transaction active")
+	}
+
+	n := 0
+	for n < len(s.Log) && s.Log[n].Time < before {
+		n++
+	}
+
+	type entryTxs struct {
+		add, del int
+		keys     map[string]int
+	}
+	entries := make(map[string]*entryTxs)
+
+	for i, tx := range s.Log[:n] {
+		if tx.Kind == TxAdd {
+			if _, ok := entries[tx.UUID]; ok {
+				return 0, fmt.Errorf("%s already exists in snapshot", tx.UUID)
+			}
+			entries[tx.UUID] = &entryTxs{add: i, del: -1, keys: make(map[string]int)}
+			continue
+		}
+
+		entry, ok := entries[tx.UUID]
+		if !ok || entry.del >= 0 {
+			return 0, fmt.Errorf("%s was not in the snapshot", tx.UUID)
+		}
+
+		switch tx.Kind {
+		case TxDelete:
+			entry.del = i
+		case TxSetKey, TxDeleteKey:
+			entry.keys[tx.Key] = i
+		}
+	}
+
+	keep := make([]bool, n)
+	for _, entry := range entries {
+		keep[entry.add] = true
+		if entry.del >= 0 {
+			keep[entry.del] = true
+			continue
+		}
+		for _, i := range entry.keys {
+			keep[i] = true
+		}
+	}
+
+	log := make([]Tx, 0, len(s.Log))
+	for i, tx := range s.Log[:n] {
+		if keep[i] {
+			log = append(log, tx)
+		}
+	}
+	log = append(log, s.Log[n:]...)
+
+	removed = len(s.Log) - len(log)
+	if removed == 0 {
+		return 0, nil
+	}
+
+	// The end result is the same so an up to date snapshot still is, but
+	// the transactions moved
+	upToDate := (s.Snapshot != nil || s.pending != nil) && s.Version == uint(len(s.Log))
+	s.Log = log
+	s.resetLazy()
+	if upToDate {
+		s.Version = uint(len(log))
+		return removed, nil
+	}
+
+	s.ResetSnapshot()
+	return removed, s.UpdateSnapshot()
+}
+
+// ReplaceLog replaces the log, with a merged one for example. When the
+// transactions the snapshot was built from are still the start of the log
+// only the new ones are applied to it, otherwise it's rebuilt. The same goes
+// for what was materialized without the snapshot (see Entry).
+func (s *DB) ReplaceLog(log []Tx) error {
+	if s.InTx() {
+		return errors.New("refusing to replace the log while transaction active")
+	}
+
+	applied := int(s.Version)
+	if applied > len(s.Log) || applied > len(log) {
+		s.ResetSnapshot()
+	} else {
+		for i := 0; i < applied; i++ {
+			if s.Log[i] != log[i] {
+				s.ResetSnapshot()
+				break
+			}
+		}
+	}
+
+	if s.lazy != nil && !hasPrefix(log, s.Log[:s.lazy.scanned]) {
+		s.resetLazy()
+	}
+
+	s.Log = log
+	s.clock = nil
+	return s.UpdateSnapshot()
+}
+
+// ResetSnapshot clears the current snapshot out of memory, and what was
+// materialized without it
 func (s *DB) ResetSnapshot() {
 	s.Version = 0
 	s.Snapshot = nil
+	s.pending = nil
+	s.resetLazy()
 }
 
 // UpdateSnapshot applies all outstanding transactions in the log to the
-// snapshot.
+// snapshot. Version is how many have been applied already so only the ones
+// added since are, changes to the log that aren't appends must reset the
+// snapshot (see ReplaceLog). A snapshot that was loaded is decoded first.
 func (s *DB) UpdateSnapshot() error {
+	if err := s.decodePending(); err != nil {
+		return err
+	}
 	if s.Version >= uint(len(s.Log)) {
 		return nil
 	}
@@ -220,7 +559,8 @@ func (s *DB) UpdateSnapshot() error {
 	}
 
 	for ; s.Version < uint(len(s.Log)); s.Version++ {
-		if err := applyTx(s.Snapshot, s.Log[s.Version]); err != nil {
+		tx := s.Log[s.Version]
+		if err := applyTx(s.Snapshot, tx); err != nil {
 			return err
 		}
 	}
@@ -228,6 +568,21 @@ func (s *DB) UpdateSnapshot() error {
 	return nil
 }
 
+// decodePending decodes the snapshot that was loaded, if it hasn't been
+func (s *DB) decodePending() error {
+	if s.pending == nil {
+		return nil
+	}
+
+	snap, err := decodeSnapshot(s.pending)
+	if err != nil {
+		return err
+	}
+	s.Snapshot = snap
+	s.pending = nil
+	return nil
+}
+
 // SnapshotAt creates a new snapshot of a particular entry versionsAgo
 // in the past.
 func (s *DB) SnapshotAt(versionsAgo int) (map[string]Entry, error) {
@@ -294,6 +649,54 @@ func (s *DB) NVersions(uuid string) (versions int) {
 	return versions
 }
 
+// EntryHistory returns the transactions that changed an entry, oldest first.
+// The last one is the current version so the i'th of n is what
+// EntrySnapshotAt calls n-1-i versions ago.
+func (s *DB) EntryHistory(uuid string) []Tx {
+	var history []Tx
+	for _, tx := range s.Log {
+		if tx.UUID == uuid {
+			history = append(history, tx)
+		}
+	}
+
+	return history
+}
+
+// KeyDiff is how a key differs between two versions of an entry
+type KeyDiff struct {
+	Key string
+	// Old and New are the values in each version, Added keys have no Old
+	// and Removed keys have no New
+	Old     string
+	New     string
+	Added   bool
+	Removed bool
+}
+
+// DiffEntries returns the keys that differ from a to b sorted by key, either
+// may be nil for an entry that didn't exist (yet)
+func DiffEntries(a, b Entry) []KeyDiff {
+	var diffs []KeyDiff
+	for k, old := range a {
+		val, ok := b[k]
+		switch {
+		case !ok:
+			diffs = append(diffs, KeyDiff{Key: k, Old: old, Removed: true})
+		case val != old:
+			diffs = append(diffs, KeyDiff{Key: k, Old: old, New: val})
+		}
+	}
+	for k, val := range b {
+		if _, ok := a[k]; !ok {
+			diffs = append(diffs, KeyDiff{Key: k, New: val, Added: true})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Key < diffs[j].Key })
+	return diffs
+}
+
 // LastUpdated returns the unix nanosecond timestamp for when the entry was
 // updated last. Will be -1 if the entry is not found.
 func (s *DB) LastUpdated(uuid string) (last int64) {
@@ -309,6 +712,33 @@ func (s *DB) LastUpdated(uuid string) (last int64) {
 	return last
 }
 
+// Diverge reports how many transactions are in a but not in b (ahead) and
+// how many are in b but not in a (behind). Transactions are identified by
+// their timestamp as they are in Merge.
+func Diverge(a, b []Tx) (ahead, behind int) {
+	inA := make(map[int64]struct{}, len(a))
+	for _, tx := range a {
+		inA[tx.Time] = struct{}{}
+	}
+	inB := make(map[int64]struct{}, len(b))
+	for _, tx := range b {
+		inB[tx.Time] = struct{}{}
+	}
+
+	for t := range inA {
+		if _, ok := inB[t]; !ok {
+			ahead++
+		}
+	}
+	for t := range inB {
+		if _, ok := inA[t]; !ok {
+			behind++
+		}
+	}
+
+	return ahead, behind
+}
+
 // Merge logs together. The standard case for merging is that the logs proceed
 // in order with the same uuids.
 //
@@ -322,7 +752,10 @@ func (s *DB) LastUpdated(uuid string) (last int64) {
 //
 // The only conflicting situation is where an event occurs on an item after
 // it has been deleted. In this case the conflicts are returned and must
-// be resolved and passed back into this method for it to complete.
+// be resolved and passed back into this method for it to complete. When the
+// transactions have clocks (see Clock) an event that was made concurrently
+// with the delete is a conflict too even if its time is before the delete's
+// (the clocks of the devices disagreed).
 //
 // If conflicts have not been resolved the same set of conflicts will simply
 // be returned.
@@ -353,6 +786,8 @@ func Merge(a, b []Tx, resolved []Conflict) (c []Tx, conflicts []Conflict) {
 
 	c = make([]Tx, 0, most)
 	deleted := make(map[string]int)
+	// fork is where a and b differ, only events after it can be concurrent
+	fork := -1
 
 	// CheckConflict checks the last thing that was appended to c to see
 	// if there's a conflict with having added that event.
@@ -385,15 +820,33 @@ func Merge(a, b []Tx, resolved []Conflict) (c []Tx, conflicts []Conflict) {
 					c = c[:last]
 					return
 				}
+				if res.resolution == resolveDiscardConflict {
+					deleted[c[last].UUID] = last
+					return
+				}
 			}
 
 			deleted[c[last].UUID] = last
+
+			// Events from the other side before the delete that it didn't
+			// know of are lost to it
+			for k := fork; k < last; k++ {
+				if c[k].UUID == c[last].UUID && c[k].Kind != TxDelete && txsConcurrent(c[k], c[last]) {
+					conflicts = append(conflicts, Conflict{
+						Kind:     ConflictKindDeleteSet,
+						Initial:  c[last],
+						Conflict: c[k],
+					})
+					break
+				}
+			}
 			return
 		}
 
 		// We've previously been deleted and have found an add/set operation of
 		// some kind, this is a conflict.
 		deleteTx := c[ind]
+
 		// Check if its resolved
 		for _, res := range resolved {
 			if res.Initial.Time == deleteTx.Time {
@@ -442,6 +895,10 @@ func Merge(a, b []Tx, resolved []Conflict) (c []Tx, conflicts []Conflict) {
 		}
 
 		// We've forked.
+		if fork < 0 {
+			fork = len(c)
+		}
+
 		// If the fork happens and we have not moved either i or j
 		// that means that there is no common ancestry and this is likely a
 		// mistake to be syncing these. Create a conflict. This will always
@@ -470,6 +927,9 @@ func Merge(a, b []Tx, resolved []Conflict) (c []Tx, conflicts []Conflict) {
 	}
 
 	// Append the rest of the events
+	if fork < 0 {
+		fork = len(c)
+	}
 	for ; i < lena; i++ {
 		c = append(c, a[i])
 		checkConflict()
@@ -457,6 +457,30 @@ func TestMerge(t *testing.T) {
 	})
 }
 
+func TestDiverge(t *testing.T) {
+	t.Parallel()
+
+	logA := []Tx{
+		{Time: 1, Kind: TxAdd, UUID: "1"},
+		{Time: 2, Kind: TxAdd, UUID: "2"},
+		{Time: 4, Kind: TxAdd, UUID: "4"},
+	}
+	logB := []Tx{
+		{Time: 1, Kind: TxAdd, UUID: "1"},
+		{Time: 3, Kind: TxAdd, UUID: "3"},
+	}
+
+	if ahead, behind := Diverge(logA, logA); ahead != 0 || behind != 0 {
+		t.Error("same logs should not diverge:", ahead, behind)
+	}
+	if ahead, behind := Diverge(logA, logA[:1]); ahead != 2 || behind != 0 {
+		t.Error("should be ahead by 2:", ahead, behind)
+	}
+	if ahead, behind := Diverge(logA, logB); ahead != 2 || behind != 1 {
+		t.Error("should be ahead by 2 and behind by 1:", ahead, behind)
+	}
+}
+
 func TestTransactions(t *testing.T) {
 	t.Parallel()
 
@@ -491,7 +515,7 @@ func TestTransactions(t *testing.T) {
 	if len(store.Log) != 3 {
 		t.Error("should have 3 txs")
 	}
-	if store.txPoint != 0 {
+	if store.InTx() {
 		t.Error("transaction should be ended")
 	}
 }
@@ -541,6 +565,69 @@ func TestTransactionsDo(t *testing.T) {
 	}
 }
 
+func TestTransactionsNested(t *testing.T) {
+	t.Parallel()
+
+	store := new(DB)
+	store.SetDevice("a", nil)
+
+	var first string
+	err := store.Do(func() error {
+		var err error
+		first, err = store.Add()
+		must(t, err)
+
+		// A failed inner transaction only undoes itself
+		err = store.Do(func() error {
+			_, err = store.Add()
+			must(t, err)
+			return errors.New("fail")
+		})
+		if err == nil || len(store.Log) != 1 {
+			t.Error("the inner transaction should be rolled back:", err, len(store.Log))
+		}
+
+		err = store.Do(func() error {
+			_, err = store.Add()
+			return err
+		})
+		must(t, err)
+		if !store.InTx() {
+			t.Error("the outer transaction should still be open")
+		}
+
+		return errors.New("fail")
+	})
+	if err == nil {
+		t.Error("should have gotten an error")
+	}
+	if len(store.Log) != 0 || store.InTx() {
+		t.Error("committed inner transactions should be rolled back with the outer one:", len(store.Log))
+	}
+
+	// The clock doesn't count what was rolled back
+	_, err = store.Add()
+	must(t, err)
+	if store.Log[0].Clock != "a:1" {
+		t.Error("wrong clock:", store.Log[0].Clock)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the panic should be passed on")
+			}
+		}()
+		_ = store.Do(func() error {
+			store.Set(first, "key", "value")
+			panic("oops")
+		})
+	}()
+	if len(store.Log) != 1 || store.InTx() {
+		t.Error("a panic should roll the transaction back:", len(store.Log))
+	}
+}
+
 func TestRollbackN(t *testing.T) {
 	t.Parallel()
 
@@ -584,6 +671,207 @@ func TestRollbackN(t *testing.T) {
 	}
 }
 
+func TestCompact(t *testing.T) {
+	t.Parallel()
+
+	// Build the log by hand so the timestamps are known
+	log := []Tx{
+		{Time: 1, Kind: TxAdd, UUID: "root"},
+		{Time: 2, Kind: TxSetKey, UUID: "root", Key: "a", Value: "1"},
+		{Time: 3, Kind: TxAdd, UUID: "keep"},
+		{Time: 4, Kind: TxSetKey, UUID: "keep", Key: "a", Value: "1"},
+		{Time: 5, Kind: TxSetKey, UUID: "keep", Key: "a", Value: "2"},
+		{Time: 6, Kind: TxSetKey, UUID: "keep", Key: "b", Value: "1"},
+		{Time: 7, Kind: TxDeleteKey, UUID: "keep", Key: "b"},
+		{Time: 8, Kind: TxAdd, UUID: "gone"},
+		{Time: 9, Kind: TxSetKey, UUID: "gone", Key: "a", Value: "1"},
+		{Time: 10, Kind: TxDelete, UUID: "gone"},
+		{Time: 11, Kind: TxDelete, UUID: "root"},
+		{Time: 12, Kind: TxSetKey, UUID: "keep", Key: "a", Value: "3"},
+		{Time: 13, Kind: TxSetKey, UUID: "keep", Key: "c", Value: "1"},
+	}
+
+	store := &DB{Log: append([]Tx{}, log...)}
+	must(t, store.UpdateSnapshot())
+	want := store.Snapshot
+
+	// Everything after 11 is history that's kept
+	removed, err := store.Compact(12)
+	must(t, err)
+
+	wantLog := []Tx{log[0], log[2], log[4], log[6], log[7], log[9], log[10], log[11], log[12]}
+	if !reflect.DeepEqual(store.Log, wantLog) {
+		t.Errorf("log was wrong:\n%#v", store.Log)
+	}
+	if removed != len(log)-len(wantLog) {
+		t.Error("removed was wrong:", removed)
+	}
+	if !reflect.DeepEqual(store.Snapshot, want) {
+		t.Errorf("snapshot changed: %#v", store.Snapshot)
+	}
+
+	// Compacting everything squashes the history too
+	removed, err = store.Compact(100)
+	must(t, err)
+	if removed != 1 || len(store.Log) != 8 {
+		t.Errorf("removed %d, log: %#v", removed, store.Log)
+	}
+	if !reflect.DeepEqual(store.Snapshot, want) {
+		t.Errorf("snapshot changed: %#v", store.Snapshot)
+	}
+
+	// The compacted log still merges with the original
+	merged, conflicts := Merge(store.Log, log, nil)
+	if len(conflicts) != 0 {
+		t.Fatal("merging had conflicts:", conflicts)
+	}
+	mergedStore := &DB{Log: merged}
+	must(t, mergedStore.UpdateSnapshot())
+	if !reflect.DeepEqual(mergedStore.Snapshot, want) {
+		t.Errorf("merged snapshot was wrong: %#v", mergedStore.Snapshot)
+	}
+
+	if removed, err = new(DB).Compact(100); err != nil || removed != 0 {
+		t.Error("an empty log has nothing to compact:", removed, err)
+	}
+
+	store.Begin()
+	if _, err = store.Compact(100); err == nil {
+		t.Error("expected an error during a transaction")
+	}
+	store.Rollback()
+
+	// Deletes are kept so changes made elsewhere don't bring entries back
+	// without a conflict
+	elsewhere := append(append([]Tx{}, log[:9]...), Tx{Time: 14, Kind: TxSetKey, UUID: "gone", Key: "b", Value: "1"})
+	if _, conflicts = Merge(store.Log, elsewhere, nil); len(conflicts) != 1 || conflicts[0].Initial != log[9] {
+		t.Errorf("want a conflict with the delete, got: %#v", conflicts)
+	}
+
+	bad := &DB{Log: []Tx{{Time: 1, Kind: TxSetKey, UUID: "nope", Key: "a"}}}
+	if _, err = bad.Compact(100); err == nil {
+		t.Error("expected an error for a tx on a missing entry")
+	}
+}
+
+func TestRevert(t *testing.T) {
+	t.Parallel()
+
+	store := new(DB)
+	uuid, err := store.Add()
+	must(t, err)
+	store.Set(uuid, "a", "1")
+	store.Set(uuid, "b", "1")
+
+	// Overwrite a, add c and then change b separately which must survive
+	n := len(store.Log)
+	store.Set(uuid, "a", "2")
+	store.Set(uuid, "c", "2")
+	change := append([]Tx{}, store.Log[n:]...)
+	store.Set(uuid, "b", "3")
+
+	reverted, err := store.Revert(change)
+	must(t, err)
+	must(t, store.UpdateSnapshot())
+	want := Entry{"a": "1", "b": "3"}
+	if !reflect.DeepEqual(store.Snapshot[uuid], want) {
+		t.Errorf("want: %v, got: %v", want, store.Snapshot[uuid])
+	}
+
+	// Reverting the revert redoes the change
+	_, err = store.Revert(reverted)
+	must(t, err)
+	must(t, store.UpdateSnapshot())
+	want = Entry{"a": "2", "b": "3", "c": "2"}
+	if !reflect.DeepEqual(store.Snapshot[uuid], want) {
+		t.Errorf("want: %v, got: %v", want, store.Snapshot[uuid])
+	}
+
+	// A deleted entry comes back under a new uuid
+	n = len(store.Log)
+	store.Delete(uuid)
+	reverted, err = store.Revert(store.Log[n:])
+	must(t, err)
+	must(t, store.UpdateSnapshot())
+	if len(store.Snapshot) != 1 {
+		t.Fatal("the entry should be back:", store.Snapshot)
+	}
+	for added, entry := range store.Snapshot {
+		if added == uuid {
+			t.Error("the entry should have a new uuid")
+		}
+		if !reflect.DeepEqual(entry, want) {
+			t.Errorf("want: %v, got: %v", want, entry)
+		}
+	}
+
+	// And redoing the delete deletes it again
+	_, err = store.Revert(reverted)
+	must(t, err)
+	must(t, store.UpdateSnapshot())
+	if len(store.Snapshot) != 0 {
+		t.Error("the entry should be deleted:", store.Snapshot)
+	}
+
+	if _, err = store.Revert([]Tx{{Time: 1, Kind: TxAdd, UUID: "nope"}}); err == nil {
+		t.Error("expected an error for changes that are not in the log")
+	}
+}
+
+func TestReplaceLog(t *testing.T) {
+	t.Parallel()
+
+	store := new(DB)
+	uuid, err := store.Add()
+	must(t, err)
+	store.Set(uuid, "a", "1")
+	must(t, store.UpdateSnapshot())
+
+	// A marker that only survives if the snapshot isn't rebuilt
+	store.Snapshot[uuid]["kept"] = "yes"
+
+	appended := append(append([]Tx{}, store.Log...), Tx{Time: store.Log[1].Time + 1, Kind: TxSetKey, UUID: uuid, Key: "b", Value: "2"})
+	must(t, store.ReplaceLog(appended))
+	if store.Snapshot[uuid]["kept"] != "yes" {
+		t.Error("the snapshot should have been kept")
+	}
+	if store.Snapshot[uuid]["b"] != "2" || store.Version != 3 {
+		t.Error("the new transaction was not applied")
+	}
+
+	changed := append([]Tx{}, appended...)
+	changed[1].Value = "changed"
+	must(t, store.ReplaceLog(changed))
+	if _, ok := store.Snapshot[uuid]["kept"]; ok {
+		t.Error("the snapshot should have been rebuilt")
+	}
+	if store.Snapshot[uuid]["a"] != "changed" {
+		t.Error("the snapshot was wrong:", store.Snapshot[uuid])
+	}
+
+	must(t, store.ReplaceLog(changed[:1]))
+	if _, ok := store.Snapshot[uuid]["a"]; ok || store.Version != 1 {
+		t.Error("a shorter log should rebuild the snapshot:", store.Snapshot[uuid])
+	}
+
+	store.Begin()
+	if err = store.ReplaceLog(appended); err == nil {
+		t.Error("expected an error during a transaction")
+	}
+	store.Rollback()
+
+	// Compacting keeps an up to date snapshot too
+	must(t, store.ReplaceLog(appended))
+	store.Set(uuid, "a", "3")
+	must(t, store.UpdateSnapshot())
+	store.Snapshot[uuid]["kept"] = "yes"
+	removed, err := store.Compact(store.Log[len(store.Log)-1].Time + 1)
+	must(t, err)
+	if removed != 1 || store.Version != uint(len(store.Log)) || store.Snapshot[uuid]["kept"] != "yes" {
+		t.Error("compacting should keep the snapshot:", removed, store.Version, store.Snapshot[uuid])
+	}
+}
+
 func TestNVersions(t *testing.T) {
 	t.Parallel()
 
@@ -614,6 +902,63 @@ func TestNVersions(t *testing.T) {
 	}
 }
 
+func TestEntryHistory(t *testing.T) {
+	t.Parallel()
+
+	store := new(DB)
+
+	uuid, err := store.Add()
+	must(t, err)
+	other, err := store.Add()
+	must(t, err)
+
+	store.Set(uuid, "test1", "value")
+	store.Set(other, "test1", "value")
+	store.DeleteKey(uuid, "test1")
+
+	history := store.EntryHistory(uuid)
+	if len(history) != store.NVersions(uuid) {
+		t.Fatalf("history should have every version, got: %d", len(history))
+	}
+
+	kinds := []TxKind{TxAdd, TxSetKey, TxDeleteKey}
+	for i, tx := range history {
+		if tx.UUID != uuid {
+			t.Errorf("%d) tx is for the wrong entry", i)
+		}
+		if tx.Kind != kinds[i] {
+			t.Errorf("%d) want: %s, got: %s", i, kinds[i], tx.Kind)
+		}
+	}
+
+	if h := store.EntryHistory("nope"); len(h) != 0 {
+		t.Error("an unknown entry should have no history:", h)
+	}
+}
+
+func TestDiffEntries(t *testing.T) {
+	t.Parallel()
+
+	a := Entry{"same": "1", "changed": "old", "removed": "gone"}
+	b := Entry{"same": "1", "changed": "new", "added": "here"}
+
+	want := []KeyDiff{
+		{Key: "added", New: "here", Added: true},
+		{Key: "changed", Old: "old", New: "new"},
+		{Key: "removed", Old: "gone", Removed: true},
+	}
+	if got := DiffEntries(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %#v\ngot:  %#v", want, got)
+	}
+
+	if got := DiffEntries(a, a); len(got) != 0 {
+		t.Error("an entry should not differ from itself:", got)
+	}
+	if got := DiffEntries(nil, Entry{"k": "v"}); len(got) != 1 || !got[0].Added {
+		t.Error("everything should be added to a nil entry:", got)
+	}
+}
+
 func TestLastUpdated(t *testing.T) {
 	t.Parallel()
 
@@ -1,25 +1,53 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"io"
+	"sync"
+	"time"
 
 	"github.com/aarondl/bpass/blobformat"
 	"github.com/aarondl/bpass/crypt"
+	"github.com/aarondl/bpass/secmem"
+	"github.com/aarondl/bpass/txlogs"
 )
 
 type uiContext struct {
+	// The lock is held while the repl is running a command or a background
+	// sync is in progress
+	sync.Mutex
+
 	// Input
 	in LineEditor
 	// Output
 	out io.Writer
+	// format is the format ls, show and get write in unless they're given
+	// one, "" is text (see parseFormat)
+	format string
 
 	created  bool
 	readOnly bool
 	startTx  int
 
+	// headless is set when we're doing work in the background and must not
+	// prompt the user for anything
+	headless bool
+	autoSync *autoSyncer
+	// bgSync is held while a background sync runs. Tab completion reads
+	// the store between commands so it takes this to wait for the sync, it
+	// can't take the lock above since commands hold that while they prompt.
+	bgSync sync.Mutex
+
+	// askHost is held while asking the user about an unknown host key so
+	// concurrent syncs don't talk over each other
+	askHost sync.Mutex
+	// sshConns is set while syncing so hosts are only dialed once
+	sshConns *sshConnCache
+
 	filename      string
 	shortFilename string
 
@@ -33,15 +61,143 @@ type uiContext struct {
 	// These encryption params that come out of decrypt()
 	// are saved. We need these to tell if we're a multi-user file
 	// as well as provide fast-path decryption for sync'd copies.
-	key, salt, master, ivm []byte
+	// The keys are kept in locked memory, see setKey and setMaster.
+	key, master *secmem.Buffer
+	salt, ivm   []byte
+
+	// derived keys are remembered for the session so that sync'd copies
+	// that were re-keyed elsewhere only cost a key derivation once
+	derived map[[sha256.Size]byte]*secmem.Buffer
+
+	// factorResponses are second factor responses remembered for the
+	// session so tokens only have to be touched once for each key,
+	// factorLock is held while asking for one
+	factorLock      sync.Mutex
+	factorResponses map[string]*secmem.Buffer
+	// fido2Device is the authenticator picked for the session
+	fido2Device string
+	// keyfile is the path of the keyfile for the session
+	keyfile string
+
+	// keyFromCache is set when the file was opened with a cached key, pass
+	// is unknown until currentPass asks for it. keyExpires is when the
+	// cached key expires.
+	keyFromCache bool
+	keyExpires   time.Time
+	// tpmEnroll is the secret from an enrollment code, it's sealed to this
+	// device's tpm once it has opened the file
+	tpmEnroll []byte
+
+	// slot and otherSlot are from files with slots (see crypt.SlotVersion),
+	// the other slot is written back verbatim when saving in slotVersion
+	slot        int
+	otherSlot   []byte
+	slotVersion int
+	// payloadKey is what their payloads are encrypted with, see crypt.Rewrap
+	payloadKey *secmem.Buffer
+
+	// onDisk is the file as it was last read or written, savedTx and
+	// savedLast are the length and last transaction of the log then. If the
+	// log is the same when saving only the payload key is rewrapped.
+	onDisk    []byte
+	savedTx   int
+	savedLast txlogs.Tx
+
+	// undoSteps are the changes made by the last commands that can be
+	// undone, newest last, and redoSteps the ones that were undone
+	undoSteps []undoStep
+	redoSteps []undoStep
+
+	// recent are the entries that were used last, nil when they're not kept
+	recent *recentEntries
+}
+
+// setKey keeps a copy of key in locked memory, the old key is wiped
+func (u *uiContext) setKey(key []byte) {
+	old := u.key
+	u.key = secmem.Copy(key)
+	old.Destroy()
+}
+
+// setMaster keeps a copy of master in locked memory, the old master key is
+// wiped
+func (u *uiContext) setMaster(master []byte) {
+	old := u.master
+	u.master = secmem.Copy(master)
+	old.Destroy()
+}
+
+// wipeSecrets wipes the keys when the file is closed
+func (u *uiContext) wipeSecrets() {
+	u.key.Destroy()
+	u.master.Destroy()
+	u.payloadKey.Destroy()
+	for id, key := range u.derived {
+		key.Destroy()
+		delete(u.derived, id)
+	}
+
+	u.factorLock.Lock()
+	for id, response := range u.factorResponses {
+		response.Destroy()
+		delete(u.factorResponses, id)
+	}
+	u.factorLock.Unlock()
+}
+
+// derivedKey looks up a previously derived key for the passphrase and salt
+func (u *uiContext) derivedKey(pass string, salt []byte) []byte {
+	return u.derived[derivedKeyID(pass, salt)].Bytes()
+}
+
+// rememberKey saves a derived key for the passphrase and salt
+func (u *uiContext) rememberKey(pass string, salt, key []byte) {
+	if len(salt) == 0 || len(key) == 0 {
+		return
+	}
+	if u.derived == nil {
+		u.derived = make(map[[sha256.Size]byte]*secmem.Buffer)
+	}
+
+	id := derivedKeyID(pass, salt)
+	u.derived[id].Destroy()
+	u.derived[id] = secmem.Copy(key)
+}
+
+// derivedKeyMAC keys the ids of derived keys, it's random for each run so
+// that an id can't be used to check guesses at the passphrase
+var derivedKeyMAC = newDerivedKeyMAC()
+
+func newDerivedKeyMAC() []byte {
+	b := make([]byte, sha256.Size)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// derivedKeyID is the HMAC of the passphrase and salt under derivedKeyMAC
+func derivedKeyID(pass string, salt []byte) [sha256.Size]byte {
+	h := hmac.New(sha256.New, derivedKeyMAC)
+	_, _ = h.Write([]byte(pass))
+	_, _ = h.Write(salt)
+
+	var id [sha256.Size]byte
+	copy(id[:], h.Sum(nil))
+	return id
 }
 
 func (u *uiContext) makeParams() (*crypt.Params, error) {
-	if len(u.master) == 0 {
-		return &crypt.Params{
-			Keys:  [][]byte{u.key},
+	if u.master.Len() == 0 {
+		p := &crypt.Params{
+			Keys:  [][]byte{u.key.Bytes()},
 			Salts: [][]byte{u.salt},
-		}, nil
+		}
+		// The other slot only fits in the version it came from
+		if u.slotVersion == u.fileVersion() {
+			p.Slot, p.Other, p.PayloadKey = u.slot, u.otherSlot, u.payloadKey.Bytes()
+		}
+		return p, nil
 	}
 
 	var p crypt.Params
@@ -80,7 +236,7 @@ func (u *uiContext) makeParams() (*crypt.Params, error) {
 
 		if u.user == name {
 			p.User = index
-			p.Keys = append(p.Keys, u.key)
+			p.Keys = append(p.Keys, u.key.Bytes())
 		} else {
 			p.Keys = append(p.Keys, nil)
 		}
@@ -93,7 +249,108 @@ func (u *uiContext) makeParams() (*crypt.Params, error) {
 	}
 
 	p.IVM = u.ivm
-	p.Master = u.master
+	p.Master = u.master.Bytes()
 
 	return &p, nil
 }
+
+// keyVersion is the crypt version the current key was derived for. Files
+// are saved with it so keys from older versions keep working until the
+// passphrase is changed.
+func (u *uiContext) keyVersion() int {
+	version, err := crypt.SaltVersion(u.salt)
+	if err != nil {
+		return cryptVersion
+	}
+
+	return version
+}
+
+// fileVersion is the crypt version the file is saved with, the cipher
+// setting picks between the versions the current key can be used with
+func (u *uiContext) fileVersion() int {
+	version := u.keyVersion()
+
+	cipher, err := u.store.Setting(blobformat.SettingCipher)
+	if err != nil || len(cipher) == 0 {
+		// Keep the second slot of a file whose setting went missing
+		if u.otherSlot == nil {
+			return version
+		}
+		cipher = crypt.CipherChaCha20Poly1305
+	}
+
+	v, err := crypt.CipherVersion(version, cipher)
+	if err != nil {
+		return version
+	}
+
+	// Single-user files always have a second slot when they can so that
+	// ones with a decoy (see duress) look the same as the rest
+	if u.master.Len() == 0 {
+		if slotVersion, err := crypt.SlotVersion(v); err == nil {
+			return slotVersion
+		}
+	}
+	return v
+}
+
+// keepSlot remembers the other slot and payload key of a file that was
+// decrypted or encrypted with version
+func (u *uiContext) keepSlot(version int, p crypt.Params) {
+	u.slot, u.otherSlot, u.slotVersion = p.Slot, p.Other, version
+
+	old := u.payloadKey
+	u.payloadKey = secmem.Copy(p.PayloadKey)
+	old.Destroy()
+}
+
+// markSaved remembers onDisk as the file for the log as it is now
+func (u *uiContext) markSaved(onDisk []byte) {
+	u.onDisk = onDisk
+	u.savedTx = len(u.store.DB.Log)
+	u.savedLast = txlogs.Tx{}
+	if u.savedTx != 0 {
+		u.savedLast = u.store.DB.Log[u.savedTx-1]
+	}
+}
+
+// logSaved checks if the log is the same as when the file was last read or
+// written
+func (u *uiContext) logSaved() bool {
+	log := u.store.DB.Log
+	if len(log) != u.savedTx {
+		return false
+	}
+	return len(log) == 0 || log[len(log)-1] == u.savedLast
+}
+
+// kdfParams reads the cost of deriving new keys from the file's settings
+func (u *uiContext) kdfParams() crypt.KDFParams {
+	if val, err := u.store.Setting(blobformat.SettingKDF); err == nil && len(val) != 0 {
+		if kdf, err := crypt.ParseKDFParams(val); err == nil {
+			return kdf
+		}
+	}
+
+	return crypt.DefaultKDFParams
+}
+
+// deriveKey derives a new key for pass that needs factor as well (or
+// instead if pass is empty). Single-user files are moved to the version of the kdf
+// setting, all users in a multi-user file must be the same version so they
+// stay at the current key's version (and params) until rekeyall.
+func (u *uiContext) deriveKey(pass string, factor crypt.Factor) (version int, key, salt []byte, err error) {
+	kdf := u.kdfParams()
+	version = kdf.Version()
+	if u.master.Len() != 0 && u.keyVersion() != version {
+		version = u.keyVersion()
+		if kdf, err = crypt.SaltKDFParams(u.salt); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+
+	kdf.Factor = factor
+	key, salt, err = crypt.DeriveKeyParams(version, []byte(pass), kdf)
+	return version, key, salt, err
+}
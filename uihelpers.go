@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
@@ -12,6 +13,10 @@ import (
 )
 
 func (u *uiContext) promptPassword(prompt string) (string, error) {
+	if u.headless {
+		return "", errHeadless
+	}
+
 	password, err := pinentry.Password(color.Clean(prompt))
 	if err == nil {
 		return password, nil
@@ -23,6 +28,10 @@ func (u *uiContext) promptPassword(prompt string) (string, error) {
 }
 
 func (u *uiContext) prompt(prompt string) (string, error) {
+	if u.headless {
+		return "", errHeadless
+	}
+
 	line, err := u.in.Line(prompt)
 	if err != nil {
 		return "", err
@@ -31,13 +40,23 @@ func (u *uiContext) prompt(prompt string) (string, error) {
 	return line, nil
 }
 
+// promptPasted is prompt for multi-line values, a paste keeps its line
+// breaks
+func (u *uiContext) promptPasted(prompt string) (string, error) {
+	if u.headless {
+		return "", errHeadless
+	}
+
+	return u.in.LineBlock(prompt)
+}
+
 func (u *uiContext) promptMultiline(prompt string) (string, error) {
 	infoColor.Println(`Enter text, 2 empty lines or "." or ctrl-d to stop:`)
 
 	var lines []string
 	oneBlank := false
 	for {
-		line, err := u.prompt(prompt)
+		line, err := u.promptPasted(prompt)
 		if err == ErrEnd || line == "." {
 			break
 		} else if err != nil {
@@ -59,12 +78,73 @@ func (u *uiContext) promptMultiline(prompt string) (string, error) {
 			oneBlank = false
 		}
 
+		// Pasted keys and certificates may contain blank lines, read
+		// them in their entirety so we don't stop halfway through
+		line, err = u.promptBlock(prompt, line)
+		if err != nil {
+			return "", err
+		}
+
 		lines = append(lines, line)
 	}
 
 	return strings.Join(lines, "\n"), nil
 }
 
+// promptBlock checks if first is the armor header of a pem style block
+// (private keys, certificates etc.) and if so keeps reading lines until the
+// block's end line is seen, returning the entire block. This is how we cope
+// with multi-line pastes since each line of a paste arrives as if it had been
+// typed and entered individually.
+//
+// Terminals that bracket pastes give us the whole paste as one line (see
+// pasteReader), a first with line breaks in it is the entire paste.
+//
+// If first is not the start of a block it's returned as is.
+func (u *uiContext) promptBlock(prompt, first string) (string, error) {
+	if strings.ContainsRune(first, '\n') {
+		return first, nil
+	}
+
+	label, ok := pemBegin(first)
+	if !ok {
+		return first, nil
+	}
+
+	end := "-----END " + label + "-----"
+	lines := []string{first}
+	for {
+		line, err := u.prompt(prompt)
+		if err == ErrEnd {
+			break
+		} else if err != nil {
+			return "", err
+		}
+
+		lines = append(lines, line)
+		if strings.TrimSpace(line) == end {
+			break
+		}
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// pemBegin returns the label from a line like: -----BEGIN LABEL-----
+func pemBegin(line string) (label string, ok bool) {
+	const begin, dashes = "-----BEGIN ", "-----"
+
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, begin) || !strings.HasSuffix(line, dashes) {
+		return "", false
+	}
+	if len(line) <= len(begin)+len(dashes) {
+		return "", false
+	}
+
+	return line[len(begin) : len(line)-len(dashes)], true
+}
+
 // findOne returns a uuid iff a single one could be found, else an error
 // message will have been printed to the user.
 func (u *uiContext) findOne(query string) (string, error) {
@@ -85,13 +165,15 @@ func (u *uiContext) findOne(query string) (string, error) {
 			infoColor.Printf("using: %s\n", name)
 		}
 
+		u.touchRecent(id)
 		return id, nil
 	}
 
 	// If there's an exact match use that
-	for u, name := range entries {
+	for id, name := range entries {
 		if name == query {
-			return u, nil
+			u.touchRecent(id)
+			return id, nil
 		}
 	}
 
@@ -122,6 +204,38 @@ func (u *uiContext) getYesNo(question string) (bool, error) {
 	}
 }
 
+// writeNewFile creates a file with mode and writes data to it. The file is
+// opened exclusively so data never goes through an existing file or link
+// that has a wider mode. If the file exists the user is asked before it's
+// replaced, false is returned if they say no.
+func (u *uiContext) writeNewFile(filename string, data []byte, mode os.FileMode) (bool, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	f, err := os.OpenFile(filename, flags, mode)
+	if os.IsExist(err) {
+		var overwrite bool
+		overwrite, err = u.getYesNo(fmt.Sprintf("%s exists, overwrite it?", filename))
+		if err != nil || !overwrite {
+			return false, err
+		}
+		if err = os.Remove(filename); err != nil {
+			return false, err
+		}
+		f, err = os.OpenFile(filename, flags, mode)
+		if err != nil {
+			return false, err
+		}
+	} else if err != nil {
+		return false, err
+	}
+
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return false, err
+	}
+
+	return true, f.Close()
+}
+
 // getString ensures a non-empty string
 func (u *uiContext) getString(key string) (string, error) {
 	var str string
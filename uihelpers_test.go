@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestWriteNewFile(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "bpass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	editor := &scriptedEditor{lines: []string{"n", "y"}}
+	u := &uiContext{in: editor, out: ioutil.Discard}
+	filename := filepath.Join(dir, "key")
+
+	checkFile := func(want string) {
+		t.Helper()
+
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("want: %q, got: %q", want, b)
+		}
+		if runtime.GOOS == "windows" {
+			return
+		}
+		info, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("mode was wrong: %v", info.Mode())
+		}
+	}
+
+	if wrote, err := u.writeNewFile(filename, []byte("one"), 0600); err != nil || !wrote {
+		t.Fatal("new file should be written:", err)
+	}
+	checkFile("one")
+
+	// An existing file with a wider mode is left alone when the user says no
+	// and replaced with the right mode when they say yes
+	if err = os.Chmod(filename, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if wrote, err := u.writeNewFile(filename, []byte("two"), 0600); err != nil || wrote {
+		t.Fatal("file should not be overwritten:", err)
+	}
+	if err = os.Chmod(filename, 0600); err != nil {
+		t.Fatal(err)
+	}
+	checkFile("one")
+	if err = os.Chmod(filename, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if wrote, err := u.writeNewFile(filename, []byte("three"), 0600); err != nil || !wrote {
+		t.Fatal("file should be overwritten:", err)
+	}
+	checkFile("three")
+}
+
+func TestPromptBlock(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name  string
+		First string
+		Lines []string
+		Want  string
+	}{
+		{"not a block", "hunter2", []string{"unread"}, "hunter2"},
+		{
+			"typed a line at a time",
+			"-----BEGIN CERTIFICATE-----",
+			[]string{"abc", "", "def", "-----END CERTIFICATE-----", "unread"},
+			"-----BEGIN CERTIFICATE-----\nabc\n\ndef\n-----END CERTIFICATE-----",
+		},
+		{
+			"bracketed paste",
+			pastedLine("-----BEGIN KEY-----" + pasteNewline + "abc" + pasteNewline + "-----END KEY-----"),
+			[]string{"unread"},
+			"-----BEGIN KEY-----\nabc\n-----END KEY-----",
+		},
+		{"pasted text", "a\n\nb", []string{"unread"}, "a\n\nb"},
+	}
+
+	for _, test := range tests {
+		editor := &scriptedEditor{lines: test.Lines}
+		u := &uiContext{in: editor, out: ioutil.Discard}
+
+		got, err := u.promptBlock("> ", test.First)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.Want {
+			t.Errorf("%s: want: %q, got: %q", test.Name, test.Want, got)
+		}
+		if len(editor.lines) != 1 {
+			t.Errorf("%s: the line after the block should not be read", test.Name)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/aarondl/bpass/txlogs"
+)
+
+// undoLimit is how many commands can be undone
+const undoLimit = 50
+
+// undoCount parses the optional count of undo and redo
+func undoCount(args []string) (n int, ok bool) {
+	if len(args) == 0 {
+		return 1, true
+	}
+
+	n, err := strconv.Atoi(args[0])
+	return n, err == nil && n > 0
+}
+
+// undoStep is the transactions a command appended to the log, line is the
+// command line so the user can tell what's being undone
+type undoStep struct {
+	line string
+	txs  []txlogs.Tx
+}
+
+// recordUndo remembers the changes a command made so they can be undone, a
+// new change means what was undone can't be redone anymore
+func (u *uiContext) recordUndo(line string, txs []txlogs.Tx) {
+	u.undoSteps = append(u.undoSteps, undoStep{
+		line: line,
+		txs:  append([]txlogs.Tx(nil), txs...),
+	})
+	if len(u.undoSteps) > undoLimit {
+		u.undoSteps = u.undoSteps[len(u.undoSteps)-undoLimit:]
+	}
+	u.redoSteps = nil
+}
+
+// undo reverts the changes of the last n commands
+func (u *uiContext) undo(n int) error {
+	return u.revertSteps(n, &u.undoSteps, &u.redoSteps, "undo")
+}
+
+// redo reverts the last n undos
+func (u *uiContext) redo(n int) error {
+	return u.revertSteps(n, &u.redoSteps, &u.undoSteps, "redo")
+}
+
+// revertSteps pops up to n steps off from and reverts them, what reverting
+// did is pushed onto to so it can be reverted in turn
+func (u *uiContext) revertSteps(n int, from, to *[]undoStep, verb string) error {
+	if len(*from) == 0 {
+		infoColor.Printf("nothing to %s\n", verb)
+		return nil
+	}
+
+	for ; n > 0 && len(*from) != 0; n-- {
+		step := (*from)[len(*from)-1]
+		*from = (*from)[:len(*from)-1]
+
+		reverted, err := u.store.Revert(step.txs)
+		if err != nil {
+			// Compacting removes the oldest changes first so the
+			// steps before this one can't be reverted either
+			*from = nil
+			errColor.Printf("cannot %s %q: %v\n", verb, step.line, err)
+			return nil
+		}
+
+		*to = append(*to, undoStep{line: step.line, txs: reverted})
+		infoColor.Printf("%s: %s\n", verb, step.line)
+	}
+
+	// Entries that come back may have had their names taken since
+	renames, err := u.store.RenameDuplicates()
+	if err != nil {
+		return err
+	}
+	for old, name := range renames {
+		infoColor.Printf("renamed %s to %s\n", old, name)
+	}
+
+	return nil
+}
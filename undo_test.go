@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestUndo(t *testing.T) {
+	t.Parallel()
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}}
+	uuid, err := u.store.New("entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.store.Set(uuid, "a", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	set := func(value string) {
+		t.Helper()
+		r := &repl{ctx: u, line: "set entry a " + value}
+		fn := undoMiddleware(replCmd{Undoable: true}, func(r *repl, cmd string, args []string) error {
+			return u.store.Set(uuid, "a", value)
+		})
+		if err := fn(r, "set", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func() string {
+		t.Helper()
+		blob, err := u.store.MustFind(uuid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return blob.Get("a")
+	}
+
+	set("2")
+	set("3")
+
+	if err = u.undo(2); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(); got != "1" {
+		t.Error("want: 1, got:", got)
+	}
+	if len(u.undoSteps) != 0 || len(u.redoSteps) != 2 {
+		t.Error("steps should have moved to redo:", len(u.undoSteps), len(u.redoSteps))
+	}
+
+	if err = u.redo(1); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(); got != "2" {
+		t.Error("want: 2, got:", got)
+	}
+
+	// A new change can't be followed by a redo
+	set("4")
+	if len(u.redoSteps) != 0 {
+		t.Error("redo should be cleared by a new change")
+	}
+	if err = u.undo(1); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(); got != "2" {
+		t.Error("want: 2, got:", got)
+	}
+}
+
+func TestUndoCount(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Args []string
+		N    int
+		OK   bool
+	}{
+		{nil, 1, true},
+		{[]string{"3"}, 3, true},
+		{[]string{"0"}, 0, false},
+		{[]string{"x"}, 0, false},
+	}
+
+	for i, test := range tests {
+		n, ok := undoCount(test.Args)
+		if ok != test.OK || (ok && n != test.N) {
+			t.Errorf("%d) want: %d %t, got: %d %t", i, test.N, test.OK, n, ok)
+		}
+	}
+}
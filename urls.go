@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aarondl/bpass/blobformat"
+)
+
+// urlToOpen is the url of an entry that open launches: url n (url is 1, url2
+// is 2 and so on) or when n is 0 the first of its urls that isn't a host
+// pattern
+func (u *uiContext) urlToOpen(blob blobformat.Blob, n int) (string, error) {
+	keys := blob.URLKeys()
+	if n > 0 {
+		key := blobformat.KeyURL
+		if n > 1 {
+			key += strconv.Itoa(n)
+		}
+		if _, ok := blob[key]; !ok {
+			return "", fmt.Errorf("%s is not set on %s", key, blob.Name())
+		}
+		keys = []string{key}
+	}
+	if len(keys) == 0 {
+		return "", fmt.Errorf("url not set on %s", blob.Name())
+	}
+
+	for _, key := range keys {
+		link, err := u.store.Resolve(blob, key)
+		if err != nil {
+			return "", err
+		}
+		if err = blobformat.FieldURL.Validate(link); err == nil {
+			return link, nil
+		} else if n > 0 {
+			return "", fmt.Errorf("%s of %s can't be opened: %v", key, blob.Name(), err)
+		}
+	}
+
+	return "", fmt.Errorf("the urls of %s are all host patterns, none can be opened", blob.Name())
+}
+
+// matchURL lists the entries with a url or host pattern that matches rawurl
+func (u *uiContext) matchURL(rawurl string) error {
+	results, err := u.store.SearchURL(rawurl)
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		errColor.Println("No entries found")
+		return nil
+	}
+
+	names := results.Names()
+	sort.Strings(names)
+	fmt.Fprintln(u.out, strings.Join(names, "\n"))
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestMatchURL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Pattern, URL string
+		Match        bool
+	}{
+		{"https://github.com", "https://github.com/aarondl/bpass", true},
+		{"https://github.com", "http://GitHub.com:8080", true},
+		{"github.com", "https://github.com/login", true},
+		{"https://github.com", "https://gist.github.com", false},
+		{"*.google.com", "https://google.com", true},
+		{"*.google.com", "https://mail.google.com/inbox", true},
+		{"*.google.com", "https://notgoogle.com", false},
+		{"login.example.*", "https://login.example.org/", true},
+		{"login.example.*", "https://www.example.org/", false},
+		{"https://user@example.com:8443/path", "example.com", true},
+		{"", "https://github.com", false},
+		{"github.com", "", false},
+	}
+
+	for i, test := range tests {
+		if got := blobformat.MatchURL(test.Pattern, test.URL); got != test.Match {
+			t.Errorf("%d) %q %q want: %t, got: %t", i, test.Pattern, test.URL, test.Match, got)
+		}
+	}
+}
+
+func TestURLs(t *testing.T) {
+	t.Parallel()
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: ioutil.Discard}
+	google, err := u.store.New("google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kv := range [][2]string{
+		{"url10", "https://youtube.com"},
+		{"url2", "*.google.com"},
+		{blobformat.KeyURL, "https://accounts.google.com"},
+	} {
+		if err = u.store.Set(google, kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = u.store.Set(google, "url3", "not a url"); !blobformat.IsInvalidValue(err) {
+		t.Error("want an invalid value error, got:", err)
+	}
+	if err = u.store.Set(google, blobformat.KeyURL, "*.gmail.com"); !blobformat.IsInvalidValue(err) {
+		t.Error("url itself can't be a pattern, got:", err)
+	}
+	if err = u.store.Set(google, "urls", "not a url"); err != nil {
+		t.Error("urls isn't a url key:", err)
+	}
+
+	blob, _ := u.store.MustFind(google)
+	if keys := blob.URLKeys(); !reflect.DeepEqual(keys, []string{"url", "url2", "url10"}) {
+		t.Error("url keys were wrong:", keys)
+	}
+
+	if link, err := u.urlToOpen(blob, 0); err != nil || link != "https://accounts.google.com" {
+		t.Error("wrong url to open:", link, err)
+	}
+	if link, err := u.urlToOpen(blob, 10); err != nil || link != "https://youtube.com" {
+		t.Error("wrong url to open:", link, err)
+	}
+	if _, err := u.urlToOpen(blob, 2); err == nil {
+		t.Error("patterns can't be opened")
+	}
+	if _, err := u.urlToOpen(blob, 4); err == nil {
+		t.Error("url4 is not set")
+	}
+
+	github, err := u.store.New("github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.store.Set(github, blobformat.KeyURL, "https://github.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	for url, want := range map[string][]string{
+		"https://mail.google.com":    {"google"},
+		"youtube.com":                {"google"},
+		"https://github.com/aarondl": {"github"},
+		"https://example.com":        nil,
+	} {
+		results, err := u.store.SearchURL(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names := results.Names(); !reflect.DeepEqual(names, want) {
+			t.Errorf("%s want: %v, got: %v", url, want, names)
+		}
+	}
+}
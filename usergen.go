@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+
+	"github.com/aarondl/bpass/blobformat"
+)
+
+// genFlag generates the value of user or email instead of typing it, in set
+// and at the prompts of add
+const genFlag = "--gen"
+
+// Words generated usernames are made of, an adjective and a noun followed by
+// two digits is 20 bits, enough that nobody guesses one from another site's
+var (
+	userAdjectives = []string{
+		"amber", "ancient", "arctic", "autumn", "bitter", "black", "bold", "brave",
+		"bright", "brisk", "broken", "calm", "clever", "cold", "cosmic", "crimson",
+		"curly", "damp", "dark", "dawn", "deep", "dry", "dusty", "eager",
+		"early", "empty", "fancy", "fast", "fierce", "floral", "foggy", "frosty",
+		"gentle", "giant", "golden", "green", "grumpy", "hidden", "hollow", "humble",
+		"icy", "idle", "jolly", "keen", "lazy", "little", "lively", "lone",
+		"lucky", "misty", "modest", "muddy", "noble", "odd", "orange", "pale",
+		"plain", "polite", "proud", "purple", "quiet", "rapid", "rough", "round",
+		"royal", "rusty", "shy", "silent", "silver", "sleepy", "slow", "small",
+		"snowy", "solar", "sour", "spare", "spicy", "steady", "stormy", "sunny",
+		"swift", "tall", "tame", "tidy", "tiny", "twin", "vast", "velvet",
+		"wandering", "warm", "white", "wild", "windy", "wise", "witty", "young",
+	}
+	userNouns = []string{
+		"badger", "banjo", "bear", "beetle", "bison", "boat", "breeze", "brook",
+		"cactus", "canyon", "cedar", "cloud", "comet", "coral", "crane", "creek",
+		"crow", "dawn", "delta", "dingo", "dove", "dune", "eagle", "ember",
+		"falcon", "fern", "field", "finch", "fjord", "flame", "forest", "fox",
+		"frog", "gecko", "glacier", "gull", "harbor", "hawk", "heron", "hill",
+		"island", "jaguar", "kettle", "koala", "lake", "lantern", "lark", "leaf",
+		"lemur", "lynx", "maple", "meadow", "moose", "moth", "newt", "oak",
+		"ocean", "orca", "otter", "owl", "panda", "pebble", "pine", "plume",
+		"pond", "quail", "rabbit", "raven", "reef", "ridge", "river", "robin",
+		"salmon", "shadow", "shell", "sparrow", "spruce", "star", "stone", "storm",
+		"summit", "swan", "thistle", "tiger", "trail", "tulip", "valley", "viper",
+		"walrus", "wave", "willow", "wolf", "wren", "yak", "zebra", "zephyr",
+	}
+)
+
+// genUsername makes a username out of a random word pair and two digits,
+// eg. brisk_otter42
+func genUsername() (string, error) {
+	adjective, err := randomIndex(len(userAdjectives))
+	if err != nil {
+		return "", err
+	}
+	noun, err := randomIndex(len(userNouns))
+	if err != nil {
+		return "", err
+	}
+	digits, err := randomIndex(100)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s_%s%02d", userAdjectives[adjective], userNouns[noun], digits), nil
+}
+
+// genAlias plus-addresses the base email with a tag for the entry so every
+// site gets its own address that's delivered to the same inbox, eg.
+// me+github@example.com. The tag is the last part of the entry's name,
+// a random word when nothing of it can be used.
+func genAlias(base, entry string) (string, error) {
+	at := strings.LastIndexByte(base, '@')
+	if at < 1 || at == len(base)-1 {
+		return "", fmt.Errorf("%q is not an email address", base)
+	}
+	local, domain := base[:at], base[at+1:]
+	// A base that's already an alias is aliased by its account
+	if plus := strings.IndexByte(local, '+'); plus > 0 {
+		local = local[:plus]
+	}
+
+	tag := aliasTag(entry)
+	if len(tag) == 0 {
+		i, err := randomIndex(len(userNouns))
+		if err != nil {
+			return "", err
+		}
+		tag = userNouns[i]
+	}
+
+	return local + "+" + tag + "@" + domain, nil
+}
+
+// aliasTag is the last part of an entry's name with what can't be in an
+// email address left out, eg. work/GitHub.com is github.com
+func aliasTag(entry string) string {
+	if i := strings.LastIndexByte(entry, '/'); i >= 0 {
+		entry = entry[i+1:]
+	}
+
+	return strings.Map(func(r rune) rune {
+		r = unicode.ToLower(r)
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return -1
+	}, strings.Trim(entry, "."))
+}
+
+func randomIndex(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
+
+// errNoAliasEmail is returned when an alias is generated without the
+// aliasemail setting
+var errNoAliasEmail = errors.New("set the aliasemail setting to generate email aliases (config aliasemail you@example.com)")
+
+// genValue generates the value of a key for an entry: a username for user
+// and an alias of the aliasemail setting for email
+func (u *uiContext) genValue(key, entry string) (string, error) {
+	switch key {
+	case blobformat.KeyUser:
+		return genUsername()
+	case blobformat.KeyEmail:
+		base, err := u.store.Setting(blobformat.SettingAliasEmail)
+		if err != nil {
+			return "", err
+		}
+		if len(base) == 0 {
+			return "", errNoAliasEmail
+		}
+		return genAlias(base, entry)
+	}
+
+	return "", fmt.Errorf("%s can't be generated, only user and email can", key)
+}
+
+// promptGenerated asks for the user or email of a new entry, --gen generates
+// it (see genValue). Email is only offered an alias when there's an
+// aliasemail setting.
+func (u *uiContext) promptGenerated(key, entry string) (string, error) {
+	prompt := key + " (" + genFlag + " to generate): "
+	if key == blobformat.KeyEmail {
+		if base, err := u.store.Setting(blobformat.SettingAliasEmail); err != nil {
+			return "", err
+		} else if len(base) == 0 {
+			prompt = key + ": "
+		} else {
+			prompt = fmt.Sprintf("%s (%s for an alias of %s): ", key, genFlag, base)
+		}
+	}
+
+	for {
+		value, err := u.prompt(promptColor.Sprint(prompt))
+		if err != nil || value != genFlag {
+			return value, err
+		}
+
+		value, err = u.genValue(key, entry)
+		if err == nil {
+			infoColor.Printf("generated %s: %s\n", key, value)
+			return value, nil
+		} else if err != errNoAliasEmail {
+			return "", err
+		}
+		errColor.Println(err)
+	}
+}
+
+// setGenerated sets the user or email of an entry to a generated one
+func (u *uiContext) setGenerated(search, key string, force bool) error {
+	uuid, err := u.findOne(search)
+	if err != nil {
+		return err
+	}
+	if len(uuid) == 0 {
+		return nil
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+	if ok, err := u.guardKey(blob, key, force); err != nil || !ok {
+		return err
+	}
+
+	if key != blobformat.KeyUser && key != blobformat.KeyEmail {
+		errColor.Printf("%s can't be generated, only user and email can\n", key)
+		return nil
+	}
+
+	value, err := u.genValue(key, blob.Name())
+	if err == errNoAliasEmail {
+		errColor.Println(err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err = u.store.Set(uuid, key, value); err != nil {
+		return err
+	}
+	infoColor.Printf("set %s.%s to %s\n", blob.Name(), key, value)
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"regexp"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestGenUsername(t *testing.T) {
+	t.Parallel()
+
+	valid := regexp.MustCompile(`^[a-z]+_[a-z]+[0-9]{2}$`)
+	seen := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		user, err := genUsername()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !valid.MatchString(user) {
+			t.Error("bad username:", user)
+		}
+		seen[user] = true
+	}
+	if len(seen) < 15 {
+		t.Error("usernames should rarely repeat:", seen)
+	}
+}
+
+func TestGenAlias(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Base, Entry string
+		Want        string
+	}{
+		{"me@example.com", "github", "me+github@example.com"},
+		{"me@example.com", "work/GitHub.com", "me+github.com@example.com"},
+		{"me+old@example.com", "shop/Ünïcode Store", "me+ncodestore@example.com"},
+		{"example.com", "github", ""},
+		{"me@", "github", ""},
+	}
+
+	for i, test := range tests {
+		got, err := genAlias(test.Base, test.Entry)
+		if len(test.Want) == 0 {
+			if err == nil {
+				t.Errorf("%d) want an error, got: %q", i, got)
+			}
+			continue
+		}
+		if err != nil || got != test.Want {
+			t.Errorf("%d) want: %q, got: %q %v", i, test.Want, got, err)
+		}
+	}
+
+	// Nothing usable of the name gets a random tag
+	got, err := genAlias("me@example.com", "???")
+	if err != nil || !regexp.MustCompile(`^me\+[a-z]+@example\.com$`).MatchString(got) {
+		t.Error("want a random tag, got:", got, err)
+	}
+}
+
+func TestSetGenerated(t *testing.T) {
+	t.Parallel()
+
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: ioutil.Discard}
+	uuid, err := u.store.New("github")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a base email there's no alias
+	if err = u.setGenerated("github", blobformat.KeyEmail, false); err != nil {
+		t.Fatal(err)
+	}
+	blob, _ := u.store.MustFind(uuid)
+	if _, ok := blob[blobformat.KeyEmail]; ok {
+		t.Error("email should not be set:", blob)
+	}
+
+	if err = u.store.SetSetting(blobformat.SettingAliasEmail, "me@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{blobformat.KeyEmail, blobformat.KeyUser, blobformat.KeyPass} {
+		if err = u.setGenerated("github", key, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blob, _ = u.store.MustFind(uuid)
+	if blob[blobformat.KeyEmail] != "me+github@example.com" || len(blob[blobformat.KeyUser]) == 0 {
+		t.Error("user and email should be generated:", blob)
+	}
+	if _, ok := blob[blobformat.KeyPass]; ok {
+		t.Error("pass isn't generated this way")
+	}
+
+	// The prompts of add generate them too
+	u.in = &scriptedEditor{lines: []string{genFlag}}
+	if email, err := u.promptGenerated(blobformat.KeyEmail, "work/gitlab"); err != nil || email != "me+gitlab@example.com" {
+		t.Error("want an alias, got:", email, err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"strings"
+
+	"github.com/aarondl/bpass/blobformat"
+
+	"github.com/boombuler/barcode/qr"
+)
+
+// qrQuietZone is how many modules of border qr codes get so scanners find
+// their edges
+const qrQuietZone = 2
+
+// wifiFields are what addwifi asks for
+var wifiFields = []kindField{
+	{Key: blobformat.KeySSID, Prompt: "ssid (network name)"},
+	{Key: blobformat.KeySecurity, Prompt: "security (WPA, WPA2, WPA3, WEP or none, WPA when empty)", Optional: true, Check: checkWifiSecurity},
+	{Key: blobformat.KeyPassphrase, Prompt: "passphrase", Hidden: true, Optional: true},
+}
+
+func checkWifiSecurity(security string) error {
+	_, err := blobformat.WifiSecurity(security)
+	return err
+}
+
+// addWifi is a wizard to add a wifi entry
+func (u *uiContext) addWifi(name string) error {
+	uuid, err := u.addKindInterruptible(name, blobformat.KindWifi, wifiFields)
+	if err != nil || len(uuid) == 0 {
+		return err
+	}
+
+	infoColor.Printf("added wifi %s, qr %s shows the code to join it\n", name, name)
+	return nil
+}
+
+// showQR shows the qr code of a wifi entry that phones scan to join the
+// network
+func (u *uiContext) showQR(search string) error {
+	uuid, err := u.findOne(search)
+	if err != nil {
+		return err
+	}
+	if len(uuid) == 0 {
+		return nil
+	}
+
+	blob, err := u.store.MustFind(uuid)
+	if err != nil {
+		return err
+	}
+	if blob.Kind() != blobformat.KindWifi {
+		errColor.Printf("%s is not a wifi entry (see addwifi)\n", blob.Name())
+		return nil
+	}
+
+	payload, err := blob.WifiQR()
+	if err != nil {
+		errColor.Printf("%s has no qr code: %v\n", blob.Name(), err)
+		return nil
+	}
+
+	if err = writeQR(u.out, payload); err != nil {
+		errColor.Println("failed to make qr code:", err)
+		return nil
+	}
+	infoColor.Printf("scan to join %s\n", blob[blobformat.KeySSID])
+	return nil
+}
+
+// writeQR draws s as a qr code with unicode half blocks, two rows of modules
+// per line. Light modules are drawn so it scans on dark terminals.
+func writeQR(w io.Writer, s string) error {
+	code, err := qr.Encode(s, qr.M, qr.Auto)
+	if err != nil {
+		return err
+	}
+
+	size := code.Bounds().Dx()
+	dark := func(x, y int) bool {
+		x, y = x-qrQuietZone, y-qrQuietZone
+		if x < 0 || y < 0 || x >= size || y >= size {
+			return false
+		}
+		return isDark(code, x, y)
+	}
+
+	var b strings.Builder
+	width := size + 2*qrQuietZone
+	for y := 0; y < width; y += 2 {
+		for x := 0; x < width; x++ {
+			top, bottom := !dark(x, y), y+1 < width && !dark(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteRune('█')
+			case top:
+				b.WriteRune('▀')
+			case bottom:
+				b.WriteRune('▄')
+			default:
+				b.WriteRune(' ')
+			}
+		}
+		b.WriteByte('\n')
+	}
+
+	_, err = fmt.Fprint(w, b.String())
+	return err
+}
+
+func isDark(img image.Image, x, y int) bool {
+	min := img.Bounds().Min
+	r, _, _, _ := img.At(min.X+x, min.Y+y).RGBA()
+	return r < 0x8000
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aarondl/bpass/blobformat"
+	"github.com/aarondl/bpass/txlogs"
+)
+
+func TestWifiQR(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Blob blobformat.Blob
+		Want string
+	}{
+		{
+			blobformat.Blob{blobformat.KeySSID: "home", blobformat.KeyPassphrase: "secret"},
+			"WIFI:T:WPA;S:home;P:secret;;",
+		},
+		{
+			blobformat.Blob{blobformat.KeySSID: `a;b,c`, blobformat.KeySecurity: "wpa3", blobformat.KeyPassphrase: `p:"\`},
+			`WIFI:T:SAE;S:a\;b\,c;P:p\:\"\\;;`,
+		},
+		{
+			blobformat.Blob{blobformat.KeySSID: "cafe", blobformat.KeySecurity: "none"},
+			"WIFI:T:nopass;S:cafe;;",
+		},
+		{blobformat.Blob{blobformat.KeySSID: "home"}, ""},
+		{blobformat.Blob{blobformat.KeyPassphrase: "secret"}, ""},
+		{blobformat.Blob{blobformat.KeySSID: "home", blobformat.KeySecurity: "wpa4"}, ""},
+	}
+
+	for i, test := range tests {
+		got, err := test.Blob.WifiQR()
+		if len(test.Want) == 0 {
+			if err == nil {
+				t.Errorf("%d) want an error, got: %q", i, got)
+			}
+			continue
+		}
+		if err != nil || got != test.Want {
+			t.Errorf("%d) want: %q, got: %q %v", i, test.Want, got, err)
+		}
+	}
+}
+
+func TestShowQR(t *testing.T) {
+	t.Parallel()
+
+	buf := new(bytes.Buffer)
+	u := &uiContext{store: blobformat.Blobs{DB: new(txlogs.DB)}, out: buf}
+	uuid, err := u.store.New("wifi/home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.store.DB.Set(uuid, blobformat.KeyKind, blobformat.KindWifi)
+	u.store.DB.Set(uuid, blobformat.KeySSID, "home")
+	u.store.DB.Set(uuid, blobformat.KeyPassphrase, "secret")
+
+	if err = u.showQR("wifi/home"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	// Square with the quiet zone, two rows of modules to a line
+	width := len([]rune(lines[0]))
+	if len(lines) != (width+1)/2 {
+		t.Fatalf("want %d lines, got %d:\n%s", (width+1)/2, len(lines), buf.String())
+	}
+	for i, line := range lines {
+		if n := len([]rune(line)); n != width {
+			t.Errorf("%d) want %d columns, got: %d", i, width, n)
+		}
+	}
+	if !strings.HasPrefix(lines[0], strings.Repeat("█", width)) {
+		t.Error("the quiet zone should be light:", lines[0])
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// yubikeySlotEnv picks the yubikey slot used for challenge-response, it's
+// slot 2 unless this is set to 1
+const yubikeySlotEnv = "BPASS_YUBIKEY_SLOT"
+
+// yubikeyResponse answers a challenge with the yubikey's hmac-sha1
+// challenge-response slot using ykchalresp (yubikey-personalization)
+func (u *uiContext) yubikeyResponse(challenge []byte) ([]byte, error) {
+	command, err := exec.LookPath("ykchalresp")
+	if err != nil {
+		return nil, errors.New("ykchalresp (yubikey-personalization) is needed to use a yubikey")
+	}
+
+	slot := "2"
+	if os.Getenv(yubikeySlotEnv) == "1" {
+		slot = "1"
+	}
+
+	for {
+		infoColor.Println("touch your yubikey if it's blinking")
+
+		var stderr bytes.Buffer
+		cmd := exec.Command(command, "-"+slot, "-x", hex.EncodeToString(challenge))
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err == nil {
+			response, err := hex.DecodeString(strings.TrimSpace(string(out)))
+			if err != nil {
+				return nil, fmt.Errorf("ykchalresp gave a bad response: %w", err)
+			}
+			return response, nil
+		}
+
+		errColor.Println("yubikey challenge-response failed:", strings.TrimSpace(stderr.String()))
+		if u.headless {
+			return nil, errFactorAbsent
+		}
+
+		retry, err := u.getYesNo("insert your yubikey and try again?")
+		if err != nil {
+			return nil, err
+		}
+		if !retry {
+			return nil, errFactorAbsent
+		}
+	}
+}